@@ -41,7 +41,7 @@ func extractTweetID(tweetURL string) (string, error) {
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
-	
+
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Warning: No .env file found or error loading it: %v", err)
@@ -80,10 +80,21 @@ func main() {
 	}
 	log.Printf("Using DVM pubkey: %s", dvmPubKey)
 
-	client, err := dvm.NewDvmClient(relayURL)
+	// The user's own identity, if they'd rather not request as a stranger
+	var opts []dvm.ClientOption
+	if mnemonic := os.Getenv("NOSTR_MNEMONIC"); mnemonic != "" {
+		sk, err := dvm.KeyFromMnemonic(mnemonic, "", 0)
+		if err != nil {
+			log.Fatalf("Invalid NOSTR_MNEMONIC: %v", err)
+		}
+		opts = append(opts, dvm.WithClientKey(sk))
+	}
+
+	client, err := dvm.NewDvmClient(relayURL, opts...)
 	if err != nil {
 		log.Fatalf("Failed to create DVM client: %v", err)
 	}
+	defer client.Close()
 
 	// Set a timeout for the request
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -103,4 +114,3 @@ func main() {
 
 	fmt.Println(string(tweetJSON))
 }
-
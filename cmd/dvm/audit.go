@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"bandita/dvm"
+)
+
+// runAudit verifies the audit log hash chain and prints its head.
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 || fs.Arg(0) != "verify" {
+		log.Fatalf("Usage: dvm audit verify")
+	}
+
+	store, err := dvm.OpenStore(dataDir())
+	if err != nil {
+		log.Fatalf("Failed to open data directory %s: %v", dataDir(), err)
+	}
+	n, head, err := dvm.VerifyAuditLog(store)
+	if err != nil {
+		log.Fatalf("Audit log verification FAILED: %v", err)
+	}
+	fmt.Printf("Audit log OK: %d records\nHead hash: %s\n", n, head)
+	fmt.Println("Compare the head hash with the latest anchor event (kind 30078, d=bandita-audit:<seq>) to detect rewrites.")
+}
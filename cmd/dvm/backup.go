@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"bandita/dvm"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// configuredPubKey returns the pubkey for DVM_KEY_FILE or DVM_PRIVATE_KEY,
+// or "" if unset or invalid.
+func configuredPubKey() string {
+	if path := os.Getenv("DVM_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		pk, _ := dvm.KeyFilePubKey(data)
+		return pk
+	}
+	sk := os.Getenv("DVM_PRIVATE_KEY")
+	if sk == "" {
+		return ""
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return ""
+	}
+	return pk
+}
+
+// runBackup archives the data directory to a file.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: dvm backup <archive.tar.gz>")
+	}
+
+	store, err := dvm.OpenStore(dataDir())
+	if err != nil {
+		log.Fatalf("Failed to open data directory %s: %v", dataDir(), err)
+	}
+
+	f, err := os.OpenFile(fs.Arg(0), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Fatalf("Failed to create backup file: %v", err)
+	}
+	if err := dvm.WriteBackup(store, configuredPubKey(), f); err != nil {
+		f.Close()
+		os.Remove(fs.Arg(0))
+		log.Fatalf("Backup failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Backup failed: %v", err)
+	}
+	log.Printf("Backed up %s to %s", store.Dir(), fs.Arg(0))
+	log.Printf("Note: the backup does not contain the private key (DVM_KEY_FILE or DVM_PRIVATE_KEY) - move it to the new host separately")
+}
+
+// runRestore unpacks a backup into the data directory.
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite an existing non-empty data directory")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: dvm restore [-force] <archive.tar.gz>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open backup file: %v", err)
+	}
+	defer f.Close()
+
+	pubkey, err := dvm.RestoreBackup(f, dataDir(), *force)
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	log.Printf("Restored %s into %s", fs.Arg(0), dataDir())
+
+	if current := configuredPubKey(); pubkey != "" && current != "" && current != pubkey {
+		log.Printf("WARNING: backup belongs to DVM %s but DVM_PRIVATE_KEY is for %s", pubkey, current)
+	}
+}
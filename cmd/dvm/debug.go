@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"bandita/dvm"
+)
+
+// startDebugServer serves pprof profiles and expvar metrics on addr, which
+// must be a loopback address so profiles are never exposed publicly, along
+// with /admin/relays for managing d's relays. With allowed pubkeys,
+// requests must also carry NIP-98 authorization from one of them.
+func startDebugServer(addr string, allowed []string, d *dvm.Dvm) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid debug address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("debug address %q must be on localhost", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/admin/relays", relaysHandler(d))
+	mux.HandleFunc("/readyz", readyHandler(d))
+	var handler http.Handler = mux
+	if len(allowed) > 0 {
+		if handler, err = dvm.NIP98Auth(allowed, mux); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	go func() {
+		if err := http.Serve(listener, handler); err != nil {
+			log.Printf("Debug server stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Debug server listening on http://%s/debug/pprof/ (metrics at /debug/vars)", listener.Addr())
+	return nil
+}
+
+// readyHandler answers 200 once d is ready and 503 with the reason until
+// then.
+func readyHandler(d *dvm.Dvm) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := d.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	}
+}
+
+// relaysHandler lists d's relays on GET, and adds or removes the relay
+// given by the url query parameter on POST or DELETE.
+func relaysHandler(d *dvm.Dvm) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		url := r.URL.Query().Get("url")
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(d.RelayHealth())
+			return
+		case http.MethodPost:
+			err = d.AddRelay(url)
+		case http.MethodDelete:
+			err = d.RemoveRelay(url)
+		default:
+			http.Error(w, "use GET, POST or DELETE", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
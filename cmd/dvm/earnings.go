@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"bandita/dvm"
+)
+
+// runEarnings prints or exports the earnings ledger.
+func runEarnings(args []string) {
+	fs := flag.NewFlagSet("earnings", flag.ExitOnError)
+	by := fs.String("by", dvm.ByDay, "group the summary by day, requester, or job")
+	format := fs.String("format", "table", "output format: table (summary), csv or json (raw entries)")
+	sinceFlag := fs.String("since", "", "only include payments on or after this date (YYYY-MM-DD, UTC)")
+	untilFlag := fs.String("until", "", "only include payments before this date (YYYY-MM-DD, UTC)")
+	private := fs.Bool("private", os.Getenv("DVM_PRIVATE_LOGS") == "true", "replace requesters with pseudonyms keyed by DVM_PRIVACY_SALT")
+	fs.Parse(args)
+
+	var since, until time.Time
+	var err error
+	if *sinceFlag != "" {
+		if since, err = time.Parse("2006-01-02", *sinceFlag); err != nil {
+			log.Fatalf("Invalid -since: %v", err)
+		}
+	}
+	if *untilFlag != "" {
+		if until, err = time.Parse("2006-01-02", *untilFlag); err != nil {
+			log.Fatalf("Invalid -until: %v", err)
+		}
+	}
+
+	store, err := dvm.OpenStore(dataDir())
+	if err != nil {
+		log.Fatalf("Failed to open data directory %s: %v", dataDir(), err)
+	}
+	entries, err := dvm.NewLedger(store).Entries(since, until)
+	if err != nil {
+		log.Fatalf("Failed to read ledger: %v", err)
+	}
+	if *private {
+		scrubber, err := dvm.NewScrubber(os.Getenv("DVM_PRIVACY_SALT"))
+		if err != nil {
+			log.Fatalf("Failed to set up scrubbing: %v", err)
+		}
+		entries = scrubber.LedgerEntries(entries)
+	}
+
+	switch *format {
+	case "csv":
+		err = dvm.WriteLedgerCSV(os.Stdout, entries)
+	case "json":
+		err = dvm.WriteLedgerJSON(os.Stdout, entries)
+	case "table":
+		err = printEarnings(entries, *by)
+	default:
+		log.Fatalf("Unknown format %q: use table, csv, or json", *format)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write earnings: %v", err)
+	}
+}
+
+func printEarnings(entries []dvm.LedgerEntry, by string) error {
+	rows, err := dvm.SummarizeEarnings(entries, by)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tjobs\tsats\t\n", by)
+	var jobs int
+	var sats int64
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t\n", row.Key, row.Jobs, row.Sats)
+		jobs += row.Jobs
+		sats += row.Sats
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t\n", jobs, sats)
+	return tw.Flush()
+}
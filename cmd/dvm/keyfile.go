@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"bandita/dvm"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// runKeyfile encrypts DVM_PRIVATE_KEY, or a new key, into a keyfile.
+func runKeyfile(args []string) {
+	fs := flag.NewFlagSet("keyfile", flag.ExitOnError)
+	generate := fs.Bool("generate", false, "encrypt a newly generated key rather than DVM_PRIVATE_KEY")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: dvm keyfile [-generate] <keyfile.json>")
+	}
+
+	privateKey := os.Getenv("DVM_PRIVATE_KEY")
+	if *generate {
+		for privateKey = ""; len(privateKey) != 64; {
+			// GeneratePrivateKey can drop leading zeros
+			privateKey = nostr.GeneratePrivateKey()
+		}
+	} else if privateKey == "" {
+		log.Fatalf("DVM_PRIVATE_KEY is not set; use -generate for a new key")
+	}
+
+	passphrase := os.Getenv("DVM_KEY_PASSPHRASE")
+	if passphrase == "" {
+		var err error
+		if passphrase, err = readPassphrase("New passphrase: "); err != nil {
+			log.Fatalf("Failed to read passphrase: %v", err)
+		}
+		again, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			log.Fatalf("Failed to read passphrase: %v", err)
+		}
+		if again != passphrase {
+			log.Fatalf("Passphrases don't match")
+		}
+	}
+
+	data, err := dvm.EncryptKeyFile(privateKey, passphrase)
+	if err != nil {
+		log.Fatalf("Failed to encrypt key: %v", err)
+	}
+	f, err := os.OpenFile(fs.Arg(0), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Fatalf("Failed to create keyfile: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(fs.Arg(0))
+		log.Fatalf("Failed to write keyfile: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write keyfile: %v", err)
+	}
+	pubkey, _ := nostr.GetPublicKey(privateKey)
+	log.Printf("Wrote keyfile %s for pubkey %s", fs.Arg(0), pubkey)
+	log.Printf("Set DVM_KEY_FILE=%s and remove DVM_PRIVATE_KEY from your .env", fs.Arg(0))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"bandita/dvm"
+)
+
+// envOr returns the environment variable name, or fallback if it's unset.
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// typedPassphrase is the passphrase the operator typed, kept so they're
+// asked only once.
+var typedPassphrase string
+
+// keyPassphrase returns DVM_KEY_PASSPHRASE, or else asks the operator for
+// it with prompt. The keyfile and encrypted config values share it.
+func keyPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv("DVM_KEY_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	if typedPassphrase != "" {
+		return typedPassphrase, nil
+	}
+	passphrase, err := readPassphrase(prompt)
+	if err == nil {
+		typedPassphrase = passphrase
+	}
+	return passphrase, err
+}
+
+// keyProvider picks where the DVM's private key comes from with
+// DVM_KEY_PROVIDER: "env" (DVM_PRIVATE_KEY), "keyfile", "keychain"
+// (macOS), "libsecret", "vault", "aws" or "mnemonic". It defaults to "keyfile" when
+// DVM_KEY_FILE is set and "env" otherwise.
+func keyProvider() (dvm.KeyProvider, error) {
+	name := os.Getenv("DVM_KEY_PROVIDER")
+	if name == "" {
+		name = "env"
+		if os.Getenv("DVM_KEY_FILE") != "" {
+			name = "keyfile"
+		}
+	}
+
+	switch name {
+	case "env":
+		return dvm.NewEnvKeyProvider("DVM_PRIVATE_KEY"), nil
+	case "keyfile":
+		path := os.Getenv("DVM_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("DVM_KEY_FILE is not set")
+		}
+		return dvm.NewKeyFileProvider(path, func() (string, error) {
+			return keyPassphrase(fmt.Sprintf("Passphrase for %s: ", path))
+		}), nil
+	case "keychain":
+		return dvm.NewKeychainKeyProvider(envOr("DVM_KEYCHAIN_SERVICE", "bandita"), envOr("DVM_KEYCHAIN_ACCOUNT", "dvm"))
+	case "libsecret":
+		return dvm.NewLibsecretKeyProvider(envOr("DVM_KEYCHAIN_SERVICE", "bandita"), envOr("DVM_KEYCHAIN_ACCOUNT", "dvm"))
+	case "vault":
+		return dvm.NewVaultKeyProvider(dvm.VaultConfig{
+			Addr:  os.Getenv("VAULT_ADDR"),
+			Token: os.Getenv("VAULT_TOKEN"),
+			Path:  os.Getenv("DVM_VAULT_PATH"),
+			Field: os.Getenv("DVM_VAULT_FIELD"),
+		})
+	case "aws":
+		return dvm.NewAWSSecretsKeyProvider(dvm.AWSSecretsConfig{
+			SecretID: os.Getenv("DVM_AWS_SECRET_ID"),
+			Field:    os.Getenv("DVM_AWS_SECRET_FIELD"),
+		})
+	case "mnemonic":
+		var account uint64
+		if envAccount := os.Getenv("DVM_MNEMONIC_ACCOUNT"); envAccount != "" {
+			var err error
+			if account, err = strconv.ParseUint(envAccount, 10, 31); err != nil {
+				return nil, fmt.Errorf("invalid DVM_MNEMONIC_ACCOUNT: %w", err)
+			}
+		}
+		return dvm.NewMnemonicKeyProvider(os.Getenv("DVM_MNEMONIC"), os.Getenv("DVM_MNEMONIC_PASSPHRASE"), uint32(account)), nil
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", name)
+	}
+}
+
+// loadPrivateKey returns the DVM's private key from the configured
+// KeyProvider.
+func loadPrivateKey() string {
+	provider, err := keyProvider()
+	if err != nil {
+		log.Fatalf("Invalid key provider configuration: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // time to type a passphrase
+	defer cancel()
+	privateKey, err := provider.PrivateKey(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load the DVM private key: %v", err)
+	}
+	return privateKey
+}
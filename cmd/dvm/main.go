@@ -1,61 +1,739 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"bandita/dvm"
 	"github.com/joho/godotenv"
 )
 
+// quotaFromEnv reads DVM_QUOTA_DAILY<suffix> and DVM_QUOTA_RESET<suffix>,
+// reporting whether a quota is configured.
+func quotaFromEnv(suffix string) (dvm.QuotaConfig, bool) {
+	var quotaCfg dvm.QuotaConfig
+	envQuota := os.Getenv("DVM_QUOTA_DAILY" + suffix)
+	if envQuota == "" {
+		return quotaCfg, false
+	}
+
+	var err error
+	if quotaCfg.Daily, err = strconv.Atoi(envQuota); err != nil || quotaCfg.Daily < 0 {
+		log.Fatalf("Invalid DVM_QUOTA_DAILY%s %q: must be a non-negative integer", suffix, envQuota)
+	}
+	if envReset := os.Getenv("DVM_QUOTA_RESET" + suffix); envReset != "" {
+		if quotaCfg.ResetAt, err = dvm.ParseResetAt(envReset); err != nil {
+			log.Fatalf("Invalid DVM_QUOTA_RESET%s: %v", suffix, err)
+		}
+	}
+	return quotaCfg, true
+}
+
+// dataDir returns the directory for persistent state, from DVM_DATA_DIR.
+func dataDir() string {
+	if envDir := os.Getenv("DVM_DATA_DIR"); envDir != "" {
+		return envDir
+	}
+	return "bandita-data"
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
-	log.Println("Starting Nostr DVM...")
-	
+	// Private keys never reach the log, however they come to be printed
+	redactor := dvm.NewKeyRedactor(os.Stderr)
+	log.SetOutput(redactor)
+
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Warning: No .env file found or error loading it: %v", err)
 	}
-	
+
+	// Operator subcommands; otherwise we run the DVM
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "earnings":
+			runEarnings(os.Args[2:])
+		case "backup":
+			runBackup(os.Args[2:])
+		case "restore":
+			runRestore(os.Args[2:])
+		case "audit":
+			runAudit(os.Args[2:])
+		case "keyfile":
+			runKeyfile(os.Args[2:])
+		case "secret":
+			runSecret(os.Args[2:])
+		case "scraper-worker":
+			// Started by the DVM itself; see DVM_SCRAPER_SANDBOX
+			if err := dvm.ServeScraperWorker(os.Stdin, os.Stdout); err != nil {
+				log.Fatalf("Scraper worker: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q. Usage: dvm [--debug] [earnings|backup|restore|audit|keyfile|secret]", os.Args[1])
+		}
+		return
+	}
+
+	debug := flag.Bool("debug", false, "serve pprof profiles and metrics on -debug-addr")
+	debugAddr := flag.String("debug-addr", "127.0.0.1:6060", "localhost address for the debug server")
+	flag.Parse()
+
+	log.Println("Starting Nostr DVM...")
+
+	// Everything goes through Tor, so nothing may connect before this
+	if os.Getenv("DVM_TOR") == "true" {
+		if err := dvm.UseTor(dvm.TorConfig{Proxy: os.Getenv("DVM_TOR_PROXY")}); err != nil {
+			log.Fatalf("Failed to use Tor: %v", err)
+		}
+		log.Println("Sending all traffic through Tor")
+	}
+
+	// Encrypted config values, decrypted before anything reads them
+	resolveSecrets()
+
 	// Configure relay URL
 	relayURL := "wss://relay.nostr.net"
-	
+
 	// Get alternative relay from environment if available
 	if envRelay := os.Getenv("NOSTR_RELAY"); envRelay != "" {
 		relayURL = envRelay
 		log.Printf("Using relay from environment: %s", relayURL)
 	}
-	
-	// Get DVM private key from environment
-	privateKey := os.Getenv("DVM_PRIVATE_KEY")
-	if privateKey == "" {
-		log.Fatalf("DVM_PRIVATE_KEY environment variable not set. Please set it to a 64-character hex string.")
-	}
-	
-	log.Printf("Using private key from environment (first 8 chars): %s...", privateKey[:8])
+
+	// The DVM's private key, from an encrypted keyfile or the environment
+	privateKey := loadPrivateKey()
+	redactor.Add(privateKey)
 	log.Printf("Connecting to relay: %s", relayURL)
-	
-	dvmInstance, err := dvm.NewDvm(relayURL, privateKey)
+
+	// Persistent state (quota counters etc.) lives in the data directory
+	store, err := dvm.OpenStore(dataDir())
+	if err != nil {
+		log.Fatalf("Failed to open data directory %s: %v", dataDir(), err)
+	}
+	log.Printf("Using data directory: %s", store.Dir())
+	opts := []dvm.Option{dvm.WithStore(store)}
+
+	// Extra relays to fail over to, ranked by health at runtime
+	if envRelays := os.Getenv("NOSTR_RELAYS"); envRelays != "" {
+		var extraRelays []string
+		for _, url := range strings.Split(envRelays, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				extraRelays = append(extraRelays, url)
+			}
+		}
+		log.Printf("Additional relays: %v", extraRelays)
+		opts = append(opts, dvm.WithRelays(extraRelays...))
+	}
+
+	// NIP-50 search relays for finding existing mirrors of a tweet
+	if envSearch := os.Getenv("DVM_SEARCH_RELAYS"); envSearch != "" {
+		var searchRelays []string
+		for _, url := range strings.Split(envSearch, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				searchRelays = append(searchRelays, url)
+			}
+		}
+		log.Printf("Search relays: %v", searchRelays)
+		opts = append(opts, dvm.WithSearchRelays(searchRelays...))
+	}
+
+	// Optional per-requester daily quota
+	if quotaCfg, ok := quotaFromEnv(""); ok {
+		log.Printf("Daily quota: %d requests per pubkey, resetting at %v past midnight UTC", quotaCfg.Daily, quotaCfg.ResetAt)
+		opts = append(opts, dvm.WithQuota(quotaCfg))
+	}
+
+	// Additional identities served from this process, e.g. "premium=<hex>,free=<hex>"
+	if envIdentities := os.Getenv("DVM_IDENTITIES"); envIdentities != "" {
+		for _, entry := range strings.Split(envIdentities, ",") {
+			name, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || name == "" || name == "default" {
+				log.Fatalf("Invalid DVM_IDENTITIES entry %q: expected name=<64-char hex key>", entry)
+			}
+			redactor.Add(key)
+			identity := dvm.Identity{Name: name, PrivateKey: key}
+			identity.Quota, _ = quotaFromEnv("_" + strings.ToUpper(name))
+			opts = append(opts, dvm.WithIdentity(identity))
+		}
+	}
+
+	// Pseudonymous requesters and no request content in the logs
+	if os.Getenv("DVM_PRIVATE_LOGS") == "true" {
+		scrubber, err := dvm.NewScrubber(os.Getenv("DVM_PRIVACY_SALT"))
+		if err != nil {
+			log.Fatalf("Failed to set up log scrubbing: %v", err)
+		}
+		log.Println("Scrubbing requesters and request content from the logs")
+		opts = append(opts, dvm.WithScrubber(scrubber))
+	}
+
+	// Job queue sizing; requests beyond the limit get "busy" feedback, or
+	// wait under the "block" overflow policy
+	var queueCfg dvm.QueueConfig
+	if envWorkers := os.Getenv("DVM_WORKERS"); envWorkers != "" {
+		if queueCfg.Workers, err = strconv.Atoi(envWorkers); err != nil {
+			log.Fatalf("Invalid DVM_WORKERS: %v", err)
+		}
+	}
+	if envLimit := os.Getenv("DVM_QUEUE_LIMIT"); envLimit != "" {
+		if queueCfg.Limit, err = strconv.Atoi(envLimit); err != nil {
+			log.Fatalf("Invalid DVM_QUEUE_LIMIT: %v", err)
+		}
+	}
+	if envRetry := os.Getenv("DVM_BUSY_RETRY_AFTER"); envRetry != "" {
+		if queueCfg.RetryAfter, err = time.ParseDuration(envRetry); err != nil {
+			log.Fatalf("Invalid DVM_BUSY_RETRY_AFTER: %v", err)
+		}
+	}
+	if envBuffer := os.Getenv("DVM_SUBSCRIPTION_BUFFER"); envBuffer != "" {
+		if queueCfg.Buffer, err = strconv.Atoi(envBuffer); err != nil {
+			log.Fatalf("Invalid DVM_SUBSCRIPTION_BUFFER: %v", err)
+		}
+	}
+	queueCfg.Overflow = dvm.OverflowPolicy(os.Getenv("DVM_QUEUE_OVERFLOW"))
+	opts = append(opts, dvm.WithQueue(queueCfg))
+
+	// Outbound queue; publishing retries happen here instead of in the workers
+	var publishCfg dvm.PublishConfig
+	if envWorkers := os.Getenv("DVM_PUBLISH_WORKERS"); envWorkers != "" {
+		if publishCfg.Workers, err = strconv.Atoi(envWorkers); err != nil {
+			log.Fatalf("Invalid DVM_PUBLISH_WORKERS: %v", err)
+		}
+	}
+	if envAttempts := os.Getenv("DVM_PUBLISH_ATTEMPTS"); envAttempts != "" {
+		if publishCfg.Attempts, err = strconv.Atoi(envAttempts); err != nil {
+			log.Fatalf("Invalid DVM_PUBLISH_ATTEMPTS: %v", err)
+		}
+	}
+	if envBackoff := os.Getenv("DVM_PUBLISH_BACKOFF"); envBackoff != "" {
+		if publishCfg.Backoff, err = time.ParseDuration(envBackoff); err != nil {
+			log.Fatalf("Invalid DVM_PUBLISH_BACKOFF: %v", err)
+		}
+	}
+	opts = append(opts, dvm.WithPublishing(publishCfg))
+
+	// How far behind our clock a request's created_at may be
+	if envSkew := os.Getenv("DVM_CLOCK_SKEW"); envSkew != "" {
+		skew, err := time.ParseDuration(envSkew)
+		if err != nil {
+			log.Fatalf("Invalid DVM_CLOCK_SKEW: %v", err)
+		}
+		opts = append(opts, dvm.WithClockSkew(skew))
+	}
+
+	// Self-test on boot, fetching this tweet, before announcing the DVM
+	if selfTestTweet := os.Getenv("DVM_SELF_TEST_TWEET"); selfTestTweet != "off" {
+		opts = append(opts, dvm.WithSelfTest(dvm.SelfTestConfig{TweetID: selfTestTweet}))
+	}
+
+	// Sample goroutines, heap and queue depths this often, alerting on leaks
+	if envWatchdog := os.Getenv("DVM_WATCHDOG_INTERVAL"); envWatchdog != "off" {
+		var interval time.Duration
+		if envWatchdog != "" {
+			if interval, err = time.ParseDuration(envWatchdog); err != nil {
+				log.Fatalf("Invalid DVM_WATCHDOG_INTERVAL: %v", err)
+			}
+		}
+		opts = append(opts, dvm.WithWatchdog(dvm.WatchdogConfig{Interval: interval}))
+	}
+
+	// Skip requests older than this when their turn comes
+	if envAge := os.Getenv("DVM_MAX_REQUEST_AGE"); envAge != "" {
+		maxAge, err := time.ParseDuration(envAge)
+		if err != nil {
+			log.Fatalf("Invalid DVM_MAX_REQUEST_AGE: %v", err)
+		}
+		opts = append(opts, dvm.WithMaxRequestAge(maxAge))
+	}
+
+	// Reject requests dated further than this behind or ahead of our clock
+	var window dvm.TimestampWindow
+	if envPast := os.Getenv("DVM_TIMESTAMP_PAST"); envPast == "off" {
+		window.Past = -1
+	} else if envPast != "" {
+		if window.Past, err = time.ParseDuration(envPast); err != nil || window.Past <= 0 {
+			log.Fatalf("Invalid DVM_TIMESTAMP_PAST: %q", envPast)
+		}
+	}
+	if envFuture := os.Getenv("DVM_TIMESTAMP_FUTURE"); envFuture == "off" {
+		window.Future = -1
+	} else if envFuture != "" {
+		if window.Future, err = time.ParseDuration(envFuture); err != nil || window.Future <= 0 {
+			log.Fatalf("Invalid DVM_TIMESTAMP_FUTURE: %q", envFuture)
+		}
+	}
+	opts = append(opts, dvm.WithTimestampWindow(window))
+
+	// NIP-13 proof of work requests must carry, against flooding
+	if envPoW := os.Getenv("DVM_MIN_POW"); envPoW != "" {
+		difficulty, err := strconv.Atoi(envPoW)
+		if err != nil {
+			log.Fatalf("Invalid DVM_MIN_POW: %v", err)
+		}
+		opts = append(opts, dvm.WithProofOfWork(difficulty))
+	}
+
+	// Hot-path log lines per second per message; errors are always logged
+	if envLogRate := os.Getenv("DVM_LOG_RATE"); envLogRate != "" {
+		perSecond, err := strconv.Atoi(envLogRate)
+		if err != nil || perSecond < 0 {
+			log.Fatalf("Invalid DVM_LOG_RATE %q: must be a non-negative integer", envLogRate)
+		}
+		opts = append(opts, dvm.WithLogSampling(perSecond))
+	}
+
+	// In-memory result cache, bounded by total bytes of serialized tweets
+	if envCache := os.Getenv("DVM_CACHE_BYTES"); envCache != "" {
+		cacheBytes, err := strconv.ParseInt(envCache, 10, 64)
+		if err != nil || cacheBytes < 0 {
+			log.Fatalf("Invalid DVM_CACHE_BYTES %q: must be a non-negative integer", envCache)
+		}
+		log.Printf("Result cache: up to %d bytes", cacheBytes)
+		opts = append(opts, dvm.WithCache(cacheBytes))
+	}
+	if envReplay := os.Getenv("DVM_REPLAY_WINDOW"); envReplay != "" {
+		window, err := time.ParseDuration(envReplay)
+		if err != nil {
+			log.Fatalf("Invalid DVM_REPLAY_WINDOW: %v", err)
+		}
+		opts = append(opts, dvm.WithResultReplay(window))
+	}
+
+	// Durable copy of every fetched tweet in an S3-compatible bucket
+	if bucket := os.Getenv("DVM_ARCHIVE_BUCKET"); bucket != "" {
+		archiveCfg := dvm.ArchiveConfig{
+			Endpoint:  os.Getenv("DVM_ARCHIVE_ENDPOINT"),
+			Bucket:    bucket,
+			Region:    os.Getenv("DVM_ARCHIVE_REGION"),
+			AccessKey: os.Getenv("DVM_ARCHIVE_ACCESS_KEY"),
+			SecretKey: os.Getenv("DVM_ARCHIVE_SECRET_KEY"),
+			Media:     os.Getenv("DVM_ARCHIVE_MEDIA") == "true",
+		}
+		log.Printf("Archiving tweets to %s/%s (media: %v)", archiveCfg.Endpoint, bucket, archiveCfg.Media)
+		opts = append(opts, dvm.WithArchive(archiveCfg))
+	}
+
+	// Encrypted DMs to the operator when relays, the scraper or jobs are failing
+	if adminPubKey := os.Getenv("DVM_ADMIN_PUBKEY"); adminPubKey != "" {
+		alertCfg := dvm.AlertConfig{AdminPubKey: adminPubKey}
+		if envCooldown := os.Getenv("DVM_ALERT_COOLDOWN"); envCooldown != "" {
+			if alertCfg.Cooldown, err = time.ParseDuration(envCooldown); err != nil {
+				log.Fatalf("Invalid DVM_ALERT_COOLDOWN: %v", err)
+			}
+		}
+		if envRate := os.Getenv("DVM_ALERT_ERROR_RATE"); envRate != "" {
+			if alertCfg.ErrorRate, err = strconv.ParseFloat(envRate, 64); err != nil {
+				log.Fatalf("Invalid DVM_ALERT_ERROR_RATE: %v", err)
+			}
+		}
+		log.Printf("Sending operator alerts to %s", adminPubKey)
+		opts = append(opts, dvm.WithAlerts(alertCfg))
+	}
+
+	// Watch for another instance running with the same key ("off" to stop)
+	if os.Getenv("DVM_COLLISION_CHECK") != "off" {
+		var collisionCfg dvm.CollisionConfig
+		if envInterval := os.Getenv("DVM_COLLISION_INTERVAL"); envInterval != "" {
+			if collisionCfg.Interval, err = time.ParseDuration(envInterval); err != nil {
+				log.Fatalf("Invalid DVM_COLLISION_INTERVAL: %v", err)
+			}
+		}
+		opts = append(opts, dvm.WithCollisionCheck(collisionCfg))
+	}
+
+	// The profile clients show for the DVM, republished when it changes
+	profileCfg := dvm.ProfileConfig{
+		Name:    os.Getenv("DVM_PROFILE_NAME"),
+		About:   os.Getenv("DVM_PROFILE_ABOUT"),
+		Picture: os.Getenv("DVM_PROFILE_PICTURE"),
+		LUD16:   os.Getenv("DVM_PROFILE_LUD16"),
+		NIP05:   os.Getenv("DVM_PROFILE_NIP05"),
+	}
+	if profileCfg != (dvm.ProfileConfig{}) {
+		opts = append(opts, dvm.WithProfile(profileCfg))
+	}
+
+	// Deny, and optionally report, requesters who keep abusing the DVM
+	if envStrikes := os.Getenv("DVM_ABUSE_STRIKES"); envStrikes != "" {
+		abuseCfg := dvm.AbuseConfig{Report: os.Getenv("DVM_ABUSE_REPORT") == "true"}
+		if abuseCfg.Strikes, err = strconv.Atoi(envStrikes); err != nil {
+			log.Fatalf("Invalid DVM_ABUSE_STRIKES: %v", err)
+		}
+		if envWindow := os.Getenv("DVM_ABUSE_WINDOW"); envWindow != "" {
+			if abuseCfg.Window, err = time.ParseDuration(envWindow); err != nil {
+				log.Fatalf("Invalid DVM_ABUSE_WINDOW: %v", err)
+			}
+		}
+		opts = append(opts, dvm.WithAbuseProtection(abuseCfg))
+	}
+
+	// Only requesters within a web of trust of follow lists, if configured
+	if envRoots := os.Getenv("DVM_WOT_ROOTS"); envRoots != "" {
+		wotCfg := dvm.WoTConfig{Roots: strings.Split(envRoots, ",")}
+		if envHops := os.Getenv("DVM_WOT_HOPS"); envHops != "" {
+			if wotCfg.Hops, err = strconv.Atoi(envHops); err != nil {
+				log.Fatalf("Invalid DVM_WOT_HOPS: %v", err)
+			}
+		}
+		if envRefresh := os.Getenv("DVM_WOT_REFRESH"); envRefresh != "" {
+			if wotCfg.Refresh, err = time.ParseDuration(envRefresh); err != nil {
+				log.Fatalf("Invalid DVM_WOT_REFRESH: %v", err)
+			}
+		}
+		log.Printf("Serving only a web of trust from %d root pubkeys", len(wotCfg.Roots))
+		opts = append(opts, dvm.WithWebOfTrust(wotCfg))
+	}
+
+	// Allow/deny lists kept in step with NIP-51 lists the operator publishes
+	listsCfg := dvm.ListSyncConfig{Allow: os.Getenv("DVM_ALLOW_LIST"), Deny: os.Getenv("DVM_DENY_LIST")}
+	if listsCfg.Allow != "" || listsCfg.Deny != "" {
+		log.Printf("Syncing the allow/deny lists from NIP-51 lists")
+		opts = append(opts, dvm.WithListSync(listsCfg))
+	}
+
+	// Translation jobs, served only when a provider is configured
+	if provider := os.Getenv("DVM_TRANSLATE_PROVIDER"); provider != "" {
+		translator, err := dvm.NewTranslator(provider, os.Getenv("DVM_TRANSLATE_URL"), os.Getenv("DVM_TRANSLATE_API_KEY"))
+		if err != nil {
+			log.Fatalf("Invalid translation config: %v", err)
+		}
+		log.Printf("Translation jobs enabled via %s", provider)
+		opts = append(opts, dvm.WithTranslator(translator))
+	}
+
+	// LLM-powered jobs (summaries), served only when a model is configured
+	if provider := os.Getenv("DVM_LLM_PROVIDER"); provider != "" {
+		llmCfg := dvm.LLMConfig{
+			Provider: provider,
+			Endpoint: os.Getenv("DVM_LLM_URL"),
+			APIKey:   os.Getenv("DVM_LLM_API_KEY"),
+			Model:    os.Getenv("DVM_LLM_MODEL"),
+		}
+		if envMax := os.Getenv("DVM_LLM_MAX_TOKENS"); envMax != "" {
+			if llmCfg.MaxTokens, err = strconv.Atoi(envMax); err != nil {
+				log.Fatalf("Invalid DVM_LLM_MAX_TOKENS: %v", err)
+			}
+		}
+		if envPrice := os.Getenv("DVM_LLM_PRICE_PER_1K_TOKENS"); envPrice != "" {
+			if llmCfg.PricePerKTokens, err = strconv.ParseInt(envPrice, 10, 64); err != nil {
+				log.Fatalf("Invalid DVM_LLM_PRICE_PER_1K_TOKENS: %v", err)
+			}
+		}
+		log.Printf("LLM jobs enabled via %s model %s (%d msats per 1k tokens)", provider, llmCfg.Model, llmCfg.PricePerKTokens)
+		opts = append(opts, dvm.WithLLM(llmCfg))
+	}
+
+	// OCR of tweet photos, for requests with ["param", "ocr", "true"]
+	if os.Getenv("DVM_OCR") == "tesseract" {
+		engine, err := dvm.NewTesseractOCR(os.Getenv("DVM_OCR_LANGUAGES"))
+		if err != nil {
+			log.Fatalf("Failed to enable OCR: %v", err)
+		}
+		log.Printf("OCR enabled via tesseract")
+		opts = append(opts, dvm.WithOCR(engine))
+	}
+
+	// File host for re-hosted media
+	if provider := os.Getenv("DVM_UPLOAD_PROVIDER"); provider != "" {
+		server := os.Getenv("DVM_UPLOAD_SERVER")
+		log.Printf("Uploads enabled via %s server %s", provider, server)
+		opts = append(opts, dvm.WithUploads(dvm.UploadConfig{Provider: provider, Server: server}))
+
+		// Results too big for relays are uploaded and referenced by hash
+		if envOffload := os.Getenv("DVM_RESULT_OFFLOAD_BYTES"); envOffload != "" {
+			offloadBytes, err := strconv.Atoi(envOffload)
+			if err != nil || offloadBytes < 0 {
+				log.Fatalf("Invalid DVM_RESULT_OFFLOAD_BYTES %q: must be a non-negative integer", envOffload)
+			}
+			log.Printf("Offloading results over %d bytes to %s", offloadBytes, server)
+			opts = append(opts, dvm.WithResultOffload(offloadBytes))
+		}
+	}
+
+	// How results are delivered: "result" (NIP-90 job results, the default),
+	// "dm" or the deprecated "note"
+	if mode := os.Getenv("DVM_RESULT_MODE"); mode != "" {
+		opts = append(opts, dvm.WithResultMode(dvm.ResultMode(mode)))
+	}
+
+	// Results carry a signed attestation of what was fetched, when and how
+	if os.Getenv("DVM_FETCH_ATTESTATIONS") != "off" {
+		opts = append(opts, dvm.WithFetchAttestations())
+	}
+
+	// Results too big for one event are split across several, unless offloaded
+	if envChunk := os.Getenv("DVM_RESULT_CHUNK_BYTES"); envChunk != "" {
+		chunkBytes, err := strconv.Atoi(envChunk)
+		if err != nil || chunkBytes < 0 {
+			log.Fatalf("Invalid DVM_RESULT_CHUNK_BYTES %q: must be a non-negative integer", envChunk)
+		}
+		log.Printf("Chunking results over %d bytes", chunkBytes)
+		opts = append(opts, dvm.WithResultChunking(chunkBytes))
+	}
+
+	// What's done with result events still too big for relays: "fail"
+	// (the default), "truncate" or "offload" (needs uploads)
+	var resultLimit dvm.ResultLimit
+	if envMax := os.Getenv("DVM_MAX_RESULT_BYTES"); envMax != "" {
+		if resultLimit.MaxBytes, err = strconv.Atoi(envMax); err != nil || resultLimit.MaxBytes < 0 {
+			log.Fatalf("Invalid DVM_MAX_RESULT_BYTES %q: must be a non-negative integer", envMax)
+		}
+	}
+	resultLimit.Policy = dvm.OversizePolicy(os.Getenv("DVM_OVERSIZE_POLICY"))
+	opts = append(opts, dvm.WithResultLimit(resultLimit))
+
+	// Identity for publishing converted content such as long-form threads
+	if mirrorKey := os.Getenv("DVM_MIRROR_PRIVATE_KEY"); mirrorKey != "" {
+		opts = append(opts, dvm.WithMirrorKey(mirrorKey))
+	}
+
+	// Payment up front for expensive jobs, by zapping the request or paying
+	// an invoice from the operator's NWC wallet or lightning address
+	zapper, nwc, lnAddress := os.Getenv("DVM_ZAPPER_PUBKEY"), os.Getenv("DVM_NWC_URI"), os.Getenv("DVM_LIGHTNING_ADDRESS")
+	clnURL := os.Getenv("DVM_CLN_REST_URL")
+	if zapper != "" || nwc != "" || lnAddress != "" || clnURL != "" {
+		paymentCfg := dvm.PaymentConfig{ZapperPubKey: zapper, NWC: nwc, LightningAddress: lnAddress,
+			PayeePubKey: os.Getenv("DVM_PAYEE_PUBKEY"),
+			Refunds:     os.Getenv("DVM_REFUNDS") == "true", RefundNWC: os.Getenv("DVM_REFUND_NWC_URI")}
+		if clnURL != "" {
+			paymentCfg.CoreLightning = &dvm.CoreLightningConfig{
+				URL:    clnURL,
+				Rune:   os.Getenv("DVM_CLN_RUNE"),
+				Offers: os.Getenv("DVM_CLN_OFFERS") == "true",
+			}
+		}
+		if envTimeout := os.Getenv("DVM_PAYMENT_TIMEOUT"); envTimeout != "" {
+			if paymentCfg.Timeout, err = time.ParseDuration(envTimeout); err != nil {
+				log.Fatalf("Invalid DVM_PAYMENT_TIMEOUT: %v", err)
+			}
+		}
+		if clnURL != "" {
+			log.Printf("Payments enabled via invoices from the Core Lightning node at %s", clnURL)
+		} else if nwc != "" {
+			log.Printf("Payments enabled via invoices from an NWC wallet")
+		} else if lnAddress != "" {
+			log.Printf("Payments enabled via invoices for %s", lnAddress)
+		} else {
+			log.Printf("Payments enabled via zaps signed by %s", zapper)
+		}
+		opts = append(opts, dvm.WithPayments(paymentCfg))
+
+		if envPrice := os.Getenv("DVM_USER_ARCHIVE_PRICE_PER_TWEET"); envPrice != "" {
+			price, err := strconv.ParseInt(envPrice, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid DVM_USER_ARCHIVE_PRICE_PER_TWEET: %v", err)
+			}
+			log.Printf("User archive jobs enabled at %d msats per tweet", price)
+			opts = append(opts, dvm.WithUserArchive(price))
+		}
+
+		// Prices per job kind, advertised in the DVM's capabilities
+		if pricingPath := os.Getenv("DVM_PRICING_FILE"); pricingPath != "" {
+			f, err := os.Open(pricingPath)
+			if err != nil {
+				log.Fatalf("Failed to open DVM_PRICING_FILE: %v", err)
+			}
+			pricing, err := dvm.ParsePricingConfig(f)
+			f.Close()
+			if err != nil {
+				log.Fatalf("Invalid DVM_PRICING_FILE: %v", err)
+			}
+			log.Printf("Pricing enabled for %d job kinds", len(pricing))
+			opts = append(opts, dvm.WithPricing(pricing))
+		}
+	}
+
+	// Have requesters confirm these costly kinds before they run
+	if envKinds := os.Getenv("DVM_CHALLENGE_KINDS"); envKinds != "" {
+		var challengeCfg dvm.ChallengeConfig
+		if challengeCfg.Kinds, err = dvm.ParseKinds(envKinds); err != nil {
+			log.Fatalf("Invalid DVM_CHALLENGE_KINDS: %v", err)
+		}
+		if envTimeout := os.Getenv("DVM_CHALLENGE_TIMEOUT"); envTimeout != "" {
+			if challengeCfg.Timeout, err = time.ParseDuration(envTimeout); err != nil {
+				log.Fatalf("Invalid DVM_CHALLENGE_TIMEOUT: %v", err)
+			}
+		}
+		opts = append(opts, dvm.WithChallenge(challengeCfg))
+	}
+
+	// Content policy applied to every result before it's published
+	if policyPath := os.Getenv("DVM_POLICY_FILE"); policyPath != "" {
+		f, err := os.Open(policyPath)
+		if err != nil {
+			log.Fatalf("Failed to open DVM_POLICY_FILE: %v", err)
+		}
+		policyCfg, err := dvm.ParsePolicyConfig(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Invalid DVM_POLICY_FILE: %v", err)
+		}
+		log.Printf("Content policy enabled: %d block, %d keyword and %d redact rules",
+			len(policyCfg.Block), len(policyCfg.Keywords), len(policyCfg.Redact))
+		opts = append(opts, dvm.WithPolicy(policyCfg))
+	}
+
+	// Templates for text and markdown tweet results, in place of the defaults
+	var templateCfg dvm.TemplateConfig
+	for _, tmpl := range []struct {
+		env  string
+		text *string
+	}{
+		{"DVM_TWEET_TEXT_TEMPLATE", &templateCfg.TweetText},
+		{"DVM_TWEET_MARKDOWN_TEMPLATE", &templateCfg.TweetMarkdown},
+	} {
+		if path := os.Getenv(tmpl.env); path != "" {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				log.Fatalf("Failed to read %s: %v", tmpl.env, err)
+			}
+			*tmpl.text = string(text)
+		}
+	}
+	if templateCfg != (dvm.TemplateConfig{}) {
+		opts = append(opts, dvm.WithTemplates(templateCfg))
+	}
+
+	// WebSocket endpoint for jobs from processes on this machine
+	if addr := os.Getenv("DVM_LOCAL_API_ADDR"); addr != "" {
+		localCfg := dvm.LocalAPIConfig{Addr: addr, Token: os.Getenv("DVM_LOCAL_API_TOKEN")}
+		if envOrigins := os.Getenv("DVM_LOCAL_API_ORIGINS"); envOrigins != "" {
+			localCfg.AllowedOrigins = strings.Split(envOrigins, ",")
+		}
+		opts = append(opts, dvm.WithLocalAPI(localCfg))
+	}
+
+	// Peer DVMs to compare suspicious tweets with before serving them
+	if envPeers := os.Getenv("DVM_CROSSCHECK_PEERS"); envPeers != "" {
+		var peers []string
+		for _, peer := range strings.Split(envPeers, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				peers = append(peers, peer)
+			}
+		}
+		log.Printf("Cross-checking suspicious tweets with %d peer DVMs", len(peers))
+		opts = append(opts, dvm.WithCrossCheck(dvm.CrossCheckConfig{Peers: peers}))
+	}
+
+	// Tweet results already on the relays, ours or trusted peers', served before scraping
+	if os.Getenv("DVM_RELAY_LOOKUP") == "true" {
+		var lookupCfg dvm.RelayLookupConfig
+		for _, peer := range strings.Split(os.Getenv("DVM_RELAY_LOOKUP_PEERS"), ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				lookupCfg.Peers = append(lookupCfg.Peers, peer)
+			}
+		}
+		if envMaxAge := os.Getenv("DVM_RELAY_LOOKUP_MAX_AGE"); envMaxAge != "" {
+			if lookupCfg.MaxAge, err = time.ParseDuration(envMaxAge); err != nil {
+				log.Fatalf("Invalid DVM_RELAY_LOOKUP_MAX_AGE: %v", err)
+			}
+		}
+		log.Printf("Looking for existing results on the relays before scraping (%d trusted peers)", len(lookupCfg.Peers))
+		opts = append(opts, dvm.WithRelayLookup(lookupCfg))
+	}
+
+	// Tamper-evident audit log of answered requests, optionally anchored to nostr
+	if os.Getenv("DVM_AUDIT_LOG") == "true" {
+		var anchorEvery time.Duration
+		if envAnchor := os.Getenv("DVM_AUDIT_ANCHOR_EVERY"); envAnchor != "" {
+			if anchorEvery, err = time.ParseDuration(envAnchor); err != nil {
+				log.Fatalf("Invalid DVM_AUDIT_ANCHOR_EVERY: %v", err)
+			}
+		}
+		log.Printf("Audit log enabled (anchoring every %v)", anchorEvery)
+		opts = append(opts, dvm.WithAuditLog(anchorEvery))
+	}
+
+	// Scraping happens in a worker process with its own limits, so it can't
+	// crash or hang the process holding the keys
+	if os.Getenv("DVM_SCRAPER_SANDBOX") != "off" {
+		var sandboxCfg dvm.SandboxConfig
+		if envMemory := os.Getenv("DVM_SCRAPER_MEMORY"); envMemory != "" {
+			if sandboxCfg.MemoryBytes, err = strconv.ParseInt(envMemory, 10, 64); err != nil {
+				log.Fatalf("Invalid DVM_SCRAPER_MEMORY: %v", err)
+			}
+		}
+		if envCPUs := os.Getenv("DVM_SCRAPER_CPUS"); envCPUs != "" {
+			if sandboxCfg.CPUs, err = strconv.Atoi(envCPUs); err != nil {
+				log.Fatalf("Invalid DVM_SCRAPER_CPUS: %v", err)
+			}
+		}
+		if envCPUTime := os.Getenv("DVM_SCRAPER_CPU_TIME"); envCPUTime != "" {
+			if sandboxCfg.CPUTime, err = time.ParseDuration(envCPUTime); err != nil {
+				log.Fatalf("Invalid DVM_SCRAPER_CPU_TIME: %v", err)
+			}
+		}
+		if envNice := os.Getenv("DVM_SCRAPER_NICE"); envNice != "" {
+			if sandboxCfg.Nice, err = strconv.Atoi(envNice); err != nil {
+				log.Fatalf("Invalid DVM_SCRAPER_NICE: %v", err)
+			}
+		}
+		if envTimeout := os.Getenv("DVM_SCRAPER_TIMEOUT"); envTimeout != "" {
+			if sandboxCfg.CallTimeout, err = time.ParseDuration(envTimeout); err != nil {
+				log.Fatalf("Invalid DVM_SCRAPER_TIMEOUT: %v", err)
+			}
+		}
+		sandbox, err := dvm.NewSandboxedScraper(sandboxCfg)
+		if err != nil {
+			log.Fatalf("Failed to set up the scraper worker: %v", err)
+		}
+		defer sandbox.Close()
+		opts = append(opts, dvm.WithScraper(sandbox))
+	}
+
+	// Fault injection for exercising reconnect/retry paths - never enable in production
+	if chaosSpec := os.Getenv("DVM_CHAOS"); chaosSpec != "" {
+		chaosCfg, err := dvm.ParseChaosConfig(chaosSpec)
+		if err != nil {
+			log.Fatalf("Invalid DVM_CHAOS: %v", err)
+		}
+		log.Printf("WARNING: chaos mode enabled: %+v", chaosCfg)
+		opts = append(opts, dvm.WithChaos(chaosCfg))
+	}
+
+	dvmInstance, err := dvm.NewDvm(relayURL, privateKey, opts...)
 	if err != nil {
 		log.Fatalf("Failed to create DVM: %v", err)
 	}
 
+	if *debug {
+		// Optional NIP-98 authorization, e.g. "npub1...,npub1..."
+		var allowed []string
+		if envAllowed := os.Getenv("DVM_DEBUG_ALLOWED_PUBKEYS"); envAllowed != "" {
+			allowed = strings.Split(envAllowed, ",")
+		}
+		if err := startDebugServer(*debugAddr, allowed, dvmInstance); err != nil {
+			log.Fatalf("Failed to start debug server: %v", err)
+		}
+	}
+
 	pubkey := dvmInstance.GetPublicKey()
 	log.Printf("========================================")
 	log.Printf("DVM Successfully initialized")
 	log.Printf("Public Key: %s", pubkey)
+	for name, identityPubKey := range dvmInstance.Identities() {
+		if name != "default" {
+			log.Printf("Identity %s Public Key: %s", name, identityPubKey)
+		}
+	}
 	log.Printf("Relay: %s", relayURL)
 	log.Printf("========================================")
 	log.Printf("To use this DVM in your CLI, add the following to your .env file:")
 	log.Printf("DVM_PUBKEY=%s", pubkey)
 	log.Printf("========================================")
-	log.Printf("To restart this exact DVM instance later, ensure your .env contains:")
-	log.Printf("DVM_PRIVATE_KEY=%s", privateKey)
-	log.Printf("========================================")
 	log.Printf("Ready to receive tweet fetch requests...")
 
-	// Run the DVM - this will block until Stop() is called
-	if err := dvmInstance.Run(); err != nil {
+	// Run the DVM until interrupted, letting jobs in flight finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := dvmInstance.Run(ctx); err != nil {
 		log.Fatalf("DVM error: %v", err)
 	}
-}
\ No newline at end of file
+}
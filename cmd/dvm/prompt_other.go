@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// readPassphrase would prompt for a passphrase, but hiding what's typed
+// is only supported on Linux; elsewhere it must come from the
+// environment.
+func readPassphrase(prompt string) (string, error) {
+	return "", errors.New("can't prompt for a passphrase on this platform; set DVM_KEY_PASSPHRASE")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"bandita/dvm"
+)
+
+// resolveSecrets decrypts the encrypted values in the environment, such
+// as DVM_TWITTER_COOKIES or DVM_NWC_URI, in place: "enc:v1:" values with
+// the key passphrase, "vault:v<n>:" values with the Vault transit key
+// DVM_SECRETS_VAULT_KEY, and "kms:" values with AWS KMS.
+func resolveSecrets() {
+	resolver := &dvm.SecretResolver{
+		Passphrase: func() (string, error) { return keyPassphrase("Passphrase for encrypted config: ") },
+		KMS:        &dvm.AWSKMSConfig{},
+	}
+	if key := os.Getenv("DVM_SECRETS_VAULT_KEY"); key != "" {
+		resolver.Vault = &dvm.VaultTransitConfig{
+			Addr:  os.Getenv("VAULT_ADDR"),
+			Token: os.Getenv("VAULT_TOKEN"),
+			Key:   key,
+			Mount: os.Getenv("DVM_SECRETS_VAULT_MOUNT"),
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // time to type a passphrase
+	defer cancel()
+	names, err := resolver.ResolveEnv(ctx)
+	if err != nil {
+		log.Fatalf("Failed to decrypt config: %v", err)
+	}
+	if len(names) > 0 {
+		log.Printf("Decrypted config: %s", strings.Join(names, ", "))
+	}
+}
+
+// runSecret encrypts a config value with the key passphrase, printing
+// the value to put in .env in its place. The value is typed without
+// echoing, or piped in.
+func runSecret(args []string) {
+	if len(args) != 0 {
+		log.Fatalf("Usage: dvm secret")
+	}
+	value, err := readPassphrase("Value to encrypt: ")
+	if err != nil {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read the value: %v", err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+
+	passphrase := os.Getenv("DVM_KEY_PASSPHRASE")
+	if passphrase == "" {
+		if passphrase, err = readPassphrase("Passphrase: "); err != nil {
+			log.Fatalf("Failed to read passphrase: %v", err)
+		}
+		again, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			log.Fatalf("Failed to read passphrase: %v", err)
+		}
+		if again != passphrase {
+			log.Fatalf("Passphrases don't match")
+		}
+	}
+	sealed, err := dvm.EncryptSecret(value, passphrase)
+	if err != nil {
+		log.Fatalf("Failed to encrypt: %v", err)
+	}
+	fmt.Println(sealed)
+}
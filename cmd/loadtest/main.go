@@ -0,0 +1,181 @@
+// Command loadtest fires tweet requests at a DVM from many synthetic
+// clients at once and reports throughput, latency percentiles and errors.
+//
+// By default it targets the DVM with DVM_PUBKEY on NOSTR_RELAY. With
+// -embedded it starts its own relay and DVM, with a fake scraper, to
+// measure the DVM itself without Twitter or a network.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bandita/dvm"
+	"bandita/internal/relaytest"
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/joho/godotenv"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeScraper returns a made-up tweet for any ID after a delay standing in
+// for Twitter.
+type fakeScraper struct {
+	latency time.Duration
+}
+
+func (s fakeScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	time.Sleep(s.latency)
+	return &twitterscraper.Tweet{ID: id, Username: "loadtest", Text: "Synthetic tweet " + id}, nil
+}
+
+// outcome is one request's result.
+type outcome struct {
+	latency time.Duration
+	err     error
+}
+
+func main() {
+	clients := flag.Int("clients", 10, "concurrent synthetic clients")
+	requests := flag.Int("requests", 10, "requests each client makes, one after another")
+	tweets := flag.String("tweets", "20", "comma-separated tweet IDs to request, in turn")
+	timeout := flag.Duration("timeout", 30*time.Second, "how long each request may take")
+	embedded := flag.Bool("embedded", false, "run against an in-process relay and DVM with a fake scraper")
+	workers := flag.Int("workers", 4, "the embedded DVM's job workers")
+	scrapeLatency := flag.Duration("scrape-latency", 50*time.Millisecond, "the embedded DVM's fake scraper latency")
+	verbose := flag.Bool("v", false, "keep the client and DVM logs")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil && !*embedded {
+		log.Printf("Warning: No .env file found or error loading it: %v", err)
+	}
+	if *clients <= 0 || *requests <= 0 {
+		log.Fatalf("-clients and -requests must be positive")
+	}
+	tweetIDs := strings.Split(*tweets, ",")
+
+	relayURL := os.Getenv("NOSTR_RELAY")
+	dvmPubKey := os.Getenv("DVM_PUBKEY")
+	if *embedded {
+		relay := relaytest.NewServer()
+		defer relay.Close()
+		relayURL = relay.URL()
+
+		d, err := dvm.NewDvm(relayURL, privateKey(),
+			dvm.WithScraper(fakeScraper{latency: *scrapeLatency}),
+			dvm.WithQueue(dvm.QueueConfig{Workers: *workers, Limit: *clients * 2}))
+		if err != nil {
+			log.Fatalf("Failed to create DVM: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			if err := d.Run(ctx); err != nil {
+				log.Fatalf("DVM run error: %v", err)
+			}
+		}()
+		defer cancel()
+		dvmPubKey = d.GetPublicKey()
+		// Let the DVM subscribe before the first request
+		time.Sleep(500 * time.Millisecond)
+	}
+	if relayURL == "" || dvmPubKey == "" {
+		log.Fatalf("Set NOSTR_RELAY and DVM_PUBKEY, or use -embedded")
+	}
+
+	log.Printf("Load testing DVM %s on %s: %d clients x %d requests", dvmPubKey[:8], relayURL, *clients, *requests)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	outcomes := make(chan outcome, *clients**requests)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *clients; i++ {
+		client, err := dvm.NewDvmClient(relayURL)
+		if err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < *requests; n++ {
+				ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+				begin := time.Now()
+				_, err := client.RequestTweet(ctx, dvmPubKey, tweetIDs[(i+n)%len(tweetIDs)])
+				cancel()
+				outcomes <- outcome{latency: time.Since(begin), err: err}
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(outcomes)
+	log.SetOutput(os.Stderr)
+
+	report(os.Stdout, outcomes, elapsed)
+}
+
+// privateKey generates the embedded DVM's key. nostr.GeneratePrivateKey
+// can drop leading zeros, which NewDvm rejects.
+func privateKey() string {
+	for {
+		if sk := nostr.GeneratePrivateKey(); len(sk) == 64 {
+			return sk
+		}
+	}
+}
+
+// report writes the throughput, latency percentiles of successful requests
+// and error counts by message.
+func report(w io.Writer, outcomes <-chan outcome, elapsed time.Duration) {
+	var latencies []time.Duration
+	errs := make(map[string]int)
+	total := 0
+	for o := range outcomes {
+		total++
+		if o.err != nil {
+			errs[o.err.Error()]++
+			continue
+		}
+		latencies = append(latencies, o.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	failed := total - len(latencies)
+	fmt.Fprintf(w, "Requests:   %d in %v\n", total, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput: %.1f successful requests/s\n", float64(len(latencies))/elapsed.Seconds())
+	fmt.Fprintf(w, "Errors:     %d (%.1f%%)\n", failed, 100*float64(failed)/float64(total))
+	if len(latencies) > 0 {
+		fmt.Fprintf(w, "Latency:    p50 %v  p90 %v  p99 %v  max %v\n",
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99),
+			latencies[len(latencies)-1].Round(time.Millisecond))
+	}
+
+	messages := make([]string, 0, len(errs))
+	for msg := range errs {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return errs[messages[i]] > errs[messages[j]] })
+	for _, msg := range messages {
+		fmt.Fprintf(w, "  %5d  %s\n", errs[msg], msg)
+	}
+}
+
+// percentile returns the pth percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Millisecond)
+}
@@ -0,0 +1,239 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// AbuseConfig has the DVM deny requesters who keep abusing it. Each
+// request over a quota, short of the proof of work required or malformed
+// is a strike, though not one that makes a handler panic, which is the
+// DVM's bug. A pubkey with Strikes strikes within Window goes on the
+// denylist, kept in the store if the DVM has one, and its requests are
+// ignored from then on. The admin can take pubkeys off with
+// "undeny <pubkey>".
+type AbuseConfig struct {
+	Strikes int           // default 10
+	Window  time.Duration // default 1h
+	// Report publishes a NIP-56 report of each pubkey denied, so clients
+	// and relays that heed the DVM's reports know of it too.
+	Report bool
+}
+
+func (c AbuseConfig) withDefaults() AbuseConfig {
+	if c.Strikes <= 0 {
+		c.Strikes = 10
+	}
+	if c.Window <= 0 {
+		c.Window = time.Hour
+	}
+	return c
+}
+
+// KindReport is the NIP-56 report kind.
+const KindReport = 1984
+
+// Strike kinds, named by the NIP-56 report type they amount to.
+const (
+	strikeSpam    = "spam"  // over a quota or short of proof of work
+	strikePayload = "other" // malformed
+)
+
+// denylistDocument is the store document holding the denylist.
+const denylistDocument = "denylist"
+
+// maxStruck is how many pubkeys with recent strikes are tracked before
+// those whose strikes have all expired are swept out.
+const maxStruck = 10000
+
+// Denial is why and when a pubkey was put on the denylist.
+type Denial struct {
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+}
+
+// abuseTracker counts strikes and keeps the denylist.
+type abuseTracker struct {
+	cfg   AbuseConfig
+	store *Store
+
+	mu      sync.Mutex
+	strikes map[string][]time.Time // pubkey to its strikes within the window, oldest first
+	denied  map[string]Denial
+}
+
+func loadAbuseTracker(cfg AbuseConfig, store *Store) (*abuseTracker, error) {
+	a := &abuseTracker{cfg: cfg.withDefaults(), store: store, strikes: make(map[string][]time.Time)}
+	if store != nil {
+		if err := store.Load(denylistDocument, &a.denied); err != nil {
+			return nil, fmt.Errorf("failed to load the denylist: %w", err)
+		}
+	}
+	if a.denied == nil {
+		a.denied = make(map[string]Denial)
+	}
+	return a, nil
+}
+
+// isDenied reports whether pubkey is on the denylist. A nil tracker
+// denies nobody.
+func (a *abuseTracker) isDenied(pubkey string) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, denied := a.denied[pubkey]
+	return denied
+}
+
+// strike counts a strike against pubkey at now, reporting whether it
+// put pubkey on the denylist.
+func (a *abuseTracker) strike(pubkey, reason string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, denied := a.denied[pubkey]; denied {
+		return false
+	}
+
+	cutoff := now.Add(-a.cfg.Window)
+	if len(a.strikes) >= maxStruck {
+		for pk, times := range a.strikes {
+			if times[len(times)-1].Before(cutoff) {
+				delete(a.strikes, pk)
+			}
+		}
+	}
+	times := a.strikes[pubkey]
+	for len(times) > 0 && times[0].Before(cutoff) {
+		times = times[1:]
+	}
+	times = append(times, now)
+	if len(times) < a.cfg.Strikes {
+		a.strikes[pubkey] = times
+		return false
+	}
+
+	delete(a.strikes, pubkey)
+	a.denied[pubkey] = Denial{At: now, Reason: reason}
+	a.saveLocked()
+	return true
+}
+
+// set puts pubkey on the denylist, or with a nil denial takes it off,
+// reporting whether that changed anything.
+func (a *abuseTracker) set(pubkey string, denial *Denial) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, denied := a.denied[pubkey]
+	if denial == nil {
+		if !denied {
+			return false
+		}
+		delete(a.denied, pubkey)
+	} else {
+		a.denied[pubkey] = *denial
+	}
+	delete(a.strikes, pubkey)
+	a.saveLocked()
+	return true
+}
+
+func (a *abuseTracker) saveLocked() {
+	if a.store == nil {
+		return
+	}
+	if err := a.store.Save(denylistDocument, a.denied); err != nil {
+		log.Printf("Failed to persist the denylist: %v", err)
+	}
+}
+
+// strike counts a strike against evt's requester, denying them and
+// reporting them if configured once they have too many.
+func (d *Dvm) strike(evt *nostr.Event, kind, reason string) {
+	if d.abuse == nil || !d.abuse.strike(evt.PubKey, reason, time.Now()) {
+		return
+	}
+	metricRequestersDenied.Add(1)
+	log.Printf("Denying %s after %d strikes in %v, the last: %s",
+		d.scrub.logPubKey(evt.PubKey), d.abuse.cfg.Strikes, d.abuse.cfg.Window, reason)
+	if d.abuse.cfg.Report {
+		d.publishReport(evt.PubKey, kind, reason)
+	}
+}
+
+// publishReport publishes a NIP-56 report of pubkey from the primary
+// identity.
+func (d *Dvm) publishReport(pubkey, kind, reason string) {
+	primary := d.identities[0]
+	report := nostr.Event{
+		PubKey:    primary.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      KindReport,
+		Tags:      nostr.Tags{{"p", pubkey, kind}},
+		Content:   fmt.Sprintf("Repeatedly abused this DVM: %s", reason),
+	}
+	if err := report.Sign(primary.sk); err != nil {
+		log.Printf("Failed to sign report of %s: %v", d.scrub.logPubKey(pubkey), err)
+		return
+	}
+	d.publishAsync(report, func(err error) {
+		if err != nil {
+			log.Printf("Giving up on report of %s: %v", d.scrub.logPubKey(pubkey), err)
+		}
+	})
+}
+
+// Denylist returns the pubkeys the DVM ignores and why.
+func (d *Dvm) Denylist() map[string]Denial {
+	if d.abuse == nil {
+		return nil
+	}
+	d.abuse.mu.Lock()
+	defer d.abuse.mu.Unlock()
+	list := make(map[string]Denial, len(d.abuse.denied))
+	for pk, denial := range d.abuse.denied {
+		list[pk] = denial
+	}
+	return list
+}
+
+// Deny puts pubkey on the denylist, which needs WithAbuseProtection.
+func (d *Dvm) Deny(pubkey, reason string) error {
+	if d.abuse == nil {
+		return fmt.Errorf("this DVM has no denylist")
+	}
+	if len(pubkey) != 64 {
+		return fmt.Errorf("invalid pubkey %q: must be 64 hex characters", pubkey)
+	}
+	d.abuse.set(pubkey, &Denial{At: time.Now(), Reason: reason})
+	return nil
+}
+
+// Undeny takes pubkey off the denylist.
+func (d *Dvm) Undeny(pubkey string) error {
+	if d.abuse == nil || !d.abuse.set(pubkey, nil) {
+		return fmt.Errorf("%s is not denied", pubkey)
+	}
+	return nil
+}
+
+// denylistLines describes the denylist for the admin, oldest first.
+func (d *Dvm) denylistLines() []string {
+	list := d.Denylist()
+	pubkeys := make([]string, 0, len(list))
+	for pk := range list {
+		pubkeys = append(pubkeys, pk)
+	}
+	sort.Slice(pubkeys, func(i, j int) bool { return list[pubkeys[i]].At.Before(list[pubkeys[j]].At) })
+	lines := make([]string, len(pubkeys))
+	for i, pk := range pubkeys {
+		lines[i] = fmt.Sprintf("%s since %s: %s", pk, list[pk].At.Format(time.RFC3339), list[pk].Reason)
+	}
+	return lines
+}
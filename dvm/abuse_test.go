@@ -0,0 +1,86 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAbuseStrikes(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := loadAbuseTracker(AbuseConfig{Strikes: 3, Window: time.Minute}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// Strikes that have left the window don't count
+	a.strike("pk", "over quota", now.Add(-2*time.Minute))
+	a.strike("pk", "over quota", now)
+	if a.strike("pk", "over quota", now) || a.isDenied("pk") {
+		t.Fatal("expected two strikes in the window not to deny")
+	}
+	if !a.strike("pk", "over quota", now) || !a.isDenied("pk") {
+		t.Fatal("expected the third strike to deny")
+	}
+
+	// The denylist survives a restart, and can be undone
+	a, err = loadAbuseTracker(AbuseConfig{}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.isDenied("pk") {
+		t.Error("expected the denial to be persisted")
+	}
+	if !a.set("pk", nil) || a.isDenied("pk") {
+		t.Error("expected the denial to be lifted")
+	}
+}
+
+func TestDeniesAbusiveRequester(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithQuota(QuotaConfig{Daily: 1}),
+		WithAbuseProtection(AbuseConfig{Strikes: 2, Report: true}))
+
+	sk := testKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	for _, tweetID := range []string{"20", "21", "22"} {
+		req := newTestRequestFrom(sk, tweetID)
+		relay.Publish(req)
+		awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	}
+	if _, denied := d.Denylist()[pk]; !denied {
+		t.Fatal("expected the requester to be denied after two strikes")
+	}
+
+	var report *nostr.Event
+	for deadline := time.Now().Add(5 * time.Second); report == nil && time.Now().Before(deadline); {
+		for _, evt := range relay.Events() {
+			if evt.Kind == KindReport && evt.PubKey == d.GetPublicKey() {
+				report = evt
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if report == nil || report.Tags.GetFirst([]string{"p", pk, strikeSpam}) == nil {
+		t.Fatalf("expected a NIP-56 spam report, got %+v", report)
+	}
+
+	// Denied requests go unanswered
+	req := newTestRequestFrom(sk, "23")
+	relay.Publish(req)
+	time.Sleep(500 * time.Millisecond)
+	for _, evt := range relay.Events() {
+		if evt.PubKey == d.GetPublicKey() && evt.Tags.GetFirst([]string{"e", req.ID}) != nil {
+			t.Fatalf("expected no response to a denied requester, got %+v", evt)
+		}
+	}
+	if err := d.Undeny(pk); err != nil {
+		t.Error(err)
+	}
+}
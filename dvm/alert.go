@@ -0,0 +1,200 @@
+package dvm
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// AlertConfig sends encrypted DMs to an operator when the DVM is in trouble.
+type AlertConfig struct {
+	AdminPubKey string        // hex pubkey that receives the DMs
+	Cooldown    time.Duration // minimum gap between repeats of one alert; default 1h
+	ErrorRate   float64       // failed job fraction that triggers an alert; default 0.5
+	ErrorWindow time.Duration // window the error rate is measured over; default 5m
+	MinJobs     int           // jobs needed in the window before the rate counts; default 10
+}
+
+func (c AlertConfig) withDefaults() AlertConfig {
+	if c.Cooldown <= 0 {
+		c.Cooldown = time.Hour
+	}
+	if c.ErrorRate <= 0 {
+		c.ErrorRate = 0.5
+	}
+	if c.ErrorWindow <= 0 {
+		c.ErrorWindow = 5 * time.Minute
+	}
+	if c.MinJobs <= 0 {
+		c.MinJobs = 10
+	}
+	return c
+}
+
+// Conditions worth waking an operator for. Each is deduplicated separately.
+const (
+	alertRelaysDown        = "relays-down"
+	alertScraperAuth       = "scraper-auth"
+	alertErrorRate         = "error-rate"
+	alertSelfTest          = "self-test"
+	alertResourceGrowth    = "resource-growth"
+	alertDuplicateIdentity = "duplicate-identity"
+)
+
+// relaysDownGrace is how long every relay must be unreachable before alerting,
+// so a brief blip during a reconnect stays quiet.
+const relaysDownGrace = 30 * time.Second
+
+// alerter tracks alert conditions and delivers DMs to the admin. Alerts
+// raised while every relay is down are held and delivered once one returns.
+// A nil *alerter does nothing.
+type alerter struct {
+	cfg AlertConfig
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	pending  []string
+	jobs     []jobOutcome
+	wake     chan struct{}
+}
+
+type jobOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+func newAlerter(cfg AlertConfig) (*alerter, error) {
+	if raw, err := hex.DecodeString(cfg.AdminPubKey); err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("invalid admin pubkey: must be 64 hex characters")
+	}
+	return &alerter{
+		cfg:      cfg.withDefaults(),
+		lastSent: make(map[string]time.Time),
+		wake:     make(chan struct{}, 1),
+	}, nil
+}
+
+// raise queues a DM about condition unless one went out within the cooldown.
+func (a *alerter) raise(condition, message string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := a.lastSent[condition]; ok && now.Sub(last) < a.cfg.Cooldown {
+		return
+	}
+	a.lastSent[condition] = now
+	log.Printf("ALERT %s: %s", condition, message)
+	a.pending = append(a.pending, fmt.Sprintf("[bandita %s] %s", condition, message))
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// jobDone records the outcome of a job and alerts on scraper auth failures
+// or a sustained error rate.
+func (a *alerter) jobDone(err error) {
+	if a == nil {
+		return
+	}
+	if isScraperAuthError(err) {
+		a.raise(alertScraperAuth, fmt.Sprintf("Scraper authentication failed: %v", err))
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	a.jobs = append(a.jobs, jobOutcome{at: now, failed: err != nil})
+	cutoff := now.Add(-a.cfg.ErrorWindow)
+	for len(a.jobs) > 0 && a.jobs[0].at.Before(cutoff) {
+		a.jobs = a.jobs[1:]
+	}
+	failed := 0
+	for _, job := range a.jobs {
+		if job.failed {
+			failed++
+		}
+	}
+	total := len(a.jobs)
+	a.mu.Unlock()
+
+	if total >= a.cfg.MinJobs && float64(failed)/float64(total) >= a.cfg.ErrorRate {
+		a.raise(alertErrorRate, fmt.Sprintf("%d of the last %d jobs failed in %v", failed, total, a.cfg.ErrorWindow))
+	}
+}
+
+// isScraperAuthError reports whether the scraper was refused for lack of
+// valid credentials rather than, say, the tweet not existing.
+func isScraperAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "response status 401") || strings.Contains(msg, "response status 403")
+}
+
+// runAlerts delivers queued alerts, holding on to any that fail to publish.
+func (d *Dvm) runAlerts(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.alerts.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		d.alerts.mu.Lock()
+		pending := d.alerts.pending
+		d.alerts.pending = nil
+		d.alerts.mu.Unlock()
+
+		for i, message := range pending {
+			if err := d.sendAlert(message); err != nil {
+				log.Printf("Failed to deliver alert, will retry: %v", err)
+				d.alerts.mu.Lock()
+				d.alerts.pending = append(pending[i:], d.alerts.pending...)
+				d.alerts.mu.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// sendAlert publishes message as a NIP-04 DM from the primary identity.
+func (d *Dvm) sendAlert(message string) error {
+	primary := d.identities[0]
+	secret, err := nip04.ComputeSharedSecret(d.alerts.cfg.AdminPubKey, primary.sk)
+	if err != nil {
+		return err
+	}
+	content, err := nip04.Encrypt(message, secret)
+	if err != nil {
+		return err
+	}
+
+	dm := nostr.Event{
+		PubKey:    primary.pk,
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      4,
+		Tags:      nostr.Tags{{"p", d.alerts.cfg.AdminPubKey}},
+		Content:   content,
+	}
+	if err := dm.Sign(primary.sk); err != nil {
+		return err
+	}
+	return d.publish(dm)
+}
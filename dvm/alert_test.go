@@ -0,0 +1,86 @@
+package dvm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// failingScraper fails every fetch with err.
+type failingScraper struct {
+	err error
+}
+
+func (f *failingScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	return nil, f.err
+}
+
+func TestAlerterDeduplicatesWithinCooldown(t *testing.T) {
+	a, err := newAlerter(AlertConfig{AdminPubKey: strings.Repeat("ab", 32), Cooldown: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.raise(alertRelaysDown, "down")
+	a.raise(alertRelaysDown, "still down")
+	a.raise(alertScraperAuth, "bad cookies")
+
+	if len(a.pending) != 2 {
+		t.Errorf("expected 2 pending alerts, got %d: %q", len(a.pending), a.pending)
+	}
+}
+
+func TestAlerterSustainedErrorRate(t *testing.T) {
+	a, err := newAlerter(AlertConfig{AdminPubKey: strings.Repeat("ab", 32), MinJobs: 4, ErrorRate: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.jobDone(nil)
+	a.jobDone(errors.New("not found"))
+	a.jobDone(nil)
+	if len(a.pending) != 0 {
+		t.Fatalf("expected no alert below MinJobs, got %q", a.pending)
+	}
+	a.jobDone(errors.New("not found"))
+	if len(a.pending) != 1 || !strings.Contains(a.pending[0], alertErrorRate) {
+		t.Errorf("expected an error rate alert, got %q", a.pending)
+	}
+}
+
+func TestScraperAuthFailureDMsAdmin(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	adminSK := testKey()
+	adminPK, _ := nostr.GetPublicKey(adminSK)
+	d := startTestDvm(t, relay,
+		WithScraper(&failingScraper{err: errors.New("response status 401 Unauthorized: bad token")}),
+		WithAlerts(AlertConfig{AdminPubKey: adminPK}),
+	)
+	relay.Publish(newTestRequest("1234"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, evt := range relay.Events() {
+			if evt.Kind != 4 || evt.PubKey != d.GetPublicKey() {
+				continue
+			}
+			secret, _ := nip04.ComputeSharedSecret(d.GetPublicKey(), adminSK)
+			msg, err := nip04.Decrypt(evt.Content, secret)
+			if err != nil {
+				t.Fatalf("failed to decrypt alert: %v", err)
+			}
+			if !strings.Contains(msg, alertScraperAuth) {
+				t.Errorf("unexpected alert: %q", msg)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("no alert DM reached the relay")
+}
@@ -0,0 +1,237 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imperatrona/twitter-scraper"
+)
+
+// ArchiveConfig points at an S3-compatible bucket (AWS, minio, R2...) that
+// receives a copy of every tweet the DVM fetches.
+type ArchiveConfig struct {
+	Endpoint  string // e.g. "https://s3.amazonaws.com" or "http://localhost:9000"
+	Bucket    string
+	Region    string // defaults to us-east-1
+	AccessKey string
+	SecretKey string
+	Media     bool // also archive the tweet's photos, videos and GIFs
+}
+
+// maxArchivedMediaBytes caps each media download so one huge video can't
+// exhaust memory.
+const maxArchivedMediaBytes = 256 << 20
+
+// archiver uploads fetched tweets to object storage. Objects are keyed
+//
+//	tweets/<tweet id>/<fetch time>/tweet.json
+//	tweets/<tweet id>/<fetch time>/media/<media id><ext>
+//
+// so repeated fetches of the same tweet are kept side by side. A nil
+// *archiver archives nothing.
+type archiver struct {
+	cfg    ArchiveConfig
+	client *http.Client
+}
+
+func newArchiver(cfg ArchiveConfig) (*archiver, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("archive requires an endpoint and bucket")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("archive requires an access key and secret key")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &archiver{cfg: cfg, client: &http.Client{Timeout: 2 * time.Minute}}, nil
+}
+
+// archive uploads the tweet and, if configured, its media. Failures are
+// logged and counted; they never affect the job itself.
+func (a *archiver) archive(tweet *twitterscraper.Tweet, tweetJSON []byte, fetchedAt time.Time) {
+	if a == nil {
+		return
+	}
+	prefix := path.Join("tweets", tweet.ID, fetchedAt.UTC().Format("20060102T150405Z"))
+
+	if err := a.put(context.Background(), path.Join(prefix, "tweet.json"), "application/json", tweetJSON); err != nil {
+		log.Printf("Failed to archive tweet %s: %v", tweet.ID, err)
+		metricArchiveFailures.Add(1)
+		return
+	}
+	metricArchiveUploads.Add(1)
+
+	if !a.cfg.Media {
+		return
+	}
+	for id, mediaURL := range tweetMedia(tweet) {
+		if err := a.archiveMedia(prefix, id, mediaURL); err != nil {
+			log.Printf("Failed to archive media %s of tweet %s: %v", id, tweet.ID, err)
+			metricArchiveFailures.Add(1)
+			continue
+		}
+		metricArchiveUploads.Add(1)
+	}
+}
+
+// tweetMedia maps each media ID in the tweet to its download URL.
+func tweetMedia(tweet *twitterscraper.Tweet) map[string]string {
+	media := make(map[string]string)
+	for _, p := range tweet.Photos {
+		media[p.ID] = p.URL
+	}
+	for _, v := range tweet.Videos {
+		if v.URL != "" {
+			media[v.ID] = v.URL
+		}
+	}
+	for _, g := range tweet.GIFs {
+		media[g.ID] = g.URL
+	}
+	return media
+}
+
+func (a *archiver) archiveMedia(prefix, id, mediaURL string) error {
+	resp, err := a.client.Get(mediaURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxArchivedMediaBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxArchivedMediaBytes {
+		return fmt.Errorf("media exceeds %d bytes", maxArchivedMediaBytes)
+	}
+
+	ext := path.Ext(strings.SplitN(path.Base(mediaURL), "?", 2)[0])
+	key := path.Join(prefix, "media", id+ext)
+	return a.put(context.Background(), key, resp.Header.Get("Content-Type"), body)
+}
+
+// put uploads body to key with a path-style, SigV4-signed PUT request.
+func (a *archiver) put(ctx context.Context, key, contentType string, body []byte) error {
+	objectURL := a.cfg.Endpoint + "/" + a.cfg.Bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	signV4(req, body, a.cfg.AccessKey, a.cfg.SecretKey, a.cfg.Region, "s3", time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload of %s returned %s: %s", key, resp.Status, msg)
+	}
+	return nil
+}
+
+// signV4 signs req for an AWS service, such as "s3", with AWS Signature
+// Version 4, covering the host and every header already set on the request.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Canonical headers: lowercase names, sorted, with trimmed values
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted by key as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, url.QueryEscape(k)+"="+strings.ReplaceAll(url.QueryEscape(v), "+", "%20"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
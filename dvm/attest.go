@@ -0,0 +1,171 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Attestation is a result that at least a threshold of independent DVMs
+// returned identically.
+type Attestation struct {
+	// Content is the normalized result the DVMs agreed on.
+	Content string
+	// Results are the signed result events of the DVMs that agreed, so
+	// anyone can check who vouched for Content.
+	Results []*nostr.Event
+	// Failed holds the error from each DVM that didn't agree, by pubkey.
+	Failed map[string]error
+}
+
+// errDisagrees is recorded for DVMs whose results lost the vote.
+var errDisagrees = errors.New("result disagrees with the attested result")
+
+// RequestAttested sends the same job to every DVM in dvmPubKeys and
+// returns once threshold of them have returned byte-identical results
+// after normalization, such as a tweet with its ever-changing engagement
+// counts left out. It fails as soon as no result can reach the threshold.
+func (c *DvmClient) RequestAttested(ctx context.Context, dvmPubKeys []string, threshold int, kind int, input string) (*Attestation, error) {
+	if threshold < 1 || threshold > len(dvmPubKeys) {
+		return nil, fmt.Errorf("threshold %d is not between 1 and the %d DVMs asked", threshold, len(dvmPubKeys))
+	}
+	seen := make(map[string]bool, len(dvmPubKeys))
+	for _, pk := range dvmPubKeys {
+		if seen[pk] {
+			return nil, fmt.Errorf("DVM %s is listed twice", pk)
+		}
+		seen[pk] = true
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stop waiting on the DVMs that weren't needed
+
+	answers := make(chan attestAnswer, len(dvmPubKeys))
+	for _, pk := range dvmPubKeys {
+		go func(pk string) {
+			content, result, err := c.request(ctx, pk, kind, input, requestOptions{})
+			if err == nil {
+				content, err = normalizeResult(kind, content)
+			}
+			answers <- attestAnswer{pk, content, result, err}
+		}(pk)
+	}
+
+	failed := make(map[string]error)
+	votes := make(map[string][]attestAnswer)
+	for pending := len(dvmPubKeys); pending > 0; pending-- {
+		a := <-answers
+		if a.err != nil {
+			failed[a.pubkey] = a.err
+		} else {
+			votes[a.content] = append(votes[a.content], a)
+		}
+
+		if agreed := votes[a.content]; a.err == nil && len(agreed) >= threshold {
+			att := &Attestation{Content: a.content, Failed: failed}
+			for _, v := range agreed {
+				att.Results = append(att.Results, v.result)
+			}
+			for content, others := range votes {
+				if content != a.content {
+					for _, v := range others {
+						failed[v.pubkey] = errDisagrees
+					}
+				}
+			}
+			return att, nil
+		}
+
+		// Give up once the leading result can't make it
+		leading := 0
+		for _, v := range votes {
+			if len(v) > leading {
+				leading = len(v)
+			}
+		}
+		if leading+pending-1 < threshold {
+			return nil, &AttestationError{Threshold: threshold, Failed: failed, Votes: voteCounts(votes)}
+		}
+	}
+	return nil, &AttestationError{Threshold: threshold, Failed: failed, Votes: voteCounts(votes)}
+}
+
+// attestAnswer is one DVM's normalized result, or why it has none.
+type attestAnswer struct {
+	pubkey  string
+	content string
+	result  *nostr.Event
+	err     error
+}
+
+// AttestationError is returned when too few DVMs agree on a result.
+type AttestationError struct {
+	Threshold int
+	Votes     []int // DVMs behind each distinct result, most first
+	Failed    map[string]error
+}
+
+func (e *AttestationError) Error() string {
+	var reasons []string
+	for pk, err := range e.Failed {
+		reasons = append(reasons, fmt.Sprintf("%s: %v", pk[:8], err))
+	}
+	sort.Strings(reasons)
+	msg := fmt.Sprintf("no result reached %d attestations (votes %v)", e.Threshold, e.Votes)
+	if len(reasons) > 0 {
+		msg += ": " + strings.Join(reasons, "; ")
+	}
+	return msg
+}
+
+func voteCounts(votes map[string][]attestAnswer) []int {
+	counts := make([]int, 0, len(votes))
+	for _, v := range votes {
+		counts = append(counts, len(v))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+	return counts
+}
+
+// normalizeResult puts a result in a canonical form, so results from
+// different DVMs that mean the same thing compare equal. JSON is re-encoded
+// with sorted keys, and tweets lose the engagement counts that change by
+// the second and the fields DVMs add to them.
+func normalizeResult(kind int, content string) (string, error) {
+	if kind == KindTweetRequest {
+		var tweet twitterscraper.Tweet
+		if err := json.Unmarshal([]byte(content), &tweet); err != nil {
+			return "", fmt.Errorf("invalid tweet: %w", err)
+		}
+		stripEngagement(&tweet)
+		normalized, err := json.Marshal(tweet)
+		return string(normalized), err
+	}
+
+	var v any
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return strings.TrimSpace(content), nil // not JSON
+	}
+	normalized, err := json.Marshal(v)
+	return string(normalized), err
+}
+
+// stripEngagement zeroes the counts of tweet and the tweets it embeds.
+func stripEngagement(tweet *twitterscraper.Tweet) {
+	if tweet == nil {
+		return
+	}
+	tweet.Likes, tweet.Replies, tweet.Retweets, tweet.Views = 0, 0, 0, 0
+	stripEngagement(tweet.InReplyToStatus)
+	stripEngagement(tweet.QuotedStatus)
+	stripEngagement(tweet.RetweetedStatus)
+	for _, t := range tweet.Thread {
+		stripEngagement(t)
+	}
+}
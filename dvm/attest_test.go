@@ -0,0 +1,106 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/imperatrona/twitter-scraper"
+)
+
+// viewScraper returns the same tweet as every other viewScraper with the
+// same text, seen with its own engagement counts.
+type viewScraper struct {
+	text  string
+	likes int
+}
+
+func (s viewScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	return &twitterscraper.Tweet{ID: id, Username: "halfin", Text: s.text, Likes: s.likes, Views: s.likes * 10}, nil
+}
+
+func TestRequestAttested(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	honest1 := startTestDvm(t, relay, WithScraper(viewScraper{"Running bitcoin", 1}))
+	honest2 := startTestDvm(t, relay, WithScraper(viewScraper{"Running bitcoin", 7}))
+	liar := startTestDvm(t, relay, WithScraper(viewScraper{"Selling bitcoin", 7}))
+	dvms := []string{honest1.GetPublicKey(), honest2.GetPublicKey(), liar.GetPublicKey()}
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	att, err := client.RequestAttested(ctx, dvms, 2, KindTweetRequest, "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(att.Results) != 2 {
+		t.Fatalf("expected 2 attesting results, got %d", len(att.Results))
+	}
+	for _, result := range att.Results {
+		if result.PubKey == liar.GetPublicKey() {
+			t.Error("the liar's result attested the honest one")
+		}
+		if ok, _ := result.CheckSignature(); !ok {
+			t.Error("attesting result has a bad signature")
+		}
+	}
+	if want, _ := normalizeResult(KindTweetRequest, `{"ID":"20","Username":"halfin","Text":"Running bitcoin"}`); att.Content != want {
+		t.Errorf("attested %s, want %s", att.Content, want)
+	}
+
+	// All three never agree
+	_, err = client.RequestAttested(ctx, dvms, 3, KindTweetRequest, "20")
+	var attErr *AttestationError
+	if !errors.As(err, &attErr) || len(attErr.Votes) != 2 {
+		t.Errorf("expected a split vote, got %v", err)
+	}
+
+	if _, err := client.RequestAttested(ctx, dvms, 4, KindTweetRequest, "20"); err == nil {
+		t.Error("expected a threshold over the DVMs asked to be rejected")
+	}
+}
+
+func TestNormalizeResult(t *testing.T) {
+	a, _ := normalizeResult(KindUnfurlRequest, `{"b": 1, "a": [true]}`)
+	b, _ := normalizeResult(KindUnfurlRequest, `{"a":[true],"b":1}`)
+	if a != b {
+		t.Errorf("%s and %s should normalize the same", a, b)
+	}
+	if _, err := normalizeResult(KindTweetRequest, "not a tweet"); err == nil {
+		t.Error("expected a tweet result that isn't JSON to be invalid")
+	}
+}
+
+// BenchmarkNormalizeResult normalizes a tweet, as cross-checking does for
+// every DVM's result, and arbitrary JSON.
+func BenchmarkNormalizeResult(b *testing.B) {
+	tweet, err := json.Marshal(benchTweet())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("tweet", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := normalizeResult(KindTweetRequest, string(tweet)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := normalizeResult(KindFollowsRequest, string(tweet)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package dvm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// AuditRecord is one entry in the hash-chained audit log, linking a request
+// to the event the DVM answered it with.
+type AuditRecord struct {
+	Seq          int64     `json:"seq"`
+	At           time.Time `json:"at"`
+	RequestID    string    `json:"request_id"`
+	Requester    string    `json:"requester"`
+	ResponderKey string    `json:"responder"`
+	ResponseID   string    `json:"response_id"`
+	ResponseKind int       `json:"response_kind"`
+	PrevHash     string    `json:"prev_hash"`
+	Hash         string    `json:"hash"`
+}
+
+// computeHash hashes every field except Hash itself, chaining to PrevHash.
+func (r AuditRecord) computeHash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// KindAuditAnchor is the NIP-78 application data kind used for audit anchors.
+const KindAuditAnchor = 30078
+
+const auditLogName = "audit"
+
+// auditLog appends records to the store, each committing to the previous one.
+type auditLog struct {
+	store *Store
+
+	mu       sync.Mutex
+	seq      int64
+	head     string
+	anchored int64 // seq of the last anchored record
+}
+
+func openAuditLog(store *Store) (*auditLog, error) {
+	a := &auditLog{store: store}
+	n, head, err := VerifyAuditLog(store)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to extend audit log: %w", err)
+	}
+	a.seq, a.head = n, head
+	return a, nil
+}
+
+// record appends the request/response pair to the log.
+func (a *auditLog) record(req, resp *nostr.Event) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rec := AuditRecord{
+		Seq:          a.seq + 1,
+		At:           time.Now().UTC(),
+		RequestID:    req.ID,
+		Requester:    req.PubKey,
+		ResponderKey: resp.PubKey,
+		ResponseID:   resp.ID,
+		ResponseKind: resp.Kind,
+		PrevHash:     a.head,
+	}
+	rec.Hash = rec.computeHash()
+	if err := a.store.Append(auditLogName, rec); err != nil {
+		log.Printf("Failed to append audit record for request %s: %v", req.ID[:8], err)
+		return
+	}
+	a.seq, a.head = rec.Seq, rec.Hash
+}
+
+// pendingAnchor returns the current head if it has not been anchored yet.
+func (a *auditLog) pendingAnchor() (int64, string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seq, a.head, a.seq > a.anchored
+}
+
+func (a *auditLog) markAnchored(seq int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if seq > a.anchored {
+		a.anchored = seq
+	}
+}
+
+// VerifyAuditLog walks the audit log in the store, checking every link of the
+// hash chain. It returns the number of records and the head hash.
+func VerifyAuditLog(store *Store) (int64, string, error) {
+	var seq int64
+	var head string
+	err := store.Scan(auditLogName, func(line []byte) error {
+		var rec AuditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("record %d is corrupt: %w", seq+1, err)
+		}
+		if rec.Seq != seq+1 {
+			return fmt.Errorf("record %d has sequence number %d", seq+1, rec.Seq)
+		}
+		if rec.PrevHash != head {
+			return fmt.Errorf("record %d does not link to record %d", rec.Seq, seq)
+		}
+		if rec.Hash != rec.computeHash() {
+			return fmt.Errorf("record %d has been modified", rec.Seq)
+		}
+		seq, head = rec.Seq, rec.Hash
+		return nil
+	})
+	return seq, head, err
+}
+
+// runAuditAnchors periodically publishes the audit log head to the relay so
+// the log can later be proven not to have been rewritten.
+func (d *Dvm) runAuditAnchors(ctx context.Context) {
+	ticker := time.NewTicker(d.auditAnchorEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			seq, head, pending := d.audit.pendingAnchor()
+			if !pending {
+				continue
+			}
+
+			primary := d.identities[0]
+			anchor := nostr.Event{
+				PubKey:    primary.pk,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Kind:      KindAuditAnchor,
+				Tags: nostr.Tags{
+					{"d", "bandita-audit:" + strconv.FormatInt(seq, 10)},
+					{"seq", strconv.FormatInt(seq, 10)},
+					{"hash", head},
+				},
+				Content: fmt.Sprintf("bandita audit log head: record %d, hash %s", seq, head),
+			}
+			if err := anchor.Sign(primary.sk); err != nil {
+				log.Printf("Failed to sign audit anchor: %v", err)
+				continue
+			}
+			if err := d.publish(anchor); err != nil {
+				log.Printf("Failed to publish audit anchor for record %d: %v", seq, err)
+				continue
+			}
+			d.audit.markAnchored(seq)
+			log.Printf("Anchored audit log at record %d (event %s)", seq, anchor.ID)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package dvm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAuditLogDetectsTampering(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := openAuditLog(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"req1", "req2", "req3"} {
+		audit.record(&nostr.Event{ID: id, PubKey: "requester"}, &nostr.Event{ID: "resp-" + id, PubKey: "dvm", Kind: 1})
+	}
+
+	// Reopening continues the chain
+	reopened, err := openAuditLog(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.record(&nostr.Event{ID: "req4", PubKey: "requester"}, &nostr.Event{ID: "resp-req4", PubKey: "dvm", Kind: 1})
+	if n, _, err := VerifyAuditLog(store); err != nil || n != 4 {
+		t.Fatalf("expected 4 valid records, got %d (%v)", n, err)
+	}
+
+	path := filepath.Join(store.Dir(), auditLogName+".jsonl")
+	data, _ := os.ReadFile(path)
+	tampered := bytes.Replace(data, []byte("resp-req2"), []byte("resp-evil"), 1)
+	os.WriteFile(path, tampered, 0o600)
+
+	if _, _, err := VerifyAuditLog(store); err == nil {
+		t.Fatal("expected verification to detect the modified record")
+	}
+	if _, err := openAuditLog(store); err == nil {
+		t.Error("expected a tampered log to refuse new records")
+	}
+}
@@ -0,0 +1,132 @@
+package dvm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupManifest is stored at the root of every backup archive.
+type backupManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	PubKey    string    `json:"pubkey,omitempty"`
+}
+
+const manifestName = "bandita-backup.json"
+
+// WriteBackup writes a gzipped tar of every file in the store's directory,
+// plus a manifest naming the DVM pubkey the state belongs to.
+func WriteBackup(store *Store, pubkey string, w io.Writer) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest, _ := json.MarshalIndent(backupManifest{CreatedAt: time.Now().UTC(), PubKey: pubkey}, "", "  ")
+	if err := writeTarFile(tw, manifestName, manifest); err != nil {
+		return err
+	}
+
+	err := filepath.WalkDir(store.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return err
+		}
+		rel, err := filepath.Rel(store.dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return writeTarFile(tw, filepath.ToSlash(rel), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", store.dir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// RestoreBackup extracts an archive created by WriteBackup into dir, which
+// must be empty or missing unless overwrite is set. It returns the pubkey
+// recorded in the archive's manifest.
+func RestoreBackup(r io.Reader, dir string, overwrite bool) (string, error) {
+	if existing, err := os.ReadDir(dir); err == nil && len(existing) > 0 && !overwrite {
+		return "", fmt.Errorf("%s is not empty; refusing to overwrite existing state", dir)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", fmt.Errorf("not a bandita backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *backupManifest
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("corrupt backup: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return "", fmt.Errorf("corrupt backup: %w", err)
+		}
+		if hdr.Name == manifestName {
+			manifest = &backupManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return "", fmt.Errorf("corrupt backup manifest: %w", err)
+			}
+			continue
+		}
+
+		// Reject entries that would escape the data directory
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return "", fmt.Errorf("backup entry %q escapes the data directory", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return "", err
+		}
+	}
+
+	if manifest == nil {
+		return "", fmt.Errorf("not a bandita backup: missing %s", manifestName)
+	}
+	return manifest.PubKey, nil
+}
@@ -0,0 +1,68 @@
+package dvm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Save("quota", map[string]int{"alice": 3})
+	NewLedger(store).Record(LedgerEntry{JobID: "job1", Sats: 21})
+
+	var archive bytes.Buffer
+	if err := WriteBackup(store, "pubkey123", &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	pubkey, err := RestoreBackup(bytes.NewReader(archive.Bytes()), dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pubkey != "pubkey123" {
+		t.Errorf("expected manifest pubkey pubkey123, got %q", pubkey)
+	}
+
+	restored, _ := OpenStore(dir)
+	var counts map[string]int
+	restored.Load("quota", &counts)
+	if counts["alice"] != 3 {
+		t.Errorf("quota not restored: %v", counts)
+	}
+	entries, _ := NewLedger(restored).Entries(time.Time{}, time.Time{})
+	if len(entries) != 1 || entries[0].Sats != 21 {
+		t.Errorf("ledger not restored: %+v", entries)
+	}
+
+	// Restoring over existing state requires overwrite
+	if _, err := RestoreBackup(bytes.NewReader(archive.Bytes()), dir, false); err == nil {
+		t.Error("expected restore into a non-empty directory to fail")
+	}
+}
+
+func TestRestoreRejectsPathTraversal(t *testing.T) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	writeTarFile(tw, manifestName, []byte(`{}`))
+	writeTarFile(tw, "../escaped.json", []byte(`{}`))
+	tw.Close()
+	gz.Close()
+
+	dir := filepath.Join(t.TempDir(), "data")
+	if _, err := RestoreBackup(&archive, dir, false); err == nil {
+		t.Fatal("expected restore to reject ../ entries")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escaped.json")); err == nil {
+		t.Error("file escaped the data directory")
+	}
+}
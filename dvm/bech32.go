@@ -0,0 +1,193 @@
+package dvm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32 (BIP-173) encoding, for the npub keys and naddr pointers of
+// NIP-19. go-nostr's nip19 package pulls in more dependencies than it's
+// worth for this.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups data from fromBits-bit to toBits-bit groups.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	var out []byte
+	maxv := uint32(1)<<toBits - 1
+	for _, b := range data {
+		if uint32(b)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
+
+// encodeBech32 encodes data under the human-readable prefix hrp.
+func encodeBech32(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Charset[(polymod>>(5*(5-i)))&31])
+	}
+	return b.String(), nil
+}
+
+// decodeBech32 returns the prefix and data of a bech32 string.
+func decodeBech32(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case bech32 string")
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 string")
+	}
+	hrp := s[:sep]
+	values := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", s[i])
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+// encodeNpub returns the NIP-19 npub form of a hex public key.
+func encodeNpub(pubkey string) (string, error) {
+	raw, err := hex.DecodeString(pubkey)
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("invalid public key %q", pubkey)
+	}
+	return encodeBech32("npub", raw)
+}
+
+// decodeNpub returns the hex public key of an npub.
+func decodeNpub(npub string) (string, error) {
+	hrp, data, err := decodeBech32(npub)
+	if err != nil {
+		return "", err
+	}
+	if hrp != "npub" || len(data) != 32 {
+		return "", fmt.Errorf("not an npub: %q", npub)
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// encodeNaddr returns the NIP-19 naddr of the addressable event kind,
+// pubkey, identifier, with relays as hints.
+func encodeNaddr(kind int, pubkey, identifier string, relays ...string) (string, error) {
+	raw, err := hex.DecodeString(pubkey)
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("invalid public key %q", pubkey)
+	}
+	var tlv []byte
+	put := func(t byte, v []byte) {
+		tlv = append(tlv, t, byte(len(v)))
+		tlv = append(tlv, v...)
+	}
+	put(0, []byte(identifier))
+	for _, relay := range relays {
+		put(1, []byte(relay))
+	}
+	put(2, raw)
+	put(3, []byte{byte(kind >> 24), byte(kind >> 16), byte(kind >> 8), byte(kind)})
+	return encodeBech32("naddr", tlv)
+}
+
+// decodeNaddr returns the kind, pubkey and identifier of a NIP-19 naddr.
+func decodeNaddr(naddr string) (kind int, pubkey, identifier string, err error) {
+	hrp, tlv, err := decodeBech32(naddr)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if hrp != "naddr" {
+		return 0, "", "", fmt.Errorf("not an naddr: %q", naddr)
+	}
+	var haveKind bool
+	for len(tlv) >= 2 {
+		t, n := tlv[0], int(tlv[1])
+		if len(tlv) < 2+n {
+			return 0, "", "", errors.New("truncated naddr")
+		}
+		v := tlv[2 : 2+n]
+		switch {
+		case t == 0:
+			identifier = string(v)
+		case t == 2 && n == 32:
+			pubkey = hex.EncodeToString(v)
+		case t == 3 && n == 4:
+			kind = int(v[0])<<24 | int(v[1])<<16 | int(v[2])<<8 | int(v[3])
+			haveKind = true
+		}
+		tlv = tlv[2+n:]
+	}
+	if pubkey == "" || !haveKind {
+		return 0, "", "", fmt.Errorf("incomplete naddr: %q", naddr)
+	}
+	return kind, pubkey, identifier, nil
+}
@@ -0,0 +1,66 @@
+package dvm
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestNpub(t *testing.T) {
+	// Examples from NIP-19
+	for pubkey, npub := range map[string]string{
+		"3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d": "npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w6",
+		"7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e": "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg",
+	} {
+		got, err := encodeNpub(pubkey)
+		if err != nil || got != npub {
+			t.Errorf("encodeNpub(%s) = %s, %v; want %s", pubkey[:8], got, err, npub)
+		}
+		back, err := decodeNpub(npub)
+		if err != nil || back != pubkey {
+			t.Errorf("decodeNpub(%s) = %s, %v; want %s", npub[:12], back, err, pubkey)
+		}
+	}
+
+	for _, bad := range []string{
+		"npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w7", // checksum
+		"nsec180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w6", // prefix
+		"npub1qqqqqqb",
+		"Npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w6", // mixed case
+	} {
+		if _, err := decodeNpub(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestNaddr(t *testing.T) {
+	pubkey := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+	naddr, err := encodeNaddr(30023, pubkey, "twitter-20", "wss://relay.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hrp, data, err := decodeBech32(naddr)
+	if err != nil || hrp != "naddr" {
+		t.Fatalf("decoding %s: %s, %v", naddr, hrp, err)
+	}
+
+	var identifier, relay, author string
+	var kind int
+	for len(data) >= 2 {
+		typ, v := data[0], data[2:2+data[1]]
+		data = data[2+len(v):]
+		switch typ {
+		case 0:
+			identifier = string(v)
+		case 1:
+			relay = string(v)
+		case 2:
+			author = hex.EncodeToString(v)
+		case 3:
+			kind = int(v[0])<<24 | int(v[1])<<16 | int(v[2])<<8 | int(v[3])
+		}
+	}
+	if identifier != "twitter-20" || relay != "wss://relay.example" || author != pubkey || kind != 30023 {
+		t.Errorf("unexpected naddr fields %q %q %s %d", identifier, relay, author, kind)
+	}
+}
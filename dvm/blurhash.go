@@ -0,0 +1,118 @@
+package dvm
+
+import (
+	"image"
+	"math"
+)
+
+// BlurHash (https://blurha.sh) encoding, so clients can show a placeholder
+// for re-hosted images while they load.
+
+const (
+	blurhashComponentsX = 4
+	blurhashComponentsY = 3
+	// blurhashSamples bounds the pixels sampled along each side; the hash
+	// only keeps the lowest frequencies, so more would be wasted work
+	blurhashSamples = 64
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encodeBase83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(v uint32) float64 {
+	c := float64(v>>8) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// blurhash returns img's BlurHash with 4x3 components.
+func blurhash(img image.Image) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+	sw, sh := w, h
+	if sw > blurhashSamples {
+		sw = blurhashSamples
+	}
+	if sh > blurhashSamples {
+		sh = blurhashSamples
+	}
+
+	// Linear colours of a grid of sampled pixels
+	type rgb [3]float64
+	samples := make([]rgb, sw*sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x*w/sw, b.Min.Y+y*h/sh).RGBA()
+			samples[y*sw+x] = rgb{srgbToLinear(r), srgbToLinear(g), srgbToLinear(bl)}
+		}
+	}
+
+	factors := make([]rgb, 0, blurhashComponentsX*blurhashComponentsY)
+	for j := 0; j < blurhashComponentsY; j++ {
+		for i := 0; i < blurhashComponentsX; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f rgb
+			for y := 0; y < sh; y++ {
+				for x := 0; x < sw; x++ {
+					basis := norm * math.Cos(math.Pi*float64(i*x)/float64(sw)) * math.Cos(math.Pi*float64(j*y)/float64(sh))
+					for c := range f {
+						f[c] += basis * samples[y*sw+x][c]
+					}
+				}
+			}
+			for c := range f {
+				f[c] /= float64(sw * sh)
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	hash := encodeBase83((blurhashComponentsX-1)+(blurhashComponentsY-1)*9, 1)
+	dc, ac := factors[0], factors[1:]
+	maxAC := 0.0
+	for _, f := range ac {
+		for _, v := range f {
+			maxAC = math.Max(maxAC, math.Abs(v))
+		}
+	}
+	quantisedMax := int(math.Max(0, math.Min(82, math.Floor(maxAC*166-0.5))))
+	maxValue := float64(quantisedMax+1) / 166
+	hash += encodeBase83(quantisedMax, 1)
+	hash += encodeBase83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		var q [3]int
+		for c, v := range f {
+			q[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		hash += encodeBase83(q[0]*19*19+q[1]*19+q[2], 2)
+	}
+	return hash
+}
@@ -0,0 +1,46 @@
+package dvm
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestBlurhash(t *testing.T) {
+	solid := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	half := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			solid.Set(x, y, color.White)
+			if x < 100 {
+				half.Set(x, y, color.Black)
+			} else {
+				half.Set(x, y, color.White)
+			}
+		}
+	}
+
+	hash := blurhash(solid)
+	// Size flag for 4x3 components, then the average colour, white
+	if len(hash) != 28 || hash[0] != 'L' || hash[2:6] != "TSUA" {
+		t.Errorf("unexpected hash %q for a white image", hash)
+	}
+	if other := blurhash(half); len(other) != 28 || other == hash {
+		t.Errorf("unexpected hash %q for a half black image", other)
+	}
+	for _, c := range hash {
+		if !strings.ContainsRune(base83Chars, c) {
+			t.Errorf("hash %q isn't base83", hash)
+		}
+	}
+}
+
+func TestEncodeBase83(t *testing.T) {
+	if got := encodeBase83(0xffffff, 4); got != "TSUA" {
+		t.Errorf("got %q", got)
+	}
+	if got := encodeBase83(21, 1); got != "L" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package dvm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// resultCache is an LRU cache of serialized results bounded by their total
+// size, so a burst of large media-heavy tweets can't exhaust memory.
+// A nil *resultCache caches nothing.
+type resultCache struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	ll    *list.List // front is most recently used
+	items map[string]*list.Element
+	bytes int64
+}
+
+type cacheEntry struct {
+	key   string
+	value []byte
+	at    time.Time // when it was put
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+func newResultCache(maxBytes int64) *resultCache {
+	return &resultCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *resultCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		metricCacheMisses.Add(1)
+		return nil, false
+	}
+	metricCacheHits.Add(1)
+	c.ll.MoveToFront(el)
+	return el.Value.(*cacheEntry).value, true
+}
+
+// fetchedAt returns when the value cached under key was put, without
+// counting as a use.
+func (c *resultCache) fetchedAt(key string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return el.Value.(*cacheEntry).at, true
+}
+
+func (c *resultCache) put(key string, value []byte) {
+	c.putAt(key, value, time.Now())
+}
+
+// putAt caches value as fetched at at, rather than now.
+func (c *resultCache) putAt(key string, value []byte, at time.Time) {
+	if c == nil {
+		return
+	}
+	entry := &cacheEntry{key: key, value: value, at: at}
+	if entry.size() > c.maxBytes {
+		// Never worth evicting everything else for one oversized result
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.bytes += entry.size()
+
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+		metricCacheEvictions.Add(1)
+	}
+	metricCacheBytes.Set(c.bytes)
+	metricCacheEntries.Set(int64(c.ll.Len()))
+}
+
+func (c *resultCache) removeElement(el *list.Element) {
+	entry := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size()
+}
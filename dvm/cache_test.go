@@ -0,0 +1,65 @@
+package dvm
+
+import (
+	"bytes"
+	"testing"
+
+	"bandita/internal/relaytest"
+)
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newResultCache(30)
+	evictions := metricCacheEvictions.Value()
+
+	c.put("1", bytes.Repeat([]byte("a"), 9))
+	c.put("2", bytes.Repeat([]byte("b"), 9))
+	c.put("3", bytes.Repeat([]byte("c"), 9))
+
+	// Touch "1" so "2" becomes the least recently used
+	if _, ok := c.get("1"); !ok {
+		t.Fatal("expected 1 to be cached")
+	}
+	c.put("4", bytes.Repeat([]byte("d"), 9))
+
+	if _, ok := c.get("2"); ok {
+		t.Error("expected 2 to be evicted")
+	}
+	for _, key := range []string{"1", "3", "4"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+	if c.bytes > c.maxBytes {
+		t.Errorf("cache holds %d bytes, over its %d limit", c.bytes, c.maxBytes)
+	}
+	if got := metricCacheEvictions.Value() - evictions; got != 1 {
+		t.Errorf("expected 1 eviction, got %d", got)
+	}
+}
+
+func TestResultCacheSkipsOversizedResults(t *testing.T) {
+	c := newResultCache(30)
+	c.put("1", []byte("small"))
+	c.put("2", bytes.Repeat([]byte("x"), 100))
+
+	if _, ok := c.get("2"); ok {
+		t.Error("expected oversized result not to be cached")
+	}
+	if _, ok := c.get("1"); !ok {
+		t.Error("expected oversized result not to evict others")
+	}
+}
+
+func TestDvmServesRepeatRequestsFromCache(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithCache(1<<20))
+
+	requestTestTweet(t, relay, d, "1234")
+	requestTestTweet(t, relay, d, "1234")
+	if calls := scraper.Calls(); calls != 1 {
+		t.Errorf("expected 1 scrape, got %d", calls)
+	}
+}
@@ -0,0 +1,320 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindHandlerInfo is the NIP-89 handler information kind, which the DVM
+// publishes to advertise its capabilities.
+const KindHandlerInfo = 31990
+
+// capabilitiesIdentifier is the d tag of the DVM's handler information, so
+// each run replaces the last.
+const capabilitiesIdentifier = "bandita"
+
+// Capabilities is the content of the DVM's handler information event:
+// enough for a client to build requests the DVM will accept.
+type Capabilities struct {
+	Name  string           `json:"name"`
+	About string           `json:"about,omitempty"`
+	Kinds []KindCapability `json:"kinds"`
+	// ResultMode is how results are published
+	ResultMode ResultMode `json:"result_mode"`
+	// Encryption lists the schemes results are encrypted with, if any
+	Encryption []string `json:"encryption,omitempty"`
+	// PoW is the NIP-13 proof of work, in bits, requests need
+	PoW int `json:"pow,omitempty"`
+}
+
+// Kind returns the capability for a request kind, or nil if the DVM doesn't
+// serve it.
+func (c *Capabilities) Kind(kind int) *KindCapability {
+	for i := range c.Kinds {
+		if c.Kinds[i].Kind == kind {
+			return &c.Kinds[i]
+		}
+	}
+	return nil
+}
+
+// KindCapability describes one job kind the DVM serves.
+type KindCapability struct {
+	Kind       int    `json:"kind"`
+	ResultKind int    `json:"result_kind"`
+	Name       string `json:"name,omitempty"`
+	// Input says what the request's content must be, such as "tweet_id"
+	Input  string      `json:"input,omitempty"`
+	Params []ParamSpec `json:"params,omitempty"`
+	// ParamsSchema is the JSON Schema requests' params are validated
+	// against, derived from Params unless the handler gives its own
+	ParamsSchema *JSONSchema `json:"params_schema,omitempty"`
+	Outputs      []string    `json:"outputs,omitempty"` // MIME types for the output tag
+	// MaxInput caps the characters of a request's text, or the bytes of
+	// the document fetched from its URL
+	MaxInput int64  `json:"max_input,omitempty"`
+	Price    *Price `json:"price,omitempty"`
+}
+
+// ParamSpec describes a ["param", <name>, <value>] tag a kind accepts.
+type ParamSpec struct {
+	Name string `json:"name"`
+	// Type is "string", "boolean", "integer", "duration" (as in 30s or 1h)
+	// or "language" (an ISO 639-1 code)
+	Type    string   `json:"type"`
+	Values  []string `json:"values,omitempty"` // the only values accepted, if limited
+	Default string   `json:"default,omitempty"`
+	Min     *int64   `json:"min,omitempty"`
+	Max     *int64   `json:"max,omitempty"`
+}
+
+// Price is what a kind's jobs cost: a flat price per request plus a price
+// per unit, such as per tweet of an archive.
+type Price struct {
+	Msats        int64  `json:"msats,omitempty"`
+	PerUnitMsats int64  `json:"per_unit_msats,omitempty"`
+	Unit         string `json:"unit,omitempty"` // such as "tweet" or "1000 tokens"
+	// Prepaid jobs wait for a zap before running; others bill their
+	// amount in the result
+	Prepaid bool `json:"prepaid,omitempty"`
+}
+
+// Describer is implemented by handlers that advertise what their requests
+// look like. Handlers registered with WithHandler should implement it,
+// including those replacing a built-in kind; otherwise only their kind is
+// advertised.
+type Describer interface {
+	Describe() KindCapability
+}
+
+// intParam describes an integer param with a default and inclusive bounds.
+func intParam(name string, def, min, max int64) ParamSpec {
+	return ParamSpec{Name: name, Type: "integer", Default: strconv.FormatInt(def, 10), Min: &min, Max: &max}
+}
+
+// removeParam returns params without the one named name.
+func removeParam(params []ParamSpec, name string) []ParamSpec {
+	kept := params[:0]
+	for _, p := range params {
+		if p.Name != name {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// builtinCapabilities describes the built-in job kinds. Prices and options
+// that depend on configuration are filled in by capabilities.
+var builtinCapabilities = map[int]KindCapability{
+	KindTweetRequest: {Name: "tweet", Input: "tweet_id",
+		Params: []ParamSpec{
+			{Name: "ocr", Type: "boolean", Default: "false"},
+			{Name: "rehost", Type: "boolean", Default: "false"},
+			{Name: "crosscheck", Type: "boolean", Default: "true"},
+		},
+		Outputs: []string{OutputJSON, OutputText, OutputMarkdown, OutputPNG},
+	},
+	KindMastodonRequest: {Name: "mastodon", Input: "url"},
+	KindRedditRequest: {Name: "reddit", Input: "url",
+		Params: []ParamSpec{intParam("comments", 0, 0, maxRedditComments)},
+	},
+	KindYouTubeRequest: {Name: "youtube", Input: "url",
+		Params: []ParamSpec{{Name: "language", Type: "language"}},
+	},
+	KindWebPageRequest: {Name: "webpage", Input: "url", MaxInput: maxFetchBytes},
+	KindFeedRequest: {Name: "feed", Input: "url", MaxInput: maxFetchBytes,
+		Params: []ParamSpec{intParam("limit", defaultFeedEntries, 1, maxFeedEntries)},
+	},
+	KindUnfurlRequest: {Name: "unfurl", Input: "url", MaxInput: maxUnfurlBytes},
+	KindPDFRequest: {Name: "pdf", Input: "url", MaxInput: maxPDFBytes,
+		Params: []ParamSpec{intParam("chunk_size", defaultPDFChunkSize, minPDFChunkSize, maxPDFChunkSize)},
+	},
+	KindTranslateRequest: {Name: "translate", Input: "tweet_id or text", MaxInput: maxTranslateChars,
+		Params: []ParamSpec{
+			{Name: "language", Type: "language", Default: defaultTargetLanguage},
+			{Name: "source", Type: "language"},
+		},
+	},
+	KindSummarizeRequest: {Name: "summarize", Input: "tweet_id or url", MaxInput: maxFetchBytes,
+		Params: []ParamSpec{{Name: "length", Type: "string", Values: []string{"short", "medium", "long"}, Default: "medium"}},
+	},
+	KindSentimentRequest: {Name: "sentiment", Input: "tweet_id",
+		Params: []ParamSpec{{Name: "target", Type: "string"}},
+	},
+	KindMirrorsRequest: {Name: "mirrors", Input: "tweet_id"},
+	KindHandleRequest:  {Name: "handle", Input: "twitter_handle"},
+	KindVerifyRequest: {Name: "verify", Input: "npub",
+		Params: []ParamSpec{
+			{Name: "handle", Type: "string"},
+			{Name: "proof", Type: "string"},
+		},
+	},
+	KindUserArchiveRequest: {Name: "user_archive", Input: "twitter_handle",
+		Params: []ParamSpec{
+			intParam("max", defaultUserArchiveTweets, 1, maxUserArchiveTweets),
+			{Name: "format", Type: "string", Values: []string{"events"}, Default: "events"},
+		},
+	},
+	KindFollowsRequest: {Name: "follows", Input: "twitter_handle",
+		Params: []ParamSpec{
+			{Name: "list", Type: "string", Values: []string{"followers", "following"}, Default: "followers"},
+			intParam("max", defaultMaxFollows, 1, maxFollows),
+		},
+	},
+	KindEngagementRequest: {Name: "engagement", Input: "tweet_id"},
+	KindExistenceRequest: {Name: "existence", Input: "tweet_id",
+		Params: []ParamSpec{{Name: "author", Type: "string"}},
+	},
+	KindLongFormRequest: {Name: "long_form", Input: "tweet_id"},
+	KindMonitorRequest: {Name: "monitor", Input: "tweet_id",
+		Params: []ParamSpec{
+			{Name: "interval", Type: "duration", Default: defaultMonitorInterval.String()},
+			{Name: "duration", Type: "duration", Default: defaultMonitorDuration.String()},
+		},
+	},
+}
+
+// capabilities describes the kinds the DVM serves as configured.
+func (d *Dvm) capabilities() Capabilities {
+	caps := Capabilities{
+		Name:       "bandita",
+		About:      "Fetches tweets and other web content for Nostr clients",
+		ResultMode: d.resultMode,
+		PoW:        d.minPoW,
+	}
+	// The DVM goes by its profile, if it has one
+	if d.profile != nil && d.profile.Name != "" {
+		caps.Name = d.profile.Name
+	}
+	if d.profile != nil && d.profile.About != "" {
+		caps.About = d.profile.About
+	}
+	if d.resultMode == ResultsAsDMs {
+		caps.Encryption = []string{"nip04"}
+	}
+	for _, kind := range d.handlerKinds() {
+		var c KindCapability
+		if describer, ok := d.handlers[kind].(Describer); ok {
+			c = describer.Describe()
+		} else if builtin, ok := builtinCapabilities[kind]; ok {
+			c = builtin
+			// Copy the params so the options below don't touch the table
+			c.Params = append([]ParamSpec(nil), c.Params...)
+		}
+		c.Kind, c.ResultKind = kind, ResultKind(kind)
+
+		if price := d.pricing[kind]; price.charges() {
+			c.Price = &Price{Msats: price.Msats, Prepaid: true}
+			if d.refunder != nil {
+				c.Params = append(c.Params, ParamSpec{Name: "refund", Type: "string"})
+			}
+			if batch, ok := d.handlers[kind].(batchHandler); ok && price.PerUnitMsats > 0 {
+				c.Price.PerUnitMsats, c.Price.Unit = price.PerUnitMsats, batch.Unit()
+			}
+		}
+
+		switch h := d.handlers[kind].(type) {
+		case *userArchiveHandler:
+			if d.uploader != nil {
+				c.Params[1].Values = []string{"events", "upload"}
+			}
+		// Jobs using the LLM bill their tokens in the result; the price up
+		// front, if any, is advertised instead
+		case *summarizeHandler:
+			if c.Price == nil {
+				c.Price = llmPrice(h.llm)
+			}
+		case *sentimentHandler:
+			if a, ok := h.analyzer.(*llmAnalyzer); ok && c.Price == nil {
+				c.Price = llmPrice(a.llm)
+			}
+		case tweetHandler:
+			// Only meaningful if there are peers to ask
+			if d.crossCheck == nil {
+				c.Params = removeParam(c.Params, "crosscheck")
+			}
+		case *longFormHandler:
+			if d.mirrorSK != "" {
+				c.Params = append(c.Params, ParamSpec{Name: "publish", Type: "boolean", Default: "false"})
+			}
+		}
+		if c.ParamsSchema == nil && len(c.Params) > 0 {
+			c.ParamsSchema = ParamsSchema(c.Params)
+		}
+		caps.Kinds = append(caps.Kinds, c)
+	}
+	return caps
+}
+
+// llmPrice is the price of jobs billed by the tokens they use, or nil if
+// they're free.
+func llmPrice(llm *llmClient) *Price {
+	if llm.cfg.PricePerKTokens <= 0 {
+		return nil
+	}
+	return &Price{PerUnitMsats: llm.cfg.PricePerKTokens, Unit: "1000 tokens"}
+}
+
+// publishCapabilities advertises the DVM's capabilities as NIP-89 handler
+// information from each identity.
+func (d *Dvm) publishCapabilities() error {
+	content, err := json.Marshal(d.capabilities())
+	if err != nil {
+		return err
+	}
+	tags := nostr.Tags{{"d", capabilitiesIdentifier}}
+	for _, kind := range d.handlerKinds() {
+		tags = append(tags, nostr.Tag{"k", strconv.Itoa(kind)})
+	}
+	for _, id := range d.identities {
+		evt := nostr.Event{
+			PubKey:    id.pk,
+			CreatedAt: nostr.Now(),
+			Kind:      KindHandlerInfo,
+			Tags:      tags,
+			Content:   string(content),
+		}
+		if err := evt.Sign(id.sk); err != nil {
+			return err
+		}
+		if err := d.publish(evt); err != nil {
+			return fmt.Errorf("identity %s: %w", id.name, err)
+		}
+	}
+	log.Printf("Published capabilities for %d job kinds", len(d.handlers))
+	return nil
+}
+
+// Discover fetches the capabilities the DVM with the given pubkey
+// advertises.
+func (c *DvmClient) Discover(ctx context.Context, dvmPubKey string) (*Capabilities, error) {
+	events, err := c.relay.QuerySync(ctx, nostr.Filter{
+		Kinds:   []int{KindHandlerInfo},
+		Authors: []string{dvmPubKey},
+		Tags:    nostr.TagMap{"d": {capabilitiesIdentifier}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var latest *nostr.Event
+	for _, evt := range verifiedEvents(events) {
+		if latest == nil || evt.CreatedAt > latest.CreatedAt {
+			latest = evt
+		}
+	}
+	if latest == nil {
+		return nil, errors.New("DVM hasn't published its capabilities")
+	}
+
+	var caps Capabilities
+	if err := json.Unmarshal([]byte(latest.Content), &caps); err != nil {
+		return nil, fmt.Errorf("error unmarshaling capabilities: %w", err)
+	}
+	return &caps, nil
+}
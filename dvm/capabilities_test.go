@@ -0,0 +1,106 @@
+package dvm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDiscover(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithResultMode(ResultsAsDMs))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var caps *Capabilities
+	for caps == nil {
+		if caps, err = client.Discover(ctx, d.GetPublicKey()); err != nil && ctx.Err() != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if len(caps.Kinds) != len(d.handlers) {
+		t.Errorf("expected %d kinds, got %d", len(d.handlers), len(caps.Kinds))
+	}
+	if len(caps.Encryption) != 1 || caps.Encryption[0] != "nip04" || caps.ResultMode != ResultsAsDMs {
+		t.Errorf("unexpected encryption support %v in mode %q", caps.Encryption, caps.ResultMode)
+	}
+	tweet := caps.Kind(KindTweetRequest)
+	if tweet == nil || tweet.ResultKind != ResultKind(KindTweetRequest) || tweet.Input != "tweet_id" {
+		t.Fatalf("unexpected tweet capability %+v", tweet)
+	}
+	if len(tweet.Params) != 2 || len(tweet.Outputs) != 4 {
+		t.Errorf("expected the tweet params and outputs, got %+v", tweet)
+	}
+	if pdf := caps.Kind(KindPDFRequest); pdf == nil || pdf.MaxInput != maxPDFBytes || *pdf.Params[0].Max != maxPDFChunkSize {
+		t.Errorf("unexpected PDF capability %+v", pdf)
+	}
+	// Archives aren't served without payments
+	if caps.Kind(KindUserArchiveRequest) != nil {
+		t.Error("advertised a kind the DVM doesn't serve")
+	}
+}
+
+// echoHandler is a custom handler that returns the request's content.
+type echoHandler struct{}
+
+func (echoHandler) Validate(req *nostr.Event) error { return nil }
+
+func (echoHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	return []byte(req.Content), nil
+}
+
+// describedHandler is a custom handler that advertises itself.
+type describedHandler struct{ echoHandler }
+
+func (describedHandler) Describe() KindCapability {
+	return KindCapability{Name: "echo", Input: "text", Price: &Price{Msats: 1000}}
+}
+
+func TestCapabilitiesOfCustomHandlers(t *testing.T) {
+	d := &Dvm{handlers: map[int]JobHandler{
+		5999: describedHandler{},
+		5998: echoHandler{},
+	}}
+	caps := d.capabilities()
+	if c := caps.Kind(5999); c == nil || c.Name != "echo" || c.ResultKind != 6999 || c.Price.Msats != 1000 {
+		t.Errorf("unexpected described capability %+v", c)
+	}
+	if c := caps.Kind(5998); c == nil || c.ResultKind != 6998 || c.Input != "" {
+		t.Errorf("unexpected undescribed capability %+v", c)
+	}
+}
+
+// TestBuiltinCapabilitiesCoverParams keeps the advertised params in step
+// with the params structs handlers decode.
+func TestBuiltinCapabilitiesCoverParams(t *testing.T) {
+	for kind, params := range map[int]any{
+		KindTweetRequest:       tweetParams{},
+		KindFollowsRequest:     followsParams{},
+		KindUserArchiveRequest: userArchiveParams{},
+		KindExistenceRequest:   existenceParams{},
+		KindMonitorRequest:     monitorParams{},
+	} {
+		advertised := make(map[string]bool)
+		for _, p := range builtinCapabilities[kind].Params {
+			advertised[p.Name] = true
+		}
+		typ := reflect.TypeOf(params)
+		for i := 0; i < typ.NumField(); i++ {
+			if name := typ.Field(i).Tag.Get("param"); name != "" && !advertised[name] {
+				t.Errorf("kind %d doesn't advertise its %s param", kind, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package dvm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ChallengeConfig has the DVM confirm requests for expensive job kinds
+// with their requester before running them, so a third party replaying a
+// captured request can't set off costly work. The DVM answers such a
+// request with challenge-required feedback carrying a random nonce,
+//
+//	["status", "challenge-required", <message>]
+//	["challenge", <nonce>]
+//
+// and runs the job only once the requester publishes a challenge response
+// echoing it, signed with the request's key:
+//
+//	{"kind": 7001, "tags": [["e", <request id>], ["p", <DVM pubkey>], ["challenge", <nonce>]]}
+//
+// Requests not confirmed within Timeout fail with ReasonChallengeUnanswered.
+// DvmClient answers challenges to its requests by itself.
+type ChallengeConfig struct {
+	Kinds   []int         // request kinds to challenge, default KindUserArchiveRequest
+	Timeout time.Duration // how long to wait for the response, default 5m
+}
+
+func (c ChallengeConfig) withDefaults() ChallengeConfig {
+	if len(c.Kinds) == 0 {
+		c.Kinds = []int{KindUserArchiveRequest}
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Minute
+	}
+	return c
+}
+
+func (c *ChallengeConfig) challenges(kind int) bool {
+	if c == nil {
+		return false
+	}
+	for _, k := range c.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChallengeRequired is the feedback status, beside NIP-90's own,
+// asking the requester to answer a challenge.
+const StatusChallengeRequired = "challenge-required"
+
+// KindChallengeResponse is the kind of the event a requester confirms a
+// challenged request with.
+const KindChallengeResponse = 7001
+
+// challengePollInterval is how often relays are checked for a challenge
+// response.
+var challengePollInterval = 3 * time.Second
+
+// errChallengeTimeout is returned by awaitChallenge when the requester
+// doesn't answer in time.
+var errChallengeTimeout = errors.New("challenge not answered")
+
+// awaitChallenge sends the requester a nonce with challenge-required
+// feedback and waits for their response echoing it.
+func (d *Dvm) awaitChallenge(id *identity, req *nostr.Event) error {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(raw)
+	d.publishFeedback(id, req, StatusChallengeRequired, "",
+		fmt.Sprintf("Publish a kind %d event tagging this request and the challenge, signed with the request's key, to run it", KindChallengeResponse),
+		nostr.Tag{"challenge", nonce})
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.challenge.Timeout)
+	defer cancel()
+	// Relays only index single-letter tags, so the nonce is checked here
+	filter := nostr.Filter{
+		Kinds:   []int{KindChallengeResponse},
+		Authors: []string{req.PubKey},
+		Tags:    nostr.TagMap{"e": {req.ID}, "p": {id.pk}},
+	}
+	ticker := time.NewTicker(challengePollInterval)
+	defer ticker.Stop()
+	for {
+		for _, f := range d.queryRelays(ctx, []nostr.Filter{filter}, nil, nil) {
+			if answersChallenge(f.Event, req, id.pk, nonce) {
+				return nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errChallengeTimeout
+		case <-d.done:
+			return errShuttingDown
+		}
+	}
+}
+
+// challengeResponse returns the unsigned response to challenge-required
+// feedback fb about req, or nil for any other feedback.
+func challengeResponse(fb, req *nostr.Event) *nostr.Event {
+	if fb.Tags.GetFirst([]string{"status", StatusChallengeRequired}) == nil {
+		return nil
+	}
+	nonce := tagValue(fb.Tags, "challenge")
+	if nonce == "" {
+		return nil
+	}
+	return &nostr.Event{
+		PubKey:    req.PubKey,
+		CreatedAt: nostr.Now(),
+		Kind:      KindChallengeResponse,
+		Tags:      nostr.Tags{{"e", req.ID}, {"p", fb.PubKey}, {"challenge", nonce}},
+	}
+}
+
+// answersChallenge reports whether resp is req's requester echoing nonce
+// to the DVM pubkey pk. queryRelays has checked its signature.
+func answersChallenge(resp, req *nostr.Event, pk, nonce string) bool {
+	return resp.Kind == KindChallengeResponse && resp.PubKey == req.PubKey &&
+		resp.Tags.GetFirst([]string{"e", req.ID}) != nil &&
+		resp.Tags.GetFirst([]string{"p", pk}) != nil &&
+		resp.Tags.GetFirst([]string{"challenge", nonce}) != nil
+}
@@ -0,0 +1,124 @@
+package dvm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+func TestChallenge(t *testing.T) {
+	defer func(interval time.Duration) { challengePollInterval = interval }(challengePollInterval)
+	challengePollInterval = 50 * time.Millisecond
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithChallenge(ChallengeConfig{Kinds: []int{KindTweetRequest}}))
+
+	// A request is held until its requester answers the challenge
+	sk := testKey()
+	req := newTestRequestFrom(sk, "20")
+	relay.Publish(req)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	resp := challengeResponse(fb, req)
+	if resp == nil {
+		t.Fatalf("expected a challenge, got %v", fb.Tags)
+	}
+
+	// Not by anyone else
+	forged := *resp
+	forged.Sign(testKey())
+	relay.Publish(&forged)
+	time.Sleep(300 * time.Millisecond)
+	if scraper.Calls() != 0 {
+		t.Fatal("expected a forged response not to run the job")
+	}
+
+	resp.Sign(sk)
+	relay.Publish(resp)
+	deadline := time.Now().Add(5 * time.Second)
+	for scraper.Calls() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the job to run once the challenge was answered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// DvmClient answers by itself
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.RequestTweet(ctx, d.GetPublicKey(), "21"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChallengeUnanswered(t *testing.T) {
+	defer func(interval time.Duration) { challengePollInterval = interval }(challengePollInterval)
+	challengePollInterval = 50 * time.Millisecond
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper),
+		WithChallenge(ChallengeConfig{Kinds: []int{KindTweetRequest}, Timeout: 300 * time.Millisecond}))
+
+	req := newTestRequest("20")
+	relay.Publish(req)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, evt := range relay.Events() {
+			if evt.PubKey == d.GetPublicKey() && evt.Tags.GetFirst([]string{"e", req.ID}) != nil &&
+				evt.Tags.GetFirst([]string{"status", StatusError, ReasonChallengeUnanswered}) != nil {
+				if scraper.Calls() != 0 {
+					t.Error("expected the unconfirmed request not to be scraped")
+				}
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("expected challenge-unanswered feedback")
+}
+
+func TestChallengeKinds(t *testing.T) {
+	var none *ChallengeConfig
+	if none.challenges(KindUserArchiveRequest) {
+		t.Error("expected no challenges without a config")
+	}
+	cfg := ChallengeConfig{}.withDefaults()
+	if !cfg.challenges(KindUserArchiveRequest) || cfg.challenges(KindTweetRequest) {
+		t.Errorf("expected only user archives challenged by default, got %v", cfg.Kinds)
+	}
+	if kinds, err := ParseKinds("user_archive, 42069"); err != nil || len(kinds) != 2 ||
+		kinds[0] != KindUserArchiveRequest || kinds[1] != KindTweetRequest {
+		t.Errorf("ParseKinds = %v, %v", kinds, err)
+	}
+}
+
+func TestChallengeOnStrictRelay(t *testing.T) {
+	defer func(interval time.Duration) { challengePollInterval = interval }(challengePollInterval)
+	challengePollInterval = 50 * time.Millisecond
+	// Real relays don't index the multi-letter challenge tag
+	relay := relaytest.NewServer()
+	relay.StrictTagFilters()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithChallenge(ChallengeConfig{Kinds: []int{KindTweetRequest}}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.RequestTweet(ctx, d.GetPublicKey(), "20"); err != nil {
+		t.Fatal(err)
+	}
+	if scraper.Calls() != 1 {
+		t.Errorf("expected the confirmed job to run once, got %d", scraper.Calls())
+	}
+}
@@ -0,0 +1,151 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ChaosConfig enables fault injection for testing and development. Each rate is
+// a probability between 0 and 1 that the fault fires at its injection point.
+type ChaosConfig struct {
+	DisconnectRate  float64       // drop the relay connection just before a publish
+	PublishFailRate float64       // fail a publish attempt without sending it
+	SlowScrapeRate  float64       // delay a scrape by SlowScrapeDelay
+	SlowScrapeDelay time.Duration // defaults to 2s
+	MalformedRate   float64       // corrupt the content of an incoming request
+	Seed            int64         // 0 picks a time-based seed
+}
+
+// ParseChaosConfig parses a comma separated spec such as
+// "disconnect=0.1,publish=0.2,slow=0.1:3s,malformed=0.05,seed=42".
+func ParseChaosConfig(spec string) (ChaosConfig, error) {
+	var cfg ChaosConfig
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return cfg, fmt.Errorf("invalid chaos option %q: expected key=value", part)
+		}
+
+		var err error
+		switch key {
+		case "disconnect":
+			cfg.DisconnectRate, err = parseRate(value)
+		case "publish":
+			cfg.PublishFailRate, err = parseRate(value)
+		case "slow":
+			rate, delay, hasDelay := strings.Cut(value, ":")
+			cfg.SlowScrapeRate, err = parseRate(rate)
+			if err == nil && hasDelay {
+				cfg.SlowScrapeDelay, err = time.ParseDuration(delay)
+			}
+		case "malformed":
+			cfg.MalformedRate, err = parseRate(value)
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return cfg, fmt.Errorf("unknown chaos option %q", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid chaos option %q: %w", part, err)
+		}
+	}
+	return cfg, nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate must be between 0 and 1")
+	}
+	return rate, nil
+}
+
+// chaos injects faults according to its config. A nil *chaos never injects
+// anything, so call sites don't need to check whether chaos mode is enabled.
+type chaos struct {
+	cfg ChaosConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newChaos(cfg ChaosConfig) *chaos {
+	if cfg.SlowScrapeDelay == 0 {
+		cfg.SlowScrapeDelay = 2 * time.Second
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaos{cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (c *chaos) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64() < rate
+}
+
+// maybeDisconnect closes the relay connection out from under the caller.
+func (c *chaos) maybeDisconnect(relay *nostr.Relay) {
+	if c != nil && c.roll(c.cfg.DisconnectRate) {
+		log.Printf("CHAOS: dropping connection to %s", relayURL(relay))
+		relay.Close()
+	}
+}
+
+// publishFault returns a synthetic error in place of a publish attempt.
+func (c *chaos) publishFault() error {
+	if c != nil && c.roll(c.cfg.PublishFailRate) {
+		log.Printf("CHAOS: failing publish attempt")
+		return fmt.Errorf("chaos: injected publish failure")
+	}
+	return nil
+}
+
+// maybeSlowScrape sleeps before a scrape to simulate a slow backend.
+func (c *chaos) maybeSlowScrape() {
+	if c != nil && c.roll(c.cfg.SlowScrapeRate) {
+		log.Printf("CHAOS: delaying scrape by %v", c.cfg.SlowScrapeDelay)
+		time.Sleep(c.cfg.SlowScrapeDelay)
+	}
+}
+
+// malformedContents are representative garbage payloads seen from real clients.
+var malformedContents = []string{
+	"",
+	"not-a-tweet-id",
+	"https://example.com/status/",
+	"{\"id\": 12345}",
+	"\x00\xff\xfe",
+	strings.Repeat("9", 4096),
+}
+
+// maybeCorrupt replaces the request content with a malformed payload.
+func (c *chaos) maybeCorrupt(evt *nostr.Event) {
+	if c == nil || !c.roll(c.cfg.MalformedRate) {
+		return
+	}
+	c.mu.Lock()
+	content := malformedContents[c.rng.Intn(len(malformedContents))]
+	c.mu.Unlock()
+	log.Printf("CHAOS: corrupting request %s content", evt.ID[:8])
+	evt.Content = content
+}
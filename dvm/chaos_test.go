@@ -0,0 +1,87 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseChaosConfig(t *testing.T) {
+	cfg, err := ParseChaosConfig("disconnect=0.1, publish=0.2,slow=0.3:5s,malformed=0.05,seed=42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := ChaosConfig{
+		DisconnectRate:  0.1,
+		PublishFailRate: 0.2,
+		SlowScrapeRate:  0.3,
+		SlowScrapeDelay: 5 * time.Second,
+		MalformedRate:   0.05,
+		Seed:            42,
+	}
+	if cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+
+	for _, spec := range []string{"disconnect", "publish=2", "slow=0.1:forever", "meteor=0.1"} {
+		if _, err := ParseChaosConfig(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestChaosPublishRetries(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay,
+		WithScraper(&fakeScraper{}),
+		WithChaos(ChaosConfig{DisconnectRate: 0.2, PublishFailRate: 0.2, Seed: 7}),
+	)
+
+	// Each request should survive injected disconnects and publish failures
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		req := newTestRequest(id)
+		relay.Publish(req)
+		awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	}
+}
+
+func TestChaosPublishGivesUp(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay,
+		WithScraper(&fakeScraper{}),
+		WithChaos(ChaosConfig{PublishFailRate: 1}),
+	)
+
+	evt := nostr.Event{PubKey: d.pk, CreatedAt: nostr.Now(), Kind: 1, Content: "never"}
+	evt.Sign(d.sk)
+	if err := d.publish(evt); err == nil {
+		t.Fatal("expected publish to fail when every attempt is sabotaged")
+	}
+	if len(relay.Events()) != 0 {
+		t.Errorf("expected nothing to reach the relay, got %d events", len(relay.Events()))
+	}
+}
+
+func TestChaosMalformedRequestsAreIgnored(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay,
+		WithScraper(scraper),
+		WithChaos(ChaosConfig{MalformedRate: 1}),
+	)
+
+	for i := 0; i < 10; i++ {
+		d.handleRequest(newTestRequest("1110302988"))
+	}
+	if scraper.Calls() != 0 {
+		t.Errorf("expected corrupted requests never to reach the scraper, got %d calls", scraper.Calls())
+	}
+}
@@ -0,0 +1,99 @@
+package dvm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxResultChunks caps the chunks DvmClient will collect for one result.
+const maxResultChunks = 1000
+
+// publishChunks queues content as a sequence of result events of at most
+// d.chunkBytes each, returning the last. sent is called for the last.
+func (d *Dvm) publishChunks(id *identity, req *nostr.Event, content []byte, tags nostr.Tags, sent func(*nostr.Event, error)) (*nostr.Event, error) {
+	chunks := splitChunks(content, d.chunkBytes)
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	var last *nostr.Event
+	for i, chunk := range chunks {
+		chunkTags := append(append(nostr.Tags(nil), tags...),
+			nostr.Tag{"chunk", strconv.Itoa(i), strconv.Itoa(len(chunks))},
+			nostr.Tag{"x", hash})
+		var chunkSent func(*nostr.Event, error)
+		if i == len(chunks)-1 {
+			chunkSent = sent
+		}
+		evt, err := d.publishResultEvent(id, req, chunk, chunkTags, chunkSent)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+		last = evt
+	}
+	return last, nil
+}
+
+// splitChunks splits content into chunks of at most size bytes, without
+// splitting UTF-8 characters, which event content can't hold halves of.
+func splitChunks(content []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(content) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(content[end]) {
+			end--
+		}
+		if end == 0 {
+			end = size // not UTF-8 anyway
+		}
+		chunks = append(chunks, content[:end])
+		content = content[end:]
+	}
+	return append(chunks, content)
+}
+
+// resultChunks collects the chunks of a result as they arrive.
+type resultChunks struct {
+	total int
+	hash  string
+	parts map[int]string
+}
+
+// add records a chunk from a result event's tags and content. Once every
+// chunk has arrived it returns the whole result, checked against its hash.
+func (c *resultChunks) add(tags nostr.Tags, content string) (string, bool, error) {
+	tag := tags.GetFirst([]string{"chunk"})
+	if tag == nil || len(*tag) < 3 {
+		return "", false, errors.New("result isn't chunked")
+	}
+	index, err1 := strconv.Atoi((*tag)[1])
+	total, err2 := strconv.Atoi((*tag)[2])
+	if err1 != nil || err2 != nil || total < 1 || total > maxResultChunks || index < 0 || index >= total {
+		return "", false, fmt.Errorf("invalid chunk tag %v", *tag)
+	}
+	hash := tagValue(tags, "x")
+	if c.parts == nil {
+		c.total, c.hash, c.parts = total, hash, make(map[int]string, total)
+	} else if total != c.total || hash != c.hash {
+		return "", false, errors.New("chunks disagree about the result")
+	}
+	c.parts[index] = content
+	if len(c.parts) < c.total {
+		return "", false, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < c.total; i++ {
+		b.WriteString(c.parts[i])
+	}
+	whole := b.String()
+	if sum := sha256.Sum256([]byte(whole)); hex.EncodeToString(sum[:]) != c.hash {
+		return "", false, errors.New("reassembled result doesn't match its hash")
+	}
+	return whole, true, nil
+}
@@ -0,0 +1,81 @@
+package dvm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSplitChunks(t *testing.T) {
+	content := []byte("naïve café — ünïcödé everywhere")
+	chunks := splitChunks(content, 5)
+	for _, chunk := range chunks {
+		if len(chunk) > 5 || !utf8.Valid(chunk) {
+			t.Errorf("bad chunk %q", chunk)
+		}
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), content) {
+		t.Errorf("chunks %q don't add up to the content", chunks)
+	}
+	if chunks := splitChunks([]byte("short"), 5); len(chunks) != 1 {
+		t.Errorf("expected content that fits to stay whole, got %q", chunks)
+	}
+}
+
+func TestResultChunks(t *testing.T) {
+	whole := "the quick brown fox"
+	sum := sha256.Sum256([]byte(whole))
+	hash := hex.EncodeToString(sum[:])
+	tags := func(i int) nostr.Tags {
+		return nostr.Tags{{"chunk", strconv.Itoa(i), "3"}, {"x", hash}}
+	}
+
+	var chunks resultChunks
+	// Out of order, with a repeat
+	for _, i := range []int{2, 0, 0} {
+		if _, complete, err := chunks.add(tags(i), []string{"the quick", " brown", " fox"}[i]); err != nil || complete {
+			t.Fatalf("chunk %d: complete %v, %v", i, complete, err)
+		}
+	}
+	got, complete, err := chunks.add(tags(1), " brown")
+	if err != nil || !complete || got != whole {
+		t.Errorf("got %q, %v, %v", got, complete, err)
+	}
+
+	var tampered resultChunks
+	tampered.add(tags(0), "the quick")
+	tampered.add(tags(1), " red")
+	if _, _, err := tampered.add(tags(2), " fox"); err == nil {
+		t.Error("expected a hash mismatch")
+	}
+	var bad resultChunks
+	if _, _, err := bad.add(nostr.Tags{{"chunk", "3", "3"}}, ""); err == nil {
+		t.Error("expected an out of range chunk to be rejected")
+	}
+}
+
+func TestChunkedResults(t *testing.T) {
+	for _, mode := range []ResultMode{ResultsAsJobResults, ResultsAsDMs} {
+		relay := relaytest.NewServer()
+		d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithResultMode(mode), WithResultChunking(64))
+		// The client reassembles the tweet, or the test fails
+		requestTestTweet(t, relay, d, "20")
+
+		chunks := 0
+		for _, evt := range relay.Events() {
+			if evt.PubKey == d.GetPublicKey() && evt.Tags.GetFirst([]string{"chunk"}) != nil {
+				chunks++
+			}
+		}
+		if chunks < 2 {
+			t.Errorf("mode %q: expected the tweet in several chunks, got %d", mode, chunks)
+		}
+		relay.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package dvm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CoreLightningConfig connects the DVM to a Core Lightning node through its
+// REST API (clnrest), to make an invoice for each job and watch for it to
+// be paid.
+type CoreLightningConfig struct {
+	URL  string // e.g. "https://127.0.0.1:3010"
+	Rune string // a rune allowing invoice, listinvoices and, with Offers, offer
+	// Offers sends requesters a single-use BOLT12 offer for each job
+	// alongside its bolt11 invoice, for wallets that pay offers. Either
+	// being paid runs the job.
+	Offers bool
+}
+
+// clnNode is an invoicer backed by a Core Lightning node.
+type clnNode struct {
+	cfg    CoreLightningConfig
+	client *http.Client
+}
+
+func newCLNNode(cfg CoreLightningConfig) (*clnNode, error) {
+	if !strings.HasPrefix(cfg.URL, "https://") && !strings.HasPrefix(cfg.URL, "http://") {
+		return nil, fmt.Errorf("invalid Core Lightning REST URL %q", cfg.URL)
+	}
+	if cfg.Rune == "" {
+		return nil, errors.New("Core Lightning REST API requires a rune")
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &clnNode{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// call runs a node RPC method with params, decoding its result into result.
+func (n *clnNode) call(ctx context.Context, method string, params, result any) error {
+	header := http.Header{"Rune": {n.cfg.Rune}}
+	if err := postJSON(ctx, n.client, n.cfg.URL+"/v1/"+method, header, params, result); err != nil {
+		return fmt.Errorf("node %s: %w", method, err)
+	}
+	return nil
+}
+
+func (n *clnNode) invoice(ctx context.Context, msats int64, description string, expiry time.Duration) (*jobInvoice, error) {
+	label := make([]byte, 8)
+	rand.Read(label)
+	inv := &jobInvoice{ref: "bandita-" + hex.EncodeToString(label)}
+
+	var made struct {
+		Bolt11 string `json:"bolt11"`
+	}
+	if err := n.call(ctx, "invoice", map[string]any{
+		"amount_msat": msats,
+		"label":       inv.ref,
+		"description": description,
+		"expiry":      int64(expiry.Seconds()),
+	}, &made); err != nil {
+		return nil, err
+	}
+	if inv.bolt11 = made.Bolt11; inv.bolt11 == "" {
+		return nil, errors.New("node returned no invoice")
+	}
+
+	if n.cfg.Offers {
+		var offer struct {
+			OfferID string `json:"offer_id"`
+			Bolt12  string `json:"bolt12"`
+		}
+		if err := n.call(ctx, "offer", map[string]any{
+			"amount":          fmt.Sprintf("%dmsat", msats),
+			"description":     description,
+			"label":           inv.ref,
+			"single_use":      true,
+			"absolute_expiry": time.Now().Add(expiry).Unix(),
+		}, &offer); err != nil {
+			return nil, err
+		}
+		inv.bolt12, inv.offerRef = offer.Bolt12, offer.OfferID
+	}
+	return inv, nil
+}
+
+// paid checks whether the job's invoice, or an invoice for its offer, has
+// been paid.
+func (n *clnNode) paid(ctx context.Context, inv *jobInvoice) (bool, error) {
+	queries := []map[string]any{{"label": inv.ref}}
+	if inv.offerRef != "" {
+		queries = append(queries, map[string]any{"offer_id": inv.offerRef})
+	}
+	for _, query := range queries {
+		var list struct {
+			Invoices []struct {
+				Status string `json:"status"`
+			} `json:"invoices"`
+		}
+		if err := n.call(ctx, "listinvoices", query, &list); err != nil {
+			return false, err
+		}
+		for _, i := range list.Invoices {
+			if i.Status == "paid" {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
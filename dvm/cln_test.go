@@ -0,0 +1,128 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeCLN is a Core Lightning REST API whose invoices are paid by calling
+// Pay, which pays the invoice's offer if it has one.
+type fakeCLN struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	labels map[string]string // bolt11 -> label
+	offers map[string]string // label -> offer ID
+	paid   map[string]bool   // label or offer ID -> paid
+}
+
+func newFakeCLN(t *testing.T) *fakeCLN {
+	n := &fakeCLN{labels: make(map[string]string), offers: make(map[string]string), paid: make(map[string]bool)}
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Rune") != "test-rune" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		label, _ := params["label"].(string)
+		switch r.URL.Path {
+		case "/v1/invoice":
+			bolt11 := fmt.Sprintf("lnbc%vmsat1fake%d", params["amount_msat"], len(n.labels))
+			n.labels[bolt11] = label
+			json.NewEncoder(w).Encode(map[string]any{"bolt11": bolt11})
+		case "/v1/offer":
+			n.offers[label] = "offer-" + label
+			json.NewEncoder(w).Encode(map[string]any{"offer_id": n.offers[label], "bolt12": "lno1fake" + label})
+		case "/v1/listinvoices":
+			key := label
+			if id, ok := params["offer_id"].(string); ok {
+				key = id
+			}
+			status := "unpaid"
+			if n.paid[key] {
+				status = "paid"
+			}
+			json.NewEncoder(w).Encode(map[string]any{"invoices": []any{map[string]string{"status": status}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+func (n *fakeCLN) Pay(ctx context.Context, dvmPubKey string, req *nostr.Event, msats int64, bolt11 string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	label, ok := n.labels[bolt11]
+	if !ok {
+		return fmt.Errorf("unknown invoice %q", bolt11)
+	}
+	if offer, ok := n.offers[label]; ok {
+		n.paid[offer] = true
+	} else {
+		n.paid[label] = true
+	}
+	return nil
+}
+
+func TestCoreLightningPayments(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	if _, err := NewDvm(relay.URL(), testKey(), WithPayments(PaymentConfig{CoreLightning: &CoreLightningConfig{URL: "https://node"}})); err == nil {
+		t.Error("expected a node without a rune to be rejected")
+	}
+
+	node := newFakeCLN(t)
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithPayments(PaymentConfig{
+			CoreLightning: &CoreLightningConfig{URL: node.URL, Rune: "test-rune", Offers: true},
+			Timeout:       5 * time.Second,
+		}),
+		WithPricing(PricingConfig{KindTweetRequest: {Msats: 3000}}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := client.RunPipeline(ctx, Pipeline{
+		Steps: []PipelineStep{{DVM: d.GetPublicKey(), Kind: KindTweetRequest, MaxMsats: 3000}},
+		Payer: node,
+	}, "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Steps[0].PaidMsats != 3000 || !strings.Contains(res.Output, "Running bitcoin") {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	// The payment-required feedback offered both an invoice and an offer
+	var offered bool
+	for _, evt := range relay.Events() {
+		if evt.Kind == KindJobFeedback && tagValue(evt.Tags, "status") == StatusPaymentRequired {
+			amount := evt.Tags.GetFirst([]string{"amount", "3000", "lnbc"})
+			offered = amount != nil && strings.HasPrefix(tagValue(evt.Tags, "bolt12"), "lno1")
+		}
+	}
+	if !offered {
+		t.Error("expected payment-required feedback with a bolt11 invoice and BOLT12 offer")
+	}
+}
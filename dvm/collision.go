@@ -0,0 +1,188 @@
+package dvm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// CollisionConfig has the DVM watch its relays for another instance
+// running under the same key, which would answer every request twice.
+// Each instance announces itself in an instance beacon, an app data event
+// with the d tag "bandita-instance" naming it, and on startup and every
+// Interval the DVM looks for a beacon from another instance, or for
+// results and feedback under its pubkeys that it didn't publish. Either
+// raises a duplicate-identity alert; see WithAlerts.
+type CollisionConfig struct {
+	Interval time.Duration // between checks, default 5m
+}
+
+func (c CollisionConfig) withDefaults() CollisionConfig {
+	if c.Interval <= 0 {
+		c.Interval = 5 * time.Minute
+	}
+	return c
+}
+
+// instanceIdentifier is the d tag of instance beacons.
+const instanceIdentifier = "bandita-instance"
+
+// collisionMemory is how many of its own event IDs the DVM remembers, to
+// tell them from another instance's.
+const collisionMemory = 10000
+
+// instanceBeacon is the content of an instance beacon.
+type instanceBeacon struct {
+	Instance string          `json:"instance"`
+	Started  nostr.Timestamp `json:"started"`
+}
+
+// collisionDetector looks for another instance under the DVM's keys. A
+// nil *collisionDetector does nothing.
+type collisionDetector struct {
+	cfg      CollisionConfig
+	instance string // random, for this run
+	started  time.Time
+
+	mu        sync.Mutex
+	published *lru[struct{}] // IDs of events this instance published
+}
+
+func newCollisionDetector(cfg CollisionConfig) (*collisionDetector, error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return &collisionDetector{
+		cfg:       cfg.withDefaults(),
+		instance:  hex.EncodeToString(raw),
+		started:   time.Now(),
+		published: newLRU[struct{}](collisionMemory),
+	}, nil
+}
+
+// sent records that this instance published the event id.
+func (c *collisionDetector) sent(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published.put(id, struct{}{})
+}
+
+func (c *collisionDetector) ours(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.published.get(id)
+	return ok
+}
+
+// runCollisionCheck checks for another instance now and every interval,
+// announcing this one after each check.
+func (d *Dvm) runCollisionCheck(ctx context.Context) {
+	since := d.collisions.started
+	for {
+		checked := time.Now()
+		d.checkCollisions(ctx, since)
+		// Results published just before the check may reach the relays
+		// after it, so the next check overlaps this one a little
+		since = checked.Add(-time.Minute)
+		if since.Before(d.collisions.started) {
+			since = d.collisions.started
+		}
+		d.announceInstance()
+
+		select {
+		case <-time.After(d.collisions.cfg.Interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkCollisions looks for beacons of other instances, and results and
+// feedback this instance didn't publish since since.
+func (d *Dvm) checkCollisions(ctx context.Context, since time.Time) {
+	c := d.collisions
+	var authors []string
+	for _, id := range d.identities {
+		authors = append(authors, id.pk)
+	}
+	kinds := []int{KindJobFeedback}
+	for _, kind := range d.handlerKinds() {
+		kinds = append(kinds, ResultKind(kind))
+	}
+	ts := nostr.Timestamp(since.Unix())
+	filters := []nostr.Filter{
+		{Kinds: []int{KindAppData}, Authors: authors, Tags: nostr.TagMap{"d": {instanceIdentifier}}},
+		{Kinds: kinds, Authors: authors, Since: &ts},
+	}
+
+	for _, f := range d.queryRelays(ctx, filters, nil, nil) {
+		evt := f.Event
+		if evt.Kind != KindAppData {
+			if !c.ours(evt.ID) && evt.CreatedAt >= ts {
+				d.reportCollision(evt.PubKey, fmt.Sprintf("it published %s (kind %d) on %s", evt.ID[:8], evt.Kind, f.Relays[0]))
+			}
+			continue
+		}
+
+		var beacon instanceBeacon
+		if json.Unmarshal([]byte(evt.Content), &beacon) != nil || beacon.Instance == c.instance {
+			continue
+		}
+		if evt.CreatedAt < nostr.Timestamp(c.started.Unix()) {
+			// Likely this DVM's own previous run; if it's still going, its
+			// next beacon gives it away
+			log.Printf("Instance %s announced itself as %s at %s, before this one started",
+				beacon.Instance, evt.PubKey[:8], evt.CreatedAt.Time().Format(time.RFC3339))
+			continue
+		}
+		d.reportCollision(evt.PubKey, fmt.Sprintf("instance %s, running since %s, announced itself on %s",
+			beacon.Instance, beacon.Started.Time().Format(time.RFC3339), f.Relays[0]))
+	}
+}
+
+// reportCollision logs and alerts that another instance is using pubkey.
+func (d *Dvm) reportCollision(pubkey, evidence string) {
+	metricIdentityCollisions.Add(1)
+	msg := fmt.Sprintf("Another DVM instance is running as %s: %s. Two instances with one key answer every request twice; stop one of them",
+		pubkey[:8], evidence)
+	log.Printf("%s", msg)
+	d.alerts.raise(alertDuplicateIdentity, msg)
+}
+
+// announceInstance publishes a beacon for each identity naming this
+// instance. Beacons expire after two intervals, so a stopped instance's
+// last one doesn't linger.
+func (d *Dvm) announceInstance() {
+	c := d.collisions
+	content, _ := json.Marshal(instanceBeacon{Instance: c.instance, Started: nostr.Timestamp(c.started.Unix())})
+	expiration := time.Now().Add(2 * c.cfg.Interval).Unix()
+	for _, id := range d.identities {
+		beacon := nostr.Event{
+			PubKey:    id.pk,
+			CreatedAt: nostr.Now(),
+			Kind:      KindAppData,
+			Tags: nostr.Tags{
+				{"d", instanceIdentifier},
+				{"expiration", strconv.FormatInt(expiration, 10)},
+			},
+			Content: string(content),
+		}
+		if err := beacon.Sign(id.sk); err != nil {
+			log.Printf("Failed to sign instance beacon: %v", err)
+			continue
+		}
+		d.publishAsync(beacon, nil)
+	}
+}
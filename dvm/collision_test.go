@@ -0,0 +1,104 @@
+package dvm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// startInstance runs a DVM as sk that checks for collisions every interval.
+func startInstance(t *testing.T, relay *relaytest.Server, sk string, opts ...Option) *Dvm {
+	t.Helper()
+	opts = append(opts, WithScraper(&fakeScraper{}), WithCollisionCheck(CollisionConfig{Interval: 200 * time.Millisecond}))
+	d, err := NewDvm(relay.URL(), sk, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	time.Sleep(100 * time.Millisecond)
+	return d
+}
+
+// awaitCollisions waits for the collision count to pass n.
+func awaitCollisions(t *testing.T, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for metricIdentityCollisions.Value() <= n {
+		if time.Now().After(deadline) {
+			t.Fatal("no collision reported")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCollisionCheck(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	adminSK := testKey()
+	adminPK, _ := nostr.GetPublicKey(adminSK)
+	sk := testKey()
+
+	// Alone, the instance's own beacons and results don't count
+	before := metricIdentityCollisions.Value()
+	d := startInstance(t, relay, sk, WithAlerts(AlertConfig{AdminPubKey: adminPK}))
+	req := newTestRequest("20")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	time.Sleep(600 * time.Millisecond)
+	if n := metricIdentityCollisions.Value(); n != before {
+		t.Fatalf("expected no collisions for a lone instance, got %d", n-before)
+	}
+
+	// A second instance with the same key gives itself away
+	startInstance(t, relay, sk)
+	awaitCollisions(t, before)
+
+	secret, _ := nip04.ComputeSharedSecret(d.GetPublicKey(), adminSK)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, evt := range relay.Events() {
+			if evt.Kind != 4 || evt.PubKey != d.GetPublicKey() {
+				continue
+			}
+			if msg, _ := nip04.Decrypt(evt.Content, secret); strings.Contains(msg, alertDuplicateIdentity) {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("expected a duplicate-identity alert DM")
+}
+
+func TestCollisionCheckForeignResults(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	sk := testKey()
+	startInstance(t, relay, sk)
+	before := metricIdentityCollisions.Value()
+
+	// Feedback under the DVM's key that it didn't send, as an older version
+	// without beacons would
+	req := newTestRequest("20")
+	feedback := &nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindJobFeedback,
+		Tags:      nostr.Tags{{"e", req.ID}, {"status", StatusProcessing}},
+	}
+	feedback.Sign(sk)
+	relay.Publish(feedback)
+	awaitCollisions(t, before)
+}
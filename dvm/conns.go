@@ -0,0 +1,253 @@
+package dvm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayConns is the process's shared relay connections. DVMs, clients and
+// wallets connecting to the same relay share one connection, which is
+// closed once the last of them releases it.
+var relayConns = newConnManager()
+
+// connManager reference counts relay connections by URL. Holders acquire a
+// relay once and release it when done with it; in between, live returns
+// the current connection, redialing if it dropped, so a reconnect by one
+// holder serves all of them.
+type connManager struct {
+	mu    sync.Mutex
+	conns map[string]*sharedConn
+	dial  func(ctx context.Context, url string) (*nostr.Relay, context.CancelFunc, error)
+
+	// watchers by normalized URL, kept apart from conns as they're
+	// registered before dialing, to be there for the relay's first AUTH
+	watchMu  sync.Mutex
+	watchers map[string][]*relayWatcher
+}
+
+// relayWatcher is told of what a relay says outside of subscriptions and
+// publishes: its NOTICEs, and its NIP-42 AUTH challenges, which auth
+// answers by signing evt, or declines by returning false. Either may be
+// nil.
+type relayWatcher struct {
+	notice func(url, notice string)
+	auth   func(evt *nostr.Event) bool
+}
+
+// sharedConn is one relay's connection and its holders.
+type sharedConn struct {
+	relay   *nostr.Relay       // nil until dialed
+	close   context.CancelFunc // ends relay's connection
+	refs    int                // holders that have acquired it and not released it
+	dialing chan struct{}      // closed when an in-progress dial finishes
+}
+
+func newConnManager() *connManager {
+	m := &connManager{
+		conns:    make(map[string]*sharedConn),
+		watchers: make(map[string][]*relayWatcher),
+	}
+	// The connection is ended by canceling its context rather than by
+	// Relay.Close, which isn't safe to call while the relay's read loop
+	// may be closing it too, as it does when the connection drops
+	m.dial = func(ctx context.Context, url string) (*nostr.Relay, context.CancelFunc, error) {
+		dialURL, closed, err := dialRelayURL(url)
+		if err != nil {
+			return nil, nil, err
+		}
+		connCtx, cancel := context.WithCancel(context.Background())
+		relay := nostr.NewRelay(connCtx, dialURL,
+			nostr.WithNoticeHandler(func(notice string) { m.notice(url, notice) }),
+			nostr.WithAuthHandler(func(_ context.Context, evt *nostr.Event) bool {
+				// Names the relay, not the tunnel it may have been dialed through
+				for i, tag := range evt.Tags {
+					if len(tag) >= 2 && tag[0] == "relay" {
+						evt.Tags[i] = nostr.Tag{"relay", url}
+					}
+				}
+				return m.auth(url, evt)
+			}),
+		)
+		// Holders check signatures themselves, through the verifier's
+		// cache; see verifyEvent
+		relay.AssumeValid = true
+		if err := relay.Connect(ctx); err != nil {
+			cancel()
+			closed()
+			return relay, nil, err
+		}
+		go func() {
+			<-relay.Context().Done()
+			closed()
+		}()
+		return relay, cancel, nil
+	}
+	return m
+}
+
+// watch registers w for the relay at url until the returned function is
+// called.
+func (m *connManager) watch(url string, w *relayWatcher) (unwatch func()) {
+	key := nostr.NormalizeURL(url)
+	m.watchMu.Lock()
+	m.watchers[key] = append(m.watchers[key], w)
+	m.watchMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.watchMu.Lock()
+			defer m.watchMu.Unlock()
+			kept := m.watchers[key][:0]
+			for _, other := range m.watchers[key] {
+				if other != w {
+					kept = append(kept, other)
+				}
+			}
+			if len(kept) == 0 {
+				delete(m.watchers, key)
+			} else {
+				m.watchers[key] = kept
+			}
+		})
+	}
+}
+
+func (m *connManager) watching(url string) []*relayWatcher {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	return append([]*relayWatcher(nil), m.watchers[nostr.NormalizeURL(url)]...)
+}
+
+// notice passes a relay's NOTICE to its watchers. It runs on the
+// connection's notice goroutine, which the connection waits on, so
+// watchers mustn't block.
+func (m *connManager) notice(url, notice string) {
+	watchers := m.watching(url)
+	if len(watchers) == 0 {
+		log.Printf("NOTICE from %s: %s", url, notice)
+	}
+	for _, w := range watchers {
+		if w.notice != nil {
+			w.notice(url, notice)
+		}
+	}
+}
+
+// auth has the first watcher able to sign a relay's AUTH event sign it.
+// The connection is shared, so it authenticates as one identity for all
+// its holders.
+func (m *connManager) auth(url string, evt *nostr.Event) bool {
+	for _, w := range m.watching(url) {
+		if w.auth != nil && w.auth(evt) {
+			log.Printf("Authenticating to %s as %s", url, evt.PubKey[:8])
+			return true
+		}
+	}
+	log.Printf("Not authenticating to %s: no key to authenticate with", url)
+	return false
+}
+
+// acquire takes a reference to the relay at url and returns a live
+// connection to it, dialing only if there isn't one already. Each
+// successful acquire must be paired with a release.
+func (m *connManager) acquire(ctx context.Context, url string) (*nostr.Relay, error) {
+	return m.connect(ctx, url, true)
+}
+
+// live returns a live connection to a relay the caller has acquired,
+// redialing it if the shared connection dropped.
+func (m *connManager) live(ctx context.Context, url string) (*nostr.Relay, error) {
+	return m.connect(ctx, url, false)
+}
+
+func (m *connManager) connect(ctx context.Context, url string, ref bool) (*nostr.Relay, error) {
+	key := nostr.NormalizeURL(url)
+	m.mu.Lock()
+	for {
+		c, ok := m.conns[key]
+		if !ok {
+			if !ref {
+				m.mu.Unlock()
+				return nil, fmt.Errorf("relay %s was not acquired", url)
+			}
+			c = &sharedConn{}
+			m.conns[key] = c
+		}
+		if c.relay != nil && c.relay.IsConnected() {
+			if ref {
+				c.refs++
+			}
+			relay := c.relay
+			m.mu.Unlock()
+			return relay, nil
+		}
+		if c.dialing == nil {
+			break
+		}
+		// Someone else is dialing; use their connection when they're done
+		dialing := c.dialing
+		m.mu.Unlock()
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		m.mu.Lock()
+	}
+
+	c := m.conns[key]
+	dialing := make(chan struct{})
+	c.dialing = dialing
+	m.mu.Unlock()
+
+	// Dial without holding the lock so a slow relay doesn't stall the rest
+	relay, cancel, err := m.dial(ctx, url)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.dialing = nil
+	close(dialing)
+	if err != nil {
+		if c.refs == 0 {
+			delete(m.conns, key)
+		}
+		return nil, err
+	}
+	if !ref && c.refs == 0 {
+		// Released while we were redialing
+		cancel()
+		delete(m.conns, key)
+		return nil, fmt.Errorf("relay %s was released", url)
+	}
+	c.relay, c.close = relay, cancel // the old connection, if any, already dropped
+	if ref {
+		c.refs++
+	}
+	return relay, nil
+}
+
+// release drops a reference taken by acquire, closing the connection if
+// it was the last.
+func (m *connManager) release(url string) {
+	key := nostr.NormalizeURL(url)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conns[key]
+	if !ok || c.refs == 0 {
+		return
+	}
+	if c.refs--; c.refs > 0 {
+		return
+	}
+	if c.close != nil {
+		c.close()
+	}
+	if c.dialing == nil {
+		delete(m.conns, key)
+	}
+}
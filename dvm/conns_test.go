@@ -0,0 +1,138 @@
+package dvm
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// countingConnManager returns a connection manager that counts its dials.
+func countingConnManager() (*connManager, *int32) {
+	var dials int32
+	m := newConnManager()
+	dial := m.dial
+	m.dial = func(ctx context.Context, url string) (*nostr.Relay, context.CancelFunc, error) {
+		atomic.AddInt32(&dials, 1)
+		return dial(ctx, url)
+	}
+	return m, &dials
+}
+
+// waitFor polls cond for up to a second.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestConnManagerSharesConnections(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	m, dials := countingConnManager()
+	ctx := context.Background()
+
+	a, err := m.acquire(ctx, relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.acquire(ctx, relay.URL()+"/") // the same relay, normalized
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || *dials != 1 || !waitFor(func() bool { return relay.Connections() == 1 }) {
+		t.Errorf("expected one shared connection, got %d dials and %d connections", *dials, relay.Connections())
+	}
+
+	// A dropped connection is redialed once for all holders
+	relay.DropConnections()
+	if !waitFor(func() bool { return !a.IsConnected() }) {
+		t.Fatal("connection didn't drop")
+	}
+	c, err := m.live(ctx, relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := m.live(ctx, relay.URL()); d != c || *dials != 2 {
+		t.Errorf("expected one redial, got %d dials", *dials)
+	}
+
+	// The connection closes with its last holder
+	m.release(relay.URL())
+	if !c.IsConnected() {
+		t.Error("connection closed while still held")
+	}
+	m.release(relay.URL())
+	if c.IsConnected() {
+		t.Error("expected the connection to close once released by all holders")
+	}
+	if _, err := m.live(ctx, relay.URL()); err == nil {
+		t.Error("expected live to need an acquired relay")
+	}
+}
+
+func TestDvmAndClientsShareConnection(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}))
+
+	for i := 0; i < 3; i++ {
+		client, err := NewDvmClient(relay.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := client.RequestTweet(ctx, d.GetPublicKey(), "20"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := relay.Connections(); n != 1 {
+		t.Errorf("expected the DVM and clients to share one connection, got %d", n)
+	}
+}
+
+// BenchmarkRelayConnect compares dialing a relay for each request with
+// acquiring a shared connection, from many goroutines at once.
+func BenchmarkRelayConnect(b *testing.B) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	ctx := context.Background()
+
+	b.Run("dial", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				conn, err := nostr.RelayConnect(ctx, relay.URL())
+				if err != nil {
+					b.Fatal(err)
+				}
+				conn.Close()
+			}
+		})
+	})
+
+	b.Run("shared", func(b *testing.B) {
+		m, dials := countingConnManager()
+		// A long-lived holder, such as the DVM's pool
+		if _, err := m.acquire(ctx, relay.URL()); err != nil {
+			b.Fatal(err)
+		}
+		defer m.release(relay.URL())
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := m.acquire(ctx, relay.URL()); err != nil {
+					b.Fatal(err)
+				}
+				m.release(relay.URL())
+			}
+		})
+		b.ReportMetric(float64(*dials), "dials")
+	})
+}
@@ -0,0 +1,121 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// CrossCheckConfig names other tweet DVMs to compare suspicious tweets
+// with before serving them. A tweet is suspicious when it has no author,
+// or no text and no media, which is what scrapers tend to return when
+// Twitter serves them a degraded page. The result is served either way,
+// tagged with each peer's verdict:
+//
+//	["crosscheck", <peer pubkey>, "agrees" | "disagrees" | "unanswered"]
+type CrossCheckConfig struct {
+	Peers   []string      // hex pubkeys of peer DVMs
+	Timeout time.Duration // how long to wait for peers; 20s if zero
+}
+
+// defaultCrossCheckTimeout bounds how long suspicious tweets wait on
+// peers.
+const defaultCrossCheckTimeout = 20 * time.Second
+
+// suspiciousTweet reports whether tweet looks like a scraper failure
+// rather than a real tweet.
+func suspiciousTweet(tweet *twitterscraper.Tweet) bool {
+	empty := tweet.Text == "" && len(tweet.Photos) == 0 && len(tweet.Videos) == 0 && len(tweet.GIFs) == 0
+	return empty || tweet.Username == "" && tweet.UserID == ""
+}
+
+// crossCheckTweet asks the configured peers for the tweet in result if it
+// looks suspicious, tagging the job's result with whether each agrees.
+func (d *Dvm) crossCheckTweet(ctx context.Context, tweetID string, result []byte) {
+	var tweet twitterscraper.Tweet
+	if err := json.Unmarshal(result, &tweet); err != nil || !suspiciousTweet(&tweet) {
+		return
+	}
+	ours, err := normalizeResult(KindTweetRequest, string(result))
+	if err != nil {
+		return
+	}
+
+	timeout := d.crossCheck.Timeout
+	if timeout <= 0 {
+		timeout = defaultCrossCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	client, err := d.peerClient(ctx)
+	if err != nil {
+		log.Printf("Can't cross-check tweet %s: %v", d.scrub.logContent(tweetID), err)
+		return
+	}
+
+	log.Printf("Tweet %s looks suspicious, cross-checking with %d peers", d.scrub.logContent(tweetID), len(d.crossCheck.Peers))
+	verdicts := make([]nostr.Tag, len(d.crossCheck.Peers))
+	var wg sync.WaitGroup
+	for i, peer := range d.crossCheck.Peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			verdict := "unanswered"
+			// Peers mustn't ask us back, or suspicious tweets would bounce
+			// between DVMs that cross-check each other
+			content, _, err := client.request(ctx, peer, KindTweetRequest, tweetID,
+				requestOptions{tags: nostr.Tags{{"param", "crosscheck", "false"}}})
+			if err == nil {
+				theirs, err := normalizeResult(KindTweetRequest, content)
+				if err == nil && theirs == ours {
+					verdict = "agrees"
+				} else if err == nil {
+					verdict = "disagrees"
+				}
+			}
+			verdicts[i] = nostr.Tag{"crosscheck", peer, verdict}
+		}(i, peer)
+	}
+	wg.Wait()
+	tagResult(ctx, verdicts...)
+}
+
+// peerClient returns a client for asking peer DVMs on the DVM's best
+// relay, with a throwaway key so peers can't tell it's us.
+func (d *Dvm) peerClient(ctx context.Context) (*DvmClient, error) {
+	relay, err := d.pool.best(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := generatePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	pk, _ := nostr.GetPublicKey(sk)
+	return &DvmClient{sk: sk, pk: pk, relay: relay, http: &http.Client{Timeout: 2 * time.Minute}}, nil
+}
+
+// validateCrossCheck checks the peers are DVMs other than this one.
+func (d *Dvm) validateCrossCheck() error {
+	if len(d.crossCheck.Peers) == 0 {
+		return fmt.Errorf("cross-checking needs at least one peer")
+	}
+	for _, peer := range d.crossCheck.Peers {
+		if !nostr.IsValidPublicKeyHex(peer) {
+			return fmt.Errorf("invalid peer pubkey %q", peer)
+		}
+		for _, id := range d.identities {
+			if id.pk == peer {
+				return fmt.Errorf("peer %s is this DVM", peer[:8])
+			}
+		}
+	}
+	return nil
+}
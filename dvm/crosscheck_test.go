@@ -0,0 +1,67 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// tweetRequestTo builds a tweet request addressed to d, which its peers
+// ignore.
+func tweetRequestTo(d *Dvm, tweetID string) *nostr.Event {
+	evt := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindTweetRequest, Tags: nostr.Tags{{"p", d.GetPublicKey()}}, Content: tweetID}
+	evt.Sign(testKey())
+	return evt
+}
+
+func TestCrossCheck(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	agrees := startTestDvm(t, relay, WithScraper(viewScraper{"", 1}))
+	disagrees := startTestDvm(t, relay, WithScraper(viewScraper{"Running bitcoin", 1}))
+	offline, _ := nostr.GetPublicKey(testKey())
+	peers := []string{agrees.GetPublicKey(), disagrees.GetPublicKey(), offline}
+
+	if _, err := NewDvm(relay.URL(), testKey(), WithCrossCheck(CrossCheckConfig{Peers: []string{"npub1nope"}})); err == nil {
+		t.Error("expected an invalid peer to be rejected")
+	}
+
+	d := startTestDvm(t, relay, WithScraper(viewScraper{"", 5}),
+		WithCrossCheck(CrossCheckConfig{Peers: peers, Timeout: 2 * time.Second}))
+	req := tweetRequestTo(d, "20")
+	relay.Publish(req)
+	result := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+
+	want := map[string]string{agrees.GetPublicKey(): "agrees", disagrees.GetPublicKey(): "disagrees", offline: "unanswered"}
+	for _, tag := range result.Tags {
+		if len(tag) == 3 && tag[0] == "crosscheck" {
+			if want[tag[1]] != tag[2] {
+				t.Errorf("peer %s %s, want %q", tag[1][:8], tag[2], want[tag[1]])
+			}
+			delete(want, tag[1])
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("no verdict from %d peers: %v", len(want), result.Tags)
+	}
+
+	// Peers were asked not to cross-check in turn
+	for _, evt := range relay.Events() {
+		if evt.Kind == KindTweetRequest && evt.Tags.GetFirst([]string{"p", agrees.GetPublicKey()}) != nil {
+			if evt.Tags.GetFirst([]string{"param", "crosscheck", "false"}) == nil {
+				t.Errorf("peer request without crosscheck=false: %v", evt.Tags)
+			}
+		}
+	}
+
+	// Tweets that look fine are served without asking
+	fine := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithCrossCheck(CrossCheckConfig{Peers: peers}))
+	req = tweetRequestTo(fine, "21")
+	relay.Publish(req)
+	if result := awaitResponse(t, relay, fine.GetPublicKey(), req.ID); result.Tags.GetFirst([]string{"crosscheck"}) != nil {
+		t.Errorf("unsuspicious tweet was cross-checked: %v", result.Tags)
+	}
+}
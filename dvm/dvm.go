@@ -5,13 +5,18 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"regexp"
 	"sync"
 	"time"
 
 	"github.com/imperatrona/twitter-scraper"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip13"
 )
 
 // generatePrivateKey creates a random 32-byte hex string for ephemeral usage.
@@ -23,14 +28,128 @@ func generatePrivateKey() (string, error) {
 	return hex.EncodeToString(sk), nil
 }
 
-// Dvm listens for kind=42069 events containing a tweet ID, then responds with tweet data.
+// tweetIDPattern matches the numeric IDs the scraper accepts.
+var tweetIDPattern = regexp.MustCompile(`^\d{1,20}$`)
+
+// Dvm listens for job requests (kind=42069 events containing a tweet ID, and
+// the other kinds in jobs.go), then responds with the job's result.
 type Dvm struct {
-	sk      string
-	pk      string
-	relay   *nostr.Relay
-	done    chan struct{}
-	scraper *twitterscraper.Scraper
-	sync.Once // For ensuring done channel is closed only once
+	sk       string
+	pk       string
+	pool     *relayPool
+	done     <-chan struct{} // Run's context's, closed as the DVM stops
+	scraper  TweetScraper
+	handlers map[int]JobHandler
+	// paramSchemas validates requests' params, by kind
+	paramSchemas map[int]*JSONSchema
+	chaos        *chaos
+	store        *Store
+
+	extraRelays   []string
+	searchRelays  []string
+	relaysChanged chan struct{} // signalled by RemoveRelay
+	// subscriptionLost is signalled when a relay refuses or ends the
+	// request subscription; see handleNotice and watchSubscription
+	subscriptionLost chan subscriptionLoss
+
+	quotaCfg        QuotaConfig
+	extraIdentities []Identity
+	identities      []*identity // identities[0] is the primary identity
+
+	queueCfg   QueueConfig
+	queue      chan *nostr.Event
+	publishCfg PublishConfig
+	logs       *logSampler // nil logs every line
+	scrub      *Scrubber   // nil logs requesters and their requests as they are
+	clockSkew  time.Duration
+	// A subscription without EOSE after this is taken to have been refused
+	eoseTimeout time.Duration
+	// Requests older than this at processing time are skipped; 0 disables
+	maxRequestAge time.Duration
+	// Requests dated outside this when they arrive are rejected
+	timestamps TimestampWindow
+	// replayWindow is how long results are replayed to duplicate requests
+	replayWindow time.Duration
+	seen         *seenStore
+	templates    *resultTemplates
+
+	selfTest *SelfTestConfig
+	readyMu  sync.Mutex
+	notReady error // nil once ready; see Ready
+	outbox   *outbox
+	cache    *resultCache
+
+	archiveCfg *ArchiveConfig
+	archive    *archiver
+
+	alertCfg *AlertConfig
+	alerts   *alerter
+	watchdog *watchdog
+
+	minPoW int // NIP-13 difficulty requests need; 0 for none
+
+	abuseCfg *AbuseConfig
+	abuse    *abuseTracker // nil unless abusive requesters are denied
+
+	wotCfg *WoTConfig
+	wot    *webOfTrust // nil unless requesters are limited to a web of trust
+
+	listsCfg *ListSyncConfig
+	lists    *listSync // nil unless the allow/deny lists are synced from NIP-51 lists
+
+	policyCfg *PolicyConfig
+	policy    *contentPolicy
+
+	templateCfg    *TemplateConfig
+	tweetTemplates tweetTemplates // nil renders with the defaults
+
+	crossCheck *CrossCheckConfig
+
+	relayLookup *RelayLookupConfig
+
+	challenge *ChallengeConfig // nil runs every request as it comes
+
+	profile *ProfileConfig // nil leaves the identities' profiles alone
+
+	hooks hooks
+
+	middleware []insertedMiddleware
+	pipeline   JobFunc // the built-in stages with the middleware inserted
+
+	collisionCfg *CollisionConfig
+	collisions   *collisionDetector // nil unless watching for another instance with the same key
+
+	attestFetches bool // results carry a FetchAttestation
+
+	localCfg *LocalAPIConfig
+	local    *localAPI // nil unless enabled
+
+	uploadCfg    *UploadConfig
+	uploader     uploader
+	offloadBytes int // results over this size are uploaded instead
+	chunkBytes   int // or else split into chunks of this size
+	resultLimit  ResultLimit
+
+	resultMode ResultMode
+
+	mirrorSK string // signs content republished on Twitter users' behalf
+	mirrorPK string
+
+	payments         *PaymentConfig
+	invoicer         invoicer // if paid by invoice rather than zap
+	refunder         refunder // if failed prepaid jobs are refunded
+	pricing          PricingConfig
+	userArchivePrice int64
+
+	translator Translator
+	llmCfg     *LLMConfig
+	llm        *llmClient
+	analyzer   Analyzer
+	ocr        OCREngine
+
+	auditEnabled     bool
+	auditAnchorEvery time.Duration
+	audit            *auditLog
 }
 
 // GetPublicKey returns the DVM's public key
@@ -38,301 +157,934 @@ func (d *Dvm) GetPublicKey() string {
 	return d.pk
 }
 
-// NewDvm creates a new DVM instance connected to the specified relay.
-// Private key must be provided as a 64-character hex string.
-func NewDvm(relayURL string, privateKey string) (*Dvm, error) {
+// NewDvm creates a new DVM instance connected to the specified relay, plus
+// any added with WithRelays. Private key must be provided as a 64-character
+// hex string.
+func NewDvm(relayURL string, privateKey string, opts ...Option) (*Dvm, error) {
 	if privateKey == "" {
 		return nil, fmt.Errorf("private key is required")
 	}
-	
+
 	// Validate private key format (should be 64 hex chars)
 	if len(privateKey) != 64 {
 		return nil, fmt.Errorf("invalid private key: must be 64 hex characters")
 	}
-	
+
 	pk, err := nostr.GetPublicKey(privateKey)
 	if err != nil {
 		return nil, fmt.Errorf("invalid private key: %w", err)
 	}
 
-	relay, err := nostr.RelayConnect(context.Background(), relayURL)
+	d := &Dvm{
+		sk:            privateKey,
+		pk:            pk,
+		relaysChanged: make(chan struct{}, 1),
+		clockSkew:     defaultClockSkew,
+		eoseTimeout:   defaultEOSETimeout,
+		templates:     newResultTemplates(),
+		notReady:      errNotStarted,
+
+		subscriptionLost: make(chan subscriptionLoss, 1),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	switch d.resultMode {
+	case "":
+		d.resultMode = ResultsAsJobResults
+	case ResultsAsJobResults, ResultsAsDMs:
+	case ResultsAsNotes:
+		log.Printf("Warning: publishing results as kind-1 notes is deprecated and will be removed; " +
+			"clients should read job results (request kind + 1000) instead")
+	default:
+		return nil, fmt.Errorf("unknown result mode %q", d.resultMode)
+	}
+
+	d.queueCfg = d.queueCfg.withDefaults()
+	switch d.queueCfg.Overflow {
+	case OverflowShed, OverflowBlock:
+	default:
+		return nil, fmt.Errorf("unknown overflow policy %q", d.queueCfg.Overflow)
+	}
+	d.queue = make(chan *nostr.Event, d.queueCfg.Limit)
+
+	if d.clockSkew < 0 {
+		return nil, fmt.Errorf("clock skew tolerance must not be negative")
+	}
+	if d.maxRequestAge < 0 {
+		return nil, fmt.Errorf("maximum request age must not be negative")
+	}
+	if d.eoseTimeout <= 0 {
+		return nil, fmt.Errorf("EOSE timeout must be positive")
+	}
+	d.timestamps = d.timestamps.withDefaults()
+	if d.selfTest != nil {
+		*d.selfTest = d.selfTest.withDefaults()
+	}
+	if d.watchdog != nil {
+		d.watchdog.cfg = d.watchdog.cfg.withDefaults()
+	}
+	if d.minPoW < 0 || d.minPoW > maxPoW {
+		return nil, fmt.Errorf("proof of work difficulty must be between 0 and %d bits", maxPoW)
+	}
+	if d.wotCfg != nil {
+		if d.wot, err = newWebOfTrust(*d.wotCfg); err != nil {
+			return nil, err
+		}
+	}
+	if d.listsCfg != nil {
+		if d.lists, err = newListSync(*d.listsCfg); err != nil {
+			return nil, err
+		}
+	}
+	if d.collisionCfg != nil {
+		if d.collisions, err = newCollisionDetector(*d.collisionCfg); err != nil {
+			return nil, err
+		}
+	}
+	d.outbox = newOutbox(d.publishCfg)
+
+	// Initialize the scraper unless one was supplied
+	if d.scraper == nil {
+		d.scraper = twitterscraper.New()
+	}
+
+	primary, err := newIdentity(primaryIdentity, privateKey, d.quotaCfg, d.store)
 	if err != nil {
 		return nil, err
 	}
+	d.identities = []*identity{primary}
+	for _, extra := range d.extraIdentities {
+		id, err := newIdentity(extra.Name, extra.PrivateKey, extra.Quota, d.store)
+		if err != nil {
+			return nil, err
+		}
+		d.identities = append(d.identities, id)
+	}
+
+	if d.localCfg != nil {
+		if d.local, err = newLocalAPI(d, *d.localCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.crossCheck != nil {
+		if err := d.validateCrossCheck(); err != nil {
+			return nil, err
+		}
+	}
+	if d.relayLookup != nil {
+		*d.relayLookup = d.relayLookup.withDefaults()
+		if err := d.validateRelayLookup(); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.challenge != nil {
+		*d.challenge = d.challenge.withDefaults()
+	}
+	if d.pipeline, err = d.buildPipeline(); err != nil {
+		return nil, err
+	}
+	if d.profile != nil {
+		*d.profile = d.profile.withDefaults()
+		if err := d.profile.validate(); err != nil {
+			return nil, err
+		}
+	}
 
-	// Initialize the scraper
-	scraper := twitterscraper.New()
+	if d.archiveCfg != nil {
+		if d.archive, err = newArchiver(*d.archiveCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.alertCfg != nil {
+		if d.alerts, err = newAlerter(*d.alertCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.abuseCfg != nil {
+		if d.abuse, err = loadAbuseTracker(*d.abuseCfg, d.store); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.uploadCfg != nil {
+		if d.uploader, err = newUploader(*d.uploadCfg, d.sk); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.chunkBytes < 0 {
+		return nil, fmt.Errorf("result chunk size must not be negative")
+	}
+	if d.offloadBytes > 0 {
+		if d.uploader == nil {
+			return nil, fmt.Errorf("offloading results requires uploads")
+		}
+		if d.resultMode == ResultsAsDMs {
+			// Uploads are public, and would leak what the DMs hide
+			return nil, fmt.Errorf("results sent as DMs can't be offloaded")
+		}
+	}
+
+	d.resultLimit = d.resultLimit.withDefaults()
+	switch d.resultLimit.Policy {
+	case OversizeFail, OversizeTruncate:
+	case OversizeOffload:
+		if d.uploader == nil {
+			return nil, fmt.Errorf("offloading oversized results requires uploads")
+		}
+		if d.resultMode == ResultsAsDMs {
+			return nil, fmt.Errorf("results sent as DMs can't be offloaded")
+		}
+	default:
+		return nil, fmt.Errorf("unknown oversize policy %q", d.resultLimit.Policy)
+	}
+
+	if d.replayWindow > 0 && d.cache == nil {
+		return nil, fmt.Errorf("replaying results requires the result cache")
+	}
 
-	return &Dvm{
-		sk:      privateKey,
-		pk:      pk,
-		relay:   relay,
-		done:    make(chan struct{}),
-		scraper: scraper,
-	}, nil
+	if d.mirrorSK != "" {
+		if len(d.mirrorSK) != 64 {
+			return nil, fmt.Errorf("invalid mirror key: must be 64 hex characters")
+		}
+		if d.mirrorPK, err = nostr.GetPublicKey(d.mirrorSK); err != nil {
+			return nil, fmt.Errorf("invalid mirror key: %w", err)
+		}
+	}
+
+	if d.payments != nil {
+		if d.payments.CoreLightning != nil {
+			if d.invoicer, err = newCLNNode(*d.payments.CoreLightning); err != nil {
+				return nil, err
+			}
+		} else if d.payments.NWC != "" {
+			if d.invoicer, err = parseNWC(d.payments.NWC); err != nil {
+				return nil, err
+			}
+		} else if d.payments.LightningAddress != "" {
+			if d.invoicer, err = parseLightningAddress(d.payments.LightningAddress); err != nil {
+				return nil, err
+			}
+		}
+		if d.invoicer == nil || d.payments.ZapperPubKey != "" {
+			if _, err := hex.DecodeString(d.payments.ZapperPubKey); err != nil || len(d.payments.ZapperPubKey) != 64 {
+				return nil, fmt.Errorf("payments require the zapper's hex pubkey, an NWC URI, a lightning address or a Core Lightning node")
+			}
+		}
+		if d.payments.PayeePubKey != "" {
+			if _, err := hex.DecodeString(d.payments.PayeePubKey); err != nil || len(d.payments.PayeePubKey) != 64 {
+				return nil, fmt.Errorf("invalid payee pubkey: must be 64 hex characters")
+			}
+		}
+		if d.payments.Refunds {
+			var ok bool
+			if d.payments.RefundNWC != "" {
+				if d.refunder, err = parseNWC(d.payments.RefundNWC); err != nil {
+					return nil, fmt.Errorf("refund wallet: %w", err)
+				}
+			} else if d.refunder, ok = d.invoicer.(refunder); !ok {
+				return nil, fmt.Errorf("refunds require an NWC wallet or Core Lightning node")
+			}
+		}
+		cfg := d.payments.withDefaults()
+		d.payments = &cfg
+	}
+	if d.userArchivePrice > 0 {
+		if _, ok := d.pricing[KindUserArchiveRequest]; !ok {
+			if d.pricing == nil {
+				d.pricing = make(PricingConfig)
+			}
+			d.pricing[KindUserArchiveRequest] = KindPrice{PerUnitMsats: d.userArchivePrice}
+		}
+	}
+	for kind, price := range d.pricing {
+		if price.Msats < 0 || price.PerUnitMsats < 0 {
+			return nil, fmt.Errorf("price of kind %d must not be negative", kind)
+		}
+		if price.charges() && d.payments == nil {
+			return nil, fmt.Errorf("pricing kind %d requires payments", kind)
+		}
+	}
+
+	if d.policyCfg != nil {
+		if d.policy, err = newContentPolicy(*d.policyCfg); err != nil {
+			return nil, err
+		}
+	}
+	if d.templateCfg != nil {
+		if d.tweetTemplates, err = newTweetTemplates(*d.templateCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.llmCfg != nil {
+		if d.llm, err = newLLMClient(*d.llmCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Built-in job kinds, unless replaced by WithHandler. Some depend on the
+	// providers configured above.
+	if d.handlers == nil {
+		d.handlers = make(map[int]JobHandler)
+	}
+	for kind, handler := range d.defaultHandlers() {
+		if _, ok := d.handlers[kind]; !ok {
+			d.handlers[kind] = handler
+		}
+	}
+
+	d.paramSchemas = make(map[int]*JSONSchema)
+	for _, c := range d.capabilities().Kinds {
+		if c.ParamsSchema != nil {
+			d.paramSchemas[c.Kind] = c.ParamsSchema
+		}
+	}
+
+	if d.auditEnabled {
+		if d.store == nil {
+			return nil, fmt.Errorf("audit log requires a store")
+		}
+		if d.audit, err = openAuditLog(d.store); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.seen, err = loadSeen(d.store, time.Now().Add(-d.clockSkew)); err != nil {
+		return nil, err
+	}
+
+	relayURLs := append([]string{relayURL}, d.extraRelays...)
+	if d.pool, err = newRelayPool(context.Background(), relayURLs, d.relayWatcher()); err != nil {
+		return nil, err
+	}
+
+	return d, nil
 }
 
-// Run subscribes to job requests and responds with tweet data.
-func (d *Dvm) Run() error {
-	ctx, cancel := context.WithCancel(context.Background())
-	
+// subscribe opens a subscription for job requests created at or after
+// since, less the clock skew tolerance, on the healthiest relay in the pool.
+func (d *Dvm) subscribe(ctx context.Context, since time.Time) (*nostr.Subscription, error) {
+	relay, err := d.pool.best(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRelayClosed, err)
+	}
+	log.Printf("DVM subscribing on %s", relayURL(relay))
+	ts := nostr.Timestamp(since.Add(-d.clockSkew).Unix())
+	filters := nostr.Filters{
+		nostr.Filter{
+			Kinds: d.handlerKinds(),
+			Since: &ts,
+		},
+	}
+	if d.alerts != nil {
+		// Commands DMed by the admin; see handleAdminCommand
+		filters = append(filters, nostr.Filter{
+			Kinds:   []int{4},
+			Authors: []string{d.alerts.cfg.AdminPubKey},
+			Tags:    nostr.TagMap{"p": {d.identities[0].pk}},
+			Since:   &ts,
+		})
+	}
+	sub, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("%w on %s: %v", ErrSubscriptionFailed, relayURL(relay), err)
+	}
+	return sub, nil
+}
+
+// resubscribe re-establishes the request subscription, failing over to
+// another relay if the previous one is unhealthy, and retrying until it
+// succeeds. It returns nil once the DVM is stopped.
+func (d *Dvm) resubscribe(ctx context.Context, since time.Time) *nostr.Subscription {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if sub, err := d.subscribe(ctx, since); err != nil {
+			log.Printf("DVM resubscribe failed (attempt %d): %v", attempt, err)
+			if time.Since(start) > relaysDownGrace {
+				d.alerts.raise(alertRelaysDown, fmt.Sprintf("No relay reachable for %v: %v",
+					time.Since(start).Round(time.Second), err))
+			}
+		} else {
+			return sub
+		}
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Errors Run returns, wrapped with the underlying error.
+var (
+	// ErrRelayClosed is returned when none of the DVM's relays can be
+	// connected to.
+	ErrRelayClosed = errors.New("relay closed")
+	// ErrSubscriptionFailed is returned when a relay refuses the
+	// subscription for job requests.
+	ErrSubscriptionFailed = errors.New("subscription failed")
+)
+
+// Run subscribes to job requests and responds with tweet data until ctx is
+// done, then finishes the jobs in flight, turns away the ones queued and
+// returns nil. A DVM runs once.
+func (d *Dvm) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.done = ctx.Done()
+	defer d.pool.close()
+
+	// Publishers outlive the workers, so the last results still go out
+	d.outbox.start(d)
+	defer d.outbox.stop()
+
 	// Start a heartbeat to keep the connection alive
 	go d.runHeartbeat(ctx)
 
-	log.Printf("DVM starting subscription for tweet requests (kind=42069)")
-	// Subscribe to all events of kind=42069
-	since := nostr.Timestamp(time.Now().Add(-time.Second).Unix())
-	sub, err := d.relay.Subscribe(ctx, nostr.Filters{
-		nostr.Filter{
-			Kinds: []int{42069},
-			Since: &since,
-		},
-	})
+	if d.local != nil {
+		d.local.start()
+		defer d.local.stop()
+	}
+
+	if d.audit != nil && d.auditAnchorEvery > 0 {
+		go d.runAuditAnchors(ctx)
+	}
+
+	if d.alerts != nil {
+		go d.runAlerts(ctx)
+	}
+
+	if d.watchdog != nil {
+		go d.runWatchdog(ctx)
+	}
+
+	if d.wot != nil {
+		go d.runWoT(ctx)
+	}
+
+	if d.lists != nil {
+		go d.runListSync(ctx)
+	}
+
+	if d.collisions != nil {
+		go d.runCollisionCheck(ctx)
+	}
+
+	if d.profile != nil {
+		go d.runProfile(ctx)
+	}
+
+	log.Printf("DVM starting subscription for job requests (kinds=%v)", d.handlerKinds())
+	// Subscribe to all job request kinds. since advances with each request
+	// so a resubscribe replays anything that was in flight when the relay
+	// dropped; subscribe reaches back over the clock skew tolerance, and
+	// d.seen drops the replays.
+	since := time.Now()
+	defer d.seen.flush()
+	sub, err := d.subscribe(ctx, since)
 	if err != nil {
 		log.Printf("DVM subscription error: %v", err)
 		return err
 	}
 
 	log.Printf("DVM subscription active - listening for events")
+	defer d.setReady(errNotStarted)
+	go d.announce(ctx)
+	events := d.readAhead(sub)
+	stopWatching := d.watchSubscription(sub)
+	defer func() { stopWatching() }()
+
+	// Workers finish their current job before Run returns
+	workers := d.startWorkers()
+	defer workers.Wait()
+	defer d.rejectQueued()
 
 	defer func() {
 		log.Printf("DVM shutting down subscription")
-		cancel()
-		sub.Unsub()
+		if sub != nil {
+			sub.Unsub()
+		}
 	}()
 
 	for {
+		// events closes once the subscription ends, including when the
+		// connection drops, after what was read ahead is handled
+		var evt *nostr.Event
+		ok, local := true, false
 		select {
-		case evt := <-sub.Events:
-			if evt.Kind == 42069 {
-				log.Printf("DVM received job request: id=%s from=%s tweet_id=%s", 
-					evt.ID[:8], evt.PubKey[:8], evt.Content)
-				
-				// Get the tweet data
-				log.Printf("Fetching tweet data for ID: %s", evt.Content)
-				startTime := time.Now()
-				tweet, err := d.scraper.GetTweet(evt.Content)
-				if err != nil {
-					log.Printf("Error getting tweet %s: %v", evt.Content, err)
-					continue
-				}
-				log.Printf("Successfully fetched tweet in %v: @%s: %s", 
-					time.Since(startTime), tweet.Username, tweet.Text)
-
-				// Convert tweet to JSON
-				tweetJSON, err := json.Marshal(tweet)
-				if err != nil {
-					log.Printf("Error marshaling tweet: %v", err)
-					continue
-				}
-
-				// Build response event with tweet data
-				log.Printf("Publishing response for request %s", evt.ID[:8])
-				resp := nostr.Event{
-					PubKey:    d.pk,
-					CreatedAt: nostr.Timestamp(time.Now().Unix()),
-					Kind:      1,
-					Tags: nostr.Tags{
-						{"e", evt.ID},     // Reference the request event
-						{"p", evt.PubKey}, // Reference the requester's pubkey
-					},
-					Content: string(tweetJSON),
-				}
-				if err := resp.Sign(d.sk); err != nil {
-					log.Printf("DVM sign error: %v", err)
-					continue
-				}
-				
-				publishStart := time.Now()
-				log.Printf("Publishing tweet data response to relay...")
-				
-				// Try to publish with reconnection logic
-				maxRetries := 3
-				for attempt := 0; attempt < maxRetries; attempt++ {
-					// Check if connection is closed and try to reconnect
-					if d.relay.ConnectionError != nil {
-						log.Printf("Relay connection error detected, reconnecting... (attempt %d/%d)", attempt+1, maxRetries)
-						
-						// Create a new relay connection
-						newRelay, err := nostr.RelayConnect(context.Background(), d.relay.URL)
-						if err != nil {
-							log.Printf("Failed to reconnect to relay: %v", err)
-							time.Sleep(500 * time.Millisecond)
-							continue
-						}
-						
-						// Update the relay reference
-						d.relay = newRelay
-						log.Printf("Successfully reconnected to relay")
-					}
-					
-					// Attempt to publish
-					if status, err := d.relay.Publish(context.Background(), resp); err != nil {
-						log.Printf("DVM publish error (attempt %d/%d): %v", attempt+1, maxRetries, err)
-						time.Sleep(500 * time.Millisecond)
-					} else {
-						log.Printf("Successfully published response in %v (status: %v)", time.Since(publishStart), status)
-						log.Printf("Verification info - Event ID: %s", resp.ID)
-						log.Printf("To verify with nak: nak event -r wss://relay.nostr.net %s", resp.ID)
-						break
-					}
-				}
+		case evt, ok = <-events:
+		case evt = <-d.local.incoming():
+			local = true
+		case <-d.relaysChanged:
+			if d.pool.has(relayURL(sub.Relay)) {
+				continue
 			}
-		case <-d.done:
+			// The subscription's relay was removed; move to another
+			ok = false
+		case loss := <-d.subscriptionLost:
+			if !loss.of(sub) {
+				continue
+			}
+			log.Printf("Relay %s ended the subscription (%s), resubscribing in %v", loss.url, loss.reason, loss.wait)
+			if loss.demote {
+				d.pool.demote(loss.url)
+			}
+			select {
+			case <-time.After(loss.wait):
+			case <-ctx.Done():
+				log.Printf("DVM received shutdown signal")
+				return nil
+			}
+			ok = false
+		case <-ctx.Done():
 			log.Printf("DVM received shutdown signal")
 			return nil
 		}
+
+		if !ok {
+			// The relay connection dropped and took the subscription with
+			// it, or the relay ended it
+			log.Printf("DVM subscription closed, reconnecting...")
+			stopWatching()
+			sub.Unsub()
+			if sub = d.resubscribe(ctx, since); sub == nil {
+				log.Printf("DVM received shutdown signal")
+				return nil
+			}
+			events = d.readAhead(sub)
+			stopWatching = d.watchSubscription(sub)
+			log.Printf("DVM subscription re-established")
+			continue
+		}
+		if d.isAdminCommand(evt) {
+			if d.seen.add(evt.ID, evt.CreatedAt.Time()) {
+				go d.handleAdminCommand(evt)
+			}
+			continue
+		}
+		if _, ok := d.handlers[evt.Kind]; !ok {
+			continue
+		}
+		if d.abuse.isDenied(evt.PubKey) || d.lists.denies(evt.PubKey) {
+			metricJobsDenied.Add(1)
+			if local {
+				d.local.refuse(evt, "blocked: requester is denied")
+			}
+			continue
+		}
+		if !d.seen.add(evt.ID, evt.CreatedAt.Time()) {
+			if local {
+				d.local.refuse(evt, "duplicate: already submitted")
+			}
+			continue
+		}
+		if local {
+			d.local.accept(evt)
+			d.enqueue(evt)
+			continue
+		}
+		// A request from a clock running ahead mustn't move since past
+		// requests still to come from clocks running behind
+		if t := evt.CreatedAt.Time(); t.After(since) {
+			since = t
+			if now := time.Now(); since.After(now) {
+				since = now
+			}
+			d.seen.advance(since.Add(-d.clockSkew))
+		}
+		d.enqueue(evt)
+	}
+}
+
+// handleRequest runs the request through the DVM's pipeline: the job
+// handler for its kind, with the stages around it; see Middleware.
+func (d *Dvm) handleRequest(evt *nostr.Event) {
+	// The job's responses are all out by the time this returns, unless
+	// it keeps publishing follow-ups
+	var job *Job
+	defer func() {
+		if job == nil || job.receipt == nil || !job.receipt.ongoing {
+			d.local.forget(evt.ID)
+		}
+	}()
+	d.chaos.maybeCorrupt(evt)
+
+	d.logs.printf("DVM received job request: id=%s kind=%d from=%s input=%s",
+		evt.ID[:8], evt.Kind, d.scrub.logPubKey(evt.PubKey), d.scrub.logContent(evt.Content))
+
+	handler, ok := d.handlers[evt.Kind]
+	if !ok {
+		log.Printf("Ignoring request %s: no handler for kind %d", evt.ID[:8], evt.Kind)
+		return
+	}
+	if err := checkRequest(evt); err != nil {
+		log.Printf("Ignoring malformed request %s: %v", evt.ID[:8], err)
+		d.strike(evt, strikePayload, "malformed request: "+err.Error())
+		return
+	}
+	id := d.route(evt)
+	if id == nil {
+		log.Printf("Ignoring request %s: addressed to a different DVM", evt.ID[:8])
+		return
+	}
+
+	job = &Job{Request: evt, id: id, handler: handler}
+	if err := d.pipeline(context.Background(), job); err != nil {
+		d.failJob(job, err)
+	}
+}
+
+// publishResult queues a result event for req, signed by id, in the DVM's
+// result mode. Results too big for one event are offloaded or chunked if
+// the DVM is configured to; the last event queued is returned. The error
+// covers only preparing the events: sent, if not nil, is called with the
+// last event and the outcome of publishing it.
+func (d *Dvm) publishResult(id *identity, req *nostr.Event, content []byte, tags nostr.Tags, sent func(*nostr.Event, error)) (*nostr.Event, error) {
+	compact, offloaded, err := d.offloadResult(content)
+	if err != nil {
+		return nil, err
+	}
+	if offloaded != nil {
+		return d.publishResultEvent(id, req, compact, append(tags, offloaded), sent)
+	}
+	if d.chunkBytes > 0 && len(content) > d.chunkBytes {
+		return d.publishChunks(id, req, content, tags, sent)
+	}
+	return d.publishResultEvent(id, req, content, tags, sent)
+}
+
+// publishResultEvent queues content as a single result event.
+func (d *Dvm) publishResultEvent(id *identity, req *nostr.Event, content []byte, tags nostr.Tags, sent func(*nostr.Event, error)) (*nostr.Event, error) {
+	build := func(content []byte, tags nostr.Tags) (nostr.Event, error) {
+		return d.resultEvent(id, req, content, tags)
+	}
+	unsigned, err := build(content, tags)
+	if err != nil {
+		return nil, err
+	}
+	if eventSize(&unsigned) > d.resultLimit.MaxBytes && d.resultLimit.Policy == OversizeOffload &&
+		tagValue(tags, "offloaded") == "" {
+		compact, offloaded, err := d.uploadResult(content)
+		if err != nil {
+			return nil, err
+		}
+		return d.publishResultEvent(id, req, compact, append(tags, offloaded), sent)
+	}
+	fitted, err := d.fitResult(unsigned, content, tags, build)
+	if err != nil {
+		return nil, err
+	}
+	resp := *fitted
+	if err := d.signResult(id, &resp); err != nil {
+		return nil, fmt.Errorf("sign error: %w", err)
+	}
+	d.publishAsync(resp, func(err error) {
+		if err != nil {
+			log.Printf("Giving up on result %s for request %s: %v", resp.ID[:8], req.ID[:8], err)
+		}
+		if sent != nil {
+			sent(&resp, err)
+		}
+	})
+	return &resp, nil
+}
+
+// resultEvent builds the unsigned result event for content in the DVM's
+// result mode.
+func (d *Dvm) resultEvent(id *identity, req *nostr.Event, content []byte, tags nostr.Tags) (nostr.Event, error) {
+	resp := nostr.Event{
+		PubKey:    id.pk,
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      ResultKind(req.Kind),
+		Tags: append(nostr.Tags{
+			{"e", req.ID},     // Reference the request event
+			{"p", req.PubKey}, // Reference the requester's pubkey
+		}, tags...),
+		Content: string(content),
+	}
+	switch d.resultMode {
+	case ResultsAsJobResults:
+		if raw, err := json.Marshal(req); err == nil {
+			resp.Tags = append(resp.Tags, nostr.Tag{"request", string(raw)})
+		}
+	case ResultsAsDMs:
+		secret, err := nip04.ComputeSharedSecret(req.PubKey, id.sk)
+		if err != nil {
+			return resp, err
+		}
+		if resp.Content, err = nip04.Encrypt(resp.Content, secret); err != nil {
+			return resp, err
+		}
+		resp.Kind = 4
+	case ResultsAsNotes:
+		resp.Kind = 1
+	}
+	return resp, nil
+}
+
+// fetchTweetJSON returns the serialized tweet, from the cache when possible.
+func (d *Dvm) fetchTweetJSON(tweetID string) ([]byte, error) {
+	if cached, ok := d.cache.get(tweetID); ok {
+		d.logs.printf("Serving tweet %s from cache", d.scrub.logContent(tweetID))
+		return cached, nil
+	}
+	_, tweetJSON, err := d.scrapeTweet(tweetID)
+	return tweetJSON, err
+}
+
+// scrapeTweet fetches the tweet from Twitter, bypassing the cache but
+// refreshing it, and returns it with its serialized form.
+func (d *Dvm) scrapeTweet(tweetID string) (*twitterscraper.Tweet, []byte, error) {
+	d.logs.printf("Fetching tweet data for ID: %s", d.scrub.logContent(tweetID))
+	startTime := time.Now()
+	d.chaos.maybeSlowScrape()
+	tweet, err := d.scraper.GetTweet(tweetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.logs.printf("Successfully fetched tweet in %v: %s",
+		time.Since(startTime), d.scrub.logContent("@"+tweet.Username+": "+tweet.Text))
+
+	// Convert tweet to JSON
+	tweetJSON, err := json.Marshal(TweetResult{Tweet: tweet, Lang: detectLanguage(tweet.Text)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshaling tweet: %w", err)
+	}
+	d.cache.put(tweetID, tweetJSON)
+	go d.archive.archive(tweet, tweetJSON, startTime)
+	return tweet, tweetJSON, nil
+}
+
+// publishTo sends evt to a single relay, reconnecting first if needed, and
+// records the outcome in the relay's health.
+func (d *Dvm) publishTo(r *poolRelay, evt nostr.Event) error {
+	relay, err := r.connect(context.Background(), true)
+	if err != nil {
+		return err
 	}
+	d.chaos.maybeDisconnect(relay)
+
+	start := time.Now()
+	err = d.chaos.publishFault()
+	if err == nil {
+		_, err = relay.Publish(context.Background(), evt)
+	}
+	r.recordPublish(time.Since(start), err)
+	return err
 }
 
 // runHeartbeat sends periodic NIP-01 keepalive events to maintain the connection
 func (d *Dvm) runHeartbeat(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
-	
+
 	for {
 		select {
 		case <-ticker.C:
-			// Check if the connection is still alive
-			if d.relay.ConnectionError != nil {
-				log.Printf("Heartbeat detected closed connection, attempting to reconnect...")
-				newRelay, err := nostr.RelayConnect(ctx, d.relay.URL)
-				if err != nil {
-					log.Printf("Heartbeat reconnection failed: %v", err)
-					continue
-				}
-				d.relay = newRelay
-				log.Printf("Heartbeat successfully reconnected to relay")
-			} else {
-				// Send a simple NIP-01 event as a ping to keep the connection alive
-				ping := nostr.Event{
-					PubKey:    d.pk,
-					CreatedAt: nostr.Timestamp(time.Now().Unix()),
-					Kind:      1,
-					Tags:      nostr.Tags{{"client", "bandita-dvm-heartbeat"}},
-					Content:   "",
-				}
-				if err := ping.Sign(d.sk); err != nil {
-					log.Printf("Failed to sign heartbeat ping: %v", err)
-					continue
-				}
-				
-				// We don't need to actually send this event - just prepare it to be ready
-				// in case we need to test the connection in the future
-				log.Printf("Heartbeat check - connection still alive")
+			// Redial any relays that dropped and are due another try
+			d.pool.refresh(ctx)
+
+			// Send a simple NIP-01 event as a ping to keep the connection alive
+			ping := nostr.Event{
+				PubKey:    d.pk,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Kind:      1,
+				Tags:      nostr.Tags{{"client", "bandita-dvm-heartbeat"}},
+				Content:   "",
 			}
+			if err := ping.Sign(d.sk); err != nil {
+				log.Printf("Failed to sign heartbeat ping: %v", err)
+				continue
+			}
+
+			// We don't need to actually send this event - just prepare it to be ready
+			// in case we need to test the connection in the future
+			log.Printf("Heartbeat check - connection still alive")
 		case <-ctx.Done():
 			log.Printf("Heartbeat routine stopped")
 			return
-		case <-d.done:
-			log.Printf("Heartbeat received shutdown signal")
-			return
 		}
 	}
 }
 
-// Stop signals the DVM to shutdown.
-func (d *Dvm) Stop() {
-	d.Do(func() {
-		close(d.done)
-	})
-}
-
 // DvmClient publishes a tweet ID and waits for the tweet data response.
 type DvmClient struct {
 	sk    string
 	pk    string
 	relay *nostr.Relay
+	owned bool         // whether the client holds relay in relayConns
+	http  *http.Client // for offloaded results
+
+	ratings   bool // publish reputation labels for DVMs used
+	pow       int  // NIP-13 difficulty to mine requests to
+	ephemeral bool // a fresh key and connection for each request
 }
 
 // NewDvmClient creates a new client for interacting with the DVM.
-func NewDvmClient(relayURL string) (*DvmClient, error) {
+func NewDvmClient(relayURL string, opts ...ClientOption) (*DvmClient, error) {
 	sk, err := generatePrivateKey()
 	if err != nil {
 		return nil, err
 	}
 	pk, _ := nostr.GetPublicKey(sk)
 
-	relay, err := nostr.RelayConnect(context.Background(), relayURL)
+	relay, err := relayConns.acquire(context.Background(), relayURL)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DvmClient{
+	c := &DvmClient{
 		sk:    sk,
 		pk:    pk,
 		relay: relay,
-	}, nil
+		owned: true,
+		http:  &http.Client{Timeout: 2 * time.Minute},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.sk != sk {
+		if c.pk, err = nostr.GetPublicKey(c.sk); err != nil || len(c.sk) != 64 {
+			c.Close()
+			return nil, fmt.Errorf("invalid client key: must be 64 hex characters")
+		}
+	}
+	return c, nil
+}
+
+// Close releases the client's relay connection, which is closed unless
+// other clients or DVMs in the process share it.
+func (c *DvmClient) Close() {
+	if c.owned {
+		relayConns.release(relayURL(c.relay))
+		c.owned = false
+	}
 }
 
 // RequestTweet publishes a job event with a tweet ID and waits for the response.
 func (c *DvmClient) RequestTweet(ctx context.Context, dvmPubKey string, tweetID string) (*twitterscraper.Tweet, error) {
-	log.Printf("Creating tweet request for ID: %s from DVM: %s", tweetID, dvmPubKey[:8])
-	
+	content, err := c.Request(ctx, dvmPubKey, KindTweetRequest, tweetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tweet twitterscraper.Tweet
+	if err := json.Unmarshal([]byte(content), &tweet); err != nil {
+		return nil, fmt.Errorf("error unmarshaling tweet data: %w", err)
+	}
+	// Check if the tweet data has basic fields to confirm it's valid
+	if tweet.Text == "" {
+		return nil, fmt.Errorf("DVM returned a tweet with no text")
+	}
+	log.Printf("Successfully parsed tweet from @%s: %s", tweet.Username, tweet.Text)
+	return &tweet, nil
+}
+
+// RequestPost asks the DVM to fetch a post by URL with a handler that returns
+// a normalized Post, such as KindMastodonRequest.
+func (c *DvmClient) RequestPost(ctx context.Context, dvmPubKey string, kind int, postURL string) (*Post, error) {
+	content, err := c.Request(ctx, dvmPubKey, kind, postURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var post Post
+	if err := json.Unmarshal([]byte(content), &post); err != nil {
+		return nil, fmt.Errorf("error unmarshaling post: %w", err)
+	}
+	return &post, nil
+}
+
+// Request publishes a job request of the given kind and waits for the
+// DVM's result, returning its content.
+func (c *DvmClient) Request(ctx context.Context, dvmPubKey string, kind int, input string) (string, error) {
+	content, _, err := c.request(ctx, dvmPubKey, kind, input, requestOptions{})
+	return content, err
+}
+
+// request is Request, also returning the result event that completed the
+// result; see requestOptions.
+func (c *DvmClient) request(ctx context.Context, dvmPubKey string, kind int, input string, opts requestOptions) (content string, result *nostr.Event, err error) {
+	log.Printf("Creating kind %d request for %s from DVM: %s", kind, input, dvmPubKey[:8])
+	who, err := c.requester(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer who.close()
+
 	// Create the job request event first
 	evt := nostr.Event{
-		PubKey:    c.pk,
+		PubKey:    who.pk,
 		CreatedAt: nostr.Timestamp(time.Now().Unix()),
-		Kind:      42069,
-		Tags:      nostr.Tags{},
-		Content:   tweetID,
+		Kind:      kind,
+		Tags:      append(nostr.Tags{{"p", dvmPubKey}}, opts.tags...), // Address the request to this DVM
+		Content:   input,
+	}
+	if c.pow > 0 {
+		if _, err := nip13.Generate(&evt, c.pow, powGenerateTimeout); err != nil {
+			return "", nil, err
+		}
 	}
-	if err := evt.Sign(c.sk); err != nil {
+	if err := evt.Sign(who.sk); err != nil {
 		log.Printf("Error signing request event: %v", err)
-		return nil, err
+		return "", nil, err
 	}
 	log.Printf("Created request event with ID: %s", evt.ID[:8])
 
 	// Subscribe to potential responses that reference our request
-	log.Printf("Setting up subscription for responses from DVM (client pubkey: %s, request ID: %s)", c.pk, evt.ID)
-	
+	log.Printf("Setting up subscription for responses from DVM (client pubkey: %s, request ID: %s)", who.pk, evt.ID)
+
 	// Go back 1 minute to ensure we don't miss anything
 	since := nostr.Timestamp(time.Now().Add(-1 * time.Minute).Unix())
-	
+
 	// First, set up a broader subscription to catch all responses from the DVM
-	sub, err := c.relay.Subscribe(ctx, nostr.Filters{
+	sub, err := who.relay.Subscribe(ctx, nostr.Filters{
 		nostr.Filter{
-			Kinds:   []int{1},
+			// Kinds 4 and 1 are results from DVMs in the DM and legacy note modes
+			Kinds:   []int{ResultKind(kind), 4, 1, KindJobFeedback},
 			Authors: []string{dvmPubKey}, // Only get responses from the DVM
-			Since: &since,
+			Since:   &since,
 		},
 	})
 	if err != nil {
 		log.Printf("Subscription error: %v", err)
-		return nil, err
+		return "", nil, err
 	}
 	defer sub.Unsub()
 	log.Printf("Subscription set up successfully")
 
 	// Now publish the request with retry logic
-	log.Printf("Publishing request for tweet ID: %s", tweetID)
+	log.Printf("Publishing request for: %s", input)
 	publishStart := time.Now()
-	
+
 	// Try to publish with reconnection logic
 	maxRetries := 3
 	var publishErr error
-	
+
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		// Check if connection is closed and try to reconnect
-		if c.relay.ConnectionError != nil {
+		if who.relay.ConnectionError != nil || !who.relay.IsConnected() {
 			log.Printf("Client relay connection error detected, reconnecting... (attempt %d/%d)", attempt+1, maxRetries)
-			
-			// Create a new relay connection
-			newRelay, err := nostr.RelayConnect(ctx, c.relay.URL)
-			if err != nil {
+
+			if _, err := who.redial(ctx); err != nil {
 				log.Printf("Client failed to reconnect to relay: %v", err)
 				time.Sleep(500 * time.Millisecond)
 				publishErr = err
 				continue
 			}
-			
-			// Update the relay reference
-			c.relay = newRelay
 			log.Printf("Client successfully reconnected to relay")
 		}
-		
+
 		// Attempt to publish
-		if _, err := c.relay.Publish(ctx, evt); err != nil {
+		if _, err := who.relay.Publish(ctx, evt); err != nil {
 			log.Printf("Error publishing request (attempt %d/%d): %v", attempt+1, maxRetries, err)
 			time.Sleep(500 * time.Millisecond)
 			publishErr = err
@@ -342,33 +1094,69 @@ func (c *DvmClient) RequestTweet(ctx context.Context, dvmPubKey string, tweetID
 			break
 		}
 	}
-	
+
 	if publishErr != nil {
 		log.Printf("Failed to publish request after %d attempts: %v", maxRetries, publishErr)
-		return nil, publishErr
+		return "", nil, publishErr
+	}
+	if c.ratings {
+		defer func() { c.rate(who, &evt, dvmPubKey, time.Since(publishStart), err) }()
 	}
 
 	deadline, ok := ctx.Deadline()
 	if ok {
-		log.Printf("Waiting for response from DVM (timeout: %v)...", 
+		log.Printf("Waiting for response from DVM (timeout: %v)...",
 			time.Until(deadline))
 	} else {
 		log.Printf("Waiting for response from DVM (no timeout set)...")
 	}
 
 	// Wait for a matching response
+	assembler := resultAssembler{client: c, sk: who.sk}
+	paged := pagedResult{client: c, sk: who.sk, emit: opts.page}
+	var final *nostr.Event // the final result, if it came before its pages
+	var finalContent string
 	for {
 		select {
-		case e := <-sub.Events:
+		case e, ok := <-sub.Events:
+			if !ok {
+				log.Printf("Subscription closed before a response arrived")
+				return "", nil, fmt.Errorf("subscription to %s closed before a response arrived", relayURL(who.relay))
+			}
+			if !verifyEvent(e) {
+				log.Printf("Ignoring event %s with a bad signature", e.ID)
+				continue
+			}
 			log.Printf("Received event kind=%d from=%s with ID: %s", e.Kind, e.PubKey[:8], e.ID[:8])
-			
+
 			// Debug: Print the tags to help troubleshoot
 			log.Printf("Event tags: %v", e.Tags)
-			
-			// Check if this is our response - either by tag or just as a kind 1 from the DVM
+
+			// Check if this is our response by its reference to our request
 			isOurResponse := false
-			
-			if e.Kind == 1 {
+
+			if e.Kind == KindJobFeedback && e.Tags.GetFirst([]string{"e", evt.ID}) != nil {
+				if err := feedbackError(e); err != nil {
+					log.Printf("DVM rejected request: %v", err)
+					return "", nil, err
+				}
+				if msats, bolt11, ok := paymentRequest(e); ok && opts.pay != nil {
+					if err := opts.pay(ctx, &evt, msats, bolt11); err != nil {
+						return "", nil, err
+					}
+				}
+				if resp := challengeResponse(e, &evt); resp != nil {
+					if err := resp.Sign(who.sk); err != nil {
+						return "", nil, err
+					}
+					if _, err := who.relay.Publish(ctx, *resp); err != nil {
+						return "", nil, fmt.Errorf("answering the DVM's challenge: %w", err)
+					}
+				}
+				continue
+			}
+
+			if e.Kind == ResultKind(kind) || e.Kind == 4 || e.Kind == 1 {
 				// First check if it's tagged with our request ID
 				for _, tag := range e.Tags {
 					if len(tag) >= 2 && tag[0] == "e" && tag[1] == evt.ID {
@@ -377,40 +1165,61 @@ func (c *DvmClient) RequestTweet(ctx context.Context, dvmPubKey string, tweetID
 						break
 					}
 				}
-				
-				// If we didn't find a matching tag but we're getting responses, 
-				// consider using it if it's from the right DVM
-				if !isOurResponse && e.PubKey == dvmPubKey {
-					log.Printf("Found response from DVM, but no matching tag. Trying to parse anyway.")
-					isOurResponse = true
+
+				// Responses to other requests (including our own earlier ones) are
+				// not ours even though they come from the same DVM
+				if !isOurResponse {
+					log.Printf("Ignoring response from DVM for a different request")
+				}
+
+				if isOurResponse && tagValue(e.Tags, "page") != "" {
+					// Part of a paged result, ahead of the final result
+					if opts.page == nil {
+						continue
+					}
+					if err := paged.add(ctx, e); err != nil {
+						return "", nil, err
+					}
+					if final != nil && paged.complete(final) {
+						return finalContent, final, nil
+					}
+					continue
 				}
-				
 				if isOurResponse {
-					log.Printf("Received tweet data response from DVM")
-					log.Printf("Raw response content: %s", e.Content)
-					
-					var tweet twitterscraper.Tweet
-					if err := json.Unmarshal([]byte(e.Content), &tweet); err != nil {
-						log.Printf("Error unmarshaling tweet data: %v", err)
-						// Don't return yet, maybe there's another response coming
+					log.Printf("Received job result from DVM")
+					content, complete, err := assembler.add(ctx, e)
+					if err != nil {
+						return "", nil, err
+					}
+					if !complete {
 						continue
 					}
-					
-					// Check if the tweet data has basic fields to confirm it's valid
-					if tweet.Text == "" {
-						log.Printf("Warning: Parsed tweet has empty text field, might be incomplete")
+					log.Printf("Raw response content: %s", content)
+					if opts.page != nil && !paged.complete(e) {
+						// Pages can land after the final result; wait for them
+						final, finalContent = e, content
 						continue
 					}
-					
-					log.Printf("Successfully parsed tweet from @%s: %s", 
-						tweet.Username, tweet.Text)
-					return &tweet, nil
+					return content, e, nil
 				}
 			}
 		case <-ctx.Done():
 			log.Printf("Request timed out after waiting for response - check if the DVM published a response by running:")
-			log.Printf("nak event -r %s --kinds 1 --author %s --limit 5", c.relay.URL, dvmPubKey)
-			return nil, ctx.Err()
+			log.Printf("nak req -k %d -a %s --limit 5 %s", ResultKind(kind), dvmPubKey, relayURL(who.relay))
+			return "", nil, ctx.Err()
 		}
 	}
-}
\ No newline at end of file
+}
+
+// decrypt returns the content of a NIP-04 DM sent to sk, the client's own
+// key if empty.
+func (c *DvmClient) decrypt(dm *nostr.Event, sk string) (string, error) {
+	if sk == "" {
+		sk = c.sk
+	}
+	secret, err := nip04.ComputeSharedSecret(dm.PubKey, sk)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Decrypt(dm.Content, secret)
+}
@@ -0,0 +1,290 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeScraper serves a canned tweet for any ID and counts calls.
+type fakeScraper struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	return &twitterscraper.Tweet{ID: id, Username: "halfin", Text: "Running bitcoin"}, nil
+}
+
+func (f *fakeScraper) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// testKey returns a random private key. nostr.GeneratePrivateKey can drop
+// leading zeros, which NewDvm rejects.
+func testKey() string {
+	sk, err := generatePrivateKey()
+	if err != nil {
+		panic(err)
+	}
+	return sk
+}
+
+// startTestDvm runs a DVM against relay until the test finishes.
+func startTestDvm(t *testing.T, relay *relaytest.Server, opts ...Option) *Dvm {
+	t.Helper()
+	d, _ := runTestDvm(t, relay, opts...)
+	return d
+}
+
+// runTestDvm runs a DVM against relay until stop is called, which waits
+// for Run to return, or the test finishes.
+func runTestDvm(t *testing.T, relay *relaytest.Server, opts ...Option) (d *Dvm, stop func()) {
+	t.Helper()
+
+	d, err := NewDvm(relay.URL(), testKey(), opts...)
+	if err != nil {
+		t.Fatalf("failed to create dvm: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := d.Run(ctx); err != nil {
+			t.Errorf("DVM run error: %v", err)
+		}
+	}()
+	stop = func() {
+		cancel()
+		wg.Wait()
+	}
+	t.Cleanup(stop)
+
+	// Give the subscription a moment to reach the relay
+	time.Sleep(100 * time.Millisecond)
+	return d, stop
+}
+
+func requestTestTweet(t *testing.T, relay *relaytest.Server, d *Dvm, tweetID string) {
+	t.Helper()
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tweet, err := client.RequestTweet(ctx, d.GetPublicKey(), tweetID)
+	if err != nil {
+		t.Fatalf("error requesting tweet %s: %v", tweetID, err)
+	}
+	if tweet.ID != tweetID {
+		t.Errorf("expected tweet %s, got %s", tweetID, tweet.ID)
+	}
+}
+
+// awaitResponse waits for pubkey to publish an event referencing requestID.
+func awaitResponse(t *testing.T, relay *relaytest.Server, pubkey string, requestID string) *nostr.Event {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, evt := range relay.Events() {
+			if evt.PubKey == pubkey && evt.Tags.GetFirst([]string{"e", requestID}) != nil {
+				return evt
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no response to request %s", requestID[:8])
+	return nil
+}
+
+// newTestRequest builds a signed tweet request from a throwaway key.
+func newTestRequest(tweetID string) *nostr.Event {
+	return newTestRequestFrom(testKey(), tweetID)
+}
+
+// newTestRequestFrom builds a tweet request signed by sk.
+func newTestRequestFrom(sk string, tweetID string) *nostr.Event {
+	evt := &nostr.Event{CreatedAt: nostr.Now(), Kind: 42069, Tags: nostr.Tags{}, Content: tweetID}
+	evt.Sign(sk)
+	return evt
+}
+
+func TestDvmResubscribesAfterRelayDrop(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}))
+	requestTestTweet(t, relay, d, "1110302988")
+
+	relay.DropConnections()
+	time.Sleep(time.Second)
+
+	req := newTestRequest("1110302989")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+}
+
+func TestRunStopsWithItsContext(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d, err := NewDvm(relay.URL(), testKey(), WithScraper(&fakeScraper{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := d.Run(ctx); err != nil {
+		t.Errorf("expected Run to return nil once its context is done, got %v", err)
+	}
+
+	// With no relay left to subscribe on, it fails straight away
+	d, err = NewDvm(relay.URL(), testKey(), WithScraper(&fakeScraper{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.DropConnections()
+	relay.Close()
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Run(ctx); !errors.Is(err, ErrRelayClosed) {
+		t.Errorf("expected ErrRelayClosed, got %v", err)
+	}
+}
+
+func TestResultModes(t *testing.T) {
+	for mode, kind := range map[ResultMode]int{
+		"":                  ResultKind(KindTweetRequest),
+		ResultsAsJobResults: ResultKind(KindTweetRequest),
+		ResultsAsDMs:        4,
+		ResultsAsNotes:      1,
+	} {
+		relay := relaytest.NewServer()
+		d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithResultMode(mode))
+		requestTestTweet(t, relay, d, "20")
+
+		var result *nostr.Event
+		for _, evt := range relay.Events() {
+			if evt.PubKey == d.GetPublicKey() && evt.Tags.GetFirst([]string{"e"}) != nil {
+				result = evt
+			}
+		}
+		if result == nil || result.Kind != kind {
+			t.Errorf("mode %q: expected a kind %d result, got %+v", mode, kind, result)
+		} else if mode == ResultsAsDMs && strings.Contains(result.Content, "Running bitcoin") {
+			t.Errorf("mode %q: result isn't encrypted", mode)
+		} else if kind == ResultKind(KindTweetRequest) && result.Tags.GetFirst([]string{"request"}) == nil {
+			t.Errorf("mode %q: result doesn't include the request", mode)
+		}
+		relay.Close()
+	}
+
+	if _, err := NewDvm("ws://localhost:1", testKey(), WithResultMode("carrier-pigeon")); err == nil {
+		t.Error("expected an unknown result mode to be rejected")
+	}
+}
+
+func TestStaleRequestsAreSkipped(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithMaxRequestAge(2*time.Minute))
+
+	// As if its turn came long after the DVM came back from downtime
+	req := &nostr.Event{CreatedAt: nostr.Timestamp(time.Now().Add(-10 * time.Minute).Unix()),
+		Kind: KindTweetRequest, Tags: nostr.Tags{}, Content: "20"}
+	req.Sign(testKey())
+	d.handleRequest(req)
+
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Tags.GetFirst([]string{"status", StatusError, ReasonStale}) == nil {
+		t.Fatalf("expected stale feedback, got %+v", fb)
+	}
+	if scraper.Calls() != 0 {
+		t.Error("expected the stale request not to be scraped")
+	}
+
+	// One within the budget, allowing for the requester's clock, is served
+	req = &nostr.Event{CreatedAt: nostr.Timestamp(time.Now().Add(-2 * time.Minute).Unix()),
+		Kind: KindTweetRequest, Tags: nostr.Tags{}, Content: "21"}
+	req.Sign(testKey())
+	d.handleRequest(req)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind != ResultKind(KindTweetRequest) {
+		t.Errorf("expected a result, got kind %d", resp.Kind)
+	}
+}
+
+// benchTweet is a tweet with a quoted tweet and the usual fields filled.
+func benchTweet() *twitterscraper.Tweet {
+	return &twitterscraper.Tweet{
+		ID: "1110302988", Username: "halfin", Name: "Hal Finney", Text: "Running bitcoin",
+		Hashtags: []string{"bitcoin"}, URLs: []string{"https://bitcoin.org"},
+		Likes: 1000, Replies: 100, Retweets: 500, Views: 100000, Timestamp: 1231018005,
+		QuotedStatus: &twitterscraper.Tweet{ID: "20", Username: "jack", Text: "just setting up my twttr"},
+	}
+}
+
+// BenchmarkResult measures the steps from a scraped tweet to a signed
+// result event.
+func BenchmarkResult(b *testing.B) {
+	d := &Dvm{resultMode: ResultsAsJobResults}
+	id, err := newIdentity(primaryIdentity, testKey(), QuotaConfig{}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := newTestRequest("1110302988")
+	tweet := benchTweet()
+	content, err := json.Marshal(TweetResult{Tweet: tweet, Lang: detectLanguage(tweet.Text)})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(TweetResult{Tweet: tweet, Lang: detectLanguage(tweet.Text)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("build", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := d.resultEvent(id, req, content, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("sign", func(b *testing.B) {
+		resp, err := d.resultEvent(id, req, content, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := resp.Sign(id.sk); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Engagement is the result of a KindEngagementRequest job: a tweet's
+// current counts, without its content.
+type Engagement struct {
+	TweetID   string          `json:"tweet_id"`
+	Likes     int             `json:"likes"`
+	Retweets  int             `json:"retweets"`
+	Replies   int             `json:"replies"`
+	Views     int             `json:"views"`
+	FetchedAt nostr.Timestamp `json:"fetched_at"`
+}
+
+// engagementHandler refreshes the counts of a tweet a client already has.
+// The counts are always scraped afresh, and the fresh copy replaces the
+// cached one so later tweet requests see the same numbers.
+type engagementHandler struct {
+	d *Dvm
+}
+
+func (h *engagementHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		return fmt.Errorf("content is not a tweet ID")
+	}
+	return nil
+}
+
+func (h *engagementHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	tweet, _, err := h.d.scrapeTweet(req.Content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Engagement{
+		TweetID:   tweet.ID,
+		Likes:     tweet.Likes,
+		Retweets:  tweet.Retweets,
+		Replies:   tweet.Replies,
+		Views:     tweet.Views,
+		FetchedAt: nostr.Now(),
+	})
+}
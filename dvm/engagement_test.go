@@ -0,0 +1,58 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// growingScraper returns a tweet that gains a like every time it's fetched.
+type growingScraper struct {
+	fakeScraper
+}
+
+func (s *growingScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	tweet, _ := s.fakeScraper.GetTweet(id)
+	tweet.Likes = s.Calls()
+	tweet.Views = 100 * s.Calls()
+	return tweet, nil
+}
+
+func TestEngagementHandler(t *testing.T) {
+	scraper := &growingScraper{}
+	d := &Dvm{scraper: scraper, cache: newResultCache(1 << 20)}
+	if _, err := d.fetchTweetJSON("1110302988"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &engagementHandler{d: d}
+	req := &nostr.Event{Content: "1110302988"}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	out, err := h.Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var engagement Engagement
+	if err := json.Unmarshal(out, &engagement); err != nil {
+		t.Fatal(err)
+	}
+	if engagement.TweetID != "1110302988" || engagement.Likes != 2 || engagement.Views != 200 || engagement.FetchedAt == 0 {
+		t.Errorf("expected fresh counts, got %s", out)
+	}
+	var full map[string]any
+	json.Unmarshal(out, &full)
+	if _, ok := full["Text"]; ok {
+		t.Error("engagement results shouldn't carry the tweet's content")
+	}
+
+	// The refreshed tweet replaces the cached one
+	tweet, err := d.fetchTweet("1110302988")
+	if err != nil || tweet.Likes != 2 || scraper.Calls() != 2 {
+		t.Errorf("expected the cache to hold the refreshed tweet, got %+v after %d scrapes", tweet, scraper.Calls())
+	}
+}
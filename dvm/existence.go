@@ -0,0 +1,137 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ProfileScraper looks up a user's profile. *twitterscraper.Scraper
+// satisfies it; existence checks use it to tell why a tweet is gone.
+type ProfileScraper interface {
+	GetProfile(username string) (twitterscraper.Profile, error)
+}
+
+// Tweet statuses reported by existence checks.
+const (
+	TweetAlive       = "alive"
+	TweetDeleted     = "deleted"
+	TweetSuspended   = "suspended"   // the author's account is suspended
+	TweetProtected   = "protected"   // the author's tweets are private
+	TweetUnavailable = "unavailable" // gone, for a reason we can't tell
+)
+
+// TweetExistence is the result of a KindExistenceRequest job: whether a
+// tweet can still be seen, without its content.
+type TweetExistence struct {
+	TweetID   string          `json:"tweet_id"`
+	Status    string          `json:"status"`
+	Author    string          `json:"author,omitempty"`
+	CheckedAt nostr.Timestamp `json:"checked_at"`
+}
+
+// existenceHandler checks whether a tweet still exists, for auditing links
+// in bulk. Tweets that are gone are told apart by the errors Twitter gives,
+// or, given the author's handle in ["param", "author", <handle>], by
+// looking up their profile.
+type existenceHandler struct {
+	d        *Dvm
+	profiles ProfileScraper // nil if the scraper can't look up profiles
+}
+
+// existenceParams are the params of an existence request.
+type existenceParams struct {
+	Author string `param:"author"`
+}
+
+func (h *existenceHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		return fmt.Errorf("content is not a tweet ID")
+	}
+	var params existenceParams
+	if err := DecodeParams(req, &params); err != nil {
+		return err
+	}
+	if params.Author != "" && !twitterHandlePattern.MatchString(params.Author) {
+		return fmt.Errorf("author param is not a Twitter handle")
+	}
+	return nil
+}
+
+func (h *existenceHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	var params existenceParams
+	if err := DecodeParams(req, &params); err != nil {
+		return nil, err
+	}
+	result := TweetExistence{TweetID: req.Content, Author: strings.TrimPrefix(params.Author, "@")}
+	tweet, err := h.d.scraper.GetTweet(req.Content)
+	if err == nil && tweet != nil && tweet.ID != "" {
+		result.Status = TweetAlive
+		result.Author = tweet.Username
+	} else {
+		status, gone := goneStatus(err)
+		if !gone {
+			// Rate limits and the like say nothing about the tweet
+			return nil, err
+		}
+		if status == TweetUnavailable && result.Author != "" && h.profiles != nil {
+			status = h.authorStatus(result.Author)
+		}
+		result.Status = status
+	}
+	result.CheckedAt = nostr.Now()
+	return json.Marshal(result)
+}
+
+// authorStatus explains a missing tweet by the state of its author's
+// account.
+func (h *existenceHandler) authorStatus(author string) string {
+	profile, err := h.profiles.GetProfile(author)
+	switch {
+	case err != nil && strings.Contains(strings.ToLower(err.Error()), "suspended"):
+		return TweetSuspended
+	case err != nil && strings.Contains(strings.ToLower(err.Error()), "not found"):
+		// A deleted account takes its tweets with it
+		return TweetDeleted
+	case err != nil:
+		return TweetUnavailable
+	case profile.IsPrivate:
+		return TweetProtected
+	default:
+		return TweetDeleted
+	}
+}
+
+// goneMessages maps what Twitter says about tweets it won't show to their
+// status.
+var goneMessages = []struct {
+	message, status string
+}{
+	{"suspended", TweetSuspended},
+	{"not authorized to see", TweetProtected},
+	{"protected", TweetProtected},
+	{"no status found", TweetDeleted},
+	{"has been deleted", TweetDeleted},
+	{"not found", TweetUnavailable},
+	{"404", TweetUnavailable},
+}
+
+// goneStatus is the status of a tweet the scraper couldn't return, going
+// by its error. It returns false if err doesn't mean the tweet is gone,
+// but that fetching it failed. A nil error means Twitter returned nothing.
+func goneStatus(err error) (string, bool) {
+	if err == nil {
+		return TweetUnavailable, true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range goneMessages {
+		if strings.Contains(msg, m.message) {
+			return m.status, true
+		}
+	}
+	return "", false
+}
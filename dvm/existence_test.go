@@ -0,0 +1,95 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// goneScraper fails to fetch tweets other than 1 with the error in errs,
+// and knows the profiles in profiles.
+type goneScraper struct {
+	fakeScraper
+	errs     map[string]error
+	profiles map[string]twitterscraper.Profile
+}
+
+func (s *goneScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	if id == "1" {
+		return s.fakeScraper.GetTweet(id)
+	}
+	if err, ok := s.errs[id]; ok {
+		return nil, err
+	}
+	return &twitterscraper.Tweet{}, nil
+}
+
+func (s *goneScraper) GetProfile(username string) (twitterscraper.Profile, error) {
+	if username == "banned" {
+		return twitterscraper.Profile{}, errors.New("user is suspended")
+	}
+	if p, ok := s.profiles[username]; ok {
+		return p, nil
+	}
+	return twitterscraper.Profile{}, errors.New("user not found")
+}
+
+func TestExistenceHandler(t *testing.T) {
+	scraper := &goneScraper{
+		errs: map[string]error{
+			"2": errors.New("tweet with ID 2 not found"),
+			"3": errors.New("response status 403 Forbidden: Sorry, you are not authorized to see this status."),
+			"4": errors.New("response status 429 Too Many Requests: Rate limit exceeded"),
+		},
+		profiles: map[string]twitterscraper.Profile{
+			"private": {Username: "private", IsPrivate: true},
+			"public":  {Username: "public"},
+		},
+	}
+	d := &Dvm{scraper: scraper}
+	h := d.defaultHandlers()[KindExistenceRequest]
+
+	for _, tc := range []struct {
+		id, author, status string
+	}{
+		{"1", "", TweetAlive},
+		{"2", "", TweetUnavailable},
+		{"3", "", TweetProtected},
+		{"5", "", TweetUnavailable},
+		{"2", "banned", TweetSuspended},
+		{"2", "private", TweetProtected},
+		{"2", "public", TweetDeleted},
+		{"2", "gone", TweetDeleted},
+	} {
+		req := &nostr.Event{Content: tc.id}
+		if tc.author != "" {
+			req.Tags = nostr.Tags{{"param", "author", tc.author}}
+		}
+		if err := h.Validate(req); err != nil {
+			t.Fatal(err)
+		}
+		out, err := h.Handle(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result TweetExistence
+		if err := json.Unmarshal(out, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.TweetID != tc.id || result.Status != tc.status || result.CheckedAt == 0 {
+			t.Errorf("tweet %s by %q: expected %s, got %s", tc.id, tc.author, tc.status, out)
+		}
+	}
+
+	// Failing to ask isn't an answer
+	if _, err := h.Handle(context.Background(), &nostr.Event{Content: "4"}); err == nil {
+		t.Error("expected a rate limit to fail the job")
+	}
+	if err := h.Validate(&nostr.Event{Content: "2", Tags: nostr.Tags{{"param", "author", "not a handle"}}}); err == nil {
+		t.Error("expected a bad author to be rejected")
+	}
+}
@@ -0,0 +1,325 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Feed is the result of a KindFeedRequest job.
+type Feed struct {
+	URL         string      `json:"url"`
+	Title       string      `json:"title"`
+	Link        string      `json:"link,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Entries     []FeedEntry `json:"entries"` // newest first
+}
+
+// FeedEntry is one item of an RSS or Atom feed.
+type FeedEntry struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Link      string    `json:"link,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Published time.Time `json:"published,omitempty"`
+	Updated   time.Time `json:"updated,omitempty"`
+}
+
+const (
+	defaultFeedEntries = 20
+	maxFeedEntries     = 100
+	maxCachedFeeds     = 256
+)
+
+// feedHandler fetches RSS 2.0, RSS 1.0 and Atom feeds. Requests may carry a
+// ["param", "limit", "<n>"] tag for the number of entries. Feeds are
+// re-fetched with If-None-Match/If-Modified-Since so unchanged feeds cost the
+// publisher almost nothing.
+type feedHandler struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*cachedFeed
+}
+
+// cachedFeed is a parsed feed along with the validators to revalidate it.
+type cachedFeed struct {
+	etag         string
+	lastModified string
+	feed         *Feed
+	fetchedAt    time.Time
+}
+
+func newFeedHandler() *feedHandler {
+	return &feedHandler{client: newFetchClient(), cache: make(map[string]*cachedFeed)}
+}
+
+func (h *feedHandler) Validate(req *nostr.Event) error {
+	if _, err := parseWebURL(req.Content); err != nil {
+		return err
+	}
+	_, err := feedLimit(req)
+	return err
+}
+
+func feedLimit(req *nostr.Event) (int, error) {
+	value, ok := paramValue(req, "limit")
+	if !ok {
+		return defaultFeedEntries, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxFeedEntries {
+		return 0, fmt.Errorf("limit param must be between 1 and %d", maxFeedEntries)
+	}
+	return n, nil
+}
+
+func (h *feedHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	feedURL := strings.TrimSpace(req.Content)
+	limit, err := feedLimit(req)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := h.fetchFeed(ctx, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	result := *feed
+	if len(result.Entries) > limit {
+		result.Entries = result.Entries[:limit]
+	}
+	return json.Marshal(result)
+}
+
+// fetchFeed returns the parsed feed, revalidating any cached copy.
+func (h *feedHandler) fetchFeed(ctx context.Context, feedURL string) (*Feed, error) {
+	h.mu.Lock()
+	cached := h.cache[feedURL]
+	h.mu.Unlock()
+
+	header := http.Header{"Accept": {"application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.8"}}
+	if cached != nil {
+		if cached.etag != "" {
+			header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	res, err := fetchWith(ctx, h.client, feedURL, header, maxFetchBytes)
+	var statusErr *httpStatusError
+	if cached != nil && errors.As(err, &statusErr) && statusErr.Status == http.StatusNotModified {
+		return cached.feed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := parseFeed(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	feed.URL = feedURL
+
+	if etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified"); etag != "" || lastModified != "" {
+		h.store(feedURL, &cachedFeed{etag: etag, lastModified: lastModified, feed: feed, fetchedAt: time.Now()})
+	}
+	return feed, nil
+}
+
+// store caches a feed, evicting the least recently fetched when full.
+func (h *feedHandler) store(feedURL string, entry *cachedFeed) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.cache[feedURL]; !ok && len(h.cache) >= maxCachedFeeds {
+		var oldest string
+		for u, c := range h.cache {
+			if oldest == "" || c.fetchedAt.Before(h.cache[oldest].fetchedAt) {
+				oldest = u
+			}
+		}
+		delete(h.cache, oldest)
+	}
+	h.cache[feedURL] = entry
+}
+
+// xmlFeed decodes RSS 2.0 (<rss><channel>), RSS 1.0 (<rdf:RDF>) and Atom
+// (<feed>) documents into one shape.
+type xmlFeed struct {
+	XMLName xml.Name
+	Channel struct {
+		Title       string    `xml:"title"`
+		Links       []xmlLink `xml:"link"`
+		Description string    `xml:"description"`
+		Items       []xmlItem `xml:"item"` // RSS 2.0
+	} `xml:"channel"`
+	Items []xmlItem `xml:"item"` // RSS 1.0 puts items beside the channel
+
+	// Atom
+	Title    string     `xml:"title"`
+	Subtitle string     `xml:"subtitle"`
+	Links    []xmlLink  `xml:"link"`
+	Entries  []xmlEntry `xml:"entry"`
+}
+
+type xmlItem struct {
+	Title       string    `xml:"title"`
+	Links       []xmlLink `xml:"link"`
+	GUID        string    `xml:"guid"`
+	PubDate     string    `xml:"pubDate"`
+	Date        string    `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string    `xml:"author"`
+	Creator     string    `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description string    `xml:"description"`
+	Content     string    `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+// xmlLink is an RSS <link>URL</link> or an Atom <link href="URL"/>; RSS
+// feeds often contain both.
+type xmlLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+type xmlEntry struct {
+	ID        string    `xml:"id"`
+	Title     string    `xml:"title"`
+	Links     []xmlLink `xml:"link"`
+	Published string    `xml:"published"`
+	Updated   string    `xml:"updated"`
+	Author    struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Summary string `xml:"summary"`
+	Content string `xml:"content"`
+}
+
+// parseFeed decodes an RSS or Atom document, newest entries first.
+func parseFeed(data []byte) (*Feed, error) {
+	var doc xmlFeed
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false // real-world feeds are often sloppy
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Feeds declaring legacy charsets are almost always ASCII-compatible
+		return input, nil
+	}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+
+	feed := &Feed{}
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss", "rdf":
+		feed.Title = strings.TrimSpace(doc.Channel.Title)
+		feed.Link = rssLink(doc.Channel.Links)
+		feed.Description = strings.TrimSpace(doc.Channel.Description)
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			link := rssLink(item.Links)
+			entry := FeedEntry{
+				ID:      firstNonEmpty(item.GUID, link),
+				Title:   strings.TrimSpace(item.Title),
+				Link:    link,
+				Author:  firstNonEmpty(item.Creator, item.Author),
+				Summary: htmlToText(item.Description),
+				Content: item.Content,
+			}
+			entry.Published = parseFeedTime(firstNonEmpty(item.PubDate, item.Date))
+			feed.Entries = append(feed.Entries, entry)
+		}
+	case "feed":
+		feed.Title = strings.TrimSpace(doc.Title)
+		feed.Link = atomLink(doc.Links)
+		feed.Description = strings.TrimSpace(doc.Subtitle)
+		for _, e := range doc.Entries {
+			entry := FeedEntry{
+				ID:      strings.TrimSpace(e.ID),
+				Title:   strings.TrimSpace(e.Title),
+				Link:    atomLink(e.Links),
+				Author:  strings.TrimSpace(e.Author.Name),
+				Summary: htmlToText(e.Summary),
+				Content: e.Content,
+			}
+			entry.Updated = parseFeedTime(e.Updated)
+			entry.Published = parseFeedTime(e.Published)
+			if entry.Published.IsZero() {
+				entry.Published = entry.Updated
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed: <%s>", doc.XMLName.Local)
+	}
+
+	sort.SliceStable(feed.Entries, func(i, j int) bool {
+		return feed.Entries[i].Published.After(feed.Entries[j].Published)
+	})
+	return feed, nil
+}
+
+// rssLink prefers the RSS text link over any Atom links alongside it.
+func rssLink(links []xmlLink) string {
+	for _, l := range links {
+		if text := strings.TrimSpace(l.Text); text != "" {
+			return text
+		}
+	}
+	return atomLink(links)
+}
+
+// atomLink picks the alternate (or first) link of an Atom element.
+func atomLink(links []xmlLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	if len(links) > 0 {
+		return links[0].Href
+	}
+	return ""
+}
+
+// feedTimeLayouts covers RFC 822 dates as RSS uses them in the wild, and
+// RFC 3339 as Atom and Dublin Core use.
+var feedTimeLayouts = []string{
+	time.RFC1123Z, time.RFC1123, time.RFC3339, time.RFC3339Nano,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700", "Mon, 02 Jan 06 15:04:05 -0700", "2006-01-02",
+}
+
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
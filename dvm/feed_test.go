@@ -0,0 +1,119 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const testRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+	<title>Bitcoin Optech</title>
+	<link>https://bitcoinops.org/</link>
+	<atom:link href="https://bitcoinops.org/feed.xml" rel="self"/>
+	<description>Newsletter</description>
+	<item>
+		<title>Newsletter #1</title>
+		<link>https://bitcoinops.org/1</link>
+		<pubDate>Tue, 05 Jun 2018 00:00:00 +0000</pubDate>
+		<dc:creator>Optech</dc:creator>
+		<description>&lt;p&gt;First issue&lt;/p&gt;</description>
+	</item>
+	<item>
+		<title>Newsletter #2</title>
+		<link>https://bitcoinops.org/2</link>
+		<guid>optech-2</guid>
+		<pubDate>Tue, 12 Jun 2018 00:00:00 +0000</pubDate>
+	</item>
+</channel>
+</rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Example Blog</title>
+	<link href="https://example.com/atom.xml" rel="self"/>
+	<link href="https://example.com/"/>
+	<entry>
+		<id>urn:uuid:1</id>
+		<title>Hello</title>
+		<link href="https://example.com/hello"/>
+		<updated>2024-01-02T03:04:05Z</updated>
+		<author><name>Alice</name></author>
+		<summary>Hi there</summary>
+	</entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	rss, err := parseFeed([]byte(testRSS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rss.Title != "Bitcoin Optech" || rss.Link != "https://bitcoinops.org/" || len(rss.Entries) != 2 {
+		t.Fatalf("unexpected RSS feed: %+v", rss)
+	}
+	if newest := rss.Entries[0]; newest.ID != "optech-2" {
+		t.Errorf("expected newest entry first, got %+v", newest)
+	}
+	if oldest := rss.Entries[1]; oldest.ID != "https://bitcoinops.org/1" || oldest.Author != "Optech" || oldest.Summary != "First issue" {
+		t.Errorf("unexpected RSS entry: %+v", oldest)
+	}
+
+	atom, err := parseFeed([]byte(testAtom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atom.Link != "https://example.com/" || len(atom.Entries) != 1 {
+		t.Fatalf("unexpected Atom feed: %+v", atom)
+	}
+	entry := atom.Entries[0]
+	if entry.Link != "https://example.com/hello" || entry.Author != "Alice" || entry.Published.IsZero() {
+		t.Errorf("unexpected Atom entry: %+v", entry)
+	}
+
+	if _, err := parseFeed([]byte(`<html><body>not a feed</body></html>`)); err == nil {
+		t.Error("expected an HTML page to be rejected")
+	}
+}
+
+func TestFeedHandlerRevalidatesWithETag(t *testing.T) {
+	fullFetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullFetches++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testRSS))
+	}))
+	defer srv.Close()
+
+	h := newFeedHandler()
+	h.client = srv.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &nostr.Event{Content: srv.URL, Tags: nostr.Tags{{"param", "limit", "1"}}}
+	for i := 0; i < 2; i++ {
+		result, err := h.Handle(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var feed Feed
+		if err := json.Unmarshal(result, &feed); err != nil {
+			t.Fatal(err)
+		}
+		if len(feed.Entries) != 1 || feed.Entries[0].Title != "Newsletter #2" {
+			t.Errorf("fetch %d: unexpected entries %+v", i, feed.Entries)
+		}
+	}
+	if fullFetches != 1 {
+		t.Errorf("expected the second fetch to be a 304, got %d full fetches", fullFetches)
+	}
+}
@@ -0,0 +1,113 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindJobFeedback is the NIP-90 job feedback kind.
+const KindJobFeedback = 7000
+
+// Feedback statuses defined by NIP-90.
+const (
+	StatusPaymentRequired = "payment-required"
+	StatusProcessing      = "processing"
+	StatusError           = "error"
+	StatusSuccess         = "success"
+	StatusPartial         = "partial"
+)
+
+// Machine-readable reasons sent as the extra info of an error status so
+// clients can react without parsing the human-readable content.
+const (
+	ReasonQuotaExceeded        = "quota-exceeded"
+	ReasonBusy                 = "busy" // sent with a "retry-after" tag in seconds
+	ReasonContentPolicy        = "content-policy"
+	ReasonExpired              = "expired"               // the request's NIP-40 expiration passed before it ran
+	ReasonStale                = "stale"                 // the request was older than the DVM's freshness budget when it ran
+	ReasonInvalidParams        = "invalid-params"        // the message lists the params failing the kind's schema
+	ReasonResultTooLarge       = "result-too-large"      // the result is over what the DVM publishes; see ResultLimit
+	ReasonShuttingDown         = "shutting-down"         // the DVM stopped before running the job; resubmit it
+	ReasonUntrusted            = "untrusted"             // the requester is outside the DVM's web of trust; see WoTConfig
+	ReasonPoWRequired          = "pow-required"          // sent with a "pow" tag of the NIP-13 difficulty required
+	ReasonNotAllowed           = "not-allowed"           // the requester isn't on the DVM's allowlist; see ListSyncConfig
+	ReasonImplausibleTimestamp = "implausible-timestamp" // the request's created_at is outside the DVM's TimestampWindow
+	ReasonChallengeUnanswered  = "challenge-unanswered"  // the requester didn't confirm the request; see ChallengeConfig
+)
+
+// publishFeedback sends a NIP-90 feedback event about req to the requester,
+// signed by the identity the request was addressed to. It queues the event
+// in the outbox rather than waiting for the relays.
+func (d *Dvm) publishFeedback(id *identity, req *nostr.Event, status, reason, message string, extra ...nostr.Tag) {
+	statusTag := nostr.Tag{"status", status}
+	if reason != "" {
+		statusTag = append(statusTag, reason)
+	}
+
+	fb := nostr.Event{
+		PubKey:    id.pk,
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      KindJobFeedback,
+		Tags: append(nostr.Tags{
+			statusTag,
+			{"e", req.ID},
+			{"p", req.PubKey},
+		}, extra...),
+		Content: message,
+	}
+	if err := fb.Sign(id.sk); err != nil {
+		log.Printf("DVM sign error for feedback: %v", err)
+		return
+	}
+
+	if status == StatusError {
+		log.Printf("Sending %s feedback for request %s: %s", status, req.ID[:8], message)
+	} else {
+		d.logs.printf("Sending %s feedback for request %s: %s", status, req.ID[:8], message)
+	}
+	d.publishAsync(fb, func(err error) {
+		if err != nil {
+			log.Printf("Giving up on feedback for request %s: %v", req.ID[:8], err)
+			return
+		}
+		d.audit.record(req, &fb)
+	})
+}
+
+// FeedbackError is returned by DvmClient when the DVM answers a request with
+// an error feedback event instead of a result.
+type FeedbackError struct {
+	Reason     string        // machine-readable reason such as ReasonQuotaExceeded, may be empty
+	Message    string        // human-readable explanation from the event content
+	RetryAfter time.Duration // how long to back off before retrying, if the DVM said
+}
+
+func (e *FeedbackError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("dvm error: %s", e.Message)
+	}
+	return fmt.Sprintf("dvm error (%s): %s", e.Reason, e.Message)
+}
+
+// feedbackError converts an error feedback event into a *FeedbackError,
+// returning nil for any other status.
+func feedbackError(fb *nostr.Event) error {
+	status := fb.Tags.GetFirst([]string{"status", StatusError})
+	if status == nil {
+		return nil
+	}
+	err := &FeedbackError{Message: fb.Content}
+	if len(*status) > 2 {
+		err.Reason = (*status)[2]
+	}
+	if tag := fb.Tags.GetFirst([]string{"retry-after"}); tag != nil && len(*tag) > 1 {
+		if secs, convErr := strconv.Atoi((*tag)[1]); convErr == nil {
+			err.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return err
+}
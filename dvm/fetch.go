@@ -0,0 +1,234 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// fetchUserAgent identifies the DVM to the sites job handlers fetch from.
+const fetchUserAgent = "bandita/1.0 (nostr DVM; +https://github.com/justinmoon/bandita)"
+
+// maxFetchBytes caps response bodies read by job handlers.
+const maxFetchBytes = 16 << 20
+
+// newFetchClient returns the HTTP client job handlers use for the open web.
+// Since requesters choose the URLs, it refuses to connect to loopback,
+// private or link-local addresses so a job can't probe the DVM's own
+// network, including via redirects.
+func newFetchClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("refusing to fetch from non-public address %s", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if torDial == nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+		// Tor resolves names itself and its exits refuse private
+		// addresses, leaving only this machine to keep jobs from reaching
+		// loopback names, or private addresses given outright
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); isLoopbackHost(host) || ip != nil && !isPublicIP(ip) {
+			return nil, fmt.Errorf("refusing to fetch from non-public address %s", host)
+		}
+		return torDial(ctx, network, address)
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}
+}
+
+// carrierNAT is the shared address space of RFC 6598, not covered by IsPrivate.
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || carrierNAT.Contains(ip))
+}
+
+// httpStatusError is returned when a fetch gets a non-2xx response.
+type httpStatusError struct {
+	Method string // GET if empty
+	URL    string
+	Status int
+}
+
+func (e *httpStatusError) Error() string {
+	method := e.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	return fmt.Sprintf("%s %s returned %d %s", method, e.URL, e.Status, http.StatusText(e.Status))
+}
+
+// fetch GETs rawURL with the given Accept header and returns the body.
+func fetch(ctx context.Context, client *http.Client, rawURL, accept string) ([]byte, error) {
+	header := make(http.Header)
+	if accept != "" {
+		header.Set("Accept", accept)
+	}
+	res, err := fetchWith(ctx, client, rawURL, header, maxFetchBytes)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// fetchResult is a successful response read by fetchWith.
+type fetchResult struct {
+	URL    string // final URL after redirects
+	Header http.Header
+	Body   []byte
+}
+
+// fetchWith GETs rawURL with extra request headers, reading at most
+// maxBytes of the body. Non-2xx responses are returned as *httpStatusError.
+func fetchWith(ctx context.Context, client *http.Client, rawURL string, header http.Header, maxBytes int64) (*fetchResult, error) {
+	res, truncated, err := fetchUpTo(ctx, client, rawURL, header, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, fmt.Errorf("response from %s exceeds %d bytes", rawURL, maxBytes)
+	}
+	return res, nil
+}
+
+// fetchUpTo is fetchWith for callers that only need the start of a
+// document: it returns the first maxBytes of the body and whether there was
+// more.
+func fetchUpTo(ctx context.Context, client *http.Client, rawURL string, header http.Header, maxBytes int64) (*fetchResult, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, false, &httpStatusError{URL: rawURL, Status: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	truncated := int64(len(body)) > maxBytes
+	if truncated {
+		body = body[:maxBytes]
+	}
+	return &fetchResult{URL: resp.Request.URL.String(), Header: resp.Header, Body: body}, truncated, nil
+}
+
+// fetchJSON GETs rawURL and decodes the JSON response into v.
+func fetchJSON(ctx context.Context, client *http.Client, rawURL, accept string, v any) error {
+	if accept == "" {
+		accept = "application/json"
+	}
+	body, err := fetch(ctx, client, rawURL, accept)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid JSON from %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// postJSON POSTs body as JSON to rawURL and decodes the JSON response into
+// v. It's for the operator-configured APIs (translation, LLMs...) that jobs
+// call, so unlike fetch it takes whatever client the caller configured.
+func postJSON(ctx context.Context, client *http.Client, rawURL string, header http.Header, body, v any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", fetchUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &httpStatusError{Method: http.MethodPost, URL: rawURL, Status: resp.StatusCode}
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxFetchBytes)).Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON from %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// parseWebURL parses an absolute http(s) URL from a job request.
+func parseWebURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("not an http(s) URL: %q", raw)
+	}
+	return u, nil
+}
+
+// htmlToText flattens an HTML fragment to plain text, turning paragraph and
+// line breaks into newlines.
+func htmlToText(fragment string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(b.String())
+		case html.TextToken:
+			b.Write(z.Text())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if name, _ := z.TagName(); string(name) == "br" {
+				b.WriteString("\n")
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "p" {
+				b.WriteString("\n\n")
+			}
+		}
+	}
+}
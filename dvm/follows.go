@@ -0,0 +1,175 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// FollowsScraper fetches the accounts following, or followed by, a user a
+// page at a time. *twitterscraper.Scraper satisfies it; follows jobs need it.
+type FollowsScraper interface {
+	FetchFollowers(user string, maxUsersNbr int, cursor string) ([]*twitterscraper.Profile, string, error)
+	FetchFollowing(user string, maxUsersNbr int, cursor string) ([]*twitterscraper.Profile, string, error)
+}
+
+// FollowList is the final result of a KindFollowsRequest job. The accounts
+// are in Pages result events tagged ["page", <n>], each a FollowPage.
+type FollowList struct {
+	Handle string `json:"handle"`
+	List   string `json:"list"` // "followers" or "following"
+	Users  int    `json:"users"`
+	Pages  int    `json:"pages"`
+}
+
+// FollowPage is the content of one page event.
+type FollowPage struct {
+	Handle string         `json:"handle"`
+	List   string         `json:"list"`
+	Page   int            `json:"page"`
+	Users  []FollowedUser `json:"users"`
+}
+
+// FollowedUser is the gist of an account's profile.
+type FollowedUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name,omitempty"`
+	Followers int    `json:"followers"`
+	Verified  bool   `json:"verified,omitempty"`
+}
+
+const (
+	maxFollows         = 10000
+	defaultMaxFollows  = 1000
+	followsPageSize    = 200
+	followsFetchedEach = 100 // Twitter's page size
+)
+
+// followsHandler lists a user's followers, or with ["param", "list",
+// "following"] the accounts they follow, up to ["param", "max", <n>].
+type followsHandler struct {
+	follows FollowsScraper
+}
+
+// followsParams are the params of a follows request.
+type followsParams struct {
+	List string `param:"list"`
+	Max  int    `param:"max"`
+}
+
+func (h *followsHandler) params(req *nostr.Event) (followsParams, error) {
+	params := followsParams{List: "followers", Max: defaultMaxFollows}
+	if err := DecodeParams(req, &params); err != nil {
+		return params, err
+	}
+	if params.Max < 1 || params.Max > maxFollows {
+		return params, fmt.Errorf("max param must be between 1 and %d", maxFollows)
+	}
+	if params.List != "followers" && params.List != "following" {
+		return params, fmt.Errorf("list param must be followers or following, not %q", params.List)
+	}
+	return params, nil
+}
+
+func (h *followsHandler) Validate(req *nostr.Event) error {
+	if !twitterHandlePattern.MatchString(strings.TrimSpace(req.Content)) {
+		return fmt.Errorf("content is not a Twitter handle")
+	}
+	_, err := h.params(req)
+	return err
+}
+
+func (h *followsHandler) Units(req *nostr.Event) int64 {
+	params, _ := h.params(req)
+	return int64(params.Max)
+}
+
+func (h *followsHandler) Unit() string {
+	return "account"
+}
+
+func (h *followsHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	params, err := h.params(req)
+	if err != nil {
+		return nil, err
+	}
+	handle := strings.TrimPrefix(strings.TrimSpace(req.Content), "@")
+	limit := params.Max
+	result := FollowList{Handle: handle, List: params.List}
+	fetch := h.follows.FetchFollowers
+	if params.List == "following" {
+		fetch = h.follows.FetchFollowing
+	}
+
+	var pending []FollowedUser
+	flush := func() error {
+		result.Pages++
+		page, err := json.Marshal(FollowPage{Handle: handle, List: result.List, Page: result.Pages, Users: pending})
+		if err != nil {
+			return err
+		}
+		pending = nil
+		return publishPage(ctx, result.Pages, page)
+	}
+
+	cursor := ""
+	for result.Users < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		count := limit - result.Users
+		if count > followsFetchedEach {
+			count = followsFetchedEach
+		}
+		profiles, next, err := fetch(handle, count, cursor)
+		if err != nil {
+			if result.Users == 0 {
+				return nil, err
+			}
+			log.Printf("Stopping %s list of @%s after %d users: %v", result.List, handle, result.Users, err)
+			break
+		}
+		if len(profiles) == 0 {
+			break
+		}
+		for _, p := range profiles {
+			if result.Users == limit {
+				break
+			}
+			result.Users++
+			pending = append(pending, FollowedUser{
+				ID:        p.UserID,
+				Username:  p.Username,
+				Name:      p.Name,
+				Followers: p.FollowersCount,
+				Verified:  p.IsVerified || p.IsBlueVerified,
+			})
+			if len(pending) == followsPageSize {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if next == "" || next == cursor {
+			break
+		}
+		cursor = next
+	}
+
+	if result.Users == 0 {
+		return nil, errors.New("no accounts found")
+	}
+	if len(pending) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(result)
+}
@@ -0,0 +1,97 @@
+package dvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"bandita/internal/relaytest"
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// followsScraper has followers and followees named by list, in pages.
+type followsScraper struct {
+	fakeScraper
+	followers, following int
+}
+
+func (s *followsScraper) page(list string, n, max int, cursor string) ([]*twitterscraper.Profile, string, error) {
+	start, _ := strconv.Atoi(cursor)
+	var profiles []*twitterscraper.Profile
+	for i := start; i < n && len(profiles) < max; i++ {
+		profiles = append(profiles, &twitterscraper.Profile{UserID: fmt.Sprint(i), Username: fmt.Sprintf("%s%d", list, i)})
+	}
+	return profiles, strconv.Itoa(start + len(profiles)), nil
+}
+
+func (s *followsScraper) FetchFollowers(user string, max int, cursor string) ([]*twitterscraper.Profile, string, error) {
+	return s.page("follower", s.followers, max, cursor)
+}
+
+func (s *followsScraper) FetchFollowing(user string, max int, cursor string) ([]*twitterscraper.Profile, string, error) {
+	return s.page("followee", s.following, max, cursor)
+}
+
+func TestFollowsHandler(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithScraper(&followsScraper{followers: 450, following: 30}))
+	request := func(params ...nostr.Tag) (FollowList, []FollowPage) {
+		t.Helper()
+		req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindFollowsRequest, Content: "halfin", Tags: params}
+		req.Sign(testKey())
+		if err := d.handlers[KindFollowsRequest].Validate(req); err != nil {
+			t.Fatal(err)
+		}
+		d.handleRequest(req)
+
+		// Results go out through the outbox, so wait for them to land
+		var list FollowList
+		var pages []FollowPage
+		waitFor(func() bool {
+			list, pages = FollowList{}, nil
+			for _, evt := range relay.Events() {
+				if evt.Kind != ResultKind(req.Kind) || evt.Tags.GetFirst([]string{"e", req.ID}) == nil {
+					continue
+				}
+				if evt.Tags.GetFirst([]string{"page"}) == nil {
+					json.Unmarshal([]byte(evt.Content), &list)
+					continue
+				}
+				var page FollowPage
+				json.Unmarshal([]byte(evt.Content), &page)
+				pages = append(pages, page)
+			}
+			return list.List != "" && len(pages) >= list.Pages
+		})
+		return list, pages
+	}
+
+	list, pages := request(nostr.Tag{"param", "max", "420"})
+	if list.List != "followers" || list.Users != 420 || list.Pages != 3 || len(pages) != 3 {
+		t.Fatalf("unexpected followers result %+v with %d pages", list, len(pages))
+	}
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		for _, u := range page.Users {
+			seen[u.Username] = true
+		}
+	}
+	if len(seen) != 420 || !seen["follower0"] || !seen["follower419"] {
+		t.Errorf("expected followers 0-419 once each, got %d", len(seen))
+	}
+
+	list, pages = request(nostr.Tag{"param", "list", "following"})
+	if list.List != "following" || list.Users != 30 || len(pages) != 1 || pages[0].Users[0].Username != "followee0" {
+		t.Errorf("unexpected following result %+v", list)
+	}
+
+	for _, bad := range []nostr.Tags{{{"param", "max", "0"}}, {{"param", "list", "friends"}}} {
+		if err := d.handlers[KindFollowsRequest].Validate(&nostr.Event{Content: "halfin", Tags: bad}); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
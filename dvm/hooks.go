@@ -0,0 +1,112 @@
+package dvm
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// hooks are the functions embedders registered to hear about jobs; see
+// OnRequest, OnResult, OnError and OnPayment.
+type hooks struct {
+	mu      sync.RWMutex
+	request []func(req *nostr.Event)
+	result  []func(req, result *nostr.Event)
+	err     []func(req *nostr.Event, err error)
+	payment []func(req *nostr.Event, msats int64)
+}
+
+// OnRequest registers fn to be called with each request the DVM takes on:
+// one addressed to it that passed its checks, such as the web of trust,
+// quotas and params, and is about to be run. Every request taken on later
+// reaches OnResult or OnError.
+//
+// Hooks are called in the order registered, on the job's goroutine, so
+// they should be quick; a hook that panics is logged and skipped. They
+// may be registered at any time, including while the DVM runs.
+func (d *Dvm) OnRequest(fn func(req *nostr.Event)) {
+	d.hooks.mu.Lock()
+	defer d.hooks.mu.Unlock()
+	d.hooks.request = append(d.hooks.request, fn)
+}
+
+// OnResult registers fn to be called once a request's result reached the
+// relays, with the result event, the last if it was chunked. Replayed
+// results count too.
+func (d *Dvm) OnResult(fn func(req, result *nostr.Event)) {
+	d.hooks.mu.Lock()
+	defer d.hooks.mu.Unlock()
+	d.hooks.result = append(d.hooks.result, fn)
+}
+
+// OnError registers fn to be called when a request taken on ends without a
+// result: it went unconfirmed or unpaid, the job failed, the content
+// policy withheld the result, or the result couldn't be published.
+func (d *Dvm) OnError(fn func(req *nostr.Event, err error)) {
+	d.hooks.mu.Lock()
+	defer d.hooks.mu.Unlock()
+	d.hooks.err = append(d.hooks.err, fn)
+}
+
+// OnPayment registers fn to be called when a request is paid for, with the
+// msats received, before the job runs.
+func (d *Dvm) OnPayment(fn func(req *nostr.Event, msats int64)) {
+	d.hooks.mu.Lock()
+	defer d.hooks.mu.Unlock()
+	d.hooks.payment = append(d.hooks.payment, fn)
+}
+
+func (h *hooks) fireRequest(req *nostr.Event) {
+	h.mu.RLock()
+	fns := h.request
+	h.mu.RUnlock()
+	for _, fn := range fns {
+		callHook("OnRequest", func() { fn(req) })
+	}
+}
+
+// fireDone calls the OnResult hooks with result, or the OnError hooks if
+// err isn't nil.
+func (h *hooks) fireDone(req, result *nostr.Event, err error) {
+	if err != nil {
+		h.fireError(req, err)
+		return
+	}
+	h.mu.RLock()
+	fns := h.result
+	h.mu.RUnlock()
+	for _, fn := range fns {
+		callHook("OnResult", func() { fn(req, result) })
+	}
+}
+
+func (h *hooks) fireError(req *nostr.Event, err error) {
+	h.mu.RLock()
+	fns := h.err
+	h.mu.RUnlock()
+	for _, fn := range fns {
+		callHook("OnError", func() { fn(req, err) })
+	}
+}
+
+func (h *hooks) firePayment(req *nostr.Event, msats int64) {
+	h.mu.RLock()
+	fns := h.payment
+	h.mu.RUnlock()
+	for _, fn := range fns {
+		callHook("OnPayment", func() { fn(req, msats) })
+	}
+}
+
+// callHook calls fn, logging rather than propagating a panic, so a broken
+// hook can't take the job down with it.
+func callHook(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s hook panicked: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,110 @@
+package dvm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// hookLog records the hooks called, in order.
+type hookLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (h *hookLog) add(call string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, call)
+}
+
+// await waits for n calls and returns them.
+func (h *hookLog) await(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		calls := append([]string(nil), h.calls...)
+		h.mu.Unlock()
+		if len(calls) >= n {
+			return calls
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected %d hook calls, got %v", n, h.calls)
+	return nil
+}
+
+// register adds hooks to d that log to h.
+func (h *hookLog) register(d *Dvm) {
+	d.OnRequest(func(req *nostr.Event) { h.add("request " + req.Content) })
+	d.OnResult(func(req, result *nostr.Event) {
+		if result.Tags.GetFirst([]string{"e", req.ID}) != nil {
+			h.add("result " + req.Content)
+		}
+	})
+	d.OnError(func(req *nostr.Event, err error) { h.add("error " + req.Content + ": " + err.Error()) })
+}
+
+func TestHooks(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}))
+	var h hookLog
+	h.register(d)
+	// A panicking hook doesn't keep the others from running
+	d.OnRequest(func(*nostr.Event) { panic("broken hook") })
+
+	req := newTestRequest("20")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	calls := h.await(t, 2)
+	if calls[0] != "request 20" || calls[1] != "result 20" {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+}
+
+func TestErrorHook(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&failingScraper{err: errors.New("scraper down")}))
+	var h hookLog
+	h.register(d)
+
+	req := newTestRequest("20")
+	relay.Publish(req)
+	calls := h.await(t, 2)
+	if calls[0] != "request 20" || calls[1] != "error 20: scraper down" {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+}
+
+func TestPaymentHook(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	zapperSK := testKey()
+	zapperPK, _ := nostr.GetPublicKey(zapperSK)
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithPayments(PaymentConfig{ZapperPubKey: zapperPK, Timeout: 5 * time.Second}),
+		WithPricing(PricingConfig{KindTweetRequest: {Msats: 1000}}))
+	var h hookLog
+	h.register(d)
+	d.OnPayment(func(req *nostr.Event, msats int64) { h.add(fmt.Sprintf("payment %s %d", req.Content, msats)) })
+
+	req := newTestRequest("20")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	relay.Publish(newZapReceipt(zapperSK, req.ID, d.GetPublicKey(), "lnbc10n1pvjluez"))
+	calls := h.await(t, 3)
+	if calls[0] != "request 20" || calls[1] != "payment 20 1000" || calls[2] != "result 20" {
+		t.Errorf("unexpected hook calls %v", calls)
+	}
+}
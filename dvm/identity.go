@@ -0,0 +1,88 @@
+package dvm
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Identity is an additional DVM keypair served from the same process, sharing
+// the scraper and relay connection, e.g. a premium tier with a larger quota.
+type Identity struct {
+	Name       string
+	PrivateKey string // 64-character hex
+	Quota      QuotaConfig
+}
+
+// identity is the runtime state of one keypair the DVM answers as.
+type identity struct {
+	name     string
+	sk       string
+	pk       string
+	key      *btcec.PrivateKey // sk parsed, for signing results
+	quotaCfg QuotaConfig
+	quota    *quota
+}
+
+// primaryIdentity is the name of the identity built from NewDvm's private key.
+const primaryIdentity = "default"
+
+func newIdentity(name, sk string, quotaCfg QuotaConfig, store *Store) (*identity, error) {
+	if len(sk) != 64 {
+		return nil, fmt.Errorf("invalid private key for identity %q: must be 64 hex characters", name)
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key for identity %q: %w", name, err)
+	}
+
+	raw, _ := hex.DecodeString(sk) // valid, or GetPublicKey would have failed
+	key, _ := btcec.PrivKeyFromBytes(raw)
+	id := &identity{name: name, sk: sk, pk: pk, key: key, quotaCfg: quotaCfg}
+	if quotaCfg.Daily > 0 {
+		// The primary identity keeps the original document name so existing
+		// counters carry over
+		document := quotaDocument
+		if name != primaryIdentity {
+			document = quotaDocument + "-" + name
+		}
+		if id.quota, err = loadQuota(quotaCfg, store, document); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// route picks the identity a request is addressed to via its p tags.
+// Untagged requests go to the primary identity; requests addressed only to
+// other DVMs return nil.
+func (d *Dvm) route(evt *nostr.Event) *identity {
+	tagged := false
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		tagged = true
+		for _, id := range d.identities {
+			if id.pk == tag[1] {
+				return id
+			}
+		}
+	}
+	if tagged {
+		return nil
+	}
+	return d.identities[0]
+}
+
+// Identities returns the public key of every identity keyed by name. The
+// identity for NewDvm's private key is named "default".
+func (d *Dvm) Identities() map[string]string {
+	pubkeys := make(map[string]string, len(d.identities))
+	for _, id := range d.identities {
+		pubkeys[id.name] = id.pk
+	}
+	return pubkeys
+}
@@ -0,0 +1,69 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRequestsRouteToTaggedIdentity(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay,
+		WithScraper(&fakeScraper{}),
+		WithIdentity(Identity{Name: "premium", PrivateKey: testKey()}),
+	)
+	ids := d.Identities()
+	if ids["default"] != d.GetPublicKey() || ids["premium"] == "" {
+		t.Fatalf("unexpected identities %v", ids)
+	}
+
+	premium := newTestRequest("1")
+	premium.Tags = nostr.Tags{{"p", ids["premium"]}}
+	premium.Sign(testKey())
+	relay.Publish(premium)
+	if resp := awaitResponse(t, relay, ids["premium"], premium.ID); resp.PubKey != ids["premium"] {
+		t.Errorf("premium request answered by %s", resp.PubKey[:8])
+	}
+
+	untagged := newTestRequest("2")
+	relay.Publish(untagged)
+	awaitResponse(t, relay, d.GetPublicKey(), untagged.ID)
+
+	// Requests for some other DVM are not ours to answer
+	other := newTestRequest("3")
+	otherDvm, _ := nostr.GetPublicKey(testKey())
+	other.Tags = nostr.Tags{{"p", otherDvm}}
+	other.Sign(testKey())
+	d.handleRequest(other)
+	time.Sleep(200 * time.Millisecond)
+	for _, evt := range relay.Events() {
+		if evt.Tags.GetFirst([]string{"e", other.ID}) != nil {
+			t.Fatal("DVM answered a request addressed to another DVM")
+		}
+	}
+}
+
+// BenchmarkRoute matches a request against the DVM's identities, the last
+// of which it's addressed to.
+func BenchmarkRoute(b *testing.B) {
+	d := &Dvm{}
+	for _, name := range []string{primaryIdentity, "premium", "archive"} {
+		id, err := newIdentity(name, testKey(), QuotaConfig{}, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		d.identities = append(d.identities, id)
+	}
+	req := benchRequest()
+	req.Tags = append(req.Tags, nostr.Tag{"p", d.identities[2].pk})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if d.route(req) != d.identities[2] {
+			b.Fatal("routed to the wrong identity")
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Job request kinds. Each kind's request carries its input (a tweet ID, a
+// URL...) in the event content.
+const (
+	KindTweetRequest       = 42069
+	KindMastodonRequest    = 42070
+	KindRedditRequest      = 42071
+	KindYouTubeRequest     = 42072
+	KindWebPageRequest     = 42073
+	KindFeedRequest        = 42074
+	KindUnfurlRequest      = 42075
+	KindPDFRequest         = 42076
+	KindTranslateRequest   = 42077
+	KindSummarizeRequest   = 42078
+	KindSentimentRequest   = 42079
+	KindMirrorsRequest     = 42080
+	KindHandleRequest      = 42081
+	KindVerifyRequest      = 42082
+	KindUserArchiveRequest = 42083
+	KindFollowsRequest     = 42084
+	KindEngagementRequest  = 42085
+	KindExistenceRequest   = 42086
+	KindLongFormRequest    = 42087
+	KindMonitorRequest     = 42088
+)
+
+// ResultKind returns the kind of the results of a request of the given
+// kind. As in NIP-90, results are 1000 kinds above their requests.
+func ResultKind(requestKind int) int {
+	return requestKind + 1000
+}
+
+// jobTimeout bounds how long a single handler may work on a request.
+const jobTimeout = 2 * time.Minute
+
+// JobHandler performs one kind of job.
+type JobHandler interface {
+	// Validate rejects malformed requests before they count against quotas.
+	// Invalid requests are ignored rather than answered.
+	Validate(req *nostr.Event) error
+	// Handle performs the job and returns the result content.
+	Handle(ctx context.Context, req *nostr.Event) ([]byte, error)
+}
+
+// jobReceipt collects what a handler reports about a job besides its
+// result, such as a usage-based price.
+type jobReceipt struct {
+	amountMsats int64
+	tags        nostr.Tags // added to the result event
+	pages       int        // highest page published
+	ongoing     bool       // the job took its followUp, to publish after its result
+	policed     bool       // the job applied the content policy itself; see policeResult
+	backend     string     // what the content was fetched with, for its attestation
+	fetchedAt   time.Time
+
+	progress func(message string)
+	page     func(content []byte, tags ...nostr.Tag) error
+	followUp func(content []byte, tags ...nostr.Tag) error
+}
+
+type jobReceiptKey struct{}
+
+// chargeJob asks for msats in payment for the job running under ctx. The
+// total is sent in the NIP-90 "amount" tag of the job's result.
+func chargeJob(ctx context.Context, msats int64) {
+	if r, ok := ctx.Value(jobReceiptKey{}).(*jobReceipt); ok {
+		r.amountMsats += msats
+	}
+}
+
+// tagResult adds tags to the result of the job running under ctx.
+func tagResult(ctx context.Context, tags ...nostr.Tag) {
+	if r, ok := ctx.Value(jobReceiptKey{}).(*jobReceipt); ok {
+		r.tags = append(r.tags, tags...)
+	}
+}
+
+// policeResult applies the DVM's content policy to result for the job
+// running under ctx, sparing the result the job returns from it. Jobs that
+// render their result, say into an image, police it before, as the policy
+// can't read the rendered result and could corrupt it.
+func (d *Dvm) policeResult(ctx context.Context, kind int, result []byte) ([]byte, string) {
+	if r, ok := ctx.Value(jobReceiptKey{}).(*jobReceipt); ok {
+		r.policed = true
+	}
+	return d.policy.apply(ctx, kind, result)
+}
+
+// reportProgress tells the requester of the job running under ctx how it's
+// going, with "processing" feedback.
+func reportProgress(ctx context.Context, format string, args ...any) {
+	if r, ok := ctx.Value(jobReceiptKey{}).(*jobReceipt); ok && r.progress != nil {
+		r.progress(fmt.Sprintf(format, args...))
+	}
+}
+
+// publishPage publishes part of the result of the job running under ctx as
+// a result event of its own, for results too big for one event. Pages are
+// tagged ["page", <n>] counting from 1, and go out as soon as they're
+// published, so clients can stream them with DvmClient.Stream; the final
+// result event is tagged ["total-pages", <count>].
+func publishPage(ctx context.Context, n int, content []byte) error {
+	r, ok := ctx.Value(jobReceiptKey{}).(*jobReceipt)
+	if !ok || r.page == nil {
+		return errors.New("paged results aren't supported here")
+	}
+	if n > r.pages {
+		r.pages = n
+	}
+	return r.page(content, nostr.Tag{"page", strconv.Itoa(n)})
+}
+
+// followUp returns a function that publishes further results of the job
+// running under ctx after its handler has returned, for jobs that keep
+// watching something. It returns nil outside of a job.
+func followUp(ctx context.Context) func(content []byte, tags ...nostr.Tag) error {
+	if r, ok := ctx.Value(jobReceiptKey{}).(*jobReceipt); ok {
+		r.ongoing = true
+		return r.followUp
+	}
+	return nil
+}
+
+// defaultHandlers returns the job kinds every DVM serves unless replaced
+// with WithHandler, plus those enabled by configuring a provider.
+func (d *Dvm) defaultHandlers() map[int]JobHandler {
+	existence := &existenceHandler{d: d}
+	existence.profiles, _ = d.scraper.(ProfileScraper)
+	handlers := map[int]JobHandler{
+		KindTweetRequest:      tweetHandler{d: d, client: newFetchClient()},
+		KindMastodonRequest:   newMastodonHandler(),
+		KindRedditRequest:     newRedditHandler(),
+		KindYouTubeRequest:    newYouTubeHandler(),
+		KindWebPageRequest:    newWebPageHandler(),
+		KindFeedRequest:       newFeedHandler(),
+		KindUnfurlRequest:     newUnfurlHandler(),
+		KindPDFRequest:        newPDFHandler(),
+		KindMirrorsRequest:    &mirrorsHandler{d: d, searchRelays: d.searchRelays},
+		KindHandleRequest:     &handleLookupHandler{d: d, searchRelays: d.searchRelays},
+		KindVerifyRequest:     &verifyHandler{d: d, searchRelays: d.searchRelays},
+		KindEngagementRequest: &engagementHandler{d: d},
+		KindExistenceRequest:  existence,
+		KindLongFormRequest:   &longFormHandler{d: d, client: newFetchClient()},
+		KindMonitorRequest:    &monitorHandler{d: d},
+	}
+
+	// Sentiment analysis works offline, but uses the LLM if there is one
+	analyzer := d.analyzer
+	if analyzer == nil {
+		analyzer = lexiconAnalyzer{}
+		if d.llm != nil {
+			analyzer = &llmAnalyzer{llm: d.llm}
+		}
+	}
+	handlers[KindSentimentRequest] = &sentimentHandler{d: d, analyzer: analyzer}
+
+	if d.translator != nil {
+		handlers[KindTranslateRequest] = &translateHandler{d: d, translator: d.translator}
+	}
+	if d.llm != nil {
+		handlers[KindSummarizeRequest] = newSummarizeHandler(d, d.llm)
+	}
+	if follows, ok := d.scraper.(FollowsScraper); ok {
+		handlers[KindFollowsRequest] = &followsHandler{follows: follows}
+	}
+	// Archives are expensive to scrape, so they're only served paid up front
+	if timeline, ok := d.scraper.(TimelineScraper); ok && d.payments != nil && d.pricing[KindUserArchiveRequest].charges() {
+		handlers[KindUserArchiveRequest] = &userArchiveHandler{d: d, timeline: timeline}
+	}
+	return handlers
+}
+
+// handlerKinds returns the request kinds the DVM subscribes to.
+func (d *Dvm) handlerKinds() []int {
+	kinds := make([]int, 0, len(d.handlers))
+	for kind := range d.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Ints(kinds)
+	return kinds
+}
+
+// tweetHandler serves tweets by ID through the DVM's scraper and cache.
+// Requests may carry ["param", "ocr", "true"] to include the text in the
+// tweet's photos, and ["param", "rehost", "true"] to copy its media to the
+// DVM's upload host; see TweetResult. An ["output", <mime>] tag renders the
+// tweet as text, markdown or an image instead of JSON. With WithCrossCheck,
+// ["param", "crosscheck", "false"] serves suspicious tweets without asking
+// peers.
+type tweetHandler struct {
+	d      *Dvm
+	client *http.Client // for media
+}
+
+// tweetParams are the params of a tweet request.
+type tweetParams struct {
+	OCR        bool `param:"ocr"`
+	Rehost     bool `param:"rehost"`
+	CrossCheck bool `param:"crosscheck"`
+}
+
+func (h tweetHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		return fmt.Errorf("content is not a tweet ID")
+	}
+	if _, err := requestedOutput(req); err != nil {
+		return err
+	}
+	var params tweetParams
+	return DecodeParams(req, &params)
+}
+
+func (h tweetHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	params := tweetParams{CrossCheck: true}
+	if err := DecodeParams(req, &params); err != nil {
+		return nil, err
+	}
+	// DM results' tags aren't encrypted, so they mustn't give away the
+	// tweet
+	public := h.d.resultMode != ResultsAsDMs
+	var result []byte
+	var err error
+	backend := scraperBackend(h.d.scraper)
+	if params.OCR || params.Rehost {
+		result, err = h.d.fetchEnrichedTweet(ctx, h.client, req.Content, params.OCR, params.Rehost)
+	} else if found := h.d.relayResultFor(ctx, req.Content); found != nil {
+		// Another result is as good as a scrape, and saves one
+		result, backend = found.content, "relay:"+found.event.PubKey
+		tagResult(ctx, nostr.Tag{"e", found.event.ID, found.relay, "source"})
+	} else {
+		result, err = h.d.fetchTweetJSON(req.Content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fetchedAt, ok := h.d.cache.fetchedAt(req.Content)
+	if !ok {
+		fetchedAt = time.Now()
+	}
+	recordFetch(ctx, backend, fetchedAt)
+	var extras struct {
+		Lang        string
+		HostedMedia []HostedMedia `json:"hosted_media"`
+	}
+	if json.Unmarshal(result, &extras) == nil {
+		if extras.Lang != "" {
+			labelLanguage(ctx, extras.Lang)
+		}
+		if public {
+			tagFileMetadata(ctx, extras.HostedMedia)
+		}
+	}
+	if h.d.crossCheck != nil && params.CrossCheck {
+		h.d.crossCheckTweet(ctx, req.Content, result)
+	}
+
+	output, err := requestedOutput(req)
+	if err != nil || output == OutputJSON {
+		if public && !params.OCR && !params.Rehost {
+			// So the result can be found by tweet; see RelayLookupConfig
+			tagResult(ctx, nostr.Tag{"i", req.Content, "text"})
+		}
+		return result, err
+	}
+	// The policy checks the tweet before it's rendered, since it can't read
+	// text drawn into an image
+	result, refusal := h.d.policeResult(ctx, req.Kind, result)
+	if refusal != "" {
+		log.Printf("Refusing request %s: result violates content policy: %s", req.ID[:8], refusal)
+		return nil, &JobError{Reason: ReasonContentPolicy, Message: "Result withheld by this DVM's content policy",
+			Err: fmt.Errorf("result withheld by this DVM's content policy: %s", refusal)}
+	}
+	var tweet TweetResult
+	if err := json.Unmarshal(result, &tweet); err != nil {
+		return nil, err
+	}
+	return renderTweet(&tweet, output, h.d.tweetTemplates)
+}
+
+// TweetResult is the result of a tweet request: the tweet as scraped, plus
+// the language detected in its text (an ISO 639-1 code, omitted if unclear)
+// and whatever extras the request asked for.
+type TweetResult struct {
+	*twitterscraper.Tweet
+	Lang string `json:"lang,omitempty"`
+	// OCR is the text found in the tweet's photos, since screenshots of
+	// text are everywhere.
+	OCR []ImageText `json:"ocr,omitempty"`
+	// HostedMedia lists copies of the tweet's media on the upload host.
+	HostedMedia []HostedMedia `json:"hosted_media,omitempty"`
+}
+
+// fetchEnrichedTweet returns the tweet's JSON with the requested extras.
+func (d *Dvm) fetchEnrichedTweet(ctx context.Context, client *http.Client, tweetID string, ocr, rehost bool) ([]byte, error) {
+	cacheKey := tweetID
+	if ocr {
+		cacheKey += "+ocr"
+	}
+	if rehost {
+		cacheKey += "+rehost"
+	}
+	if cached, ok := d.cache.get(cacheKey); ok {
+		return cached, nil
+	}
+
+	tweet, err := d.fetchTweet(tweetID)
+	if err != nil {
+		return nil, err
+	}
+	result := TweetResult{Tweet: tweet, Lang: detectLanguage(tweet.Text)}
+	if ocr {
+		if result.OCR, err = d.tweetOCR(ctx, client, tweet); err != nil {
+			return nil, err
+		}
+	}
+	if rehost {
+		if result.HostedMedia, err = d.rehostMedia(ctx, client, tweet); err != nil {
+			return nil, err
+		}
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	d.cache.put(cacheKey, resultJSON)
+	return resultJSON, nil
+}
+
+// fetchTweet returns a tweet through the scraper and cache, for handlers that
+// work on a tweet's content rather than serving it as is.
+func (d *Dvm) fetchTweet(tweetID string) (*twitterscraper.Tweet, error) {
+	tweetJSON, err := d.fetchTweetJSON(tweetID)
+	if err != nil {
+		return nil, err
+	}
+	var tweet twitterscraper.Tweet
+	if err := json.Unmarshal(tweetJSON, &tweet); err != nil {
+		return nil, err
+	}
+	return &tweet, nil
+}
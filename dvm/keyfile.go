@@ -0,0 +1,153 @@
+package dvm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// keyFile is an encrypted private key at rest, a JSON object:
+//
+//	key        = PBKDF2-HMAC-SHA256(passphrase, salt, iterations, 32 bytes)
+//	ciphertext = AES-256-GCM(key, nonce, the 64 hex characters of the
+//	             private key, additional data the pubkey's hex), with the
+//	             16-byte tag appended
+//
+// with the 16-byte salt, 12-byte nonce and ciphertext base64 encoded.
+//
+// It isn't an age file or a NaCl secretbox: both need scrypt and a
+// ChaCha20 or XSalsa20 cipher from golang.org/x/crypto, a dependency the
+// process holding the keys would take on only for this. PBKDF2 and
+// AES-GCM are in Go's standard library and in most others, Python's
+// hashlib and cryptography or the browser's Web Crypto for instance, so
+// the file can be opened, or made, without this program from the
+// description above.
+type keyFile struct {
+	Version    int    `json:"version"`
+	PubKey     string `json:"pubkey"` // so the file can be told apart without the passphrase
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"` // base64, as are nonce and ciphertext
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+const (
+	keyFileVersion = 1
+	keyFileKDF     = "pbkdf2-sha256"
+	// keyFileIterations follows OWASP's advice for PBKDF2-SHA256, about
+	// half a second to unlock
+	keyFileIterations = 600000
+	// maxKeyFileIterations bounds what a keyfile may ask for, so a
+	// tampered one can't hold up starting for hours before failing
+	maxKeyFileIterations = 100 * keyFileIterations
+)
+
+// ErrWrongPassphrase is returned by DecryptKeyFile when the passphrase
+// doesn't unlock the keyfile.
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// EncryptKeyFile seals privateKey, 64 hex characters, with passphrase,
+// returning the keyfile's contents.
+func EncryptKeyFile(privateKey, passphrase string) ([]byte, error) {
+	pk, err := nostr.GetPublicKey(privateKey)
+	if err != nil || len(privateKey) != 64 {
+		return nil, fmt.Errorf("invalid private key: must be 64 hex characters")
+	}
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := keyFileCipher(passphrase, salt, keyFileIterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	kf := keyFile{
+		Version:    keyFileVersion,
+		PubKey:     pk,
+		KDF:        keyFileKDF,
+		Iterations: keyFileIterations,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		// The public key is authenticated with it, so it can't be swapped
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, []byte(privateKey), []byte(pk))),
+	}
+	return json.MarshalIndent(kf, "", "  ")
+}
+
+// DecryptKeyFile unlocks a keyfile made by EncryptKeyFile, returning the
+// private key.
+func DecryptKeyFile(data []byte, passphrase string) (string, error) {
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return "", fmt.Errorf("invalid keyfile: %w", err)
+	}
+	if kf.Version != keyFileVersion || kf.KDF != keyFileKDF {
+		return "", fmt.Errorf("unsupported keyfile version %d (%s)", kf.Version, kf.KDF)
+	}
+	salt, err := base64.StdEncoding.DecodeString(kf.Salt)
+	if err != nil {
+		return "", fmt.Errorf("invalid keyfile salt: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(kf.Nonce)
+	if err != nil {
+		return "", fmt.Errorf("invalid keyfile nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(kf.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("invalid keyfile ciphertext: %w", err)
+	}
+	if kf.Iterations < 1 || kf.Iterations > maxKeyFileIterations {
+		return "", fmt.Errorf("invalid keyfile iterations %d", kf.Iterations)
+	}
+
+	gcm, err := keyFileCipher(passphrase, salt, kf.Iterations)
+	if err != nil {
+		return "", err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return "", errors.New("invalid keyfile nonce size")
+	}
+	sk, err := gcm.Open(nil, nonce, ciphertext, []byte(kf.PubKey))
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+	return string(sk), nil
+}
+
+// KeyFilePubKey returns the public key a keyfile is for, without
+// unlocking it.
+func KeyFilePubKey(data []byte) (string, error) {
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return "", fmt.Errorf("invalid keyfile: %w", err)
+	}
+	return kf.PubKey, nil
+}
+
+func keyFileCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2Key(sha256.New, []byte(passphrase), salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+package dvm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestKeyFileRoundTrip(t *testing.T) {
+	sk := testKey()
+	data, err := EncryptKeyFile(sk, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := nostr.GetPublicKey(sk)
+	if got, err := KeyFilePubKey(data); err != nil || got != pk {
+		t.Errorf("expected the keyfile to name pubkey %s, got %s (%v)", pk, got, err)
+	}
+
+	if got, err := DecryptKeyFile(data, "correct horse battery staple"); err != nil || got != sk {
+		t.Errorf("expected the private key back, got %v", err)
+	}
+	if _, err := DecryptKeyFile(data, "incorrect horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected a wrong passphrase error, got %v", err)
+	}
+	// A keyfile asking for hours of key derivation is refused outright
+	var kf map[string]any
+	json.Unmarshal(data, &kf)
+	kf["iterations"] = 1 << 40
+	tampered, _ := json.Marshal(kf)
+	if _, err := DecryptKeyFile(tampered, "correct horse battery staple"); err == nil || errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected the iterations to be refused, got %v", err)
+	}
+
+	if _, err := EncryptKeyFile("nope", "passphrase"); err == nil {
+		t.Error("expected an invalid private key to be rejected")
+	}
+	if _, err := EncryptKeyFile(sk, ""); err == nil {
+		t.Error("expected an empty passphrase to be rejected")
+	}
+}
+
+// TestKeyFileFormat opens a keyfile by its documented layout alone, as
+// another tool would.
+func TestKeyFileFormat(t *testing.T) {
+	sk := testKey()
+	data, err := EncryptKeyFile(sk, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kf struct {
+		PubKey, KDF, Salt, Nonce, Ciphertext string
+		Iterations                           int
+	}
+	if err := json.Unmarshal(data, &kf); err != nil || kf.KDF != "pbkdf2-sha256" {
+		t.Fatalf("unexpected keyfile %s (%v)", data, err)
+	}
+	salt, _ := base64.StdEncoding.DecodeString(kf.Salt)
+	nonce, _ := base64.StdEncoding.DecodeString(kf.Nonce)
+	ciphertext, _ := base64.StdEncoding.DecodeString(kf.Ciphertext)
+	if len(salt) != 16 || len(nonce) != 12 {
+		t.Fatalf("expected a 16-byte salt and 12-byte nonce, got %d and %d", len(salt), len(nonce))
+	}
+	key, _ := pbkdf2Key(sha256.New, []byte("passphrase"), salt, kf.Iterations, 32)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	if plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(kf.PubKey)); err != nil || string(plaintext) != sk {
+		t.Errorf("expected the private key, got %v", err)
+	}
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914 section 11
+	key, err := pbkdf2Key(sha256.New, []byte("passwd"), []byte("salt"), 1, 64)
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got := hex.EncodeToString(key); err != nil || got != want {
+		t.Errorf("got %s, %v", got, err)
+	}
+	key, err = pbkdf2Key(sha256.New, []byte("Password"), []byte("NaCl"), 1000, 32)
+	if got, want := hex.EncodeToString(key), "c27dad0abae39af4ebb9965719d584e8b4eb2ee69e1fc9f8f4784d1ca68696e2"; err != nil || got != want {
+		t.Errorf("got %s, %v after 1000 iterations", got, err)
+	}
+}
@@ -0,0 +1,268 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// KeyProvider supplies the DVM's private key, 64 hex characters, from
+// wherever the operator keeps it, so it needn't sit in the environment in
+// plaintext. Deployments pick one with configuration; the built-in
+// providers read the environment, an encrypted keyfile, the OS keychain,
+// HashiCorp Vault and AWS Secrets Manager.
+type KeyProvider interface {
+	PrivateKey(ctx context.Context) (string, error)
+}
+
+// checkPrivateKey trims what a secret store hands back and checks it looks
+// like a private key, without saying what it got.
+func checkPrivateKey(source, key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if _, err := hex.DecodeString(key); err != nil || len(key) != 64 {
+		return "", fmt.Errorf("%s: not a 64-character hex private key", source)
+	}
+	return key, nil
+}
+
+// NewEnvKeyProvider returns a provider reading the private key from the
+// environment variable name.
+func NewEnvKeyProvider(name string) KeyProvider {
+	return envKeyProvider(name)
+}
+
+type envKeyProvider string
+
+func (name envKeyProvider) PrivateKey(ctx context.Context) (string, error) {
+	key := os.Getenv(string(name))
+	if key == "" {
+		return "", fmt.Errorf("%s is not set", string(name))
+	}
+	return checkPrivateKey(string(name), key)
+}
+
+// NewKeyFileProvider returns a provider unlocking the keyfile at path,
+// made by EncryptKeyFile, with the passphrase passphrase returns.
+func NewKeyFileProvider(path string, passphrase func() (string, error)) KeyProvider {
+	return &keyFileProvider{path: path, passphrase: passphrase}
+}
+
+type keyFileProvider struct {
+	path       string
+	passphrase func() (string, error)
+}
+
+func (p *keyFileProvider) PrivateKey(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+	passphrase, err := p.passphrase()
+	if err != nil {
+		return "", fmt.Errorf("reading the passphrase for %s: %w", p.path, err)
+	}
+	key, err := DecryptKeyFile(data, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("unlocking %s: %w", p.path, err)
+	}
+	return key, nil
+}
+
+// NewKeychainKeyProvider returns a provider reading the private key from
+// the macOS Keychain's generic password for service and account, with the
+// security command.
+func NewKeychainKeyProvider(service, account string) (KeyProvider, error) {
+	return newCommandKeyProvider("macOS Keychain", "security",
+		"find-generic-password", "-s", service, "-a", account, "-w")
+}
+
+// NewLibsecretKeyProvider returns a provider reading the private key from
+// the freedesktop Secret Service (GNOME Keyring, KWallet) with the
+// secret-tool command, looked up by service and account attributes, as
+// stored with `secret-tool store --label=bandita service <service>
+// account <account>`.
+func NewLibsecretKeyProvider(service, account string) (KeyProvider, error) {
+	return newCommandKeyProvider("Secret Service", "secret-tool",
+		"lookup", "service", service, "account", account)
+}
+
+func newCommandKeyProvider(store, command string, args ...string) (KeyProvider, error) {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found: %w", command, err)
+	}
+	return &commandKeyProvider{store: store, path: path, args: args}, nil
+}
+
+// commandKeyProvider runs a command that prints the private key.
+type commandKeyProvider struct {
+	store string
+	path  string
+	args  []string
+}
+
+func (p *commandKeyProvider) PrivateKey(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, p.path, p.args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %s", p.store, err, strings.TrimSpace(stderr.String()))
+	}
+	return checkPrivateKey(p.store, string(out))
+}
+
+// VaultConfig locates the private key in HashiCorp Vault.
+type VaultConfig struct {
+	Addr  string // e.g. "https://vault.example.com:8200"
+	Token string
+	// Path is the secret's API path below /v1/, e.g. "secret/data/bandita"
+	// for the KV version 2 engine mounted at secret
+	Path  string
+	Field string // of the secret holding the key, default "private_key"
+}
+
+// NewVaultKeyProvider returns a provider reading the private key from a
+// Vault KV secret, version 1 or 2.
+func NewVaultKeyProvider(cfg VaultConfig) (KeyProvider, error) {
+	if cfg.Addr == "" || cfg.Token == "" || cfg.Path == "" {
+		return nil, errors.New("vault needs an address, token and secret path")
+	}
+	if cfg.Field == "" {
+		cfg.Field = "private_key"
+	}
+	return &vaultKeyProvider{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type vaultKeyProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+func (p *vaultKeyProvider) PrivateKey(ctx context.Context) (string, error) {
+	url := strings.TrimSuffix(p.cfg.Addr, "/") + "/v1/" + strings.TrimPrefix(p.cfg.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.cfg.Token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: %s reading %s", resp.Status, p.cfg.Path)
+	}
+
+	// KV version 2 nests the secret's fields a level deeper than version 1
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&secret); err != nil {
+		return "", fmt.Errorf("vault: invalid response: %w", err)
+	}
+	fields := secret.Data
+	if nested, ok := secret.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return "", fmt.Errorf("vault: invalid secret: %w", err)
+		}
+	}
+	var key string
+	if err := json.Unmarshal(fields[p.cfg.Field], &key); err != nil || key == "" {
+		return "", fmt.Errorf("vault: secret %s has no %q field", p.cfg.Path, p.cfg.Field)
+	}
+	return checkPrivateKey("vault", key)
+}
+
+// AWSSecretsConfig locates the private key in AWS Secrets Manager.
+// Credentials default to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables, and the region to AWS_REGION.
+type AWSSecretsConfig struct {
+	SecretID string // name or ARN
+	// Field, if set, is the JSON field of the secret holding the key;
+	// otherwise the whole secret string is the key
+	Field string
+
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Endpoint        string // default https://secretsmanager.<region>.amazonaws.com
+}
+
+// NewAWSSecretsKeyProvider returns a provider reading the private key from
+// AWS Secrets Manager with GetSecretValue.
+func NewAWSSecretsKeyProvider(cfg AWSSecretsConfig) (KeyProvider, error) {
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if cfg.SecretID == "" || cfg.Region == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("AWS Secrets Manager needs a secret ID, region and credentials")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://secretsmanager." + cfg.Region + ".amazonaws.com"
+	}
+	return &awsSecretsKeyProvider{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type awsSecretsKeyProvider struct {
+	cfg    AWSSecretsConfig
+	client *http.Client
+}
+
+func (p *awsSecretsKeyProvider) PrivateKey(ctx context.Context) (string, error) {
+	body, _ := json.Marshal(map[string]string{"SecretId": p.cfg.SecretID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if p.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.cfg.SessionToken)
+	}
+	signV4(req, body, p.cfg.AccessKeyID, p.cfg.SecretAccessKey, p.cfg.Region, "secretsmanager", time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("AWS Secrets Manager: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("AWS Secrets Manager: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("AWS Secrets Manager: %s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	var secret struct {
+		SecretString string
+	}
+	if err := json.Unmarshal(raw, &secret); err != nil {
+		return "", fmt.Errorf("AWS Secrets Manager: invalid response: %w", err)
+	}
+	key := secret.SecretString
+	if p.cfg.Field != "" {
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(key), &fields); err != nil || fields[p.cfg.Field] == "" {
+			return "", fmt.Errorf("AWS Secrets Manager: secret %s has no %q field", p.cfg.SecretID, p.cfg.Field)
+		}
+		key = fields[p.cfg.Field]
+	}
+	return checkPrivateKey("AWS Secrets Manager", key)
+}
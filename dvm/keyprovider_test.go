@@ -0,0 +1,130 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvAndKeyFileKeyProviders(t *testing.T) {
+	sk := testKey()
+	t.Setenv("TEST_DVM_KEY", sk+"\n")
+	if got, err := NewEnvKeyProvider("TEST_DVM_KEY").PrivateKey(context.Background()); err != nil || got != sk {
+		t.Errorf("expected the key from the environment, got %v", err)
+	}
+	t.Setenv("TEST_DVM_KEY", "hunter2")
+	if _, err := NewEnvKeyProvider("TEST_DVM_KEY").PrivateKey(context.Background()); err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("expected a non-key rejected without echoing it, got %v", err)
+	}
+
+	data, err := EncryptKeyFile(sk, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	os.WriteFile(path, data, 0o600)
+	p := NewKeyFileProvider(path, func() (string, error) { return "passphrase", nil })
+	if got, err := p.PrivateKey(context.Background()); err != nil || got != sk {
+		t.Errorf("expected the key from the keyfile, got %v", err)
+	}
+}
+
+func TestKeychainKeyProvider(t *testing.T) {
+	// A stand-in for macOS's security command that records its arguments
+	dir := t.TempDir()
+	sk := testKey()
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\necho " + sk + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "security"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	p, err := NewKeychainKeyProvider("bandita", "dvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.PrivateKey(context.Background()); err != nil || got != sk {
+		t.Fatalf("expected the key from the keychain, got %v", err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "find-generic-password -s bandita -a dvm -w\n" {
+		t.Errorf("unexpected arguments %q", args)
+	}
+
+	if _, err := NewLibsecretKeyProvider("bandita", "dvm"); err == nil {
+		t.Error("expected an error without secret-tool installed")
+	}
+}
+
+func TestVaultKeyProvider(t *testing.T) {
+	sk := testKey()
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/bandita": // KV version 2
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"data": map[string]string{"private_key": sk}, "metadata": map[string]any{"version": 3}}})
+		case "/v1/kv/bandita": // KV version 1
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"nsec": sk}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+
+	for _, cfg := range []VaultConfig{
+		{Addr: vault.URL, Token: "s.token", Path: "secret/data/bandita"},
+		{Addr: vault.URL, Token: "s.token", Path: "kv/bandita", Field: "nsec"},
+	} {
+		p, err := NewVaultKeyProvider(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := p.PrivateKey(context.Background()); err != nil || got != sk {
+			t.Errorf("%s: expected the key from vault, got %v", cfg.Path, err)
+		}
+	}
+
+	p, _ := NewVaultKeyProvider(VaultConfig{Addr: vault.URL, Token: "wrong", Path: "secret/data/bandita"})
+	if _, err := p.PrivateKey(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected vault's refusal, got %v", err)
+	}
+}
+
+func TestAWSSecretsKeyProvider(t *testing.T) {
+	sk := testKey()
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&body)
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || body.SecretId != "bandita/dvm" ||
+			!strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") ||
+			!strings.Contains(auth, "x-amz-security-token") {
+			http.Error(w, `{"__type":"AccessDeniedException"}`, http.StatusBadRequest)
+			return
+		}
+		secret, _ := json.Marshal(map[string]string{"private_key": sk})
+		json.NewEncoder(w).Encode(map[string]string{"Name": "bandita/dvm", "SecretString": string(secret)})
+	}))
+	defer aws.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	p, err := NewAWSSecretsKeyProvider(AWSSecretsConfig{SecretID: "bandita/dvm", Field: "private_key",
+		Region: "eu-west-1", Endpoint: aws.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.PrivateKey(context.Background()); err != nil || got != sk {
+		t.Errorf("expected the key from Secrets Manager, got %v", err)
+	}
+}
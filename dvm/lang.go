@@ -0,0 +1,136 @@
+package dvm
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Language detection for results, so downstream DVMs can route work by
+// language without analyzing the content again. It's deliberately simple:
+// the writing system settles most non-Latin scripts, and common function
+// words tell the Latin-script languages apart. Anything unclear is left
+// undetected rather than guessed.
+
+// scriptLanguages maps scripts used by a single major language to it.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Bengali, "bn"},
+	{unicode.Tamil, "ta"},
+	{unicode.Georgian, "ka"},
+	{unicode.Armenian, "hy"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+}
+
+// functionWords are frequent words distinctive enough to identify a language.
+var functionWords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "this", "that", "with", "for", "you", "not", "have", "it's", "of", "to", "be", "what", "just"},
+	"es": {"el", "los", "las", "es", "y", "que", "con", "por", "para", "una", "pero", "como", "muy", "está", "del", "se", "lo"},
+	"fr": {"le", "les", "est", "et", "que", "avec", "pour", "une", "mais", "dans", "pas", "des", "du", "je", "c'est", "vous", "sur"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "ein", "eine", "auch", "ich", "sie", "wir", "auf", "für", "zu", "den"},
+	"pt": {"os", "as", "é", "e", "que", "com", "por", "para", "uma", "mas", "não", "muito", "está", "do", "da", "em", "você"},
+	"it": {"il", "gli", "è", "e", "che", "con", "per", "una", "ma", "non", "sono", "della", "anche", "del", "di", "questo", "molto"},
+	"nl": {"de", "het", "een", "en", "is", "niet", "met", "voor", "maar", "ook", "zijn", "dat", "van", "ik", "op", "je", "wat"},
+	"id": {"yang", "dan", "ini", "itu", "tidak", "dengan", "untuk", "ada", "saya", "di", "ke", "dari", "akan", "juga", "kita", "sudah"},
+	"tr": {"ve", "bir", "bu", "için", "ile", "değil", "çok", "da", "de", "ne", "ama", "gibi", "daha", "olarak", "ben", "var"},
+	"pl": {"nie", "się", "jest", "że", "na", "to", "jak", "ale", "czy", "tak", "już", "tylko", "dla", "przez", "jestem", "oraz"},
+}
+
+// Languages sharing a script with the one scriptLanguages names, told apart
+// by letters only they use.
+var (
+	ukrainianLetters = "іїєґ"
+	persianLetters   = "پچژگ"
+)
+
+// unlinkedText drops links, mentions and hashtags, which say nothing about
+// the language a post is written in.
+var unlinkedText = regexp.MustCompile(`https?://\S+|[@#][\p{L}\p{N}_]+`)
+
+// detectLanguage returns the ISO 639-1 code of text's language, or "" if
+// it can't tell.
+func detectLanguage(text string) string {
+	text = strings.ToLower(unlinkedText.ReplaceAllString(text, " "))
+
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Kana marks Japanese even among many more Han characters
+	if counts["ja"] > 0 && counts["ja"]+counts["zh"] > letters/2 {
+		return "ja"
+	}
+	for lang, n := range counts {
+		if n <= letters/2 {
+			continue
+		}
+		switch {
+		case lang == "ru" && strings.ContainsAny(text, ukrainianLetters):
+			return "uk"
+		case lang == "ar" && strings.ContainsAny(text, persianLetters):
+			return "fa"
+		}
+		return lang
+	}
+
+	// Latin script: score function words
+	scores := make(map[string]int)
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for _, word := range words {
+		for lang, list := range functionWords {
+			for _, w := range list {
+				if word == w {
+					scores[lang]++
+					break
+				}
+			}
+		}
+	}
+	best, bestScore, tied := "", 0, false
+	for lang, score := range scores {
+		if score > bestScore {
+			best, bestScore, tied = lang, score, false
+		} else if score == bestScore {
+			tied = true
+		}
+	}
+	if bestScore < 2 || tied {
+		return ""
+	}
+	return best
+}
+
+// labelLanguage records lang as the language of the job running under ctx.
+// The result is labeled with it in NIP-32 "L"/"l" tags.
+func labelLanguage(ctx context.Context, lang string) {
+	tagResult(ctx, nostr.Tag{"L", "ISO-639-1"}, nostr.Tag{"l", lang, "ISO-639-1"})
+}
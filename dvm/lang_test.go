@@ -0,0 +1,66 @@
+package dvm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bandita/internal/relaytest"
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	for text, want := range map[string]string{
+		"This is the best thing that happened to me this year":    "en",
+		"Esto es lo que pasa cuando no se para a pensar":          "es",
+		"C'est pas mal, mais je préfère le chocolat avec du café": "fr",
+		"Das ist nicht das, was ich wollte, aber auch gut":        "de",
+		"Não sei, mas você está muito bem com isso":               "pt",
+		"Questo non è il momento, ma sono molto felice":           "it",
+		"Ik weet niet wat het is, maar het is mooi":               "nl",
+		"Привет, как дела? https://example.com/привет":            "ru",
+		"Привіт, як справи? Їжак":                                 "uk",
+		"今日はいい天気ですね":                                              "ja",
+		"今天天气很好":                                                  "zh",
+		"오늘 날씨가 좋네요":                                              "ko",
+		"Καλημέρα σε όλους":                                       "el",
+		"مرحبا بالعالم":                                           "ar",
+		"Running bitcoin":                                         "",
+		"@halfin #bitcoin https://bitcoin.org 🚀":                  "",
+		"": "",
+	} {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestTweetResultLanguage(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	scraper := proofScraper{"1": {ID: "1", Username: "halfin", Text: "Das ist nicht das, was ich wollte"}}
+	d := startTestDvm(t, relay, WithScraper(scraper))
+
+	req := newTestRequest("1")
+	d.handleRequest(req)
+	resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	var tweet TweetResult
+	if err := json.Unmarshal([]byte(resp.Content), &tweet); err != nil {
+		t.Fatal(err)
+	}
+	if tweet.Lang != "de" {
+		t.Errorf("expected lang de, got %q", tweet.Lang)
+	}
+	if resp.Tags.GetFirst([]string{"L", "ISO-639-1"}) == nil || resp.Tags.GetFirst([]string{"l", "de", "ISO-639-1"}) == nil {
+		t.Errorf("missing language label tags: %v", resp.Tags)
+	}
+
+	// Tweets in no clear language aren't labeled
+	scraper["2"] = &twitterscraper.Tweet{ID: "2", Username: "halfin", Text: "Running bitcoin"}
+	req = newTestRequest("2")
+	d.handleRequest(req)
+	resp = awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if resp.Tags.GetFirst([]string{"l"}) != nil {
+		t.Errorf("unexpected language label: %v", resp.Tags)
+	}
+}
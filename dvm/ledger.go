@@ -0,0 +1,138 @@
+package dvm
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LedgerEntry records a payment received for a job, or a refund of one,
+// whose Sats are negative.
+type LedgerEntry struct {
+	JobID     string    `json:"job_id"`
+	Requester string    `json:"requester"`
+	Kind      int       `json:"kind"`
+	Sats      int64     `json:"sats"`
+	At        time.Time `json:"at"`
+	Refund    bool      `json:"refund,omitempty"`
+}
+
+// Ledger is an append-only record of sats received, kept in the store.
+type Ledger struct {
+	store *Store
+}
+
+const ledgerLog = "ledger"
+
+// NewLedger returns a ledger backed by store.
+func NewLedger(store *Store) *Ledger {
+	return &Ledger{store: store}
+}
+
+// Record appends a payment to the ledger.
+func (l *Ledger) Record(entry LedgerEntry) error {
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	entry.At = entry.At.UTC()
+	return l.store.Append(ledgerLog, entry)
+}
+
+// Entries returns all recorded payments between since and until (zero values
+// leave that end open), oldest first.
+func (l *Ledger) Entries(since, until time.Time) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := l.store.Scan(ledgerLog, func(line []byte) error {
+		var entry LedgerEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("corrupt ledger entry: %w", err)
+		}
+		if !since.IsZero() && entry.At.Before(since) {
+			return nil
+		}
+		if !until.IsZero() && !entry.At.Before(until) {
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Ways to group earnings in a summary.
+const (
+	ByDay       = "day"
+	ByRequester = "requester"
+	ByJob       = "job"
+)
+
+// EarningsRow is one group in an earnings summary.
+type EarningsRow struct {
+	Key  string `json:"key"`
+	Jobs int    `json:"jobs"`
+	Sats int64  `json:"sats"`
+}
+
+// SummarizeEarnings totals entries grouped by day, requester, or job, sorted by key.
+func SummarizeEarnings(entries []LedgerEntry, by string) ([]EarningsRow, error) {
+	var keyOf func(LedgerEntry) string
+	switch by {
+	case ByDay:
+		keyOf = func(e LedgerEntry) string { return e.At.Format("2006-01-02") }
+	case ByRequester:
+		keyOf = func(e LedgerEntry) string { return e.Requester }
+	case ByJob:
+		keyOf = func(e LedgerEntry) string { return e.JobID }
+	default:
+		return nil, fmt.Errorf("unknown grouping %q: use %s, %s, or %s", by, ByDay, ByRequester, ByJob)
+	}
+
+	index := make(map[string]int)
+	var rows []EarningsRow
+	for _, e := range entries {
+		key := keyOf(e)
+		i, ok := index[key]
+		if !ok {
+			i = len(rows)
+			index[key] = i
+			rows = append(rows, EarningsRow{Key: key})
+		}
+		if !e.Refund {
+			rows[i].Jobs++
+		}
+		rows[i].Sats += e.Sats
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+	return rows, nil
+}
+
+// WriteLedgerCSV writes entries as CSV with a header row.
+func WriteLedgerCSV(w io.Writer, entries []LedgerEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"at", "job_id", "requester", "kind", "sats"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.At.Format(time.RFC3339),
+			e.JobID,
+			e.Requester,
+			strconv.Itoa(e.Kind),
+			strconv.FormatInt(e.Sats, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteLedgerJSON writes entries as an indented JSON array.
+func WriteLedgerJSON(w io.Writer, entries []LedgerEntry) error {
+	if entries == nil {
+		entries = []LedgerEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
@@ -0,0 +1,55 @@
+package dvm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLedgerSummaryAndExport(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := NewLedger(store)
+
+	day1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	for _, e := range []LedgerEntry{
+		{JobID: "job1", Requester: "alice", Kind: 42069, Sats: 10, At: day1},
+		{JobID: "job2", Requester: "bob", Kind: 42069, Sats: 25, At: day1},
+		{JobID: "job3", Requester: "alice", Kind: 42069, Sats: 10, At: day2},
+	} {
+		if err := ledger.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ledger.Entries(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := SummarizeEarnings(entries, ByRequester)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EarningsRow{{Key: "alice", Jobs: 2, Sats: 20}, {Key: "bob", Jobs: 1, Sats: 25}}
+	if len(rows) != len(want) || rows[0] != want[0] || rows[1] != want[1] {
+		t.Errorf("got %+v, want %+v", rows, want)
+	}
+
+	onlyDay2, _ := ledger.Entries(day2.Truncate(24*time.Hour), time.Time{})
+	if len(onlyDay2) != 1 || onlyDay2[0].JobID != "job3" {
+		t.Errorf("date filter returned %+v", onlyDay2)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteLedgerCSV(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[1] != "2024-03-01T12:00:00Z,job1,alice,42069,10" {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+}
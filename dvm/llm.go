@@ -0,0 +1,131 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// LLMConfig configures the language model behind LLM-powered jobs such as
+// KindSummarizeRequest.
+type LLMConfig struct {
+	// Provider is "openai" for any OpenAI-compatible chat completions API
+	// (OpenAI, OpenRouter, vLLM, llama.cpp...) or "ollama" for a local
+	// Ollama server.
+	Provider string
+	Endpoint string // defaults to the provider's usual address
+	APIKey   string // sent as a bearer token if set
+	Model    string
+
+	// MaxTokens caps each completion (default 1024).
+	MaxTokens int
+
+	// PricePerKTokens is charged per thousand tokens, prompt and completion
+	// combined, in millisats. Zero makes LLM jobs free.
+	PricePerKTokens int64
+}
+
+func (c LLMConfig) withDefaults() LLMConfig {
+	c.Provider = strings.ToLower(c.Provider)
+	if c.Endpoint == "" {
+		switch c.Provider {
+		case "openai":
+			c.Endpoint = "https://api.openai.com/v1"
+		case "ollama":
+			c.Endpoint = "http://localhost:11434"
+		}
+	}
+	c.Endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	if c.MaxTokens <= 0 {
+		c.MaxTokens = 1024
+	}
+	return c
+}
+
+// llmUsage is the token count of one completion, as the backend reports it.
+type llmUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// llmClient calls the configured chat API.
+type llmClient struct {
+	cfg    LLMConfig
+	client *http.Client
+}
+
+func newLLMClient(cfg LLMConfig) (*llmClient, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Provider != "openai" && cfg.Provider != "ollama" {
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+	if cfg.Model == "" {
+		return nil, errors.New("LLM model is required")
+	}
+	if cfg.PricePerKTokens < 0 {
+		return nil, errors.New("LLM price must not be negative")
+	}
+	// Local models can be slow; the job timeout still bounds each call
+	return &llmClient{cfg: cfg, client: &http.Client{Timeout: jobTimeout}}, nil
+}
+
+// complete runs a single-turn chat with a system prompt.
+func (c *llmClient) complete(ctx context.Context, system, prompt string) (string, llmUsage, error) {
+	messages := []map[string]string{
+		{"role": "system", "content": system},
+		{"role": "user", "content": prompt},
+	}
+	header := make(http.Header)
+	if c.cfg.APIKey != "" {
+		header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+
+	if c.cfg.Provider == "ollama" {
+		body := map[string]any{
+			"model":    c.cfg.Model,
+			"messages": messages,
+			"stream":   false,
+			"options":  map[string]any{"num_predict": c.cfg.MaxTokens},
+		}
+		var resp struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			PromptEvalCount int `json:"prompt_eval_count"`
+			EvalCount       int `json:"eval_count"`
+		}
+		if err := postJSON(ctx, c.client, c.cfg.Endpoint+"/api/chat", header, body, &resp); err != nil {
+			return "", llmUsage{}, err
+		}
+		return strings.TrimSpace(resp.Message.Content), llmUsage{resp.PromptEvalCount, resp.EvalCount}, nil
+	}
+
+	body := map[string]any{
+		"model":      c.cfg.Model,
+		"messages":   messages,
+		"max_tokens": c.cfg.MaxTokens,
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage llmUsage `json:"usage"`
+	}
+	if err := postJSON(ctx, c.client, c.cfg.Endpoint+"/chat/completions", header, body, &resp); err != nil {
+		return "", llmUsage{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return "", llmUsage{}, errors.New("LLM returned no choices")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), resp.Usage, nil
+}
+
+// price returns the charge for usage in millisats, rounding up.
+func (c *llmClient) price(usage llmUsage) int64 {
+	tokens := int64(usage.PromptTokens + usage.CompletionTokens)
+	return (tokens*c.cfg.PricePerKTokens + 999) / 1000
+}
@@ -0,0 +1,136 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lnurlScheme is the scheme lightning addresses are resolved over; tests
+// use plain http.
+var lnurlScheme = "https"
+
+// errUnverifiable is returned by an invoicer whose invoices can't be checked
+// for payment.
+var errUnverifiable = errors.New("payments to this invoicer can't be verified")
+
+// lnurlPayee makes invoices for a lightning address (LUD-16) through its
+// LNURL-pay endpoint, and checks them with the verify URL (LUD-21) the
+// endpoint returns alongside each one.
+type lnurlPayee struct {
+	address string
+	url     string // the LNURL-pay endpoint the address resolves to
+	client  *http.Client
+}
+
+// parseLightningAddress parses a user@domain lightning address.
+func parseLightningAddress(address string) (*lnurlPayee, error) {
+	user, domain, ok := strings.Cut(address, "@")
+	if !ok || user == "" || strings.ContainsAny(user, "/?#: ") || !validHostPort(domain) {
+		return nil, fmt.Errorf("invalid lightning address %q", address)
+	}
+	return &lnurlPayee{
+		address: address,
+		url:     fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", lnurlScheme, domain, url.PathEscape(user)),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// validHostPort reports whether s is a host with an optional port.
+func validHostPort(s string) bool {
+	u, err := url.Parse("//" + s)
+	return err == nil && u.Host == s && u.Hostname() != ""
+}
+
+// lnurlStatus is the error part of an LNURL response.
+type lnurlStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func (s lnurlStatus) err() error {
+	if strings.EqualFold(s.Status, "ERROR") {
+		return fmt.Errorf("LNURL error: %s", s.Reason)
+	}
+	return nil
+}
+
+// invoice fetches an invoice for msats from the address's callback. The
+// invoice's description is fixed by the address, so description is unused.
+func (p *lnurlPayee) invoice(ctx context.Context, msats int64, _ string, _ time.Duration) (*jobInvoice, error) {
+	bolt11, verify, err := p.requestInvoice(ctx, msats)
+	if err != nil {
+		return nil, err
+	}
+	if verify == "" {
+		return nil, fmt.Errorf("%s: %w", p.address, errUnverifiable)
+	}
+	return &jobInvoice{bolt11: bolt11, ref: verify}, nil
+}
+
+// requestInvoice asks the address's callback for an invoice of msats,
+// returning it with its verify URL, if the callback gave one.
+func (p *lnurlPayee) requestInvoice(ctx context.Context, msats int64) (bolt11, verify string, err error) {
+	var params struct {
+		lnurlStatus
+		Tag         string `json:"tag"`
+		Callback    string `json:"callback"`
+		MinSendable int64  `json:"minSendable"`
+		MaxSendable int64  `json:"maxSendable"`
+	}
+	if err := fetchJSON(ctx, p.client, p.url, "", &params); err != nil {
+		return "", "", err
+	}
+	if err := params.err(); err != nil {
+		return "", "", err
+	}
+	if params.Tag != "payRequest" || params.Callback == "" {
+		return "", "", fmt.Errorf("%s isn't an LNURL-pay endpoint", p.address)
+	}
+	if msats < params.MinSendable || params.MaxSendable > 0 && msats > params.MaxSendable {
+		return "", "", fmt.Errorf("%s takes %d to %d msats, not %d", p.address, params.MinSendable, params.MaxSendable, msats)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid LNURL callback: %w", err)
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(msats, 10))
+	callback.RawQuery = query.Encode()
+	var inv struct {
+		lnurlStatus
+		PR     string `json:"pr"`
+		Verify string `json:"verify"`
+	}
+	if err := fetchJSON(ctx, p.client, callback.String(), "", &inv); err != nil {
+		return "", "", err
+	}
+	if err := inv.err(); err != nil {
+		return "", "", err
+	}
+	if inv.PR == "" {
+		return "", "", errors.New("LNURL callback returned no invoice")
+	}
+	return inv.PR, inv.Verify, nil
+}
+
+// paid asks the invoice's verify URL whether it's been settled.
+func (p *lnurlPayee) paid(ctx context.Context, inv *jobInvoice) (bool, error) {
+	var status struct {
+		lnurlStatus
+		Settled bool `json:"settled"`
+	}
+	if err := fetchJSON(ctx, p.client, inv.ref, "", &status); err != nil {
+		return false, err
+	}
+	if err := status.err(); err != nil {
+		return false, err
+	}
+	return status.Settled, nil
+}
@@ -0,0 +1,133 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeLNURL serves a lightning address whose invoices are paid by calling
+// Pay, with verify URLs unless noVerify is set.
+type fakeLNURL struct {
+	*httptest.Server
+	noVerify bool
+
+	mu      sync.Mutex
+	settled map[string]bool // by bolt11
+}
+
+func newFakeLNURL(t *testing.T) *fakeLNURL {
+	l := &fakeLNURL{settled: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/lnurlp/dvm", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"tag": "payRequest", "callback": l.URL + "/callback?user=dvm",
+			"minSendable": 1000, "maxSendable": 100000, "metadata": `[["text/plain","dvm"]]`,
+		})
+	})
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		msats, _ := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+		l.mu.Lock()
+		bolt11 := fmt.Sprintf("lnbc%dn1fake%d", msats/100, len(l.settled))
+		l.settled[bolt11] = false
+		l.mu.Unlock()
+		resp := map[string]any{"pr": bolt11, "routes": []any{}}
+		if !l.noVerify {
+			resp["verify"] = l.URL + "/verify/" + bolt11
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/verify/", func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		settled, ok := l.settled[strings.TrimPrefix(r.URL.Path, "/verify/")]
+		l.mu.Unlock()
+		if !ok {
+			json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": "Not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "OK", "settled": settled})
+	})
+	l.Server = httptest.NewServer(mux)
+	t.Cleanup(l.Close)
+
+	scheme := lnurlScheme
+	lnurlScheme = "http"
+	t.Cleanup(func() { lnurlScheme = scheme })
+	return l
+}
+
+// address returns the fake's lightning address.
+func (l *fakeLNURL) address() string {
+	return "dvm@" + strings.TrimPrefix(l.URL, "http://")
+}
+
+func (l *fakeLNURL) Pay(ctx context.Context, dvmPubKey string, req *nostr.Event, msats int64, bolt11 string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.settled[bolt11]; !ok {
+		return fmt.Errorf("unknown invoice %q", bolt11)
+	}
+	l.settled[bolt11] = true
+	return nil
+}
+
+func TestParseLightningAddress(t *testing.T) {
+	p, err := parseLightningAddress("dvm@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.url != "https://example.com/.well-known/lnurlp/dvm" {
+		t.Errorf("unexpected LNURL-pay endpoint %s", p.url)
+	}
+	for _, bad := range []string{"example.com", "@example.com", "dvm@", "dvm@exa/mple.com", "d/vm@example.com", "dvm@example.com?x"} {
+		if _, err := parseLightningAddress(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLightningAddressPayments(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	lnurl := newFakeLNURL(t)
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithPayments(PaymentConfig{LightningAddress: lnurl.address(), Timeout: 5 * time.Second}),
+		WithPricing(PricingConfig{KindTweetRequest: {Msats: 3000}}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := client.RunPipeline(ctx, Pipeline{
+		Steps: []PipelineStep{{DVM: d.GetPublicKey(), Kind: KindTweetRequest, MaxMsats: 3000}},
+		Payer: lnurl,
+	}, "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Steps[0].PaidMsats != 3000 || !strings.Contains(res.Output, "Running bitcoin") {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	// Addresses without verify URLs can't be used on their own
+	lnurl.noVerify = true
+	if _, err := d.invoicer.invoice(ctx, 3000, "", time.Minute); !errors.Is(err, errUnverifiable) {
+		t.Errorf("expected an unverifiable invoice, got %v", err)
+	}
+}
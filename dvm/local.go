@@ -0,0 +1,284 @@
+package dvm
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/websocket"
+)
+
+// LocalAPIConfig configures a WebSocket endpoint on which processes on the
+// same machine submit jobs straight to the DVM, skipping the round trip
+// through public relays.
+//
+// The endpoint speaks enough of the relay protocol (NIP-01) that DvmClient
+// can use it as its relay: clients send job requests as EVENT messages and
+// REQ the responses. The feedback and results of jobs submitted locally,
+// including progress and pages as they're published, go only to the
+// connection that submitted them, never to relays.
+type LocalAPIConfig struct {
+	// Addr is the loopback address to listen on, e.g. "127.0.0.1:7777".
+	Addr string
+	// Token, if set, must be given as "Authorization: Bearer <token>" or a
+	// token query parameter.
+	Token string
+	// AllowedOrigins are the web origins, e.g. "http://localhost:3000",
+	// whose pages may connect. Browsers send the page's origin with the
+	// handshake, and any page can open a WebSocket to a loopback address,
+	// so connections with an origin not listed are refused; clients
+	// outside a browser send none.
+	AllowedOrigins []string
+}
+
+// localAPI is the DVM's local WebSocket endpoint.
+type localAPI struct {
+	d   *Dvm
+	cfg LocalAPIConfig
+	ln  net.Listener
+	srv *http.Server
+
+	// requests passes submitted jobs to Run, which takes them like those
+	// from relays
+	requests chan *nostr.Event
+
+	// jobs routes responses to the clients that submitted the jobs, until
+	// the jobs finish; see forget
+	mu   sync.Mutex
+	jobs map[string]*localConn // by job request ID; nil once disconnected
+}
+
+// localConn is a connected local client.
+type localConn struct {
+	ws *websocket.Conn
+
+	mu   sync.Mutex // guards writes and subs
+	subs map[string]nostr.Filters
+}
+
+func newLocalAPI(d *Dvm, cfg LocalAPIConfig) (*localAPI, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local API address: %w", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("local API must listen on a loopback address, not %q", host)
+	}
+	// Bound now, so an address in use fails NewDvm, and the address
+	// listened on, say for port 0, is known before Run
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("local API: %w", err)
+	}
+	l := &localAPI{d: d, cfg: cfg, ln: ln, requests: make(chan *nostr.Event), jobs: make(map[string]*localConn)}
+	l.srv = &http.Server{Handler: &websocket.Server{Handshake: l.handshake, Handler: l.serve}}
+	return l, nil
+}
+
+// start serves on the listener until stop.
+func (l *localAPI) start() {
+	go func() {
+		if err := l.srv.Serve(l.ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Local API stopped: %v", err)
+		}
+	}()
+	log.Printf("Local API listening on ws://%s", l.ln.Addr())
+}
+
+func (l *localAPI) stop() {
+	l.srv.Close()
+	l.ln.Close() // in case it was never served
+}
+
+// handshake admits local clients from allowed origins, with the token if
+// there is one.
+func (l *localAPI) handshake(_ *websocket.Config, r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" && !l.allowsOrigin(origin) {
+		return fmt.Errorf("origin %q not allowed", origin)
+	}
+	if l.cfg.Token == "" {
+		return nil
+	}
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(l.cfg.Token)) != 1 {
+		return errors.New("bad token")
+	}
+	return nil
+}
+
+func (l *localAPI) allowsOrigin(origin string) bool {
+	for _, allowed := range l.cfg.AllowedOrigins {
+		if strings.EqualFold(origin, strings.TrimSuffix(allowed, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *localAPI) serve(ws *websocket.Conn) {
+	c := &localConn{ws: ws, subs: make(map[string]nostr.Filters)}
+	defer func() {
+		// The connection's jobs still run, but their results go nowhere:
+		// they're private to the local client, not for relays
+		l.mu.Lock()
+		for id, conn := range l.jobs {
+			if conn == c {
+				l.jobs[id] = nil
+			}
+		}
+		l.mu.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		switch env := nostr.ParseMessage([]byte(msg)).(type) {
+		case *nostr.EventEnvelope:
+			evt := env.Event
+			if reason := l.check(&evt); reason != "" {
+				c.send(nostr.OKEnvelope{EventID: evt.ID, OK: false, Reason: &reason})
+				continue
+			}
+			l.mu.Lock()
+			l.jobs[evt.ID] = c
+			l.mu.Unlock()
+			log.Printf("Local API received request %s", evt.ID[:8])
+			// Run answers it, once it has checked it as it does requests
+			// from relays
+			select {
+			case l.requests <- &evt:
+			case <-l.d.done:
+				return
+			}
+		case *nostr.ReqEnvelope:
+			c.mu.Lock()
+			c.subs[env.SubscriptionID] = env.Filters
+			c.mu.Unlock()
+			c.send(nostr.EOSEEnvelope(env.SubscriptionID))
+		case *nostr.CloseEnvelope:
+			c.mu.Lock()
+			delete(c.subs, string(*env))
+			c.mu.Unlock()
+		}
+	}
+}
+
+// check returns why a submitted event can't run as a job, or "".
+func (l *localAPI) check(evt *nostr.Event) string {
+	if !verifyEvent(evt) {
+		return "invalid: bad signature"
+	}
+	if _, ok := l.d.handlers[evt.Kind]; !ok {
+		return fmt.Sprintf("invalid: this DVM doesn't serve kind %d", evt.Kind)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, dup := l.jobs[evt.ID]; dup {
+		return "duplicate: already submitted"
+	}
+	return ""
+}
+
+// incoming returns the channel of jobs submitted locally, or nil without
+// a local API.
+func (l *localAPI) incoming() <-chan *nostr.Event {
+	if l == nil {
+		return nil
+	}
+	return l.requests
+}
+
+// accept tells the client that submitted evt it's been taken.
+func (l *localAPI) accept(evt *nostr.Event) {
+	if c := l.conn(evt.ID); c != nil {
+		c.send(nostr.OKEnvelope{EventID: evt.ID, OK: true})
+	}
+}
+
+// refuse tells the client that submitted evt it won't run, and forgets it.
+func (l *localAPI) refuse(evt *nostr.Event, reason string) {
+	c := l.conn(evt.ID)
+	l.forget(evt.ID)
+	if c != nil {
+		c.send(nostr.OKEnvelope{EventID: evt.ID, OK: false, Reason: &reason})
+	}
+}
+
+// forget stops routing responses to evt's job once they're all out, by
+// the time its pipeline has run or it has been turned away with error
+// feedback. Ongoing jobs are never forgotten, so their follow-ups can't
+// leak to relays after the client has gone.
+func (l *localAPI) forget(id string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.jobs, id)
+	l.mu.Unlock()
+}
+
+func (l *localAPI) conn(id string) *localConn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.jobs[id]
+}
+
+// deliver sends evt to the local client whose job it responds to,
+// reporting whether it was one.
+func (l *localAPI) deliver(evt *nostr.Event) bool {
+	if l == nil {
+		return false
+	}
+	id := tagValue(evt.Tags, "e")
+	l.mu.Lock()
+	c, ok := l.jobs[id]
+	l.mu.Unlock()
+	if !ok {
+		return false
+	}
+	// Error feedback is the last a job sends, including one turned away
+	// before it ran
+	if evt.Kind == KindJobFeedback && tagValue(evt.Tags, "status") == StatusError {
+		defer l.forget(id)
+	}
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	var ids []string
+	for id, filters := range c.subs {
+		if filters.Match(evt) {
+			ids = append(ids, id)
+		}
+	}
+	c.mu.Unlock()
+	for _, id := range ids {
+		id := id
+		c.send(nostr.EventEnvelope{SubscriptionID: &id, Event: *evt})
+	}
+	return true
+}
+
+func (c *localConn) send(env json.Marshaler) {
+	b, err := env.MarshalJSON()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	websocket.Message.Send(c.ws, string(b))
+}
@@ -0,0 +1,91 @@
+package dvm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/websocket"
+)
+
+func TestLocalAPI(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	if _, err := NewDvm(relay.URL(), testKey(), WithLocalAPI(LocalAPIConfig{Addr: "0.0.0.0:7777"})); err == nil {
+		t.Error("expected a public address to be rejected")
+	}
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithLocalAPI(LocalAPIConfig{Addr: "127.0.0.1:0", Token: "secret",
+		AllowedOrigins: []string{"http://localhost:3000"}}))
+	url := "ws://" + d.local.ln.Addr().String()
+
+	if _, err := NewDvmClient(url); err == nil {
+		t.Error("expected a client without the token to be refused")
+	}
+	// Pages can connect from allowed origins only
+	if ws, err := websocket.Dial(url+"/?token=secret", "", "http://evil.example"); err == nil {
+		ws.Close()
+		t.Error("expected a page from another origin to be refused")
+	}
+	ws, err := websocket.Dial(url+"/?token=secret", "", "http://localhost:3000")
+	if err != nil {
+		t.Fatalf("expected a page from an allowed origin to connect: %v", err)
+	}
+	ws.Close()
+
+	client, err := NewDvmClient(url + "/?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tweet, err := client.RequestTweet(ctx, d.GetPublicKey(), "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tweet.ID != "20" {
+		t.Errorf("expected tweet 20, got %s", tweet.ID)
+	}
+
+	// Neither the request nor its result went through the relay
+	for _, evt := range relay.Events() {
+		if evt.Kind == KindTweetRequest || evt.Kind == ResultKind(KindTweetRequest) {
+			t.Errorf("local job leaked to the relay: %v", evt)
+		}
+	}
+
+	// Finished jobs are forgotten
+	if !waitFor(func() bool {
+		d.local.mu.Lock()
+		defer d.local.mu.Unlock()
+		return len(d.local.jobs) == 0
+	}) {
+		t.Error("expected the finished job to be forgotten")
+	}
+}
+
+func TestLocalAPIDuplicate(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithLocalAPI(LocalAPIConfig{Addr: "127.0.0.1:0"}))
+
+	req := newTestRequest("20")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+
+	// A request already taken from a relay is refused locally, as a relay
+	// would refuse it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	local, err := nostr.RelayConnect(ctx, "ws://"+d.local.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	if status, _ := local.Publish(ctx, *req); status != nostr.PublishStatusFailed {
+		t.Errorf("expected the duplicate to be refused, got %v", status)
+	}
+}
@@ -0,0 +1,91 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// logSampler rate limits hot-path log lines, those written for every
+// request or publish, so at high volume logging neither becomes the
+// bottleneck nor fills the disk. Each message, told apart by its format
+// string, may be written perSecond times a second; the rest are counted,
+// and the next line written says how many were suppressed. Errors aren't
+// sampled: they're logged with log.Printf as usual.
+//
+// A nil *logSampler writes every line.
+type logSampler struct {
+	perSecond int
+
+	mu      sync.Mutex
+	windows map[string]*logWindow // by format string
+}
+
+// logWindow is one message's count for the current second.
+type logWindow struct {
+	start      time.Time
+	written    int
+	suppressed int // since the last line written
+}
+
+func newLogSampler(perSecond int) *logSampler {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &logSampler{perSecond: perSecond, windows: make(map[string]*logWindow)}
+}
+
+// printf logs like log.Printf unless the message is over its rate.
+func (s *logSampler) printf(format string, args ...any) {
+	if s == nil {
+		log.Printf(format, args...)
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	w, ok := s.windows[format]
+	if !ok {
+		w = &logWindow{start: now}
+		s.windows[format] = w
+	}
+	if now.Sub(w.start) >= time.Second {
+		w.start, w.written = now, 0
+	}
+	if w.written >= s.perSecond {
+		w.suppressed++
+		s.mu.Unlock()
+		metricLogsSuppressed.Add(1)
+		return
+	}
+	w.written++
+	suppressed := w.suppressed
+	w.suppressed = 0
+	s.mu.Unlock()
+
+	if suppressed > 0 {
+		log.Printf(format+" (%d similar lines suppressed)", append(args, suppressed)...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// maxLogSnippet is how much of a request's input or a tweet's text goes
+// in a log line.
+const maxLogSnippet = 80
+
+// logSnippet quotes s for a log line, shortened, so that long or
+// multi-line input keeps to one short line.
+func logSnippet(s string) string {
+	if len(s) <= maxLogSnippet {
+		return strconv.Quote(s)
+	}
+	cut := maxLogSnippet
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return strconv.Quote(s[:cut]) + fmt.Sprintf("… (%d bytes)", len(s))
+}
@@ -0,0 +1,79 @@
+package dvm
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer is a log output that goroutines still logging can share with
+// a reader.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs collects what's logged while fn runs. A DVM running in the
+// background logs as it likes, so fn should stop it to capture all of what
+// it logged; see runTestDvm.
+func captureLogs(fn func()) string {
+	var buf logBuffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	fn()
+	log.SetOutput(prev)
+	return buf.String()
+}
+
+func TestLogSampler(t *testing.T) {
+	s := newLogSampler(2)
+	suppressed := metricLogsSuppressed.Value()
+	out := captureLogs(func() {
+		for i := 0; i < 10; i++ {
+			s.printf("received request %d", i)
+		}
+		s.printf("published event %d", 1)
+	})
+	if n := strings.Count(out, "received request"); n != 2 {
+		t.Errorf("expected 2 lines of the sampled message, got %d:\n%s", n, out)
+	}
+	if !strings.Contains(out, "published event 1") {
+		t.Error("expected other messages to have their own rate")
+	}
+	if metricLogsSuppressed.Value() != suppressed+8 {
+		t.Error("expected the suppressed lines to be counted")
+	}
+
+	// The next line written owns up to what was suppressed
+	time.Sleep(time.Second)
+	out = captureLogs(func() { s.printf("received request %d", 10) })
+	if !strings.Contains(out, "received request 10 (8 similar lines suppressed)") {
+		t.Errorf("unexpected line %q", out)
+	}
+}
+
+func TestLogSnippet(t *testing.T) {
+	if got := logSnippet("two\nlines"); got != `"two\nlines"` {
+		t.Errorf("expected the newline escaped, got %s", got)
+	}
+	long := strings.Repeat("é", 100)
+	got := logSnippet(long)
+	if !strings.HasSuffix(got, "… (200 bytes)") || len(got) > maxLogSnippet+20 {
+		t.Errorf("expected a shortened snippet, got %s", got)
+	}
+}
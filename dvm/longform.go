@@ -0,0 +1,191 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindLongForm is the NIP-23 long-form article kind.
+const KindLongForm = 30023
+
+// maxNaddrRelays caps the relay hints in an article's naddr.
+const maxNaddrRelays = 2
+
+// LongFormArticle is the result of a KindLongFormRequest job. Unless the
+// request asked for it to be published, Event is unsigned, for the
+// requester to sign and publish as their own.
+type LongFormArticle struct {
+	Event nostr.Event   `json:"event"`
+	Naddr string        `json:"naddr,omitempty"` // if published
+	Media []HostedMedia `json:"media,omitempty"` // images re-hosted for the article
+}
+
+// longFormHandler unrolls the thread of a tweet into a NIP-23 article.
+// Images are re-hosted when the DVM has an upload host. With ["param",
+// "publish", "true"] the DVM signs the article with its mirror key and
+// publishes it.
+type longFormHandler struct {
+	d      *Dvm
+	client *http.Client
+}
+
+// longFormParams are the params of a long-form request.
+type longFormParams struct {
+	Publish bool `param:"publish"`
+}
+
+func (h *longFormHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		return fmt.Errorf("content is not a tweet ID")
+	}
+	var params longFormParams
+	if err := DecodeParams(req, &params); err != nil {
+		return err
+	}
+	if params.Publish && h.d.mirrorSK == "" {
+		return fmt.Errorf("publishing articles is not enabled on this DVM")
+	}
+	return nil
+}
+
+func (h *longFormHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	var params longFormParams
+	if err := DecodeParams(req, &params); err != nil {
+		return nil, err
+	}
+	tweet, err := h.d.fetchTweet(req.Content)
+	if err != nil {
+		return nil, err
+	}
+	thread := unrollThread(tweet)
+	first := thread[0]
+
+	var result LongFormArticle
+	images := make(map[string]string) // original URL to the one to embed
+	for _, t := range thread {
+		for _, p := range t.Photos {
+			images[p.URL] = p.URL
+		}
+		if h.d.uploader == nil || len(t.Photos) == 0 {
+			continue
+		}
+		hosted, err := h.d.rehostMedia(ctx, h.client, t)
+		if err != nil {
+			return nil, err
+		}
+		tagFileMetadata(ctx, hosted)
+		for _, m := range hosted {
+			if m.URL != "" {
+				images[m.Original] = m.URL
+			}
+		}
+		result.Media = append(result.Media, hosted...)
+	}
+
+	title := fmt.Sprintf("Thread by @%s", first.Username)
+	if first.Name != "" {
+		title = fmt.Sprintf("Thread by %s (@%s)", first.Name, first.Username)
+	}
+	result.Event = nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindLongForm,
+		Content:   threadMarkdown(thread, images),
+		Tags: nostr.Tags{
+			{"d", "twitter-" + first.ID},
+			{"title", title},
+			{"summary", truncateText(first.Text, 280)},
+			{"published_at", strconv.FormatInt(first.Timestamp, 10)},
+		},
+	}
+	if first.PermanentURL != "" {
+		result.Event.Tags = append(result.Event.Tags, nostr.Tag{"r", first.PermanentURL})
+	}
+	if len(first.Photos) > 0 {
+		result.Event.Tags = append(result.Event.Tags, nostr.Tag{"image", images[first.Photos[0].URL]})
+	}
+	seen := make(map[string]bool)
+	for _, t := range thread {
+		for _, tag := range t.Hashtags {
+			if tag = strings.ToLower(tag); !seen[tag] {
+				seen[tag] = true
+				result.Event.Tags = append(result.Event.Tags, nostr.Tag{"t", tag})
+			}
+		}
+	}
+
+	if params.Publish {
+		if err := h.publish(&result, "twitter-"+first.ID); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(result)
+}
+
+// publish signs the article with the mirror key, publishes it and points
+// result at it by its identifier.
+func (h *longFormHandler) publish(result *LongFormArticle, identifier string) error {
+	if h.d.mirrorSK == "" {
+		return errors.New("publishing articles is not enabled on this DVM")
+	}
+	evt := &result.Event
+	if err := evt.Sign(h.d.mirrorSK); err != nil {
+		return err
+	}
+	if err := h.d.publish(*evt); err != nil {
+		return err
+	}
+
+	var relays []string
+	if h.d.pool != nil {
+		for _, r := range h.d.pool.healthy() {
+			if len(relays) == maxNaddrRelays {
+				break
+			}
+			relays = append(relays, r.url)
+		}
+	}
+	var err error
+	result.Naddr, err = encodeNaddr(evt.Kind, evt.PubKey, identifier, relays...)
+	return err
+}
+
+// unrollThread returns the tweets of tweet's thread, oldest first.
+func unrollThread(tweet *twitterscraper.Tweet) []*twitterscraper.Tweet {
+	thread := []*twitterscraper.Tweet{tweet}
+	for _, t := range tweet.Thread {
+		if t.ID != tweet.ID {
+			thread = append(thread, t)
+		}
+	}
+	sort.SliceStable(thread, func(i, j int) bool {
+		return thread[i].Timestamp < thread[j].Timestamp
+	})
+	return thread
+}
+
+// threadMarkdown formats a thread as markdown, a paragraph per tweet
+// followed by its photos, embedded from images where they were re-hosted.
+func threadMarkdown(thread []*twitterscraper.Tweet, images map[string]string) string {
+	var b strings.Builder
+	for _, t := range thread {
+		// Keep the tweet's line breaks, which markdown would otherwise join
+		b.WriteString(strings.ReplaceAll(strings.TrimSpace(t.Text), "\n", "  \n"))
+		b.WriteString("\n\n")
+		for _, p := range t.Photos {
+			fmt.Fprintf(&b, "![](%s)\n\n", images[p.URL])
+		}
+	}
+	if url := thread[0].PermanentURL; url != "" {
+		fmt.Fprintf(&b, "---\n\nOriginally posted at %s\n", url)
+	}
+	return b.String()
+}
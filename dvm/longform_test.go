@@ -0,0 +1,132 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// articleScraper serves a three-tweet thread, the second with a photo,
+// whichever of its tweets is asked for.
+type articleScraper struct {
+	fakeScraper
+	photo string
+}
+
+func (s *articleScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	s.fakeScraper.GetTweet(id)
+	thread := []*twitterscraper.Tweet{
+		{ID: "10", Timestamp: 1000, Username: "halfin", Name: "Hal Finney", Text: "Running bitcoin\n1/", Hashtags: []string{"Bitcoin"},
+			PermanentURL: "https://twitter.com/halfin/status/10"},
+		{ID: "11", Timestamp: 1060, Username: "halfin", Text: "Looking at ways to add more anonymity 2/", Hashtags: []string{"bitcoin"},
+			Photos: []twitterscraper.Photo{{ID: "p", URL: s.photo}}},
+		{ID: "12", Timestamp: 1120, Username: "halfin", Text: "Fin 3/"},
+	}
+	for _, t := range thread {
+		if t.ID == id {
+			tweet := *t
+			tweet.Thread = thread
+			return &tweet, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestLongFormHandler(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("a photo"))
+	}))
+	defer media.Close()
+
+	blossom := newFakeBlossom(t)
+	mirrorSK := testKey()
+	mirrorPK, _ := nostr.GetPublicKey(mirrorSK)
+	d := startTestDvm(t, relay,
+		WithScraper(&articleScraper{photo: media.URL + "/photo.png"}),
+		WithUploads(UploadConfig{Provider: "blossom", Server: blossom.URL}),
+		WithMirrorKey(mirrorSK),
+	)
+	h := &longFormHandler{d: d, client: media.Client()}
+
+	// Asking for the middle of the thread still gets all of it
+	req := &nostr.Event{Content: "11", Tags: nostr.Tags{{"param", "publish", "true"}}}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	out, err := h.Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var article LongFormArticle
+	if err := json.Unmarshal(out, &article); err != nil {
+		t.Fatal(err)
+	}
+
+	evt := article.Event
+	if evt.Kind != KindLongForm || evt.PubKey != mirrorPK {
+		t.Fatalf("expected an article signed with the mirror key, got %+v", evt)
+	}
+	if ok, _ := evt.CheckSignature(); !ok {
+		t.Error("article signature doesn't verify")
+	}
+	first, second, third := strings.Index(evt.Content, "Running"), strings.Index(evt.Content, "anonymity"), strings.Index(evt.Content, "Fin")
+	if first < 0 || !(first < second && second < third) {
+		t.Errorf("expected the thread in order, got %q", evt.Content)
+	}
+	if len(article.Media) != 1 || !strings.Contains(evt.Content, "![]("+article.Media[0].URL+")") || strings.Contains(evt.Content, media.URL) {
+		t.Errorf("expected the re-hosted photo to be embedded, got %q", evt.Content)
+	}
+	for _, want := range []nostr.Tag{{"d", "twitter-10"}, {"title", "Thread by Hal Finney (@halfin)"}, {"published_at", "1000"}, {"t", "bitcoin"}} {
+		if evt.Tags.GetFirst(want) == nil {
+			t.Errorf("article is missing %v: %v", want, evt.Tags)
+		}
+	}
+	hashtags := 0
+	for _, tag := range evt.Tags {
+		if tag[0] == "t" {
+			hashtags++
+		}
+	}
+	if hashtags != 1 {
+		t.Errorf("expected hashtags once each: %v", evt.Tags)
+	}
+
+	hrp, _, err := decodeBech32(article.Naddr)
+	if err != nil || hrp != "naddr" {
+		t.Errorf("bad naddr %q: %v", article.Naddr, err)
+	}
+	published := false
+	for _, e := range relay.Events() {
+		published = published || e.ID == evt.ID
+	}
+	if !published {
+		t.Error("article wasn't published")
+	}
+
+	// Unpublished articles are left for the requester to sign
+	d.uploader = nil
+	out, err = h.Handle(context.Background(), &nostr.Event{Content: "12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	article = LongFormArticle{}
+	json.Unmarshal(out, &article)
+	if article.Event.Sig != "" || article.Naddr != "" || !strings.Contains(article.Event.Content, "![]("+media.URL+"/photo.png)") {
+		t.Errorf("expected an unsigned article with the original photo, got %s", out)
+	}
+
+	d.mirrorSK = ""
+	if err := h.Validate(req); err == nil {
+		t.Error("expected publishing to be refused without a mirror key")
+	}
+}
@@ -0,0 +1,179 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// mastodonStatusPath matches the status URL shapes used by Mastodon and
+// compatible servers: /@alice/123, /users/alice/statuses/123,
+// /web/statuses/123 and Pleroma's /notice/abc.
+var mastodonStatusPath = regexp.MustCompile(`^/(?:@[^/]+|users/[^/]+/statuses|web/statuses|notice)/([0-9A-Za-z]+)/?$`)
+
+// mastodonHandler resolves a fediverse status URL to a Post, using the
+// instance's public API and falling back to an ActivityPub fetch for servers
+// that restrict the API.
+type mastodonHandler struct {
+	client *http.Client
+}
+
+func newMastodonHandler() *mastodonHandler {
+	return &mastodonHandler{client: newFetchClient()}
+}
+
+func (h *mastodonHandler) Validate(req *nostr.Event) error {
+	_, _, err := parseMastodonURL(req.Content)
+	return err
+}
+
+// parseMastodonURL splits a status URL into the instance base URL and status ID.
+func parseMastodonURL(raw string) (*url.URL, string, error) {
+	u, err := parseWebURL(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	m := mastodonStatusPath.FindStringSubmatch(u.Path)
+	if m == nil {
+		return nil, "", fmt.Errorf("not a fediverse status URL: %q", raw)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, m[1], nil
+}
+
+func (h *mastodonHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	instance, id, err := parseMastodonURL(req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	post, err := h.fromAPI(ctx, instance, id)
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		// Instances in authorized-fetch or limited mode refuse the API
+		// but will still serve the ActivityPub object
+		post, err = h.fromActivityPub(ctx, strings.TrimSpace(req.Content))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(post)
+}
+
+// mastodonStatus is the subset of the Mastodon API status entity we use.
+type mastodonStatus struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+	Content     string    `json:"content"`
+	SpoilerText string    `json:"spoiler_text"`
+	Account     struct {
+		Acct        string `json:"acct"`
+		DisplayName string `json:"display_name"`
+		URL         string `json:"url"`
+	} `json:"account"`
+	MediaAttachments []struct {
+		Type        string `json:"type"`
+		URL         string `json:"url"`
+		Description string `json:"description"`
+	} `json:"media_attachments"`
+	RepliesCount    int `json:"replies_count"`
+	ReblogsCount    int `json:"reblogs_count"`
+	FavouritesCount int `json:"favourites_count"`
+}
+
+func (h *mastodonHandler) fromAPI(ctx context.Context, instance *url.URL, id string) (*Post, error) {
+	var status mastodonStatus
+	apiURL := instance.String() + "/api/v1/statuses/" + id
+	if err := fetchJSON(ctx, h.client, apiURL, "", &status); err != nil {
+		return nil, err
+	}
+
+	// Remote accounts already carry their domain; local ones don't
+	handle := status.Account.Acct
+	if !strings.Contains(handle, "@") {
+		handle += "@" + instance.Host
+	}
+
+	post := &Post{
+		Platform: "mastodon",
+		ID:       status.ID,
+		URL:      status.URL,
+		Author: PostAuthor{
+			Name:   status.Account.DisplayName,
+			Handle: handle,
+			URL:    status.Account.URL,
+		},
+		Title:     status.SpoilerText,
+		Text:      htmlToText(status.Content),
+		HTML:      status.Content,
+		CreatedAt: status.CreatedAt,
+		Replies:   status.RepliesCount,
+		Reposts:   status.ReblogsCount,
+		Likes:     status.FavouritesCount,
+	}
+	for _, m := range status.MediaAttachments {
+		post.Media = append(post.Media, PostMedia{Type: m.Type, URL: m.URL, Description: m.Description})
+	}
+	return post, nil
+}
+
+// activityPubNote is the subset of an ActivityStreams Note we use.
+type activityPubNote struct {
+	ID           string    `json:"id"`
+	URL          any       `json:"url"` // a string or a Link object, depending on server
+	Published    time.Time `json:"published"`
+	AttributedTo string    `json:"attributedTo"`
+	Summary      string    `json:"summary"`
+	Content      string    `json:"content"`
+	Attachment   []struct {
+		MediaType string `json:"mediaType"`
+		URL       string `json:"url"`
+		Name      string `json:"name"`
+	} `json:"attachment"`
+}
+
+func (h *mastodonHandler) fromActivityPub(ctx context.Context, statusURL string) (*Post, error) {
+	var note activityPubNote
+	accept := `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+	if err := fetchJSON(ctx, h.client, statusURL, accept, &note); err != nil {
+		return nil, err
+	}
+
+	post := &Post{
+		Platform:  "mastodon",
+		ID:        note.ID,
+		URL:       statusURL,
+		Author:    PostAuthor{Handle: activityPubHandle(note.AttributedTo), URL: note.AttributedTo},
+		Title:     note.Summary,
+		Text:      htmlToText(note.Content),
+		HTML:      note.Content,
+		CreatedAt: note.Published,
+	}
+	if u, ok := note.URL.(string); ok && u != "" {
+		post.URL = u
+	}
+	for _, a := range note.Attachment {
+		mediaType, _, _ := strings.Cut(a.MediaType, "/")
+		post.Media = append(post.Media, PostMedia{Type: mediaType, URL: a.URL, Description: a.Name})
+	}
+	return post, nil
+}
+
+// activityPubHandle derives user@host from an actor URL like
+// https://host/users/alice.
+func activityPubHandle(actor string) string {
+	u, err := url.Parse(actor)
+	if err != nil || u.Host == "" {
+		return actor
+	}
+	name := u.Path[strings.LastIndex(u.Path, "/")+1:]
+	return strings.TrimPrefix(name, "@") + "@" + u.Host
+}
@@ -0,0 +1,121 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseMastodonURL(t *testing.T) {
+	for raw, wantID := range map[string]string{
+		"https://mastodon.social/@Gargron/109318821117356215":       "109318821117356215",
+		"https://mastodon.social/users/Gargron/statuses/1093188211": "1093188211",
+		"https://pleroma.example/notice/AbCdEf123":                  "AbCdEf123",
+	} {
+		instance, id, err := parseMastodonURL(raw)
+		if err != nil {
+			t.Errorf("%s: %v", raw, err)
+			continue
+		}
+		if id != wantID || instance.Path != "" {
+			t.Errorf("%s: got instance %s id %s", raw, instance, id)
+		}
+	}
+
+	for _, raw := range []string{"1234", "ftp://mastodon.social/@a/1", "https://mastodon.social/@Gargron"} {
+		if _, _, err := parseMastodonURL(raw); err == nil {
+			t.Errorf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestMastodonHandlerFallsBackToActivityPub(t *testing.T) {
+	apiEnabled := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/statuses/42":
+			if !apiEnabled {
+				http.Error(w, "authorized fetch", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"id":"42","url":"https://example.social/@alice/42",
+				"created_at":"2024-01-02T03:04:05Z","content":"<p>hello<br>fediverse</p>",
+				"account":{"acct":"alice","display_name":"Alice","url":"https://example.social/@alice"},
+				"media_attachments":[{"type":"image","url":"https://example.social/a.png"}],
+				"replies_count":1,"reblogs_count":2,"favourites_count":3}`))
+		case "/@alice/42":
+			w.Header().Set("Content-Type", "application/activity+json")
+			w.Write([]byte(`{"id":"https://example.social/users/alice/statuses/42",
+				"url":"https://example.social/@alice/42","published":"2024-01-02T03:04:05Z",
+				"attributedTo":"https://example.social/users/alice","content":"<p>hello</p>"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	h := newMastodonHandler()
+	h.client = srv.Client()
+	req := &nostr.Event{Content: srv.URL + "/@alice/42"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	post := decodePost(t, h, ctx, req)
+	if post.Text != "hello\nfediverse" || post.Likes != 3 || len(post.Media) != 1 {
+		t.Errorf("unexpected post from API: %+v", post)
+	}
+	if instance, _ := url.Parse(srv.URL); post.Author.Handle != "alice@"+instance.Host {
+		t.Errorf("expected local handle to gain the instance host, got %s", post.Author.Handle)
+	}
+
+	apiEnabled = false
+	post = decodePost(t, h, ctx, req)
+	if post.Text != "hello" || post.Author.Handle != "alice@example.social" {
+		t.Errorf("unexpected post from ActivityPub: %+v", post)
+	}
+}
+
+func decodePost(t *testing.T, h JobHandler, ctx context.Context, req *nostr.Event) Post {
+	t.Helper()
+	result, err := h.Handle(ctx, req)
+	if err != nil {
+		t.Fatalf("handle failed: %v", err)
+	}
+	var post Post
+	if err := json.Unmarshal(result, &post); err != nil {
+		t.Fatal(err)
+	}
+	return post
+}
+
+func TestClientRequestsMastodonStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"7","content":"<p>toot</p>","account":{"acct":"bob@other.social"}}`))
+	}))
+	defer srv.Close()
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithHandler(KindMastodonRequest, &mastodonHandler{client: srv.Client()}))
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	post, err := client.RequestPost(ctx, d.GetPublicKey(), KindMastodonRequest, srv.URL+"/@bob@other.social/7")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if post.Platform != "mastodon" || post.Text != "toot" || post.Author.Handle != "bob@other.social" {
+		t.Errorf("unexpected post: %+v", post)
+	}
+}
@@ -0,0 +1,146 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // decoders for describeImage
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindFileMetadata is the NIP-94 file metadata kind.
+const KindFileMetadata = 1063
+
+// maxRehostedMediaBytes caps each media file downloaded for re-hosting.
+const maxRehostedMediaBytes = 100 << 20
+
+// HostedMedia is one of a tweet's media files copied to the DVM's upload
+// host, so it survives the original link rotting.
+type HostedMedia struct {
+	ID       string `json:"id"`       // media ID on Twitter
+	Original string `json:"original"` // the Twitter URL
+	UploadedFile
+	Dim      string `json:"dim,omitempty"`      // "<width>x<height>", for images
+	Blurhash string `json:"blurhash,omitempty"` // placeholder, for images
+	EventID  string `json:"event_id,omitempty"` // its NIP-94 file metadata event
+	Error    string `json:"error,omitempty"`    // if this file couldn't be re-hosted
+}
+
+// rehostMedia copies each of the tweet's media files to the upload host
+// and publishes a NIP-94 event describing it, with the dimensions and
+// BlurHash of images. Failures are recorded per file rather than failing
+// the job.
+func (d *Dvm) rehostMedia(ctx context.Context, client *http.Client, tweet *twitterscraper.Tweet) ([]HostedMedia, error) {
+	if d.uploader == nil {
+		return nil, errors.New("media re-hosting is not enabled on this DVM")
+	}
+
+	media := tweetMedia(tweet)
+	ids := make([]string, 0, len(media))
+	for id := range media {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	hosted := []HostedMedia{}
+	for _, id := range ids {
+		h := HostedMedia{ID: id, Original: media[id]}
+		file, data, err := d.rehost(ctx, client, media[id])
+		if err != nil {
+			log.Printf("Failed to re-host media %s of tweet %s: %v", id, tweet.ID, err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			h.Error = err.Error()
+			hosted = append(hosted, h)
+			continue
+		}
+		h.UploadedFile = *file
+		h.Dim, h.Blurhash = describeImage(data)
+		if h.EventID, err = d.publishFileMetadata(h, tweet); err != nil {
+			log.Printf("Failed to publish file metadata for %s: %v", file.URL, err)
+		}
+		hosted = append(hosted, h)
+	}
+	return hosted, nil
+}
+
+// rehost downloads mediaURL and uploads it, returning the file's contents
+// too.
+func (d *Dvm) rehost(ctx context.Context, client *http.Client, mediaURL string) (*UploadedFile, []byte, error) {
+	res, err := fetchWith(ctx, client, mediaURL, nil, maxRehostedMediaBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	mimeType, _, _ := strings.Cut(res.Header.Get("Content-Type"), ";")
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType, _, _ = strings.Cut(http.DetectContentType(res.Body), ";")
+	}
+	file, err := d.uploader.upload(ctx, res.Body, strings.TrimSpace(mimeType))
+	return file, res.Body, err
+}
+
+// describeImage returns the dimensions and BlurHash of an image, or empty
+// strings for other files.
+func describeImage(data []byte) (dim, hash string) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", ""
+	}
+	b := img.Bounds()
+	return fmt.Sprintf("%dx%d", b.Dx(), b.Dy()), blurhash(img)
+}
+
+// tagFileMetadata references the NIP-94 events of re-hosted media from the
+// result of the job running under ctx.
+func tagFileMetadata(ctx context.Context, media []HostedMedia) {
+	for _, m := range media {
+		if m.EventID != "" {
+			tagResult(ctx, nostr.Tag{"e", m.EventID, "", "file"})
+		}
+	}
+}
+
+// publishFileMetadata publishes a NIP-94 event for a re-hosted file and
+// returns its ID. The original URL is listed as a fallback.
+func (d *Dvm) publishFileMetadata(h HostedMedia, tweet *twitterscraper.Tweet) (string, error) {
+	file := h.UploadedFile
+	evt := nostr.Event{
+		PubKey:    d.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      KindFileMetadata,
+		Tags: nostr.Tags{
+			{"url", file.URL},
+			{"m", file.MimeType},
+			{"x", file.SHA256},
+			{"ox", file.SHA256},
+			{"size", strconv.FormatInt(file.Size, 10)},
+			{"fallback", h.Original},
+		},
+		Content: tweet.Text,
+	}
+	if h.Dim != "" {
+		evt.Tags = append(evt.Tags, nostr.Tag{"dim", h.Dim}, nostr.Tag{"blurhash", h.Blurhash})
+	}
+	if tweet.PermanentURL != "" {
+		evt.Tags = append(evt.Tags, nostr.Tag{"r", tweet.PermanentURL})
+	}
+	if err := evt.Sign(d.sk); err != nil {
+		return "", err
+	}
+	if err := d.publish(evt); err != nil {
+		return "", err
+	}
+	return evt.ID, nil
+}
@@ -0,0 +1,167 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeBlossom is a Blossom server that checks upload authorizations and
+// serves the blobs uploaded.
+type fakeBlossom struct {
+	*httptest.Server
+	mu       sync.Mutex
+	blobs    map[string][]byte
+	uploader string // pubkey that authorized the last upload
+}
+
+func newFakeBlossom(t *testing.T) *fakeBlossom {
+	b := &fakeBlossom{blobs: make(map[string][]byte)}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			b.mu.Lock()
+			blob, ok := b.blobs[strings.TrimPrefix(r.URL.Path, "/")]
+			b.mu.Unlock()
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(blob)
+			return
+		}
+		if r.Method != http.MethodPut || r.URL.Path != "/upload" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+
+		raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Nostr "))
+		var auth nostr.Event
+		if json.Unmarshal(raw, &auth) != nil || auth.Kind != KindBlossomAuth ||
+			auth.Tags.GetFirst([]string{"t", "upload"}) == nil || auth.Tags.GetFirst([]string{"x", hash}) == nil {
+			w.Header().Set("X-Reason", "bad authorization")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if ok, _ := auth.CheckSignature(); !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		b.mu.Lock()
+		b.blobs[hash] = body
+		b.uploader = auth.PubKey
+		b.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"url":    b.URL + "/" + hash,
+			"sha256": hash,
+			"size":   len(body),
+			"type":   r.Header.Get("Content-Type"),
+		})
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestRehostMedia(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/photo.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("a photo"))
+	}))
+	defer media.Close()
+
+	blossom := newFakeBlossom(t)
+	scraper := &photoScraper{photos: []string{media.URL + "/photo.png", media.URL + "/gone.png"}}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithUploads(UploadConfig{Provider: "blossom", Server: blossom.URL}))
+
+	h := tweetHandler{d: d, client: media.Client()}
+	req := &nostr.Event{Content: "1110302988", Tags: nostr.Tags{{"param", "rehost", "true"}}}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	out, err := h.Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tweet TweetResult
+	if err := json.Unmarshal(out, &tweet); err != nil {
+		t.Fatal(err)
+	}
+	if len(tweet.HostedMedia) != 2 || tweet.OCR != nil {
+		t.Fatalf("unexpected result: %s", out)
+	}
+
+	hosted, failed := tweet.HostedMedia[0], tweet.HostedMedia[1]
+	sum := sha256.Sum256([]byte("a photo"))
+	if hosted.SHA256 != hex.EncodeToString(sum[:]) || hosted.MimeType != "image/png" || hosted.Size != 7 || hosted.EventID == "" {
+		t.Errorf("unexpected hosted media: %+v", hosted)
+	}
+	if string(blossom.blobs[hosted.SHA256]) != "a photo" || blossom.uploader != d.GetPublicKey() {
+		t.Error("photo wasn't uploaded by the DVM")
+	}
+	if failed.Error == "" || failed.URL != "" {
+		t.Errorf("expected the missing photo to fail: %+v", failed)
+	}
+
+	var metadata *nostr.Event
+	for _, evt := range relay.Events() {
+		if evt.ID == hosted.EventID {
+			metadata = evt
+		}
+	}
+	if metadata == nil || metadata.Kind != KindFileMetadata || metadata.PubKey != d.GetPublicKey() {
+		t.Fatalf("file metadata event not published: %+v", metadata)
+	}
+	for _, want := range []nostr.Tag{{"url", hosted.URL}, {"x", hosted.SHA256}, {"m", "image/png"}, {"fallback", hosted.Original}} {
+		if metadata.Tags.GetFirst(want) == nil {
+			t.Errorf("file metadata is missing %v: %v", want, metadata.Tags)
+		}
+	}
+
+	// Results reference the metadata of what they re-hosted
+	receipt := &jobReceipt{}
+	if _, err := h.Handle(context.WithValue(context.Background(), jobReceiptKey{}, receipt), req); err != nil {
+		t.Fatal(err)
+	}
+	if len(receipt.tags) != 1 || receipt.tags[0][1] != hosted.EventID || receipt.tags[0][3] != "file" {
+		t.Errorf("expected the result tagged with the file metadata, got %v", receipt.tags)
+	}
+
+	d.uploader = nil
+	if _, err := h.Handle(context.Background(), &nostr.Event{Content: "2", Tags: nostr.Tags{{"param", "rehost", "true"}}}); err == nil {
+		t.Error("expected re-hosting to fail without an upload host")
+	}
+}
+
+func TestDescribeImage(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 6)))
+	if dim, hash := describeImage(buf.Bytes()); dim != "8x6" || len(hash) != 28 {
+		t.Errorf("got %q, %q", dim, hash)
+	}
+	if dim, hash := describeImage([]byte("a video")); dim != "" || hash != "" {
+		t.Errorf("expected nothing for a file that isn't an image, got %q, %q", dim, hash)
+	}
+}
@@ -0,0 +1,103 @@
+package dvm
+
+import (
+	"expvar"
+)
+
+// Process-wide metrics, published through expvar under "bandita" so they show
+// up at /debug/vars when the debug server is enabled.
+var (
+	metrics = expvar.NewMap("bandita")
+
+	metricQueueDepth    = new(expvar.Int)
+	metricJobsBusy      = new(expvar.Int)
+	metricJobsAccepted  = new(expvar.Int)
+	metricEventsDropped = new(expvar.Int)
+	metricJobsExpired   = new(expvar.Int)
+	metricJobsStale     = new(expvar.Int)
+	metricJobsReplayed  = new(expvar.Int)
+	metricJobsPanicked  = new(expvar.Int)
+	metricJobsUntrusted = new(expvar.Int)
+	metricWoTSize       = new(expvar.Int)
+
+	metricJobsNotAllowed = new(expvar.Int)
+	metricAllowlistSize  = new(expvar.Int)
+	metricDenylistSize   = new(expvar.Int)
+
+	metricRelayResultHits   = new(expvar.Int)
+	metricRelayResultMisses = new(expvar.Int)
+
+	metricIdentityCollisions = new(expvar.Int)
+
+	metricJobsImplausibleTimestamp = new(expvar.Int)
+
+	metricChallengesSent       = new(expvar.Int)
+	metricChallengesUnanswered = new(expvar.Int)
+
+	metricProfilePublishes = new(expvar.Int)
+
+	metricJobsRejectedPoW  = new(expvar.Int)
+	metricJobsDenied       = new(expvar.Int)
+	metricRequestersDenied = new(expvar.Int)
+
+	metricCacheHits      = new(expvar.Int)
+	metricCacheMisses    = new(expvar.Int)
+	metricCacheEvictions = new(expvar.Int)
+	metricCacheBytes     = new(expvar.Int)
+	metricCacheEntries   = new(expvar.Int)
+
+	metricResultTemplateHits = new(expvar.Int)
+
+	metricArchiveUploads  = new(expvar.Int)
+	metricArchiveFailures = new(expvar.Int)
+
+	metricPublishQueueDepth = new(expvar.Int)
+	metricPublishRetries    = new(expvar.Int)
+	metricPublishFailures   = new(expvar.Int)
+
+	metricLogsSuppressed = new(expvar.Int)
+
+	metricScraperRestarts = new(expvar.Int)
+
+	metricWatchdog = new(expvar.Map).Init() // the watchdog's last sample
+)
+
+func init() {
+	metrics.Set("queue_depth", metricQueueDepth)
+	metrics.Set("jobs_rejected_busy", metricJobsBusy)
+	metrics.Set("jobs_accepted", metricJobsAccepted)
+	metrics.Set("events_dropped", metricEventsDropped)
+	metrics.Set("jobs_expired", metricJobsExpired)
+	metrics.Set("jobs_stale", metricJobsStale)
+	metrics.Set("jobs_implausible_timestamp", metricJobsImplausibleTimestamp)
+	metrics.Set("challenges_sent", metricChallengesSent)
+	metrics.Set("challenges_unanswered", metricChallengesUnanswered)
+	metrics.Set("profile_publishes", metricProfilePublishes)
+	metrics.Set("jobs_replayed", metricJobsReplayed)
+	metrics.Set("jobs_panicked", metricJobsPanicked)
+	metrics.Set("jobs_untrusted", metricJobsUntrusted)
+	metrics.Set("jobs_rejected_pow", metricJobsRejectedPoW)
+	metrics.Set("jobs_denied", metricJobsDenied)
+	metrics.Set("requesters_denied", metricRequestersDenied)
+	metrics.Set("identity_collisions", metricIdentityCollisions)
+	metrics.Set("wot_size", metricWoTSize)
+	metrics.Set("jobs_not_allowed", metricJobsNotAllowed)
+	metrics.Set("allowlist_size", metricAllowlistSize)
+	metrics.Set("denylist_size", metricDenylistSize)
+	metrics.Set("cache_hits", metricCacheHits)
+	metrics.Set("relay_result_hits", metricRelayResultHits)
+	metrics.Set("relay_result_misses", metricRelayResultMisses)
+	metrics.Set("cache_misses", metricCacheMisses)
+	metrics.Set("cache_evictions", metricCacheEvictions)
+	metrics.Set("cache_bytes", metricCacheBytes)
+	metrics.Set("cache_entries", metricCacheEntries)
+	metrics.Set("result_template_hits", metricResultTemplateHits)
+	metrics.Set("archive_uploads", metricArchiveUploads)
+	metrics.Set("archive_failures", metricArchiveFailures)
+	metrics.Set("publish_queue_depth", metricPublishQueueDepth)
+	metrics.Set("publish_retries", metricPublishRetries)
+	metrics.Set("publish_failures", metricPublishFailures)
+	metrics.Set("logs_suppressed", metricLogsSuppressed)
+	metrics.Set("scraper_restarts", metricScraperRestarts)
+	metrics.Set("watchdog", metricWatchdog)
+}
@@ -0,0 +1,359 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Job is a request on its way through the DVM's pipeline of stages.
+type Job struct {
+	Request *nostr.Event
+	// Result is the content the handler stage produced, which stages
+	// inserted before publish may change.
+	Result []byte
+	// Paid is the msats paid up front for the job, refunded if it fails.
+	Paid int64
+
+	id      *identity
+	handler JobHandler
+	receipt *jobReceipt
+	taken   bool // passed the checks and rate limits; see OnRequest
+}
+
+// JobFunc runs a job through the rest of the pipeline.
+type JobFunc func(ctx context.Context, job *Job) error
+
+// Middleware is a stage of the request pipeline. It does its part and
+// calls next to hand the job on, or returns without calling it to stop the
+// job there: with nil once the request has been answered or is to be
+// ignored, or with an error to fail the job, for which the DVM sends error
+// feedback, a *JobError's or "Job failed: <error>", refunds what was paid
+// and calls the OnError hooks.
+//
+// The built-in stages run in this order, publish last:
+//
+//	auth → rate-limit → cache → payment → handler → publish
+//
+// and WithMiddleware inserts more before any of them.
+type Middleware func(next JobFunc) JobFunc
+
+// The built-in stages of the request pipeline.
+const (
+	StageAuth      = "auth"       // timestamps, proof of work, web of trust, allow/deny lists, params and freshness
+	StageRateLimit = "rate-limit" // the requester's daily quota
+	StageCache     = "cache"      // replays of results to duplicate requests, which cost nothing
+	StagePayment   = "payment"    // challenges and payment up front
+	StageHandler   = "handler"    // the job itself, the content policy and fetch attestations
+	StagePublish   = "publish"    // the result event
+)
+
+// JobError fails a job with error feedback giving Reason and Message.
+type JobError struct {
+	Reason  string // machine-readable, such as ReasonShuttingDown; may be empty
+	Message string // for the requester
+	Err     error  // the cause, for logs and OnError hooks
+}
+
+func (e *JobError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *JobError) Unwrap() error { return e.Err }
+
+// insertedMiddleware is a Middleware inserted before a built-in stage.
+type insertedMiddleware struct {
+	before string
+	mw     Middleware
+}
+
+// buildPipeline chains the built-in stages with those inserted.
+func (d *Dvm) buildPipeline() (JobFunc, error) {
+	builtin := []struct {
+		name string
+		mw   Middleware
+	}{
+		{StageAuth, d.authStage},
+		{StageRateLimit, d.rateLimitStage},
+		{StageCache, d.cacheStage},
+		{StagePayment, d.paymentStage},
+		{StageHandler, d.handlerStage},
+	}
+	inserted := make(map[string][]Middleware)
+	for _, m := range d.middleware {
+		known := m.before == StagePublish
+		for _, stage := range builtin {
+			known = known || m.before == stage.name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown pipeline stage %q", m.before)
+		}
+		inserted[m.before] = append(inserted[m.before], m.mw)
+	}
+
+	var chain []Middleware
+	for _, stage := range builtin {
+		chain = append(chain, inserted[stage.name]...)
+		chain = append(chain, stage.mw)
+	}
+	chain = append(chain, inserted[StagePublish]...)
+	run := JobFunc(d.publishStage)
+	for i := len(chain) - 1; i >= 0; i-- {
+		run = chain[i](run)
+	}
+	return run, nil
+}
+
+// failJob answers a job that failed with err with error feedback,
+// refunding what was paid for it.
+func (d *Dvm) failJob(job *Job, err error) {
+	evt := job.Request
+	log.Printf("Job %s failed: %v", evt.ID[:8], err)
+	if job.taken {
+		d.hooks.fireError(evt, err)
+	}
+	reason, msg := "", fmt.Sprintf("Job failed: %v", err)
+	var jobErr *JobError
+	if errors.As(err, &jobErr) {
+		reason, msg = jobErr.Reason, jobErr.Message
+	}
+	d.publishFeedback(job.id, evt, StatusError, reason, msg+d.refundNote(evt, job.Paid))
+}
+
+// authStage turns away requests the DVM won't serve, and ignores those
+// it can't.
+func (d *Dvm) authStage(next JobFunc) JobFunc {
+	return func(ctx context.Context, job *Job) error {
+		evt, id := job.Request, job.id
+		if d.rejectedTimestamp(id, evt) {
+			return nil
+		}
+		if d.rejectedPoW(id, evt) {
+			return nil
+		}
+		if d.wot != nil {
+			if trusted, built := d.wot.trusts(evt.PubKey); !trusted {
+				d.rejectUntrusted(id, evt, built)
+				return nil
+			}
+		}
+		if allowed, loaded := d.lists.allows(evt.PubKey); !allowed {
+			d.rejectNotAllowed(id, evt, loaded)
+			return nil
+		}
+		// Params that don't fit the kind's schema are the client's bug, so
+		// say exactly what's wrong rather than ignoring the request
+		if schema := d.paramSchemas[evt.Kind]; schema != nil {
+			if err := schema.ValidateParams(evt); err != nil {
+				log.Printf("Rejecting request %s: %v", evt.ID[:8], err)
+				d.publishFeedback(id, evt, StatusError, ReasonInvalidParams, err.Error())
+				return nil
+			}
+		}
+		if err := job.handler.Validate(evt); err != nil {
+			log.Printf("Ignoring request %s: %v", evt.ID[:8], err)
+			return nil
+		}
+		// A request that expired, say while the DVM was down or it sat in
+		// the queue, has nobody waiting for its result
+		if at, expired := expiredAt(evt, time.Now()); expired {
+			log.Printf("Skipping request %s: expired at %s", evt.ID[:8], at.Format(time.RFC3339))
+			metricJobsExpired.Add(1)
+			d.publishFeedback(id, evt, StatusError, ReasonExpired, "Request expired before the DVM got to it")
+			return nil
+		}
+		// Nor, likely, does one older than the freshness budget, say one
+		// backfilled after downtime, so don't spend scraper quota on it
+		if d.maxRequestAge > 0 {
+			if age := time.Since(evt.CreatedAt.Time()); age > d.maxRequestAge+d.clockSkew {
+				d.logs.printf("Skipping request %s: %v old", evt.ID[:8], age.Round(time.Second))
+				metricJobsStale.Add(1)
+				d.publishFeedback(id, evt, StatusError, ReasonStale,
+					fmt.Sprintf("Request is older than %v, resubmit it if you still want a result", d.maxRequestAge))
+				return nil
+			}
+		}
+		return next(ctx, job)
+	}
+}
+
+// rateLimitStage holds requesters to their daily quota, then takes the
+// job on.
+func (d *Dvm) rateLimitStage(next JobFunc) JobFunc {
+	return func(ctx context.Context, job *Job) error {
+		evt, id := job.Request, job.id
+		if id.quota != nil {
+			if ok, resetAt := id.quota.allow(evt.PubKey, time.Now()); !ok {
+				log.Printf("Rejecting request %s: %s is over its daily quota", evt.ID[:8], d.scrub.logPubKey(evt.PubKey))
+				d.strike(evt, strikeSpam, "over the daily quota")
+				d.publishFeedback(id, evt, StatusError, ReasonQuotaExceeded,
+					fmt.Sprintf("Daily quota of %d requests exceeded, resets at %s",
+						id.quotaCfg.Daily, resetAt.Format(time.RFC3339)))
+				return nil
+			}
+		}
+		job.taken = true
+		d.hooks.fireRequest(evt)
+		return next(ctx, job)
+	}
+}
+
+// paymentStage has the requester confirm costly jobs and pay for priced
+// ones before they run.
+func (d *Dvm) paymentStage(next JobFunc) JobFunc {
+	return func(ctx context.Context, job *Job) error {
+		evt, id := job.Request, job.id
+		// Costly jobs only run once the requester confirms they're asking,
+		// so a replayed request doesn't set one off
+		if d.challenge.challenges(evt.Kind) {
+			metricChallengesSent.Add(1)
+			err := d.awaitChallenge(id, evt)
+			if errors.Is(err, errShuttingDown) {
+				return &JobError{Reason: ReasonShuttingDown, Message: "DVM is shutting down, resubmit the request later", Err: err}
+			}
+			if err != nil {
+				metricChallengesUnanswered.Add(1)
+				return &JobError{Reason: ReasonChallengeUnanswered, Err: err,
+					Message: fmt.Sprintf("Request not confirmed within %v, resubmit it and answer the challenge", d.challenge.Timeout)}
+			}
+		}
+
+		if price := d.jobPrice(job.handler, evt); price > 0 {
+			paid, err := d.awaitPayment(id, evt, price)
+			if errors.Is(err, errShuttingDown) {
+				// What was paid so far goes back
+				job.Paid = paid
+				return &JobError{Reason: ReasonShuttingDown, Message: "DVM is shutting down, resubmit the request later", Err: err}
+			}
+			if err != nil {
+				return &JobError{Message: fmt.Sprintf("Payment of %d msats not received: %v", price, err), Err: err}
+			}
+			d.logs.printf("Request %s paid %d msats", evt.ID[:8], paid)
+			job.Paid = paid
+			d.recordPayment(evt, paid)
+			d.hooks.firePayment(evt, paid)
+		}
+		return next(ctx, job)
+	}
+}
+
+// cacheStage answers a requester asking again for a job that just ran,
+// say because they missed the result, with that result again, and
+// remembers results for it.
+func (d *Dvm) cacheStage(next JobFunc) JobFunc {
+	return func(ctx context.Context, job *Job) error {
+		if d.replayResult(job.id, job.Request) {
+			return nil
+		}
+		if err := next(ctx, job); err != nil {
+			return err
+		}
+		// Paged and ongoing jobs publish more than the result, so aren't
+		// replayed; nor is what the job billed, which a replay doesn't cost
+		if job.receipt != nil && job.receipt.pages == 0 && !job.receipt.ongoing {
+			d.rememberResult(job.id, job.Request, job.Result, job.receipt.tags)
+		}
+		return nil
+	}
+}
+
+// handlerStage runs the job's handler, holding its result to the content
+// policy and attesting the fetch.
+func (d *Dvm) handlerStage(next JobFunc) JobFunc {
+	return func(ctx context.Context, job *Job) error {
+		evt, id := job.Request, job.id
+		ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+		defer cancel()
+		publishMore := func(ctx context.Context, content []byte, tags []nostr.Tag) error {
+			content, refusal := d.policy.apply(ctx, evt.Kind, content)
+			if refusal != "" {
+				return fmt.Errorf("result withheld by this DVM's content policy")
+			}
+			_, err := d.publishResult(id, evt, content, tags, nil)
+			return err
+		}
+		receipt := &jobReceipt{
+			progress: func(message string) {
+				d.publishFeedback(id, evt, StatusProcessing, "", message)
+			},
+			page: func(content []byte, tags ...nostr.Tag) error {
+				return publishMore(ctx, content, tags)
+			},
+			followUp: func(content []byte, tags ...nostr.Tag) error {
+				ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+				defer cancel()
+				return publishMore(ctx, content, tags)
+			},
+		}
+		job.receipt = receipt
+		ctx = context.WithValue(ctx, jobReceiptKey{}, receipt)
+		result, err := handle(ctx, job.handler, evt)
+		if err != nil {
+			d.alerts.jobDone(err)
+			return err
+		}
+		if !receipt.policed {
+			var refusal string
+			if result, refusal = d.policy.apply(ctx, evt.Kind, result); refusal != "" {
+				log.Printf("Refusing request %s: result violates content policy: %s", evt.ID[:8], refusal)
+				return &JobError{Reason: ReasonContentPolicy, Message: "Result withheld by this DVM's content policy",
+					Err: fmt.Errorf("result withheld by this DVM's content policy: %s", refusal)}
+			}
+		}
+
+		// Attested before it's remembered, so a replay carries the
+		// attestation of the fetch it replays. DM results' attestations
+		// would give away a hash of what's encrypted, so they have none
+		if d.attestFetches && d.resultMode != ResultsAsDMs {
+			if att, err := d.attestFetch(id, evt, result, receipt); err != nil {
+				log.Printf("Failed to attest the result of request %s: %v", evt.ID[:8], err)
+			} else {
+				receipt.tags = append(receipt.tags, att.tag())
+			}
+		}
+		job.Result = result
+		return next(ctx, job)
+	}
+}
+
+// publishStage publishes the job's result, ending the pipeline.
+func (d *Dvm) publishStage(ctx context.Context, job *Job) error {
+	evt, id, receipt := job.Request, job.id, job.receipt
+	if receipt == nil {
+		receipt = &jobReceipt{}
+	}
+	var tags nostr.Tags
+	if receipt.amountMsats > 0 {
+		tags = append(tags, nostr.Tag{"amount", strconv.FormatInt(receipt.amountMsats, 10)})
+	}
+	tags = append(tags, receipt.tags...)
+	if receipt.pages > 0 {
+		// Clients streaming the pages know how many to wait for
+		tags = append(tags, nostr.Tag{"total-pages", strconv.Itoa(receipt.pages)})
+	}
+	d.logs.printf("Publishing response for request %s as %s", evt.ID[:8], id.name)
+	_, err := d.publishResult(id, evt, job.Result, tags, func(resp *nostr.Event, err error) {
+		d.alerts.jobDone(err)
+		if err == nil {
+			d.audit.record(evt, resp)
+		}
+		d.hooks.fireDone(evt, resp, err)
+	})
+	if err != nil {
+		d.alerts.jobDone(err)
+		reason := ""
+		if errors.Is(err, errResultTooLarge) {
+			reason = ReasonResultTooLarge
+		}
+		return &JobError{Reason: reason, Message: fmt.Sprintf("Result couldn't be published: %v", err), Err: err}
+	}
+	return nil
+}
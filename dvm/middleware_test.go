@@ -0,0 +1,108 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMiddleware(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	// Inserted stages run in order, around the built-in ones
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next JobFunc) JobFunc {
+			return func(ctx context.Context, job *Job) error {
+				order = append(order, name)
+				return next(ctx, job)
+			}
+		}
+	}
+	blocked := func(next JobFunc) JobFunc {
+		return func(ctx context.Context, job *Job) error {
+			if job.Request.Content == "21" {
+				return &JobError{Reason: "blocked", Message: "Tweet 21 is blocked", Err: errors.New("blocked tweet")}
+			}
+			return next(ctx, job)
+		}
+	}
+	shout := func(next JobFunc) JobFunc {
+		return func(ctx context.Context, job *Job) error {
+			job.Result = []byte(strings.ToUpper(string(job.Result)))
+			return next(ctx, job)
+		}
+	}
+	d := startTestDvm(t, relay, WithScraper(scraper),
+		WithMiddleware(StageAuth, trace("first")),
+		WithMiddleware(StageAuth, trace("second")),
+		WithMiddleware(StageHandler, blocked),
+		WithMiddleware(StagePublish, shout))
+
+	req := newTestRequest("21")
+	relay.Publish(req)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Tags.GetFirst([]string{"status", StatusError, "blocked"}) == nil || fb.Content != "Tweet 21 is blocked" {
+		t.Fatalf("expected blocked feedback, got %+v", fb)
+	}
+	if scraper.Calls() != 0 {
+		t.Error("expected the blocked request not to be scraped")
+	}
+
+	req = newTestRequest("20")
+	relay.Publish(req)
+	resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if resp.Kind != ResultKind(KindTweetRequest) || resp.Content != strings.ToUpper(resp.Content) {
+		t.Errorf("expected the result changed before publishing, got %q", resp.Content)
+	}
+	if len(order) != 4 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("unexpected stage order %v", order)
+	}
+}
+
+func TestMiddlewareUnknownStage(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	noop := func(next JobFunc) JobFunc { return next }
+	if _, err := NewDvm(relay.URL(), testKey(), WithMiddleware("billing", noop)); err == nil {
+		t.Error("expected an unknown stage to be rejected")
+	}
+}
+
+func TestReplaysSkipPayment(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	// Stands in for the payment stage, which a replay mustn't reach
+	var charged int32
+	charge := func(next JobFunc) JobFunc {
+		return func(ctx context.Context, job *Job) error {
+			atomic.AddInt32(&charged, 1)
+			return next(ctx, job)
+		}
+	}
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithCache(1<<20), WithResultReplay(time.Minute),
+		WithMiddleware(StagePayment, charge))
+	sk := testKey()
+
+	first := newTestRequestFrom(sk, "20")
+	relay.Publish(first)
+	awaitResponse(t, relay, d.GetPublicKey(), first.ID)
+	// The same job again, as a request of its own
+	again := &nostr.Event{CreatedAt: nostr.Now(), Kind: first.Kind, Content: first.Content,
+		Tags: nostr.Tags{{"relays", relay.URL()}}}
+	again.Sign(sk)
+	relay.Publish(again)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), again.ID); resp.Tags.GetFirst([]string{"replayed"}) == nil {
+		t.Fatal("expected the duplicate to be replayed")
+	}
+	if n := atomic.LoadInt32(&charged); n != 1 {
+		t.Errorf("expected only the job that ran to reach payment, got %d", n)
+	}
+}
@@ -0,0 +1,121 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// TweetMirrors is the result of a KindMirrorsRequest job: the nostr notes
+// that already carry a tweet, so it needn't be mirrored again.
+type TweetMirrors struct {
+	TweetID string       `json:"tweet_id"`
+	Notes   []MirrorNote `json:"notes"` // newest first
+}
+
+// MirrorNote is a note referencing the tweet.
+type MirrorNote struct {
+	EventID   string          `json:"event_id"`
+	PubKey    string          `json:"pubkey"`
+	CreatedAt nostr.Timestamp `json:"created_at"`
+	Relays    []string        `json:"relays"` // where it was found
+	// Type is "mirror" for copies of the tweet (bridged notes with a NIP-48
+	// proxy tag, or tweet results like this DVM's) and "quote" for notes
+	// that merely link to it.
+	Type string `json:"type"`
+}
+
+// mirrorsHandler searches the DVM's relays, and any NIP-50 search relays
+// configured with WithSearchRelays, for notes referencing a tweet.
+type mirrorsHandler struct {
+	d            *Dvm
+	searchRelays []string
+}
+
+func (h *mirrorsHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		return fmt.Errorf("content is not a tweet ID")
+	}
+	return nil
+}
+
+func (h *mirrorsHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	tweetID := req.Content
+
+	// Links to tweets include the author, which we need for "r" tag lookups
+	users := []string{"i"}
+	if tweet, err := h.d.fetchTweet(tweetID); err != nil {
+		log.Printf("Looking up mirrors of %s without its author: %v", h.d.scrub.logContent(tweetID), err)
+	} else if tweet.Username != "" {
+		users = append(users, tweet.Username)
+	}
+	tagFilter := nostr.Filter{Kinds: []int{1}, Tags: nostr.TagMap{"r": tweetURLs(users, tweetID)}, Limit: 500}
+	searchFilter := nostr.Filter{Kinds: []int{1}, Search: tweetID, Limit: 500}
+	found := h.d.queryRelays(ctx, []nostr.Filter{tagFilter}, h.searchRelays, []nostr.Filter{tagFilter, searchFilter})
+
+	link := tweetLink(tweetID)
+	result := TweetMirrors{TweetID: tweetID, Notes: []MirrorNote{}}
+	for _, f := range found {
+		if kind := mirrorType(f.Event, tweetID, link); kind != "" {
+			result.Notes = append(result.Notes, MirrorNote{
+				EventID:   f.Event.ID,
+				PubKey:    f.Event.PubKey,
+				CreatedAt: f.Event.CreatedAt,
+				Relays:    f.Relays,
+				Type:      kind,
+			})
+		}
+	}
+	sort.Slice(result.Notes, func(i, j int) bool {
+		if result.Notes[i].CreatedAt != result.Notes[j].CreatedAt {
+			return result.Notes[i].CreatedAt > result.Notes[j].CreatedAt
+		}
+		return result.Notes[i].EventID < result.Notes[j].EventID
+	})
+	return json.Marshal(result)
+}
+
+// tweetURLs lists the forms a link to the tweet takes in "r" tags.
+func tweetURLs(users []string, tweetID string) []string {
+	var urls []string
+	for _, host := range []string{"twitter.com", "x.com", "mobile.twitter.com", "www.twitter.com"} {
+		for _, user := range users {
+			urls = append(urls, fmt.Sprintf("https://%s/%s/status/%s", host, user, tweetID))
+		}
+	}
+	return urls
+}
+
+// tweetLink matches links to the tweet; tweetID must be numeric.
+func tweetLink(tweetID string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b(?:twitter|x)\.com/(?:\w+|i/web)/status(?:es)?/` + tweetID + `\b`)
+}
+
+// mirrorType reports how evt references the tweet: "mirror", "quote", or
+// "" if it doesn't. Relays match search terms loosely, so every candidate
+// is checked.
+func mirrorType(evt *nostr.Event, tweetID string, link *regexp.Regexp) string {
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == "proxy" && link.MatchString(tag[1]) {
+			return "mirror"
+		}
+	}
+	var tweet struct{ ID string }
+	if json.Unmarshal([]byte(evt.Content), &tweet) == nil && tweet.ID == tweetID {
+		return "mirror"
+	}
+	if link.MatchString(evt.Content) {
+		return "quote"
+	}
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == "r" && link.MatchString(tag[1]) {
+			return "quote"
+		}
+	}
+	return ""
+}
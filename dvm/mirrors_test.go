@@ -0,0 +1,62 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMirrorsHandler(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	// relaytest ignores "search", so this relay returns every note and the
+	// handler has to pick out the relevant ones itself
+	search := relaytest.NewServer()
+	defer search.Close()
+
+	note := func(createdAt int64, content string, tags ...nostr.Tag) *nostr.Event {
+		evt := &nostr.Event{CreatedAt: nostr.Timestamp(createdAt), Kind: 1, Tags: tags, Content: content}
+		evt.Sign(testKey())
+		return evt
+	}
+	tweetURL := "https://twitter.com/halfin/status/1110302988"
+	bridged := note(100, "Running bitcoin", nostr.Tag{"proxy", tweetURL, "web"}, nostr.Tag{"r", tweetURL})
+	quote := note(200, "The first tweet about bitcoin: https://x.com/halfin/status/1110302988")
+	result := note(300, `{"ID":"1110302988","Username":"halfin","Text":"Running bitcoin"}`)
+	unrelated := note(400, "A different tweet: https://x.com/halfin/status/11103029880")
+	relay.Publish(bridged)
+	for _, evt := range []*nostr.Event{bridged, quote, result, unrelated} {
+		search.Publish(evt)
+	}
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithSearchRelays(search.URL()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := d.handlers[KindMirrorsRequest].Handle(ctx, &nostr.Event{Content: "1110302988"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mirrors TweetMirrors
+	if err := json.Unmarshal(out, &mirrors); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		id, typ string
+		relays  int
+	}{{result.ID, "mirror", 1}, {quote.ID, "quote", 1}, {bridged.ID, "mirror", 2}}
+	if len(mirrors.Notes) != len(want) {
+		t.Fatalf("expected %d notes, got %+v", len(want), mirrors.Notes)
+	}
+	for i, w := range want {
+		got := mirrors.Notes[i]
+		if got.EventID != w.id || got.Type != w.typ || len(got.Relays) != w.relays {
+			t.Errorf("note %d: got %+v, want %s %s on %d relays", i, got, w.id[:8], w.typ, w.relays)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	defaultMonitorInterval = 15 * time.Minute
+	defaultMonitorDuration = 24 * time.Hour
+	maxMonitorDuration     = 7 * 24 * time.Hour
+	maxMonitors            = 100
+)
+
+// minMonitorInterval is the shortest re-fetch interval a requester may ask
+// for, to spare the scraper.
+var minMonitorInterval = time.Minute
+
+// TweetMonitor is the first result of a KindMonitorRequest job: the version
+// of the tweet changes are reported against, and how long it's watched.
+type TweetMonitor struct {
+	TweetID   string          `json:"tweet_id"`
+	Text      string          `json:"text"`
+	Interval  int64           `json:"interval"` // seconds between checks
+	ExpiresAt nostr.Timestamp `json:"expires_at"`
+}
+
+// TweetChange is a further result of a KindMonitorRequest job, tagged
+// ["change", <Change>], published when the monitored tweet changes.
+type TweetChange struct {
+	TweetID string `json:"tweet_id"`
+	// Change is "edited", or for a tweet that's gone its status as an
+	// existence check would report it, such as "deleted"
+	Change     string          `json:"change"`
+	Previous   string          `json:"previous"`
+	Current    string          `json:"current,omitempty"`
+	Diff       []DiffChunk     `json:"diff,omitempty"`
+	DetectedAt nostr.Timestamp `json:"detected_at"`
+}
+
+// DiffChunk is a run of words kept, removed or added by an edit.
+type DiffChunk struct {
+	Op   string `json:"op"` // "=", "-" or "+"
+	Text string `json:"text"`
+}
+
+// monitorHandler watches a tweet for edits and deletion, re-fetching it
+// every ["param", "interval", <duration>] for ["param", "duration",
+// <duration>]. Each change is published as a further result for the
+// request; monitoring stops once the tweet is gone. Monitors are held in
+// memory, so they end if the DVM restarts.
+type monitorHandler struct {
+	d *Dvm
+
+	mu     sync.Mutex
+	active int
+}
+
+func (h *monitorHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		return fmt.Errorf("content is not a tweet ID")
+	}
+	_, err := parseMonitorParams(req)
+	return err
+}
+
+// monitorParams are the params of a monitor request.
+type monitorParams struct {
+	Interval time.Duration `param:"interval"`
+	Duration time.Duration `param:"duration"`
+}
+
+func parseMonitorParams(req *nostr.Event) (monitorParams, error) {
+	params := monitorParams{Interval: defaultMonitorInterval, Duration: defaultMonitorDuration}
+	if err := DecodeParams(req, &params); err != nil {
+		return params, err
+	}
+	if params.Interval < minMonitorInterval {
+		return params, fmt.Errorf("interval param must be at least %v", minMonitorInterval)
+	}
+	if params.Duration <= 0 || params.Duration > maxMonitorDuration {
+		return params, fmt.Errorf("duration param must be positive and at most %v", maxMonitorDuration)
+	}
+	return params, nil
+}
+
+func (h *monitorHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	publish := followUp(ctx)
+	if publish == nil {
+		return nil, errors.New("monitoring isn't supported here")
+	}
+	params, err := parseMonitorParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	if h.active >= maxMonitors {
+		h.mu.Unlock()
+		return nil, errors.New("too many tweets are being monitored, try again later")
+	}
+	h.active++
+	h.mu.Unlock()
+
+	tweet, _, err := h.d.scrapeTweet(req.Content)
+	if err != nil {
+		h.release()
+		return nil, err
+	}
+	expires := time.Now().Add(params.Duration)
+	go h.watch(tweet.ID, tweet.Text, params.Interval, expires, publish)
+
+	return json.Marshal(TweetMonitor{
+		TweetID:   tweet.ID,
+		Text:      tweet.Text,
+		Interval:  int64(params.Interval / time.Second),
+		ExpiresAt: nostr.Timestamp(expires.Unix()),
+	})
+}
+
+func (h *monitorHandler) release() {
+	h.mu.Lock()
+	h.active--
+	h.mu.Unlock()
+}
+
+// watch re-fetches the tweet every interval until it's gone, the monitor
+// expires or the DVM stops, publishing each change.
+func (h *monitorHandler) watch(id, text string, interval time.Duration, expires time.Time, publish func([]byte, ...nostr.Tag) error) {
+	defer h.release()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	expiry := time.NewTimer(time.Until(expires))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-h.d.done:
+			return
+		case <-expiry.C:
+			log.Printf("Monitor of tweet %s expired", id)
+			return
+		case <-ticker.C:
+		}
+
+		change := TweetChange{TweetID: id, Previous: text, DetectedAt: nostr.Now()}
+		tweet, err := h.d.scraper.GetTweet(id)
+		if err == nil && tweet != nil && tweet.ID != "" {
+			if tweet.Text == text {
+				continue
+			}
+			change.Change = "edited"
+			change.Current = tweet.Text
+			change.Diff = diffWords(text, tweet.Text)
+			text = tweet.Text
+		} else {
+			status, gone := goneStatus(err)
+			if !gone {
+				log.Printf("Monitor of tweet %s failed to fetch it: %v", id, err)
+				continue
+			}
+			change.Change = status
+		}
+
+		content, err := json.Marshal(change)
+		if err == nil {
+			err = publish(content, nostr.Tag{"change", change.Change})
+		}
+		if err != nil {
+			log.Printf("Failed to publish change to tweet %s: %v", id, err)
+		}
+		if change.Change != "edited" {
+			return
+		}
+	}
+}
+
+// diffWords diffs two texts word by word, by their longest common
+// subsequence.
+func diffWords(before, after string) []DiffChunk {
+	a, b := strings.Fields(before), strings.Fields(after)
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var chunks []DiffChunk
+	add := func(op, word string) {
+		if n := len(chunks); n > 0 && chunks[n-1].Op == op {
+			chunks[n-1].Text += " " + word
+			return
+		}
+		chunks = append(chunks, DiffChunk{Op: op, Text: word})
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add("=", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add("-", a[i])
+			i++
+		default:
+			add("+", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add("-", a[i])
+	}
+	for ; j < len(b); j++ {
+		add("+", b[j])
+	}
+	return chunks
+}
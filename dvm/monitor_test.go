@@ -0,0 +1,106 @@
+package dvm
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// editingScraper serves a tweet that's edited on its third fetch and
+// deleted on its fifth.
+type editingScraper struct {
+	fakeScraper
+}
+
+func (s *editingScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	tweet, _ := s.fakeScraper.GetTweet(id)
+	switch n := s.Calls(); {
+	case n >= 5:
+		return nil, errors.New("response status 404 Not Found: No status found with that ID.")
+	case n >= 3:
+		tweet.Text = "Running bitcoin again"
+	default:
+		tweet.Text = "Running bitcoin"
+	}
+	return tweet, nil
+}
+
+func TestMonitorHandler(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { minMonitorInterval = interval }(minMonitorInterval)
+	minMonitorInterval = 10 * time.Millisecond
+
+	d := startTestDvm(t, relay, WithScraper(&editingScraper{}))
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindMonitorRequest, Content: "1110302988",
+		Tags: nostr.Tags{{"param", "interval", "50ms"}}}
+	req.Sign(testKey())
+	d.handleRequest(req)
+
+	var monitor *TweetMonitor
+	var changes []TweetChange
+	deadline := time.Now().Add(5 * time.Second)
+	for len(changes) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		monitor, changes = nil, nil
+		for _, evt := range relay.Events() {
+			if evt.Kind != ResultKind(req.Kind) || evt.Tags.GetFirst([]string{"e", req.ID}) == nil {
+				continue
+			}
+			if evt.Tags.GetFirst([]string{"change"}) == nil {
+				monitor = &TweetMonitor{}
+				json.Unmarshal([]byte(evt.Content), monitor)
+				continue
+			}
+			var change TweetChange
+			json.Unmarshal([]byte(evt.Content), &change)
+			changes = append(changes, change)
+		}
+	}
+
+	if monitor == nil || monitor.Text != "Running bitcoin" || monitor.ExpiresAt <= nostr.Now() {
+		t.Fatalf("unexpected first result %+v", monitor)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected an edit and a deletion, got %+v", changes)
+	}
+	edit, deletion := changes[0], changes[1]
+	if edit.Change != "edited" || edit.Previous != "Running bitcoin" || edit.Current != "Running bitcoin again" {
+		t.Errorf("unexpected edit %+v", edit)
+	}
+	if want := []DiffChunk{{"=", "Running bitcoin"}, {"+", "again"}}; !reflect.DeepEqual(edit.Diff, want) {
+		t.Errorf("expected diff %v, got %v", want, edit.Diff)
+	}
+	if deletion.Change != TweetDeleted || deletion.Previous != "Running bitcoin again" {
+		t.Errorf("unexpected deletion %+v", deletion)
+	}
+
+	// Monitoring stops with the tweet gone
+	time.Sleep(200 * time.Millisecond)
+	h := d.handlers[KindMonitorRequest].(*monitorHandler)
+	h.mu.Lock()
+	if h.active != 0 {
+		t.Errorf("expected no active monitors, got %d", h.active)
+	}
+	h.mu.Unlock()
+
+	for _, bad := range []nostr.Tags{{{"param", "interval", "1ms"}}, {{"param", "duration", "720h"}}} {
+		if err := d.handlers[KindMonitorRequest].Validate(&nostr.Event{Content: "1", Tags: bad}); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	got := diffWords("the quick brown fox jumps", "the slow brown fox leaps high")
+	want := []DiffChunk{{"=", "the"}, {"-", "quick"}, {"+", "slow"}, {"=", "brown fox"}, {"-", "jumps"}, {"+", "leaps high"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package dvm
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// NIP-06 keys are derived from a BIP-39 mnemonic along the BIP-32 path
+// m/44'/1237'/<account>'/0/0, so the same words restore the same identity
+// in any NIP-06 client.
+const (
+	nip06Purpose  = 44
+	nip06CoinType = 1237
+	bip32Hardened = 1 << 31
+)
+
+// KeyFromMnemonic derives the hex private key of account from a BIP-39
+// mnemonic and its optional passphrase, as NIP-06 does; account 0 is the
+// one other clients use by default. The words must be from the English
+// word list and their checksum must hold, so a mistyped word fails rather
+// than deriving someone else's key.
+func KeyFromMnemonic(mnemonic, passphrase string, account uint32) (string, error) {
+	words := strings.Fields(mnemonic)
+	if err := checkMnemonic(words); err != nil {
+		return "", err
+	}
+	if account >= bip32Hardened {
+		return "", fmt.Errorf("invalid account %d", account)
+	}
+
+	// BIP-39: the seed is PBKDF2 of the words, salted with the passphrase
+	seed, err := pbkdf2Key(sha512.New, []byte(strings.Join(words, " ")), []byte("mnemonic"+passphrase), 2048, 64)
+	if err != nil {
+		return "", err
+	}
+	key, chain, err := bip32Master(seed)
+	if err != nil {
+		return "", err
+	}
+	for _, index := range []uint32{nip06Purpose + bip32Hardened, nip06CoinType + bip32Hardened, account + bip32Hardened, 0, 0} {
+		if key, chain, err = bip32Child(key, chain, index); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// checkMnemonic checks words are a BIP-39 mnemonic: each from the word
+// list, with the checksum their last bits carry, the first bits of the
+// SHA-256 of the rest, matching.
+func checkMnemonic(words []string) error {
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return fmt.Errorf("a mnemonic has 12, 15, 18, 21 or 24 words, not %d", len(words))
+	}
+	// 11 bits a word: the entropy, then a bit of checksum per 32 of it
+	bits := make([]bool, 0, 11*len(words))
+	for _, word := range words {
+		index, ok := bip39Index[word]
+		if !ok {
+			return fmt.Errorf("invalid mnemonic word %q: not in the BIP-39 English word list", word)
+		}
+		for i := 10; i >= 0; i-- {
+			bits = append(bits, index>>i&1 == 1)
+		}
+	}
+	checksumBits := len(words) / 3
+	entropy := make([]byte, (len(bits)-checksumBits)/8)
+	for i := range entropy {
+		for j := 0; j < 8; j++ {
+			if bits[8*i+j] {
+				entropy[i] |= 0x80 >> j
+			}
+		}
+	}
+	sum := sha256.Sum256(entropy)
+	for i := 0; i < checksumBits; i++ {
+		if bits[8*len(entropy)+i] != (sum[0]&(0x80>>i) != 0) {
+			return errors.New("invalid mnemonic: checksum mismatch, check the words")
+		}
+	}
+	return nil
+}
+
+// bip32Master returns the BIP-32 master private key and chain code of seed.
+func bip32Master(seed []byte) (key, chain []byte, err error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	var k btcec.ModNScalar
+	if overflow := k.SetByteSlice(sum[:32]); overflow || k.IsZero() {
+		return nil, nil, errors.New("seed derives an invalid master key")
+	}
+	return sum[:32], sum[32:], nil
+}
+
+// bip32Child derives the private child key at index, hardened from
+// bip32Hardened on, of a BIP-32 private key and chain code.
+func bip32Child(key, chain []byte, index uint32) ([]byte, []byte, error) {
+	var data []byte
+	if index >= bip32Hardened {
+		data = append([]byte{0}, key...)
+	} else {
+		priv, _ := btcec.PrivKeyFromBytes(key)
+		data = priv.PubKey().SerializeCompressed()
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+	mac := hmac.New(sha512.New, chain)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	var tweak, parent btcec.ModNScalar
+	parent.SetByteSlice(key)
+	if overflow := tweak.SetByteSlice(sum[:32]); overflow {
+		return nil, nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+	child := tweak.Add(&parent)
+	if child.IsZero() {
+		return nil, nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+	bytes := child.Bytes()
+	return bytes[:], sum[32:], nil
+}
+
+// WithClientKey has the client send requests as sk, a hex private key,
+// rather than a key of its own made up for the session; with
+// KeyFromMnemonic, the same identity on every machine.
+func WithClientKey(sk string) ClientOption {
+	return func(c *DvmClient) {
+		c.sk = sk
+	}
+}
+
+// NewMnemonicKeyProvider returns a provider deriving the private key of
+// account from a mnemonic as KeyFromMnemonic does, so an operator can
+// restore the DVM's identity from words they wrote down.
+func NewMnemonicKeyProvider(mnemonic, passphrase string, account uint32) KeyProvider {
+	return &mnemonicKeyProvider{mnemonic: mnemonic, passphrase: passphrase, account: account}
+}
+
+type mnemonicKeyProvider struct {
+	mnemonic, passphrase string
+	account              uint32
+}
+
+func (p *mnemonicKeyProvider) PrivateKey(ctx context.Context) (string, error) {
+	if strings.TrimSpace(p.mnemonic) == "" {
+		return "", errors.New("no mnemonic")
+	}
+	return KeyFromMnemonic(p.mnemonic, p.passphrase, p.account)
+}
@@ -0,0 +1,88 @@
+package dvm
+
+import (
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestKeyFromMnemonic(t *testing.T) {
+	// The NIP-06 test vectors
+	for _, tc := range []struct{ mnemonic, sk, pk string }{
+		{"leader monkey parrot ring guide accident before fence cannon height naive bean",
+			"7f7ff03d123792d6ac594bfa67bf6d0c0ab55b6b1fdb6249303fe861f1ccba9a",
+			"17162c921dc4d2518f9a101db33695df1afb56ab82f5ff3e5da6eec3ca5cd917"},
+		{"what bleak badge arrange retreat wolf trade produce cricket blur garlic valid proud rude strong choose busy staff weather area salt hollow arm fade",
+			"c15d739894c81a2fcfd3a2df85a0d2c0dbc47a280d092799f144d73d7ae78add",
+			"d41b22899549e1f3d335a31002cfd382174006e166d3e658e3a5eecdb6463573"},
+	} {
+		sk, err := KeyFromMnemonic(tc.mnemonic, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sk != tc.sk {
+			t.Errorf("derived %s, want %s", sk, tc.sk)
+		}
+		if pk, _ := nostr.GetPublicKey(sk); pk != tc.pk {
+			t.Errorf("derived pubkey %s, want %s", pk, tc.pk)
+		}
+	}
+
+	// Other accounts and passphrases are other identities
+	words := "leader monkey parrot ring guide accident before fence cannon height naive bean"
+	base, _ := KeyFromMnemonic(words, "", 0)
+	if other, _ := KeyFromMnemonic(words, "", 1); other == base {
+		t.Error("expected account 1 to derive another key")
+	}
+	if other, _ := KeyFromMnemonic(words, "hunter2", 0); other == base {
+		t.Error("expected a passphrase to derive another key")
+	}
+	if _, err := KeyFromMnemonic("leader monkey parrot", "", 0); err == nil {
+		t.Error("expected a 3-word mnemonic to be refused")
+	}
+	// A typo, caught by the word list or the checksum
+	for _, typo := range []string{
+		"leader monkey parrot ring guide accident before fence cannon height naive beam",
+		"leader monkey parrot ring guide accident before fence cannon height naive bea",
+		"monkey leader parrot ring guide accident before fence cannon height naive bean",
+		"Leader monkey parrot ring guide accident before fence cannon height naive bean",
+	} {
+		if _, err := KeyFromMnemonic(typo, "", 0); err == nil {
+			t.Errorf("expected %q to be refused", typo)
+		}
+	}
+	// The BIP-39 test vectors' mnemonics are valid, the checksum too
+	for _, words := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+	} {
+		if err := checkMnemonic(strings.Fields(words)); err != nil {
+			t.Errorf("%q: %v", words, err)
+		}
+	}
+	if err := checkMnemonic(strings.Fields("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon")); err == nil {
+		t.Error("expected a bad checksum to be refused")
+	}
+}
+
+func TestWithClientKey(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	sk, _ := KeyFromMnemonic("leader monkey parrot ring guide accident before fence cannon height naive bean", "", 0)
+	client, err := NewDvmClient(relay.URL(), WithClientKey(sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.pk != "17162c921dc4d2518f9a101db33695df1afb56ab82f5ff3e5da6eec3ca5cd917" {
+		t.Errorf("expected the client to request as the mnemonic's identity, got %s", client.pk)
+	}
+
+	if _, err := NewDvmClient(relay.URL(), WithClientKey("nsec")); err == nil {
+		t.Error("expected an invalid client key to be refused")
+	}
+}
@@ -0,0 +1,213 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// HandleLookup is the result of a KindHandleRequest job: the nostr
+// keys whose profiles claim a Twitter handle, split by whether the proof
+// tweet checks out.
+type HandleLookup struct {
+	Handle   string          `json:"handle"`
+	Verified []IdentityClaim `json:"verified"`
+	Rejected []IdentityClaim `json:"rejected"`
+}
+
+// IdentityClaim is a NIP-39 "i" tag on a kind-0 profile.
+type IdentityClaim struct {
+	PubKey string `json:"pubkey"`
+	Npub   string `json:"npub"`
+	Proof  string `json:"proof"`            // the proof tweet's ID
+	Reason string `json:"reason,omitempty"` // why a claim was rejected
+}
+
+// twitterHandlePattern matches a Twitter handle, with or without the @.
+var twitterHandlePattern = regexp.MustCompile(`^@?[A-Za-z0-9_]{1,15}$`)
+
+// handleLookupHandler resolves a Twitter handle to npubs through NIP-39
+// external identity claims, checking each proof tweet with the scraper.
+type handleLookupHandler struct {
+	d            *Dvm
+	searchRelays []string
+}
+
+func (h *handleLookupHandler) Validate(req *nostr.Event) error {
+	if !twitterHandlePattern.MatchString(strings.TrimSpace(req.Content)) {
+		return fmt.Errorf("content is not a Twitter handle")
+	}
+	return nil
+}
+
+func (h *handleLookupHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	handle := strings.TrimPrefix(strings.TrimSpace(req.Content), "@")
+	identity := "twitter:" + strings.ToLower(handle)
+
+	// Claims are made with the handle as the user typed it
+	values := []string{identity}
+	if exact := "twitter:" + handle; exact != identity {
+		values = append(values, exact)
+	}
+	filter := nostr.Filter{Kinds: []int{0}, Tags: nostr.TagMap{"i": values}, Limit: 500}
+	found := h.d.queryRelays(ctx, []nostr.Filter{filter}, h.searchRelays, []nostr.Filter{filter})
+
+	// Profiles are replaceable: only each key's latest counts
+	latest := make(map[string]*nostr.Event)
+	for _, f := range found {
+		evt := f.Event
+		if prev, ok := latest[evt.PubKey]; !ok || evt.CreatedAt > prev.CreatedAt {
+			latest[evt.PubKey] = evt
+		}
+	}
+
+	result := HandleLookup{Handle: handle, Verified: []IdentityClaim{}, Rejected: []IdentityClaim{}}
+	for pubkey, profile := range latest {
+		proof, ok := twitterClaim(profile, identity)
+		if !ok {
+			continue // an older profile made the claim; the latest dropped it
+		}
+		claim := IdentityClaim{PubKey: pubkey, Proof: proof}
+		var err error
+		if claim.Npub, err = encodeNpub(pubkey); err != nil {
+			continue
+		}
+		if claim.Reason = h.d.checkTwitterProof(handle, claim.Npub, proof); claim.Reason == "" {
+			result.Verified = append(result.Verified, claim)
+		} else {
+			result.Rejected = append(result.Rejected, claim)
+		}
+	}
+	sort.Slice(result.Verified, func(i, j int) bool { return result.Verified[i].PubKey < result.Verified[j].PubKey })
+	sort.Slice(result.Rejected, func(i, j int) bool { return result.Rejected[i].PubKey < result.Rejected[j].PubKey })
+	return json.Marshal(result)
+}
+
+// twitterClaim returns the proof of profile's claim to identity, matching
+// the handle case-insensitively as Twitter does.
+func twitterClaim(profile *nostr.Event, identity string) (string, bool) {
+	for _, tag := range profile.Tags {
+		if len(tag) >= 3 && tag[0] == "i" && strings.ToLower(tag[1]) == identity {
+			return tag[2], true
+		}
+	}
+	return "", false
+}
+
+// checkTwitterProof verifies a proof tweet: per NIP-39 it must be posted by
+// the handle and contain the claimant's npub. It returns why the claim
+// fails, or "" if it holds.
+func (d *Dvm) checkTwitterProof(handle, npub, proof string) string {
+	if !tweetIDPattern.MatchString(proof) {
+		return "proof is not a tweet ID"
+	}
+	tweet, err := d.fetchTweet(proof)
+	if err != nil {
+		log.Printf("Can't fetch proof tweet %s for %s: %v", proof, npub, err)
+		return "proof tweet unavailable"
+	}
+	if !strings.EqualFold(tweet.Username, handle) {
+		return fmt.Sprintf("proof tweet is by @%s", tweet.Username)
+	}
+	if !strings.Contains(tweet.Text, npub) {
+		return "proof tweet doesn't contain the npub"
+	}
+	return ""
+}
+
+// IdentityVerification is the result of a KindVerifyRequest job. Signed
+// by the DVM, it attests that the proof tweet was checked at VerifiedAt.
+type IdentityVerification struct {
+	PubKey     string          `json:"pubkey"`
+	Npub       string          `json:"npub"`
+	Handle     string          `json:"handle"`
+	Proof      string          `json:"proof"`
+	Verified   bool            `json:"verified"`
+	Reason     string          `json:"reason,omitempty"` // why verification failed
+	VerifiedAt nostr.Timestamp `json:"verified_at"`
+}
+
+// verifyHandler checks one NIP-39 Twitter claim. The request's content is
+// the npub, with ["param", "handle", <handle>] and optionally ["param",
+// "proof", <tweet ID>]; without a proof, the claim in the npub's latest
+// profile is checked.
+//
+// Results are tagged ["i", "twitter:<handle>", <proof>] and labeled
+// "verified" or "unverified" in the "nip39" NIP-32 namespace, so they can
+// be found and relied on by services other than the requester.
+type verifyHandler struct {
+	d            *Dvm
+	searchRelays []string
+}
+
+func (h *verifyHandler) Validate(req *nostr.Event) error {
+	if _, err := decodeNpub(strings.TrimSpace(req.Content)); err != nil {
+		return fmt.Errorf("content is not an npub: %w", err)
+	}
+	if handle, ok := paramValue(req, "handle"); !ok || !twitterHandlePattern.MatchString(handle) {
+		return fmt.Errorf("handle param must be a Twitter handle")
+	}
+	if proof, ok := paramValue(req, "proof"); ok && !tweetIDPattern.MatchString(proof) {
+		return fmt.Errorf("proof param must be a tweet ID")
+	}
+	return nil
+}
+
+func (h *verifyHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	npub := strings.TrimSpace(req.Content)
+	pubkey, _ := decodeNpub(npub)
+	handle, _ := paramValue(req, "handle")
+	handle = strings.TrimPrefix(handle, "@")
+	result := IdentityVerification{PubKey: pubkey, Npub: npub, Handle: handle}
+
+	if proof, ok := paramValue(req, "proof"); ok {
+		result.Proof = proof
+	} else {
+		proof, err := h.profileProof(ctx, pubkey, handle)
+		if err != nil {
+			return nil, err
+		}
+		result.Proof = proof
+	}
+
+	result.Reason = h.d.checkTwitterProof(handle, npub, result.Proof)
+	result.Verified = result.Reason == ""
+	result.VerifiedAt = nostr.Now()
+
+	label := "unverified"
+	if result.Verified {
+		label = "verified"
+	}
+	tagResult(ctx,
+		nostr.Tag{"i", "twitter:" + strings.ToLower(handle), result.Proof},
+		nostr.Tag{"L", "nip39"},
+		nostr.Tag{"l", label, "nip39"},
+	)
+	return json.Marshal(result)
+}
+
+// profileProof returns the proof of pubkey's claim to handle in its latest
+// profile.
+func (h *verifyHandler) profileProof(ctx context.Context, pubkey, handle string) (string, error) {
+	filter := nostr.Filter{Kinds: []int{0}, Authors: []string{pubkey}, Limit: 10}
+	var profile *nostr.Event
+	for _, f := range h.d.queryRelays(ctx, []nostr.Filter{filter}, h.searchRelays, []nostr.Filter{filter}) {
+		if profile == nil || f.Event.CreatedAt > profile.CreatedAt {
+			profile = f.Event
+		}
+	}
+	if profile == nil {
+		return "", fmt.Errorf("no profile found for %s", pubkey[:8])
+	}
+	proof, ok := twitterClaim(profile, "twitter:"+strings.ToLower(handle))
+	if !ok {
+		return "", fmt.Errorf("profile doesn't claim @%s; pass the proof param to check a tweet anyway", handle)
+	}
+	return proof, nil
+}
@@ -0,0 +1,161 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// proofScraper serves proof tweets by ID.
+type proofScraper map[string]*twitterscraper.Tweet
+
+func (p proofScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	if tweet, ok := p[id]; ok {
+		return tweet, nil
+	}
+	return nil, errors.New("tweet not found")
+}
+
+func TestHandleLookupHandler(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	scraper := proofScraper{}
+	// claim publishes a profile claiming handle, proven by a tweet from
+	// author, and returns the claimant's pubkey
+	claim := func(proof, handle, author string, withNpub bool) string {
+		sk := testKey()
+		pk, _ := nostr.GetPublicKey(sk)
+		npub, _ := encodeNpub(pk)
+		text := "Verifying my account on nostr"
+		if withNpub {
+			text += " My Public Key: \"" + npub + "\""
+		}
+		scraper[proof] = &twitterscraper.Tweet{ID: proof, Username: author, Text: text}
+		profile := &nostr.Event{CreatedAt: nostr.Now(), Kind: 0, Content: `{"name":"hal"}`,
+			Tags: nostr.Tags{{"i", "twitter:" + handle, proof}}}
+		profile.Sign(sk)
+		relay.Publish(profile)
+		return pk
+	}
+	verified := claim("1001", "halfin", "halfin", true)
+	mixedCase := claim("1002", "HalFin", "halfin", true)
+	impostor := claim("1003", "halfin", "notHal", true)
+	noNpub := claim("1004", "halfin", "halfin", false)
+	claim("1005", "satoshi", "satoshi", true)
+
+	d := startTestDvm(t, relay, WithScraper(scraper))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := d.handlers[KindHandleRequest]
+	if err := h.Validate(&nostr.Event{Content: "not a handle!"}); err == nil {
+		t.Error("expected an invalid handle to be rejected")
+	}
+	out, err := h.Handle(ctx, &nostr.Event{Content: "@HalFin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lookup HandleLookup
+	if err := json.Unmarshal(out, &lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, c := range lookup.Verified {
+		got[c.PubKey] = "verified"
+		if back, _ := decodeNpub(c.Npub); back != c.PubKey {
+			t.Errorf("npub %s doesn't decode to %s", c.Npub, c.PubKey[:8])
+		}
+	}
+	for _, c := range lookup.Rejected {
+		got[c.PubKey] = c.Reason
+	}
+	want := map[string]string{
+		verified:  "verified",
+		mixedCase: "verified",
+		impostor:  "proof tweet is by @notHal",
+		noNpub:    "proof tweet doesn't contain the npub",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d claims, got %+v", len(want), lookup)
+	}
+	for pk, w := range want {
+		if got[pk] != w {
+			t.Errorf("claim by %s: got %q, want %q", pk[:8], got[pk], w)
+		}
+	}
+}
+
+func TestVerifyHandler(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	sk := testKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	npub, _ := encodeNpub(pk)
+	scraper := proofScraper{
+		"2001": {ID: "2001", Username: "halfin", Text: "Verifying my account on nostr My Public Key: \"" + npub + "\""},
+		"2002": {ID: "2002", Username: "halfin", Text: "Running bitcoin"},
+	}
+	profile := &nostr.Event{CreatedAt: nostr.Now(), Kind: 0, Content: `{"name":"hal"}`,
+		Tags: nostr.Tags{{"i", "twitter:halfin", "2001"}}}
+	profile.Sign(sk)
+	relay.Publish(profile)
+
+	d := startTestDvm(t, relay, WithScraper(scraper))
+	verify := func(params ...nostr.Tag) (*nostr.Event, IdentityVerification) {
+		t.Helper()
+		req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindVerifyRequest, Tags: params, Content: npub}
+		req.Sign(testKey())
+		if err := d.handlers[KindVerifyRequest].Validate(req); err != nil {
+			t.Fatal(err)
+		}
+		d.handleRequest(req)
+		resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+		var result IdentityVerification
+		if resp.Kind == ResultKind(KindVerifyRequest) {
+			if err := json.Unmarshal([]byte(resp.Content), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp, result
+	}
+
+	// The proof is taken from the profile when not given
+	resp, result := verify(nostr.Tag{"param", "handle", "@HalFin"})
+	if !result.Verified || result.PubKey != pk || result.Proof != "2001" {
+		t.Errorf("expected a verified claim, got %+v", result)
+	}
+	if resp.Tags.GetFirst([]string{"i", "twitter:halfin", "2001"}) == nil || resp.Tags.GetFirst([]string{"l", "verified", "nip39"}) == nil {
+		t.Errorf("missing verification tags: %v", resp.Tags)
+	}
+
+	resp, result = verify(nostr.Tag{"param", "handle", "halfin"}, nostr.Tag{"param", "proof", "2002"})
+	if result.Verified || result.Reason != "proof tweet doesn't contain the npub" {
+		t.Errorf("expected a rejected claim, got %+v", result)
+	}
+	if resp.Tags.GetFirst([]string{"l", "unverified", "nip39"}) == nil {
+		t.Errorf("missing unverified label: %v", resp.Tags)
+	}
+
+	if resp, _ := verify(nostr.Tag{"param", "handle", "satoshi"}); resp.Kind != KindJobFeedback {
+		t.Errorf("expected error feedback for an unclaimed handle, got kind %d", resp.Kind)
+	}
+
+	for _, bad := range []nostr.Tags{
+		{},
+		{{"param", "handle", "not a handle"}},
+		{{"param", "handle", "halfin"}, {"param", "proof", "x"}},
+	} {
+		if err := d.handlers[KindVerifyRequest].Validate(&nostr.Event{Tags: bad, Content: npub}); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package dvm
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// expiredAt returns when evt expired by its NIP-40 ["expiration",
+// <unix time>] tag, or false if it hasn't. Events without the tag, or with
+// an unreadable one, never expire.
+func expiredAt(evt *nostr.Event, now time.Time) (time.Time, bool) {
+	value := tagValue(evt.Tags, "expiration")
+	if value == "" {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	at := time.Unix(unix, 0)
+	return at, !now.Before(at)
+}
@@ -0,0 +1,58 @@
+package dvm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestExpiredAt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		tags    nostr.Tags
+		expired bool
+	}{
+		{nil, false},
+		{nostr.Tags{{"expiration", "1699999999"}}, true},
+		{nostr.Tags{{"expiration", "1700000000"}}, true},
+		{nostr.Tags{{"expiration", "1700000001"}}, false},
+		{nostr.Tags{{"expiration", "soon"}}, false},
+	} {
+		if _, expired := expiredAt(&nostr.Event{Tags: tc.tags}, now); expired != tc.expired {
+			t.Errorf("%v: expected expired=%v", tc.tags, tc.expired)
+		}
+	}
+}
+
+func TestExpiredRequestsAreSkipped(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper))
+
+	// As if backfilled after an outage
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindTweetRequest, Content: "20",
+		Tags: nostr.Tags{{"expiration", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}}}
+	req.Sign(testKey())
+	relay.Publish(req)
+
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Kind != KindJobFeedback || fb.Tags.GetFirst([]string{"status", StatusError, ReasonExpired}) == nil {
+		t.Fatalf("expected expired feedback, got %+v", fb)
+	}
+	if scraper.Calls() != 0 {
+		t.Error("expected the expired request not to be scraped")
+	}
+
+	// One that hasn't expired yet is served
+	req = &nostr.Event{CreatedAt: nostr.Now(), Kind: KindTweetRequest, Content: "21",
+		Tags: nostr.Tags{{"expiration", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)}}}
+	req.Sign(testKey())
+	relay.Publish(req)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind != ResultKind(KindTweetRequest) {
+		t.Errorf("expected a result, got kind %d", resp.Kind)
+	}
+}
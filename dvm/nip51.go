@@ -0,0 +1,272 @@
+package dvm
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ListSyncConfig points the DVM's allowlist and denylist at NIP-51 lists
+// the operator maintains from any nostr client, so they're edited by
+// publishing a new version of the list rather than by redeploying. Each
+// is the address of a list: an naddr, or a "<kind>:<pubkey>:<d tag>"
+// coordinate such as "30000:<hex pubkey>:customers" for a follow set or
+// "10000:<hex pubkey>:" for a mute list. The public "p" tags of the
+// list's latest version on the DVM's relays are its members; private,
+// encrypted items can't be read by the DVM.
+type ListSyncConfig struct {
+	Allow string // only its members are served; others get ReasonNotAllowed
+	Deny  string // its members' requests are ignored, as with Deny
+}
+
+// Common NIP-51 list kinds.
+const (
+	KindMuteList  = 10000
+	KindFollowSet = 30000
+)
+
+// listSyncRetry is how soon the lists are subscribed to again after the
+// subscription ends. A var so tests can shorten it.
+var listSyncRetry = 30 * time.Second
+
+// listAddress identifies a replaceable list event.
+type listAddress struct {
+	kind   int
+	pubkey string
+	d      string
+}
+
+func parseListAddress(s string) (listAddress, error) {
+	if strings.HasPrefix(s, "naddr1") {
+		kind, pubkey, d, err := decodeNaddr(s)
+		if err != nil {
+			return listAddress{}, fmt.Errorf("invalid list address %q: %v", s, err)
+		}
+		return listAddress{kind: kind, pubkey: pubkey, d: d}, nil
+	}
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return listAddress{}, fmt.Errorf("invalid list address %q: want an naddr or <kind>:<pubkey>:<d tag>", s)
+	}
+	kind, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return listAddress{}, fmt.Errorf("invalid list address %q: bad kind", s)
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || len(parts[1]) != 64 {
+		return listAddress{}, fmt.Errorf("invalid list address %q: the pubkey must be 64 hex characters", s)
+	}
+	addr := listAddress{kind: kind, pubkey: parts[1], d: parts[2]}
+	if !addr.parameterized() && addr.d != "" {
+		return listAddress{}, fmt.Errorf("invalid list address %q: kind %d lists have no d tag", s, kind)
+	}
+	return addr, nil
+}
+
+// parameterized reports whether the list's kind is addressed by d tag as
+// well as author.
+func (a listAddress) parameterized() bool {
+	return a.kind >= 30000 && a.kind < 40000
+}
+
+func (a listAddress) filter() nostr.Filter {
+	f := nostr.Filter{Kinds: []int{a.kind}, Authors: []string{a.pubkey}}
+	if a.parameterized() {
+		f.Tags = nostr.TagMap{"d": {a.d}}
+	}
+	return f
+}
+
+func (a listAddress) matches(evt *nostr.Event) bool {
+	if evt.Kind != a.kind || evt.PubKey != a.pubkey {
+		return false
+	}
+	if !a.parameterized() {
+		return true
+	}
+	d := ""
+	if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+		d = tag.Value()
+	}
+	return d == a.d
+}
+
+// syncedList holds the members of a list's latest version seen.
+type syncedList struct {
+	name string // "allowlist" or "denylist", for logs
+	addr listAddress
+	size *expvar.Int
+
+	mu      sync.RWMutex
+	at      nostr.Timestamp
+	members map[string]bool // nil until a version is seen
+}
+
+// apply replaces the members with evt's if it's a newer version of the
+// list, reporting whether it was.
+func (l *syncedList) apply(evt *nostr.Event) bool {
+	if l == nil || !l.addr.matches(evt) {
+		return false
+	}
+	members := make(map[string]bool)
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && len(tag[1]) == 64 {
+			members[tag[1]] = true
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.members != nil && evt.CreatedAt <= l.at {
+		return false
+	}
+	l.members, l.at = members, evt.CreatedAt
+	l.size.Set(int64(len(members)))
+	log.Printf("Synced the %s: %d pubkeys as of %s", l.name, len(members), evt.CreatedAt.Time().Format(time.RFC3339))
+	return true
+}
+
+// has reports whether pubkey is on the list, and whether the list has
+// been loaded yet.
+func (l *syncedList) has(pubkey string) (member, loaded bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.members[pubkey], l.members != nil
+}
+
+// listSync is the allowlist and denylist kept in step with their NIP-51
+// lists; either may be nil.
+type listSync struct {
+	allow, deny *syncedList
+}
+
+func newListSync(cfg ListSyncConfig) (*listSync, error) {
+	if cfg.Allow == "" && cfg.Deny == "" {
+		return nil, errors.New("list sync needs an allow or deny list address")
+	}
+	l := &listSync{}
+	if cfg.Allow != "" {
+		addr, err := parseListAddress(cfg.Allow)
+		if err != nil {
+			return nil, err
+		}
+		l.allow = &syncedList{name: "allowlist", addr: addr, size: metricAllowlistSize}
+	}
+	if cfg.Deny != "" {
+		addr, err := parseListAddress(cfg.Deny)
+		if err != nil {
+			return nil, err
+		}
+		l.deny = &syncedList{name: "denylist", addr: addr, size: metricDenylistSize}
+	}
+	return l, nil
+}
+
+// allows reports whether pubkey may make requests, and whether the
+// allowlist has been loaded yet; without an allowlist everyone may.
+func (l *listSync) allows(pubkey string) (allowed, loaded bool) {
+	if l == nil || l.allow == nil {
+		return true, true
+	}
+	return l.allow.has(pubkey)
+}
+
+// denies reports whether pubkey is on the denylist. Until the list is
+// loaded nobody is.
+func (l *listSync) denies(pubkey string) bool {
+	if l == nil || l.deny == nil {
+		return false
+	}
+	denied, _ := l.deny.has(pubkey)
+	return denied
+}
+
+func (l *listSync) filters() nostr.Filters {
+	var filters nostr.Filters
+	for _, list := range []*syncedList{l.allow, l.deny} {
+		if list != nil {
+			filters = append(filters, list.addr.filter())
+		}
+	}
+	return filters
+}
+
+func (l *listSync) apply(evt *nostr.Event) {
+	l.allow.apply(evt)
+	l.deny.apply(evt)
+}
+
+// runListSync loads the lists from the DVM's relays and then follows
+// their updates, subscribing again whenever the subscription ends.
+func (d *Dvm) runListSync(ctx context.Context) {
+	filters := d.lists.filters()
+	for {
+		// Catch up on every relay, as the list may have been published to
+		// any of them
+		for _, f := range d.queryRelays(ctx, filters, nil, nil) {
+			d.lists.apply(f.Event)
+		}
+		if err := d.followListUpdates(ctx, filters); err != nil {
+			log.Printf("List sync subscription failed, retrying in %v: %v", listSyncRetry, err)
+		}
+		select {
+		case <-time.After(listSyncRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// followListUpdates applies new versions of the lists as the healthiest
+// relay delivers them, until the subscription or the DVM ends.
+func (d *Dvm) followListUpdates(ctx context.Context, filters nostr.Filters) error {
+	relay, err := d.pool.best(ctx)
+	if err != nil {
+		return err
+	}
+	sub, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsub()
+	for {
+		select {
+		case evt, ok := <-sub.Events:
+			if !ok {
+				return errors.New("subscription closed")
+			}
+			if verifyEvent(evt) {
+				d.lists.apply(evt)
+			}
+		case <-relay.Context().Done():
+			return errors.New("relay connection dropped")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// rejectNotAllowed sends feedback turning away a request from someone
+// not on the allowlist, or asking the requester to retry if it hasn't
+// been loaded yet.
+func (d *Dvm) rejectNotAllowed(id *identity, evt *nostr.Event, loaded bool) {
+	metricJobsNotAllowed.Add(1)
+	if !loaded {
+		d.logs.printf("Deferring request %s: the allowlist hasn't been loaded yet", evt.ID[:8])
+		retryAfter := int(listSyncRetry.Seconds())
+		d.publishFeedback(id, evt, StatusError, ReasonBusy,
+			fmt.Sprintf("DVM is still loading its allowlist, retry after %d seconds", retryAfter),
+			nostr.Tag{"retry-after", strconv.Itoa(retryAfter)})
+		return
+	}
+	d.logs.printf("Rejecting request %s: %s isn't on the allowlist", evt.ID[:8], d.scrub.logPubKey(evt.PubKey))
+	d.publishFeedback(id, evt, StatusError, ReasonNotAllowed, "Sorry, this DVM only serves accounts on its operator's allowlist")
+}
@@ -0,0 +1,113 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newList returns sk's list of kind with the d tag d, if any, of pubkeys.
+func newList(sk string, kind int, d string, pubkeys ...string) *nostr.Event {
+	evt := &nostr.Event{CreatedAt: nostr.Now(), Kind: kind}
+	if d != "" {
+		evt.Tags = append(evt.Tags, nostr.Tag{"d", d})
+	}
+	for _, pk := range pubkeys {
+		evt.Tags = append(evt.Tags, nostr.Tag{"p", pk})
+	}
+	evt.Sign(sk)
+	return evt
+}
+
+func TestListSync(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	operator := testKey()
+	opk, _ := nostr.GetPublicKey(operator)
+	var sks, pks [3]string
+	for i := range sks {
+		sks[i] = testKey()
+		pks[i], _ = nostr.GetPublicKey(sks[i])
+	}
+	relay.Publish(newList(operator, KindFollowSet, "customers", pks[0], pks[2]))
+	relay.Publish(newList(operator, KindFollowSet, "someone-else", pks[1]))
+	relay.Publish(newList(operator, KindMuteList, "", pks[2]))
+
+	allow, _ := encodeNaddr(KindFollowSet, opk, "customers")
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithListSync(ListSyncConfig{
+		Allow: allow,
+		Deny:  "10000:" + opk + ":",
+	}))
+	deadline := time.Now().Add(5 * time.Second)
+	for !d.lists.denies(pks[2]) {
+		if time.Now().After(deadline) {
+			t.Fatal("lists not synced")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	req := newTestRequestFrom(sks[0], "20")
+	relay.Publish(req)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind == KindJobFeedback {
+		t.Errorf("expected a result for an allowed requester, got feedback %v", resp.Tags)
+	}
+	req = newTestRequestFrom(sks[1], "20")
+	relay.Publish(req)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Tags.GetFirst([]string{"status", StatusError, ReasonNotAllowed}) == nil {
+		t.Errorf("expected not-allowed feedback, got %v", fb.Tags)
+	}
+
+	// A new version of the allowlist applies at once
+	update := newList(operator, KindFollowSet, "customers", pks[0], pks[1], pks[2])
+	update.CreatedAt++
+	update.Sign(operator)
+	relay.Publish(update)
+	for allowed, _ := d.lists.allows(pks[1]); !allowed; allowed, _ = d.lists.allows(pks[1]) {
+		if time.Now().After(deadline) {
+			t.Fatal("allowlist update not applied")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	req = newTestRequestFrom(sks[1], "21")
+	relay.Publish(req)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind == KindJobFeedback {
+		t.Errorf("expected a result once allowed, got feedback %v", resp.Tags)
+	}
+
+	// The denylist wins, and denied requests get no answer at all
+	req = newTestRequestFrom(sks[2], "20")
+	relay.Publish(req)
+	time.Sleep(300 * time.Millisecond)
+	for _, evt := range relay.Events() {
+		if evt.PubKey == d.GetPublicKey() && evt.Tags.GetFirst([]string{"e", req.ID}) != nil {
+			t.Fatalf("expected a denied request to be ignored, got kind %d", evt.Kind)
+		}
+	}
+}
+
+func TestParseListAddress(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(testKey())
+	naddr, _ := encodeNaddr(KindFollowSet, pk, "vip")
+	for s, want := range map[string]listAddress{
+		naddr:                      {KindFollowSet, pk, "vip"},
+		"30000:" + pk + ":vip":     {KindFollowSet, pk, "vip"},
+		"10000:" + pk + ":":        {KindMuteList, pk, ""},
+		"30000:" + pk + ":a:b:c:d": {KindFollowSet, pk, "a:b:c:d"},
+	} {
+		if got, err := parseListAddress(s); err != nil || got != want {
+			t.Errorf("parseListAddress(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, bad := range []string{"npub1xyz", "30000:" + pk, "x:" + pk + ":vip", "30000:abc:vip", "10000:" + pk + ":vip"} {
+		if _, err := parseListAddress(bad); err == nil {
+			t.Errorf("expected %q to be refused", bad)
+		}
+	}
+	if _, err := newListSync(ListSyncConfig{}); err == nil {
+		t.Error("expected a list sync without lists to be refused")
+	}
+}
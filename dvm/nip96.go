@@ -0,0 +1,183 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nip96PollInterval is how often a server still processing an upload is
+// asked whether it's done.
+var nip96PollInterval = 2 * time.Second
+
+// nip96Uploader uploads to a NIP-96 HTTP file storage server, authorizing
+// with NIP-98 events signed by the DVM's key. The server's API URL is read
+// from its /.well-known/nostr/nip96.json on first use.
+type nip96Uploader struct {
+	server string
+	sk     string
+	client *http.Client
+
+	mu     sync.Mutex
+	apiURL string
+}
+
+// nip96Response is the body of a NIP-96 upload or processing response.
+type nip96Response struct {
+	Status        string `json:"status"`
+	Message       string `json:"message"`
+	ProcessingURL string `json:"processing_url"`
+	NIP94Event    struct {
+		Tags [][]string `json:"tags"`
+	} `json:"nip94_event"`
+}
+
+func (n *nip96Uploader) upload(ctx context.Context, data []byte, mimeType string) (*UploadedFile, error) {
+	apiURL, err := n.api(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("size", strconv.Itoa(len(data)))
+	if mimeType != "" {
+		form.WriteField("content_type", mimeType)
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="file"`)
+	if mimeType != "" {
+		header.Set("Content-Type", mimeType)
+	}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(data)
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	auth, err := httpAuthorization(n.sk, http.MethodPost, apiURL, body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("User-Agent", fetchUserAgent)
+	res, err := n.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Servers that transform files finish in the background
+	for processing := res.ProcessingURL; processing != "" && res.Status == "processing"; {
+		select {
+		case <-time.After(nip96PollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, processing, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", fetchUserAgent)
+		if res, err = n.do(req); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	file := &UploadedFile{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data)), MimeType: mimeType}
+	for _, tag := range res.NIP94Event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "url":
+			file.URL = tag[1]
+		case "x":
+			// The hash of the file as served, if the server changed it
+			file.SHA256 = tag[1]
+		case "m":
+			file.MimeType = tag[1]
+		case "size":
+			if size, err := strconv.ParseInt(tag[1], 10, 64); err == nil {
+				file.Size = size
+			}
+		}
+	}
+	if file.URL == "" {
+		return nil, fmt.Errorf("nip96 server returned no URL: %s", res.Message)
+	}
+	return file, nil
+}
+
+// do sends a NIP-96 request and decodes the response, which may still be
+// processing.
+func (n *nip96Uploader) do(req *http.Request) (*nip96Response, error) {
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res nip96Response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxFetchBytes)).Decode(&res); err != nil && resp.StatusCode/100 == 2 {
+		return nil, fmt.Errorf("invalid nip96 response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 || res.Status == "error" {
+		return nil, fmt.Errorf("nip96 %s returned %s: %s", req.Method, resp.Status, res.Message)
+	}
+	if resp.StatusCode == http.StatusAccepted && res.Status == "" {
+		res.Status = "processing"
+	}
+	return &res, nil
+}
+
+// api returns the server's upload URL, reading it from the server's NIP-96
+// information the first time.
+func (n *nip96Uploader) api(ctx context.Context) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.apiURL != "" {
+		return n.apiURL, nil
+	}
+
+	var info struct {
+		APIURL      string `json:"api_url"`
+		DelegatedTo string `json:"delegated_to_url"`
+	}
+	server := n.server
+	// A server may delegate to another, once
+	for i := 0; i < 2; i++ {
+		info.APIURL, info.DelegatedTo = "", ""
+		if err := fetchJSON(ctx, n.client, server+"/.well-known/nostr/nip96.json", "", &info); err != nil {
+			return "", err
+		}
+		if info.APIURL != "" || info.DelegatedTo == "" {
+			break
+		}
+		server = strings.TrimSuffix(info.DelegatedTo, "/")
+	}
+	if info.APIURL == "" {
+		return "", errors.New("nip96 server has no api_url")
+	}
+	n.apiURL = info.APIURL
+	return n.apiURL, nil
+}
@@ -0,0 +1,135 @@
+package dvm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeNIP96 is a NIP-96 server that checks NIP-98 upload authorizations,
+// reports each upload as processing once, and serves the files uploaded.
+type fakeNIP96 struct {
+	*httptest.Server
+	mu       sync.Mutex
+	files    map[string][]byte
+	polled   map[string]bool
+	uploader string // pubkey that authorized the last upload
+}
+
+func newFakeNIP96(t *testing.T) *fakeNIP96 {
+	n := &fakeNIP96{files: make(map[string][]byte), polled: make(map[string]bool)}
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		switch {
+		case r.URL.Path == "/.well-known/nostr/nip96.json":
+			json.NewEncoder(w).Encode(map[string]any{"api_url": n.URL + "/api/upload"})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/upload":
+			pubkey, err := checkHTTPAuth(r, time.Now())
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]any{"status": "error", "message": err.Error()})
+				return
+			}
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(file)
+			sum := sha256.Sum256(data)
+			hash := hex.EncodeToString(sum[:])
+			n.files[hash] = data
+			n.uploader = pubkey
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]any{"status": "processing", "processing_url": n.URL + "/processing/" + hash})
+		case strings.HasPrefix(r.URL.Path, "/processing/"):
+			hash := strings.TrimPrefix(r.URL.Path, "/processing/")
+			if !n.polled[hash] {
+				n.polled[hash] = true
+				json.NewEncoder(w).Encode(map[string]any{"status": "processing"})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{
+				"status":      "success",
+				"nip94_event": map[string]any{"tags": [][]string{{"url", n.URL + "/" + hash}, {"x", hash}, {"ox", hash}}},
+			})
+		case r.Method == http.MethodGet:
+			data, ok := n.files[strings.TrimPrefix(r.URL.Path, "/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+func TestNIP96Upload(t *testing.T) {
+	defer func(interval time.Duration) { nip96PollInterval = interval }(nip96PollInterval)
+	nip96PollInterval = time.Millisecond
+
+	server := newFakeNIP96(t)
+	sk := testKey()
+	up, err := newUploader(UploadConfig{Provider: "nip96", Server: server.URL + "/"}, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello, nip96")
+	file, err := up.upload(context.Background(), data, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if hash := hex.EncodeToString(sum[:]); file.SHA256 != hash || file.URL != server.URL+"/"+hash {
+		t.Errorf("unexpected upload %+v", file)
+	}
+	if file.Size != int64(len(data)) || file.MimeType != "text/plain" {
+		t.Errorf("unexpected upload %+v", file)
+	}
+	if pk, _ := nostr.GetPublicKey(sk); server.uploader != pk {
+		t.Errorf("upload authorized by %q, not the DVM", server.uploader)
+	}
+
+	// A server that refuses the upload is an error
+	other, err := newUploader(UploadConfig{Provider: "nip96", Server: server.URL}, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.(*nip96Uploader).apiURL = server.URL + "/missing"
+	if _, err := other.upload(context.Background(), data, ""); err == nil {
+		t.Error("expected a failed upload")
+	}
+}
+
+func TestNIP96ResultOffload(t *testing.T) {
+	defer func(interval time.Duration) { nip96PollInterval = interval }(nip96PollInterval)
+	nip96PollInterval = time.Millisecond
+
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	server := newFakeNIP96(t)
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithUploads(UploadConfig{Provider: "nip96", Server: server.URL}), WithResultOffload(10))
+	// The client fetches the tweet from the NIP-96 server, or the test fails
+	requestTestTweet(t, relay, d, "20")
+	if len(server.files) == 0 {
+		t.Error("nothing uploaded")
+	}
+}
@@ -0,0 +1,148 @@
+package dvm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindHTTPAuth is the kind of NIP-98 HTTP authorization events.
+const KindHTTPAuth = 27235
+
+// httpAuthWindow is how far an authorization event's created_at may be
+// from the time it's checked.
+const httpAuthWindow = time.Minute
+
+// NIP98Auth wraps next so that only requests authorized by one of the
+// allowed keys, given as npubs or hex pubkeys, reach it. Requests carry a
+// NIP-98 event signed for their URL and method in an "Authorization: Nostr
+// <base64 event>" header; anything else is refused with 401. The URL is
+// checked as the server sees it, so a proxy in front must keep the Host
+// header.
+func NIP98Auth(allowed []string, next http.Handler) (http.Handler, error) {
+	pubkeys := make(map[string]bool, len(allowed))
+	for _, key := range allowed {
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(key, "npub1") {
+			pk, err := decodeNpub(key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid npub %q: %w", key, err)
+			}
+			key = pk
+		}
+		if _, err := hex.DecodeString(key); err != nil || len(key) != 64 {
+			return nil, fmt.Errorf("invalid pubkey %q", key)
+		}
+		pubkeys[key] = true
+	}
+	if len(pubkeys) == 0 {
+		return nil, errors.New("NIP-98 auth needs at least one allowed pubkey")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pubkey, err := checkHTTPAuth(r, time.Now())
+		if err == nil && !pubkeys[pubkey] {
+			err = fmt.Errorf("%s is not allowed", pubkey)
+		}
+		if err != nil {
+			log.Printf("Refusing %s %s: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", "Nostr")
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// checkHTTPAuth verifies r's NIP-98 authorization and returns the pubkey
+// that signed it.
+func checkHTTPAuth(r *http.Request, now time.Time) (string, error) {
+	header := r.Header.Get("Authorization")
+	encoded, ok := strings.CutPrefix(header, "Nostr ")
+	if !ok {
+		return "", errors.New("missing Nostr authorization")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", errors.New("authorization is not base64")
+	}
+	var evt nostr.Event
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		return "", errors.New("authorization is not an event")
+	}
+	if evt.Kind != KindHTTPAuth {
+		return "", fmt.Errorf("authorization event has kind %d, not %d", evt.Kind, KindHTTPAuth)
+	}
+	if !verifyEvent(&evt) {
+		return "", errors.New("authorization event has a bad signature")
+	}
+	if age := now.Sub(evt.CreatedAt.Time()); age > httpAuthWindow || age < -httpAuthWindow {
+		return "", errors.New("authorization event is stale")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if u := tagValue(evt.Tags, "u"); u != scheme+"://"+r.Host+r.URL.RequestURI() {
+		return "", fmt.Errorf("authorization is for %q", u)
+	}
+	if method := tagValue(evt.Tags, "method"); !strings.EqualFold(method, r.Method) {
+		return "", fmt.Errorf("authorization is for %s requests", method)
+	}
+	if payload := tagValue(evt.Tags, "payload"); payload != "" {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxFetchBytes))
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != payload {
+			return "", errors.New("body doesn't match the authorized payload")
+		}
+	}
+	return evt.PubKey, nil
+}
+
+// tagValue returns the value of the first tag named name, or "". Unlike
+// Tags.GetFirst, it doesn't match longer names.
+func tagValue(tags nostr.Tags, name string) string {
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// httpAuthorization returns a NIP-98 Authorization header signed by sk for
+// a request to url with the given method and body.
+func httpAuthorization(sk, method, url string, body []byte) (string, error) {
+	evt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindHTTPAuth,
+		Tags:      nostr.Tags{{"u", url}, {"method", strings.ToUpper(method)}},
+	}
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		evt.Tags = append(evt.Tags, nostr.Tag{"payload", hex.EncodeToString(sum[:])})
+	}
+	if err := evt.Sign(sk); err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(raw), nil
+}
@@ -0,0 +1,89 @@
+package dvm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestNIP98Auth(t *testing.T) {
+	sk := testKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	npub, _ := encodeNpub(pk)
+
+	auth, err := NIP98Auth([]string{npub}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+		w.Write(body.Bytes())
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(auth)
+	defer srv.Close()
+
+	call := func(method, path string, body []byte, authorization string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	sign := func(sk, method, path string, body []byte) string {
+		header, err := httpAuthorization(sk, method, srv.URL+path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return header
+	}
+
+	if code := call("GET", "/vars?x=1", nil, sign(sk, "GET", "/vars?x=1", nil)); code != http.StatusOK {
+		t.Errorf("authorized GET got %d", code)
+	}
+	body := []byte(`{"kind":42069}`)
+	if code := call("POST", "/jobs", body, sign(sk, "POST", "/jobs", body)); code != http.StatusOK {
+		t.Errorf("authorized POST got %d", code)
+	}
+
+	// A key that isn't allowed, another URL or method, a different body, or
+	// an old event
+	stale := func() string {
+		evt := nostr.Event{CreatedAt: nostr.Now() - 600, Kind: KindHTTPAuth, Tags: nostr.Tags{{"u", srv.URL + "/vars"}, {"method", "GET"}}}
+		evt.Sign(sk)
+		raw, _ := json.Marshal(evt)
+		return "Nostr " + base64.StdEncoding.EncodeToString(raw)
+	}
+	for name, code := range map[string]int{
+		"none":        call("GET", "/vars", nil, ""),
+		"other key":   call("GET", "/vars", nil, sign(testKey(), "GET", "/vars", nil)),
+		"other path":  call("GET", "/vars", nil, sign(sk, "GET", "/other", nil)),
+		"other verb":  call("DELETE", "/vars", nil, sign(sk, "GET", "/vars", nil)),
+		"other body":  call("POST", "/jobs", []byte("{}"), sign(sk, "POST", "/jobs", body)),
+		"stale":       call("GET", "/vars", nil, stale()),
+		"not base64":  call("GET", "/vars", nil, "Nostr !!!"),
+		"wrong realm": call("GET", "/vars", nil, strings.Replace(sign(sk, "GET", "/vars", nil), "Nostr", "Bearer", 1)),
+	} {
+		if code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want 401", name, code)
+		}
+	}
+
+	if _, err := NIP98Auth([]string{"npub1bogus"}, auth); err == nil {
+		t.Error("expected an invalid npub to be rejected")
+	}
+	if _, err := NIP98Auth(nil, auth); err == nil {
+		t.Error("expected auth without allowed keys to be rejected")
+	}
+}
@@ -0,0 +1,99 @@
+package dvm
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// defaultEOSETimeout is how long a new subscription may go without EOSE
+// before it's taken to have been refused; see WithEOSETimeout.
+const defaultEOSETimeout = 15 * time.Second
+
+// Waits before resubscribing after the relay ended the subscription.
+const (
+	authResubscribeDelay = time.Second // for the AUTH exchange to finish
+	rateLimitBackoff     = 30 * time.Second
+)
+
+// subscriptionLoss is the request subscription's relay refusing or ending
+// it, reported to the Run loop to resubscribe.
+type subscriptionLoss struct {
+	url    string
+	sub    *nostr.Subscription // the subscription, or nil for any on url
+	reason string
+	wait   time.Duration // before resubscribing
+	demote bool          // whether to prefer another relay
+}
+
+// relayWatcher answers the pool's relays' AUTH challenges as the primary
+// identity and reports NOTICEs that end the subscription.
+func (d *Dvm) relayWatcher() *relayWatcher {
+	return &relayWatcher{
+		notice: d.handleNotice,
+		auth: func(evt *nostr.Event) bool {
+			return evt.Sign(d.sk) == nil
+		},
+	}
+}
+
+// handleNotice acts on a relay's NOTICE by its NIP-01 machine-readable
+// prefix. Notices aren't tied to a subscription, so one from the
+// subscription's relay that says requests are refused is taken to mean
+// the subscription was.
+func (d *Dvm) handleNotice(url, notice string) {
+	log.Printf("NOTICE from %s: %s", url, notice)
+	loss := subscriptionLoss{url: url, reason: notice}
+	prefix, _, _ := strings.Cut(notice, ":")
+	switch prefix {
+	case "auth-required":
+		// The relay's AUTH challenge is answered as it arrives; resubscribe
+		// once that's done
+		loss.wait = authResubscribeDelay
+	case "rate-limited":
+		loss.wait, loss.demote = rateLimitBackoff, true
+	case "restricted", "blocked":
+		loss.demote = true
+	default:
+		return
+	}
+	d.loseSubscription(loss)
+}
+
+// watchSubscription reports sub lost if its relay doesn't send EOSE within
+// the DVM's EOSE timeout. The returned function stops watching.
+func (d *Dvm) watchSubscription(sub *nostr.Subscription) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-sub.EndOfStoredEvents:
+		case <-time.After(d.eoseTimeout):
+			d.loseSubscription(subscriptionLoss{url: relayURL(sub.Relay), sub: sub,
+				reason: "no EOSE, likely CLOSED", wait: authResubscribeDelay})
+		case <-stopped:
+		case <-d.done:
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stopped) }) }
+}
+
+// loseSubscription hands loss to the Run loop without waiting for it.
+func (d *Dvm) loseSubscription(loss subscriptionLoss) {
+	select {
+	case d.subscriptionLost <- loss:
+	default:
+		// The Run loop has one to handle already
+	}
+}
+
+// of reports whether loss is of sub.
+func (loss subscriptionLoss) of(sub *nostr.Subscription) bool {
+	if loss.sub != nil {
+		return loss.sub == sub
+	}
+	return nostr.NormalizeURL(loss.url) == nostr.NormalizeURL(relayURL(sub.Relay))
+}
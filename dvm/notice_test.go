@@ -0,0 +1,71 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+func TestResubscribesAfterAuth(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	relay.RequireAuth()
+
+	// The first subscription is refused with CLOSED and an AUTH challenge;
+	// the resubscribe, once the DVM has answered it, isn't
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithEOSETimeout(200*time.Millisecond))
+	time.Sleep(2 * time.Second)
+	req := newTestRequest("20")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+}
+
+func TestResubscribesElsewhereWhenRestricted(t *testing.T) {
+	first := relaytest.NewServer()
+	defer first.Close()
+	second := relaytest.NewServer()
+	defer second.Close()
+
+	d := startTestDvm(t, first, WithScraper(&fakeScraper{}), WithRelays(second.URL()))
+	time.Sleep(200 * time.Millisecond)
+	first.Notice("restricted: this relay no longer serves you")
+	time.Sleep(500 * time.Millisecond)
+
+	// Only the relay the subscription moved to sees the request
+	req := newTestRequest("20")
+	second.Publish(req)
+	awaitResponse(t, second, d.GetPublicKey(), req.ID)
+	if health := d.RelayHealth(); health[0].URL != second.URL() {
+		t.Errorf("expected the restricted relay to be demoted, got %+v", health)
+	}
+}
+
+func TestHandleNotice(t *testing.T) {
+	d := &Dvm{subscriptionLost: make(chan subscriptionLoss, 1)}
+	for _, tc := range []struct {
+		notice string
+		lost   bool
+		wait   time.Duration
+	}{
+		{"auth-required: sign in first", true, authResubscribeDelay},
+		{"rate-limited: slow down", true, rateLimitBackoff},
+		{"blocked: go away", true, 0},
+		{"error: could not save event", false, 0},
+		{"hello", false, 0},
+	} {
+		d.handleNotice("wss://relay", tc.notice)
+		select {
+		case loss := <-d.subscriptionLost:
+			if !tc.lost {
+				t.Errorf("%q: unexpected loss %+v", tc.notice, loss)
+			} else if loss.wait != tc.wait {
+				t.Errorf("%q: expected a wait of %v, got %v", tc.notice, tc.wait, loss.wait)
+			}
+		default:
+			if tc.lost {
+				t.Errorf("%q: expected the subscription to be lost", tc.notice)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// Nostr Wallet Connect (NIP-47) event kinds.
+const (
+	KindNWCRequest  = 23194
+	KindNWCResponse = 23195
+)
+
+// nwcWallet makes and looks up invoices through a Nostr Wallet Connect
+// connection, so operators can take payments with any NWC wallet instead
+// of running a lightning node and zapper.
+type nwcWallet struct {
+	walletPK string
+	relayURL string
+	sk       string // the connection secret, which signs our requests
+	pk       string
+	secret   []byte // shared with the wallet, for NIP-04
+
+	mu   sync.Mutex
+	held bool // whether the wallet holds its relay in relayConns
+}
+
+// parseNWC parses a nostr+walletconnect://<wallet pubkey>?relay=<url>&secret=<hex>
+// connection URI.
+func parseNWC(uri string) (*nwcWallet, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "nostr+walletconnect" {
+		return nil, errors.New("NWC URI must start with nostr+walletconnect://")
+	}
+	w := &nwcWallet{walletPK: u.Host, relayURL: u.Query().Get("relay"), sk: u.Query().Get("secret")}
+	if w.walletPK == "" {
+		w.walletPK = u.Opaque // nostr+walletconnect:<pubkey>?...
+	}
+	if !nostr.IsValidPublicKeyHex(w.walletPK) {
+		return nil, fmt.Errorf("invalid NWC wallet pubkey %q", w.walletPK)
+	}
+	if w.relayURL == "" {
+		return nil, errors.New("NWC URI has no relay")
+	}
+	if w.pk, err = nostr.GetPublicKey(w.sk); err != nil || len(w.sk) != 64 {
+		return nil, errors.New("NWC URI has no valid secret")
+	}
+	if w.secret, err = nip04.ComputeSharedSecret(w.walletPK, w.sk); err != nil {
+		return nil, fmt.Errorf("invalid NWC wallet pubkey: %w", err)
+	}
+	return w, nil
+}
+
+// nwcInvoice is the result of make_invoice and lookup_invoice.
+type nwcInvoice struct {
+	Invoice     string `json:"invoice"`
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"`
+	SettledAt   int64  `json:"settled_at"`
+	State       string `json:"state"`
+}
+
+func (i *nwcInvoice) settled() bool {
+	return i.SettledAt > 0 || i.State == "settled"
+}
+
+// makeInvoice asks the wallet for an invoice of msats.
+func (w *nwcWallet) makeInvoice(ctx context.Context, msats int64, description string, expiry time.Duration) (*nwcInvoice, error) {
+	var inv nwcInvoice
+	err := w.call(ctx, "make_invoice", map[string]any{
+		"amount":      msats,
+		"description": description,
+		"expiry":      int64(expiry.Seconds()),
+	}, &inv)
+	if err == nil && (inv.Invoice == "" || inv.PaymentHash == "") {
+		err = errors.New("wallet returned an incomplete invoice")
+	}
+	return &inv, err
+}
+
+func (w *nwcWallet) invoice(ctx context.Context, msats int64, description string, expiry time.Duration) (*jobInvoice, error) {
+	inv, err := w.makeInvoice(ctx, msats, description, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return &jobInvoice{bolt11: inv.Invoice, ref: inv.PaymentHash}, nil
+}
+
+func (w *nwcWallet) paid(ctx context.Context, inv *jobInvoice) (bool, error) {
+	status, err := w.lookupInvoice(ctx, inv.ref)
+	if err != nil {
+		return false, err
+	}
+	return status.settled(), nil
+}
+
+// lookupInvoice asks the wallet about the invoice with paymentHash.
+func (w *nwcWallet) lookupInvoice(ctx context.Context, paymentHash string) (*nwcInvoice, error) {
+	var inv nwcInvoice
+	err := w.call(ctx, "lookup_invoice", map[string]any{"payment_hash": paymentHash}, &inv)
+	return &inv, err
+}
+
+// call sends the wallet a request and decodes its result into result.
+func (w *nwcWallet) call(ctx context.Context, method string, params any, result any) error {
+	payload, err := json.Marshal(map[string]any{"method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	content, err := nip04.Encrypt(string(payload), w.secret)
+	if err != nil {
+		return err
+	}
+	req := nostr.Event{
+		PubKey:    w.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      KindNWCRequest,
+		Tags:      nostr.Tags{{"p", w.walletPK}},
+		Content:   content,
+	}
+	if err := req.Sign(w.sk); err != nil {
+		return err
+	}
+
+	relay, err := w.connect(ctx)
+	if err != nil {
+		return err
+	}
+	sub, err := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{KindNWCResponse},
+		Authors: []string{w.walletPK},
+		Tags:    nostr.TagMap{"e": {req.ID}},
+	}})
+	if err != nil {
+		w.disconnect()
+		return err
+	}
+	defer sub.Unsub()
+	if _, err := relay.Publish(ctx, req); err != nil {
+		w.disconnect()
+		return err
+	}
+
+	for {
+		select {
+		case evt, ok := <-sub.Events:
+			if !ok {
+				w.disconnect()
+				return fmt.Errorf("NWC relay closed the subscription")
+			}
+			if !verifyEvent(evt) {
+				continue
+			}
+			plain, err := nip04.Decrypt(evt.Content, w.secret)
+			if err != nil {
+				return fmt.Errorf("undecryptable NWC response: %w", err)
+			}
+			var resp struct {
+				ResultType string `json:"result_type"`
+				Error      *struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+				Result json.RawMessage `json:"result"`
+			}
+			if err := json.Unmarshal([]byte(plain), &resp); err != nil {
+				return fmt.Errorf("invalid NWC response: %w", err)
+			}
+			if resp.Error != nil {
+				return fmt.Errorf("wallet %s failed: %s: %s", method, resp.Error.Code, resp.Error.Message)
+			}
+			return json.Unmarshal(resp.Result, result)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// connect returns the connection to the wallet's relay, acquiring it from
+// relayConns if need be.
+func (w *nwcWallet) connect(ctx context.Context) (*nostr.Relay, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.held {
+		return relayConns.live(ctx, w.relayURL)
+	}
+	relay, err := relayConns.acquire(ctx, w.relayURL)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to NWC relay: %w", err)
+	}
+	w.held = true
+	return relay, nil
+}
+
+// disconnect releases the relay connection after an error, so the next
+// call reacquires it, redialing if it dropped.
+func (w *nwcWallet) disconnect() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.held {
+		relayConns.release(w.relayURL)
+		w.held = false
+	}
+}
@@ -0,0 +1,154 @@
+package dvm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// fakeWallet is an NWC wallet service whose invoices are paid by calling
+// pay.
+type fakeWallet struct {
+	sk, pk string
+
+	mu       sync.Mutex
+	invoices map[string]*nwcInvoice // by bolt11
+	keysends map[string]int64       // msats sent, by node pubkey
+}
+
+func startFakeWallet(t *testing.T, relay *relaytest.Server) *fakeWallet {
+	w := &fakeWallet{sk: testKey(), invoices: make(map[string]*nwcInvoice), keysends: make(map[string]int64)}
+	w.pk, _ = nostr.GetPublicKey(w.sk)
+
+	conn, err := nostr.RelayConnect(context.Background(), relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := conn.Subscribe(context.Background(), nostr.Filters{{Kinds: []int{KindNWCRequest}, Tags: nostr.TagMap{"p": {w.pk}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsub(); conn.Close() })
+	go func() {
+		for req := range sub.Events {
+			secret, _ := nip04.ComputeSharedSecret(req.PubKey, w.sk)
+			plain, _ := nip04.Decrypt(req.Content, secret)
+			var call struct {
+				Method string
+				Params struct {
+					Amount      int64  `json:"amount"`
+					PaymentHash string `json:"payment_hash"`
+					Pubkey      string `json:"pubkey"`
+				}
+			}
+			json.Unmarshal([]byte(plain), &call)
+			resp := map[string]any{"result_type": call.Method}
+			w.mu.Lock()
+			switch call.Method {
+			case "make_invoice":
+				bolt11 := fmt.Sprintf("lnbc%dn1fake%d", call.Params.Amount/100, len(w.invoices))
+				sum := sha256.Sum256([]byte(bolt11))
+				inv := &nwcInvoice{Invoice: bolt11, PaymentHash: hex.EncodeToString(sum[:]), Amount: call.Params.Amount}
+				w.invoices[bolt11] = inv
+				resp["result"] = *inv
+			case "lookup_invoice":
+				resp["error"] = map[string]string{"code": "NOT_FOUND", "message": "no such invoice"}
+				for _, inv := range w.invoices {
+					if inv.PaymentHash == call.Params.PaymentHash {
+						resp["result"], resp["error"] = *inv, nil
+					}
+				}
+			case "pay_keysend":
+				w.keysends[call.Params.Pubkey] += call.Params.Amount
+				resp["result"] = map[string]string{"preimage": "00"}
+			}
+			w.mu.Unlock()
+			payload, _ := json.Marshal(resp)
+			content, _ := nip04.Encrypt(string(payload), secret)
+			evt := nostr.Event{CreatedAt: nostr.Now(), Kind: KindNWCResponse,
+				Tags: nostr.Tags{{"p", req.PubKey}, {"e", req.ID}}, Content: content}
+			evt.Sign(w.sk)
+			conn.Publish(context.Background(), evt)
+		}
+	}()
+	return w
+}
+
+// uri returns a connection URI for the wallet.
+func (w *fakeWallet) uri(relay *relaytest.Server) string {
+	return fmt.Sprintf("nostr+walletconnect://%s?relay=%s&secret=%s", w.pk, url.QueryEscape(relay.URL()), testKey())
+}
+
+// Pay settles bolt11 as a requester paying it would.
+func (w *fakeWallet) Pay(ctx context.Context, dvmPubKey string, req *nostr.Event, msats int64, bolt11 string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	inv, ok := w.invoices[bolt11]
+	if !ok || inv.Amount != msats {
+		return fmt.Errorf("unknown invoice %q", bolt11)
+	}
+	inv.SettledAt = time.Now().Unix()
+	return nil
+}
+
+func TestParseNWC(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(testKey())
+	good := fmt.Sprintf("nostr+walletconnect://%s?relay=wss%%3A%%2F%%2Frelay.example.com&secret=%s", pk, testKey())
+	w, err := parseNWC(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.walletPK != pk || w.relayURL != "wss://relay.example.com" {
+		t.Errorf("unexpected wallet %+v", w)
+	}
+	for _, bad := range []string{
+		"https://example.com",
+		strings.Replace(good, pk, "nope", 1),
+		strings.Replace(good, "relay=", "r=", 1),
+		strings.Replace(good, "secret=", "s=", 1),
+	} {
+		if _, err := parseNWC(bad); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestNWCPayments(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	wallet := startFakeWallet(t, relay)
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithPayments(PaymentConfig{NWC: wallet.uri(relay), Timeout: 5 * time.Second}),
+		WithPricing(PricingConfig{KindTweetRequest: {Msats: 3000}}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := client.RunPipeline(ctx, Pipeline{
+		Steps: []PipelineStep{{DVM: d.GetPublicKey(), Kind: KindTweetRequest, MaxMsats: 3000}},
+		Payer: wallet,
+	}, "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Steps[0].PaidMsats != 3000 || !strings.Contains(res.Output, "Running bitcoin") {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
@@ -0,0 +1,94 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+)
+
+// ImageText is the text recognized in one image.
+type ImageText struct {
+	URL   string `json:"url"`
+	Text  string `json:"text"`
+	Error string `json:"error,omitempty"` // if this image couldn't be read
+}
+
+// OCREngine recognizes text in images. Deployments enable OCR with
+// WithOCR; NewTesseractOCR is the built-in engine.
+type OCREngine interface {
+	// Recognize returns the text in image, which may be JPEG, PNG or WebP.
+	Recognize(ctx context.Context, image []byte) (string, error)
+}
+
+// maxOCRImageBytes caps the photos downloaded for OCR.
+const maxOCRImageBytes = 10 << 20
+
+// NewTesseractOCR returns an engine that runs the tesseract command, which
+// must be installed, with the given "+"-separated languages (e.g.
+// "eng+deu"; empty for tesseract's default).
+func NewTesseractOCR(languages string) (OCREngine, error) {
+	path, err := exec.LookPath("tesseract")
+	if err != nil {
+		return nil, fmt.Errorf("tesseract not found: %w", err)
+	}
+	return &tesseractOCR{path: path, languages: languages}, nil
+}
+
+type tesseractOCR struct {
+	path      string
+	languages string
+}
+
+func (t *tesseractOCR) Recognize(ctx context.Context, image []byte) (string, error) {
+	args := []string{"stdin", "stdout"}
+	if t.languages != "" {
+		args = append(args, "-l", t.languages)
+	}
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	cmd.Stdin = bytes.NewReader(image)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("tesseract: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// tweetOCR recognizes the text in each of the tweet's photos. Photos that
+// can't be read are recorded with an error rather than failing the job.
+func (d *Dvm) tweetOCR(ctx context.Context, client *http.Client, tweet *twitterscraper.Tweet) ([]ImageText, error) {
+	if d.ocr == nil {
+		return nil, errors.New("OCR is not enabled on this DVM")
+	}
+	texts := []ImageText{}
+	for _, photo := range tweet.Photos {
+		text, err := d.recognize(ctx, client, photo.URL)
+		if err != nil {
+			log.Printf("OCR failed for %s: %v", photo.URL, err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			texts = append(texts, ImageText{URL: photo.URL, Error: err.Error()})
+			continue
+		}
+		texts = append(texts, ImageText{URL: photo.URL, Text: text})
+	}
+	return texts, nil
+}
+
+func (d *Dvm) recognize(ctx context.Context, client *http.Client, imageURL string) (string, error) {
+	header := http.Header{"Accept": {"image/*"}}
+	res, err := fetchWith(ctx, client, imageURL, header, maxOCRImageBytes)
+	if err != nil {
+		return "", err
+	}
+	return d.ocr.Recognize(ctx, res.Body)
+}
@@ -0,0 +1,92 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// photoScraper returns a tweet with the given photo URLs.
+type photoScraper struct {
+	fakeScraper
+	photos []string
+}
+
+func (s *photoScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	tweet, _ := s.fakeScraper.GetTweet(id)
+	for i, u := range s.photos {
+		tweet.Photos = append(tweet.Photos, twitterscraper.Photo{ID: fmt.Sprint(i), URL: u})
+	}
+	return tweet, nil
+}
+
+// fakeOCR "recognizes" an image as its contents.
+type fakeOCR struct{}
+
+func (fakeOCR) Recognize(ctx context.Context, image []byte) (string, error) {
+	return "text: " + string(image), nil
+}
+
+func TestTweetWithOCR(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/screenshot.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("not your keys"))
+	}))
+	defer srv.Close()
+
+	scraper := &photoScraper{photos: []string{srv.URL + "/screenshot.png", srv.URL + "/gone.png"}}
+	d := &Dvm{scraper: scraper, ocr: fakeOCR{}, cache: newResultCache(1 << 20)}
+	h := tweetHandler{d: d, client: srv.Client()}
+	req := &nostr.Event{Content: "1110302988", Tags: nostr.Tags{{"param", "ocr", "true"}}}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := h.Handle(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tweet TweetResult
+		if err := json.Unmarshal(result, &tweet); err != nil {
+			t.Fatal(err)
+		}
+		if tweet.Tweet == nil || tweet.Text != "Running bitcoin" || len(tweet.OCR) != 2 {
+			t.Fatalf("unexpected result: %s", result)
+		}
+		if tweet.OCR[0].Text != "text: not your keys" || tweet.OCR[1].Error == "" {
+			t.Errorf("unexpected OCR results: %+v", tweet.OCR)
+		}
+	}
+	if scraper.Calls() != 1 {
+		t.Errorf("expected the second request to be served from cache, got %d scrapes", scraper.Calls())
+	}
+
+	// Plain requests are unaffected
+	result, err := h.Handle(context.Background(), &nostr.Event{Content: "1110302988"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain map[string]any
+	json.Unmarshal(result, &plain)
+	if _, ok := plain["ocr"]; ok {
+		t.Error("expected no OCR without the param")
+	}
+
+	if err := h.Validate(&nostr.Event{Content: "1", Tags: nostr.Tags{{"param", "ocr", "yes"}}}); err == nil {
+		t.Error("expected a malformed ocr param to be rejected")
+	}
+	d.ocr = nil
+	if _, err := h.Handle(context.Background(), &nostr.Event{Content: "2", Tags: nostr.Tags{{"param", "ocr", "true"}}}); err == nil {
+		t.Error("expected OCR requests to fail when OCR is disabled")
+	}
+}
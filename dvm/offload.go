@@ -0,0 +1,85 @@
+package dvm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxOffloadedResultBytes caps the results DvmClient downloads.
+const maxOffloadedResultBytes = 256 << 20
+
+// OffloadedResult is the content of a result too big for relays, whose
+// payload was uploaded to the DVM's file host instead. The result is
+// tagged ["offloaded", <url>, <sha256>].
+type OffloadedResult struct {
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+// offloadResult uploads content that's over the DVM's offload threshold,
+// returning the compact content and tag to publish in its place, or nil
+// if content fits in an event.
+func (d *Dvm) offloadResult(content []byte) ([]byte, nostr.Tag, error) {
+	if d.offloadBytes <= 0 || len(content) <= d.offloadBytes {
+		return nil, nil, nil
+	}
+	return d.uploadResult(content)
+}
+
+// uploadResult uploads content to the upload host, returning the compact
+// content and tag to publish in its place.
+func (d *Dvm) uploadResult(content []byte) ([]byte, nostr.Tag, error) {
+	mimeType := "text/plain; charset=utf-8"
+	if json.Valid(content) {
+		mimeType = "application/json"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+	file, err := d.uploader.upload(ctx, content, mimeType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("offloading %d byte result: %w", len(content), err)
+	}
+	compact, err := json.Marshal(OffloadedResult{URL: file.URL, SHA256: file.SHA256, Size: file.Size, MimeType: mimeType})
+	if err != nil {
+		return nil, nil, err
+	}
+	return compact, nostr.Tag{"offloaded", file.URL, file.SHA256}, nil
+}
+
+// fetchOffloaded downloads an offloaded result and checks it against its
+// hash.
+func (c *DvmClient) fetchOffloaded(ctx context.Context, url, hash string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", &httpStatusError{URL: url, Status: resp.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOffloadedResultBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxOffloadedResultBytes {
+		return "", fmt.Errorf("offloaded result is over %d bytes", maxOffloadedResultBytes)
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != hash {
+		return "", fmt.Errorf("offloaded result from %s doesn't match its hash", url)
+	}
+	return string(body), nil
+}
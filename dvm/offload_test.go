@@ -0,0 +1,60 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+)
+
+func TestResultOffload(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	blossom := newFakeBlossom(t)
+	uploads := WithUploads(UploadConfig{Provider: "blossom", Server: blossom.URL})
+
+	if _, err := NewDvm(relay.URL(), testKey(), WithResultOffload(10)); err == nil {
+		t.Error("expected offloading without uploads to be rejected")
+	}
+	if _, err := NewDvm(relay.URL(), testKey(), uploads, WithResultOffload(10), WithResultMode(ResultsAsDMs)); err == nil {
+		t.Error("expected offloading DM results to be rejected")
+	}
+
+	// Every tweet is over 10 bytes, so the client has to fetch it
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), uploads, WithResultOffload(10))
+	requestTestTweet(t, relay, d, "20")
+
+	var offloaded OffloadedResult
+	for _, evt := range relay.Events() {
+		if evt.Kind != ResultKind(KindTweetRequest) {
+			continue
+		}
+		tag := evt.Tags.GetFirst([]string{"offloaded"})
+		if tag == nil || len(*tag) < 3 {
+			t.Fatalf("result isn't tagged as offloaded: %v", evt.Tags)
+		}
+		if err := json.Unmarshal([]byte(evt.Content), &offloaded); err != nil {
+			t.Fatal(err)
+		}
+		if offloaded.URL != (*tag)[1] || offloaded.SHA256 != (*tag)[2] || offloaded.MimeType != "application/json" {
+			t.Errorf("compact result %+v doesn't match its tag %v", offloaded, *tag)
+		}
+	}
+	if offloaded.URL == "" {
+		t.Fatal("no result published")
+	}
+
+	// A payload that doesn't match its hash is refused
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blossom.mu.Lock()
+	blossom.blobs[offloaded.SHA256] = []byte(`{"text":"forged"}`)
+	blossom.mu.Unlock()
+	if _, err := client.fetchOffloaded(context.Background(), offloaded.URL, offloaded.SHA256); err == nil || !strings.Contains(err.Error(), "hash") {
+		t.Errorf("expected a hash mismatch, got %v", err)
+	}
+}
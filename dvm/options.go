@@ -0,0 +1,460 @@
+package dvm
+
+import (
+	"time"
+
+	"github.com/imperatrona/twitter-scraper"
+)
+
+// TweetScraper fetches a single tweet by ID. *twitterscraper.Scraper satisfies it.
+type TweetScraper interface {
+	GetTweet(id string) (*twitterscraper.Tweet, error)
+}
+
+// ResultMode says how the DVM delivers job results.
+type ResultMode string
+
+const (
+	// ResultsAsJobResults publishes results as NIP-90 job result events,
+	// of kind ResultKind(request kind). This is the default.
+	ResultsAsJobResults ResultMode = "result"
+	// ResultsAsDMs sends results to the requester as NIP-04 encrypted DMs,
+	// for clients that don't read job results.
+	ResultsAsDMs ResultMode = "dm"
+	// ResultsAsNotes publishes results as public kind-1 notes, as the DVM
+	// originally did. Deprecated: it fills the DVM's feed with raw JSON,
+	// and will be removed once clients read job results.
+	ResultsAsNotes ResultMode = "note"
+)
+
+// Option configures optional Dvm behaviour in NewDvm.
+type Option func(*Dvm)
+
+// WithScraper replaces the default twitter scraper, e.g. with a mock in tests.
+func WithScraper(s TweetScraper) Option {
+	return func(d *Dvm) {
+		d.scraper = s
+	}
+}
+
+// WithChaos enables fault injection according to cfg.
+func WithChaos(cfg ChaosConfig) Option {
+	return func(d *Dvm) {
+		d.chaos = newChaos(cfg)
+	}
+}
+
+// WithStore persists DVM state (quota counters etc.) in store.
+func WithStore(store *Store) Option {
+	return func(d *Dvm) {
+		d.store = store
+	}
+}
+
+// WithQuota limits jobs per requester per day; see QuotaConfig.
+func WithQuota(cfg QuotaConfig) Option {
+	return func(d *Dvm) {
+		d.quotaCfg = cfg
+	}
+}
+
+// WithIdentity serves an additional keypair alongside the primary one.
+// Requests are routed to it by their "p" tag.
+func WithIdentity(id Identity) Option {
+	return func(d *Dvm) {
+		d.extraIdentities = append(d.extraIdentities, id)
+	}
+}
+
+// WithAuditLog records every answered request in a hash-chained log in the
+// store. A positive anchorEvery also publishes the log head to the relay at
+// that interval.
+func WithAuditLog(anchorEvery time.Duration) Option {
+	return func(d *Dvm) {
+		d.auditEnabled = true
+		d.auditAnchorEvery = anchorEvery
+	}
+}
+
+// WithQueue configures the job queue and worker pool; see QueueConfig.
+func WithQueue(cfg QueueConfig) Option {
+	return func(d *Dvm) {
+		d.queueCfg = cfg
+	}
+}
+
+// WithPublishing configures the outbound queue results and feedback are
+// published from; see PublishConfig.
+func WithPublishing(cfg PublishConfig) Option {
+	return func(d *Dvm) {
+		d.publishCfg = cfg
+	}
+}
+
+// WithClockSkew sets how far behind the DVM's clock a request's created_at
+// may be and still be picked up, default 30s. Requests are deduplicated by
+// ID, so a generous tolerance costs only a longer replay on resubscribing;
+// with a Store, restarts don't rerun the requests replayed either.
+func WithClockSkew(tolerance time.Duration) Option {
+	return func(d *Dvm) {
+		d.clockSkew = tolerance
+	}
+}
+
+// WithEOSETimeout sets how long a new subscription may go without EOSE
+// before it's taken to have been refused and is retried, default 15s. A
+// relay refusing a subscription, say until the client authenticates,
+// answers with CLOSED in place of EOSE, and go-nostr drops CLOSED
+// messages, leaving the subscription open but dead.
+func WithEOSETimeout(timeout time.Duration) Option {
+	return func(d *Dvm) {
+		d.eoseTimeout = timeout
+	}
+}
+
+// WithMaxRequestAge skips requests older than maxAge, by their created_at,
+// when their turn comes, with error feedback, rather than answering
+// requests backfilled after downtime whose clients have long given up.
+// The clock skew tolerance is allowed on top. 0 disables it, the default.
+func WithMaxRequestAge(maxAge time.Duration) Option {
+	return func(d *Dvm) {
+		d.maxRequestAge = maxAge
+	}
+}
+
+// WithTimestampWindow sets how far from the DVM's clock a request's
+// created_at may be when it arrives; see TimestampWindow.
+func WithTimestampWindow(w TimestampWindow) Option {
+	return func(d *Dvm) {
+		d.timestamps = w
+	}
+}
+
+// WithChallenge has the DVM confirm requests for expensive job kinds with
+// their requesters before running them; see ChallengeConfig.
+func WithChallenge(cfg ChallengeConfig) Option {
+	return func(d *Dvm) {
+		d.challenge = &cfg
+	}
+}
+
+// WithProfile has the DVM keep a kind-0 profile published for each of its
+// identities; see ProfileConfig.
+func WithProfile(cfg ProfileConfig) Option {
+	return func(d *Dvm) {
+		d.profile = &cfg
+	}
+}
+
+// WithMiddleware inserts mw into the request pipeline before the built-in
+// stage named before, such as StagePayment, after any inserted there
+// already; see Middleware.
+func WithMiddleware(before string, mw Middleware) Option {
+	return func(d *Dvm) {
+		d.middleware = append(d.middleware, insertedMiddleware{before: before, mw: mw})
+	}
+}
+
+// WithSelfTest has the DVM run a self-test on starting, and only become
+// ready and announce itself once it passes; see SelfTestConfig.
+func WithSelfTest(cfg SelfTestConfig) Option {
+	return func(d *Dvm) {
+		d.selfTest = &cfg
+	}
+}
+
+// WithLogSampling limits the lines logged for every request or publish to
+// perSecond a second for each kind of message, counting the rest. Errors
+// are always logged. 0 logs everything, the default.
+func WithLogSampling(perSecond int) Option {
+	return func(d *Dvm) {
+		d.logs = newLogSampler(perSecond)
+	}
+}
+
+// WithScrubber keeps requesters' pubkeys and what they asked for out of
+// the DVM's logs; see Scrubber.
+func WithScrubber(s *Scrubber) Option {
+	return func(d *Dvm) {
+		d.scrub = s
+	}
+}
+
+// WithCache keeps recently served results in memory, bounded by maxBytes of
+// serialized JSON, so repeat requests skip the scraper.
+func WithCache(maxBytes int64) Option {
+	return func(d *Dvm) {
+		if maxBytes > 0 {
+			d.cache = newResultCache(maxBytes)
+		}
+	}
+}
+
+// WithResultReplay answers a requester resubmitting a job they submitted
+// within window, the same kind, input and params to the same identity, with
+// the earlier result instead of running the job again. The result is
+// published afresh for the new request, tagged ["replayed", <unix time the
+// job ran>]. Results are kept in the result cache, so WithCache is needed.
+func WithResultReplay(window time.Duration) Option {
+	return func(d *Dvm) {
+		d.replayWindow = window
+	}
+}
+
+// WithRelays adds relays to the pool alongside the one passed to NewDvm.
+// Requests are read from the healthiest relay and results are published to
+// every relay that isn't backing off after failures.
+func WithRelays(urls ...string) Option {
+	return func(d *Dvm) {
+		d.extraRelays = append(d.extraRelays, urls...)
+	}
+}
+
+// WithSearchRelays names relays supporting NIP-50 search, used alongside
+// the pool when looking for notes that mirror a tweet.
+func WithSearchRelays(urls ...string) Option {
+	return func(d *Dvm) {
+		d.searchRelays = append(d.searchRelays, urls...)
+	}
+}
+
+// WithArchive copies every fetched tweet, and optionally its media, to an
+// S3-compatible bucket; see ArchiveConfig.
+func WithArchive(cfg ArchiveConfig) Option {
+	return func(d *Dvm) {
+		d.archiveCfg = &cfg
+	}
+}
+
+// WithAlerts DMs an operator about critical conditions; see AlertConfig.
+func WithAlerts(cfg AlertConfig) Option {
+	return func(d *Dvm) {
+		d.alertCfg = &cfg
+	}
+}
+
+// WithWatchdog has the DVM sample its goroutines, heap and queue depths,
+// logging each sample and alerting when one keeps rising; see
+// WatchdogConfig.
+func WithWatchdog(cfg WatchdogConfig) Option {
+	return func(d *Dvm) {
+		d.watchdog = newWatchdog(cfg)
+	}
+}
+
+// WithProofOfWork requires requests to carry NIP-13 proof of work of at
+// least difficulty bits, turning away the rest with feedback saying how
+// much is needed: a cost to flooding a free DVM that honest clients
+// barely notice. 0 requires none.
+func WithProofOfWork(difficulty int) Option {
+	return func(d *Dvm) {
+		d.minPoW = difficulty
+	}
+}
+
+// WithAbuseProtection denies requesters who keep abusing the DVM; see
+// AbuseConfig. With WithStore the denylist survives restarts.
+func WithAbuseProtection(cfg AbuseConfig) Option {
+	return func(d *Dvm) {
+		d.abuseCfg = &cfg
+	}
+}
+
+// WithWebOfTrust serves only requesters within a web of trust grown from
+// root pubkeys' follow lists; see WoTConfig.
+func WithWebOfTrust(cfg WoTConfig) Option {
+	return func(d *Dvm) {
+		d.wotCfg = &cfg
+	}
+}
+
+// WithListSync keeps the DVM's allowlist and denylist in step with NIP-51
+// lists the operator publishes; see ListSyncConfig.
+func WithListSync(cfg ListSyncConfig) Option {
+	return func(d *Dvm) {
+		d.listsCfg = &cfg
+	}
+}
+
+// WithUploads lets the DVM store files on a file host, e.g. for tweet
+// requests with ["param", "rehost", "true"]; see UploadConfig.
+func WithUploads(cfg UploadConfig) Option {
+	return func(d *Dvm) {
+		d.uploadCfg = &cfg
+	}
+}
+
+// WithMirrorKey lets the DVM publish content it converts, such as threads
+// as long-form articles, under a separate identity from its own, given as
+// a 64-character hex private key.
+func WithMirrorKey(privateKey string) Option {
+	return func(d *Dvm) {
+		d.mirrorSK = privateKey
+	}
+}
+
+// WithResultMode changes how results are delivered; see ResultMode.
+func WithResultMode(mode ResultMode) Option {
+	return func(d *Dvm) {
+		d.resultMode = mode
+	}
+}
+
+// WithPayments lets the DVM require payment before running expensive
+// jobs; see PaymentConfig.
+func WithPayments(cfg PaymentConfig) Option {
+	return func(d *Dvm) {
+		d.payments = &cfg
+	}
+}
+
+// WithUserArchive enables KindUserArchiveRequest jobs at pricePerTweet
+// msats, paid up front, so it needs WithPayments too. A price for the kind
+// given to WithPricing takes precedence.
+func WithUserArchive(pricePerTweet int64) Option {
+	return func(d *Dvm) {
+		d.userArchivePrice = pricePerTweet
+	}
+}
+
+// WithResultOffload uploads results over maxBytes to the upload host,
+// which needs WithUploads, publishing a compact OffloadedResult in their
+// place. DvmClient fetches and verifies them transparently.
+func WithResultOffload(maxBytes int) Option {
+	return func(d *Dvm) {
+		d.offloadBytes = maxBytes
+	}
+}
+
+// WithResultLimit caps the size of result events, applying its policy to
+// those over it; see ResultLimit. Results are offloaded or chunked first,
+// if the DVM is configured to, and the limit applies to what that leaves.
+func WithResultLimit(limit ResultLimit) Option {
+	return func(d *Dvm) {
+		d.resultLimit = limit
+	}
+}
+
+// WithResultChunking splits results over chunkBytes across several result
+// events, tagged ["chunk", <index>, <total>] counting from 0 and ["x",
+// <sha256 of the whole result>], for relays that reject big events when
+// there's no upload host to offload to. Offloading takes precedence if
+// both are configured. DvmClient reassembles and verifies them
+// transparently.
+func WithResultChunking(chunkBytes int) Option {
+	return func(d *Dvm) {
+		d.chunkBytes = chunkBytes
+	}
+}
+
+// WithFetchAttestations has results carry a FetchAttestation, signed by
+// the identity answering, of a hash of the full result and when and with
+// what it was fetched, so third parties can check where content came from
+// after the original has changed or gone. Results sent as DMs have none.
+func WithFetchAttestations() Option {
+	return func(d *Dvm) {
+		d.attestFetches = true
+	}
+}
+
+// WithPricing charges for job kinds up front; see PricingConfig. Priced
+// kinds need WithPayments too.
+func WithPricing(cfg PricingConfig) Option {
+	return func(d *Dvm) {
+		// Copied, since the user archive price may be added to it
+		d.pricing = make(PricingConfig, len(cfg))
+		for kind, price := range cfg {
+			d.pricing[kind] = price
+		}
+	}
+}
+
+// WithPolicy filters results through an operator's content policy before
+// they're published; see PolicyConfig.
+func WithPolicy(cfg PolicyConfig) Option {
+	return func(d *Dvm) {
+		d.policyCfg = &cfg
+	}
+}
+
+// WithTemplates renders text and markdown tweet results with the given
+// templates rather than the defaults; see TemplateConfig.
+func WithTemplates(cfg TemplateConfig) Option {
+	return func(d *Dvm) {
+		d.templateCfg = &cfg
+	}
+}
+
+// WithCrossCheck compares suspicious tweets with other tweet DVMs before
+// serving them; see CrossCheckConfig.
+func WithCrossCheck(cfg CrossCheckConfig) Option {
+	return func(d *Dvm) {
+		d.crossCheck = &cfg
+	}
+}
+
+// WithRelayLookup serves tweets from results already on the DVM's relays,
+// its own or trusted peers', before scraping them; see RelayLookupConfig.
+func WithRelayLookup(cfg RelayLookupConfig) Option {
+	return func(d *Dvm) {
+		d.relayLookup = &cfg
+	}
+}
+
+// WithCollisionCheck watches the relays for another instance running
+// under the DVM's keys; see CollisionConfig.
+func WithCollisionCheck(cfg CollisionConfig) Option {
+	return func(d *Dvm) {
+		d.collisionCfg = &cfg
+	}
+}
+
+// WithLocalAPI serves jobs to local processes over WebSocket; see
+// LocalAPIConfig.
+func WithLocalAPI(cfg LocalAPIConfig) Option {
+	return func(d *Dvm) {
+		d.localCfg = &cfg
+	}
+}
+
+// WithTranslator enables KindTranslateRequest jobs using t; see
+// NewTranslator for the built-in providers.
+func WithTranslator(t Translator) Option {
+	return func(d *Dvm) {
+		d.translator = t
+	}
+}
+
+// WithLLM enables LLM-powered jobs such as KindSummarizeRequest; see
+// LLMConfig.
+func WithLLM(cfg LLMConfig) Option {
+	return func(d *Dvm) {
+		d.llmCfg = &cfg
+	}
+}
+
+// WithAnalyzer replaces the analyzer used for KindSentimentRequest jobs.
+func WithAnalyzer(a Analyzer) Option {
+	return func(d *Dvm) {
+		d.analyzer = a
+	}
+}
+
+// WithOCR lets tweet requests ask for the text in the tweet's photos with
+// ["param", "ocr", "true"]; see NewTesseractOCR for the built-in engine.
+func WithOCR(engine OCREngine) Option {
+	return func(d *Dvm) {
+		d.ocr = engine
+	}
+}
+
+// WithHandler serves requests of the given kind with h, replacing any
+// built-in handler for that kind.
+func WithHandler(kind int, h JobHandler) Option {
+	return func(d *Dvm) {
+		if d.handlers == nil {
+			d.handlers = make(map[int]JobHandler)
+		}
+		d.handlers[kind] = h
+	}
+}
@@ -0,0 +1,213 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// PublishConfig controls the outbound queue events are published from.
+// Publishing runs on its own workers, so a slow or failing relay holds up
+// other publishes at worst, never the jobs producing them.
+type PublishConfig struct {
+	Workers  int           // concurrent publishers, default 4
+	Limit    int           // queued events before publishing blocks the caller, default 1000
+	Attempts int           // tries per event before giving up, default 3
+	Backoff  time.Duration // wait before the first retry, doubling for each one after, default 500ms
+}
+
+func (c PublishConfig) withDefaults() PublishConfig {
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.Limit <= 0 {
+		c.Limit = 1000
+	}
+	if c.Attempts <= 0 {
+		c.Attempts = 3
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 500 * time.Millisecond
+	}
+	return c
+}
+
+// outboundEvent is an event waiting in the outbox.
+type outboundEvent struct {
+	evt     nostr.Event
+	attempt int         // attempts made so far
+	done    func(error) // called once with the outcome, may be nil
+	start   time.Time
+	queued  bool // whether it went through the queue rather than being published inline
+}
+
+// outbox is the DVM's outbound queue. Events wait for a retry off the
+// workers, on a timer, so one undeliverable event doesn't occupy a
+// publisher through its backoff.
+type outbox struct {
+	cfg   PublishConfig
+	queue chan *outboundEvent
+
+	mu      sync.Mutex // guards running and quit
+	running bool
+	quit    chan struct{}
+	pending sync.WaitGroup // events queued or waiting for a retry
+	workers sync.WaitGroup
+}
+
+func newOutbox(cfg PublishConfig) *outbox {
+	cfg = cfg.withDefaults()
+	return &outbox{cfg: cfg, queue: make(chan *outboundEvent, cfg.Limit)}
+}
+
+// start launches the publishers for d.
+func (o *outbox) start(d *Dvm) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.running {
+		return
+	}
+	o.running = true
+	o.quit = make(chan struct{})
+	for i := 0; i < o.cfg.Workers; i++ {
+		o.workers.Add(1)
+		go func(quit chan struct{}) {
+			defer o.workers.Done()
+			for {
+				select {
+				case ob := <-o.queue:
+					metricPublishQueueDepth.Add(-1)
+					d.publishAttempt(ob)
+				case <-quit:
+					return
+				}
+			}
+		}(o.quit)
+	}
+}
+
+// stop waits for everything queued, retries included, to be published or
+// given up on, then stops the publishers. Publishes after stop happen on
+// the caller's goroutine.
+func (o *outbox) stop() {
+	o.mu.Lock()
+	if !o.running {
+		o.mu.Unlock()
+		return
+	}
+	o.running = false
+	o.mu.Unlock()
+
+	o.pending.Wait()
+	close(o.quit)
+	o.workers.Wait()
+}
+
+// publishAsync queues evt for publishing to every healthy relay in the pool
+// and returns without waiting for the relays, unless the outbox is full.
+// done, if not nil, is called with the outcome.
+func (d *Dvm) publishAsync(evt nostr.Event, done func(error)) {
+	d.collisions.sent(evt.ID)
+
+	// Responses to jobs submitted locally skip the relays
+	if d.local.deliver(&evt) {
+		if done != nil {
+			done(nil)
+		}
+		return
+	}
+
+	ob := &outboundEvent{evt: evt, done: done, start: time.Now()}
+	o := d.outbox
+	o.mu.Lock()
+	if !o.running {
+		// Not running (yet, or any more): publish inline
+		o.mu.Unlock()
+		for !d.publishAttempt(ob) {
+			time.Sleep(o.backoff(ob.attempt))
+		}
+		return
+	}
+	ob.queued = true
+	o.pending.Add(1)
+	o.mu.Unlock()
+
+	metricPublishQueueDepth.Add(1)
+	o.queue <- ob
+}
+
+// publish sends evt to every healthy relay in the pool through the outbox,
+// waiting until at least one accepts it or the outbox gives up.
+func (d *Dvm) publish(evt nostr.Event) error {
+	errc := make(chan error, 1)
+	d.publishAsync(evt, func(err error) { errc <- err })
+	return <-errc
+}
+
+// backoff is how long to wait before retrying after attempt attempts.
+func (o *outbox) backoff(attempt int) time.Duration {
+	return o.cfg.Backoff << (attempt - 1)
+}
+
+// publishAttempt makes one attempt at publishing ob to the healthy relays.
+// It reports whether ob is finished with, which it isn't if it failed with
+// attempts to spare; queued events are then requeued after the backoff,
+// the caller retries inline ones.
+func (d *Dvm) publishAttempt(ob *outboundEvent) bool {
+	o := d.outbox
+	ob.attempt++
+	relays := d.pool.healthy()
+	errs := make([]error, len(relays))
+	var wg sync.WaitGroup
+	for i, r := range relays {
+		wg.Add(1)
+		go func(i int, r *poolRelay) {
+			defer wg.Done()
+			errs[i] = d.publishTo(r, ob.evt)
+		}(i, r)
+	}
+	wg.Wait()
+
+	accepted := 0
+	var publishErr error
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("DVM publish error on %s (attempt %d/%d): %v", relays[i].url, ob.attempt, o.cfg.Attempts, err)
+			publishErr = err
+			continue
+		}
+		accepted++
+	}
+
+	var err error
+	switch {
+	case accepted > 0:
+		d.logs.printf("Published event %s to %d/%d relays in %v", ob.evt.ID, accepted, len(relays), time.Since(ob.start))
+	case ob.attempt < o.cfg.Attempts:
+		metricPublishRetries.Add(1)
+		if ob.queued {
+			time.AfterFunc(o.backoff(ob.attempt), func() {
+				metricPublishQueueDepth.Add(1)
+				o.queue <- ob
+			})
+		}
+		return false
+	default:
+		metricPublishFailures.Add(1)
+		if publishErr == nil {
+			publishErr = fmt.Errorf("no healthy relays")
+		}
+		err = fmt.Errorf("publish failed after %d attempts: %w", o.cfg.Attempts, publishErr)
+	}
+
+	if ob.done != nil {
+		ob.done(err)
+	}
+	if ob.queued {
+		o.pending.Done()
+	}
+	return true
+}
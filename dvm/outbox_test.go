@@ -0,0 +1,84 @@
+package dvm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestOutboxRetriesOffTheCaller(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay,
+		WithScraper(&fakeScraper{}),
+		WithChaos(ChaosConfig{PublishFailRate: 1}),
+		WithPublishing(PublishConfig{Attempts: 3, Backoff: 100 * time.Millisecond}),
+	)
+	failures := metricPublishFailures.Value()
+
+	evt := nostr.Event{PubKey: d.pk, CreatedAt: nostr.Now(), Kind: 1, Content: "never"}
+	evt.Sign(d.sk)
+	errc := make(chan error, 1)
+	start := time.Now()
+	d.publishAsync(evt, func(err error) { errc <- err })
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("publishAsync blocked for %v", elapsed)
+	}
+
+	select {
+	case err := <-errc:
+		// Two retries, 100ms then 200ms after the failures before them
+		if err == nil {
+			t.Fatal("expected publishing to fail when every attempt is sabotaged")
+		}
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Errorf("gave up after %v, before the retry schedule", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("outbox never gave up")
+	}
+	if metricPublishFailures.Value() <= failures {
+		t.Error("expected the failure to be counted")
+	}
+}
+
+func TestOutboxDrainsOnStop(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d, err := NewDvm(relay.URL(), testKey(), WithScraper(&fakeScraper{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	var sent []string
+	for i := 0; i < 20; i++ {
+		evt := nostr.Event{PubKey: d.pk, CreatedAt: nostr.Now(), Kind: 1, Content: fmt.Sprintf("queued %d", i)}
+		evt.Sign(d.sk)
+		d.publishAsync(evt, nil)
+		sent = append(sent, evt.ID)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	published := make(map[string]bool)
+	for _, evt := range relay.Events() {
+		published[evt.ID] = true
+	}
+	for _, id := range sent {
+		if !published[id] {
+			t.Errorf("event %s queued before stopping wasn't published", id[:8])
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package dvm
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DecodeParams fills the fields of the struct v points to from req's NIP-90
+// ["param", <name>, <value>...] tags. Fields name their param with a
+// `param:"<name>"` struct tag and may be strings, bools ("true" or
+// "false"), ints, floats, time.Durations or, for params with several
+// values, []string. Params the request doesn't carry leave their field
+// as it was, so set defaults before decoding; params the struct doesn't
+// name are ignored.
+//
+//	params := struct {
+//		MaxResults int  `param:"max_results"`
+//		Replies    bool `param:"include_replies"`
+//	}{MaxResults: 20}
+//	if err := DecodeParams(req, &params); err != nil {
+//		return err
+//	}
+func DecodeParams(req *nostr.Event, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("params must be decoded into a pointer to a struct, not %T", v)
+	}
+	rv = rv.Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		name := rv.Type().Field(i).Tag.Get("param")
+		if name == "" {
+			continue
+		}
+		for _, tag := range req.Tags {
+			if len(tag) < 3 || tag[0] != "param" || tag[1] != name {
+				continue
+			}
+			if err := setParam(rv.Field(i), tag[2:]); err != nil {
+				return fmt.Errorf("%s param %w", name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setParam sets field from a param's values.
+func setParam(field reflect.Value, values []string) error {
+	value := values[0]
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 30s or 1h, not %q", value)
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false")
+		}
+		field.SetBool(value == "true")
+	case field.CanInt():
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a whole number, not %q", value)
+		}
+		field.SetInt(n)
+	case field.CanFloat():
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number, not %q", value)
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		field.Set(reflect.ValueOf(append([]string(nil), values...)))
+	default:
+		return fmt.Errorf("can't be decoded into a %s", field.Type())
+	}
+	return nil
+}
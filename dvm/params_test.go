@@ -0,0 +1,58 @@
+package dvm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDecodeParams(t *testing.T) {
+	type params struct {
+		MaxResults int           `param:"max_results"`
+		Replies    bool          `param:"include_replies"`
+		Lang       string        `param:"lang"`
+		Format     string        `param:"format"`
+		Threshold  float64       `param:"threshold"`
+		Every      time.Duration `param:"every"`
+		Langs      []string      `param:"langs"`
+		Untagged   string
+	}
+	req := &nostr.Event{Tags: nostr.Tags{
+		{"param", "max_results", "50"},
+		{"param", "include_replies", "true"},
+		{"param", "lang", "de"},
+		{"param", "threshold", "0.5"},
+		{"param", "every", "90s"},
+		{"param", "langs", "en", "es"},
+		{"param", "unknown", "x"},
+		{"param", "format"}, // no value
+		{"i", "ignored"},
+	}}
+	got := params{Format: "json", Untagged: "kept"}
+	if err := DecodeParams(req, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := params{
+		MaxResults: 50, Replies: true, Lang: "de", Format: "json", Threshold: 0.5,
+		Every: 90 * time.Second, Langs: []string{"en", "es"}, Untagged: "kept",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []nostr.Tag{
+		{"param", "max_results", "lots"},
+		{"param", "include_replies", "yes"},
+		{"param", "threshold", "high"},
+		{"param", "every", "daily"},
+	} {
+		if err := DecodeParams(&nostr.Event{Tags: nostr.Tags{bad}}, &params{}); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+	if err := DecodeParams(req, params{}); err == nil {
+		t.Error("expected decoding into a non-pointer to fail")
+	}
+}
@@ -0,0 +1,266 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// PaymentConfig lets the DVM take payment up front for expensive jobs.
+// Requesters pay by zapping the job request (NIP-57); the DVM accepts the
+// zap receipts published by its lightning address's zapper. Alternatively,
+// with a Nostr Wallet Connect (NIP-47) URI, the DVM makes an invoice for
+// each job in the operator's wallet and sends it with its payment-required
+// feedback. A lightning address works the same way, with invoices from its
+// LNURL-pay endpoint checked through their LUD-21 verify URLs; if the
+// address doesn't offer those, requesters zap instead, given a ZapperPubKey.
+//
+// With a Core Lightning node, invoices can come with a BOLT12 offer, sent
+// as a ["bolt12", <offer>] feedback tag beside the amount.
+//
+// The keys that sign results needn't be the ones paid. With a PayeePubKey,
+// requesters zap that pubkey, whose profile holds the operator's lightning
+// address and whose private key never reaches the DVM, so someone with the
+// signing key can't redirect zaps by changing the DVM's profile. Likewise a
+// RefundNWC connection keeps the one allowed to spend apart from the one
+// that makes invoices, which then needs only receive permissions.
+type PaymentConfig struct {
+	ZapperPubKey     string        // hex pubkey that signs the DVM's zap receipts
+	PayeePubKey      string        // hex pubkey requesters zap; default the identity's own
+	NWC              string        // nostr+walletconnect:// URI; takes precedence over zaps
+	LightningAddress string        // user@domain to invoice through LNURL-pay, if not NWC
+	Timeout          time.Duration // how long to wait for payment; default 10m
+
+	// CoreLightning, if set, makes the invoices on the operator's own node,
+	// taking precedence over NWC and lightning addresses.
+	CoreLightning *CoreLightningConfig
+
+	// Refunds pays back requesters whose prepaid jobs fail, from the NWC
+	// wallet or Core Lightning node. Requesters say where with a refund
+	// param, a lightning address or node pubkey to keysend to, or else the
+	// lightning address (lud16) in their profile.
+	Refunds bool
+	// RefundNWC, if set, is the nostr+walletconnect:// URI refunds are paid
+	// through, in place of NWC or CoreLightning, so those need no permission
+	// to spend. Give it a budget.
+	RefundNWC string
+}
+
+func (c PaymentConfig) withDefaults() PaymentConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Minute
+	}
+	return c
+}
+
+// KindZapReceipt is the NIP-57 zap receipt kind.
+const KindZapReceipt = 9735
+
+// paymentPollInterval is how often relays are checked for a zap receipt.
+var paymentPollInterval = 3 * time.Second
+
+// errPaymentTimeout is returned by awaitPayment when no zap arrives in time.
+var errPaymentTimeout = errors.New("no payment received")
+
+// awaitPayment asks the requester for msats with payment-required feedback
+// and waits for a zap of the request covering it, returning the msats
+// paid.
+func (d *Dvm) awaitPayment(id *identity, req *nostr.Event, msats int64) (int64, error) {
+	if d.payments == nil {
+		return 0, errors.New("this DVM doesn't take payments")
+	}
+	if d.invoicer != nil {
+		paid, err := d.awaitInvoice(id, req, msats)
+		if !errors.Is(err, errUnverifiable) || d.payments.ZapperPubKey == "" {
+			return paid, err
+		}
+	}
+	payee := d.payee(id)
+	tags := []nostr.Tag{{"amount", strconv.FormatInt(msats, 10)}}
+	if payee != id.pk {
+		// NIP-57 zap splits: zap the payee in place of this identity
+		tags = append(tags, nostr.Tag{"zap", payee, "", "1"})
+	}
+	d.publishFeedback(id, req, StatusPaymentRequired, "",
+		fmt.Sprintf("Zap this request %d sats to run it", (msats+999)/1000), tags...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.payments.Timeout)
+	defer cancel()
+	filter := nostr.Filter{Kinds: []int{KindZapReceipt}, Tags: nostr.TagMap{"e": {req.ID}, "p": {payee}}}
+	ticker := time.NewTicker(paymentPollInterval)
+	defer ticker.Stop()
+	for {
+		var paid int64
+		for _, f := range d.queryRelays(ctx, []nostr.Filter{filter}, nil, nil) {
+			paid += d.zapAmount(f.Event, req.ID, payee)
+		}
+		if paid >= msats {
+			return paid, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return paid, errPaymentTimeout
+		case <-d.done:
+			return paid, errShuttingDown
+		}
+	}
+}
+
+// payee is the pubkey zaps for id's jobs go to.
+func (d *Dvm) payee(id *identity) string {
+	if d.payments.PayeePubKey != "" {
+		return d.payments.PayeePubKey
+	}
+	return id.pk
+}
+
+// awaitInvoice is awaitPayment for DVMs paid by invoice: it sends the
+// requester an invoice for msats with payment-required feedback, as
+// ["amount", <msats>, <bolt11>], and waits for it to be paid.
+func (d *Dvm) awaitInvoice(id *identity, req *nostr.Event, msats int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.payments.Timeout)
+	defer cancel()
+	inv, err := d.invoicer.invoice(ctx, msats, fmt.Sprintf("Kind %d job %s", req.Kind, req.ID[:8]), d.payments.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("making invoice: %w", err)
+	}
+	d.publishFeedback(id, req, StatusPaymentRequired, "",
+		fmt.Sprintf("Pay this invoice for %d sats to run the job", (msats+999)/1000),
+		invoiceTags(msats, inv)...)
+
+	ticker := time.NewTicker(paymentPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0, errPaymentTimeout
+		case <-d.done:
+			return 0, errShuttingDown
+		}
+		paid, err := d.invoicer.paid(ctx, inv)
+		if err != nil {
+			log.Printf("Failed to check invoice for request %s: %v", req.ID[:8], err)
+			continue
+		}
+		if paid {
+			return msats, nil
+		}
+	}
+}
+
+// invoicer makes an invoice for each job and checks whether it's been paid,
+// for DVMs paid by invoice rather than by zap.
+type invoicer interface {
+	invoice(ctx context.Context, msats int64, description string, expiry time.Duration) (*jobInvoice, error)
+	paid(ctx context.Context, inv *jobInvoice) (bool, error)
+}
+
+// jobInvoice is an invoice made for a job.
+type jobInvoice struct {
+	bolt11 string
+	ref    string // how the invoicer looks it up, such as its payment hash
+
+	bolt12   string // an offer for the same amount, if the invoicer makes them
+	offerRef string
+}
+
+// invoiceTags are the payment-required feedback tags for inv.
+func invoiceTags(msats int64, inv *jobInvoice) []nostr.Tag {
+	tags := []nostr.Tag{{"amount", strconv.FormatInt(msats, 10), inv.bolt11}}
+	if inv.bolt12 != "" {
+		tags = append(tags, nostr.Tag{"bolt12", inv.bolt12})
+	}
+	return tags
+}
+
+// recordPayment adds a payment to the ledger, if the DVM has a store.
+func (d *Dvm) recordPayment(req *nostr.Event, msats int64) {
+	if d.store == nil {
+		return
+	}
+	entry := LedgerEntry{JobID: req.ID, Requester: req.PubKey, Kind: req.Kind, Sats: msats / 1000}
+	if err := NewLedger(d.store).Record(entry); err != nil {
+		log.Printf("Failed to record payment for request %s: %v", req.ID[:8], err)
+	}
+}
+
+// zapAmount returns the msats a zap receipt pays towards the request, or 0
+// if it isn't a valid receipt for it.
+func (d *Dvm) zapAmount(receipt *nostr.Event, requestID, pubkey string) int64 {
+	if receipt.PubKey != d.payments.ZapperPubKey {
+		return 0
+	}
+	if !verifyEvent(receipt) {
+		return 0
+	}
+	if receipt.Tags.GetFirst([]string{"e", requestID}) == nil || receipt.Tags.GetFirst([]string{"p", pubkey}) == nil {
+		return 0
+	}
+	bolt11 := receipt.Tags.GetFirst([]string{"bolt11"})
+	if bolt11 == nil || len(*bolt11) < 2 {
+		return 0
+	}
+	msats, err := bolt11Msats((*bolt11)[1])
+	if err != nil {
+		log.Printf("Ignoring zap receipt %s: %v", receipt.ID[:8], err)
+		return 0
+	}
+
+	// The zap request, which the requester signed, must agree on the amount
+	if desc := receipt.Tags.GetFirst([]string{"description"}); desc != nil && len(*desc) >= 2 {
+		var zapRequest nostr.Event
+		if json.Unmarshal([]byte((*desc)[1]), &zapRequest) == nil {
+			if amount := zapRequest.Tags.GetFirst([]string{"amount"}); amount != nil && len(*amount) >= 2 && (*amount)[1] != strconv.FormatInt(msats, 10) {
+				return 0
+			}
+		}
+	}
+	return msats
+}
+
+// bolt11Msats returns the amount of a BOLT11 invoice in msats, from the
+// human-readable part (e.g. "lnbc2500u1..." is 250000000 msats).
+func bolt11Msats(invoice string) (int64, error) {
+	invoice = strings.ToLower(invoice)
+	sep := strings.LastIndexByte(invoice, '1')
+	if !strings.HasPrefix(invoice, "ln") || sep < 0 {
+		return 0, fmt.Errorf("not a bolt11 invoice")
+	}
+	hrp := strings.TrimLeft(invoice[2:sep], "abcdefghijklmnopqrstuvwxyz")
+	if hrp == "" {
+		return 0, fmt.Errorf("invoice has no amount")
+	}
+
+	// Multipliers of a bitcoin, which is 1e11 msats
+	perUnit := map[byte]int64{'m': 1e8, 'u': 1e5, 'n': 100}
+	digits := hrp
+	unit := int64(1e11)
+	if last := hrp[len(hrp)-1]; last < '0' || last > '9' {
+		digits = hrp[:len(hrp)-1]
+		if last == 'p' {
+			n, err := strconv.ParseInt(digits, 10, 64)
+			if err != nil || n%10 != 0 {
+				return 0, fmt.Errorf("invalid invoice amount %q", hrp)
+			}
+			return n / 10, nil
+		}
+		var ok bool
+		if unit, ok = perUnit[last]; !ok {
+			return 0, fmt.Errorf("invalid invoice multiplier %q", last)
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid invoice amount %q", hrp)
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,132 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBolt11Msats(t *testing.T) {
+	for invoice, want := range map[string]int64{
+		"lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfq": 250_000_000,
+		"lnbc20m1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfq":   2_000_000_000,
+		"LNBC15N1PVJLUEZ":  1500,
+		"lntb10p1pvjluez":  1,
+		"lnbcrt1m1pvjluez": 100_000_000,
+	} {
+		if got, err := bolt11Msats(invoice); err != nil || got != want {
+			t.Errorf("bolt11Msats(%s) = %d, %v; want %d", invoice[:10], got, err, want)
+		}
+	}
+	for _, bad := range []string{"lnbc1pvjluez", "lnbc15p1pvjluez", "lnbc10x1pvjluez", "bc1qxyz"} {
+		if _, err := bolt11Msats(bad); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+// newZapReceipt returns a zap receipt for requestID paying pubkey, signed
+// by zapperSK.
+func newZapReceipt(zapperSK, requestID, pubkey, bolt11 string) *nostr.Event {
+	receipt := &nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindZapReceipt,
+		Tags:      nostr.Tags{{"p", pubkey}, {"e", requestID}, {"bolt11", bolt11}},
+	}
+	receipt.Sign(zapperSK)
+	return receipt
+}
+
+func TestAwaitPayment(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	zapperSK := testKey()
+	zapperPK, _ := nostr.GetPublicKey(zapperSK)
+	d := startTestDvm(t, relay, WithPayments(PaymentConfig{ZapperPubKey: zapperPK, Timeout: 2 * time.Second}))
+	id := d.identities[0]
+
+	req := newTestRequest("1")
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		// Not from the zapper, then too little, then the rest
+		relay.Publish(newZapReceipt(testKey(), req.ID, id.pk, "lnbc20n1pvjluez"))
+		relay.Publish(newZapReceipt(zapperSK, req.ID, id.pk, "lnbc10n1pvjluez"))
+		time.Sleep(200 * time.Millisecond)
+		relay.Publish(newZapReceipt(zapperSK, req.ID, id.pk, "lnbc10n1pvjluey"))
+	}()
+	paid, err := d.awaitPayment(id, req, 2000)
+	if err != nil || paid != 2000 {
+		t.Fatalf("awaitPayment = %d, %v; want 2000", paid, err)
+	}
+
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Kind != KindJobFeedback || fb.Tags.GetFirst([]string{"status", StatusPaymentRequired}) == nil ||
+		fb.Tags.GetFirst([]string{"amount", "2000"}) == nil {
+		t.Errorf("expected payment-required feedback, got %+v", fb)
+	}
+
+	unpaid := newTestRequest("2")
+	if _, err := d.awaitPayment(id, unpaid, 1000); err != errPaymentTimeout {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestAwaitPaymentToPayee(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	zapperSK := testKey()
+	zapperPK, _ := nostr.GetPublicKey(zapperSK)
+	payeePK, _ := nostr.GetPublicKey(testKey())
+	d := startTestDvm(t, relay, WithPayments(PaymentConfig{ZapperPubKey: zapperPK, PayeePubKey: payeePK, Timeout: 2 * time.Second}))
+	id := d.identities[0]
+
+	req := newTestRequest("1")
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		// A zap of the signing identity doesn't count, one of the payee does
+		relay.Publish(newZapReceipt(zapperSK, req.ID, id.pk, "lnbc20n1pvjluez"))
+		time.Sleep(200 * time.Millisecond)
+		relay.Publish(newZapReceipt(zapperSK, req.ID, payeePK, "lnbc20n1pvjluey"))
+	}()
+	start := time.Now()
+	paid, err := d.awaitPayment(id, req, 2000)
+	if err != nil || paid != 2000 {
+		t.Fatalf("awaitPayment = %d, %v; want 2000", paid, err)
+	}
+	if time.Since(start) < 400*time.Millisecond {
+		t.Error("expected the zap of the signing identity to be ignored")
+	}
+
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Tags.GetFirst([]string{"zap", payeePK}) == nil {
+		t.Errorf("expected the feedback to direct zaps to the payee, got %v", fb.Tags)
+	}
+}
+
+func TestRefundWallet(t *testing.T) {
+	zapperPK, _ := nostr.GetPublicKey(testKey())
+	walletPK, _ := nostr.GetPublicKey(testKey())
+	uri := "nostr+walletconnect://" + walletPK + "?relay=wss://relay.example&secret=" + testKey()
+
+	// Zaps can't refund, but a refund wallet can
+	if _, err := NewDvm("ws://localhost:1", testKey(), WithPayments(PaymentConfig{ZapperPubKey: zapperPK, Refunds: true})); err == nil {
+		t.Error("expected refunds without a wallet to be rejected")
+	}
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithPayments(PaymentConfig{ZapperPubKey: zapperPK, Refunds: true, RefundNWC: uri}))
+	if d.invoicer != nil || d.refunder == nil {
+		t.Error("expected refunds from the refund wallet alone")
+	}
+	if _, err := NewDvm("ws://localhost:1", testKey(), WithPayments(PaymentConfig{ZapperPubKey: zapperPK, PayeePubKey: "npub"})); err == nil {
+		t.Error("expected an invalid payee pubkey to be rejected")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build go1.24
+
+package dvm
+
+import (
+	"crypto/pbkdf2"
+	"hash"
+)
+
+// pbkdf2Key is PBKDF2 with HMAC over newHash as its PRF, as in RFC 8018.
+func pbkdf2Key(newHash func() hash.Hash, password, salt []byte, iterations, keyLen int) ([]byte, error) {
+	return pbkdf2.Key(newHash, string(password), salt, iterations, keyLen)
+}
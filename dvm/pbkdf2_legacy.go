@@ -0,0 +1,33 @@
+//go:build !go1.24
+
+package dvm
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+)
+
+// pbkdf2Key is PBKDF2 with HMAC over newHash as its PRF, as in RFC 8018,
+// for toolchains older than Go 1.24, whose standard library has none.
+func pbkdf2Key(newHash func() hash.Hash, password, salt []byte, iterations, keyLen int) ([]byte, error) {
+	prf := hmac.New(newHash, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen], nil
+}
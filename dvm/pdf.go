@@ -0,0 +1,190 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// PDFText is the result of a KindPDFRequest job: a document's text split
+// into chunks sized for downstream language-model jobs.
+type PDFText struct {
+	URL         string     `json:"url"`
+	Pages       int        `json:"pages"`
+	WordCount   int        `json:"word_count"`
+	ContentHash string     `json:"content_sha256"` // of the full text
+	Chunks      []PDFChunk `json:"chunks"`
+}
+
+// PDFChunk is a run of whole paragraphs, noting the pages it spans.
+type PDFChunk struct {
+	Index     int    `json:"index"`
+	FirstPage int    `json:"first_page"`
+	LastPage  int    `json:"last_page"`
+	Text      string `json:"text"`
+}
+
+const (
+	// maxPDFBytes caps the documents the DVM will download.
+	maxPDFBytes = 25 << 20
+
+	defaultPDFChunkSize = 4000
+	minPDFChunkSize     = 200
+	maxPDFChunkSize     = 50000
+)
+
+// pdfHandler downloads a PDF and extracts its text. Requests may carry a
+// ["param", "chunk_size", "<chars>"] tag.
+type pdfHandler struct {
+	client *http.Client
+}
+
+func newPDFHandler() *pdfHandler {
+	return &pdfHandler{client: newFetchClient()}
+}
+
+func (h *pdfHandler) Validate(req *nostr.Event) error {
+	if _, err := parseWebURL(req.Content); err != nil {
+		return err
+	}
+	_, err := pdfChunkSize(req)
+	return err
+}
+
+func pdfChunkSize(req *nostr.Event) (int, error) {
+	value, ok := paramValue(req, "chunk_size")
+	if !ok {
+		return defaultPDFChunkSize, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minPDFChunkSize || n > maxPDFChunkSize {
+		return 0, fmt.Errorf("chunk_size param must be between %d and %d", minPDFChunkSize, maxPDFChunkSize)
+	}
+	return n, nil
+}
+
+func (h *pdfHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	pdfURL := strings.TrimSpace(req.Content)
+	chunkSize, err := pdfChunkSize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{"Accept": {"application/pdf"}}
+	res, err := fetchWith(ctx, h.client, pdfURL, header, maxPDFBytes)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parsePDF(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := doc.pages()
+	texts := make([]string, len(pages))
+	var full []string
+	for i, page := range pages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		texts[i] = cleanPDFText(doc.pageText(page))
+		if texts[i] != "" {
+			full = append(full, texts[i])
+		}
+	}
+	if len(full) == 0 {
+		return nil, errors.New("no extractable text in PDF (scanned document?)")
+	}
+
+	text := strings.Join(full, "\n\n")
+	return json.Marshal(PDFText{
+		URL:         pdfURL,
+		Pages:       len(pages),
+		WordCount:   len(strings.Fields(text)),
+		ContentHash: sha256Hex([]byte(text)),
+		Chunks:      chunkPages(texts, chunkSize),
+	})
+}
+
+// cleanPDFText trims each line and collapses runs of blank lines.
+func cleanPDFText(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// chunkPages packs the pages' paragraphs into chunks of at most size
+// characters, breaking on paragraph, then line, then word boundaries.
+func chunkPages(pages []string, size int) []PDFChunk {
+	var chunks []PDFChunk
+	var cur strings.Builder
+	first, last := 0, 0
+
+	flush := func() {
+		if text := strings.TrimSpace(cur.String()); text != "" {
+			chunks = append(chunks, PDFChunk{Index: len(chunks), FirstPage: first, LastPage: last, Text: text})
+		}
+		cur.Reset()
+	}
+	add := func(piece string, page int) {
+		if cur.Len() > 0 && cur.Len()+2+len(piece) > size {
+			flush()
+		}
+		if cur.Len() == 0 {
+			first = page
+		} else {
+			cur.WriteString("\n\n")
+		}
+		cur.WriteString(piece)
+		last = page
+	}
+
+	for i, text := range pages {
+		for _, para := range strings.Split(text, "\n\n") {
+			for _, piece := range splitText(para, size) {
+				add(piece, i+1)
+			}
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitText breaks s into pieces of at most size bytes, on line breaks
+// where possible and otherwise between words.
+func splitText(s string, size int) []string {
+	if len(s) <= size {
+		return []string{s}
+	}
+	var pieces []string
+	for len(s) > size {
+		cut := strings.LastIndex(s[:size], "\n")
+		if cut <= 0 {
+			cut = strings.LastIndex(s[:size], " ")
+		}
+		if cut <= 0 {
+			for cut = size; cut > 0 && !utf8.RuneStart(s[cut]); cut-- {
+			}
+		}
+		pieces = append(pieces, strings.TrimSpace(s[:cut]))
+		s = strings.TrimSpace(s[cut:])
+	}
+	if s != "" {
+		pieces = append(pieces, s)
+	}
+	return pieces
+}
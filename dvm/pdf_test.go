@@ -0,0 +1,138 @@
+package dvm
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// buildTestPDF assembles a two-page PDF: page one in a standard font with
+// an uncompressed content stream, page two in a two-byte font with a
+// Flate-compressed stream and a ToUnicode CMap, as PDF writers emit for
+// embedded fonts.
+func buildTestPDF() []byte {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte("BT /F2 12 Tf 72 720 Td <00010002> Tj 0 -14 Td [<0003> -500 <0001>] TJ ET"))
+	zw.Close()
+
+	cmap := `/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+1 beginbfchar <0001> <0048> endbfchar
+1 beginbfrange <0002> <0003> <0069> endbfrange
+endcmap end`
+
+	page1 := `BT /F1 12 Tf 72 720 Td (Hello, \(PDF\) world) Tj 0 -14 Td [(Second) -300 (line)] TJ ET`
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [8 0 R] >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /Embedded /Encoding /Identity-H /ToUnicode 9 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(page1), page1),
+		fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()),
+		fmt.Sprintf("<< /Length 10 0 R >>\nstream\n%s\nendstream", cmap),
+		fmt.Sprintf("%d", len(cmap)),
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer << /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestParsePDF(t *testing.T) {
+	doc, err := parsePDF(buildTestPDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := doc.pages()
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(pages))
+	}
+
+	want := []string{"Hello, (PDF) world\nSecond line", "Hi\nj H"}
+	for i, page := range pages {
+		if got := cleanPDFText(doc.pageText(page)); got != want[i] {
+			t.Errorf("page %d: got %q, want %q", i+1, got, want[i])
+		}
+	}
+
+	if _, err := parsePDF([]byte("<html>not a pdf</html>")); err == nil {
+		t.Error("expected non-PDF data to be rejected")
+	}
+	if _, err := parsePDF([]byte("%PDF-1.4\ntrailer << /Root 1 0 R /Encrypt 2 0 R >>")); err == nil {
+		t.Error("expected an encrypted PDF to be rejected")
+	}
+}
+
+func TestChunkPages(t *testing.T) {
+	para := strings.Repeat("word ", 50) // 250 bytes
+	pages := []string{para + "\n\n" + para, para, strings.Repeat("x", 450)}
+
+	chunks := chunkPages(pages, 600)
+	for _, c := range chunks {
+		if len(c.Text) > 600 {
+			t.Errorf("chunk %d is %d bytes", c.Index, len(c.Text))
+		}
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[0].FirstPage != 1 || chunks[0].LastPage != 1 || chunks[1].FirstPage != 2 || chunks[2].LastPage != 3 {
+		t.Errorf("unexpected page spans: %+v", chunks)
+	}
+
+	// Unbreakable text is split hard
+	if pieces := splitText(strings.Repeat("x", 450), 200); len(pieces) != 3 {
+		t.Errorf("expected 3 pieces, got %d", len(pieces))
+	}
+}
+
+func TestPDFHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(buildTestPDF())
+	}))
+	defer srv.Close()
+
+	h := newPDFHandler()
+	h.client = srv.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &nostr.Event{Content: srv.URL + "/paper.pdf", Tags: nostr.Tags{{"param", "chunk_size", "200"}}}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.Handle(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text PDFText
+	if err := json.Unmarshal(result, &text); err != nil {
+		t.Fatal(err)
+	}
+	if text.Pages != 2 || text.WordCount != 8 || len(text.Chunks) != 1 || text.Chunks[0].LastPage != 2 {
+		t.Errorf("unexpected result: %+v", text)
+	}
+
+	if err := h.Validate(&nostr.Event{Content: srv.URL, Tags: nostr.Tags{{"param", "chunk_size", "10"}}}); err == nil {
+		t.Error("expected a tiny chunk_size to be rejected")
+	}
+}
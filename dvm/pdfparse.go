@@ -0,0 +1,765 @@
+package dvm
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// A small PDF reader: just enough of the format (PDF 32000-1) to walk the
+// page tree and pull text out of content streams. It handles classic and
+// compressed object storage, Flate/ASCIIHex/ASCII85 streams and ToUnicode
+// CMaps; it does not handle encryption, images or fancy layout.
+
+// maxPDFStreamBytes caps each decoded stream, guarding against zip bombs.
+const maxPDFStreamBytes = 64 << 20
+
+type (
+	pdfName    string
+	pdfDict    map[pdfName]any
+	pdfArray   []any
+	pdfString  []byte
+	pdfKeyword string
+	pdfRef     struct{ num, gen int }
+)
+
+// pdfObject is an indirect object, with its raw stream data if it has one.
+type pdfObject struct {
+	value  any
+	stream []byte
+}
+
+type pdfDocument struct {
+	objects map[int]*pdfObject
+}
+
+var pdfObjHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// parsePDF indexes every object in data. Objects are found by scanning for
+// "N G obj" rather than trusting the xref table, which is often damaged;
+// later definitions win, as with incremental updates.
+func parsePDF(data []byte) (*pdfDocument, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return nil, errors.New("encrypted PDFs are not supported")
+	}
+
+	doc := &pdfDocument{objects: make(map[int]*pdfObject)}
+	for _, m := range pdfObjHeader.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		lex := &pdfLexer{data: data, pos: m[1]}
+		value, err := lex.object()
+		if err != nil {
+			continue
+		}
+		obj := &pdfObject{value: value}
+		if dict, ok := value.(pdfDict); ok {
+			obj.stream = lex.streamData(dict)
+		}
+		doc.objects[num] = obj
+	}
+
+	// Objects packed into object streams (PDF 1.5+)
+	for _, obj := range doc.objectsOfType("ObjStm") {
+		doc.unpackObjectStream(obj)
+	}
+	return doc, nil
+}
+
+func (doc *pdfDocument) objectsOfType(typ pdfName) []*pdfObject {
+	var found []*pdfObject
+	for _, obj := range doc.objects {
+		if dict, ok := obj.value.(pdfDict); ok && dict["Type"] == typ {
+			found = append(found, obj)
+		}
+	}
+	return found
+}
+
+func (doc *pdfDocument) unpackObjectStream(obj *pdfObject) {
+	dict := obj.value.(pdfDict)
+	data, err := doc.decodeStream(obj)
+	if err != nil {
+		return
+	}
+	n, _ := doc.resolve(dict["N"]).(float64)
+	first, _ := doc.resolve(dict["First"]).(float64)
+
+	header := &pdfLexer{data: data}
+	for i := 0; i < int(n); i++ {
+		num, err1 := header.object()
+		offset, err2 := header.object()
+		numF, ok1 := num.(float64)
+		offsetF, ok2 := offset.(float64)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 {
+			return
+		}
+		pos := int(first) + int(offsetF)
+		if pos < 0 || pos >= len(data) {
+			continue
+		}
+		if _, exists := doc.objects[int(numF)]; exists {
+			continue
+		}
+		lex := &pdfLexer{data: data, pos: pos}
+		if value, err := lex.object(); err == nil {
+			doc.objects[int(numF)] = &pdfObject{value: value}
+		}
+	}
+}
+
+// resolve follows indirect references.
+func (doc *pdfDocument) resolve(v any) any {
+	for i := 0; i < 32; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		obj := doc.objects[ref.num]
+		if obj == nil {
+			return nil
+		}
+		v = obj.value
+	}
+	return nil
+}
+
+func (doc *pdfDocument) dict(v any) pdfDict {
+	d, _ := doc.resolve(v).(pdfDict)
+	return d
+}
+
+// decodeStream applies the stream's filters.
+func (doc *pdfDocument) decodeStream(obj *pdfObject) ([]byte, error) {
+	dict, _ := obj.value.(pdfDict)
+	data := obj.stream
+	if data == nil {
+		return nil, errors.New("object has no stream")
+	}
+
+	var filters []pdfName
+	switch f := doc.resolve(dict["Filter"]).(type) {
+	case pdfName:
+		filters = []pdfName{f}
+	case pdfArray:
+		for _, item := range f {
+			if name, ok := doc.resolve(item).(pdfName); ok {
+				filters = append(filters, name)
+			}
+		}
+	}
+
+	for _, filter := range filters {
+		var r io.Reader
+		switch filter {
+		case "FlateDecode", "Fl":
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			r = zr
+		case "ASCIIHexDecode", "AHx":
+			s := strings.Join(strings.Fields(strings.TrimSuffix(strings.TrimSpace(string(data)), ">")), "")
+			if len(s)%2 == 1 {
+				s += "0"
+			}
+			decoded, err := hex.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			r = bytes.NewReader(decoded)
+		case "ASCII85Decode", "A85":
+			trimmed := bytes.TrimSuffix(bytes.TrimSpace(data), []byte("~>"))
+			r = ascii85.NewDecoder(bytes.NewReader(bytes.TrimPrefix(trimmed, []byte("<~"))))
+		default:
+			return nil, fmt.Errorf("unsupported stream filter %s", filter)
+		}
+
+		decoded, err := io.ReadAll(io.LimitReader(r, maxPDFStreamBytes+1))
+		// Truncated Flate data is common; keep what decoded cleanly
+		if err != nil && len(decoded) == 0 {
+			return nil, err
+		}
+		if len(decoded) > maxPDFStreamBytes {
+			return nil, errors.New("stream too large")
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+// pdfPage is a leaf of the page tree with its inherited resources.
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages returns the document's pages in order.
+func (doc *pdfDocument) pages() []pdfPage {
+	var catalog pdfDict
+	for _, obj := range doc.objectsOfType("Catalog") {
+		catalog = obj.value.(pdfDict)
+	}
+	if catalog == nil {
+		return nil
+	}
+
+	var pages []pdfPage
+	seen := make(map[int]bool) // object numbers, to survive cyclic trees
+	var visit func(node pdfDict, resources pdfDict, depth int)
+	visit = func(node pdfDict, resources pdfDict, depth int) {
+		if node == nil || depth > 64 {
+			return
+		}
+		if r := doc.dict(node["Resources"]); r != nil {
+			resources = r
+		}
+		if node["Type"] == pdfName("Page") {
+			pages = append(pages, pdfPage{dict: node, resources: resources})
+			return
+		}
+		kids, _ := doc.resolve(node["Kids"]).(pdfArray)
+		for _, kid := range kids {
+			if ref, ok := kid.(pdfRef); ok {
+				if seen[ref.num] {
+					continue
+				}
+				seen[ref.num] = true
+			}
+			visit(doc.dict(kid), resources, depth+1)
+		}
+	}
+	visit(doc.dict(catalog["Pages"]), nil, 0)
+	return pages
+}
+
+// pageText extracts the text of one page.
+func (doc *pdfDocument) pageText(page pdfPage) string {
+	var content []byte
+	var refs []any
+	switch c := page.dict["Contents"].(type) {
+	case pdfArray:
+		refs = c
+	default:
+		if arr, ok := doc.resolve(c).(pdfArray); ok {
+			refs = arr
+		} else {
+			refs = []any{c}
+		}
+	}
+	for _, ref := range refs {
+		r, ok := ref.(pdfRef)
+		if !ok || doc.objects[r.num] == nil {
+			continue
+		}
+		if data, err := doc.decodeStream(doc.objects[r.num]); err == nil {
+			content = append(append(content, data...), '\n')
+		}
+	}
+
+	fonts := make(map[pdfName]*pdfCMap)
+	for name, ref := range doc.dict(page.resources["Font"]) {
+		font := doc.dict(ref)
+		if font == nil {
+			continue
+		}
+		if cmapRef, ok := font["ToUnicode"].(pdfRef); ok {
+			if obj := doc.objects[cmapRef.num]; obj != nil {
+				if data, err := doc.decodeStream(obj); err == nil {
+					fonts[name] = parseCMap(data)
+				}
+			}
+		}
+	}
+	return extractContentText(content, fonts)
+}
+
+// extractContentText interprets the text operators of a content stream.
+func extractContentText(content []byte, fonts map[pdfName]*pdfCMap) string {
+	var out strings.Builder
+	var operands []any
+	var cmap *pdfCMap
+	lastY, haveY := 0.0, false
+
+	newline := func() {
+		if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString("\n")
+		}
+	}
+	space := func() {
+		if s := out.String(); len(s) > 0 && !strings.HasSuffix(s, " ") && !strings.HasSuffix(s, "\n") {
+			out.WriteString(" ")
+		}
+	}
+	show := func(s pdfString) {
+		out.WriteString(cmap.decode(s))
+	}
+
+	lex := &pdfLexer{data: content}
+	for {
+		tok, err := lex.object()
+		if err != nil {
+			break
+		}
+		op, isOp := tok.(pdfKeyword)
+		if !isOp {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					cmap = fonts[name]
+				}
+			}
+		case "Tj":
+			if len(operands) > 0 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					show(s)
+				}
+			}
+		case "'", "\"":
+			newline()
+			if len(operands) > 0 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					show(s)
+				}
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				arr, _ := operands[len(operands)-1].(pdfArray)
+				for _, item := range arr {
+					switch v := item.(type) {
+					case pdfString:
+						show(v)
+					case float64:
+						// Large negative kerning is how many PDFs draw spaces
+						if v < -200 {
+							space()
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, _ := operands[1].(float64); ty != 0 {
+					newline()
+				} else {
+					space()
+				}
+			}
+		case "T*":
+			newline()
+		case "Tm":
+			if len(operands) >= 6 {
+				y, _ := operands[5].(float64)
+				if haveY && y != lastY {
+					newline()
+				} else {
+					space()
+				}
+				lastY, haveY = y, true
+			}
+		case "ET":
+			space()
+		case "ID":
+			lex.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+	return out.String()
+}
+
+// pdfCMap maps character codes to Unicode text, from a ToUnicode CMap.
+type pdfCMap struct {
+	codeLen int
+	chars   map[uint32]string
+}
+
+// parseCMap reads the bfchar and bfrange sections of a ToUnicode CMap.
+func parseCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{chars: make(map[uint32]string)}
+	lex := &pdfLexer{data: data}
+	var operands []any
+
+	code := func(s pdfString) uint32 {
+		if cmap.codeLen == 0 {
+			cmap.codeLen = len(s)
+		}
+		var c uint32
+		for _, b := range s {
+			c = c<<8 | uint32(b)
+		}
+		return c
+	}
+
+	for {
+		tok, err := lex.object()
+		if err != nil {
+			break
+		}
+		kw, ok := tok.(pdfKeyword)
+		if !ok {
+			operands = append(operands, tok)
+			continue
+		}
+		switch kw {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					cmap.chars[code(src)] = utf16BE(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 {
+					continue
+				}
+				start, end := code(lo), code(hi)
+				if end < start || end-start > 0xffff {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					base := []rune(utf16BE(dst))
+					if len(base) == 0 {
+						continue
+					}
+					for c := start; c <= end; c++ {
+						r := append([]rune(nil), base...)
+						r[len(r)-1] += rune(c - start)
+						cmap.chars[c] = string(r)
+					}
+				case pdfArray:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok && start+uint32(j) <= end {
+							cmap.chars[start+uint32(j)] = utf16BE(s)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	if cmap.codeLen == 0 {
+		cmap.codeLen = 1
+	}
+	return cmap
+}
+
+// decode converts a shown string to text. Without a CMap, bytes are taken
+// as Latin-1, which is right for the standard fonts' common characters.
+func (c *pdfCMap) decode(s pdfString) string {
+	if c == nil {
+		if bytes.HasPrefix(s, []byte{0xfe, 0xff}) {
+			return utf16BE(s[2:])
+		}
+		runes := make([]rune, len(s))
+		for i, b := range s {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+
+	var b strings.Builder
+	for i := 0; i+c.codeLen <= len(s); i += c.codeLen {
+		var code uint32
+		for _, x := range s[i : i+c.codeLen] {
+			code = code<<8 | uint32(x)
+		}
+		b.WriteString(c.chars[code])
+	}
+	return b.String()
+}
+
+func utf16BE(s []byte) string {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfLexer reads PDF objects and content-stream operators.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+var errPDFEOF = errors.New("unexpected end of PDF data")
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFWhitespace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// object reads the next object, or keyword in a content stream.
+func (l *pdfLexer) object() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errPDFEOF
+	}
+
+	switch c := l.data[l.pos]; {
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		dict := make(pdfDict)
+		for {
+			l.skipSpace()
+			if l.pos+1 < len(l.data) && l.data[l.pos] == '>' && l.data[l.pos+1] == '>' {
+				l.pos += 2
+				return dict, nil
+			}
+			key, err := l.object()
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(pdfName)
+			if !ok {
+				return nil, fmt.Errorf("dictionary key is not a name")
+			}
+			value, err := l.object()
+			if err != nil {
+				return nil, err
+			}
+			dict[name] = value
+		}
+	case c == '<':
+		end := bytes.IndexByte(l.data[l.pos:], '>')
+		if end < 0 {
+			return nil, errPDFEOF
+		}
+		s := strings.Join(strings.Fields(string(l.data[l.pos+1:l.pos+end])), "")
+		l.pos += end + 1
+		if len(s)%2 == 1 {
+			s += "0"
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return pdfString(b), nil
+	case c == '[':
+		l.pos++
+		var arr pdfArray
+		for {
+			l.skipSpace()
+			if l.pos < len(l.data) && l.data[l.pos] == ']' {
+				l.pos++
+				return arr, nil
+			}
+			v, err := l.object()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	case c == '(':
+		return l.literalString()
+	case c == '/':
+		l.pos++
+		start := l.pos
+		for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+			l.pos++
+		}
+		return pdfName(unescapePDFName(string(l.data[start:l.pos]))), nil
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		// Stray delimiter; skip it rather than stall
+		l.pos++
+		return pdfKeyword(string(c)), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(word, 64)
+	if err != nil {
+		return pdfKeyword(word), nil
+	}
+
+	// An integer may start an indirect reference: "12 0 R"
+	save := l.pos
+	if gen, ok := l.integer(); ok {
+		l.skipSpace()
+		if l.pos < len(l.data) && l.data[l.pos] == 'R' &&
+			(l.pos+1 == len(l.data) || isPDFWhitespace(l.data[l.pos+1]) || isPDFDelimiter(l.data[l.pos+1])) {
+			l.pos++
+			return pdfRef{num: int(n), gen: gen}, nil
+		}
+	}
+	l.pos = save
+	return n, nil
+}
+
+func (l *pdfLexer) integer() (int, bool) {
+	l.skipSpace()
+	start := l.pos
+	for l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '9' {
+		l.pos++
+	}
+	if start == l.pos {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(l.data[start:l.pos]))
+	return n, err == nil
+}
+
+func (l *pdfLexer) literalString() (pdfString, error) {
+	l.pos++ // opening paren
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(out), nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return nil, errPDFEOF
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation
+				if e == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return nil, errPDFEOF
+}
+
+func unescapePDFName(s string) string {
+	if !strings.Contains(s, "#") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// streamData returns the raw bytes of the stream following dict, if any.
+func (l *pdfLexer) streamData(dict pdfDict) []byte {
+	l.skipSpace()
+	if !bytes.HasPrefix(l.data[l.pos:], []byte("stream")) {
+		return nil
+	}
+	start := l.pos + len("stream")
+	if start < len(l.data) && l.data[start] == '\r' {
+		start++
+	}
+	if start < len(l.data) && l.data[start] == '\n' {
+		start++
+	}
+
+	if length, ok := dict["Length"].(float64); ok {
+		end := start + int(length)
+		if end <= len(l.data) && bytes.HasPrefix(bytes.TrimLeft(l.data[end:], "\r\n \t"), []byte("endstream")) {
+			return l.data[start:end]
+		}
+	}
+	// Indirect or wrong /Length: fall back to searching for endstream
+	end := bytes.Index(l.data[start:], []byte("endstream"))
+	if end < 0 {
+		return nil
+	}
+	return bytes.TrimRight(l.data[start:start+end], "\r\n")
+}
+
+// skipInlineImage skips the binary data of an inline image (BI ... ID
+// <data> EI) so it isn't lexed as operators.
+func (l *pdfLexer) skipInlineImage() {
+	for i := l.pos; i+2 < len(l.data); i++ {
+		if l.data[i] == 'E' && l.data[i+1] == 'I' && isPDFWhitespace(l.data[i-1]) &&
+			(i+2 == len(l.data) || isPDFWhitespace(l.data[i+2])) {
+			l.pos = i + 2
+			return
+		}
+	}
+	l.pos = len(l.data)
+}
@@ -0,0 +1,130 @@
+package dvm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Payer pays DVMs that ask for payment before running a job, say by zapping
+// the job request from the caller's wallet. bolt11 is the invoice the DVM
+// sent, if any; otherwise it expects a zap of req.
+type Payer interface {
+	Pay(ctx context.Context, dvmPubKey string, req *nostr.Event, msats int64, bolt11 string) error
+}
+
+// PipelineStep is one job of a Pipeline.
+type PipelineStep struct {
+	DVM  string     // hex pubkey of the DVM to run the job
+	Kind int        // job request kind
+	Tags nostr.Tags // extra request tags, such as params
+	// MaxMsats is the most the step may be paid. A DVM asking for more
+	// fails the pipeline; zero pays nothing.
+	MaxMsats int64
+}
+
+// Pipeline runs jobs in sequence on one or more DVMs, each taking the
+// previous job's result as its input, e.g. fetching a thread and then
+// summarizing it elsewhere.
+type Pipeline struct {
+	Steps []PipelineStep
+	Payer Payer // pays steps that ask for it; nil fails them instead
+}
+
+// PipelineResult is the outcome of a pipeline that ran to the end.
+type PipelineResult struct {
+	Output string // the last step's result
+	Steps  []StepResult
+}
+
+// StepResult records how one step of a pipeline went.
+type StepResult struct {
+	RequestID string
+	Result    *nostr.Event // the result event that completed the job
+	PaidMsats int64
+}
+
+// RunPipeline runs p with input as the first step's input. Later steps
+// carry the result of the one before as their content, which is what
+// bandita's handlers read, and chain to its request with NIP-90's ["i",
+// <request id>, "job"] tag, for DVMs that take their input that way.
+func (c *DvmClient) RunPipeline(ctx context.Context, p Pipeline, input string) (*PipelineResult, error) {
+	if len(p.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline has no steps")
+	}
+	var res PipelineResult
+	var prev string // request ID of the step before
+	for i, step := range p.Steps {
+		opts := requestOptions{tags: append(nostr.Tags(nil), step.Tags...)}
+		if prev != "" {
+			opts.tags = append(opts.tags, nostr.Tag{"i", prev, "job"})
+		}
+		var paid int64
+		opts.pay = step.pay(p.Payer, &paid)
+
+		output, result, err := c.request(ctx, step.DVM, step.Kind, input, opts)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline step %d (kind %d): %w", i+1, step.Kind, err)
+		}
+		prev = tagValue(result.Tags, "e")
+		res.Steps = append(res.Steps, StepResult{RequestID: prev, Result: result, PaidMsats: paid})
+		input = output
+	}
+	res.Output = input
+	return &res, nil
+}
+
+// pay returns a requestOptions.pay that pays for the step with payer, up to
+// the step's MaxMsats, adding what it pays to *paid.
+func (step PipelineStep) pay(payer Payer, paid *int64) func(ctx context.Context, req *nostr.Event, msats int64, bolt11 string) error {
+	return func(ctx context.Context, req *nostr.Event, msats int64, bolt11 string) error {
+		if payer == nil {
+			return fmt.Errorf("DVM asked for %d msats and there's no payer", msats)
+		}
+		if msats+*paid > step.MaxMsats {
+			return fmt.Errorf("DVM asked for %d msats, over the step's limit of %d", msats, step.MaxMsats)
+		}
+		log.Printf("Paying %d msats for a kind %d job", msats, step.Kind)
+		if err := payer.Pay(ctx, step.DVM, req, msats, bolt11); err != nil {
+			return fmt.Errorf("payment failed: %w", err)
+		}
+		*paid += msats
+		return nil
+	}
+}
+
+// requestOptions adjust a single job request made by DvmClient.
+type requestOptions struct {
+	tags nostr.Tags // added to the request
+	// pay is called when the DVM asks for payment; without it, the
+	// client keeps waiting in case someone else pays.
+	pay func(ctx context.Context, req *nostr.Event, msats int64, bolt11 string) error
+	// page is called with each page of a paged result, in order; with it,
+	// the request also waits for every page before returning the final
+	// result. Without it, pages are ignored.
+	page func(n int, content string)
+}
+
+// paymentRequest returns the msats and invoice asked for by
+// payment-required feedback, or false for any other status.
+func paymentRequest(fb *nostr.Event) (int64, string, bool) {
+	if fb.Tags.GetFirst([]string{"status", StatusPaymentRequired}) == nil {
+		return 0, "", false
+	}
+	amount := fb.Tags.GetFirst([]string{"amount"})
+	if amount == nil || len(*amount) < 2 {
+		return 0, "", false
+	}
+	msats, err := strconv.ParseInt((*amount)[1], 10, 64)
+	if err != nil || msats <= 0 {
+		return 0, "", false
+	}
+	var bolt11 string
+	if len(*amount) > 2 {
+		bolt11 = (*amount)[2]
+	}
+	return msats, bolt11, true
+}
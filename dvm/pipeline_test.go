@@ -0,0 +1,83 @@
+package dvm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// zapPayer pays by publishing zap receipts from a fake zapper.
+type zapPayer struct {
+	relay    *relaytest.Server
+	zapperSK string
+	paid     int64
+}
+
+func (z *zapPayer) Pay(ctx context.Context, dvmPubKey string, req *nostr.Event, msats int64, bolt11 string) error {
+	if msats != 3000 {
+		return fmt.Errorf("test invoices are for 3000 msats, not %d", msats)
+	}
+	z.relay.Publish(newZapReceipt(z.zapperSK, req.ID, dvmPubKey, "lnbc30n1pvjluez"))
+	z.paid += msats
+	return nil
+}
+
+func TestRunPipeline(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	const kindEcho = 5998
+	zapperSK := testKey()
+	zapperPK, _ := nostr.GetPublicKey(zapperSK)
+	fetcher := startTestDvm(t, relay, WithScraper(&fakeScraper{}))
+	echo := startTestDvm(t, relay, WithHandler(kindEcho, echoHandler{}),
+		WithPayments(PaymentConfig{ZapperPubKey: zapperPK, Timeout: 5 * time.Second}),
+		WithPricing(PricingConfig{kindEcho: {Msats: 3000}}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payer := &zapPayer{relay: relay, zapperSK: zapperSK}
+	res, err := client.RunPipeline(ctx, Pipeline{
+		Steps: []PipelineStep{
+			{DVM: fetcher.GetPublicKey(), Kind: KindTweetRequest},
+			{DVM: echo.GetPublicKey(), Kind: kindEcho, MaxMsats: 5000},
+		},
+		Payer: payer,
+	}, "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(res.Output, "Running bitcoin") {
+		t.Errorf("expected the tweet to come through the pipeline, got %q", res.Output)
+	}
+	if len(res.Steps) != 2 || res.Steps[1].PaidMsats != 3000 || payer.paid != 3000 {
+		t.Errorf("unexpected steps %+v, paid %d", res.Steps, payer.paid)
+	}
+	// The second job chains to the first
+	for _, evt := range relay.Events() {
+		if evt.Kind == kindEcho && evt.Tags.GetFirst([]string{"i", res.Steps[0].RequestID, "job"}) == nil {
+			t.Errorf("second job isn't chained to the first: %v", evt.Tags)
+		}
+	}
+
+	// A step that costs more than allowed fails without paying
+	_, err = client.RunPipeline(ctx, Pipeline{
+		Steps: []PipelineStep{{DVM: echo.GetPublicKey(), Kind: kindEcho, MaxMsats: 1000}},
+		Payer: payer,
+	}, "hello")
+	if err == nil || !strings.Contains(err.Error(), "limit") || payer.paid != 3000 {
+		t.Errorf("expected the step's limit to be enforced, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package dvm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// PolicyConfig is an operator's content policy, applied to every result
+// before it's published. Results matching Block or Keywords are refused
+// with ReasonContentPolicy feedback; matches of Redact are replaced.
+type PolicyConfig struct {
+	Block       []string   // regular expressions
+	Keywords    []string   // whole words, matched case-insensitively
+	Redact      []string   // regular expressions
+	Replacement string     // replaces redacted text; default "[redacted]"
+	Classifier  Classifier // optional, consulted after the lists
+}
+
+// Classifier decides whether a result may be served, e.g. by calling a
+// moderation API. It sees the result after redaction.
+type Classifier interface {
+	// Classify returns why result must be refused, or "" to allow it. An
+	// error refuses the result too, so a failing classifier fails closed.
+	Classify(ctx context.Context, kind int, result []byte) (reason string, err error)
+}
+
+// ParsePolicyConfig reads a policy file with one rule per line:
+//
+//	block <regexp>
+//	keyword <word>
+//	redact <regexp>
+//	replacement <text>
+//
+// Blank lines and lines starting with # are ignored.
+func ParsePolicyConfig(r io.Reader) (PolicyConfig, error) {
+	var cfg PolicyConfig
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return cfg, fmt.Errorf("line %d: %s rule without a value", n, rule)
+		}
+		switch rule {
+		case "block":
+			cfg.Block = append(cfg.Block, value)
+		case "keyword":
+			cfg.Keywords = append(cfg.Keywords, value)
+		case "redact":
+			cfg.Redact = append(cfg.Redact, value)
+		case "replacement":
+			cfg.Replacement = value
+		default:
+			return cfg, fmt.Errorf("line %d: unknown rule %q", n, rule)
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// contentPolicy is a compiled PolicyConfig. A nil *contentPolicy allows
+// everything unchanged.
+type contentPolicy struct {
+	block       []*regexp.Regexp
+	redact      []*regexp.Regexp
+	replacement string
+	classifier  Classifier
+}
+
+func newContentPolicy(cfg PolicyConfig) (*contentPolicy, error) {
+	p := &contentPolicy{replacement: cfg.Replacement, classifier: cfg.Classifier}
+	if p.replacement == "" {
+		p.replacement = "[redacted]"
+	}
+	for _, expr := range cfg.Block {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block rule %q: %w", expr, err)
+		}
+		p.block = append(p.block, re)
+	}
+	for _, word := range cfg.Keywords {
+		p.block = append(p.block, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(word)+`\b`))
+	}
+	for _, expr := range cfg.Redact {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact rule %q: %w", expr, err)
+		}
+		p.redact = append(p.redact, re)
+	}
+	return p, nil
+}
+
+// apply checks a kind's result against the policy. It returns the result
+// to serve, redacted if need be, or a non-empty reason it must be refused.
+func (p *contentPolicy) apply(ctx context.Context, kind int, result []byte) ([]byte, string) {
+	if p == nil {
+		return result, ""
+	}
+
+	// Rules match the text of JSON string values rather than the raw JSON,
+	// so escaping can't hide a match and redaction can't break the syntax
+	dec := json.NewDecoder(bytes.NewReader(result))
+	dec.UseNumber()
+	var doc interface{}
+	isJSON := dec.Decode(&doc) == nil
+	if !isJSON {
+		doc = string(result)
+	}
+
+	var blocked *regexp.Regexp
+	redacted := false
+	doc = mapStrings(doc, func(s string) string {
+		for _, re := range p.block {
+			if blocked == nil && re.MatchString(s) {
+				blocked = re
+			}
+		}
+		for _, re := range p.redact {
+			if re.MatchString(s) {
+				s = re.ReplaceAllLiteralString(s, p.replacement)
+				redacted = true
+			}
+		}
+		return s
+	})
+	if blocked != nil {
+		return nil, fmt.Sprintf("matched %q", blocked.String())
+	}
+
+	if redacted {
+		if !isJSON {
+			result = []byte(doc.(string))
+		} else if out, err := json.Marshal(doc); err == nil {
+			result = out
+		} else {
+			return nil, fmt.Sprintf("can't re-encode redacted result: %v", err)
+		}
+	}
+
+	if p.classifier != nil {
+		reason, err := p.classifier.Classify(ctx, kind, result)
+		if err != nil {
+			log.Printf("Content classifier failed: %v", err)
+			return nil, "classifier unavailable"
+		}
+		if reason != "" {
+			return nil, reason
+		}
+	}
+	return result, ""
+}
+
+// mapStrings applies f to every string value in a decoded JSON document.
+func mapStrings(v interface{}, f func(string) string) interface{} {
+	switch v := v.(type) {
+	case string:
+		return f(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = mapStrings(v[i], f)
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = mapStrings(v[k], f)
+		}
+	}
+	return v
+}
@@ -0,0 +1,168 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type classifierFunc func(ctx context.Context, kind int, result []byte) (string, error)
+
+func (f classifierFunc) Classify(ctx context.Context, kind int, result []byte) (string, error) {
+	return f(ctx, kind, result)
+}
+
+func TestContentPolicy(t *testing.T) {
+	cfg, err := ParsePolicyConfig(strings.NewReader(`
+# house rules
+block   (?i)forbidden\s+thing
+keyword Scam
+redact  \b\d{3}-\d{3}-\d{4}\b
+replacement [removed]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newContentPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, blocked := range []string{
+		`{"Text":"a Forbidden  thing"}`,
+		`{"Text":"this is a scam!"}`,
+		`{"Replies":[{"Text":"SCAM"}]}`,
+		`{"Text":"\u0053cam"}`, // escaped in the JSON
+	} {
+		if _, reason := p.apply(ctx, KindTweetRequest, []byte(blocked)); reason == "" {
+			t.Errorf("expected %s to be refused", blocked)
+		}
+	}
+	if _, reason := p.apply(ctx, KindTweetRequest, []byte(`{"Text":"scampi for dinner"}`)); reason != "" {
+		t.Errorf("keywords should match whole words, got %q", reason)
+	}
+
+	out, reason := p.apply(ctx, KindTweetRequest, []byte(`{"Text":"call 555-123-4567","Likes":12345678901234567890}`))
+	if reason != "" {
+		t.Fatalf("unexpected refusal: %s", reason)
+	}
+	var tweet struct {
+		Text  string
+		Likes json.Number
+	}
+	if err := json.Unmarshal(out, &tweet); err != nil {
+		t.Fatalf("redacted result isn't JSON: %v", err)
+	}
+	if tweet.Text != "call [removed]" || tweet.Likes != "12345678901234567890" {
+		t.Errorf("unexpected redaction %s", out)
+	}
+
+	plain := []byte("not JSON")
+	if out, _ := p.apply(ctx, KindTweetRequest, plain); string(out) != "not JSON" {
+		t.Errorf("unmatched result changed to %s", out)
+	}
+
+	if _, err := ParsePolicyConfig(strings.NewReader("allow everything")); err == nil {
+		t.Error("expected an unknown rule to be rejected")
+	}
+	if _, err := newContentPolicy(PolicyConfig{Block: []string{"("}}); err == nil {
+		t.Error("expected an invalid regexp to be rejected")
+	}
+}
+
+func TestContentPolicyClassifier(t *testing.T) {
+	var seen string
+	p, _ := newContentPolicy(PolicyConfig{
+		Redact: []string{"secret"},
+		Classifier: classifierFunc(func(ctx context.Context, kind int, result []byte) (string, error) {
+			seen = string(result)
+			if strings.Contains(seen, "nsfw") {
+				return "adult content", nil
+			}
+			if strings.Contains(seen, "timeout") {
+				return "", errors.New("moderation API timed out")
+			}
+			return "", nil
+		}),
+	})
+	ctx := context.Background()
+
+	if _, reason := p.apply(ctx, KindTweetRequest, []byte(`"a secret"`)); reason != "" || seen != `"a [redacted]"` {
+		t.Errorf("classifier should allow and see the redacted result, got %q seeing %s", reason, seen)
+	}
+	if _, reason := p.apply(ctx, KindTweetRequest, []byte(`"nsfw"`)); reason != "adult content" {
+		t.Errorf("expected the classifier's reason, got %q", reason)
+	}
+	if _, reason := p.apply(ctx, KindTweetRequest, []byte(`"timeout"`)); reason == "" {
+		t.Error("a failing classifier should refuse the result")
+	}
+}
+
+func TestContentPolicyFeedback(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithPolicy(PolicyConfig{Keywords: []string{"bitcoin"}}))
+
+	req := newTestRequest("1")
+	d.handleRequest(req)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Kind != KindJobFeedback {
+		t.Fatalf("expected feedback, got kind %d", fb.Kind)
+	}
+	status := fb.Tags.GetFirst([]string{"status"})
+	if status == nil || len(*status) < 3 || (*status)[1] != StatusError || (*status)[2] != ReasonContentPolicy {
+		t.Errorf("unexpected status tag %v", status)
+	}
+	if strings.Contains(fb.Content, "bitcoin") {
+		t.Errorf("feedback shouldn't reveal the rule: %q", fb.Content)
+	}
+	for _, evt := range relay.Events() {
+		if evt.Kind == ResultKind(req.Kind) && evt.Tags.GetFirst([]string{"e", req.ID}) != nil {
+			t.Errorf("refused result was published: %s", evt.Content)
+		}
+	}
+}
+
+func TestContentPolicyRenderedOutput(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	// "iVBORw0KGgo" is how every base64 PNG starts, so only a second pass
+	// over the rendered card could match it
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithPolicy(PolicyConfig{Block: []string{"^666$", "iVBORw0KGgo"}, Redact: []string{"bitcoin"}}))
+	request := func(tweetID, output string) *nostr.Event {
+		req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindTweetRequest, Tags: nostr.Tags{{"output", output}}, Content: tweetID}
+		req.Sign(testKey())
+		d.handleRequest(req)
+		return awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	}
+
+	// Refused before rendering, with the policy's reason
+	fb := request("666", OutputMarkdown)
+	if fb.Tags.GetFirst([]string{"status", StatusError, ReasonContentPolicy}) == nil {
+		t.Errorf("expected content-policy feedback, got %v", fb.Tags)
+	}
+
+	resp := request("20", OutputPNG)
+	if resp.Kind != ResultKind(KindTweetRequest) || !strings.HasPrefix(resp.Content, "data:image/png;base64,iVBORw0KGgo") {
+		t.Fatalf("expected the card, got kind %d: %.40q", resp.Kind, resp.Content)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(resp.Content, "data:image/png;base64,"))
+	if _, err := png.Decode(bytes.NewReader(raw)); err != nil {
+		t.Errorf("expected the card intact: %v", err)
+	}
+	if resp = request("20", OutputText); strings.Contains(resp.Content, "bitcoin") || !strings.Contains(resp.Content, "[redacted]") {
+		t.Errorf("expected the text redacted, got %q", resp.Content)
+	}
+}
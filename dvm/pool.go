@@ -0,0 +1,399 @@
+package dvm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Demotion backoff after consecutive failures: 1s, 2s, 4s... capped at 5m.
+const (
+	relayBaseBackoff = time.Second
+	relayMaxBackoff  = 5 * time.Minute
+)
+
+// RelayHealth is a snapshot of one relay's standing in the pool.
+type RelayHealth struct {
+	URL             string
+	Connected       bool
+	Score           float64       // higher is healthier
+	ConnectFailures int           // failed dials
+	Drops           int           // connections lost after being established
+	PublishOK       int           // events the relay accepted
+	PublishFailed   int           // events the relay rejected or timed out on
+	Latency         time.Duration // moving average of successful publishes
+	DemotedUntil    time.Time     // zero unless the relay is backing off
+}
+
+// poolRelay is one relay in the pool along with its health record.
+type poolRelay struct {
+	url string
+
+	mu                  sync.Mutex
+	conn                *nostr.Relay // nil until connected, or after a drop
+	held                bool         // whether the pool holds a reference in relayConns
+	connectFailures     int
+	drops               int
+	publishOK           int
+	publishFailed       int
+	latency             time.Duration
+	consecutiveFailures int
+	demotedUntil        time.Time
+	unwatch             func() // stops the pool's relayWatcher, if any
+}
+
+// newPoolRelay returns the relay at url, watched by w if not nil. w is
+// registered before the relay is dialed so it sees the relay's first AUTH
+// challenge.
+func newPoolRelay(url string, w *relayWatcher) *poolRelay {
+	r := &poolRelay{url: url}
+	if w != nil {
+		r.unwatch = relayConns.watch(url, w)
+	}
+	return r
+}
+
+// connect returns a live connection to the relay, dialing if the previous
+// one dropped. A demoted relay is dialed only when force is set.
+func (r *poolRelay) connect(ctx context.Context, force bool) (*nostr.Relay, error) {
+	r.mu.Lock()
+	if r.conn != nil {
+		if r.conn.IsConnected() {
+			conn := r.conn
+			r.mu.Unlock()
+			return conn, nil
+		}
+		log.Printf("Relay %s dropped the connection", r.url)
+		r.conn = nil
+		r.drops++
+		r.failLocked()
+	}
+	if !force && time.Now().Before(r.demotedUntil) {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("relay %s is demoted until %s", r.url, r.demotedUntil.Format(time.TimeOnly))
+	}
+	held := r.held
+	r.mu.Unlock()
+
+	// Dial without holding the lock so a slow relay doesn't stall ranking.
+	// The connection is shared, so another holder may have redialed already
+	var conn *nostr.Relay
+	var err error
+	if held {
+		conn, err = relayConns.live(ctx, r.url)
+	} else {
+		conn, err = relayConns.acquire(ctx, r.url)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.connectFailures++
+		r.failLocked()
+		return nil, err
+	}
+	if !held {
+		if r.held {
+			// Another caller acquired it first
+			relayConns.release(r.url)
+		}
+		r.held = true
+	}
+	r.conn = conn
+	r.consecutiveFailures = 0
+	r.demotedUntil = time.Time{}
+	return conn, nil
+}
+
+// recordPublish updates the relay's publish statistics.
+func (r *poolRelay) recordPublish(latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.publishFailed++
+		r.failLocked()
+		return
+	}
+	r.publishOK++
+	r.consecutiveFailures = 0
+	r.demotedUntil = time.Time{}
+	if r.latency == 0 {
+		r.latency = latency
+	} else {
+		r.latency = (r.latency*4 + latency) / 5
+	}
+}
+
+// failLocked demotes the relay for a backoff that doubles with each
+// consecutive failure, so a dead relay isn't retried on every publish.
+func (r *poolRelay) failLocked() {
+	r.consecutiveFailures++
+	backoff := relayBaseBackoff << (r.consecutiveFailures - 1)
+	if backoff > relayMaxBackoff || backoff <= 0 {
+		backoff = relayMaxBackoff
+	}
+	r.demotedUntil = time.Now().Add(backoff)
+	log.Printf("Demoting relay %s for %v after %d consecutive failures", r.url, backoff, r.consecutiveFailures)
+}
+
+// demote backs off from the relay as though it had failed, so others are
+// preferred for a while.
+func (r *poolRelay) demote() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failLocked()
+}
+
+// health snapshots the relay's statistics and score.
+func (r *poolRelay) health() RelayHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Smoothed success rate, so a new relay starts at 0.5 rather than
+	// outranking one with a long good record
+	attempts := r.publishOK + r.publishFailed
+	score := float64(r.publishOK+1) / float64(attempts+2)
+	score /= 1 + 0.1*float64(r.drops+r.connectFailures)
+	score /= 1 + r.latency.Seconds()
+
+	return RelayHealth{
+		URL:             r.url,
+		Connected:       r.conn != nil && r.conn.IsConnected(),
+		Score:           score,
+		ConnectFailures: r.connectFailures,
+		Drops:           r.drops,
+		PublishOK:       r.publishOK,
+		PublishFailed:   r.publishFailed,
+		Latency:         r.latency,
+		DemotedUntil:    r.demotedUntil,
+	}
+}
+
+// relayPool ranks a set of relays by health so publishing and subscribing
+// prefer the ones that are working.
+type relayPool struct {
+	mu      sync.RWMutex // guards relays, which change with AddRelay and RemoveRelay
+	relays  []*poolRelay
+	watcher *relayWatcher // watches every relay in the pool, may be nil
+}
+
+// newRelayPool connects to each relay, succeeding if at least one answers.
+func newRelayPool(ctx context.Context, urls []string, w *relayWatcher) (*relayPool, error) {
+	p := &relayPool{watcher: w}
+	var lastErr error
+	connected := 0
+	for _, url := range urls {
+		r := newPoolRelay(url, w)
+		p.relays = append(p.relays, r)
+		if _, err := r.connect(ctx, true); err != nil {
+			log.Printf("Failed to connect to relay %s: %v", url, err)
+			lastErr = err
+			continue
+		}
+		connected++
+	}
+	if connected == 0 {
+		p.close()
+		return nil, fmt.Errorf("could not connect to any relay: %w", lastErr)
+	}
+	return p, nil
+}
+
+// close releases the pool's connections.
+func (p *relayPool) close() {
+	for _, r := range p.list() {
+		r.release()
+	}
+}
+
+// list returns the pool's relays in the order they were added.
+func (p *relayPool) list() []*poolRelay {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*poolRelay(nil), p.relays...)
+}
+
+// release gives up the relay's connection.
+func (r *poolRelay) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.unwatch != nil {
+		r.unwatch()
+	}
+	if r.held {
+		relayConns.release(r.url)
+		r.held, r.conn = false, nil
+	}
+}
+
+// ranked returns the relays ordered best first, with demoted relays last.
+func (p *relayPool) ranked() []*poolRelay {
+	type ranking struct {
+		relay  *poolRelay
+		health RelayHealth
+	}
+	now := time.Now()
+	relays := p.list()
+	rankings := make([]ranking, len(relays))
+	for i, r := range relays {
+		rankings[i] = ranking{r, r.health()}
+	}
+	sort.SliceStable(rankings, func(i, j int) bool {
+		di := now.Before(rankings[i].health.DemotedUntil)
+		dj := now.Before(rankings[j].health.DemotedUntil)
+		if di != dj {
+			return dj
+		}
+		return rankings[i].health.Score > rankings[j].health.Score
+	})
+
+	for i, r := range rankings {
+		relays[i] = r.relay
+	}
+	return relays
+}
+
+// best connects to the healthiest relay that will accept a connection,
+// falling back to demoted relays only when no healthy one is reachable.
+func (p *relayPool) best(ctx context.Context) (*nostr.Relay, error) {
+	var lastErr error
+	for _, force := range []bool{false, true} {
+		for _, r := range p.ranked() {
+			conn, err := r.connect(ctx, force)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// demote backs off from the pool's relay at url, if it has one.
+func (p *relayPool) demote(url string) {
+	for _, r := range p.list() {
+		if nostr.NormalizeURL(r.url) == nostr.NormalizeURL(url) {
+			r.demote()
+		}
+	}
+}
+
+// healthy returns the relays that are not currently demoted, best first. If
+// every relay is demoted it returns just the best of them so callers still
+// have something to try.
+func (p *relayPool) healthy() []*poolRelay {
+	ranked := p.ranked()
+	now := time.Now()
+	for i, r := range ranked {
+		if now.Before(r.health().DemotedUntil) {
+			if i == 0 {
+				return ranked[:1]
+			}
+			return ranked[:i]
+		}
+	}
+	return ranked
+}
+
+// refresh redials dropped relays whose demotion has expired.
+func (p *relayPool) refresh(ctx context.Context) {
+	for _, r := range p.list() {
+		if _, err := r.connect(ctx, false); err != nil {
+			log.Printf("Relay %s unavailable: %v", r.url, err)
+		}
+	}
+}
+
+// RelayHealth reports the health of each relay in the pool, best first.
+func (d *Dvm) RelayHealth() []RelayHealth {
+	ranked := d.pool.ranked()
+	health := make([]RelayHealth, len(ranked))
+	for i, r := range ranked {
+		health[i] = r.health()
+	}
+	return health
+}
+
+// relayQueryTimeout bounds each query made by queryRelays.
+const relayQueryTimeout = 10 * time.Second
+
+// foundEvent is an event returned by queryRelays, with where it was found.
+type foundEvent struct {
+	Event  *nostr.Event
+	Relays []string
+}
+
+// queryRelays runs filters against the pool's healthy relays, and
+// extraFilters against extra relays dialed just for the query, returning
+// the distinct events found by ID. Failing relays are logged and skipped.
+func (d *Dvm) queryRelays(ctx context.Context, filters []nostr.Filter, extra []string, extraFilters []nostr.Filter) map[string]*foundEvent {
+	var mu sync.Mutex
+	found := make(map[string]*foundEvent)
+	var wg sync.WaitGroup
+	query := func(url string, conn *nostr.Relay, filter nostr.Filter) {
+		defer wg.Done()
+		qctx, cancel := context.WithTimeout(ctx, relayQueryTimeout)
+		defer cancel()
+		events, err := conn.QuerySync(qctx, filter)
+		if err != nil {
+			log.Printf("Query on %s failed: %v", url, err)
+			return
+		}
+		events = verifiedEvents(events)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, evt := range events {
+			f, ok := found[evt.ID]
+			if !ok {
+				f = &foundEvent{Event: evt}
+				found[evt.ID] = f
+			}
+			f.Relays = appendUnique(f.Relays, url)
+		}
+	}
+
+	for _, r := range d.pool.healthy() {
+		conn, err := r.connect(ctx, false)
+		if err != nil {
+			continue
+		}
+		for _, filter := range filters {
+			wg.Add(1)
+			go query(r.url, conn, filter)
+		}
+	}
+	for _, url := range extra {
+		conn, err := relayConns.acquire(ctx, url)
+		if err != nil {
+			log.Printf("Query: can't connect to %s: %v", url, err)
+			continue
+		}
+		defer relayConns.release(url)
+		for _, filter := range extraFilters {
+			wg.Add(1)
+			go query(url, conn, filter)
+		}
+	}
+	wg.Wait()
+
+	for _, f := range found {
+		sort.Strings(f.Relays)
+	}
+	return found
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
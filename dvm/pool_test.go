@@ -0,0 +1,64 @@
+package dvm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+var errTest = errors.New("relay said no")
+
+func TestRelayPoolRanksFailingRelaysLast(t *testing.T) {
+	good := &poolRelay{url: "wss://good"}
+	flaky := &poolRelay{url: "wss://flaky"}
+	dead := &poolRelay{url: "wss://dead"}
+	pool := &relayPool{relays: []*poolRelay{dead, flaky, good}}
+
+	for i := 0; i < 10; i++ {
+		good.recordPublish(50*time.Millisecond, nil)
+	}
+	flaky.recordPublish(50*time.Millisecond, nil)
+	flaky.recordPublish(time.Second, nil)
+	dead.recordPublish(0, errTest)
+
+	ranked := pool.ranked()
+	if ranked[0] != good || ranked[1] != flaky || ranked[2] != dead {
+		t.Errorf("unexpected ranking: %s, %s, %s", ranked[0].url, ranked[1].url, ranked[2].url)
+	}
+	if healthy := pool.healthy(); len(healthy) != 2 {
+		t.Errorf("expected the demoted relay to be skipped, got %d healthy", len(healthy))
+	}
+
+	// Backoff doubles with each consecutive failure
+	dead.recordPublish(0, errTest)
+	if backoff := time.Until(dead.health().DemotedUntil); backoff < relayBaseBackoff {
+		t.Errorf("expected backoff to grow past %v, got %v", relayBaseBackoff, backoff)
+	}
+}
+
+func TestDvmFailsOverWhenRelayDies(t *testing.T) {
+	primary := relaytest.NewServer()
+	defer primary.Close()
+	backup := relaytest.NewServer()
+	defer backup.Close()
+
+	d := startTestDvm(t, primary, WithScraper(&fakeScraper{}), WithRelays(backup.URL()))
+	requestTestTweet(t, primary, d, "1110302988")
+
+	primary.Close()
+	time.Sleep(time.Second)
+
+	req := newTestRequest("1110302989")
+	backup.Publish(req)
+	awaitResponse(t, backup, d.GetPublicKey(), req.ID)
+
+	health := d.RelayHealth()
+	if health[0].URL != backup.URL() {
+		t.Errorf("expected %s to rank first, got %s", backup.URL(), health[0].URL)
+	}
+	if health[1].Drops == 0 {
+		t.Errorf("expected the dead relay to have recorded a drop")
+	}
+}
@@ -0,0 +1,35 @@
+package dvm
+
+import "time"
+
+// Post is the normalized form of a social media post returned by non-Twitter
+// job handlers, so clients can treat every platform alike.
+type Post struct {
+	Platform  string      `json:"platform"` // e.g. "mastodon", "reddit"
+	ID        string      `json:"id"`
+	URL       string      `json:"url"`
+	Author    PostAuthor  `json:"author"`
+	Title     string      `json:"title,omitempty"`
+	Text      string      `json:"text"`
+	HTML      string      `json:"html,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	Media     []PostMedia `json:"media,omitempty"`
+	Replies   int         `json:"replies"`
+	Reposts   int         `json:"reposts"`
+	Likes     int         `json:"likes"`
+	Comments  []Post      `json:"comments,omitempty"`
+}
+
+// PostAuthor identifies who wrote a Post.
+type PostAuthor struct {
+	Name   string `json:"name,omitempty"` // display name
+	Handle string `json:"handle"`         // e.g. "alice@mastodon.social"
+	URL    string `json:"url,omitempty"`
+}
+
+// PostMedia is an attachment on a Post.
+type PostMedia struct {
+	Type        string `json:"type"` // "image", "video", "gifv" or "audio"
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
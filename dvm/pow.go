@@ -0,0 +1,56 @@
+package dvm
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// maxPoW is the most proof of work a DVM may require, the bits of an ID.
+const maxPoW = 256
+
+// powGenerateTimeout bounds how long a client mines a request.
+const powGenerateTimeout = time.Minute
+
+// requestPoW is the NIP-13 proof of work a request carries: the leading
+// zero bits of its ID, but no more than the target its nonce tag commits
+// to, so an ID that came out lucky counts only for the work that was meant.
+func requestPoW(evt *nostr.Event) int {
+	difficulty := nip13.Difficulty(evt.ID)
+	if nonce := evt.Tags.GetFirst([]string{"nonce"}); nonce != nil && len(*nonce) >= 3 {
+		if target, err := strconv.Atoi((*nonce)[2]); err == nil && target < difficulty {
+			difficulty = target
+		}
+	}
+	return difficulty
+}
+
+// rejectedPoW sends feedback turning away a request with less proof of work
+// than the DVM requires, reporting whether it did.
+func (d *Dvm) rejectedPoW(id *identity, evt *nostr.Event) bool {
+	if d.minPoW <= 0 {
+		return false
+	}
+	pow := requestPoW(evt)
+	if pow >= d.minPoW {
+		return false
+	}
+	metricJobsRejectedPoW.Add(1)
+	d.strike(evt, strikeSpam, "short of the proof of work required")
+	d.logs.printf("Rejecting request %s: %d bits of proof of work, %d required", evt.ID[:8], pow, d.minPoW)
+	d.publishFeedback(id, evt, StatusError, ReasonPoWRequired,
+		fmt.Sprintf("This DVM requires NIP-13 proof of work of at least %d bits on requests, this one has %d", d.minPoW, pow),
+		nostr.Tag{"pow", strconv.Itoa(d.minPoW)})
+	return true
+}
+
+// WithRequestPoW has the client mine its requests to difficulty bits of
+// NIP-13 proof of work, for DVMs that require it.
+func WithRequestPoW(difficulty int) ClientOption {
+	return func(c *DvmClient) {
+		c.pow = difficulty
+	}
+}
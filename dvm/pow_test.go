@@ -0,0 +1,56 @@
+package dvm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+func TestRequestPoW(t *testing.T) {
+	evt := &nostr.Event{ID: "000fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"}
+	if got := requestPoW(evt); got != 12 {
+		t.Errorf("expected 12 bits, got %d", got)
+	}
+	// Luck beyond the committed target doesn't count
+	evt.Tags = nostr.Tags{{"nonce", "1", "8"}}
+	if got := requestPoW(evt); got != 8 {
+		t.Errorf("expected the committed 8 bits, got %d", got)
+	}
+}
+
+func TestRequirePoW(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithProofOfWork(8))
+
+	req := newTestRequest("20")
+	for nip13.Difficulty(req.ID) >= 8 {
+		req = newTestRequest("20")
+	}
+	relay.Publish(req)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Tags.GetFirst([]string{"status", StatusError, ReasonPoWRequired}) == nil ||
+		fb.Tags.GetFirst([]string{"pow", "8"}) == nil {
+		t.Errorf("expected pow-required feedback, got %v", fb.Tags)
+	}
+
+	// A client mining its requests gets its result
+	client, err := NewDvmClient(relay.URL(), WithRequestPoW(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.RequestTweet(ctx, d.GetPublicKey(), "20"); err != nil {
+		t.Errorf("expected a mined request to be served: %v", err)
+	}
+
+	if caps := d.capabilities(); caps.PoW != 8 {
+		t.Errorf("expected the capabilities to advertise 8 bits, got %d", caps.PoW)
+	}
+}
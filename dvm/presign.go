@@ -0,0 +1,83 @@
+package dvm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Bounds on the result templates kept.
+const (
+	resultTemplateEntries  = 512
+	maxResultTemplateBytes = 16 << 10 // of content; bigger results are rarely repeated
+)
+
+// resultTemplates keeps what the result events for recent results have in
+// common, so a result served again, typically a cache hit, costs little
+// more than the signature. A signed result can't itself be reused for
+// another request, since its ID and signature cover the e and p tags tying
+// it to its request. What is reused is the NIP-01 serialization of its
+// content, the bulk of the event, leaving only the header and the tags,
+// with the request-specific ones, to serialize again before signing.
+type resultTemplates struct {
+	mu      sync.Mutex
+	escaped *lru[[]byte] // serialized content, by content
+}
+
+func newResultTemplates() *resultTemplates {
+	return &resultTemplates{escaped: newLRU[[]byte](resultTemplateEntries)}
+}
+
+// serialize returns evt's NIP-01 serialization, the preimage of its ID,
+// reusing the serialization of its content if it was seen before. A nil
+// *resultTemplates serializes from scratch.
+func (t *resultTemplates) serialize(evt *nostr.Event) []byte {
+	if t == nil || len(evt.Content) > maxResultTemplateBytes {
+		return evt.Serialize()
+	}
+	// Serialized with empty content, the event ends `,""]`; the content
+	// goes between the comma and the bracket
+	head := (&nostr.Event{PubKey: evt.PubKey, CreatedAt: evt.CreatedAt, Kind: evt.Kind, Tags: evt.Tags}).Serialize()
+	head = head[:len(head)-len(`""]`)]
+
+	t.mu.Lock()
+	escaped, ok := t.escaped.get(evt.Content)
+	t.mu.Unlock()
+	if ok {
+		metricResultTemplateHits.Add(1)
+		return append(append(head, escaped...), ']')
+	}
+
+	serialized := evt.Serialize()
+	escaped = append([]byte(nil), serialized[len(head):len(serialized)-1]...)
+	t.mu.Lock()
+	t.escaped.put(evt.Content, escaped)
+	t.mu.Unlock()
+	return serialized
+}
+
+// signResult signs a result event as id. Results sent as DMs are
+// encrypted afresh each time, so have nothing to reuse.
+func (d *Dvm) signResult(id *identity, evt *nostr.Event) error {
+	if evt.Tags == nil {
+		evt.Tags = nostr.Tags{}
+	}
+	evt.PubKey = id.pk
+	var serialized []byte
+	if evt.Kind == 4 {
+		serialized = evt.Serialize()
+	} else {
+		serialized = d.templates.serialize(evt)
+	}
+	hash := sha256.Sum256(serialized)
+	sig, err := schnorr.Sign(id.key, hash[:])
+	if err != nil {
+		return err
+	}
+	evt.ID = hex.EncodeToString(hash[:])
+	evt.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
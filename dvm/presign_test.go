@@ -0,0 +1,93 @@
+package dvm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSignResultReusesTemplate(t *testing.T) {
+	d := &Dvm{resultMode: ResultsAsJobResults, templates: newResultTemplates()}
+	id, err := newIdentity(primaryIdentity, testKey(), QuotaConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Content needing every kind of escaping
+	content := []byte("{\"text\":\"line\\nbreak <b>&amp;</b> \\\"quoted\\\" é 🚀\"}\t\x01")
+
+	hits := metricResultTemplateHits.Value()
+	for i, req := range []*nostr.Event{newTestRequest("20"), newTestRequest("20")} {
+		resp, err := d.resultEvent(id, req, content, nostr.Tags{{"amount", "1000"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.signResult(id, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := resp.CheckSignature(); !ok || resp.ID != resp.GetID() {
+			t.Fatalf("result %d doesn't verify: %+v", i, resp)
+		}
+		if resp.PubKey != id.pk || resp.Tags.GetFirst([]string{"e", req.ID}) == nil {
+			t.Errorf("result %d isn't tied to its request: %+v", i, resp)
+		}
+	}
+	if metricResultTemplateHits.Value() != hits+1 {
+		t.Error("expected the second result to reuse the first's template")
+	}
+}
+
+func TestCacheHitsPublishValidResults(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithCache(1<<20))
+
+	for i := 0; i < 3; i++ {
+		req := newTestRequest("20")
+		relay.Publish(req)
+		resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+		if ok, _ := resp.CheckSignature(); !ok {
+			t.Fatalf("result %d has a bad signature", i)
+		}
+	}
+	if scraper.Calls() != 1 {
+		t.Errorf("expected the later requests served from cache, got %d scrapes", scraper.Calls())
+	}
+}
+
+// BenchmarkSignResult compares signing a cache hit's result with go-nostr
+// and with the result templates.
+func BenchmarkSignResult(b *testing.B) {
+	d := &Dvm{resultMode: ResultsAsJobResults, templates: newResultTemplates()}
+	id, err := newIdentity(primaryIdentity, testKey(), QuotaConfig{}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	content, err := json.Marshal(TweetResult{Tweet: benchTweet()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	resp, err := d.resultEvent(id, newTestRequest("1110302988"), content, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Sign", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := resp.Sign(id.sk); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("signResult", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := d.signResult(id, &resp); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
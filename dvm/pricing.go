@@ -0,0 +1,113 @@
+package dvm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindPrice is what an operator charges for a job kind, paid up front: a
+// flat price per request plus, for batch and timeline jobs, a price for
+// each unit the request asks for, such as each tweet of an archive.
+type KindPrice struct {
+	Msats        int64
+	PerUnitMsats int64
+}
+
+func (p KindPrice) charges() bool {
+	return p.Msats > 0 || p.PerUnitMsats > 0
+}
+
+// PricingConfig maps job request kinds to their prices. Kinds without a
+// price are free, unless they bill by usage, like LLM jobs.
+type PricingConfig map[int]KindPrice
+
+// ParsePricingConfig reads a pricing file with one kind per line:
+//
+//	<kind> <msats per request> [<msats per unit>]
+//
+// where kind is a request kind number or a built-in kind's name, such as
+// "tweet" or "user_archive". Blank lines and lines starting with # are
+// ignored.
+func ParsePricingConfig(r io.Reader) (PricingConfig, error) {
+	cfg := make(PricingConfig)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected <kind> <msats> [<msats per unit>]", n)
+		}
+		kind, err := parseKind(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if _, dup := cfg[kind]; dup {
+			return nil, fmt.Errorf("line %d: kind %d is priced twice", n, kind)
+		}
+		var price KindPrice
+		if price.Msats, err = strconv.ParseInt(fields[1], 10, 64); err != nil || price.Msats < 0 {
+			return nil, fmt.Errorf("line %d: price must be a non-negative number of msats", n)
+		}
+		if len(fields) == 3 {
+			if price.PerUnitMsats, err = strconv.ParseInt(fields[2], 10, 64); err != nil || price.PerUnitMsats < 0 {
+				return nil, fmt.Errorf("line %d: unit price must be a non-negative number of msats", n)
+			}
+		}
+		cfg[kind] = price
+	}
+	return cfg, scanner.Err()
+}
+
+// parseKind reads a request kind by number or by built-in name.
+func parseKind(s string) (int, error) {
+	if kind, err := strconv.Atoi(s); err == nil {
+		return kind, nil
+	}
+	for kind, c := range builtinCapabilities {
+		if c.Name == s {
+			return kind, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown kind %q", s)
+}
+
+// ParseKinds reads a comma-separated list of request kinds, each by number
+// or by built-in name such as "user_archive".
+func ParseKinds(s string) ([]int, error) {
+	var kinds []int
+	for _, field := range strings.Split(s, ",") {
+		kind, err := parseKind(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// batchHandler is implemented by handlers whose requests ask for a number
+// of items, so they can be priced per unit.
+type batchHandler interface {
+	// Units returns how many units the request asks for.
+	Units(req *nostr.Event) int64
+	// Unit names what's counted, such as "tweet".
+	Unit() string
+}
+
+// jobPrice returns the msats to be paid up front for req.
+func (d *Dvm) jobPrice(handler JobHandler, req *nostr.Event) int64 {
+	price := d.pricing[req.Kind]
+	msats := price.Msats
+	if batch, ok := handler.(batchHandler); ok && price.PerUnitMsats > 0 {
+		msats += price.PerUnitMsats * batch.Units(req)
+	}
+	return msats
+}
@@ -0,0 +1,88 @@
+package dvm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParsePricingConfig(t *testing.T) {
+	cfg, err := ParsePricingConfig(strings.NewReader(`
+# flat price per tweet
+tweet 1000
+42084 0 5
+user_archive 2000 10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PricingConfig{
+		KindTweetRequest:       {Msats: 1000},
+		KindFollowsRequest:     {PerUnitMsats: 5},
+		KindUserArchiveRequest: {Msats: 2000, PerUnitMsats: 10},
+	}
+	if len(cfg) != len(want) {
+		t.Fatalf("got %+v, want %+v", cfg, want)
+	}
+	for kind, price := range want {
+		if cfg[kind] != price {
+			t.Errorf("kind %d: got %+v, want %+v", kind, cfg[kind], price)
+		}
+	}
+
+	for _, bad := range []string{"tweet", "tweet lots", "tweet -1", "tweet 1 2 3", "bogus 1", "tweet 1\n42069 2"} {
+		if _, err := ParsePricingConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestJobPrice(t *testing.T) {
+	d := &Dvm{pricing: PricingConfig{
+		KindTweetRequest:   {Msats: 1000, PerUnitMsats: 7}, // tweets have no units
+		KindFollowsRequest: {Msats: 100, PerUnitMsats: 5},
+	}}
+	tweet := &nostr.Event{Kind: KindTweetRequest, Content: "20"}
+	if got := d.jobPrice(tweetHandler{d: d}, tweet); got != 1000 {
+		t.Errorf("tweet price = %d, want 1000", got)
+	}
+	follows := &nostr.Event{Kind: KindFollowsRequest, Content: "halfin", Tags: nostr.Tags{{"param", "max", "30"}}}
+	if got := d.jobPrice(&followsHandler{}, follows); got != 100+5*30 {
+		t.Errorf("follows price = %d, want %d", got, 100+5*30)
+	}
+	unpriced := &nostr.Event{Kind: KindMirrorsRequest, Content: "20"}
+	if got := d.jobPrice(&mirrorsHandler{d: d}, unpriced); got != 0 {
+		t.Errorf("unpriced kind costs %d", got)
+	}
+}
+
+func TestPricedKindAsksForPayment(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	if _, err := NewDvm(relay.URL(), testKey(), WithPricing(PricingConfig{KindTweetRequest: {Msats: 3000}})); err == nil {
+		t.Error("expected pricing without payments to be rejected")
+	}
+
+	zapperPK, _ := nostr.GetPublicKey(testKey())
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithPayments(PaymentConfig{ZapperPubKey: zapperPK, Timeout: time.Second}),
+		WithPricing(PricingConfig{KindTweetRequest: {Msats: 3000}}))
+
+	req := newTestRequest("20")
+	relay.Publish(req)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Kind != KindJobFeedback || fb.Tags.GetFirst([]string{"status", StatusPaymentRequired}) == nil ||
+		fb.Tags.GetFirst([]string{"amount", "3000"}) == nil {
+		t.Errorf("expected payment-required feedback for 3000 msats, got %+v", fb)
+	}
+
+	caps := d.capabilities()
+	price := caps.Kind(KindTweetRequest).Price
+	if price == nil || price.Msats != 3000 || !price.Prepaid {
+		t.Errorf("expected the price to be advertised, got %+v", price)
+	}
+}
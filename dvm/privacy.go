@@ -0,0 +1,98 @@
+package dvm
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ephemeralJitter is the longest a client using ephemeral identities waits
+// before submitting a request. A var so tests can shorten it.
+var ephemeralJitter = 5 * time.Second
+
+// WithEphemeralIdentity makes the client harder to follow from one request
+// to the next: each request is signed by a fresh key, sent on a relay
+// connection of its own, and submitted after a random pause of up to a few
+// seconds, so neither keys, connections, subscriptions nor timing tie a
+// user's lookups together. Ratings are signed by the request's key too.
+// Resolve can't decrypt DM results of such requests, whose keys are gone.
+func WithEphemeralIdentity() ClientOption {
+	return func(c *DvmClient) {
+		c.ephemeral = true
+	}
+}
+
+// requester is who a request is sent as, and over what connection.
+type requester struct {
+	sk, pk string
+	relay  *nostr.Relay
+	// redial returns a live connection once relay drops
+	redial func(ctx context.Context) (*nostr.Relay, error)
+	close  func()
+}
+
+// requester returns who to send the next request as: the client itself,
+// on its shared connection, or with WithEphemeralIdentity a fresh key on a
+// fresh connection, once a random pause has passed.
+func (c *DvmClient) requester(ctx context.Context) (*requester, error) {
+	if !c.ephemeral {
+		r := &requester{sk: c.sk, pk: c.pk, relay: c.relay, close: func() {}}
+		r.redial = func(ctx context.Context) (*nostr.Relay, error) {
+			// Reuse the shared connection if someone else already redialed
+			relay, err := relayConns.live(ctx, relayURL(c.relay))
+			if err == nil {
+				c.relay, r.relay = relay, relay
+			}
+			return relay, err
+		}
+		return r, nil
+	}
+
+	if err := sleepJitter(ctx, ephemeralJitter); err != nil {
+		return nil, err
+	}
+	sk, err := generatePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	pk, _ := nostr.GetPublicKey(sk)
+	url := relayURL(c.relay)
+	relay, cancel, err := relayConns.dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	r := &requester{sk: sk, pk: pk, relay: relay}
+	r.redial = func(ctx context.Context) (*nostr.Relay, error) {
+		relay, next, err := relayConns.dial(ctx, url)
+		if err == nil {
+			cancel()
+			r.relay, cancel = relay, next
+		}
+		return relay, err
+	}
+	r.close = func() { cancel() }
+	return r, nil
+}
+
+// sleepJitter waits a uniformly random time up to max, or until ctx is
+// done.
+func sleepJitter(ctx context.Context, max time.Duration) error {
+	if max <= 0 {
+		return nil
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(time.Duration(n.Int64()))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,54 @@
+package dvm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+func TestEphemeralIdentity(t *testing.T) {
+	defer func(jitter time.Duration) { ephemeralJitter = jitter }(ephemeralJitter)
+	ephemeralJitter = 50 * time.Millisecond
+
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	// DM results are encrypted to each request's key
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithResultMode(ResultsAsDMs))
+
+	client, err := NewDvmClient(relay.URL(), WithEphemeralIdentity(), WithRatings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, id := range []string{"20", "21"} {
+		tweet, err := client.RequestTweet(ctx, d.GetPublicKey(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tweet.ID != id {
+			t.Errorf("expected tweet %s, got %s", id, tweet.ID)
+		}
+	}
+
+	requesters := make(map[string]bool)
+	for _, evt := range relay.Events() {
+		switch evt.Kind {
+		case KindTweetRequest:
+			requesters[evt.PubKey] = true
+		case KindLabel:
+			if !requesters[evt.PubKey] {
+				t.Errorf("expected ratings signed by their request's key, got %s", evt.PubKey[:8])
+			}
+		}
+	}
+	if len(requesters) != 2 || requesters[client.pk] {
+		t.Errorf("expected two requests from two fresh keys, got %v", requesters)
+	}
+	if relay.Connections() > 2 {
+		t.Errorf("expected each request's connection closed after it, %d open", relay.Connections())
+	}
+}
@@ -0,0 +1,135 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ProfileConfig is the kind-0 profile the DVM keeps published for each of
+// its identities, so clients show it by name and picture rather than as a
+// bare pubkey. On starting and every Interval the DVM reads its latest
+// profile from the relays and publishes a new one if any configured field
+// differs, so a changed config goes out on the next start. Fields left
+// empty, and fields the config doesn't cover such as a banner set from
+// another client, are kept as they are.
+type ProfileConfig struct {
+	Name     string
+	About    string
+	Picture  string        // an http(s) URL
+	LUD16    string        // lightning address, user@domain, zaps go to
+	NIP05    string        // NIP-05 identifier, user@domain
+	Interval time.Duration // between checks, default 1h
+}
+
+func (c ProfileConfig) withDefaults() ProfileConfig {
+	if c.Interval <= 0 {
+		c.Interval = time.Hour
+	}
+	return c
+}
+
+// fields returns the profile's fields by their kind-0 names, leaving out
+// empty ones.
+func (c ProfileConfig) fields() map[string]string {
+	fields := map[string]string{}
+	for name, value := range map[string]string{
+		"name": c.Name, "about": c.About, "picture": c.Picture, "lud16": c.LUD16, "nip05": c.NIP05,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+func (c ProfileConfig) validate() error {
+	if c.Picture != "" {
+		if u, err := url.Parse(c.Picture); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid profile picture URL %q", c.Picture)
+		}
+	}
+	for name, value := range map[string]string{"lud16": c.LUD16, "nip05": c.NIP05} {
+		if user, domain, ok := strings.Cut(value, "@"); value != "" && (!ok || user == "" || domain == "") {
+			return fmt.Errorf("invalid profile %s %q: want user@domain", name, value)
+		}
+	}
+	return nil
+}
+
+// runProfile keeps each identity's profile published.
+func (d *Dvm) runProfile(ctx context.Context) {
+	for {
+		for _, id := range d.identities {
+			if err := d.maintainProfile(ctx, id); err != nil {
+				log.Printf("Failed to publish profile for identity %s: %v", id.name, err)
+			}
+		}
+		select {
+		case <-time.After(d.profile.Interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// maintainProfile publishes id's profile if its latest one on the relays
+// doesn't match the config.
+func (d *Dvm) maintainProfile(ctx context.Context, id *identity) error {
+	filter := nostr.Filter{Kinds: []int{0}, Authors: []string{id.pk}}
+	var latest *nostr.Event
+	for _, f := range d.queryRelays(ctx, []nostr.Filter{filter}, nil, nil) {
+		if latest == nil || f.Event.CreatedAt > latest.CreatedAt {
+			latest = f.Event
+		}
+	}
+
+	profile := map[string]any{}
+	if latest != nil {
+		if err := json.Unmarshal([]byte(latest.Content), &profile); err != nil {
+			// Replaced wholesale by the configured fields
+			profile = map[string]any{}
+		}
+	}
+	changed := latest == nil
+	for name, value := range d.profile.fields() {
+		if current, _ := profile[name].(string); current != value {
+			profile[name] = value
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	content, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	evt := nostr.Event{
+		PubKey:    id.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      0,
+		Tags:      nostr.Tags{},
+		Content:   string(content),
+	}
+	if latest != nil && evt.CreatedAt <= latest.CreatedAt {
+		// Relays keep only the newest profile
+		evt.CreatedAt = latest.CreatedAt + 1
+	}
+	if err := evt.Sign(id.sk); err != nil {
+		return err
+	}
+	if err := d.publish(evt); err != nil {
+		return err
+	}
+	metricProfilePublishes.Add(1)
+	log.Printf("Published profile for identity %s", id.name)
+	return nil
+}
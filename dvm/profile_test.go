@@ -0,0 +1,117 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// latestProfile returns the newest kind-0 content pubkey has on relay.
+func latestProfile(relay *relaytest.Server, pubkey string) (map[string]any, *nostr.Event) {
+	var latest *nostr.Event
+	for _, evt := range relay.Events() {
+		if evt.Kind == 0 && evt.PubKey == pubkey && (latest == nil || evt.CreatedAt > latest.CreatedAt) {
+			latest = evt
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	profile := map[string]any{}
+	json.Unmarshal([]byte(latest.Content), &profile)
+	return profile, latest
+}
+
+// runProfileDvm runs a DVM as sk with the profile cfg until the returned
+// stop is called.
+func runProfileDvm(t *testing.T, relay *relaytest.Server, sk string, cfg ProfileConfig) (*Dvm, func()) {
+	t.Helper()
+	d, err := NewDvm(relay.URL(), sk, WithScraper(&fakeScraper{}), WithProfile(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	return d, func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestProfile(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	sk := testKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	// A profile set from another client keeps its other fields
+	existing := &nostr.Event{CreatedAt: nostr.Now() - 60, Kind: 0, Tags: nostr.Tags{},
+		Content: `{"name":"old","banner":"https://example.com/banner.png"}`}
+	existing.Sign(sk)
+	relay.Publish(existing)
+
+	cfg := ProfileConfig{Name: "bandita", About: "Fetches tweets", LUD16: "dvm@example.com"}
+	_, stop := runProfileDvm(t, relay, sk, cfg)
+	deadline := time.Now().Add(5 * time.Second)
+	var profile map[string]any
+	var published *nostr.Event
+	for {
+		if profile, published = latestProfile(relay, pk); published != nil && published.ID != existing.ID {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a profile published")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	stop()
+	if profile["name"] != "bandita" || profile["lud16"] != "dvm@example.com" || profile["banner"] != "https://example.com/banner.png" {
+		t.Errorf("unexpected profile %v", profile)
+	}
+
+	// Unchanged, it isn't published again
+	d, stop := runProfileDvm(t, relay, sk, cfg)
+	time.Sleep(500 * time.Millisecond)
+	stop()
+	if _, latest := latestProfile(relay, pk); latest.ID != published.ID {
+		t.Errorf("expected the unchanged profile left alone, got %s", latest.Content)
+	}
+	if caps := d.capabilities(); caps.Name != "bandita" || caps.About != "Fetches tweets" {
+		t.Errorf("expected the capabilities to use the profile, got %q, %q", caps.Name, caps.About)
+	}
+
+	// Changed, it is
+	cfg.About = "Fetches tweets and more"
+	_, stop = runProfileDvm(t, relay, sk, cfg)
+	defer stop()
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		if profile, _ = latestProfile(relay, pk); profile["about"] == "Fetches tweets and more" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the changed profile republished, got %v", profile)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestProfileValidation(t *testing.T) {
+	for _, cfg := range []ProfileConfig{
+		{Picture: "javascript:alert(1)"},
+		{LUD16: "not-an-address"},
+		{NIP05: "@example.com"},
+	} {
+		if _, err := NewDvm("ws://localhost:1", testKey(), WithProfile(cfg)); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
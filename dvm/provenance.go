@@ -0,0 +1,149 @@
+package dvm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// FetchAttestation is the DVM's signed statement of what it fetched, when
+// and how, carried by results in a ["fetch-attestation", <JSON>] tag. It
+// stands apart from the result's event, so anyone holding the result's
+// content can check its provenance long after the tweet has been edited or
+// deleted, however the content reached them.
+type FetchAttestation struct {
+	// Hash is the hex SHA-256 of the full result normalized as
+	// RequestAttested normalizes results, so engagement counts don't count
+	Hash      string          `json:"hash"`
+	FetchedAt nostr.Timestamp `json:"fetched_at"`
+	// Backend is what the content was fetched with, such as
+	// "twitter-scraper", or the job kind's name
+	Backend string `json:"backend"`
+	PubKey  string `json:"pubkey"`
+	// Sig is a Schnorr signature by PubKey of the hash, along with when
+	// and how it was fetched; see digest
+	Sig string `json:"sig"`
+}
+
+// fetchAttestationTag names the tag results carry their attestation in.
+const fetchAttestationTag = "fetch-attestation"
+
+// digest is what Sig signs: the SHA-256 of the JSON array
+// ["fetch-attestation", <pubkey>, <hash>, <fetched_at>, <backend>].
+func (a *FetchAttestation) digest() [32]byte {
+	serialized, _ := json.Marshal([]any{fetchAttestationTag, a.PubKey, a.Hash, a.FetchedAt, a.Backend})
+	return sha256.Sum256(serialized)
+}
+
+// Verify checks that the attestation is signed by its pubkey and vouches
+// for content, the full result of a job of the given kind.
+func (a *FetchAttestation) Verify(kind int, content string) error {
+	normalized, err := normalizeResult(kind, content)
+	if err != nil {
+		return err
+	}
+	if hash := sha256.Sum256([]byte(normalized)); hex.EncodeToString(hash[:]) != a.Hash {
+		return errors.New("content doesn't match the attested hash")
+	}
+	rawKey, err := hex.DecodeString(a.PubKey)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	pubKey, err := schnorr.ParsePubKey(rawKey)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	rawSig, err := hex.DecodeString(a.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	sig, err := schnorr.ParseSignature(rawSig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if digest := a.digest(); !sig.Verify(digest[:], pubKey) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// FetchAttestationOf returns the attestation a result event carries, or
+// nil if it has none.
+func FetchAttestationOf(result *nostr.Event) (*FetchAttestation, error) {
+	raw := tagValue(result.Tags, fetchAttestationTag)
+	if raw == "" {
+		return nil, nil
+	}
+	var att FetchAttestation
+	if err := json.Unmarshal([]byte(raw), &att); err != nil {
+		return nil, fmt.Errorf("invalid fetch attestation: %w", err)
+	}
+	return &att, nil
+}
+
+// recordFetch tells the job running under ctx what its content was fetched
+// with and when, for its attestation; when the content came from a cache,
+// that's when it was first fetched. Jobs that don't say are attested as
+// fetched by the job kind's handler as it finished.
+func recordFetch(ctx context.Context, backend string, at time.Time) {
+	if r, ok := ctx.Value(jobReceiptKey{}).(*jobReceipt); ok {
+		r.backend, r.fetchedAt = backend, at
+	}
+}
+
+// scraperBackend names a tweet scraper for attestations.
+func scraperBackend(s TweetScraper) string {
+	switch s.(type) {
+	case *twitterscraper.Scraper, *SandboxedScraper:
+		return "twitter-scraper"
+	default:
+		return fmt.Sprintf("%T", s)
+	}
+}
+
+// attestFetch signs an attestation, as id, of result, what the job evt
+// asked for returned.
+func (d *Dvm) attestFetch(id *identity, evt *nostr.Event, result []byte, receipt *jobReceipt) (*FetchAttestation, error) {
+	normalized, err := normalizeResult(evt.Kind, string(result))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(normalized))
+	att := &FetchAttestation{
+		Hash:      hex.EncodeToString(hash[:]),
+		FetchedAt: nostr.Now(),
+		Backend:   receipt.backend,
+		PubKey:    id.pk,
+	}
+	if !receipt.fetchedAt.IsZero() {
+		att.FetchedAt = nostr.Timestamp(receipt.fetchedAt.Unix())
+	}
+	if att.Backend == "" {
+		att.Backend = builtinCapabilities[evt.Kind].Name
+		if att.Backend == "" {
+			att.Backend = "kind " + strconv.Itoa(evt.Kind)
+		}
+	}
+	digest := att.digest()
+	sig, err := schnorr.Sign(id.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	att.Sig = hex.EncodeToString(sig.Serialize())
+	return att, nil
+}
+
+// tag returns the result tag carrying the attestation.
+func (a *FetchAttestation) tag() nostr.Tag {
+	raw, _ := json.Marshal(a)
+	return nostr.Tag{fetchAttestationTag, string(raw)}
+}
@@ -0,0 +1,49 @@
+package dvm
+
+import (
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+)
+
+func TestFetchAttestation(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithCache(1<<20), WithFetchAttestations())
+
+	req := newTestRequest("20")
+	relay.Publish(req)
+	result := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	att, err := FetchAttestationOf(result)
+	if err != nil || att == nil {
+		t.Fatalf("expected the result to carry an attestation, got %v, %v", att, err)
+	}
+	if att.Backend != scraperBackend(scraper) || att.PubKey != d.GetPublicKey() {
+		t.Errorf("unexpected attestation %+v", att)
+	}
+	if fetchedAt, _ := d.cache.fetchedAt("20"); att.FetchedAt.Time().Unix() != fetchedAt.Unix() {
+		t.Errorf("attested fetch at %v, cached at %v", att.FetchedAt.Time(), fetchedAt)
+	}
+	if err := att.Verify(KindTweetRequest, result.Content); err != nil {
+		t.Errorf("expected the attestation to verify: %v", err)
+	}
+
+	// It still vouches for the tweet once its counts have moved on, but
+	// not for an edit, nor once its fetch time is moved
+	recounted := strings.Replace(result.Content, `"Likes":0`, `"Likes":21`, 1)
+	if recounted == result.Content {
+		t.Fatalf("no likes in %s", result.Content)
+	}
+	if err := att.Verify(KindTweetRequest, recounted); err != nil {
+		t.Errorf("expected engagement counts not to matter: %v", err)
+	}
+	if err := att.Verify(KindTweetRequest, strings.Replace(result.Content, "Running", "Selling", 1)); err == nil {
+		t.Error("expected an edited tweet not to verify")
+	}
+	att.FetchedAt--
+	if err := att.Verify(KindTweetRequest, result.Content); err == nil {
+		t.Error("expected a changed fetch time not to verify")
+	}
+}
@@ -0,0 +1,204 @@
+package dvm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// OverflowPolicy says what the DVM does with requests that arrive while its
+// queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowShed answers requests beyond the queue limit with "busy"
+	// feedback straight away. This is the default.
+	OverflowShed OverflowPolicy = "shed"
+	// OverflowBlock stops reading the subscription until a worker frees a
+	// slot, so bursts wait in the subscription buffer and then at the relay
+	// instead of being turned away.
+	OverflowBlock OverflowPolicy = "block"
+)
+
+// QueueConfig controls how incoming requests are buffered for the workers.
+type QueueConfig struct {
+	Workers    int            // concurrent job workers, default 1
+	Limit      int            // queued jobs before the overflow policy applies, default 100
+	RetryAfter time.Duration  // suggested client back-off in busy feedback, default 30s
+	Buffer     int            // events read ahead from the relay subscription, default 256
+	Overflow   OverflowPolicy // what to do with requests beyond Limit, default OverflowShed
+}
+
+func (c QueueConfig) withDefaults() QueueConfig {
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+	if c.Limit <= 0 {
+		c.Limit = 100
+	}
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = 30 * time.Second
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = 256
+	}
+	if c.Overflow == "" {
+		c.Overflow = OverflowShed
+	}
+	return c
+}
+
+// readAhead drains sub into a buffer of the configured size, so a burst of
+// events doesn't pile up in the relay library while the Run loop is busy.
+// When the buffer is full, events are shed with busy feedback or, under
+// OverflowBlock, the reader waits. The returned channel closes when sub
+// ends.
+func (d *Dvm) readAhead(sub *nostr.Subscription) <-chan *nostr.Event {
+	events := make(chan *nostr.Event, d.queueCfg.Buffer)
+	go func() {
+		defer close(events)
+		for {
+			var evt *nostr.Event
+			select {
+			case e, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				if !verifyEvent(e) {
+					d.logs.printf("Ignoring event %s with a bad signature", e.ID)
+					continue
+				}
+				evt = e
+			case <-sub.Relay.Context().Done():
+				return
+			case <-d.done:
+				return
+			}
+
+			if d.queueCfg.Overflow == OverflowBlock {
+				select {
+				case events <- evt:
+				case <-d.done:
+					return
+				}
+				continue
+			}
+			select {
+			case events <- evt:
+			default:
+				metricEventsDropped.Add(1)
+				if _, ok := d.handlers[evt.Kind]; ok {
+					d.logs.printf("Subscription buffer full (%d events), shedding request %s", len(events), evt.ID[:8])
+					d.shed(evt)
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// enqueue hands a request to the workers. When the queue is saturated it
+// either answers with busy feedback straight away or, under OverflowBlock,
+// waits for room. Only the Run loop calls it.
+func (d *Dvm) enqueue(evt *nostr.Event) {
+	if len(d.queue) >= d.queueCfg.Limit && d.queueCfg.Overflow == OverflowShed {
+		d.logs.printf("Queue full (%d jobs), shedding request %s", len(d.queue), evt.ID[:8])
+		d.shed(evt)
+		return
+	}
+
+	metricJobsAccepted.Add(1)
+	metricQueueDepth.Add(1)
+	select {
+	case d.queue <- evt:
+	case <-d.done:
+		metricQueueDepth.Add(-1)
+	}
+}
+
+// errShuttingDown is a job given up on because the DVM is stopping.
+var errShuttingDown = errors.New("DVM is shutting down")
+
+// stopping reports whether the DVM has been told to stop.
+func (d *Dvm) stopping() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// rejectQueued turns away the jobs still queued when the DVM stops, while
+// the workers finish the jobs they have, paid ones included. No queued job
+// has paid: a job is only priced once a worker takes it.
+func (d *Dvm) rejectQueued() {
+	for {
+		select {
+		case evt := <-d.queue:
+			metricQueueDepth.Add(-1)
+			d.rejectShuttingDown(evt)
+		default:
+			return
+		}
+	}
+}
+
+// rejectShuttingDown answers evt with shutting-down feedback, so its
+// requester knows to resubmit it rather than wait.
+func (d *Dvm) rejectShuttingDown(evt *nostr.Event) {
+	id := d.route(evt)
+	if id == nil {
+		return
+	}
+	d.logs.printf("Shutting down, turning away queued request %s", evt.ID[:8])
+	d.publishFeedback(id, evt, StatusError, ReasonShuttingDown, "DVM is shutting down, resubmit the request later")
+}
+
+// shed turns evt away with busy feedback.
+func (d *Dvm) shed(evt *nostr.Event) {
+	metricJobsBusy.Add(1)
+	id := d.route(evt)
+	if id == nil {
+		return
+	}
+	d.logs.printf("Telling %s to retry later", d.scrub.logPubKey(evt.PubKey))
+	retryAfter := int(d.queueCfg.RetryAfter.Seconds())
+	go d.publishFeedback(id, evt, StatusError, ReasonBusy,
+		fmt.Sprintf("DVM is busy, retry after %d seconds", retryAfter),
+		nostr.Tag{"retry-after", strconv.Itoa(retryAfter)})
+}
+
+// startWorkers launches the job workers, which exit once the DVM is stopped.
+func (d *Dvm) startWorkers() *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < d.queueCfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case evt := <-d.queue:
+					metricQueueDepth.Add(-1)
+					// The select picks at random when the DVM is stopping
+					// with jobs still queued; those are turned away
+					if d.stopping() {
+						d.rejectShuttingDown(evt)
+						continue
+					}
+					func() {
+						defer recoverWorker(evt)
+						d.handleRequest(evt)
+					}()
+				case <-d.done:
+					return
+				}
+			}
+		}()
+	}
+	return &wg
+}
@@ -0,0 +1,137 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// blockingScraper holds every scrape until release is closed.
+type blockingScraper struct {
+	release chan struct{}
+}
+
+func (b *blockingScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	<-b.release
+	return &twitterscraper.Tweet{ID: id, Username: "halfin", Text: "Running bitcoin"}, nil
+}
+
+func TestBusyFeedbackWhenQueueSaturated(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	scraper := &blockingScraper{release: make(chan struct{})}
+	d := startTestDvm(t, relay,
+		WithScraper(scraper),
+		WithQueue(QueueConfig{Workers: 1, Limit: 1, RetryAfter: 42 * time.Second}),
+	)
+	defer close(scraper.release)
+
+	// The first request occupies the worker and the second fills the queue
+	for _, id := range []string{"1", "2"} {
+		relay.Publish(newTestRequest(id))
+		time.Sleep(100 * time.Millisecond)
+	}
+	if depth := metricQueueDepth.Value(); depth != 1 {
+		t.Errorf("expected queue depth 1, got %d", depth)
+	}
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = client.RequestTweet(ctx, d.GetPublicKey(), "3")
+	var fbErr *FeedbackError
+	if !errors.As(err, &fbErr) || fbErr.Reason != ReasonBusy {
+		t.Fatalf("expected busy feedback, got %v", err)
+	}
+	if fbErr.RetryAfter != 42*time.Second {
+		t.Errorf("expected retry-after 42s, got %v", fbErr.RetryAfter)
+	}
+}
+
+func TestOverflowBlockQueuesInsteadOfShedding(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	scraper := &blockingScraper{release: make(chan struct{})}
+	d := startTestDvm(t, relay,
+		WithScraper(scraper),
+		WithQueue(QueueConfig{Workers: 1, Limit: 1, Buffer: 1, Overflow: OverflowBlock}),
+	)
+	busy := metricJobsBusy.Value()
+
+	// One request for the worker, one queued, one held by the Run loop and
+	// the rest waiting in the subscription buffer and beyond
+	var reqs []*nostr.Event
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		req := newTestRequest(id)
+		relay.Publish(req)
+		reqs = append(reqs, req)
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(scraper.release)
+
+	for _, req := range reqs {
+		if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind != ResultKind(KindTweetRequest) {
+			t.Errorf("expected a result for request %s, got kind %d", req.ID[:8], resp.Kind)
+		}
+	}
+	if metricJobsBusy.Value() != busy {
+		t.Error("expected no busy feedback under OverflowBlock")
+	}
+}
+
+func TestUnknownOverflowPolicy(t *testing.T) {
+	if _, err := NewDvm("ws://localhost", testKey(), WithQueue(QueueConfig{Overflow: "drop"})); err == nil {
+		t.Error("expected an unknown overflow policy to be rejected")
+	}
+}
+
+func TestShutdownFinishesInFlightJobsAndTurnsAwayQueued(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	scraper := &blockingScraper{release: make(chan struct{})}
+	d, err := NewDvm(relay.URL(), testKey(), WithScraper(scraper), WithQueue(QueueConfig{Workers: 1, Limit: 5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// The first request occupies the worker, the others wait in the queue
+	var reqs []*nostr.Event
+	for _, id := range []string{"1", "2", "3"} {
+		req := newTestRequest(id)
+		reqs = append(reqs, req)
+		relay.Publish(req)
+		time.Sleep(100 * time.Millisecond)
+	}
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	close(scraper.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), reqs[0].ID); resp.Kind != ResultKind(KindTweetRequest) {
+		t.Errorf("expected the in-flight job to finish, got %+v", resp)
+	}
+	for _, req := range reqs[1:] {
+		fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+		if fb.Tags.GetFirst([]string{"status", StatusError, ReasonShuttingDown}) == nil {
+			t.Errorf("expected shutting-down feedback for a queued job, got %+v", fb)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// QuotaConfig limits how many jobs each requester pubkey may submit per day.
+type QuotaConfig struct {
+	Daily   int           // jobs per requester per day; 0 disables quotas
+	ResetAt time.Duration // offset from midnight UTC at which counters reset
+}
+
+// ParseResetAt parses a "HH:MM" UTC time of day into a QuotaConfig.ResetAt offset.
+func ParseResetAt(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reset time %q: expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// periodStart returns the most recent reset boundary at or before now.
+func (c QuotaConfig) periodStart(now time.Time) time.Time {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(c.ResetAt)
+	if start.After(now) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+// quotaState is the persisted form of the quota counters.
+type quotaState struct {
+	PeriodStart time.Time      `json:"period_start"`
+	Counts      map[string]int `json:"counts"`
+}
+
+// quota tracks per-pubkey job counts for the current period, persisting them
+// to the store after every change.
+type quota struct {
+	cfg      QuotaConfig
+	store    *Store
+	document string
+
+	mu    sync.Mutex
+	state quotaState
+}
+
+const quotaDocument = "quota"
+
+func loadQuota(cfg QuotaConfig, store *Store, document string) (*quota, error) {
+	q := &quota{cfg: cfg, store: store, document: document}
+	if store != nil {
+		if err := store.Load(document, &q.state); err != nil {
+			return nil, fmt.Errorf("failed to load quota counters: %w", err)
+		}
+	}
+	if q.state.Counts == nil {
+		q.state.Counts = make(map[string]int)
+	}
+	return q, nil
+}
+
+// allow counts a job for pubkey, reporting false once the daily limit is
+// reached. It also returns when the current period ends.
+func (q *quota) allow(pubkey string, now time.Time) (bool, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	start := q.cfg.periodStart(now)
+	if !q.state.PeriodStart.Equal(start) {
+		q.state = quotaState{PeriodStart: start, Counts: make(map[string]int)}
+	}
+	resetAt := start.AddDate(0, 0, 1)
+
+	if q.state.Counts[pubkey] >= q.cfg.Daily {
+		return false, resetAt
+	}
+	q.state.Counts[pubkey]++
+
+	if q.store != nil {
+		if err := q.store.Save(q.document, q.state); err != nil {
+			log.Printf("Failed to persist quota counters: %v", err)
+		}
+	}
+	return true, resetAt
+}
@@ -0,0 +1,109 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+func TestQuotaResetsAtBoundary(t *testing.T) {
+	cfg := QuotaConfig{Daily: 2, ResetAt: 6 * time.Hour}
+	q, err := loadQuota(cfg, nil, quotaDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Date(2024, 3, 1, 5, 59, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if ok, _ := q.allow("alice", before); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	ok, resetAt := q.allow("alice", before)
+	if ok {
+		t.Fatal("third request should exceed the quota")
+	}
+	if want := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC); !resetAt.Equal(want) {
+		t.Errorf("expected reset at %v, got %v", want, resetAt)
+	}
+	if ok, _ := q.allow("bob", before); !ok {
+		t.Error("quota should be tracked per pubkey")
+	}
+
+	if ok, _ := q.allow("alice", before.Add(time.Minute)); !ok {
+		t.Error("quota should reset at the configured boundary")
+	}
+}
+
+func TestQuotaSurvivesRestart(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := QuotaConfig{Daily: 1}
+	now := time.Now()
+
+	q, _ := loadQuota(cfg, store, quotaDocument)
+	if ok, _ := q.allow("alice", now); !ok {
+		t.Fatal("first request should be allowed")
+	}
+
+	reloaded, err := loadQuota(cfg, store, quotaDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := reloaded.allow("alice", now); ok {
+		t.Error("counter should persist across restarts")
+	}
+}
+
+func TestQuotaExceededFeedback(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithQuota(QuotaConfig{Daily: 1}))
+
+	sk := testKey()
+	first := newTestRequestFrom(sk, "1")
+	d.handleRequest(first)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), first.ID); resp.Kind != ResultKind(KindTweetRequest) {
+		t.Fatalf("expected a tweet response, got kind %d", resp.Kind)
+	}
+
+	second := newTestRequestFrom(sk, "2")
+	d.handleRequest(second)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), second.ID)
+	if fb.Kind != KindJobFeedback {
+		t.Fatalf("expected feedback, got kind %d", fb.Kind)
+	}
+	status := fb.Tags.GetFirst([]string{"status"})
+	if status == nil || len(*status) < 3 || (*status)[1] != StatusError || (*status)[2] != ReasonQuotaExceeded {
+		t.Errorf("unexpected status tag %v", status)
+	}
+}
+
+func TestClientReportsQuotaExceeded(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithQuota(QuotaConfig{Daily: 1}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.RequestTweet(ctx, d.GetPublicKey(), "1"); err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+	_, err = client.RequestTweet(ctx, d.GetPublicKey(), "2")
+	var fbErr *FeedbackError
+	if !errors.As(err, &fbErr) || fbErr.Reason != ReasonQuotaExceeded {
+		t.Fatalf("expected quota-exceeded feedback error, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package dvm
+
+// raceEnabled reports whether the tests were built with the race detector,
+// whose runtime needs far more memory than a process otherwise would.
+const raceEnabled = true
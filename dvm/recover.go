@@ -0,0 +1,37 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// errJobPanicked is a job whose handler panicked. The requester is told
+// no more than this; the panic and its stack go to the log.
+var errJobPanicked = errors.New("internal error")
+
+// handle runs handler on req, recovering from a panic, say over a tweet
+// payload the scraper didn't expect, as the job failing with
+// errJobPanicked, so one bad request can't take down the DVM.
+func handle(ctx context.Context, handler JobHandler, req *nostr.Event) (result []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			metricJobsPanicked.Add(1)
+			log.Printf("Job %s panicked: %v\n%s", req.ID[:8], r, debug.Stack())
+			result, err = nil, errJobPanicked
+		}
+	}()
+	return handler.Handle(ctx, req)
+}
+
+// recoverWorker is deferred by the job workers to survive a panic outside
+// the job handler, which handle doesn't catch, logging it.
+func recoverWorker(evt *nostr.Event) {
+	if r := recover(); r != nil {
+		metricJobsPanicked.Add(1)
+		log.Printf("Panic handling request %s: %v\n%s", evt.ID[:8], r, debug.Stack())
+	}
+}
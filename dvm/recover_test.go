@@ -0,0 +1,48 @@
+package dvm
+
+import (
+	"context"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// panicHandler panics on every request, like a handler tripping over a
+// payload it didn't expect.
+type panicHandler struct{ echoHandler }
+
+func (panicHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	var tweet map[string]string
+	return []byte(tweet["text"][:1]), nil
+}
+
+func TestHandlerPanicFailsOnlyItsJob(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	panicked := metricJobsPanicked.Value()
+	// Our bugs aren't held against the requester
+	d := startTestDvm(t, relay, WithHandler(5997, panicHandler{}), WithHandler(5998, echoHandler{}),
+		WithAbuseProtection(AbuseConfig{Strikes: 1}))
+	sk := testKey()
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: 5997, Tags: nostr.Tags{}, Content: "boom"}
+	req.Sign(sk)
+	relay.Publish(req)
+
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Kind != KindJobFeedback || fb.Tags.GetFirst([]string{"status", StatusError}) == nil || fb.Content != "Job failed: internal error" {
+		t.Fatalf("expected error feedback, got %+v", fb)
+	}
+	if pk, _ := nostr.GetPublicKey(sk); d.abuse.isDenied(pk) {
+		t.Error("expected the requester not to be struck for the panic")
+	}
+	if metricJobsPanicked.Value() != panicked+1 {
+		t.Error("expected the panic to be counted")
+	}
+
+	// The DVM carries on with other jobs
+	if evt := requestEcho(t, relay, d, "still here"); evt.Content != "still here" {
+		t.Errorf("expected the echo result, got %+v", evt)
+	}
+}
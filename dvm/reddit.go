@@ -0,0 +1,176 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// redditPostPath matches /r/<sub>/comments/<id>/... and /comments/<id> on
+// reddit.com, or /<id> on the redd.it shortener.
+var (
+	redditPostPath  = regexp.MustCompile(`^(?:/r/[^/]+)?/comments/([0-9a-z]+)(?:/|$)`)
+	redditShortPath = regexp.MustCompile(`^/([0-9a-z]+)/?$`)
+)
+
+// maxRedditComments caps the "comments" param of a Reddit request.
+const maxRedditComments = 100
+
+// redditHandler fetches a Reddit post, and optionally its top-level
+// comments, from the public JSON endpoints. Requests may carry a
+// ["param", "comments", "<n>"] tag to include up to n comments.
+type redditHandler struct {
+	client  *http.Client
+	baseURL string // overridden in tests
+}
+
+func newRedditHandler() *redditHandler {
+	return &redditHandler{client: newFetchClient(), baseURL: "https://www.reddit.com"}
+}
+
+func (h *redditHandler) Validate(req *nostr.Event) error {
+	if _, err := parseRedditURL(req.Content); err != nil {
+		return err
+	}
+	_, err := redditCommentLimit(req)
+	return err
+}
+
+// parseRedditURL extracts the post ID from a Reddit post URL.
+func parseRedditURL(raw string) (string, error) {
+	u, err := parseWebURL(raw)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "redd.it":
+		if m := redditShortPath.FindStringSubmatch(u.Path); m != nil {
+			return m[1], nil
+		}
+	case host == "reddit.com" || strings.HasSuffix(host, ".reddit.com"):
+		if m := redditPostPath.FindStringSubmatch(u.Path); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", fmt.Errorf("not a Reddit post URL: %q", raw)
+}
+
+// redditCommentLimit reads the optional "comments" param.
+func redditCommentLimit(req *nostr.Event) (int, error) {
+	value, ok := paramValue(req, "comments")
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > maxRedditComments {
+		return 0, fmt.Errorf("comments param must be between 0 and %d", maxRedditComments)
+	}
+	return n, nil
+}
+
+// redditThing is the envelope Reddit wraps every object in.
+type redditThing struct {
+	Kind string          `json:"kind"` // "t3" post, "t1" comment, "more"...
+	Data json.RawMessage `json:"data"`
+}
+
+type redditListing struct {
+	Data struct {
+		Children []redditThing `json:"children"`
+	} `json:"data"`
+}
+
+// redditItem holds the fields shared by posts and comments.
+type redditItem struct {
+	ID          string  `json:"id"`
+	Author      string  `json:"author"`
+	Permalink   string  `json:"permalink"`
+	CreatedUTC  float64 `json:"created_utc"`
+	Score       int     `json:"score"`
+	NumComments int     `json:"num_comments"`
+
+	// Posts
+	Title       string `json:"title"`
+	Selftext    string `json:"selftext"`
+	URL         string `json:"url"`
+	PostHint    string `json:"post_hint"`
+	SecureMedia *struct {
+		RedditVideo *struct {
+			FallbackURL string `json:"fallback_url"`
+		} `json:"reddit_video"`
+	} `json:"secure_media"`
+
+	// Comments
+	Body string `json:"body"`
+}
+
+func (h *redditHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	id, err := parseRedditURL(req.Content)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := redditCommentLimit(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first listing holds the post, the second its comments
+	var listings []redditListing
+	apiURL := fmt.Sprintf("%s/comments/%s.json?raw_json=1&depth=1&limit=%d", h.baseURL, id, limit)
+	if err := fetchJSON(ctx, h.client, apiURL, "", &listings); err != nil {
+		return nil, err
+	}
+	if len(listings) == 0 || len(listings[0].Data.Children) == 0 {
+		return nil, fmt.Errorf("reddit post %s not found", id)
+	}
+
+	var item redditItem
+	if err := json.Unmarshal(listings[0].Data.Children[0].Data, &item); err != nil {
+		return nil, fmt.Errorf("invalid reddit post: %w", err)
+	}
+	post := item.toPost()
+	post.Title = item.Title
+	post.Text = item.Selftext
+	post.Replies = item.NumComments
+	switch {
+	case item.SecureMedia != nil && item.SecureMedia.RedditVideo != nil:
+		post.Media = append(post.Media, PostMedia{Type: "video", URL: item.SecureMedia.RedditVideo.FallbackURL})
+	case item.PostHint == "image":
+		post.Media = append(post.Media, PostMedia{Type: "image", URL: item.URL})
+	}
+
+	if limit > 0 && len(listings) > 1 {
+		for _, child := range listings[1].Data.Children {
+			if child.Kind != "t1" || len(post.Comments) >= limit {
+				continue
+			}
+			var comment redditItem
+			if err := json.Unmarshal(child.Data, &comment); err != nil {
+				continue
+			}
+			c := comment.toPost()
+			c.Text = comment.Body
+			post.Comments = append(post.Comments, c)
+		}
+	}
+	return json.Marshal(post)
+}
+
+func (item redditItem) toPost() Post {
+	return Post{
+		Platform:  "reddit",
+		ID:        item.ID,
+		URL:       "https://www.reddit.com" + item.Permalink,
+		Author:    PostAuthor{Handle: "u/" + item.Author, URL: "https://www.reddit.com/user/" + item.Author},
+		CreatedAt: time.Unix(int64(item.CreatedUTC), 0).UTC(),
+		Likes:     item.Score,
+	}
+}
@@ -0,0 +1,76 @@
+package dvm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseRedditURL(t *testing.T) {
+	for raw, wantID := range map[string]string{
+		"https://www.reddit.com/r/golang/comments/abc123/some_title/": "abc123",
+		"https://old.reddit.com/comments/abc123":                      "abc123",
+		"https://redd.it/abc123":                                      "abc123",
+	} {
+		if id, err := parseRedditURL(raw); err != nil || id != wantID {
+			t.Errorf("%s: got %q, %v", raw, id, err)
+		}
+	}
+	for _, raw := range []string{"https://www.reddit.com/r/golang/", "https://notreddit.com/comments/abc123", "abc123"} {
+		if _, err := parseRedditURL(raw); err == nil {
+			t.Errorf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestRedditHandlerIncludesRequestedComments(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/comments/abc123.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[
+			{"data":{"children":[{"kind":"t3","data":{"id":"abc123","author":"gopher",
+				"permalink":"/r/golang/comments/abc123/hi/","created_utc":1700000000,"score":42,
+				"num_comments":3,"title":"Hi","selftext":"Body","post_hint":"image",
+				"url":"https://i.redd.it/x.png"}}]}},
+			{"data":{"children":[
+				{"kind":"t1","data":{"id":"c1","author":"a","body":"first","permalink":"/r/golang/comments/abc123/hi/c1/"}},
+				{"kind":"t1","data":{"id":"c2","author":"b","body":"second"}},
+				{"kind":"more","data":{}}]}}]`))
+	}))
+	defer srv.Close()
+
+	h := newRedditHandler()
+	h.baseURL = srv.URL
+	h.client = srv.Client()
+	req := &nostr.Event{
+		Content: "https://www.reddit.com/r/golang/comments/abc123/hi/",
+		Tags:    nostr.Tags{{"param", "comments", "1"}},
+	}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	post := decodePost(t, h, ctx, req)
+	if post.Title != "Hi" || post.Text != "Body" || post.Likes != 42 || post.Author.Handle != "u/gopher" {
+		t.Errorf("unexpected post: %+v", post)
+	}
+	if len(post.Media) != 1 || post.Media[0].Type != "image" {
+		t.Errorf("expected the image to be attached, got %+v", post.Media)
+	}
+	if len(post.Comments) != 1 || post.Comments[0].Text != "first" {
+		t.Errorf("expected only the first comment, got %+v", post.Comments)
+	}
+
+	req.Tags = nostr.Tags{{"param", "comments", "lots"}}
+	if err := h.Validate(req); err == nil {
+		t.Error("expected an invalid comments param to be rejected")
+	}
+}
@@ -0,0 +1,162 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// refundTimeout bounds how long sending a refund may take.
+const refundTimeout = time.Minute
+
+// refunder sends money back to requesters whose prepaid jobs failed. The
+// NWC wallet and Core Lightning node are refunders; zaps and lightning
+// addresses only receive.
+type refunder interface {
+	payInvoice(ctx context.Context, bolt11 string) error
+	keysend(ctx context.Context, nodePubKey string, msats int64) error
+}
+
+func (w *nwcWallet) payInvoice(ctx context.Context, bolt11 string) error {
+	var result struct {
+		Preimage string `json:"preimage"`
+	}
+	return w.call(ctx, "pay_invoice", map[string]any{"invoice": bolt11}, &result)
+}
+
+func (w *nwcWallet) keysend(ctx context.Context, nodePubKey string, msats int64) error {
+	var result struct {
+		Preimage string `json:"preimage"`
+	}
+	return w.call(ctx, "pay_keysend", map[string]any{"amount": msats, "pubkey": nodePubKey}, &result)
+}
+
+func (n *clnNode) payInvoice(ctx context.Context, bolt11 string) error {
+	var result struct {
+		Status string `json:"status"`
+	}
+	if err := n.call(ctx, "pay", map[string]any{"bolt11": bolt11}, &result); err != nil {
+		return err
+	}
+	if result.Status != "complete" {
+		return fmt.Errorf("payment %s", result.Status)
+	}
+	return nil
+}
+
+func (n *clnNode) keysend(ctx context.Context, nodePubKey string, msats int64) error {
+	var result struct {
+		Status string `json:"status"`
+	}
+	if err := n.call(ctx, "keysend", map[string]any{"destination": nodePubKey, "amount_msat": msats}, &result); err != nil {
+		return err
+	}
+	if result.Status != "complete" {
+		return fmt.Errorf("keysend %s", result.Status)
+	}
+	return nil
+}
+
+// refund returns the msats paid for a failed job to its requester,
+// recording the refund in the ledger, and reports whether it was sent.
+func (d *Dvm) refund(req *nostr.Event, msats int64) bool {
+	if d.refunder == nil || msats <= 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), refundTimeout)
+	defer cancel()
+	if err := d.sendRefund(ctx, req, msats); err != nil {
+		log.Printf("Failed to refund request %s: %v", req.ID[:8], err)
+		return false
+	}
+	log.Printf("Refunded %d msats for request %s", msats, req.ID[:8])
+	d.recordRefund(req, msats)
+	return true
+}
+
+// refundNote refunds a failed job, if it was paid for, returning a note
+// for the requester saying so, or "".
+func (d *Dvm) refundNote(req *nostr.Event, msats int64) string {
+	if !d.refund(req, msats) {
+		return ""
+	}
+	return fmt.Sprintf("; refunded %d sats", msats/1000)
+}
+
+// sendRefund pays msats to the destination in the request's refund param,
+// a lightning address or node pubkey to keysend to, or else to the
+// lightning address in the requester's profile.
+func (d *Dvm) sendRefund(ctx context.Context, req *nostr.Event, msats int64) error {
+	dest, _ := paramValue(req, "refund")
+	if dest == "" {
+		dest = d.profileLightningAddress(ctx, req.PubKey)
+	}
+	if dest == "" {
+		return errors.New("requester has no refund address")
+	}
+	if isNodePubKey(dest) {
+		return d.refunder.keysend(ctx, dest, msats)
+	}
+
+	payee, err := parseLightningAddress(dest)
+	if err != nil {
+		return err
+	}
+	payee.client = newFetchClient() // the requester chose the address
+	bolt11, _, err := payee.requestInvoice(ctx, msats)
+	if err != nil {
+		return err
+	}
+	// Never pay more than was paid, whatever the address's server says
+	if amount, err := bolt11Msats(bolt11); err != nil || amount != msats {
+		return fmt.Errorf("%s returned an invoice for the wrong amount", dest)
+	}
+	return d.refunder.payInvoice(ctx, bolt11)
+}
+
+// profileLightningAddress returns the lud16 of pubkey's latest profile, or
+// "".
+func (d *Dvm) profileLightningAddress(ctx context.Context, pubkey string) string {
+	filter := nostr.Filter{Kinds: []int{0}, Authors: []string{pubkey}, Limit: 10}
+	var profile *nostr.Event
+	for _, f := range d.queryRelays(ctx, []nostr.Filter{filter}, nil, nil) {
+		if profile == nil || f.Event.CreatedAt > profile.CreatedAt {
+			profile = f.Event
+		}
+	}
+	if profile == nil {
+		return ""
+	}
+	var meta struct {
+		LUD16 string `json:"lud16"`
+	}
+	json.Unmarshal([]byte(profile.Content), &meta)
+	return meta.LUD16
+}
+
+// recordRefund adds a refund to the ledger as negative earnings, if the DVM
+// has a store.
+func (d *Dvm) recordRefund(req *nostr.Event, msats int64) {
+	if d.store == nil {
+		return
+	}
+	entry := LedgerEntry{JobID: req.ID, Requester: req.PubKey, Kind: req.Kind, Sats: -msats / 1000, Refund: true}
+	if err := NewLedger(d.store).Record(entry); err != nil {
+		log.Printf("Failed to record refund for request %s: %v", req.ID[:8], err)
+	}
+}
+
+// isNodePubKey reports whether s is a lightning node's compressed public
+// key in hex.
+func isNodePubKey(s string) bool {
+	if len(s) != 66 || (s[:2] != "02" && s[:2] != "03") {
+		return false
+	}
+	return strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
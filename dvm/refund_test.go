@@ -0,0 +1,75 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRefundFailedJobs(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	if _, err := NewDvm(relay.URL(), testKey(), WithPayments(PaymentConfig{LightningAddress: "dvm@example.com", Refunds: true})); err == nil {
+		t.Error("expected refunds to need a wallet that can pay")
+	}
+
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet := startFakeWallet(t, relay)
+	d := startTestDvm(t, relay, WithStore(store),
+		WithScraper(&failingScraper{err: errors.New("scraper down")}),
+		WithPayments(PaymentConfig{NWC: wallet.uri(relay), Refunds: true, Timeout: 5 * time.Second}),
+		WithPricing(PricingConfig{KindTweetRequest: {Msats: 3000}}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	node := "02" + strings.Repeat("ab", 32)
+	_, err = client.RunPipeline(ctx, Pipeline{
+		Steps: []PipelineStep{{DVM: d.GetPublicKey(), Kind: KindTweetRequest, MaxMsats: 3000,
+			Tags: nostr.Tags{{"param", "refund", node}}}},
+		Payer: wallet,
+	}, "20")
+	if err == nil || !strings.Contains(err.Error(), "refunded 3 sats") {
+		t.Fatalf("expected the failed job to be refunded, got %v", err)
+	}
+
+	wallet.mu.Lock()
+	sent := wallet.keysends[node]
+	wallet.mu.Unlock()
+	if sent != 3000 {
+		t.Errorf("expected 3000 msats keysent back, got %d", sent)
+	}
+	entries, _ := NewLedger(store).Entries(time.Time{}, time.Time{})
+	rows, _ := SummarizeEarnings(entries, ByJob)
+	if len(entries) != 2 || len(rows) != 1 || rows[0].Sats != 0 || rows[0].Jobs != 1 {
+		t.Errorf("expected the payment and its refund in the ledger, got %+v", entries)
+	}
+}
+
+func TestIsNodePubKey(t *testing.T) {
+	for s, want := range map[string]bool{
+		"02" + strings.Repeat("ab", 32): true,
+		"03" + strings.Repeat("AB", 32): true,
+		"04" + strings.Repeat("ab", 32): false,
+		strings.Repeat("ab", 32):        false,
+		"dvm@example.com":               false,
+	} {
+		if got := isNodePubKey(s); got != want {
+			t.Errorf("isNodePubKey(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
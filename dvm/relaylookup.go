@@ -0,0 +1,128 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RelayLookupConfig has the DVM look on its relays for a tweet result it,
+// or a peer DVM it trusts, already published before scraping the tweet,
+// making relays a cache shared between DVMs and across restarts. Results
+// are found by the ["i", <tweet ID>, "text"] tag tweet results carry, and
+// one is served if the tweet was fetched within MaxAge, going by its fetch
+// attestation if it has a valid one and when it was published if not. The
+// result served references the one it came from:
+//
+//	["e", <result id>, <relay>, "source"]
+//
+// NIP-90 results are regular events, so the lookup is by that tag rather
+// than by an addressable event per tweet, which would mean publishing each
+// result twice. Lookups are off with ResultsAsDMs.
+type RelayLookupConfig struct {
+	Peers   []string      // hex pubkeys of DVMs whose results are trusted; the DVM's own always are
+	MaxAge  time.Duration // how long ago the tweet may have been fetched, default 1h
+	Timeout time.Duration // how long to wait for the relays, default 5s
+}
+
+func (c RelayLookupConfig) withDefaults() RelayLookupConfig {
+	if c.MaxAge <= 0 {
+		c.MaxAge = time.Hour
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	return c
+}
+
+// validateRelayLookup checks the peers are valid pubkeys.
+func (d *Dvm) validateRelayLookup() error {
+	for _, peer := range d.relayLookup.Peers {
+		if !nostr.IsValidPublicKeyHex(peer) {
+			return fmt.Errorf("invalid relay lookup peer pubkey %q", peer)
+		}
+	}
+	return nil
+}
+
+// relayResult is a tweet result found on the relays.
+type relayResult struct {
+	content   []byte
+	event     *nostr.Event
+	relay     string
+	fetchedAt time.Time
+}
+
+// relayResultFor returns a result for tweetID found on the relays when
+// it isn't cached and relay lookups are configured, caching it as fetched
+// when it was. DM results are never looked up, since the lookup, and the
+// source tag on the result, would tell the relays what was asked for.
+func (d *Dvm) relayResultFor(ctx context.Context, tweetID string) *relayResult {
+	if d.relayLookup == nil || d.resultMode == ResultsAsDMs {
+		return nil
+	}
+	if _, ok := d.cache.fetchedAt(tweetID); ok {
+		return nil
+	}
+	found := d.lookupRelayResult(ctx, tweetID)
+	if found != nil {
+		d.cache.putAt(tweetID, found.content, found.fetchedAt)
+	}
+	return found
+}
+
+// lookupRelayResult returns the freshest trusted result for tweetID on
+// the DVM's relays, or nil if there's none fresh enough.
+func (d *Dvm) lookupRelayResult(ctx context.Context, tweetID string) *relayResult {
+	cfg := d.relayLookup
+	authors := append([]string(nil), cfg.Peers...)
+	for _, id := range d.identities {
+		authors = append(authors, id.pk)
+	}
+	oldest := time.Now().Add(-cfg.MaxAge)
+	since := nostr.Timestamp(oldest.Unix())
+	filter := nostr.Filter{
+		Kinds:   []int{ResultKind(KindTweetRequest)},
+		Authors: authors,
+		Tags:    nostr.TagMap{"i": {tweetID}},
+		Since:   &since,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	var best *relayResult
+	for _, f := range d.queryRelays(ctx, []nostr.Filter{filter}, nil, nil) {
+		found := relayResultOf(f.Event, tweetID)
+		if found == nil || found.fetchedAt.Before(oldest) {
+			continue
+		}
+		if best == nil || found.fetchedAt.After(best.fetchedAt) {
+			found.relay = f.Relays[0]
+			best = found
+		}
+	}
+	if best == nil {
+		metricRelayResultMisses.Add(1)
+		return nil
+	}
+	metricRelayResultHits.Add(1)
+	return best
+}
+
+// relayResultOf returns evt as the result for tweetID, or nil if it isn't
+// the whole tweet, as chunked, offloaded or rendered results aren't.
+func relayResultOf(evt *nostr.Event, tweetID string) *relayResult {
+	var tweet TweetResult
+	if err := json.Unmarshal([]byte(evt.Content), &tweet); err != nil || tweet.Tweet == nil || tweet.ID != tweetID {
+		return nil
+	}
+	found := &relayResult{content: []byte(evt.Content), event: evt, fetchedAt: evt.CreatedAt.Time()}
+	if att, err := FetchAttestationOf(evt); err == nil && att != nil && att.PubKey == evt.PubKey &&
+		att.Verify(KindTweetRequest, evt.Content) == nil {
+		found.fetchedAt = att.FetchedAt.Time()
+	}
+	return found
+}
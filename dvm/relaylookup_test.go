@@ -0,0 +1,112 @@
+package dvm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newTweetResult returns a tweet result for tweetID signed by sk, as a DVM
+// would publish it.
+func newTweetResult(sk, tweetID, text string, createdAt nostr.Timestamp) *nostr.Event {
+	content, _ := json.Marshal(TweetResult{Tweet: &twitterscraper.Tweet{ID: tweetID, Username: "halfin", Text: text}})
+	evt := &nostr.Event{
+		CreatedAt: createdAt,
+		Kind:      ResultKind(KindTweetRequest),
+		Tags:      nostr.Tags{{"i", tweetID, "text"}},
+		Content:   string(content),
+	}
+	evt.Sign(sk)
+	return evt
+}
+
+func TestRelayLookup(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	peer, stranger := testKey(), testKey()
+	peerPK, _ := nostr.GetPublicKey(peer)
+	fromPeer := newTweetResult(peer, "20", "from a peer", nostr.Now())
+	relay.Publish(fromPeer)
+	relay.Publish(newTweetResult(stranger, "21", "from a stranger", nostr.Now()))
+	relay.Publish(newTweetResult(peer, "22", "from a while ago", nostr.Now()-2*60*60))
+
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithRelayLookup(RelayLookupConfig{Peers: []string{peerPK}}))
+	tweetOf := func(resp *nostr.Event) *twitterscraper.Tweet {
+		var tweet twitterscraper.Tweet
+		if err := json.Unmarshal([]byte(resp.Content), &tweet); err != nil {
+			t.Fatalf("expected a tweet, got %q", resp.Content)
+		}
+		return &tweet
+	}
+
+	// A trusted peer's fresh result is served without scraping
+	req := newTestRequest("20")
+	relay.Publish(req)
+	resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if tweet := tweetOf(resp); tweet.Text != "from a peer" {
+		t.Errorf("expected the peer's result, got %q", tweet.Text)
+	}
+	if resp.Tags.GetFirst([]string{"e", fromPeer.ID, relay.URL(), "source"}) == nil {
+		t.Errorf("expected the result to reference the peer's, got %v", resp.Tags)
+	}
+	if scraper.Calls() != 0 {
+		t.Errorf("expected no scrape, got %d", scraper.Calls())
+	}
+
+	// Strangers' and stale results are not
+	for _, id := range []string{"21", "22"} {
+		req := newTestRequest(id)
+		relay.Publish(req)
+		resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+		if tweet := tweetOf(resp); tweet.Text != "Running bitcoin" {
+			t.Errorf("expected tweet %s scraped, got %q", id, tweet.Text)
+		}
+		if resp.Tags.GetFirst([]string{"i", id, "text"}) == nil {
+			t.Errorf("expected the result tagged with the tweet, got %v", resp.Tags)
+		}
+	}
+	if scraper.Calls() != 2 {
+		t.Errorf("expected 2 scrapes, got %d", scraper.Calls())
+	}
+
+	// The DVM's own results count too, with no cache of its own
+	req = newTestRequest("21")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if scraper.Calls() != 2 {
+		t.Errorf("expected the DVM's earlier result served, got %d scrapes", scraper.Calls())
+	}
+}
+
+func TestRelayLookupDMs(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	peer := testKey()
+	peerPK, _ := nostr.GetPublicKey(peer)
+	relay.Publish(newTweetResult(peer, "20", "from a peer", nostr.Now()))
+
+	// DM results' tags are in the clear, so they say nothing of the tweet
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithResultMode(ResultsAsDMs),
+		WithRelayLookup(RelayLookupConfig{Peers: []string{peerPK}}))
+	req := newTestRequest("20")
+	relay.Publish(req)
+	resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if resp.Kind != 4 {
+		t.Fatalf("expected a DM, got kind %d", resp.Kind)
+	}
+	for _, tag := range resp.Tags {
+		if tag.Key() == "i" || (tag.Key() == "e" && tag.Value() != req.ID) {
+			t.Errorf("expected no tag identifying the tweet, got %v", tag)
+		}
+	}
+	if scraper.Calls() != 1 {
+		t.Errorf("expected the tweet scraped rather than looked up, got %d scrapes", scraper.Calls())
+	}
+}
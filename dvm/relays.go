@@ -0,0 +1,169 @@
+package dvm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// relayDrainTimeout is how long a removed relay's connection is kept open
+// for publishes that were already under way.
+var relayDrainTimeout = 10 * time.Second
+
+// AddRelay adds a relay to the running DVM's pool. Results are published to
+// it from then on, and the request subscription can fail over to it. The
+// relay must accept a connection.
+func (d *Dvm) AddRelay(url string) error {
+	if !strings.HasPrefix(url, "wss://") && !strings.HasPrefix(url, "ws://") {
+		return fmt.Errorf("invalid relay URL %q", url)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.pool.add(ctx, url); err != nil {
+		return err
+	}
+	log.Printf("Added relay %s", url)
+	return nil
+}
+
+// RemoveRelay drains a relay from the running DVM's pool: nothing new is
+// published to it, the request subscription moves to another relay if it
+// was on this one, and publishes already under way get relayDrainTimeout
+// to finish before the connection is released. The last relay can't be
+// removed.
+func (d *Dvm) RemoveRelay(url string) error {
+	r, err := d.pool.remove(url)
+	if err != nil {
+		return err
+	}
+	select {
+	case d.relaysChanged <- struct{}{}:
+	default:
+	}
+	time.AfterFunc(relayDrainTimeout, r.release)
+	log.Printf("Removed relay %s", url)
+	return nil
+}
+
+// add connects to url and adds it to the pool.
+func (p *relayPool) add(ctx context.Context, url string) error {
+	if p.has(url) {
+		return fmt.Errorf("relay %s is already in use", url)
+	}
+	r := newPoolRelay(url, p.watcher)
+	if _, err := r.connect(ctx, true); err != nil {
+		r.release()
+		return fmt.Errorf("can't connect to %s: %w", url, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, existing := range p.relays {
+		if nostr.NormalizeURL(existing.url) == nostr.NormalizeURL(url) {
+			// Added concurrently
+			r.release()
+			return fmt.Errorf("relay %s is already in use", url)
+		}
+	}
+	p.relays = append(p.relays, r)
+	return nil
+}
+
+// remove takes url out of the pool, returning it for draining.
+func (p *relayPool) remove(url string) (*poolRelay, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, r := range p.relays {
+		if nostr.NormalizeURL(r.url) != nostr.NormalizeURL(url) {
+			continue
+		}
+		if len(p.relays) == 1 {
+			return nil, fmt.Errorf("can't remove %s, the only relay", url)
+		}
+		p.relays = append(p.relays[:i:i], p.relays[i+1:]...)
+		return r, nil
+	}
+	return nil, fmt.Errorf("relay %s is not in use", url)
+}
+
+// has reports whether url is in the pool.
+func (p *relayPool) has(url string) bool {
+	for _, r := range p.list() {
+		if nostr.NormalizeURL(r.url) == nostr.NormalizeURL(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdminCommand reports whether evt is a DM to the DVM from the alerts
+// admin.
+func (d *Dvm) isAdminCommand(evt *nostr.Event) bool {
+	if d.alerts == nil || evt.Kind != 4 || evt.PubKey != d.alerts.cfg.AdminPubKey {
+		return false
+	}
+	return verifyEvent(evt)
+}
+
+// handleAdminCommand runs a command the admin DMed the DVM and DMs back the
+// outcome. Commands are "relays", "relay add <url>", "relay remove <url>",
+// and with WithAbuseProtection "denylist", "deny <pubkey>" and
+// "undeny <pubkey>".
+func (d *Dvm) handleAdminCommand(evt *nostr.Event) {
+	primary := d.identities[0]
+	secret, err := nip04.ComputeSharedSecret(evt.PubKey, primary.sk)
+	if err != nil {
+		return
+	}
+	command, err := nip04.Decrypt(evt.Content, secret)
+	if err != nil {
+		log.Printf("Undecryptable admin DM %s: %v", evt.ID[:8], err)
+		return
+	}
+	log.Printf("Admin command: %s", command)
+
+	var reply string
+	switch fields := strings.Fields(command); {
+	case len(fields) == 1 && fields[0] == "relays":
+		var lines []string
+		for _, h := range d.RelayHealth() {
+			lines = append(lines, fmt.Sprintf("%s connected=%v score=%.2f", h.URL, h.Connected, h.Score))
+		}
+		reply = strings.Join(lines, "\n")
+	case len(fields) == 3 && fields[0] == "relay" && fields[1] == "add":
+		reply = "Added " + fields[2]
+		if err := d.AddRelay(fields[2]); err != nil {
+			reply = "Failed: " + err.Error()
+		}
+	case len(fields) == 3 && fields[0] == "relay" && fields[1] == "remove":
+		reply = "Removed " + fields[2]
+		if err := d.RemoveRelay(fields[2]); err != nil {
+			reply = "Failed: " + err.Error()
+		}
+	case len(fields) == 1 && fields[0] == "denylist":
+		reply = strings.Join(d.denylistLines(), "\n")
+		if reply == "" {
+			reply = "Nobody is denied"
+		}
+	case len(fields) == 2 && fields[0] == "deny":
+		reply = "Denied " + fields[1]
+		if err := d.Deny(fields[1], "denied by the admin"); err != nil {
+			reply = "Failed: " + err.Error()
+		}
+	case len(fields) == 2 && fields[0] == "undeny":
+		reply = "Undenied " + fields[1]
+		if err := d.Undeny(fields[1]); err != nil {
+			reply = "Failed: " + err.Error()
+		}
+	default:
+		reply = `Unknown command. Try "relays", "relay add <url>", "relay remove <url>", "denylist", "deny <pubkey>" or "undeny <pubkey>".`
+	}
+	if err := d.sendAlert(reply); err != nil {
+		log.Printf("Failed to reply to admin command: %v", err)
+	}
+}
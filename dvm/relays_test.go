@@ -0,0 +1,86 @@
+package dvm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+func TestAddAndRemoveRelays(t *testing.T) {
+	first := relaytest.NewServer()
+	defer first.Close()
+	second := relaytest.NewServer()
+	defer second.Close()
+	defer func(timeout time.Duration) { relayDrainTimeout = timeout }(relayDrainTimeout)
+	relayDrainTimeout = 0
+
+	d := startTestDvm(t, first, WithScraper(&fakeScraper{}))
+	if err := d.AddRelay(second.URL()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddRelay(second.URL()); err == nil {
+		t.Error("expected adding a relay twice to fail")
+	}
+
+	// Results go to the added relay too
+	req := newTestRequest("20")
+	first.Publish(req)
+	awaitResponse(t, second, d.GetPublicKey(), req.ID)
+
+	// Removing the subscription's relay moves the subscription, without
+	// restarting the DVM
+	if err := d.RemoveRelay(first.URL()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	req = newTestRequest("21")
+	second.Publish(req)
+	awaitResponse(t, second, d.GetPublicKey(), req.ID)
+
+	if err := d.RemoveRelay(second.URL()); err == nil {
+		t.Error("expected removing the last relay to fail")
+	}
+	if health := d.RelayHealth(); len(health) != 1 || health[0].URL != second.URL() {
+		t.Errorf("unexpected relays %+v", health)
+	}
+}
+
+func TestAdminRelayCommands(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	other := relaytest.NewServer()
+	defer other.Close()
+
+	adminSK := testKey()
+	adminPK, _ := nostr.GetPublicKey(adminSK)
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithAlerts(AlertConfig{AdminPubKey: adminPK}))
+	secret, _ := nip04.ComputeSharedSecret(d.GetPublicKey(), adminSK)
+
+	content, _ := nip04.Encrypt("relay add "+other.URL(), secret)
+	dm := nostr.Event{CreatedAt: nostr.Now(), Kind: 4, Tags: nostr.Tags{{"p", d.GetPublicKey()}}, Content: content}
+	dm.Sign(adminSK)
+	relay.Publish(&dm)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, evt := range relay.Events() {
+			if evt.Kind != 4 || evt.PubKey != d.GetPublicKey() {
+				continue
+			}
+			reply, _ := nip04.Decrypt(evt.Content, secret)
+			if !strings.HasPrefix(reply, "Added") {
+				t.Fatalf("unexpected reply %q", reply)
+			}
+			if len(d.RelayHealth()) != 2 {
+				t.Errorf("expected the relay to be added, got %+v", d.RelayHealth())
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("no reply to the admin command")
+}
@@ -0,0 +1,193 @@
+package dvm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Result formats a tweet request can ask for with an ["output", <mime>]
+// tag. JSON, a TweetResult, is the default.
+const (
+	OutputJSON     = "application/json"
+	OutputText     = "text/plain"
+	OutputMarkdown = "text/markdown"
+	OutputPNG      = "image/png" // sent as a base64 data URL
+)
+
+// requestedOutput returns the MIME type req's output tag asks for, without
+// parameters, or OutputJSON if it has none.
+func requestedOutput(req *nostr.Event) (string, error) {
+	tag := req.Tags.GetFirst([]string{"output"})
+	if tag == nil || len(*tag) < 2 || strings.TrimSpace((*tag)[1]) == "" {
+		return OutputJSON, nil
+	}
+	value := (*tag)[1]
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		// Some clients add flags without the ";", as in "application/json private"
+		mediaType = strings.ToLower(strings.Fields(value)[0])
+	}
+	switch mediaType {
+	case OutputJSON, OutputText, OutputMarkdown, OutputPNG:
+		return mediaType, nil
+	}
+	return "", fmt.Errorf("unsupported output %q", value)
+}
+
+// renderTweet renders a tweet result in the given output format, text and
+// markdown with templates, the defaults if nil; see TemplateConfig.
+func renderTweet(result *TweetResult, output string, templates tweetTemplates) ([]byte, error) {
+	if templates == nil {
+		templates = defaultTweetTemplates
+	}
+	switch output {
+	case OutputText, OutputMarkdown:
+		return templates.render(result, output)
+	case OutputPNG:
+		img, err := tweetCard(result)
+		if err != nil {
+			return nil, err
+		}
+		return []byte("data:image/png;base64," + base64.StdEncoding.EncodeToString(img)), nil
+	}
+	return nil, fmt.Errorf("unsupported output %q", output)
+}
+
+// tweetPhotos returns the URLs of the tweet's photos, preferring re-hosted
+// copies.
+func tweetPhotos(result *TweetResult) []string {
+	hosted := make(map[string]string)
+	for _, m := range result.HostedMedia {
+		if m.URL != "" {
+			hosted[m.Original] = m.URL
+		}
+	}
+	var urls []string
+	for _, p := range result.Photos {
+		if u, ok := hosted[p.URL]; ok {
+			urls = append(urls, u)
+		} else {
+			urls = append(urls, p.URL)
+		}
+	}
+	return urls
+}
+
+// tweetByline is the author as "Name (@username)".
+func tweetByline(result *TweetResult) string {
+	if result.Name == "" {
+		return "@" + result.Username
+	}
+	return fmt.Sprintf("%s (@%s)", result.Name, result.Username)
+}
+
+// tweetStats is the tweet's date and counts on one line.
+func tweetStats(result *TweetResult) string {
+	return tweetDate(result) + " · " + tweetCounts(result)
+}
+
+const (
+	cardScale   = 2  // pixels per font pixel
+	cardColumns = 48 // characters per line
+	cardPadding = 16
+	cardAdvance = 6 * cardScale // glyph plus a column of spacing
+	cardLeading = 10 * cardScale
+)
+
+// cardASCII replaces common typographic characters the card's font lacks.
+var cardASCII = strings.NewReplacer("‘", "'", "’", "'", "“", `"`, "”", `"`, "–", "-", "—", "-", "…", "...", "·", "-")
+
+// tweetCard draws the tweet as a PNG card in a small bitmap font. Only
+// ASCII is drawn; other characters show as "?".
+func tweetCard(result *TweetResult) ([]byte, error) {
+	wrap := func(s string) []string {
+		return wrapText(cardASCII.Replace(s), cardColumns)
+	}
+	lines := wrap(tweetByline(result))
+	bylineLines := len(lines)
+	lines = append(lines, "")
+	for _, paragraph := range strings.Split(strings.TrimSpace(result.Text), "\n") {
+		lines = append(lines, wrap(paragraph)...)
+	}
+	lines = append(lines, "")
+	statsFrom := len(lines)
+	lines = append(lines, wrap(tweetStats(result))...)
+
+	palette := color.Palette{color.White, color.Gray{0x20}, color.Gray{0x80}}
+	width := 2*cardPadding + cardColumns*cardAdvance
+	height := 2*cardPadding + len(lines)*cardLeading
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	for i, line := range lines {
+		ink := uint8(1)
+		if i < bylineLines || i >= statsFrom {
+			ink = 2 // byline and stats in grey
+		}
+		drawText(img, cardPadding, cardPadding+i*cardLeading, line, ink)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawText draws s with its top left at x, y in palette index ink.
+func drawText(img *image.Paletted, x, y int, s string, ink uint8) {
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		for row, bits := range glyphs[r-' '] {
+			for col := 0; col < 5; col++ {
+				if bits&(0x10>>col) == 0 {
+					continue
+				}
+				for dy := 0; dy < cardScale; dy++ {
+					for dx := 0; dx < cardScale; dx++ {
+						img.SetColorIndex(x+col*cardScale+dx, y+row*cardScale+dy, ink)
+					}
+				}
+			}
+		}
+		x += cardAdvance
+	}
+}
+
+// wrapText breaks s into lines of at most width characters, between words
+// where it can.
+func wrapText(s string, width int) []string {
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(s) {
+		w := []rune(word)
+		for len(w) > width {
+			if len(line) > 0 {
+				lines = append(lines, string(line))
+				line = nil
+			}
+			lines = append(lines, string(w[:width]))
+			w = w[width:]
+		}
+		if len(line) > 0 && len(line)+1+len(w) > width {
+			lines = append(lines, string(line))
+			line = nil
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, w...)
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
@@ -0,0 +1,88 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRequestedOutput(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                          OutputJSON,
+		"application/json":          OutputJSON,
+		"application/json private":  OutputJSON,
+		"text/plain; charset=utf-8": OutputText,
+		"text/markdown":             OutputMarkdown,
+		"IMAGE/PNG":                 OutputPNG,
+	} {
+		req := &nostr.Event{Tags: nostr.Tags{{"output", value}}}
+		if got, err := requestedOutput(req); err != nil || got != want {
+			t.Errorf("%q: got %q, %v; want %q", value, got, err, want)
+		}
+	}
+	if _, err := requestedOutput(&nostr.Event{Tags: nostr.Tags{{"output", "video/mp4"}}}); err == nil {
+		t.Error("expected an unsupported output to be rejected")
+	}
+}
+
+func TestTweetOutputs(t *testing.T) {
+	d := &Dvm{scraper: &fakeScraper{}, cache: newResultCache(1 << 20)}
+	h := tweetHandler{d: d}
+	render := func(output string) string {
+		t.Helper()
+		req := &nostr.Event{Content: "20", Tags: nostr.Tags{{"output", output}}}
+		if err := h.Validate(req); err != nil {
+			t.Fatal(err)
+		}
+		out, err := h.Handle(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	if text := render("text/plain"); !strings.HasPrefix(text, "@halfin\n\nRunning bitcoin\n\n") || !strings.Contains(text, "0 likes") {
+		t.Errorf("unexpected text rendering %q", text)
+	}
+	if md := render("text/markdown"); !strings.HasPrefix(md, "[@halfin](https://twitter.com/halfin)\n\n> Running bitcoin\n") {
+		t.Errorf("unexpected markdown rendering %q", md)
+	}
+
+	dataURL := render("image/png")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(dataURL, "data:image/png;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Byline, text and stats wrapped onto two lines, with blank lines between
+	if b := img.Bounds(); b.Dx() != 2*cardPadding+cardColumns*cardAdvance || b.Dy() != 2*cardPadding+6*cardLeading {
+		t.Errorf("unexpected card size %v", b)
+	}
+	inked := 0
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r < 0xffff {
+				inked++
+			}
+		}
+	}
+	if inked == 0 {
+		t.Error("expected text drawn on the card")
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	got := wrapText("the quick brown fox jumps over supercalifragilistic", 10)
+	want := []string{"the quick", "brown fox", "jumps over", "supercalif", "ragilistic"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
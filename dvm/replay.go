@@ -0,0 +1,93 @@
+package dvm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// replayedResult is a job's result kept in the result cache so the same
+// requester resubmitting the same job gets it again without the job
+// running; see WithResultReplay.
+type replayedResult struct {
+	At      time.Time  `json:"at"`
+	Content []byte     `json:"content"`
+	Tags    nostr.Tags `json:"tags,omitempty"`
+}
+
+// replayKey identifies a job by who asked which identity for what: the
+// request's kind, content and the NIP-90 tags that say what to do, but not
+// those, such as relays or expiration, that vary between submissions of
+// the same job.
+func replayKey(id *identity, req *nostr.Event) string {
+	var parts []string
+	for _, tag := range req.Tags {
+		if len(tag) == 0 {
+			continue
+		}
+		switch tag[0] {
+		case "i", "param", "output":
+			raw, _ := json.Marshal(tag)
+			parts = append(parts, string(raw))
+		}
+	}
+	sort.Strings(parts)
+	h := sha256.New()
+	for _, s := range append([]string{id.pk, req.PubKey, req.Content}, parts...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return "replay:" + strconv.Itoa(req.Kind) + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// rememberResult keeps a job's result for replaying to duplicates of req.
+func (d *Dvm) rememberResult(id *identity, req *nostr.Event, content []byte, tags nostr.Tags) {
+	if d.replayWindow <= 0 {
+		return
+	}
+	raw, err := json.Marshal(replayedResult{At: time.Now(), Content: content, Tags: tags})
+	if err != nil {
+		return
+	}
+	d.cache.put(replayKey(id, req), raw)
+}
+
+// replayResult publishes the remembered result of a duplicate of req as
+// req's result, reporting whether there was one recent enough.
+func (d *Dvm) replayResult(id *identity, req *nostr.Event) bool {
+	if d.replayWindow <= 0 {
+		return false
+	}
+	raw, ok := d.cache.get(replayKey(id, req))
+	if !ok {
+		return false
+	}
+	var prev replayedResult
+	if err := json.Unmarshal(raw, &prev); err != nil || time.Since(prev.At) > d.replayWindow {
+		return false
+	}
+
+	log.Printf("Replaying result from %s ago for duplicate request %s", time.Since(prev.At).Round(time.Second), req.ID[:8])
+	metricJobsReplayed.Add(1)
+	// Tagged with when the job actually ran, as the content dates from then
+	tags := append(prev.Tags, nostr.Tag{"replayed", strconv.FormatInt(prev.At.Unix(), 10)})
+	_, err := d.publishResult(id, req, prev.Content, tags, func(resp *nostr.Event, err error) {
+		d.alerts.jobDone(err)
+		if err == nil {
+			d.audit.record(req, resp)
+		}
+		d.hooks.fireDone(req, resp, err)
+	})
+	if err != nil {
+		d.alerts.jobDone(err)
+		d.hooks.fireError(req, err)
+		log.Printf("Giving up on replayed response for request %s: %v", req.ID[:8], err)
+	}
+	return true
+}
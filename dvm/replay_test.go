@@ -0,0 +1,74 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDuplicateRequestsAreReplayed(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithCache(1<<20), WithResultReplay(time.Minute))
+	sk := testKey()
+	replayed := metricJobsReplayed.Value()
+
+	first := newTestRequestFrom(sk, "20")
+	relay.Publish(first)
+	original := awaitResponse(t, relay, d.GetPublicKey(), first.ID)
+	if original.Tags.GetFirst([]string{"replayed"}) != nil {
+		t.Fatal("expected the first request to run")
+	}
+
+	// The same job again, resubmitted with a relays tag this time
+	again := &nostr.Event{CreatedAt: nostr.Now(), Kind: first.Kind, Content: first.Content,
+		Tags: nostr.Tags{{"relays", relay.URL()}}}
+	again.Sign(sk)
+	relay.Publish(again)
+	resp := awaitResponse(t, relay, d.GetPublicKey(), again.ID)
+	if resp.Tags.GetFirst([]string{"replayed"}) == nil {
+		t.Error("expected the duplicate to be answered with the earlier result")
+	}
+	if resp.Content != original.Content {
+		t.Errorf("replayed content differs: %q", resp.Content)
+	}
+	if resp.Tags.GetFirst([]string{"e", again.ID}) == nil {
+		t.Error("expected the replayed result to reference the new request")
+	}
+	if metricJobsReplayed.Value() != replayed+1 {
+		t.Error("expected the replay to be counted")
+	}
+
+	// Someone else asking for the same job runs it
+	other := newTestRequest("20")
+	relay.Publish(other)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), other.ID); resp.Tags.GetFirst([]string{"replayed"}) != nil {
+		t.Error("expected another requester's job to run")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithCache(1<<20), WithResultReplay(time.Second))
+	sk := testKey()
+
+	first := newTestRequestFrom(sk, "20")
+	relay.Publish(first)
+	awaitResponse(t, relay, d.GetPublicKey(), first.ID)
+
+	time.Sleep(1100 * time.Millisecond)
+	again := newTestRequestFrom(sk, "20")
+	relay.Publish(again)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), again.ID); resp.Tags.GetFirst([]string{"replayed"}) != nil {
+		t.Error("expected a result older than the window not to be replayed")
+	}
+}
+
+func TestResultReplayRequiresCache(t *testing.T) {
+	if _, err := NewDvm("ws://localhost:1", testKey(), WithResultReplay(time.Minute)); err == nil {
+		t.Error("expected replaying without the result cache to be rejected")
+	}
+}
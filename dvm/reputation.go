@@ -0,0 +1,181 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindLabel is the kind of NIP-32 label events.
+const KindLabel = 1985
+
+// ReputationNamespace is the NIP-32 namespace of the labels DvmClient
+// publishes rating the DVMs it used. Each label is "success" or "failure",
+// and tags the DVM, the job request and its kind:
+//
+//	["L", "bandita/reputation"]
+//	["l", "success", "bandita/reputation"]
+//	["p", <dvm pubkey>]
+//	["e", <job request id>]
+//	["k", <job request kind>]
+//	["latency", <milliseconds until the result>]
+//
+// Failures carry the error as their content.
+const ReputationNamespace = "bandita/reputation"
+
+// ClientOption configures a DvmClient.
+type ClientOption func(*DvmClient)
+
+// WithRatings makes the client publish a reputation label for every job it
+// requests, once it has the result or gives up. Jobs the caller cancels
+// aren't rated.
+func WithRatings() ClientOption {
+	return func(c *DvmClient) {
+		c.ratings = true
+	}
+}
+
+// rate publishes a reputation label, as who sent it, for the job request
+// req, answered in latency or failed with jobErr.
+func (c *DvmClient) rate(who *requester, req *nostr.Event, dvmPubKey string, latency time.Duration, jobErr error) {
+	if errors.Is(jobErr, context.Canceled) {
+		return
+	}
+	label := nostr.Event{
+		PubKey:    who.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      KindLabel,
+		Tags: nostr.Tags{
+			{"L", ReputationNamespace},
+			{"l", "success", ReputationNamespace},
+			{"p", dvmPubKey},
+			{"e", req.ID},
+			{"k", strconv.Itoa(req.Kind)},
+		},
+	}
+	if jobErr != nil {
+		label.Tags[1][1] = "failure"
+		label.Content = jobErr.Error()
+	} else {
+		label.Tags = append(label.Tags, nostr.Tag{"latency", strconv.FormatInt(latency.Milliseconds(), 10)})
+	}
+	if err := label.Sign(who.sk); err != nil {
+		log.Printf("Failed to sign reputation label: %v", err)
+		return
+	}
+	// The job's context may be what ran out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := who.relay.Publish(ctx, label); err != nil {
+		log.Printf("Failed to publish reputation label for %s: %v", dvmPubKey[:8], err)
+	}
+}
+
+// RankedDVM is a DVM found by DiscoverByKind, with its reputation.
+type RankedDVM struct {
+	PubKey       string
+	Capabilities *Capabilities
+	Successes    int
+	Failures     int
+	// MedianLatency is the median time to a successful result, or zero if
+	// there are none.
+	MedianLatency time.Duration
+	// Score is the estimated chance of a job succeeding, starting from
+	// an even chance for DVMs nobody has rated.
+	Score float64
+}
+
+// DiscoverByKind finds the DVMs advertising capabilities for kind and
+// ranks them by their reputation labels for it, best first. Labels from
+// any key count unless raters, hex pubkeys, names the ones to trust.
+func (c *DvmClient) DiscoverByKind(ctx context.Context, kind int, raters ...string) ([]RankedDVM, error) {
+	k := strconv.Itoa(kind)
+	adverts, err := c.relay.QuerySync(ctx, nostr.Filter{
+		Kinds: []int{KindHandlerInfo},
+		Tags:  nostr.TagMap{"d": {capabilitiesIdentifier}, "k": {k}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]*nostr.Event)
+	for _, evt := range verifiedEvents(adverts) {
+		if prev := latest[evt.PubKey]; prev == nil || evt.CreatedAt > prev.CreatedAt {
+			latest[evt.PubKey] = evt
+		}
+	}
+	if len(latest) == 0 {
+		return nil, nil
+	}
+
+	dvms := make(map[string]*RankedDVM, len(latest))
+	pubkeys := make([]string, 0, len(latest))
+	for pk, evt := range latest {
+		var caps Capabilities
+		if err := json.Unmarshal([]byte(evt.Content), &caps); err != nil || caps.Kind(kind) == nil {
+			continue
+		}
+		dvms[pk] = &RankedDVM{PubKey: pk, Capabilities: &caps}
+		pubkeys = append(pubkeys, pk)
+	}
+
+	filter := nostr.Filter{
+		Kinds: []int{KindLabel},
+		Tags:  nostr.TagMap{"L": {ReputationNamespace}, "p": pubkeys},
+	}
+	if len(raters) > 0 {
+		filter.Authors = raters
+	}
+	labels, err := c.relay.QuerySync(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	latencies := make(map[string][]time.Duration)
+	counted := make(map[string]bool) // one label per rater and job
+	for _, label := range verifiedEvents(labels) {
+		dvm := dvms[tagValue(label.Tags, "p")]
+		job := tagValue(label.Tags, "e")
+		if dvm == nil || tagValue(label.Tags, "k") != k || counted[label.PubKey+job] {
+			continue
+		}
+		counted[label.PubKey+job] = true
+		switch tagValue(label.Tags, "l") {
+		case "success":
+			dvm.Successes++
+			if ms, err := strconv.ParseInt(tagValue(label.Tags, "latency"), 10, 64); err == nil {
+				latencies[dvm.PubKey] = append(latencies[dvm.PubKey], time.Duration(ms)*time.Millisecond)
+			}
+		case "failure":
+			dvm.Failures++
+		}
+	}
+
+	ranked := make([]RankedDVM, 0, len(dvms))
+	for pk, dvm := range dvms {
+		// Laplace's rule of succession, so one lucky job doesn't beat a
+		// long record
+		dvm.Score = float64(dvm.Successes+1) / float64(dvm.Successes+dvm.Failures+2)
+		if l := latencies[pk]; len(l) > 0 {
+			sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+			dvm.MedianLatency = l[len(l)/2]
+		}
+		ranked = append(ranked, *dvm)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.MedianLatency != b.MedianLatency {
+			// Unknown latency sorts last
+			return b.MedianLatency == 0 || a.MedianLatency != 0 && a.MedianLatency < b.MedianLatency
+		}
+		return a.PubKey < b.PubKey
+	})
+	return ranked, nil
+}
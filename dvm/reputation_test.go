@@ -0,0 +1,63 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+func TestReputation(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	good := startTestDvm(t, relay, WithScraper(&fakeScraper{}))
+	bad := startTestDvm(t, relay, WithScraper(&failingScraper{err: errors.New("rate limited")}))
+	unrated := startTestDvm(t, relay, WithScraper(&fakeScraper{}))
+
+	client, err := NewDvmClient(relay.URL(), WithRatings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, id := range []string{"20", "21"} {
+		if _, err := client.RequestTweet(ctx, good.GetPublicKey(), id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.RequestTweet(ctx, bad.GetPublicKey(), id); err == nil {
+			t.Fatal("expected the failing DVM to fail")
+		}
+	}
+
+	ranked, err := client.DiscoverByKind(ctx, KindTweetRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranked) != 3 {
+		t.Fatalf("expected 3 DVMs, got %d", len(ranked))
+	}
+	if ranked[0].PubKey != good.GetPublicKey() || ranked[0].Successes != 2 || ranked[0].MedianLatency == 0 {
+		t.Errorf("expected the good DVM first, got %+v", ranked[0])
+	}
+	if ranked[1].PubKey != unrated.GetPublicKey() || ranked[1].Score != 0.5 {
+		t.Errorf("expected the unrated DVM second, got %+v", ranked[1])
+	}
+	if ranked[2].PubKey != bad.GetPublicKey() || ranked[2].Failures != 2 {
+		t.Errorf("expected the failing DVM last, got %+v", ranked[2])
+	}
+
+	// Only trusted raters count
+	other, _ := NewDvmClient(relay.URL())
+	ranked, err = client.DiscoverByKind(ctx, KindTweetRequest, other.pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dvm := range ranked {
+		if dvm.Successes+dvm.Failures != 0 {
+			t.Errorf("counted labels from an untrusted rater: %+v", dvm)
+		}
+	}
+}
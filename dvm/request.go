@@ -0,0 +1,63 @@
+package dvm
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Limits on the shape of incoming requests. No handler needs anything near
+// them; requests past them are garbage or hostile and are dropped before a
+// handler sees them.
+const (
+	maxRequestContent = 64 << 10 // bytes of content
+	maxRequestTags    = 100
+	maxTagValues      = 16      // elements of one tag, its name included
+	maxTagValueBytes  = 8 << 10 // bytes of one tag element
+)
+
+// checkRequest returns why evt is too malformed to hand to a job handler,
+// or nil. Handlers may then assume valid UTF-8 throughout, content and tag
+// values within the limits above, and that every tag has a name.
+func checkRequest(evt *nostr.Event) error {
+	if len(evt.Content) > maxRequestContent {
+		return fmt.Errorf("content is %d bytes, over the limit of %d", len(evt.Content), maxRequestContent)
+	}
+	if !utf8.ValidString(evt.Content) {
+		return fmt.Errorf("content is not valid UTF-8")
+	}
+	if len(evt.Tags) > maxRequestTags {
+		return fmt.Errorf("%d tags, over the limit of %d", len(evt.Tags), maxRequestTags)
+	}
+	for i, tag := range evt.Tags {
+		if len(tag) == 0 || tag[0] == "" {
+			return fmt.Errorf("tag %d has no name", i)
+		}
+		if len(tag) > maxTagValues {
+			return fmt.Errorf("%s tag has %d elements, over the limit of %d", tag[0], len(tag), maxTagValues)
+		}
+		for _, value := range tag {
+			if len(value) > maxTagValueBytes {
+				return fmt.Errorf("%s tag has a %d byte value, over the limit of %d", tag[0], len(value), maxTagValueBytes)
+			}
+			if !utf8.ValidString(value) {
+				return fmt.Errorf("%s tag is not valid UTF-8", tag[0])
+			}
+		}
+	}
+	return nil
+}
+
+// paramValue returns the first value of req's ["param", name, <value>...]
+// tag. Unlike Tags.GetFirst, it matches name exactly, so a "max" param
+// isn't read from a "max_results" tag, and skips param tags without a
+// value.
+func paramValue(req *nostr.Event, name string) (string, bool) {
+	for _, tag := range req.Tags {
+		if len(tag) >= 3 && tag[0] == "param" && tag[1] == name {
+			return tag[2], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,149 @@
+package dvm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCheckRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		evt     nostr.Event
+		wantErr bool
+	}{
+		{"ordinary", nostr.Event{Content: "20", Tags: nostr.Tags{{"p", "x"}, {"param", "max", "5"}}}, false},
+		{"oversized content", nostr.Event{Content: strings.Repeat("9", maxRequestContent+1)}, true},
+		{"binary content", nostr.Event{Content: "\x00\xff\xfe"}, true},
+		{"too many tags", nostr.Event{Tags: make(nostr.Tags, maxRequestTags+1)}, true},
+		{"empty tag", nostr.Event{Tags: nostr.Tags{{}}}, true},
+		{"nameless tag", nostr.Event{Tags: nostr.Tags{{"", "x"}}}, true},
+		{"long tag", nostr.Event{Tags: nostr.Tags{make(nostr.Tag, maxTagValues+1)}}, true},
+		{"huge tag value", nostr.Event{Tags: nostr.Tags{{"param", "x", strings.Repeat("a", maxTagValueBytes+1)}}}, true},
+		{"binary tag value", nostr.Event{Tags: nostr.Tags{{"param", "x", "\xff"}}}, true},
+	} {
+		if err := checkRequest(&tc.evt); (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v", tc.name, err)
+		}
+	}
+}
+
+func TestParamsMatchExactly(t *testing.T) {
+	req := &nostr.Event{Tags: nostr.Tags{{"param", "max_results", "9"}, {"param", "max"}, {"param", "max", "5"}}}
+	if value, ok := paramValue(req, "max"); !ok || value != "5" {
+		t.Errorf("expected max 5, got %q", value)
+	}
+	var params struct {
+		Max int `param:"max"`
+	}
+	if err := DecodeParams(req, &params); err != nil || params.Max != 5 {
+		t.Errorf("expected max 5, got %d (%v)", params.Max, err)
+	}
+}
+
+// fuzzParams has a field of every kind DecodeParams decodes.
+type fuzzParams struct {
+	S  string        `param:"s"`
+	B  bool          `param:"b"`
+	I  int8          `param:"i"`
+	F  float32       `param:"f"`
+	D  time.Duration `param:"d"`
+	SS []string      `param:"ss"`
+}
+
+// FuzzRequest feeds every default handler requests with arbitrary content
+// and tags, as JSON so tags can take any shape, checking that nothing
+// panics on what checkRequest lets through.
+//
+//	go test ./dvm -run '^$' -fuzz FuzzRequest
+func FuzzRequest(f *testing.F) {
+	for _, content := range malformedContents {
+		f.Add(content, []byte(`[["param","max","5"]]`))
+	}
+	f.Add("20", []byte(`[]`))
+	f.Add("@halfin", []byte(`[[],[""],["param"],["param","max"],["p"],["i","x","url"]]`))
+	f.Add("https://example.com/feed.xml", []byte(`[["param","limit","-1"],["param","d","1h"],["param","ss","a","b"]]`))
+	f.Add("npub1xyz", []byte(`[["param","handle","@x"],["param","proof","1e309"],["expiration","99999999999999999999"]]`))
+
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d, err := NewDvm(relay.URL(), testKey(), WithScraper(&fakeScraper{}))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, content string, tagsJSON []byte) {
+		var tags nostr.Tags
+		json.Unmarshal(tagsJSON, &tags)
+		for kind, handler := range d.handlers {
+			evt := &nostr.Event{CreatedAt: nostr.Now(), Kind: kind, Content: content, Tags: tags}
+			if checkRequest(evt) != nil {
+				return
+			}
+			handler.Validate(evt)
+			d.route(evt)
+			expiredAt(evt, time.Now())
+			var params fuzzParams
+			DecodeParams(evt, &params)
+		}
+	})
+}
+
+// benchRequest builds a request shaped like a typical client's: addressed
+// to a DVM, with an input, a few params and a relays tag.
+func benchRequest() *nostr.Event {
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindFollowsRequest, Content: "",
+		Tags: nostr.Tags{
+			{"i", "jack", "text"},
+			{"p", strings.Repeat("a", 64)},
+			{"relays", "wss://relay.damus.io", "wss://nos.lol"},
+			{"param", "list", "following"},
+			{"param", "max", "50"},
+			{"bid", "5000"},
+		}}
+	req.Sign(testKey())
+	return req
+}
+
+func BenchmarkCheckRequest(b *testing.B) {
+	req := benchRequest()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := checkRequest(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTagScan compares the exact-match tag lookups the job loop uses
+// with go-nostr's prefix-matching GetFirst.
+func BenchmarkTagScan(b *testing.B) {
+	req := benchRequest()
+	b.Run("paramValue", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, ok := paramValue(req, "max"); !ok {
+				b.Fatal("param not found")
+			}
+		}
+	})
+	b.Run("tagValue", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if tagValue(req.Tags, "bid") == "" {
+				b.Fatal("tag not found")
+			}
+		}
+	})
+	b.Run("GetFirst", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if req.Tags.GetFirst([]string{"bid"}) == nil {
+				b.Fatal("tag not found")
+			}
+		}
+	})
+}
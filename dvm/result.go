@@ -0,0 +1,77 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// resultAssembler turns the result events of one job back into the result
+// as the handler returned it: decrypting DMs, reassembling chunks and
+// fetching offloaded payloads.
+type resultAssembler struct {
+	client *DvmClient
+	sk     string // DM results are decrypted with, the client's own if empty
+	chunks resultChunks
+}
+
+// add takes one result event, returning the complete result once it has
+// everything.
+func (a *resultAssembler) add(ctx context.Context, e *nostr.Event) (string, bool, error) {
+	content := e.Content
+	if e.Kind == 4 {
+		var err error
+		if content, err = a.client.decrypt(e, a.sk); err != nil {
+			return "", false, fmt.Errorf("error decrypting result: %w", err)
+		}
+	}
+
+	// Results too big for relays come in chunks, or point at the full
+	// payload
+	if e.Tags.GetFirst([]string{"chunk"}) != nil {
+		whole, complete, err := a.chunks.add(e.Tags, content)
+		if err != nil {
+			return "", false, fmt.Errorf("error reassembling result: %w", err)
+		}
+		if !complete {
+			log.Printf("Received %d of %d result chunks", len(a.chunks.parts), a.chunks.total)
+			return "", false, nil
+		}
+		content = whole
+	}
+	if tag := e.Tags.GetFirst([]string{"offloaded"}); tag != nil {
+		if len(*tag) < 3 || (*tag)[1] == "" || (*tag)[2] == "" {
+			return "", false, fmt.Errorf("invalid offloaded tag %v", *tag)
+		}
+		log.Printf("Fetching offloaded result from %s", (*tag)[1])
+		var err error
+		if content, err = a.client.fetchOffloaded(ctx, (*tag)[1], (*tag)[2]); err != nil {
+			return "", false, fmt.Errorf("error fetching offloaded result: %w", err)
+		}
+	}
+	return content, true, nil
+}
+
+// Resolve returns the result carried by the result events of one job, as
+// if it had been published inline. It's for callers that find results
+// themselves, say by querying relays: events may be job results or DMs to
+// the client, and chunks may come in any order.
+func (c *DvmClient) Resolve(ctx context.Context, results []*nostr.Event) (string, error) {
+	if len(results) == 0 {
+		return "", errors.New("no result events")
+	}
+	a := resultAssembler{client: c}
+	for _, e := range results {
+		content, complete, err := a.add(ctx, e)
+		if err != nil {
+			return "", err
+		}
+		if complete {
+			return content, nil
+		}
+	}
+	return "", fmt.Errorf("missing %d of %d result chunks", a.chunks.total-len(a.chunks.parts), a.chunks.total)
+}
@@ -0,0 +1,97 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestResolve(t *testing.T) {
+	for _, mode := range []ResultMode{ResultsAsJobResults, ResultsAsDMs} {
+		relay := relaytest.NewServer()
+		d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithResultMode(mode), WithResultChunking(64))
+		requestTestTweet(t, relay, d, "20")
+
+		var chunks []*nostr.Event
+		for _, evt := range relay.Events() {
+			if evt.PubKey == d.GetPublicKey() && evt.Tags.GetFirst([]string{"chunk"}) != nil {
+				chunks = append(chunks, evt)
+			}
+		}
+		if len(chunks) < 2 {
+			t.Fatalf("mode %q: expected the tweet in several chunks, got %d", mode, len(chunks))
+		}
+
+		// Only the client that asked can decrypt DM results
+		client, err := NewDvmClient(relay.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode == ResultsAsDMs {
+			if _, err := client.Resolve(context.Background(), chunks); err == nil {
+				t.Errorf("expected a DM to someone else to be unreadable")
+			}
+			relay.Close()
+			continue
+		}
+
+		// In reverse, as relays don't promise any order
+		reversed := make([]*nostr.Event, len(chunks))
+		for i, evt := range chunks {
+			reversed[len(chunks)-1-i] = evt
+		}
+		content, err := client.Resolve(context.Background(), reversed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tweet struct{ Text string }
+		if err := json.Unmarshal([]byte(content), &tweet); err != nil || tweet.Text == "" {
+			t.Errorf("resolved %q, %v", content, err)
+		}
+
+		if _, err := client.Resolve(context.Background(), chunks[1:]); err == nil || !strings.Contains(err.Error(), "missing 1 of") {
+			t.Errorf("expected a missing chunk, got %v", err)
+		}
+		relay.Close()
+	}
+}
+
+func TestResolveOffloaded(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	blossom := newFakeBlossom(t)
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithUploads(UploadConfig{Provider: "blossom", Server: blossom.URL}), WithResultOffload(10))
+	requestTestTweet(t, relay, d, "20")
+
+	var result *nostr.Event
+	for _, evt := range relay.Events() {
+		if evt.Kind == ResultKind(KindTweetRequest) {
+			result = evt
+		}
+	}
+	if result == nil {
+		t.Fatal("no result published")
+	}
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := client.Resolve(context.Background(), []*nostr.Event{result})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content == result.Content || !json.Valid([]byte(content)) {
+		t.Errorf("expected the offloaded payload, got %q", content)
+	}
+
+	bad := *result
+	bad.Tags = nostr.Tags{{"offloaded", ""}}
+	if _, err := client.Resolve(context.Background(), []*nostr.Event{&bad}); err == nil {
+		t.Error("expected a malformed offloaded tag to be rejected")
+	}
+}
@@ -0,0 +1,102 @@
+package dvm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// OversizePolicy says what becomes of a result event over the DVM's
+// ResultLimit, which relays would reject, often without saying so.
+type OversizePolicy string
+
+const (
+	// OversizeFail sends the requester error feedback saying the result
+	// was too large. This is the default.
+	OversizeFail OversizePolicy = "fail"
+	// OversizeTruncate cuts the result's content to fit and tags the
+	// event ["truncated", <bytes of the full content>]. Truncated JSON is
+	// no longer valid JSON.
+	OversizeTruncate OversizePolicy = "truncate"
+	// OversizeOffload uploads the result to the upload host, as
+	// WithResultOffload does, which needs WithUploads.
+	OversizeOffload OversizePolicy = "offload"
+)
+
+// ResultLimit caps the size of the result events the DVM publishes.
+type ResultLimit struct {
+	MaxBytes int            // of a signed event's JSON, default 128 KiB, the most many relays accept
+	Policy   OversizePolicy // default OversizeFail
+}
+
+func (l ResultLimit) withDefaults() ResultLimit {
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = 128 << 10
+	}
+	if l.Policy == "" {
+		l.Policy = OversizeFail
+	}
+	return l
+}
+
+// errResultTooLarge is a result over the ResultLimit under OversizeFail.
+var errResultTooLarge = errors.New("result too large")
+
+// signedEventOverhead is what signing adds to an event's serialization:
+// its ID, signature and field names.
+const signedEventOverhead = 256
+
+// eventSize estimates the size of evt's JSON once signed.
+func eventSize(evt *nostr.Event) int {
+	return len(evt.Serialize()) + signedEventOverhead
+}
+
+// fitResult applies the DVM's OversizePolicy, other than offloading, to a
+// result event over the ResultLimit, returning the event to publish. resp
+// was built from content and tags by build, which makes the event for
+// some content, encrypting it if results are DMs.
+func (d *Dvm) fitResult(resp nostr.Event, content []byte, tags nostr.Tags, build func([]byte, nostr.Tags) (nostr.Event, error)) (*nostr.Event, error) {
+	size := eventSize(&resp)
+	if size <= d.resultLimit.MaxBytes {
+		return &resp, nil
+	}
+
+	if d.resultLimit.Policy == OversizeTruncate {
+		tags = append(append(nostr.Tags(nil), tags...), nostr.Tag{"truncated", strconv.Itoa(len(content))})
+		for size > d.resultLimit.MaxBytes && len(content) > 0 {
+			excess := size - d.resultLimit.MaxBytes
+			if resp.Kind == 4 {
+				// Encrypting base64-encodes, adding a third
+				excess = excess*3/4 + 1
+			}
+			content = truncateUTF8(content, len(content)-excess)
+			var err error
+			if resp, err = build(content, tags); err != nil {
+				return nil, err
+			}
+			size = eventSize(&resp)
+		}
+		// Still too big with no content at all, the tags are the problem
+		if size <= d.resultLimit.MaxBytes {
+			return &resp, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d bytes as an event, over the %d byte limit", errResultTooLarge, size, d.resultLimit.MaxBytes)
+}
+
+// truncateUTF8 cuts content to at most n bytes, not splitting a character.
+func truncateUTF8(content []byte, n int) []byte {
+	if n <= 0 {
+		return nil
+	}
+	if n >= len(content) {
+		return content
+	}
+	for n > 0 && !utf8.RuneStart(content[n]) {
+		n--
+	}
+	return content[:n]
+}
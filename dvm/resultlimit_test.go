@@ -0,0 +1,108 @@
+package dvm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// requestEcho asks d's echo handler for content, returning what d
+// published in reply: the result or error feedback.
+func requestEcho(t *testing.T, relay *relaytest.Server, d *Dvm, content string) *nostr.Event {
+	t.Helper()
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: 5998, Tags: nostr.Tags{}, Content: content}
+	req.Sign(testKey())
+	relay.Publish(req)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, evt := range relay.Events() {
+			if evt.PubKey != d.GetPublicKey() || evt.Tags.GetFirst([]string{"e", req.ID}) == nil {
+				continue
+			}
+			if evt.Kind == 6998 || evt.Tags.GetFirst([]string{"status", StatusError}) != nil {
+				return evt
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no result or error for request %s", req.ID[:8])
+	return nil
+}
+
+func TestOversizeResultFails(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithHandler(5998, echoHandler{}),
+		WithResultLimit(ResultLimit{MaxBytes: 8000}))
+	evt := requestEcho(t, relay, d, strings.Repeat("x", 6000))
+	if evt.Tags.GetFirst([]string{"status", StatusError, ReasonResultTooLarge}) == nil {
+		t.Fatalf("expected result-too-large feedback, got %+v", evt)
+	}
+
+	// Results within the limit are unaffected
+	evt = requestEcho(t, relay, d, "small")
+	if evt.Kind != 6998 || evt.Content != "small" {
+		t.Errorf("expected the small result, got %+v", evt)
+	}
+}
+
+func TestOversizeResultTruncated(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithHandler(5998, echoHandler{}),
+		WithResultLimit(ResultLimit{MaxBytes: 16000, Policy: OversizeTruncate}))
+	content := strings.Repeat("é", 4000)
+	evt := requestEcho(t, relay, d, content)
+	if evt.Kind != 6998 {
+		t.Fatalf("expected a result, got %+v", evt)
+	}
+	if size := len(evt.String()); size > 16000 {
+		t.Errorf("result is %d bytes, over the limit", size)
+	}
+	if tag := evt.Tags.GetFirst([]string{"truncated"}); tag == nil || (*tag)[1] != strconv.Itoa(len(content)) {
+		t.Errorf("expected a truncated tag with the full size, got %v", evt.Tags)
+	}
+	if len(evt.Content) == 0 || !strings.HasPrefix(content, evt.Content) {
+		t.Errorf("expected a prefix of the content, got %d bytes", len(evt.Content))
+	}
+}
+
+func TestOversizeResultOffloaded(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	blossom := newFakeBlossom(t)
+	defer blossom.Close()
+
+	d := startTestDvm(t, relay, WithHandler(5998, echoHandler{}),
+		WithUploads(UploadConfig{Provider: "blossom", Server: blossom.URL}),
+		WithResultLimit(ResultLimit{MaxBytes: 16000, Policy: OversizeOffload}))
+	evt := requestEcho(t, relay, d, strings.Repeat("x", 10000))
+	if evt.Kind != 6998 || evt.Tags.GetFirst([]string{"offloaded"}) == nil {
+		t.Fatalf("expected an offloaded result, got %+v", evt)
+	}
+}
+
+func TestResultLimitRequiresUploadsToOffload(t *testing.T) {
+	if _, err := NewDvm("ws://localhost:1", testKey(), WithResultLimit(ResultLimit{Policy: OversizeOffload})); err == nil {
+		t.Error("expected offloading without uploads to be rejected")
+	}
+	if _, err := NewDvm("ws://localhost:1", testKey(), WithResultLimit(ResultLimit{Policy: "shrink"})); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	if got := string(truncateUTF8([]byte("aé"), 2)); got != "a" {
+		t.Errorf("expected the split character dropped, got %q", got)
+	}
+	if got := string(truncateUTF8([]byte("abc"), 5)); got != "abc" {
+		t.Errorf("expected short content kept, got %q", got)
+	}
+}
@@ -0,0 +1,428 @@
+package dvm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/imperatrona/twitter-scraper"
+)
+
+// SandboxConfig runs the Twitter scraper in a worker process with limits
+// of its own, so a pathological page or a bug in the scraper can use up,
+// crash or hang only the worker, never the process holding the DVM's
+// keys. The worker is restarted on the next call after it dies.
+//
+// On Linux the worker's memory and CPU time are kernel limits (RLIMIT_DATA
+// and RLIMIT_CPU), and it runs at a lower priority than the DVM, so a busy
+// loop can neither take over the machine's cores nor run forever. Elsewhere
+// only its GC's memory limit, CPUs and CallTimeout apply.
+type SandboxConfig struct {
+	// Command runs the worker, which must call ServeScraperWorker; default
+	// this executable with the argument "scraper-worker"
+	Command     []string
+	MemoryBytes int64 // the worker's heap at most, default 512 MiB
+	CPUs        int   // cores the worker runs Go code on at once (GOMAXPROCS), default 1
+	// CPUTime is the CPU time a worker may use over its life, past which
+	// it's killed, and replaced on the next call; default 10m
+	CPUTime time.Duration
+	// Nice is the worker's scheduling niceness, 1 to 19, so the DVM comes
+	// first for the cores; default 10, negative to leave it as the DVM's
+	Nice        int
+	CallTimeout time.Duration // a call taking longer kills the worker, default 1m
+}
+
+func (c SandboxConfig) withDefaults() (SandboxConfig, error) {
+	if len(c.Command) == 0 {
+		exe, err := os.Executable()
+		if err != nil {
+			return c, fmt.Errorf("finding the scraper worker: %w", err)
+		}
+		c.Command = []string{exe, "scraper-worker"}
+	}
+	if c.MemoryBytes <= 0 {
+		c.MemoryBytes = 512 << 20
+	}
+	if c.CPUs <= 0 {
+		c.CPUs = 1
+	}
+	if c.CPUTime <= 0 {
+		c.CPUTime = 10 * time.Minute
+	}
+	if c.Nice == 0 {
+		c.Nice = 10
+	}
+	if c.Nice > 19 {
+		return c, fmt.Errorf("sandbox niceness %d is over 19", c.Nice)
+	}
+	if c.CallTimeout <= 0 {
+		c.CallTimeout = time.Minute
+	}
+	return c, nil
+}
+
+// These pass the worker its limits.
+const (
+	sandboxMemoryEnv  = "BANDITA_SANDBOX_MEMORY"
+	sandboxCPUsEnv    = "BANDITA_SANDBOX_CPUS"
+	sandboxCPUTimeEnv = "BANDITA_SANDBOX_CPU_SECONDS"
+	sandboxNiceEnv    = "BANDITA_SANDBOX_NICE"
+)
+
+// sandboxCall is a scraper call sent to the worker as a line of JSON.
+type sandboxCall struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method"`
+	Args   sandboxArgs `json:"args"`
+}
+
+// sandboxArgs are every method's arguments; each uses some.
+type sandboxArgs struct {
+	ID     string `json:"id,omitempty"`
+	User   string `json:"user,omitempty"`
+	Max    int    `json:"max,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// sandboxReply is the worker's answer to the call with the same ID.
+type sandboxReply struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Cursor string          `json:"cursor,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// SandboxedScraper is a scraper whose calls run in a worker process. It
+// serves tweets, profiles, timelines and follow lists, as
+// *twitterscraper.Scraper does.
+type SandboxedScraper struct {
+	cfg SandboxConfig
+
+	mu     sync.Mutex
+	worker *sandboxWorker // nil until started, and once it dies
+	nextID uint64
+	closed bool
+}
+
+// sandboxWorker is one run of the worker process.
+type sandboxWorker struct {
+	cmd     *exec.Cmd
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	pending map[uint64]chan sandboxReply
+	dead    chan struct{} // closed once the process exited
+	err     error         // why, once dead is closed
+}
+
+// NewSandboxedScraper returns a scraper running its calls in a worker
+// process, started on the first call.
+func NewSandboxedScraper(cfg SandboxConfig) (*SandboxedScraper, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return &SandboxedScraper{cfg: cfg}, nil
+}
+
+// Close stops the worker.
+func (s *SandboxedScraper) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.worker != nil {
+		s.worker.kill()
+		s.worker = nil
+	}
+	return nil
+}
+
+func (s *SandboxedScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	var tweet *twitterscraper.Tweet
+	_, err := s.call("GetTweet", sandboxArgs{ID: id}, &tweet)
+	return tweet, err
+}
+
+func (s *SandboxedScraper) GetProfile(username string) (twitterscraper.Profile, error) {
+	var profile twitterscraper.Profile
+	_, err := s.call("GetProfile", sandboxArgs{User: username}, &profile)
+	return profile, err
+}
+
+func (s *SandboxedScraper) FetchTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	var tweets []*twitterscraper.Tweet
+	next, err := s.call("FetchTweets", sandboxArgs{User: user, Max: maxTweetsNbr, Cursor: cursor}, &tweets)
+	return tweets, next, err
+}
+
+func (s *SandboxedScraper) FetchFollowers(user string, maxUsersNbr int, cursor string) ([]*twitterscraper.Profile, string, error) {
+	var profiles []*twitterscraper.Profile
+	next, err := s.call("FetchFollowers", sandboxArgs{User: user, Max: maxUsersNbr, Cursor: cursor}, &profiles)
+	return profiles, next, err
+}
+
+func (s *SandboxedScraper) FetchFollowing(user string, maxUsersNbr int, cursor string) ([]*twitterscraper.Profile, string, error) {
+	var profiles []*twitterscraper.Profile
+	next, err := s.call("FetchFollowing", sandboxArgs{User: user, Max: maxUsersNbr, Cursor: cursor}, &profiles)
+	return profiles, next, err
+}
+
+// call runs method in the worker, decoding its result into result and
+// returning its cursor, if any.
+func (s *SandboxedScraper) call(method string, args sandboxArgs, result any) (string, error) {
+	w, id, err := s.acquire()
+	if err != nil {
+		return "", err
+	}
+	replies := make(chan sandboxReply, 1)
+	w.mu.Lock()
+	w.pending[id] = replies
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.pending, id)
+		w.mu.Unlock()
+	}()
+
+	line, _ := json.Marshal(sandboxCall{ID: id, Method: method, Args: args})
+	w.writeMu.Lock()
+	_, err = w.stdin.Write(append(line, '\n'))
+	w.writeMu.Unlock()
+	if err != nil {
+		s.discard(w)
+		return "", fmt.Errorf("scraper worker: %w", err)
+	}
+
+	timer := time.NewTimer(s.cfg.CallTimeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replies:
+		if reply.Error != "" {
+			return "", errors.New(reply.Error)
+		}
+		if err := json.Unmarshal(reply.Result, result); err != nil {
+			return "", fmt.Errorf("scraper worker: invalid %s result: %w", method, err)
+		}
+		return reply.Cursor, nil
+	case <-w.dead:
+		s.discard(w)
+		return "", fmt.Errorf("scraper worker died: %v", w.err)
+	case <-timer.C:
+		log.Printf("Scraper worker took over %v on %s, restarting it", s.cfg.CallTimeout, method)
+		s.discard(w)
+		return "", fmt.Errorf("scraper timed out after %v", s.cfg.CallTimeout)
+	}
+}
+
+// acquire returns the running worker, starting one if there's none, and
+// an ID for a call.
+func (s *SandboxedScraper) acquire() (*sandboxWorker, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, 0, errors.New("scraper worker closed")
+	}
+	if s.worker != nil {
+		select {
+		case <-s.worker.dead:
+			s.worker = nil
+			metricScraperRestarts.Add(1)
+		default:
+		}
+	}
+	if s.worker == nil {
+		w, err := s.start()
+		if err != nil {
+			return nil, 0, err
+		}
+		s.worker = w
+	}
+	s.nextID++
+	return s.worker, s.nextID, nil
+}
+
+func (s *SandboxedScraper) start() (*sandboxWorker, error) {
+	cmd := exec.Command(s.cfg.Command[0], s.cfg.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GOMAXPROCS="+strconv.Itoa(s.cfg.CPUs),
+		sandboxMemoryEnv+"="+strconv.FormatInt(s.cfg.MemoryBytes, 10),
+		sandboxCPUsEnv+"="+strconv.Itoa(s.cfg.CPUs),
+		// Rounded up, so a limit under a second isn't none
+		sandboxCPUTimeEnv+"="+strconv.FormatInt(int64((s.cfg.CPUTime+time.Second-1)/time.Second), 10),
+		sandboxNiceEnv+"="+strconv.Itoa(s.cfg.Nice))
+	cmd.Stderr = os.Stderr // the worker's logs join ours
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting the scraper worker: %w", err)
+	}
+
+	w := &sandboxWorker{cmd: cmd, stdin: stdin, pending: make(map[uint64]chan sandboxReply), dead: make(chan struct{})}
+	go w.read(stdout)
+	return w, nil
+}
+
+// read hands the worker's replies to their calls until it exits.
+func (w *sandboxWorker) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		var reply sandboxReply
+		if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil {
+			log.Printf("Scraper worker sent garbage: %v", err)
+			continue
+		}
+		w.mu.Lock()
+		replies, ok := w.pending[reply.ID]
+		w.mu.Unlock()
+		if ok {
+			replies <- reply
+		}
+	}
+	w.stdin.Close()
+	w.err = w.cmd.Wait()
+	if w.err == nil {
+		w.err = errors.New("exited")
+	}
+	close(w.dead)
+}
+
+// discard kills w, so the next call starts a new worker.
+func (s *SandboxedScraper) discard(w *sandboxWorker) {
+	s.mu.Lock()
+	if s.worker == w {
+		s.worker = nil
+		metricScraperRestarts.Add(1)
+	}
+	s.mu.Unlock()
+	w.kill()
+}
+
+// kill ends the worker; its calls fail once it's gone.
+func (w *sandboxWorker) kill() {
+	w.cmd.Process.Kill()
+}
+
+// ServeScraperWorker is the worker process of a SandboxedScraper: it
+// applies the limits of its SandboxConfig to itself and uses Tor if the DVM
+// does, then serves scraper calls read from in, one JSON line each, writing
+// the replies to out, until in is closed.
+func ServeScraperWorker(in io.Reader, out io.Writer) error {
+	if proxy := os.Getenv(torProxyEnv); proxy != "" {
+		if err := UseTor(TorConfig{Proxy: proxy}); err != nil {
+			return err
+		}
+	}
+	if err := limitWorker(); err != nil {
+		return err
+	}
+	return serveScraper(in, out, twitterscraper.New())
+}
+
+// limitWorker applies the limits the DVM passed the worker.
+func limitWorker() error {
+	if limit, err := strconv.ParseInt(os.Getenv(sandboxMemoryEnv), 10, 64); err == nil && limit > 0 {
+		// The GC works hard short of the limit; past it, allocating fails
+		// and the worker dies
+		debug.SetMemoryLimit(limit * 3 / 4)
+		if err := limitMemory(limit); err != nil {
+			return fmt.Errorf("limiting memory: %w", err)
+		}
+	}
+	// GOMAXPROCS is set too, but a Command wrapping the worker could drop it
+	if cpus, err := strconv.Atoi(os.Getenv(sandboxCPUsEnv)); err == nil && cpus > 0 {
+		runtime.GOMAXPROCS(cpus)
+	}
+	if seconds, err := strconv.ParseUint(os.Getenv(sandboxCPUTimeEnv), 10, 64); err == nil && seconds > 0 {
+		if err := limitCPUTime(seconds); err != nil {
+			return fmt.Errorf("limiting CPU time: %w", err)
+		}
+	}
+	if nice, err := strconv.Atoi(os.Getenv(sandboxNiceEnv)); err == nil && nice > 0 {
+		if err := setNice(nice); err != nil {
+			return fmt.Errorf("lowering priority: %w", err)
+		}
+	}
+	return nil
+}
+
+// sandboxBackend is what the worker serves calls with.
+type sandboxBackend interface {
+	TweetScraper
+	ProfileScraper
+	TimelineScraper
+	FollowsScraper
+}
+
+func serveScraper(in io.Reader, out io.Writer, backend sandboxBackend) error {
+	var writeMu sync.Mutex
+	reply := func(r sandboxReply) {
+		line, _ := json.Marshal(r)
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		out.Write(append(line, '\n'))
+	}
+
+	// Calls still running when in closes get their replies before we return
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var call sandboxCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return fmt.Errorf("invalid call: %w", err)
+		}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			result, cursor, err := runSandboxCall(backend, call)
+			r := sandboxReply{ID: call.ID, Cursor: cursor}
+			if err == nil {
+				r.Result, err = json.Marshal(result)
+			}
+			if err != nil {
+				r.Error = err.Error()
+			}
+			reply(r)
+		}()
+	}
+	return scanner.Err()
+}
+
+func runSandboxCall(backend sandboxBackend, call sandboxCall) (result any, cursor string, err error) {
+	args := call.Args
+	switch call.Method {
+	case "GetTweet":
+		result, err = backend.GetTweet(args.ID)
+	case "GetProfile":
+		result, err = backend.GetProfile(args.User)
+	case "FetchTweets":
+		result, cursor, err = backend.FetchTweets(args.User, args.Max, args.Cursor)
+	case "FetchFollowers":
+		result, cursor, err = backend.FetchFollowers(args.User, args.Max, args.Cursor)
+	case "FetchFollowing":
+		result, cursor, err = backend.FetchFollowing(args.User, args.Max, args.Cursor)
+	default:
+		err = fmt.Errorf("unknown method %q", call.Method)
+	}
+	return result, cursor, err
+}
@@ -0,0 +1,39 @@
+package dvm
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// limitMemory caps the process's data segment, which holds the Go heap,
+// at limit bytes.
+func limitMemory(limit int64) error {
+	return syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: uint64(limit), Max: uint64(limit)})
+}
+
+// limitCPUTime has the kernel kill the process once it has used seconds
+// of CPU time. The soft limit's SIGXCPU is ignored by Go programs, so the
+// hard limit's SIGKILL is the one that counts.
+func limitCPUTime(seconds uint64) error {
+	return syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: seconds, Max: seconds})
+}
+
+// setNice sets the niceness of each of the process's threads, since Linux
+// keeps one per thread; threads started later take their creator's.
+func setNice(nice int) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
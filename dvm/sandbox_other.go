@@ -0,0 +1,20 @@
+//go:build !linux
+
+package dvm
+
+// limitMemory is a no-op off Linux, where the worker's memory is bounded
+// only by its GC's soft limit.
+func limitMemory(limit int64) error {
+	return nil
+}
+
+// limitCPUTime is a no-op off Linux, where only CallTimeout bounds a busy
+// worker.
+func limitCPUTime(seconds uint64) error {
+	return nil
+}
+
+// setNice is a no-op off Linux.
+func setNice(nice int) error {
+	return nil
+}
@@ -0,0 +1,162 @@
+package dvm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imperatrona/twitter-scraper"
+)
+
+// sandboxBackendEnv makes the test binary a scraper worker; see
+// TestSandboxWorkerProcess.
+const sandboxBackendEnv = "BANDITA_TEST_SANDBOX_WORKER"
+
+// workerScraper is the backend of the test's worker processes. Some tweet
+// IDs misbehave.
+type workerScraper struct{}
+
+func (workerScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	switch id {
+	case "hang":
+		select {}
+	case "crash":
+		os.Exit(3)
+	case "gone":
+		return nil, errors.New("tweet not found")
+	case "burn":
+		for {
+		}
+	case "procs":
+		return &twitterscraper.Tweet{ID: id, Text: fmt.Sprint(runtime.GOMAXPROCS(0))}, nil
+	case "hog":
+		// Past the worker's memory limit
+		var hog [][]byte
+		for {
+			hog = append(hog, make([]byte, 16<<20))
+			for i := 0; i < len(hog[len(hog)-1]); i += 4096 {
+				hog[len(hog)-1][i] = 1
+			}
+		}
+	}
+	return &twitterscraper.Tweet{ID: id, Text: "tweet " + id, Username: fmt.Sprint(os.Getpid())}, nil
+}
+
+func (workerScraper) GetProfile(username string) (twitterscraper.Profile, error) {
+	return twitterscraper.Profile{Username: username}, nil
+}
+
+func (workerScraper) FetchTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	return []*twitterscraper.Tweet{{ID: "1", Username: user}}, cursor + "+", nil
+}
+
+func (workerScraper) FetchFollowers(user string, maxUsersNbr int, cursor string) ([]*twitterscraper.Profile, string, error) {
+	return []*twitterscraper.Profile{{Username: "follower"}}, "", nil
+}
+
+func (workerScraper) FetchFollowing(user string, maxUsersNbr int, cursor string) ([]*twitterscraper.Profile, string, error) {
+	return nil, "", nil
+}
+
+// TestSandboxWorkerProcess isn't a test: it's the worker process the
+// other tests start, serving workerScraper.
+func TestSandboxWorkerProcess(t *testing.T) {
+	if os.Getenv(sandboxBackendEnv) == "" {
+		t.Skip("only run as a scraper worker")
+	}
+	if err := limitWorker(); err != nil {
+		t.Fatal(err)
+	}
+	serveScraper(os.Stdin, os.Stdout, workerScraper{})
+	os.Exit(0)
+}
+
+func newTestSandbox(t *testing.T, cfg SandboxConfig) *SandboxedScraper {
+	t.Helper()
+	t.Setenv(sandboxBackendEnv, "1")
+	t.Setenv("GOTRACEBACK", "none") // the hog's out of memory crash is expected
+	cfg.Command = []string{os.Args[0], "-test.run=^TestSandboxWorkerProcess$"}
+	s, err := NewSandboxedScraper(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSandboxedScraper(t *testing.T) {
+	s := newTestSandbox(t, SandboxConfig{CallTimeout: 2 * time.Second})
+
+	tweet, err := s.GetTweet("20")
+	if err != nil || tweet.Text != "tweet 20" {
+		t.Fatalf("GetTweet = %+v, %v", tweet, err)
+	}
+	if tweet.Username == fmt.Sprint(os.Getpid()) {
+		t.Error("expected the tweet to be fetched in another process")
+	}
+	if _, err := s.GetTweet("gone"); err == nil || err.Error() != "tweet not found" {
+		t.Errorf("expected the scraper's error, got %v", err)
+	}
+	if tweets, cursor, err := s.FetchTweets("jack", 10, "a"); err != nil || len(tweets) != 1 || cursor != "a+" {
+		t.Errorf("FetchTweets = %v, %q, %v", tweets, cursor, err)
+	}
+	if profile, err := s.GetProfile("jack"); err != nil || profile.Username != "jack" {
+		t.Errorf("GetProfile = %+v, %v", profile, err)
+	}
+}
+
+func TestSandboxedScraperRecovers(t *testing.T) {
+	memory := int64(256 << 20)
+	if raceEnabled {
+		// The race detector's runtime can't start in that little
+		memory = 1 << 30
+	}
+	s := newTestSandbox(t, SandboxConfig{CallTimeout: 500 * time.Millisecond, MemoryBytes: memory})
+	restarts := metricScraperRestarts.Value()
+
+	for _, id := range []string{"crash", "hang", "hog"} {
+		start := time.Now()
+		if _, err := s.GetTweet(id); err == nil {
+			t.Errorf("%s: expected an error", id)
+		} else if id == "hang" && !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("%s: expected a timeout, got %v", id, err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%s: took %v", id, time.Since(start))
+		}
+		// Each time a fresh worker takes over
+		if tweet, err := s.GetTweet("20"); err != nil || tweet.Text != "tweet 20" {
+			t.Fatalf("%s: expected the worker to be restarted, got %+v, %v", id, tweet, err)
+		}
+	}
+	if got := metricScraperRestarts.Value() - restarts; got != 3 {
+		t.Errorf("expected 3 restarts, got %d", got)
+	}
+}
+
+func TestSandboxedScraperCPULimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("CPU time is only limited on Linux")
+	}
+	s := newTestSandbox(t, SandboxConfig{CPUs: 2, CPUTime: time.Second, CallTimeout: 30 * time.Second})
+	if tweet, err := s.GetTweet("procs"); err != nil || tweet.Text != "2" {
+		t.Errorf("expected the worker to run on 2 cores, got %+v, %v", tweet, err)
+	}
+
+	// A busy loop is killed once it has used up its CPU time, long before
+	// the call times out
+	start := time.Now()
+	if _, err := s.GetTweet("burn"); err == nil {
+		t.Error("expected the busy worker to be killed")
+	}
+	if took := time.Since(start); took > 15*time.Second {
+		t.Errorf("expected the CPU limit to stop the worker, took %v", took)
+	}
+	if tweet, err := s.GetTweet("20"); err != nil || tweet.Text != "tweet 20" {
+		t.Errorf("expected the worker to be restarted, got %+v, %v", tweet, err)
+	}
+}
@@ -0,0 +1,183 @@
+package dvm
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// jsonSchemaDialect is the JSON Schema version params schemas are written in.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// durationPattern matches durations time.ParseDuration accepts, such as
+// 30s or 1h30m.
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// JSONSchema is the subset of JSON Schema used to describe a kind's params.
+// A request's ["param", <name>, <value>] tags are validated as the object
+// {<name>: <value>}, with each value read as the JSON type its property
+// declares: ["param", "max", "50"] is {"max": 50}.
+type JSONSchema struct {
+	Schema      string                 `json:"$schema,omitempty"`
+	Type        string                 `json:"type,omitempty"` // "object", "string", "integer" or "boolean"
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Pattern     string                 `json:"pattern,omitempty"`
+	Minimum     *int64                 `json:"minimum,omitempty"`
+	Maximum     *int64                 `json:"maximum,omitempty"`
+	Default     any                    `json:"default,omitempty"`
+}
+
+// ParamsSchema returns the JSON Schema for requests carrying params.
+// Params not in it are allowed, as DecodeParams ignores them.
+func ParamsSchema(params []ParamSpec) *JSONSchema {
+	schema := &JSONSchema{Schema: jsonSchemaDialect, Type: "object", Properties: make(map[string]*JSONSchema)}
+	for _, p := range params {
+		prop := &JSONSchema{Type: "string", Enum: p.Values, Minimum: p.Min, Maximum: p.Max}
+		switch p.Type {
+		case "boolean", "integer":
+			prop.Type = p.Type
+		case "duration":
+			prop.Description = "a duration such as 30s or 1h"
+			prop.Pattern = durationPattern
+		case "language":
+			prop.Description = "a language code such as en or pt-BR"
+			prop.Pattern = languageCode.String()
+		}
+		if p.Default != "" {
+			prop.Default = p.Default
+			if v, err := prop.decode(p.Default); err == nil {
+				prop.Default = v
+			}
+		}
+		schema.Properties[p.Name] = prop
+	}
+	return schema
+}
+
+// ValidateParams checks req's param tags against the schema, returning a
+// ParamsError listing every problem, or nil if there are none.
+func (s *JSONSchema) ValidateParams(req *nostr.Event) error {
+	var problems []string
+	seen := make(map[string]bool)
+	for _, tag := range req.Tags {
+		if len(tag) < 2 || tag[0] != "param" {
+			continue
+		}
+		name := tag[1]
+		prop, ok := s.Properties[name]
+		if !ok {
+			continue
+		}
+		if seen[name] {
+			problems = append(problems, fmt.Sprintf("%s: given more than once", name))
+			continue
+		}
+		seen[name] = true
+		if len(tag) != 3 {
+			problems = append(problems, fmt.Sprintf("%s: must have exactly one value, got %d", name, len(tag)-2))
+			continue
+		}
+		if err := prop.validate(tag[2]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ParamsError{Problems: problems}
+}
+
+// ParamsError is a request's params failing validation.
+type ParamsError struct {
+	Problems []string // one per param, such as `max: must be at most 3200, got 5000`
+}
+
+func (e *ParamsError) Error() string {
+	return "invalid params: " + strings.Join(e.Problems, "; ")
+}
+
+// decode reads a param value as the property's JSON type.
+func (s *JSONSchema) decode(value string) (any, error) {
+	switch s.Type {
+	case "boolean":
+		if value != "true" && value != "false" {
+			return nil, fmt.Errorf("must be true or false, got %q", value)
+		}
+		return value == "true", nil
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a whole number, got %q", value)
+		}
+		return n, nil
+	}
+	return value, nil
+}
+
+// validate checks one param value against the property.
+func (s *JSONSchema) validate(value string) error {
+	v, err := s.decode(value)
+	if err != nil {
+		return err
+	}
+	if n, ok := v.(int64); ok {
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("must be at least %d, got %d", *s.Minimum, n)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("must be at most %d, got %d", *s.Maximum, n)
+		}
+	}
+	if len(s.Enum) > 0 && !containsString(s.Enum, value) {
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(s.Enum, ", "), value)
+	}
+	if s.Pattern != "" {
+		re, err := compilePattern(s.Pattern)
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value) {
+			if s.Description != "" {
+				return fmt.Errorf("must be %s, got %q", s.Description, value)
+			}
+			return fmt.Errorf("must match %s, got %q", s.Pattern, value)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// compiledPatterns caches the regexps of schema patterns, which come from
+// a handful of schemas built at startup.
+var compiledPatterns = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	compiledPatterns.Lock()
+	defer compiledPatterns.Unlock()
+	if re, ok := compiledPatterns.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema pattern %q: %w", pattern, err)
+	}
+	compiledPatterns.m[pattern] = re
+	return re, nil
+}
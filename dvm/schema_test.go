@@ -0,0 +1,100 @@
+package dvm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestValidateParams(t *testing.T) {
+	schema := ParamsSchema(append(builtinCapabilities[KindFollowsRequest].Params,
+		ParamSpec{Name: "ocr", Type: "boolean"},
+		ParamSpec{Name: "interval", Type: "duration"},
+		ParamSpec{Name: "language", Type: "language"},
+	))
+	for _, tc := range []struct {
+		tags    nostr.Tags
+		problem string // empty if valid
+	}{
+		{nil, ""},
+		{nostr.Tags{{"param", "max", "50"}, {"param", "list", "following"}}, ""},
+		{nostr.Tags{{"param", "unknown", "whatever"}}, ""},
+		{nostr.Tags{{"param", "interval", "1h30m"}, {"param", "language", "pt-BR"}, {"param", "ocr", "true"}}, ""},
+		{nostr.Tags{{"param", "max", "fifty"}}, `max: must be a whole number, got "fifty"`},
+		{nostr.Tags{{"param", "max", "0"}}, "max: must be at least 1"},
+		{nostr.Tags{{"param", "max", "1000000"}}, "max: must be at most"},
+		{nostr.Tags{{"param", "list", "friends"}}, `list: must be one of followers, following, got "friends"`},
+		{nostr.Tags{{"param", "ocr", "yes"}}, "ocr: must be true or false"},
+		{nostr.Tags{{"param", "interval", "soon"}}, "interval: must be a duration"},
+		{nostr.Tags{{"param", "language", "english"}}, "language: must be a language code"},
+		{nostr.Tags{{"param", "max", "5"}, {"param", "max", "6"}}, "max: given more than once"},
+		{nostr.Tags{{"param", "max", "5", "6"}}, "max: must have exactly one value, got 2"},
+	} {
+		err := schema.ValidateParams(&nostr.Event{Tags: tc.tags})
+		switch {
+		case tc.problem == "" && err != nil:
+			t.Errorf("%v: unexpected error %v", tc.tags, err)
+		case tc.problem != "" && (err == nil || !strings.Contains(err.Error(), tc.problem)):
+			t.Errorf("%v: expected %q, got %v", tc.tags, tc.problem, err)
+		}
+	}
+
+	// Every problem is reported, not just the first
+	err := schema.ValidateParams(&nostr.Event{Tags: nostr.Tags{{"param", "max", "0"}, {"param", "list", "friends"}}})
+	if pe, ok := err.(*ParamsError); !ok || len(pe.Problems) != 2 {
+		t.Errorf("expected two problems, got %v", err)
+	}
+}
+
+func TestParamsSchemaJSON(t *testing.T) {
+	raw, err := json.Marshal(ParamsSchema(builtinCapabilities[KindUserArchiveRequest].Params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	json.Unmarshal(raw, &schema)
+	if schema["$schema"] != jsonSchemaDialect || schema["type"] != "object" {
+		t.Errorf("unexpected schema %s", raw)
+	}
+	max := schema["properties"].(map[string]any)["max"].(map[string]any)
+	if max["type"] != "integer" || max["default"] != float64(defaultUserArchiveTweets) || max["maximum"] != float64(maxUserArchiveTweets) {
+		t.Errorf("unexpected max property %v", max)
+	}
+}
+
+func TestInvalidParamsFeedback(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper))
+
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindTweetRequest, Content: "20",
+		Tags: nostr.Tags{{"param", "ocr", "maybe"}}}
+	req.Sign(testKey())
+	relay.Publish(req)
+
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Kind != KindJobFeedback || fb.Tags.GetFirst([]string{"status", StatusError, ReasonInvalidParams}) == nil {
+		t.Fatalf("expected invalid params feedback, got %+v", fb)
+	}
+	if !strings.Contains(fb.Content, `ocr: must be true or false, got "maybe"`) {
+		t.Errorf("feedback doesn't say what's wrong: %q", fb.Content)
+	}
+	if scraper.Calls() != 0 {
+		t.Error("expected the invalid request not to be scraped")
+	}
+}
+
+func BenchmarkValidateParams(b *testing.B) {
+	schema := ParamsSchema(builtinCapabilities[KindFollowsRequest].Params)
+	req := benchRequest()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := schema.ValidateParams(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
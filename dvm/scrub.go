@@ -0,0 +1,124 @@
+package dvm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// Scrubber keeps personal data out of the DVM's logs and exports: a
+// requester's pubkey becomes a pseudonym, a keyed hash that still tells
+// one requester's lines from another's but can't be matched against known
+// pubkeys without the salt, and what they asked for isn't written at all.
+// A nil *Scrubber leaves everything as it is.
+type Scrubber struct {
+	key []byte
+}
+
+// NewScrubber returns a Scrubber whose pseudonyms are keyed by salt. Set
+// the same salt to correlate logs and exports across restarts; with an
+// empty one a random salt is used, so pseudonyms change with each run.
+func NewScrubber(salt string) (*Scrubber, error) {
+	key := []byte(salt)
+	if salt == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Scrubber{key: key}, nil
+}
+
+// PubKey returns the pseudonym of pubkey, or pubkey itself if s is nil.
+func (s *Scrubber) PubKey(pubkey string) string {
+	if s == nil {
+		return pubkey
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(pubkey))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// logPubKey returns how pubkey appears in a log line: shortened, or its
+// pseudonym.
+func (s *Scrubber) logPubKey(pubkey string) string {
+	if s == nil {
+		if len(pubkey) > 8 {
+			return pubkey[:8]
+		}
+		return pubkey
+	}
+	return s.PubKey(pubkey)
+}
+
+// logContent returns how a request's input, or what was fetched for it,
+// appears in a log line: a snippet, or just its size.
+func (s *Scrubber) logContent(content string) string {
+	if s == nil {
+		return logSnippet(content)
+	}
+	return fmt.Sprintf("[%d bytes redacted]", len(content))
+}
+
+// LedgerEntries returns entries with their requesters replaced by
+// pseudonyms, for exporting.
+func (s *Scrubber) LedgerEntries(entries []LedgerEntry) []LedgerEntry {
+	if s == nil {
+		return entries
+	}
+	scrubbed := make([]LedgerEntry, len(entries))
+	for i, e := range entries {
+		e.Requester = s.PubKey(e.Requester)
+		scrubbed[i] = e
+	}
+	return scrubbed
+}
+
+// nsecPattern matches NIP-19 private keys.
+var nsecPattern = regexp.MustCompile(`nsec1[02-9ac-hj-np-z]{58}`)
+
+// KeyRedactor is a writer, meant for the log output, that redacts private
+// keys before they reach w: any nsec, and the hex keys it's told about
+// with Add, so a key never ends up in a log however it was printed.
+type KeyRedactor struct {
+	w io.Writer
+
+	mu   sync.RWMutex
+	keys [][]byte
+}
+
+// NewKeyRedactor returns a KeyRedactor writing to w.
+func NewKeyRedactor(w io.Writer) *KeyRedactor {
+	return &KeyRedactor{w: w}
+}
+
+// Add redacts the hex private key sk, in either case, from then on.
+func (r *KeyRedactor) Add(sk string) {
+	if sk == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, bytes.ToLower([]byte(sk)), bytes.ToUpper([]byte(sk)))
+}
+
+// Write writes p with the keys in it redacted. The log package writes a
+// line at a time, so a key is never split across writes.
+func (r *KeyRedactor) Write(p []byte) (int, error) {
+	out := nsecPattern.ReplaceAll(p, []byte("nsec1[redacted]"))
+	r.mu.RLock()
+	for _, key := range r.keys {
+		out = bytes.ReplaceAll(out, key, []byte("[redacted key]"))
+	}
+	r.mu.RUnlock()
+	if _, err := r.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
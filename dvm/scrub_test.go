@@ -0,0 +1,74 @@
+package dvm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestScrubber(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(testKey())
+	a, _ := NewScrubber("salt")
+	b, _ := NewScrubber("salt")
+	other, _ := NewScrubber("")
+	if a.PubKey(pk) != b.PubKey(pk) {
+		t.Error("expected the same salt to give the same pseudonym")
+	}
+	if a.PubKey(pk) == other.PubKey(pk) || strings.Contains(a.PubKey(pk), pk[:8]) {
+		t.Errorf("expected a pseudonym unrelated to the pubkey, got %s", a.PubKey(pk))
+	}
+	var none *Scrubber
+	if none.PubKey(pk) != pk || none.logPubKey(pk) != pk[:8] {
+		t.Error("expected no scrubbing without a Scrubber")
+	}
+
+	entries := []LedgerEntry{{JobID: "job", Requester: pk, Sats: 21}}
+	scrubbed := a.LedgerEntries(entries)
+	if scrubbed[0].Requester != a.PubKey(pk) || scrubbed[0].Sats != 21 {
+		t.Errorf("expected the requester pseudonymized, got %+v", scrubbed[0])
+	}
+	if entries[0].Requester != pk {
+		t.Error("expected the entries passed in left alone")
+	}
+}
+
+func TestScrubbedLogs(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scrubber, _ := NewScrubber("")
+	d, stop := runTestDvm(t, relay, WithScraper(&fakeScraper{}), WithScrubber(scrubber))
+
+	sk := testKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	req := newTestRequestFrom(sk, "1110302988")
+	out := captureLogs(func() {
+		relay.Publish(req)
+		awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+		// Everything the job logged, once the DVM is done logging
+		stop()
+	})
+	if !strings.Contains(out, scrubber.PubKey(pk)) {
+		t.Errorf("expected the requester's pseudonym in the logs:\n%s", out)
+	}
+	if strings.Contains(out, pk[:8]) || strings.Contains(out, "1110302988") {
+		t.Errorf("expected neither the requester nor the request in the logs:\n%s", out)
+	}
+}
+
+func TestKeyRedactor(t *testing.T) {
+	sk := testKey()
+	var buf bytes.Buffer
+	r := NewKeyRedactor(&buf)
+	r.Add(sk)
+	nsec, _ := encodeBech32("nsec", []byte(strings.Repeat("k", 32)))
+	line := "key " + sk + " upper " + strings.ToUpper(sk) + " bech32 " + nsec + "\n"
+	if n, err := r.Write([]byte(line)); err != nil || n != len(line) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if out := buf.String(); strings.Contains(out, sk) || strings.Contains(out, strings.ToUpper(sk)) || strings.Contains(out, nsec) {
+		t.Errorf("expected the keys redacted, got %q", out)
+	}
+}
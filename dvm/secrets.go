@@ -0,0 +1,255 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Encrypted config values are marked by a prefix saying what decrypts them:
+//
+//   - "enc:v1:" values were sealed with EncryptSecret under a passphrase,
+//     the one unlocking the keyfile by convention
+//   - "vault:v<n>:" values are Vault transit ciphertext, as made by
+//     `vault write transit/encrypt/<key> plaintext=<base64>`
+//   - "kms:" values are a base64 AWS KMS ciphertext blob, as made by
+//     `aws kms encrypt --key-id <key> --plaintext fileb://<file>`
+//
+// Anything else is plaintext.
+const (
+	secretPrefix      = "enc:v1:"
+	vaultSecretPrefix = "vault:v"
+	kmsSecretPrefix   = "kms:"
+)
+
+// IsEncryptedSecret reports whether a config value is encrypted.
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix) || strings.HasPrefix(value, vaultSecretPrefix) ||
+		strings.HasPrefix(value, kmsSecretPrefix)
+}
+
+// EncryptSecret seals a config value, such as scraper cookies or an NWC
+// URI, under passphrase, the same way EncryptKeyFile seals the key.
+func EncryptSecret(value, passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := keyFileCipher(passphrase, salt, keyFileIterations)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := append(append(salt, nonce...), gcm.Seal(nil, nonce, []byte(value), nil)...)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// SecretResolver decrypts encrypted config values at startup. Each way of
+// decrypting is optional; a value needing one that isn't configured is an
+// error.
+type SecretResolver struct {
+	// Passphrase returns the passphrase for "enc:v1:" values. It's called
+	// at most once.
+	Passphrase func() (string, error)
+	Vault      *VaultTransitConfig
+	KMS        *AWSKMSConfig
+
+	passphrase string
+	client     *http.Client
+}
+
+// VaultTransitConfig is the HashiCorp Vault transit key "vault:" values
+// are decrypted with.
+type VaultTransitConfig struct {
+	Addr  string
+	Token string
+	Key   string // the transit key's name
+	Mount string // where the transit engine is mounted, default "transit"
+}
+
+// AWSKMSConfig is where "kms:" values are decrypted. The key is named in
+// the ciphertext. Credentials default to the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables, and
+// the region to AWS_REGION.
+type AWSKMSConfig struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Endpoint        string // default https://kms.<region>.amazonaws.com
+}
+
+// Resolve returns value decrypted, or value itself if it isn't encrypted.
+func (r *SecretResolver) Resolve(ctx context.Context, value string) (string, error) {
+	if r.client == nil {
+		r.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	switch {
+	case strings.HasPrefix(value, secretPrefix):
+		return r.unseal(strings.TrimPrefix(value, secretPrefix))
+	case strings.HasPrefix(value, vaultSecretPrefix):
+		return r.vaultDecrypt(ctx, value)
+	case strings.HasPrefix(value, kmsSecretPrefix):
+		return r.kmsDecrypt(ctx, strings.TrimPrefix(value, kmsSecretPrefix))
+	default:
+		return value, nil
+	}
+}
+
+// ResolveEnv replaces every encrypted environment variable with its
+// decrypted value, returning the names of those it decrypted.
+func (r *SecretResolver) ResolveEnv(ctx context.Context) ([]string, error) {
+	var names []string
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !IsEncryptedSecret(value) {
+			continue
+		}
+		plain, err := r.Resolve(ctx, value)
+		if err != nil {
+			return names, fmt.Errorf("decrypting %s: %w", name, err)
+		}
+		if err := os.Setenv(name, plain); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (r *SecretResolver) unseal(encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	if r.passphrase == "" {
+		if r.Passphrase == nil {
+			return "", errors.New("no passphrase to decrypt with")
+		}
+		if r.passphrase, err = r.Passphrase(); err != nil {
+			return "", fmt.Errorf("reading the passphrase: %w", err)
+		}
+	}
+	if len(sealed) < 16 {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	gcm, err := keyFileCipher(r.passphrase, sealed[:16], keyFileIterations)
+	if err != nil {
+		return "", err
+	}
+	sealed = sealed[16:]
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+	return string(plain), nil
+}
+
+func (r *SecretResolver) vaultDecrypt(ctx context.Context, ciphertext string) (string, error) {
+	cfg := r.Vault
+	if cfg == nil || cfg.Addr == "" || cfg.Token == "" || cfg.Key == "" {
+		return "", errors.New("vault transit needs an address, token and key")
+	}
+	mount := cfg.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	url := fmt.Sprintf("%s/v1/%s/decrypt/%s", strings.TrimSuffix(cfg.Addr, "/"), mount, cfg.Key)
+	body, _ := json.Marshal(map[string]string{"ciphertext": ciphertext})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", cfg.Token)
+	var result struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := r.call(req, "vault", &result); err != nil {
+		return "", err
+	}
+	plain, err := base64.StdEncoding.DecodeString(result.Data.Plaintext)
+	if err != nil {
+		return "", fmt.Errorf("vault: invalid plaintext: %w", err)
+	}
+	return string(plain), nil
+}
+
+func (r *SecretResolver) kmsDecrypt(ctx context.Context, blob string) (string, error) {
+	if r.KMS == nil {
+		return "", errors.New("no AWS KMS configured")
+	}
+	cfg := *r.KMS
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if cfg.Region == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return "", errors.New("AWS KMS needs a region and credentials")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://kms." + cfg.Region + ".amazonaws.com"
+	}
+
+	body, _ := json.Marshal(map[string]string{"CiphertextBlob": blob})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	if cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", cfg.SessionToken)
+	}
+	signV4(req, body, cfg.AccessKeyID, cfg.SecretAccessKey, cfg.Region, "kms", time.Now())
+	var result struct {
+		Plaintext []byte // base64 in the JSON
+	}
+	if err := r.call(req, "AWS KMS", &result); err != nil {
+		return "", err
+	}
+	return string(result.Plaintext), nil
+}
+
+// call sends req to a secret service and decodes its JSON response into v.
+func (r *SecretResolver) call(req *http.Request, service string, v any) error {
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", service, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%s: %w", service, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s: %s", service, resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", service, err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package dvm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSecretPassphrase(t *testing.T) {
+	sealed, err := EncryptSecret("auth_token=abc; ct0=def", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedSecret(sealed) || strings.Contains(sealed, "abc") {
+		t.Fatalf("unexpected sealed value %q", sealed)
+	}
+
+	calls := 0
+	r := &SecretResolver{Passphrase: func() (string, error) { calls++; return "hunter2", nil }}
+	for i := 0; i < 2; i++ {
+		if got, err := r.Resolve(context.Background(), sealed); err != nil || got != "auth_token=abc; ct0=def" {
+			t.Fatalf("Resolve = %q, %v", got, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the passphrase to be asked for once, got %d", calls)
+	}
+	if got, _ := r.Resolve(context.Background(), "plain"); got != "plain" {
+		t.Errorf("expected plaintext to pass through, got %q", got)
+	}
+
+	wrong := &SecretResolver{Passphrase: func() (string, error) { return "hunter3", nil }}
+	if _, err := wrong.Resolve(context.Background(), sealed); err != ErrWrongPassphrase {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+}
+
+func TestSecretVaultTransit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Ciphertext string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/transit/decrypt/bandita" || r.Header.Get("X-Vault-Token") != "token" || body.Ciphertext != "vault:v2:abcd" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{
+			"plaintext": base64.StdEncoding.EncodeToString([]byte("nostr+walletconnect://x")),
+		}})
+	}))
+	defer server.Close()
+
+	r := &SecretResolver{Vault: &VaultTransitConfig{Addr: server.URL, Token: "token", Key: "bandita"}}
+	if got, err := r.Resolve(context.Background(), "vault:v2:abcd"); err != nil || got != "nostr+walletconnect://x" {
+		t.Errorf("Resolve = %q, %v", got, err)
+	}
+}
+
+func TestSecretKMS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ CiphertextBlob string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" || body.CiphertextBlob != "YmxvYg==" ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/kms/aws4_request") {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": []byte("bearer-token")})
+	}))
+	defer server.Close()
+
+	r := &SecretResolver{KMS: &AWSKMSConfig{Region: "us-east-1", AccessKeyID: "id", SecretAccessKey: "secret", Endpoint: server.URL}}
+	if got, err := r.Resolve(context.Background(), "kms:YmxvYg=="); err != nil || got != "bearer-token" {
+		t.Errorf("Resolve = %q, %v", got, err)
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	sealed, err := EncryptSecret("cookie", "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BANDITA_TEST_SECRET", sealed)
+	t.Setenv("BANDITA_TEST_PLAIN", "plain")
+	r := &SecretResolver{Passphrase: func() (string, error) { return "pw", nil }}
+	names, err := r.ResolveEnv(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "BANDITA_TEST_SECRET" || os.Getenv("BANDITA_TEST_SECRET") != "cookie" {
+		t.Errorf("unexpected result %v, %q", names, os.Getenv("BANDITA_TEST_SECRET"))
+	}
+	if os.Getenv("BANDITA_TEST_PLAIN") != "plain" {
+		t.Error("expected plaintext left alone")
+	}
+}
@@ -0,0 +1,105 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultClockSkew is how far behind the DVM's clock a request's created_at
+// may be and still be picked up; see WithClockSkew.
+const defaultClockSkew = 30 * time.Second
+
+// seenDocument is the store document the seen requests persist to.
+const seenDocument = "seen"
+
+// seenSaveInterval throttles persisting the seen requests, which change
+// with every request.
+const seenSaveInterval = time.Second
+
+// seenStore remembers the requests the DVM has taken, by ID, so that one
+// replayed by a resubscribe or after a restart isn't run twice. Request
+// timestamps aren't trusted for that: the subscription reaches back over
+// the clock skew tolerance, so replays are expected.
+//
+// An ID is kept while its request's created_at is at or after the
+// subscription's floor, as only those can be replayed. With a Store, the
+// IDs survive restarts, saved within a second of changing.
+type seenStore struct {
+	store *Store
+
+	mu       sync.Mutex
+	ids      map[string]int64 // created_at by request ID
+	floor    int64            // requests created before this can't be replayed
+	dirty    bool
+	lastSave time.Time
+	saving   bool // a throttled save is scheduled
+}
+
+func loadSeen(store *Store, floor time.Time) (*seenStore, error) {
+	s := &seenStore{store: store, ids: make(map[string]int64)}
+	if store != nil {
+		if err := store.Load(seenDocument, &s.ids); err != nil {
+			return nil, fmt.Errorf("failed to load seen requests: %w", err)
+		}
+	}
+	s.advance(floor)
+	return s, nil
+}
+
+// add records a request, reporting false if it was already seen.
+func (s *seenStore) add(id string, createdAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.ids[id]; dup {
+		return false
+	}
+	s.ids[id] = createdAt.Unix()
+	s.dirty = true
+	if wait := seenSaveInterval - time.Since(s.lastSave); wait <= 0 {
+		s.save()
+	} else if s.store != nil && !s.saving {
+		s.saving = true
+		time.AfterFunc(wait, s.flush)
+	}
+	return true
+}
+
+// advance raises the floor to that of the latest subscription, forgetting
+// requests created before it.
+func (s *seenStore) advance(floor time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if floor.Unix() <= s.floor {
+		return
+	}
+	s.floor = floor.Unix()
+	for id, createdAt := range s.ids {
+		if createdAt < s.floor {
+			delete(s.ids, id)
+			s.dirty = true
+		}
+	}
+}
+
+// flush saves anything not yet persisted.
+func (s *seenStore) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saving = false
+	s.save()
+}
+
+// save persists the IDs if they changed. s.mu must be held.
+func (s *seenStore) save() {
+	if s.store == nil || !s.dirty {
+		return
+	}
+	if err := s.store.Save(seenDocument, s.ids); err != nil {
+		log.Printf("Failed to persist seen requests: %v", err)
+		return
+	}
+	s.dirty = false
+	s.lastSave = time.Now()
+}
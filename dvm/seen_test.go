@@ -0,0 +1,93 @@
+package dvm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSeenStore(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	seen, err := loadSeen(store, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seen.add("old", now.Add(-30*time.Second)) || !seen.add("new", now) {
+		t.Fatal("expected new requests to be added")
+	}
+	if seen.add("new", now) {
+		t.Error("expected a repeat to be reported")
+	}
+
+	// Requests created before the floor can't be replayed, so are forgotten
+	seen.advance(now.Add(-10 * time.Second))
+	if !seen.add("old", now.Add(-30*time.Second)) {
+		t.Error("expected a request before the floor to be forgotten")
+	}
+
+	// The rest survive a restart
+	seen.flush()
+	reloaded, err := loadSeen(store, now.Add(-10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.add("new", now) {
+		t.Error("expected the reloaded store to remember the request")
+	}
+}
+
+func TestSkewedRequestsAreServed(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithClockSkew(time.Minute))
+
+	// From a requester whose clock runs 20s behind
+	req := &nostr.Event{CreatedAt: nostr.Timestamp(time.Now().Add(-20 * time.Second).Unix()),
+		Kind: KindTweetRequest, Content: "20"}
+	req.Sign(testKey())
+	relay.Publish(req)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind != ResultKind(KindTweetRequest) {
+		t.Errorf("expected a result, got kind %d", resp.Kind)
+	}
+}
+
+func TestReplaysAfterRestartAreSkipped(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scraper := &fakeScraper{}
+
+	d, err := NewDvm(relay.URL(), testKey(), WithScraper(scraper), WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+	req := newTestRequest("20")
+	relay.Publish(req)
+	awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The new subscription reaches back over the request, which the seen
+	// store recognizes
+	startTestDvm(t, relay, WithScraper(scraper), WithStore(store))
+	time.Sleep(500 * time.Millisecond)
+	if calls := scraper.Calls(); calls != 1 {
+		t.Errorf("expected the replayed request not to be rerun, got %d scrapes", calls)
+	}
+}
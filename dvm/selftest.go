@@ -0,0 +1,160 @@
+package dvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SelfTestConfig configures the self-test the DVM runs on starting: it
+// fetches a tweet known to exist and publishes a probe event, reading it
+// back. Until the self-test passes the DVM isn't ready, see Ready, and
+// doesn't announce itself with NIP-89 handler information; it serves any
+// requests that find it meanwhile.
+type SelfTestConfig struct {
+	TweetID string        // default "20", the first tweet
+	Timeout time.Duration // for each check, default 30s
+	Retry   time.Duration // between failed runs, default 1m
+}
+
+func (c SelfTestConfig) withDefaults() SelfTestConfig {
+	if c.TweetID == "" {
+		c.TweetID = "20"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	if c.Retry <= 0 {
+		c.Retry = time.Minute
+	}
+	return c
+}
+
+// KindAppData is NIP-78 arbitrary app data, an addressable kind, so each
+// self-test's probe replaces the last.
+const KindAppData = 30078
+
+// selfTestIdentifier is the d tag of the self-test's probe events.
+const selfTestIdentifier = "bandita-self-test"
+
+// errNotStarted is the DVM's readiness before Run.
+var errNotStarted = errors.New("not running")
+
+// Ready returns nil once the running DVM passed its self-test, or once it
+// subscribed to requests if it has none, and otherwise what's wrong.
+func (d *Dvm) Ready() error {
+	d.readyMu.Lock()
+	defer d.readyMu.Unlock()
+	return d.notReady
+}
+
+func (d *Dvm) setReady(err error) {
+	d.readyMu.Lock()
+	d.notReady = err
+	d.readyMu.Unlock()
+}
+
+// announce marks the DVM ready, running the self-test until it passes if
+// there is one, then publishes its capabilities.
+func (d *Dvm) announce(ctx context.Context) {
+	if d.selfTest != nil {
+		for {
+			err := d.runSelfTest(ctx)
+			if err == nil {
+				break
+			}
+			err = fmt.Errorf("self-test failed: %w", err)
+			d.setReady(err)
+			log.Printf("DVM not ready: %v; retrying in %v", err, d.selfTest.Retry)
+			d.alerts.raise(alertSelfTest, err.Error())
+			select {
+			case <-time.After(d.selfTest.Retry):
+			case <-ctx.Done():
+				return
+			}
+		}
+		log.Printf("Self-test passed")
+	}
+	d.setReady(nil)
+
+	// Advertise what the DVM serves, so clients can discover it
+	if err := d.publishCapabilities(); err != nil {
+		log.Printf("Failed to publish capabilities: %v", err)
+	}
+}
+
+// runSelfTest checks the DVM can fetch a tweet and publish to its relays.
+func (d *Dvm) runSelfTest(ctx context.Context) error {
+	if err := d.selfTestFetch(); err != nil {
+		return fmt.Errorf("fetching tweet %s: %w", d.selfTest.TweetID, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.selfTest.Timeout)
+	defer cancel()
+	if err := d.selfTestPublish(ctx); err != nil {
+		return fmt.Errorf("relay probe: %w", err)
+	}
+	return nil
+}
+
+// selfTestFetch fetches the self-test's tweet from the scraper, leaving
+// the cache and archive alone.
+func (d *Dvm) selfTestFetch() error {
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		tweet, err := d.scraper.GetTweet(d.selfTest.TweetID)
+		if err == nil && tweet == nil {
+			err = errors.New("no tweet returned")
+		}
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			return err
+		}
+		log.Printf("Self-test fetched tweet %s in %v", d.selfTest.TweetID, time.Since(start).Round(time.Millisecond))
+		return nil
+	case <-time.After(d.selfTest.Timeout):
+		return fmt.Errorf("timed out after %v", d.selfTest.Timeout)
+	}
+}
+
+// selfTestPublish publishes a probe event to the best relay and reads it
+// back. The probe expires after an hour.
+func (d *Dvm) selfTestPublish(ctx context.Context) error {
+	relay, err := d.pool.best(ctx)
+	if err != nil {
+		return err
+	}
+	probe := nostr.Event{
+		PubKey:    d.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      KindAppData,
+		Tags: nostr.Tags{
+			{"d", selfTestIdentifier},
+			{"expiration", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+		},
+		Content: "bandita self-test",
+	}
+	if err := probe.Sign(d.sk); err != nil {
+		return err
+	}
+	if _, err := relay.Publish(ctx, probe); err != nil {
+		return fmt.Errorf("publishing to %s: %w", relayURL(relay), err)
+	}
+	events, err := relay.QuerySync(ctx, nostr.Filter{IDs: []string{probe.ID}})
+	if err != nil {
+		return fmt.Errorf("reading back from %s: %w", relayURL(relay), err)
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("%s didn't return the probe event it was sent", relayURL(relay))
+	}
+	log.Printf("Self-test published and read back a probe event on %s", relayURL(relay))
+	return nil
+}
@@ -0,0 +1,101 @@
+package dvm
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/imperatrona/twitter-scraper"
+)
+
+// flakyScraper fails its first failures calls, then scrapes like
+// fakeScraper.
+type flakyScraper struct {
+	fakeScraper
+	mu       sync.Mutex
+	failures int
+}
+
+func (f *flakyScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("rate limited")
+	}
+	return f.fakeScraper.GetTweet(id)
+}
+
+// announced reports whether relay has d's handler information.
+func announced(relay *relaytest.Server, d *Dvm) bool {
+	for _, evt := range relay.Events() {
+		if evt.Kind == KindHandlerInfo && evt.PubKey == d.GetPublicKey() {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitReady waits for d to become ready.
+func awaitReady(t *testing.T, d *Dvm) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Ready() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("DVM never became ready: %v", d.Ready())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSelfTestGatesReadiness(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d, err := NewDvm(relay.URL(), testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Ready() != errNotStarted {
+		t.Errorf("expected a DVM that isn't running not to be ready, got %v", d.Ready())
+	}
+
+	d = startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithSelfTest(SelfTestConfig{}))
+	awaitReady(t, d)
+	var probed bool
+	for _, evt := range relay.Events() {
+		probed = probed || evt.Kind == KindAppData && evt.PubKey == d.GetPublicKey()
+	}
+	if !probed {
+		t.Error("expected the self-test's probe event on the relay")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !announced(relay, d) {
+		t.Error("expected the ready DVM to announce itself")
+	}
+}
+
+func TestSelfTestFailureDelaysAnnouncement(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithScraper(&flakyScraper{failures: 1}),
+		WithSelfTest(SelfTestConfig{Retry: time.Second}))
+	time.Sleep(300 * time.Millisecond)
+	if err := d.Ready(); err == nil || !strings.Contains(err.Error(), "fetching tweet 20: rate limited") {
+		t.Errorf("expected the failed fetch as the reason the DVM isn't ready, got %v", err)
+	}
+	if announced(relay, d) {
+		t.Error("expected no announcement before the self-test passes")
+	}
+
+	// The retry passes
+	awaitReady(t, d)
+	time.Sleep(200 * time.Millisecond)
+	if !announced(relay, d) {
+		t.Error("expected the DVM to announce itself once ready")
+	}
+}
@@ -0,0 +1,341 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SentimentResult is the result of a KindSentimentRequest job: an analysis
+// of a tweet, or of a whole thread and each tweet in it.
+type SentimentResult struct {
+	TweetID  string          `json:"tweet_id"`
+	Analyzer string          `json:"analyzer"`
+	Overall  Analysis        `json:"overall"`
+	Tweets   []TweetAnalysis `json:"tweets,omitempty"` // per tweet, for threads
+}
+
+// TweetAnalysis is the analysis of one tweet of a thread.
+type TweetAnalysis struct {
+	ID string `json:"id"`
+	Analysis
+}
+
+// Analysis is what an Analyzer reports about a text.
+type Analysis struct {
+	Sentiment  string   `json:"sentiment"` // positive, negative or neutral
+	Score      float64  `json:"score"`     // -1 (most negative) to 1
+	KeyPhrases []string `json:"key_phrases"`
+	// Stance towards the requested target: favor, against, neutral, or
+	// none when the text doesn't mention it. Empty without a target.
+	Stance string `json:"stance,omitempty"`
+}
+
+// Analyzer performs sentiment, stance and key-phrase analysis for
+// KindSentimentRequest jobs. The default is a fast lexicon-based analyzer;
+// with WithLLM configured the model is used instead, and WithAnalyzer
+// plugs in anything else.
+type Analyzer interface {
+	// Name identifies the analyzer in results.
+	Name() string
+	// Analyze analyzes text, including its stance towards target if set.
+	Analyze(ctx context.Context, text, target string) (Analysis, error)
+}
+
+// sentimentLabel buckets a score the way VADER does.
+func sentimentLabel(score float64) string {
+	switch {
+	case score >= 0.05:
+		return "positive"
+	case score <= -0.05:
+		return "negative"
+	default:
+		return "neutral"
+	}
+}
+
+// sentimentHandler analyzes a tweet and its thread. Requests may carry
+// ["param", "target", "<topic>"] for stance detection.
+type sentimentHandler struct {
+	d        *Dvm
+	analyzer Analyzer
+}
+
+func (h *sentimentHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		return fmt.Errorf("content is not a tweet ID")
+	}
+	if target, _ := paramValue(req, "target"); len(target) > 100 {
+		return errors.New("target param is too long")
+	}
+	return nil
+}
+
+func (h *sentimentHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	target, _ := paramValue(req, "target")
+	target = strings.TrimSpace(target)
+	tweet, err := h.d.fetchTweet(req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	result := SentimentResult{TweetID: tweet.ID, Analyzer: h.analyzer.Name()}
+	thread := []*twitterscraper.Tweet{tweet}
+	for _, t := range tweet.Thread {
+		if t.ID != tweet.ID {
+			thread = append(thread, t)
+		}
+	}
+	texts := make([]string, len(thread))
+	for i, t := range thread {
+		texts[i] = t.Text
+		if len(thread) == 1 {
+			break
+		}
+		analysis, err := h.analyzer.Analyze(ctx, t.Text, target)
+		if err != nil {
+			return nil, err
+		}
+		result.Tweets = append(result.Tweets, TweetAnalysis{ID: t.ID, Analysis: analysis})
+	}
+	if result.Overall, err = h.analyzer.Analyze(ctx, strings.Join(texts, "\n\n"), target); err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// lexiconAnalyzer scores text against a small sentiment lexicon, with
+// negation handling, and extracts key phrases with RAKE. It needs no
+// external service and is good enough for aggregate research use.
+type lexiconAnalyzer struct{}
+
+func (lexiconAnalyzer) Name() string { return "lexicon" }
+
+func (lexiconAnalyzer) Analyze(ctx context.Context, text, target string) (Analysis, error) {
+	score := lexiconScore(text)
+	analysis := Analysis{Sentiment: sentimentLabel(score), Score: score, KeyPhrases: keyPhrases(text, 5)}
+	if target != "" {
+		analysis.Stance = lexiconStance(text, target)
+	}
+	return analysis, nil
+}
+
+var (
+	wordPattern     = regexp.MustCompile(`[\p{L}\p{N}']+`)
+	sentencePattern = regexp.MustCompile(`[^.!?\n]+`)
+	urlPattern      = regexp.MustCompile(`https?://\S+`)
+	phraseBreak     = regexp.MustCompile(`[.,;:!?()"\n\t]+`)
+)
+
+// lexiconScore sums word valences, flipping those within three words of a
+// negation, and normalizes the sum into -1..1 as VADER does.
+func lexiconScore(text string) float64 {
+	var sum float64
+	negateFor := 0
+	for _, word := range wordPattern.FindAllString(strings.ToLower(urlPattern.ReplaceAllString(text, " ")), -1) {
+		if negations[word] || strings.HasSuffix(word, "n't") {
+			negateFor = 3
+			continue
+		}
+		valence := sentimentLexicon[word]
+		if negateFor > 0 {
+			valence = -valence * 0.75
+			negateFor--
+		}
+		sum += valence
+	}
+	return math.Round(sum/math.Sqrt(sum*sum+15)*1000) / 1000
+}
+
+// lexiconStance takes the sentiment of the sentences that mention target as
+// the author's stance towards it; a common baseline for stance detection.
+func lexiconStance(text, target string) string {
+	target = strings.ToLower(target)
+	var mentions []string
+	for _, sentence := range sentencePattern.FindAllString(text, -1) {
+		if strings.Contains(strings.ToLower(sentence), target) {
+			mentions = append(mentions, sentence)
+		}
+	}
+	if len(mentions) == 0 {
+		return "none"
+	}
+	switch sentimentLabel(lexiconScore(strings.Join(mentions, ". "))) {
+	case "positive":
+		return "favor"
+	case "negative":
+		return "against"
+	default:
+		return "neutral"
+	}
+}
+
+// keyPhrases extracts up to n key phrases with RAKE: candidate phrases are
+// runs of words between stopwords and punctuation, scored by the summed
+// degree/frequency ratio of their words.
+func keyPhrases(text string, n int) []string {
+	text = strings.ToLower(urlPattern.ReplaceAllString(text, " "))
+	var phrases [][]string
+	for _, fragment := range phraseBreak.Split(text, -1) {
+		var phrase []string
+		for _, word := range wordPattern.FindAllString(fragment, -1) {
+			word = strings.Trim(word, "'")
+			if stopwords[word] || len(word) < 2 {
+				if len(phrase) > 0 {
+					phrases = append(phrases, phrase)
+				}
+				phrase = nil
+				continue
+			}
+			phrase = append(phrase, word)
+		}
+		if len(phrase) > 0 {
+			phrases = append(phrases, phrase)
+		}
+	}
+
+	freq := make(map[string]float64)
+	degree := make(map[string]float64)
+	for _, phrase := range phrases {
+		for _, word := range phrase {
+			freq[word]++
+			degree[word] += float64(len(phrase))
+		}
+	}
+	scores := make(map[string]float64)
+	for _, phrase := range phrases {
+		if len(phrase) > 4 {
+			continue // long runs are usually missed stopwords, not phrases
+		}
+		var score float64
+		for _, word := range phrase {
+			score += degree[word] / freq[word]
+		}
+		scores[strings.Join(phrase, " ")] = score
+	}
+
+	ranked := make([]string, 0, len(scores))
+	for phrase := range scores {
+		ranked = append(ranked, phrase)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// llmAnalyzer asks the configured language model for the analysis.
+type llmAnalyzer struct {
+	llm *llmClient
+}
+
+func (a *llmAnalyzer) Name() string { return "llm:" + a.llm.cfg.Model }
+
+const sentimentSystemPrompt = "You analyze the sentiment of social media posts for research " +
+	"and moderation tools. Reply with a single JSON object and nothing else."
+
+func (a *llmAnalyzer) Analyze(ctx context.Context, text, target string) (Analysis, error) {
+	prompt := `Analyze the text below. Reply with JSON of the form {"sentiment": ` +
+		`"positive"|"negative"|"neutral", "score": <number from -1 to 1>, "key_phrases": ` +
+		`[<up to 5 short phrases from the text>]`
+	if target != "" {
+		prompt += fmt.Sprintf(`, "stance": "favor"|"against"|"neutral"|"none"} where stance is `+
+			`the author's position on %q, or none if the text doesn't address it`, target)
+	} else {
+		prompt += "}"
+	}
+	prompt += ".\n\nText:\n" + truncateText(text, maxSummaryInput)
+
+	reply, usage, err := a.llm.complete(ctx, sentimentSystemPrompt, prompt)
+	if err != nil {
+		return Analysis{}, fmt.Errorf("analysis failed: %w", err)
+	}
+	chargeJob(ctx, a.llm.price(usage))
+
+	// Models like to wrap JSON in code fences
+	if start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+	var analysis Analysis
+	if err := json.Unmarshal([]byte(reply), &analysis); err != nil {
+		return Analysis{}, fmt.Errorf("model returned invalid analysis: %w", err)
+	}
+	analysis.Score = math.Max(-1, math.Min(1, analysis.Score))
+	if analysis.Sentiment != "positive" && analysis.Sentiment != "negative" && analysis.Sentiment != "neutral" {
+		analysis.Sentiment = sentimentLabel(analysis.Score)
+	}
+	if target == "" {
+		analysis.Stance = ""
+	}
+	return analysis, nil
+}
+
+var negations = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "nobody": true,
+	"neither": true, "nor": true, "without": true, "hardly": true, "cannot": true,
+}
+
+// sentimentLexicon holds valences for common English words on AFINN's -5
+// to 5 scale, with some tuned for crypto and tech discourse.
+var sentimentLexicon = map[string]float64{
+	// positive
+	"good": 2, "great": 3, "excellent": 3, "amazing": 3, "awesome": 3, "fantastic": 3,
+	"love": 3, "loved": 3, "loving": 2, "like": 1, "liked": 1, "best": 3, "better": 2,
+	"happy": 3, "glad": 2, "excited": 2, "exciting": 2, "nice": 2, "cool": 1, "fun": 2,
+	"beautiful": 3, "brilliant": 3, "win": 2, "wins": 2, "won": 2, "winning": 2,
+	"success": 2, "successful": 2, "thanks": 2, "thank": 2, "grateful": 2, "congrats": 2,
+	"congratulations": 2, "impressive": 3, "incredible": 3, "perfect": 3, "wonderful": 3,
+	"support": 2, "supports": 2, "agree": 1, "bullish": 2, "hope": 2, "hopeful": 2,
+	"proud": 2, "safe": 1, "strong": 2, "free": 1, "freedom": 2, "fair": 2, "easy": 1,
+	"interesting": 2, "helpful": 2, "useful": 2, "recommend": 2, "favorite": 2, "wow": 3,
+	"yay": 2, "lol": 1, "haha": 2, "enjoy": 2, "enjoyed": 2, "innovative": 2, "right": 1,
+	"correct": 1, "benefit": 2, "improve": 2, "improved": 2, "growth": 2, "gains": 2,
+	"optimistic": 2, "celebrate": 3, "kind": 2, "smart": 2, "honest": 2, "trust": 1,
+	// negative
+	"bad": -3, "terrible": -3, "awful": -3, "horrible": -3, "worst": -3, "worse": -3,
+	"hate": -3, "hated": -3, "hates": -3, "dislike": -2, "sad": -2, "angry": -3,
+	"annoying": -2, "annoyed": -2, "disappointed": -2, "disappointing": -2, "fail": -2,
+	"failed": -2, "failure": -2, "fails": -2, "lose": -3, "loss": -3, "lost": -3,
+	"losing": -3, "wrong": -2, "broken": -1, "bug": -1, "scam": -3, "fraud": -4,
+	"stupid": -2, "dumb": -3, "idiot": -3, "ugly": -3, "boring": -3, "crash": -2,
+	"crashed": -2, "risk": -2, "risky": -2, "dangerous": -2, "danger": -2, "fear": -2,
+	"scared": -2, "worried": -3, "worry": -3, "problem": -2, "problems": -2, "crisis": -3,
+	"disaster": -2, "corrupt": -3, "lie": -2, "lies": -2, "liar": -3, "censorship": -2,
+	"censored": -2, "bearish": -2, "dump": -1, "useless": -2, "waste": -1, "pathetic": -2,
+	"sucks": -3, "shame": -2, "unfair": -2, "attack": -1, "war": -2, "kill": -3,
+	"killed": -3, "dead": -3, "death": -2, "sick": -2, "pain": -2, "hurt": -2, "ban": -2,
+	"banned": -2, "abuse": -3, "evil": -3, "toxic": -2, "ridiculous": -3, "wtf": -4,
+	"against": -1, "oppose": -2, "disagree": -2, "concerned": -2, "doubt": -1,
+}
+
+// stopwords delimit RAKE candidate phrases.
+var stopwords = func() map[string]bool {
+	words := strings.Fields(`a about above after again against all am an and any are as at be
+		because been before being below between both but by can could did do does doing down
+		during each few for from further had has have having he her here hers herself him himself
+		his how i if in into is it its itself just me more most my myself no nor not now of off on
+		once only or other our ours ourselves out over own same she should so some such than that
+		the their theirs them themselves then there these they this those through to too under
+		until up very was we were what when where which while who whom why will with would you
+		your yours yourself yourselves also get got it's i'm don't can't won't isn't that's
+		there's we're they're you're via rt amp like just really`)
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}()
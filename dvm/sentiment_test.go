@@ -0,0 +1,116 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// threadScraper returns a two-tweet self-thread.
+type threadScraper struct{}
+
+func (threadScraper) GetTweet(id string) (*twitterscraper.Tweet, error) {
+	first := &twitterscraper.Tweet{ID: id, Username: "halfin", Text: "Running bitcoin, a great project!"}
+	second := &twitterscraper.Tweet{ID: "2", Username: "halfin", Text: "Not a good day, lost my coins in a crash."}
+	self := *first // threads can include the requested tweet itself
+	first.Thread = []*twitterscraper.Tweet{&self, second}
+	return first, nil
+}
+
+func TestLexiconAnalyzer(t *testing.T) {
+	ctx := context.Background()
+	for text, want := range map[string]string{
+		"I love this, what a great release":    "positive",
+		"This is a terrible scam":              "negative",
+		"The meeting is at noon":               "neutral",
+		"This is not good at all":              "negative",
+		"I don't hate it https://bad.example/": "positive",
+	} {
+		analysis, _ := lexiconAnalyzer{}.Analyze(ctx, text, "")
+		if analysis.Sentiment != want {
+			t.Errorf("%q: got %s (%v), want %s", text, analysis.Sentiment, analysis.Score, want)
+		}
+		if analysis.Score < -1 || analysis.Score > 1 {
+			t.Errorf("%q: score %v out of range", text, analysis.Score)
+		}
+	}
+
+	text := "Lightning is great for payments. Fiat banking is a disaster."
+	for target, want := range map[string]string{"lightning": "favor", "banking": "against", "ethereum": "none"} {
+		if analysis, _ := (lexiconAnalyzer{}).Analyze(ctx, text, target); analysis.Stance != want {
+			t.Errorf("stance on %s: got %s, want %s", target, analysis.Stance, want)
+		}
+	}
+}
+
+func TestKeyPhrases(t *testing.T) {
+	// The first sentence is one long run without stopwords, too long to be
+	// a phrase
+	got := keyPhrases("The lightning network makes bitcoin payments instant. Lightning network channels are cheap.", 3)
+	want := []string{"lightning network channels", "cheap"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSentimentHandler(t *testing.T) {
+	h := &sentimentHandler{d: &Dvm{scraper: threadScraper{}}, analyzer: lexiconAnalyzer{}}
+	req := &nostr.Event{Content: "1", Tags: nostr.Tags{{"param", "target", "bitcoin"}}}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.Handle(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sr SentimentResult
+	if err := json.Unmarshal(result, &sr); err != nil {
+		t.Fatal(err)
+	}
+	if sr.Analyzer != "lexicon" || len(sr.Tweets) != 2 {
+		t.Fatalf("unexpected result: %+v", sr)
+	}
+	if sr.Tweets[0].Sentiment != "positive" || sr.Tweets[1].Sentiment != "negative" || sr.Tweets[1].Stance != "none" {
+		t.Errorf("unexpected per-tweet analyses: %+v", sr.Tweets)
+	}
+	if sr.Overall.Stance != "favor" {
+		t.Errorf("expected the thread to favor bitcoin, got %+v", sr.Overall)
+	}
+
+	if err := h.Validate(&nostr.Event{Content: "https://x.com/"}); err == nil {
+		t.Error("expected a non-tweet ID to be rejected")
+	}
+}
+
+func TestLLMAnalyzer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := "```json\n{\"sentiment\": \"negative\", \"score\": -3, \"key_phrases\": [\"fees\"], \"stance\": \"against\"}\n```"
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]string{"content": reply}}},
+			"usage":   map[string]int{"prompt_tokens": 90, "completion_tokens": 10},
+		})
+	}))
+	defer srv.Close()
+
+	llm, _ := newLLMClient(LLMConfig{Provider: "openai", Endpoint: srv.URL, Model: "m", PricePerKTokens: 1000})
+	receipt := &jobReceipt{}
+	ctx := context.WithValue(context.Background(), jobReceiptKey{}, receipt)
+
+	analysis, err := (&llmAnalyzer{llm: llm}).Analyze(ctx, "fees are too high", "fees")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Analysis{Sentiment: "negative", Score: -1, KeyPhrases: []string{"fees"}, Stance: "against"}
+	if !reflect.DeepEqual(analysis, want) {
+		t.Errorf("got %+v, want %+v", analysis, want)
+	}
+	if receipt.amountMsats != 100 {
+		t.Errorf("expected the job to be charged 100 msats, got %d", receipt.amountMsats)
+	}
+}
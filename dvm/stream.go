@@ -0,0 +1,144 @@
+package dvm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ResultStream is a job whose result comes in pages, such as a user
+// archive or a follows list, read page by page as the DVM publishes them:
+//
+//	stream := client.Stream(ctx, step, payer, "@halfin")
+//	for stream.Next() {
+//		page := stream.Page()
+//		...
+//	}
+//	final, err := stream.Result()
+type ResultStream struct {
+	pages chan ResultPage
+	page  ResultPage
+
+	done   chan struct{} // closed once result and err are set
+	result string
+	event  *nostr.Event
+	err    error
+}
+
+// ResultPage is one page of a paged result.
+type ResultPage struct {
+	N       int // from 1
+	Content string
+}
+
+// Stream requests a job from step.DVM, paying it with payer up to
+// step.MaxMsats if it asks, and returns the job's result pages as they
+// arrive. Jobs that don't page their results just have a final result.
+func (c *DvmClient) Stream(ctx context.Context, step PipelineStep, payer Payer, input string) *ResultStream {
+	s := &ResultStream{pages: make(chan ResultPage), done: make(chan struct{})}
+	var paid int64
+	opts := requestOptions{
+		tags: step.Tags,
+		pay:  step.pay(payer, &paid),
+		page: func(n int, content string) {
+			select {
+			case s.pages <- ResultPage{N: n, Content: content}:
+			case <-ctx.Done():
+			}
+		},
+	}
+	go func() {
+		s.result, s.event, s.err = c.request(ctx, step.DVM, step.Kind, input, opts)
+		close(s.done)
+	}()
+	return s
+}
+
+// Next waits for the next page, in page order, reporting false once there
+// are no more.
+func (s *ResultStream) Next() bool {
+	select {
+	case s.page = <-s.pages:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Page returns the page Next moved to.
+func (s *ResultStream) Page() ResultPage {
+	return s.page
+}
+
+// Result waits for the job to finish and returns its final result, which
+// for paged jobs summarizes the pages. Pages not yet read with Next are
+// skipped.
+func (s *ResultStream) Result() (string, error) {
+	for s.Next() {
+	}
+	return s.result, s.err
+}
+
+// Event returns the final result event, once Result has returned.
+func (s *ResultStream) Event() *nostr.Event {
+	return s.event
+}
+
+// pagedResult reassembles the pages of a paged result, which may arrive in
+// any order, and hands them on in page order.
+type pagedResult struct {
+	client *DvmClient
+	sk     string // as for resultAssembler
+	emit   func(n int, content string)
+
+	parts map[int]*resultAssembler // pages still being assembled
+	ready map[int]string           // assembled pages waiting for those before them
+	next  int                      // pages handed on so far
+}
+
+// add takes a result event tagged ["page", <n>].
+func (p *pagedResult) add(ctx context.Context, e *nostr.Event) error {
+	n, err := strconv.Atoi(tagValue(e.Tags, "page"))
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid page tag %q", tagValue(e.Tags, "page"))
+	}
+	if _, dup := p.ready[n]; dup || n <= p.next {
+		return nil
+	}
+	if p.parts == nil {
+		p.parts = make(map[int]*resultAssembler)
+		p.ready = make(map[int]string)
+	}
+	a, ok := p.parts[n]
+	if !ok {
+		a = &resultAssembler{client: p.client, sk: p.sk}
+		p.parts[n] = a
+	}
+	content, complete, err := a.add(ctx, e)
+	if err != nil {
+		return fmt.Errorf("page %d: %w", n, err)
+	}
+	if !complete {
+		return nil
+	}
+	delete(p.parts, n)
+	p.ready[n] = content
+	for {
+		content, ok := p.ready[p.next+1]
+		if !ok {
+			return nil
+		}
+		delete(p.ready, p.next+1)
+		p.next++
+		p.emit(p.next, content)
+	}
+}
+
+// complete reports whether every page the final result event counts has
+// been handed on.
+func (p *pagedResult) complete(final *nostr.Event) bool {
+	total, _ := strconv.Atoi(tagValue(final.Tags, "total-pages"))
+	return p.next >= total
+}
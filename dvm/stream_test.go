@@ -0,0 +1,77 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPagedResultInOrder(t *testing.T) {
+	var got []int
+	p := pagedResult{emit: func(n int, content string) {
+		if content != "page "+strconv.Itoa(n) {
+			t.Errorf("page %d has content %q", n, content)
+		}
+		got = append(got, n)
+	}}
+	for _, n := range []int{2, 1, 2, 4, 3} {
+		e := &nostr.Event{Kind: ResultKind(KindFollowsRequest), Content: "page " + strconv.Itoa(n),
+			Tags: nostr.Tags{{"page", strconv.Itoa(n)}}}
+		if err := p.add(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 4 || got[0] != 1 || got[3] != 4 {
+		t.Errorf("expected pages 1 to 4 once each in order, got %v", got)
+	}
+	if !p.complete(&nostr.Event{Tags: nostr.Tags{{"total-pages", "4"}}}) || p.complete(&nostr.Event{Tags: nostr.Tags{{"total-pages", "5"}}}) {
+		t.Error("wrong completeness")
+	}
+	if err := p.add(context.Background(), &nostr.Event{Tags: nostr.Tags{{"page", "zero"}}}); err == nil {
+		t.Error("expected an invalid page tag to be rejected")
+	}
+}
+
+func TestStreamFollows(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	d := startTestDvm(t, relay, WithScraper(&followsScraper{followers: 450}))
+
+	client, err := NewDvmClient(relay.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream := client.Stream(ctx, PipelineStep{DVM: d.GetPublicKey(), Kind: KindFollowsRequest,
+		Tags: nostr.Tags{{"param", "max", "420"}}}, nil, "halfin")
+	users := 0
+	for i := 1; stream.Next(); i++ {
+		var page FollowPage
+		if err := json.Unmarshal([]byte(stream.Page().Content), &page); err != nil {
+			t.Fatal(err)
+		}
+		if stream.Page().N != i || page.Page != i {
+			t.Errorf("expected page %d, got %d (%d)", i, stream.Page().N, page.Page)
+		}
+		users += len(page.Users)
+	}
+	content, err := stream.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list FollowList
+	if err := json.Unmarshal([]byte(content), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Pages != 3 || users != 420 || tagValue(stream.Event().Tags, "total-pages") != "3" {
+		t.Errorf("unexpected result %+v after %d streamed users", list, users)
+	}
+}
@@ -0,0 +1,119 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/html"
+)
+
+// Summary is the result of a KindSummarizeRequest job.
+type Summary struct {
+	Source      string   `json:"source"` // the tweet ID or URL summarized
+	Title       string   `json:"title,omitempty"`
+	Summary     string   `json:"summary"`
+	Model       string   `json:"model"`
+	Usage       llmUsage `json:"usage"`
+	AmountMsats int64    `json:"amount_msats,omitempty"` // also in the result's "amount" tag
+}
+
+// maxSummaryInput bounds the text sent to the model, roughly 12k tokens.
+const maxSummaryInput = 48000
+
+// summaryLengths maps the "length" param to an instruction for the model.
+var summaryLengths = map[string]string{
+	"short":  "in one or two sentences",
+	"medium": "in one short paragraph",
+	"long":   "in a few paragraphs, covering every main point",
+}
+
+const summarySystemPrompt = "You summarize social media threads and articles for readers who " +
+	"haven't seen them. Be accurate and neutral, keep the author's meaning, and never add " +
+	"facts that aren't in the text. Reply with the summary only."
+
+// summarizeHandler summarizes a tweet thread, given a tweet ID, or an
+// article, given its URL, with the configured LLM. Requests may carry
+// ["param", "length", "short|medium|long"].
+type summarizeHandler struct {
+	d      *Dvm
+	llm    *llmClient
+	client *http.Client // for articles
+}
+
+func newSummarizeHandler(d *Dvm, llm *llmClient) *summarizeHandler {
+	return &summarizeHandler{d: d, llm: llm, client: newFetchClient()}
+}
+
+func (h *summarizeHandler) Validate(req *nostr.Event) error {
+	if !tweetIDPattern.MatchString(req.Content) {
+		if _, err := parseWebURL(req.Content); err != nil {
+			return errors.New("content is neither a tweet ID nor a URL")
+		}
+	}
+	if length, ok := paramValue(req, "length"); ok {
+		if _, ok := summaryLengths[length]; !ok {
+			return fmt.Errorf("length param must be short, medium or long")
+		}
+	}
+	return nil
+}
+
+func (h *summarizeHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	result := Summary{Source: strings.TrimSpace(req.Content), Model: h.llm.cfg.Model}
+	length := "medium"
+	if value, ok := paramValue(req, "length"); ok {
+		length = value
+	}
+
+	var text string
+	if tweetIDPattern.MatchString(result.Source) {
+		tweet, err := h.d.fetchTweet(result.Source)
+		if err != nil {
+			return nil, err
+		}
+		result.Title = fmt.Sprintf("Thread by @%s", tweet.Username)
+		parts := []string{tweet.Text}
+		for _, t := range tweet.Thread {
+			if t.ID != tweet.ID {
+				parts = append(parts, t.Text)
+			}
+		}
+		text = strings.Join(parts, "\n\n")
+	} else {
+		header := http.Header{"Accept": {"text/html,application/xhtml+xml"}}
+		res, err := fetchWith(ctx, h.client, result.Source, header, maxFetchBytes)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := html.Parse(bytes.NewReader(res.Body))
+		if err != nil {
+			return nil, err
+		}
+		page := extractReadable(doc)
+		result.Title, text = page.Title, page.Text
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("nothing to summarize")
+	}
+
+	prompt := fmt.Sprintf("Summarize the following %s.\n\n", summaryLengths[length])
+	if result.Title != "" {
+		prompt += "Title: " + result.Title + "\n\n"
+	}
+	prompt += truncateText(text, maxSummaryInput)
+
+	var err error
+	result.Summary, result.Usage, err = h.llm.complete(ctx, summarySystemPrompt, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("summarization failed: %w", err)
+	}
+	result.AmountMsats = h.llm.price(result.Usage)
+	chargeJob(ctx, result.AmountMsats)
+	return json.Marshal(result)
+}
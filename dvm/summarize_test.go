@@ -0,0 +1,143 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeLLM serves both the OpenAI and Ollama chat APIs, answering with the
+// last line of the prompt so tests can see what the model was given.
+func fakeLLM(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model    string              `json:"model"`
+			Messages []map[string]string `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Messages) != 2 || body.Model != "test-model" {
+			t.Errorf("unexpected chat request: %+v", body)
+		}
+		prompt := body.Messages[1]["content"]
+		answer := prompt[strings.LastIndex(prompt, "\n")+1:]
+
+		switch r.URL.Path {
+		case "/chat/completions":
+			json.NewEncoder(w).Encode(map[string]any{
+				"choices": []any{map[string]any{"message": map[string]string{"content": answer}}},
+				"usage":   map[string]int{"prompt_tokens": 1500, "completion_tokens": 500},
+			})
+		case "/api/chat":
+			json.NewEncoder(w).Encode(map[string]any{
+				"message":           map[string]string{"content": answer},
+				"prompt_eval_count": 100, "eval_count": 50,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestLLMClient(t *testing.T) {
+	srv := fakeLLM(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		provider string
+		usage    llmUsage
+		price    int64
+	}{
+		{"openai", llmUsage{1500, 500}, 2000},
+		{"ollama", llmUsage{100, 50}, 150},
+	} {
+		llm, err := newLLMClient(LLMConfig{Provider: tc.provider, Endpoint: srv.URL, Model: "test-model", PricePerKTokens: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, usage, err := llm.complete(ctx, "system", "line one\nanswer")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.provider, err)
+		}
+		if text != "answer" || usage != tc.usage || llm.price(usage) != tc.price {
+			t.Errorf("%s: got %q, %+v, %d msats", tc.provider, text, usage, llm.price(usage))
+		}
+	}
+
+	if _, err := newLLMClient(LLMConfig{Provider: "openai"}); err == nil {
+		t.Error("expected a missing model to be rejected")
+	}
+	if _, err := newLLMClient(LLMConfig{Provider: "clippy", Model: "x"}); err == nil {
+		t.Error("expected an unknown provider to be rejected")
+	}
+}
+
+func TestSummarizeHandler(t *testing.T) {
+	llmSrv := fakeLLM(t)
+	defer llmSrv.Close()
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>Block size</title></head><body><article>
+			<p>The block size debate lasted years, and it split the community in two.</p>
+		</article></body></html>`))
+	}))
+	defer page.Close()
+
+	llm, _ := newLLMClient(LLMConfig{Provider: "openai", Endpoint: llmSrv.URL, Model: "test-model"})
+	h := newSummarizeHandler(&Dvm{scraper: &fakeScraper{}}, llm)
+	h.client = page.Client()
+	ctx := context.Background()
+
+	for input, want := range map[string]Summary{
+		"1110302988": {Title: "Thread by @halfin", Summary: "Running bitcoin"},
+		page.URL:     {Title: "Block size", Summary: "The block size debate lasted years, and it split the community in two."},
+	} {
+		req := &nostr.Event{Content: input, Tags: nostr.Tags{{"param", "length", "short"}}}
+		if err := h.Validate(req); err != nil {
+			t.Fatal(err)
+		}
+		result, err := h.Handle(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s Summary
+		if err := json.Unmarshal(result, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Source != input || s.Title != want.Title || s.Summary != want.Summary || s.Model != "test-model" {
+			t.Errorf("%s: unexpected summary %+v", input, s)
+		}
+	}
+
+	if err := h.Validate(&nostr.Event{Content: "not a url"}); err == nil {
+		t.Error("expected invalid input to be rejected")
+	}
+	if err := h.Validate(&nostr.Event{Content: "1", Tags: nostr.Tags{{"param", "length", "epic"}}}); err == nil {
+		t.Error("expected an unknown length to be rejected")
+	}
+}
+
+func TestSummaryResultCarriesAmount(t *testing.T) {
+	llmSrv := fakeLLM(t)
+	defer llmSrv.Close()
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}),
+		WithLLM(LLMConfig{Provider: "openai", Endpoint: llmSrv.URL, Model: "test-model", PricePerKTokens: 500}))
+
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindSummarizeRequest, Tags: nostr.Tags{}, Content: "1110302988"}
+	req.Sign(testKey())
+	relay.Publish(req)
+
+	resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	// 2000 tokens at 500 msats per thousand
+	if amount := resp.Tags.GetFirst([]string{"amount"}); amount == nil || (*amount)[1] != "1000" {
+		t.Errorf("expected an amount tag of 1000 msats, got %v", resp.Tags)
+	}
+}
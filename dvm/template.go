@@ -0,0 +1,136 @@
+package dvm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+)
+
+// TemplateConfig replaces the text and markdown renderings of tweet results
+// with Go text/templates, so operators can reshape them without code
+// changes. A template left empty keeps the default, DefaultTweetText or
+// DefaultTweetMarkdown.
+//
+// Templates are executed with the TweetResult as dot, and can use these
+// functions besides the builtins:
+//
+//	byline  "Name (@username)", or "@username" without a name
+//	date    the tweet's date, as "2006-01-02 15:04 UTC"
+//	counts  "<n> likes · <n> retweets · <n> replies"
+//	stats   date and counts, joined by " · "
+//	photos  the URLs of the tweet's photos, preferring re-hosted copies
+//	trim    strings.TrimSpace
+//	join    strings.Join
+//	oneline the string with its runs of whitespace collapsed to one space
+//	quote   the string trimmed, as a markdown blockquote
+type TemplateConfig struct {
+	TweetText     string // for OutputText
+	TweetMarkdown string // for OutputMarkdown
+}
+
+// The templates tweet results are rendered with by default.
+const (
+	DefaultTweetText = `{{byline .}}
+
+{{trim .Text}}
+
+{{with photos .}}{{join . "\n"}}
+
+{{end}}{{range .OCR}}{{if .Text}}Text in image: {{trim .Text}}
+
+{{end}}{{end}}{{stats .}}{{with .PermanentURL}}
+{{.}}{{end}}`
+
+	DefaultTweetMarkdown = `{{with .Name}}**{{.}}** {{end}}[@{{.Username}}](https://twitter.com/{{.Username}})
+
+{{quote .Text}}
+{{range photos .}}![]({{.}})
+
+{{end}}{{range .OCR}}{{if .Text}}*Text in image:* {{oneline .Text}}
+
+{{end}}{{end}}{{if .PermanentURL}}[{{date .}}]({{.PermanentURL}}) · {{counts .}}{{else}}{{stats .}}{{end}}`
+)
+
+var templateFuncs = template.FuncMap{
+	"byline":  tweetByline,
+	"date":    tweetDate,
+	"counts":  tweetCounts,
+	"stats":   tweetStats,
+	"photos":  tweetPhotos,
+	"trim":    strings.TrimSpace,
+	"join":    strings.Join,
+	"oneline": func(s string) string { return strings.Join(strings.Fields(s), " ") },
+	"quote": func(s string) string {
+		var b strings.Builder
+		for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+			fmt.Fprintf(&b, "> %s\n", line)
+		}
+		return b.String()
+	},
+}
+
+// tweetTemplates are the templates tweet results are rendered with, by
+// output.
+type tweetTemplates map[string]*template.Template
+
+var defaultTweetTemplates = mustTweetTemplates(TemplateConfig{})
+
+// newTweetTemplates parses cfg's templates, falling back to the defaults,
+// and tries each on an empty tweet so one that can't execute, say for a
+// misspelled field, is caught on starting rather than on a request.
+func newTweetTemplates(cfg TemplateConfig) (tweetTemplates, error) {
+	t := make(tweetTemplates)
+	for _, tmpl := range []struct{ output, text, fallback string }{
+		{OutputText, cfg.TweetText, DefaultTweetText},
+		{OutputMarkdown, cfg.TweetMarkdown, DefaultTweetMarkdown},
+	} {
+		text := tmpl.text
+		if text == "" {
+			text = tmpl.fallback
+		}
+		parsed, err := template.New(tmpl.output).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", tmpl.output, err)
+		}
+		t[tmpl.output] = parsed
+		if _, err := t.render(&TweetResult{Tweet: &twitterscraper.Tweet{}}, tmpl.output); err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", tmpl.output, err)
+		}
+	}
+	return t, nil
+}
+
+func mustTweetTemplates(cfg TemplateConfig) tweetTemplates {
+	t, err := newTweetTemplates(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// render executes the template for output on result.
+func (t tweetTemplates) render(result *TweetResult, output string) ([]byte, error) {
+	tmpl, ok := t[output]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output %q", output)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, result); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tweetDate is the tweet's date in UTC, to the minute.
+func tweetDate(result *TweetResult) string {
+	return time.Unix(result.Timestamp, 0).UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// tweetCounts is the tweet's likes, retweets and replies.
+func tweetCounts(result *TweetResult) string {
+	return fmt.Sprintf("%d likes · %d retweets · %d replies", result.Likes, result.Retweets, result.Replies)
+}
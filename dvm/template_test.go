@@ -0,0 +1,60 @@
+package dvm
+
+import (
+	"testing"
+
+	"github.com/imperatrona/twitter-scraper"
+)
+
+func TestDefaultTweetTemplates(t *testing.T) {
+	result := &TweetResult{
+		Tweet: &twitterscraper.Tweet{Name: "Hal Finney", Username: "halfin", Text: "Running\nbitcoin ",
+			Timestamp: 1231469665, Likes: 3, PermanentURL: "https://twitter.com/halfin/status/20",
+			Photos: []twitterscraper.Photo{{URL: "https://pbs.twimg.com/a.jpg"}, {URL: "https://pbs.twimg.com/b.jpg"}}},
+		OCR:         []ImageText{{Text: " block\nheight 1 "}, {}},
+		HostedMedia: []HostedMedia{{Original: "https://pbs.twimg.com/b.jpg", UploadedFile: UploadedFile{URL: "https://host.example/b.jpg"}}},
+	}
+	for output, want := range map[string]string{
+		OutputText: "Hal Finney (@halfin)\n\nRunning\nbitcoin\n\n" +
+			"https://pbs.twimg.com/a.jpg\nhttps://host.example/b.jpg\n\n" +
+			"Text in image: block\nheight 1\n\n" +
+			"2009-01-09 02:54 UTC · 3 likes · 0 retweets · 0 replies\nhttps://twitter.com/halfin/status/20",
+		OutputMarkdown: "**Hal Finney** [@halfin](https://twitter.com/halfin)\n\n> Running\n> bitcoin\n\n" +
+			"![](https://pbs.twimg.com/a.jpg)\n\n![](https://host.example/b.jpg)\n\n" +
+			"*Text in image:* block height 1\n\n" +
+			"[2009-01-09 02:54 UTC](https://twitter.com/halfin/status/20) · 3 likes · 0 retweets · 0 replies",
+	} {
+		got, err := renderTweet(result, output, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", output, got, want)
+		}
+	}
+}
+
+func TestTweetTemplates(t *testing.T) {
+	templates, err := newTweetTemplates(TemplateConfig{TweetMarkdown: "## {{byline .}}\n\n{{oneline .Text}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &TweetResult{Tweet: &twitterscraper.Tweet{Username: "halfin", Text: "Running\nbitcoin"}}
+	if got, _ := renderTweet(result, OutputMarkdown, templates); string(got) != "## @halfin\n\nRunning bitcoin" {
+		t.Errorf("unexpected markdown %q", got)
+	}
+	// The template left empty keeps the default
+	if got, _ := renderTweet(result, OutputText, templates); string(got) != "@halfin\n\nRunning\nbitcoin\n\n1970-01-01 00:00 UTC · 0 likes · 0 retweets · 0 replies" {
+		t.Errorf("unexpected text %q", got)
+	}
+
+	for _, cfg := range []TemplateConfig{
+		{TweetText: "{{.Text"},
+		{TweetMarkdown: "{{.Txet}}"},
+		{TweetText: "{{shout .Text}}"},
+	} {
+		if _, err := NewDvm("ws://localhost:1", testKey(), WithTemplates(cfg)); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
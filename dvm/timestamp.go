@@ -0,0 +1,66 @@
+package dvm
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// TimestampWindow bounds how far a request's created_at may be from the
+// DVM's clock when it arrives. Requests outside it are rejected with
+// ReasonImplausibleTimestamp feedback, as a replay of a request captured
+// long ago, or a relay backfilling old or misdated events, is likelier
+// than a client whose clock is off by that much. Unlike WithMaxRequestAge,
+// which is a freshness budget measured when a request's turn comes, the
+// window is a plausibility check and on by default.
+type TimestampWindow struct {
+	Past   time.Duration // how far behind, default 24h; negative disables the bound
+	Future time.Duration // how far ahead, default 10m; negative disables the bound
+}
+
+func (w TimestampWindow) withDefaults() TimestampWindow {
+	if w.Past == 0 {
+		w.Past = 24 * time.Hour
+	}
+	if w.Future == 0 {
+		w.Future = 10 * time.Minute
+	}
+	return w
+}
+
+// implausible returns how far evt's created_at is outside the window at
+// now, negative if it's in the past, or 0 if it's inside.
+func (w TimestampWindow) implausible(evt *nostr.Event, now time.Time) time.Duration {
+	offset := evt.CreatedAt.Time().Sub(now)
+	switch {
+	case w.Future >= 0 && offset > w.Future:
+		return offset
+	case w.Past >= 0 && -offset > w.Past:
+		return offset
+	}
+	return 0
+}
+
+// rejectedTimestamp rejects evt if its created_at is outside the DVM's
+// timestamp window, reporting whether it did.
+func (d *Dvm) rejectedTimestamp(id *identity, evt *nostr.Event) bool {
+	offset := d.timestamps.implausible(evt, time.Now())
+	if offset == 0 {
+		return false
+	}
+	metricJobsImplausibleTimestamp.Add(1)
+	var msg string
+	if offset > 0 {
+		msg = fmt.Sprintf("Request is dated %v in the future, more than the %v allowed; check your clock",
+			offset.Round(time.Second), d.timestamps.Future)
+	} else {
+		msg = fmt.Sprintf("Request is dated %v in the past, more than the %v allowed; resubmit it if you still want a result",
+			(-offset).Round(time.Second), d.timestamps.Past)
+	}
+	log.Printf("Rejecting request %s from %s: created_at %s is implausible",
+		evt.ID[:8], d.scrub.logPubKey(evt.PubKey), evt.CreatedAt.Time().Format(time.RFC3339))
+	d.publishFeedback(id, evt, StatusError, ReasonImplausibleTimestamp, msg)
+	return true
+}
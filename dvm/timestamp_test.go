@@ -0,0 +1,61 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestTimestampWindow(t *testing.T) {
+	now := time.Now()
+	at := func(offset time.Duration) *nostr.Event {
+		return &nostr.Event{CreatedAt: nostr.Timestamp(now.Add(offset).Unix())}
+	}
+	w := TimestampWindow{}.withDefaults()
+	for _, offset := range []time.Duration{0, -23 * time.Hour, 9 * time.Minute} {
+		if got := w.implausible(at(offset), now); got != 0 {
+			t.Errorf("expected %v to be plausible, got %v", offset, got)
+		}
+	}
+	for _, offset := range []time.Duration{-25 * time.Hour, 11 * time.Minute} {
+		if got := w.implausible(at(offset), now); got == 0 {
+			t.Errorf("expected %v to be implausible", offset)
+		}
+	}
+	if got := (TimestampWindow{Past: -1}).withDefaults().implausible(at(-365*24*time.Hour), now); got != 0 {
+		t.Errorf("expected no past bound, got %v", got)
+	}
+}
+
+func TestImplausibleTimestampsAreRejected(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	scraper := &fakeScraper{}
+	d := startTestDvm(t, relay, WithScraper(scraper), WithTimestampWindow(TimestampWindow{Past: time.Hour, Future: time.Minute}))
+
+	for i, offset := range []time.Duration{-2 * time.Hour, 5 * time.Minute} {
+		req := &nostr.Event{CreatedAt: nostr.Timestamp(time.Now().Add(offset).Unix()),
+			Kind: KindTweetRequest, Tags: nostr.Tags{}, Content: []string{"20", "21"}[i]}
+		req.Sign(testKey())
+		d.handleRequest(req)
+
+		fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+		if fb.Tags.GetFirst([]string{"status", StatusError, ReasonImplausibleTimestamp}) == nil {
+			t.Fatalf("expected implausible-timestamp feedback for %v, got %+v", offset, fb)
+		}
+	}
+	if scraper.Calls() != 0 {
+		t.Error("expected the implausible requests not to be scraped")
+	}
+
+	// One within the window is served
+	req := &nostr.Event{CreatedAt: nostr.Timestamp(time.Now().Add(-30 * time.Minute).Unix()),
+		Kind: KindTweetRequest, Tags: nostr.Tags{}, Content: "22"}
+	req.Sign(testKey())
+	d.handleRequest(req)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind != ResultKind(KindTweetRequest) {
+		t.Errorf("expected a result, got kind %d", resp.Kind)
+	}
+}
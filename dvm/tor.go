@@ -0,0 +1,201 @@
+package dvm
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/proxy"
+)
+
+// TorConfig is the Tor client UseTor sends traffic through.
+type TorConfig struct {
+	Proxy string // the Tor client's SOCKS5 address, default 127.0.0.1:9050
+}
+
+func (c TorConfig) withDefaults() TorConfig {
+	if c.Proxy == "" {
+		c.Proxy = "127.0.0.1:9050"
+	}
+	return c
+}
+
+// torProxyEnv passes the Tor proxy to the scraper worker, which sends its
+// traffic the same way.
+const torProxyEnv = "BANDITA_TOR_PROXY"
+
+// torDial connects through Tor; nil unless UseTor was called.
+var torDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// torTunnels maps the local URLs of relays reached through a tunnel to
+// the relays' own URLs, both normalized.
+var torTunnels sync.Map
+
+// UseTor sends all of the process's traffic through Tor: relay
+// connections, scraping and every other HTTP request, with names resolved
+// by Tor so DNS doesn't give the DVM away either, and with it ".onion"
+// relays and sites. Only connections to this machine's loopback addresses
+// go direct. Call it before creating any DVM or client; it fails if
+// there's no Tor client at cfg.Proxy.
+func UseTor(cfg TorConfig) error {
+	cfg = cfg.withDefaults()
+	conn, err := net.DialTimeout("tcp", cfg.Proxy, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("no Tor client at %s: %w", cfg.Proxy, err)
+	}
+	conn.Close()
+	socks, err := proxy.SOCKS5("tcp", cfg.Proxy, nil, &net.Dialer{Timeout: 30 * time.Second})
+	if err != nil {
+		return err
+	}
+	dialer := socks.(proxy.ContextDialer)
+	torDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && isLoopbackHost(host) {
+			var direct net.Dialer
+			return direct.DialContext(ctx, network, addr)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport)
+	transport.Proxy = nil
+	transport.DialContext = torDial
+	return os.Setenv(torProxyEnv, cfg.Proxy)
+}
+
+// isLoopbackHost reports whether host, a name or an address, is this
+// machine.
+func isLoopbackHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// isOnion reports whether rawURL is that of a Tor onion service.
+func isOnion(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && strings.HasSuffix(strings.ToLower(u.Hostname()), ".onion")
+}
+
+// relayURL returns the URL relay was dialed for, which isn't its own if it
+// was reached through a Tor tunnel.
+func relayURL(relay *nostr.Relay) string {
+	if original, ok := torTunnels.Load(relay.URL); ok {
+		return original.(string)
+	}
+	return relay.URL
+}
+
+// torTunnel returns a local websocket URL leading to the relay at rawURL
+// through Tor, for the websocket library, which can't use a proxy itself.
+// It takes a single connection, within the dial timeout.
+func torTunnel(rawURL string) (string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	addr := target.Host
+	switch target.Scheme {
+	case "ws":
+		if target.Port() == "" {
+			addr = net.JoinHostPort(target.Hostname(), "80")
+		}
+	case "wss":
+		if target.Port() == "" {
+			addr = net.JoinHostPort(target.Hostname(), "443")
+		}
+	default:
+		return "", fmt.Errorf("invalid relay URL %q", rawURL)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(time.Minute))
+	go func() {
+		defer ln.Close()
+		client, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		serveTorTunnel(client, target, addr)
+	}()
+
+	local := url.URL{Scheme: "ws", Host: ln.Addr().String(), Path: target.Path, RawQuery: target.RawQuery}
+	return local.String(), nil
+}
+
+// serveTorTunnel passes the websocket handshake read from client on to the
+// relay at target, dialed at addr through Tor, as if it were for the relay
+// all along, then relays the connection both ways until either end closes.
+func serveTorTunnel(client net.Conn, target *url.URL, addr string) {
+	defer client.Close()
+	reader := bufio.NewReader(client)
+	client.SetReadDeadline(time.Now().Add(30 * time.Second))
+	handshake, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	client.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	upstream, err := torDial(ctx, "tcp", addr)
+	if err != nil {
+		fmt.Fprintf(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	if target.Scheme == "wss" {
+		secure := tls.Client(upstream, &tls.Config{ServerName: target.Hostname()})
+		if err := secure.HandshakeContext(ctx); err != nil {
+			upstream.Close()
+			fmt.Fprintf(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		upstream = secure
+	}
+	defer upstream.Close()
+
+	handshake.Host = target.Host
+	if err := handshake.Write(upstream); err != nil {
+		return
+	}
+	go func() {
+		io.Copy(upstream, reader)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+}
+
+// dialRelayURL returns the URL to dial the relay at rawURL at: its own,
+// or in Tor mode a tunnel's, with a function to call once the connection
+// is closed.
+func dialRelayURL(rawURL string) (string, func(), error) {
+	if torDial == nil {
+		if isOnion(rawURL) {
+			return "", nil, errors.New("relay is an onion service, which needs Tor")
+		}
+		return rawURL, func() {}, nil
+	}
+	local, err := torTunnel(rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	key := nostr.NormalizeURL(local)
+	torTunnels.Store(key, nostr.NormalizeURL(rawURL))
+	return local, func() { torTunnels.Delete(key) }, nil
+}
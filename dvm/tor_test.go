@@ -0,0 +1,180 @@
+package dvm
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+// fakeTor is a SOCKS5 proxy standing in for a Tor client, connecting to
+// the addresses in hosts by name and recording what it was asked for.
+type fakeTor struct {
+	ln    net.Listener
+	hosts map[string]string // name:port to the address it stands for
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func startFakeTor(t *testing.T, hosts map[string]string) *fakeTor {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeTor{ln: ln, hosts: hosts}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeTor) serve(conn net.Conn) {
+	defer conn.Close()
+	// Greeting: version, methods; we take no authentication
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	io.CopyN(io.Discard, conn, int64(head[1]))
+	conn.Write([]byte{5, 0})
+
+	// CONNECT by domain name, the only way Tor is asked
+	req := make([]byte, 5)
+	if _, err := io.ReadFull(conn, req); err != nil || req[3] != 3 {
+		return
+	}
+	name := make([]byte, int(req[4])+2)
+	if _, err := io.ReadFull(conn, name); err != nil {
+		return
+	}
+	target := string(name[:len(name)-2]) + ":" + strconv.Itoa(int(binary.BigEndian.Uint16(name[len(name)-2:])))
+	f.mu.Lock()
+	f.targets = append(f.targets, target)
+	f.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", f.hosts[target])
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func (f *fakeTor) asked(target string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// useTestTor sends the process's traffic through f until the test ends.
+func useTestTor(t *testing.T, f *fakeTor) {
+	transport := http.DefaultTransport.(*http.Transport)
+	dial, proxy := transport.DialContext, transport.Proxy
+	t.Cleanup(func() {
+		torDial = nil
+		transport.DialContext, transport.Proxy = dial, proxy
+		transport.CloseIdleConnections()
+	})
+	t.Setenv(torProxyEnv, "")
+	if err := UseTor(TorConfig{Proxy: f.ln.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTorRelays(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	onion := "ws://bandita2relayxyz.onion"
+	tor := startFakeTor(t, map[string]string{
+		"bandita2relayxyz.onion:80": strings.TrimPrefix(relay.URL(), "ws://"),
+	})
+
+	if _, err := NewDvm(onion, testKey()); err == nil || !strings.Contains(err.Error(), "needs Tor") {
+		t.Fatalf("expected an onion relay to need Tor, got %v", err)
+	}
+	useTestTor(t, tor)
+
+	d, err := NewDvm(onion, testKey(), WithScraper(&fakeScraper{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go d.Run(runCtx)
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := NewDvmClient(onion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.RequestTweet(ctx, d.GetPublicKey(), "20"); err != nil {
+		t.Fatalf("expected a result through Tor: %v", err)
+	}
+	if !tor.asked("bandita2relayxyz.onion:80") {
+		t.Error("expected the relay to be reached through Tor")
+	}
+}
+
+func TestTorHTTP(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.Host)
+	}))
+	defer site.Close()
+	tor := startFakeTor(t, map[string]string{"example.com:80": strings.TrimPrefix(site.URL, "http://")})
+	useTestTor(t, tor)
+
+	body, err := fetch(context.Background(), newFetchClient(), "http://example.com/", "")
+	if err != nil || string(body) != "hello from example.com" {
+		t.Fatalf("expected the page through Tor, got %q, %v", body, err)
+	}
+	if !tor.asked("example.com:80") {
+		t.Error("expected the name to be resolved by Tor")
+	}
+
+	// Jobs still can't reach this machine, which Tor wouldn't stop
+	if _, err := fetch(context.Background(), newFetchClient(), site.URL, ""); err == nil ||
+		!strings.Contains(err.Error(), "non-public") {
+		t.Errorf("expected a loopback fetch to be refused, got %v", err)
+	}
+
+	// Every other client goes through Tor too
+	tor.mu.Lock()
+	tor.targets = nil
+	tor.mu.Unlock()
+	resp, err := http.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !tor.asked("example.com:80") {
+		t.Error("expected the default client to go through Tor")
+	}
+}
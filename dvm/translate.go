@@ -0,0 +1,177 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Translation is the result of a KindTranslateRequest job.
+type Translation struct {
+	TweetID        string `json:"tweet_id,omitempty"` // when a tweet was translated
+	Text           string `json:"text"`
+	Translated     string `json:"translated"`
+	SourceLanguage string `json:"source_language,omitempty"`
+	TargetLanguage string `json:"target_language"`
+}
+
+// Translator translates text for KindTranslateRequest jobs. Deployments pick
+// one with WithTranslator; see NewTranslator for the built-in providers.
+type Translator interface {
+	// Translate translates text into target, an ISO 639-1 code such as "en".
+	// An empty source asks the provider to detect the language, which it
+	// returns alongside the translation.
+	Translate(ctx context.Context, text, source, target string) (translated, detected string, err error)
+}
+
+const (
+	defaultTargetLanguage = "en"
+
+	// maxTranslateChars bounds arbitrary text input; most providers bill
+	// per character.
+	maxTranslateChars = 5000
+)
+
+var languageCode = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{2,4})?$`)
+
+// NewTranslator returns a built-in provider: "libretranslate", whose
+// endpoint is required and can be a self-hosted instance running local
+// models, or "deepl". apiKey may be empty for keyless LibreTranslate servers.
+func NewTranslator(provider, endpoint, apiKey string) (Translator, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch strings.ToLower(provider) {
+	case "libretranslate":
+		if endpoint == "" {
+			return nil, errors.New("libretranslate requires an endpoint URL")
+		}
+		return &libreTranslate{endpoint: strings.TrimSuffix(endpoint, "/"), apiKey: apiKey, client: client}, nil
+	case "deepl":
+		if apiKey == "" {
+			return nil, errors.New("deepl requires an API key")
+		}
+		if endpoint == "" {
+			// Free-tier keys end in ":fx" and have their own host
+			endpoint = "https://api.deepl.com"
+			if strings.HasSuffix(apiKey, ":fx") {
+				endpoint = "https://api-free.deepl.com"
+			}
+		}
+		return &deepL{endpoint: strings.TrimSuffix(endpoint, "/"), apiKey: apiKey, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown translation provider %q", provider)
+	}
+}
+
+// libreTranslate uses a LibreTranslate server (https://libretranslate.com).
+type libreTranslate struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (t *libreTranslate) Translate(ctx context.Context, text, source, target string) (string, string, error) {
+	if source == "" {
+		source = "auto"
+	}
+	body := map[string]string{"q": text, "source": source, "target": target, "format": "text"}
+	if t.apiKey != "" {
+		body["api_key"] = t.apiKey
+	}
+	var resp struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := postJSON(ctx, t.client, t.endpoint+"/translate", nil, body, &resp); err != nil {
+		return "", "", err
+	}
+	if source == "auto" {
+		source = resp.DetectedLanguage.Language
+	}
+	return resp.TranslatedText, source, nil
+}
+
+// deepL uses the DeepL API (https://developers.deepl.com).
+type deepL struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (t *deepL) Translate(ctx context.Context, text, source, target string) (string, string, error) {
+	body := map[string]any{"text": []string{text}, "target_lang": strings.ToUpper(target)}
+	if source != "" {
+		body["source_lang"] = strings.ToUpper(source)
+	}
+	header := http.Header{"Authorization": {"DeepL-Auth-Key " + t.apiKey}}
+	var resp struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := postJSON(ctx, t.client, t.endpoint+"/v2/translate", header, body, &resp); err != nil {
+		return "", "", err
+	}
+	if len(resp.Translations) == 0 {
+		return "", "", errors.New("deepl returned no translation")
+	}
+	tr := resp.Translations[0]
+	return tr.Text, strings.ToLower(tr.DetectedSourceLanguage), nil
+}
+
+// translateHandler translates a tweet, given its ID, or the request's text.
+// Requests may carry ["param", "language", "<code>"] for the target language
+// (default English) and ["param", "source", "<code>"] to skip detection.
+type translateHandler struct {
+	d          *Dvm
+	translator Translator
+}
+
+func (h *translateHandler) Validate(req *nostr.Event) error {
+	text := strings.TrimSpace(req.Content)
+	if text == "" {
+		return errors.New("nothing to translate")
+	}
+	if len([]rune(text)) > maxTranslateChars {
+		return fmt.Errorf("text exceeds %d characters", maxTranslateChars)
+	}
+	for _, name := range []string{"language", "source"} {
+		if code, ok := paramValue(req, name); ok && !languageCode.MatchString(code) {
+			return fmt.Errorf("%s param is not a language code", name)
+		}
+	}
+	return nil
+}
+
+func (h *translateHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	result := Translation{Text: strings.TrimSpace(req.Content), TargetLanguage: defaultTargetLanguage}
+	if language, ok := paramValue(req, "language"); ok {
+		result.TargetLanguage = strings.ToLower(language)
+	}
+	source, _ := paramValue(req, "source")
+	source = strings.ToLower(source)
+
+	if tweetIDPattern.MatchString(result.Text) {
+		tweet, err := h.d.fetchTweet(result.Text)
+		if err != nil {
+			return nil, err
+		}
+		result.TweetID, result.Text = tweet.ID, tweet.Text
+	}
+
+	var err error
+	result.Translated, result.SourceLanguage, err = h.translator.Translate(ctx, result.Text, source, result.TargetLanguage)
+	if err != nil {
+		return nil, fmt.Errorf("translation failed: %w", err)
+	}
+	return json.Marshal(result)
+}
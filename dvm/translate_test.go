@@ -0,0 +1,105 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestTranslatorProviders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/translate": // LibreTranslate
+			if body["source"] != "auto" || body["target"] != "en" || body["api_key"] != "lt-key" {
+				t.Errorf("unexpected LibreTranslate request: %v", body)
+			}
+			w.Write([]byte(`{"translatedText":"Hello","detectedLanguage":{"confidence":90,"language":"de"}}`))
+		case "/v2/translate": // DeepL
+			if r.Header.Get("Authorization") != "DeepL-Auth-Key dl-key" || body["target_lang"] != "EN" {
+				t.Errorf("unexpected DeepL request: %v %v", r.Header, body)
+			}
+			w.Write([]byte(`{"translations":[{"detected_source_language":"DE","text":"Hello"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, tc := range []struct{ provider, key string }{{"libretranslate", "lt-key"}, {"deepl", "dl-key"}} {
+		tr, err := NewTranslator(tc.provider, srv.URL, tc.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		translated, detected, err := tr.Translate(ctx, "Hallo", "", "en")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.provider, err)
+		}
+		if translated != "Hello" || detected != "de" {
+			t.Errorf("%s: got %q from %q", tc.provider, translated, detected)
+		}
+	}
+
+	if _, err := NewTranslator("libretranslate", "", ""); err == nil {
+		t.Error("expected libretranslate without an endpoint to be rejected")
+	}
+	if _, err := NewTranslator("babelfish", srv.URL, ""); err == nil {
+		t.Error("expected an unknown provider to be rejected")
+	}
+}
+
+// echoTranslator "translates" by tagging the text with the target language.
+type echoTranslator struct{}
+
+func (echoTranslator) Translate(ctx context.Context, text, source, target string) (string, string, error) {
+	return "[" + target + "] " + text, "xx", nil
+}
+
+func TestTranslateHandler(t *testing.T) {
+	d := &Dvm{scraper: &fakeScraper{}}
+	h := &translateHandler{d: d, translator: echoTranslator{}}
+	ctx := context.Background()
+
+	req := &nostr.Event{Content: "1110302988", Tags: nostr.Tags{{"param", "language", "de"}}}
+	if err := h.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.Handle(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tr Translation
+	if err := json.Unmarshal(result, &tr); err != nil {
+		t.Fatal(err)
+	}
+	want := Translation{TweetID: "1110302988", Text: "Running bitcoin", Translated: "[de] Running bitcoin", SourceLanguage: "xx", TargetLanguage: "de"}
+	if tr != want {
+		t.Errorf("got  %+v\nwant %+v", tr, want)
+	}
+
+	// Arbitrary text, default target language
+	result, err = h.Handle(ctx, &nostr.Event{Content: "Bonjour"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr = Translation{}
+	json.Unmarshal(result, &tr)
+	if tr.TweetID != "" || tr.Translated != "[en] Bonjour" {
+		t.Errorf("unexpected text translation: %+v", tr)
+	}
+
+	if err := h.Validate(&nostr.Event{Content: "hi", Tags: nostr.Tags{{"param", "language", "not a language"}}}); err == nil {
+		t.Error("expected a bad language code to be rejected")
+	}
+	if err := h.Validate(&nostr.Event{Content: "  "}); err == nil {
+		t.Error("expected empty input to be rejected")
+	}
+}
@@ -0,0 +1,151 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// LinkPreview is the result of a KindUnfurlRequest job: what a client needs
+// to render a link card.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	FinalURL    string `json:"final_url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	Type        string `json:"type,omitempty"` // og:type, e.g. "article"
+	Image       string `json:"image,omitempty"`
+	ImageAlt    string `json:"image_alt,omitempty"`
+	Video       string `json:"video,omitempty"`
+	Favicon     string `json:"favicon,omitempty"`
+	TwitterCard string `json:"twitter_card,omitempty"` // e.g. "summary_large_image"
+}
+
+// maxUnfurlBytes is how much of a page unfurling reads; the metadata lives
+// in the <head>, so there's no need for the whole document.
+const maxUnfurlBytes = 512 << 10
+
+// unfurlHandler reads a page's OpenGraph and Twitter card metadata, a much
+// cheaper job than a full webpage archive.
+type unfurlHandler struct {
+	client *http.Client
+}
+
+func newUnfurlHandler() *unfurlHandler {
+	return &unfurlHandler{client: newFetchClient()}
+}
+
+func (h *unfurlHandler) Validate(req *nostr.Event) error {
+	_, err := parseWebURL(req.Content)
+	return err
+}
+
+func (h *unfurlHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	pageURL := strings.TrimSpace(req.Content)
+	header := http.Header{"Accept": {"text/html,application/xhtml+xml"}}
+	res, _, err := fetchUpTo(ctx, h.client, pageURL, header, maxUnfurlBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(res.URL)
+	if err != nil {
+		return nil, err
+	}
+	preview := parseLinkPreview(res.Body, base)
+	preview.URL = pageURL
+	preview.FinalURL = res.URL
+	return json.Marshal(preview)
+}
+
+// parseLinkPreview reads the <head> of a page, stopping at <body>. Relative
+// image and icon URLs are resolved against base.
+func parseLinkPreview(page []byte, base *url.URL) *LinkPreview {
+	preview := &LinkPreview{}
+	meta := make(map[string]string)
+	var title, favicon string
+
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for done := false; !done; {
+		switch z.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Body:
+				done = true
+			case atom.Title:
+				if z.Next() == html.TextToken && title == "" {
+					title = strings.TrimSpace(string(z.Text()))
+				}
+			case atom.Meta:
+				key := strings.ToLower(tokenAttr(tok, "property"))
+				if key == "" {
+					key = strings.ToLower(tokenAttr(tok, "name"))
+				}
+				if content := strings.TrimSpace(tokenAttr(tok, "content")); key != "" && content != "" {
+					if _, seen := meta[key]; !seen {
+						meta[key] = content
+					}
+				}
+			case atom.Link:
+				rel := strings.ToLower(tokenAttr(tok, "rel"))
+				if favicon == "" && (rel == "icon" || rel == "shortcut icon" || rel == "apple-touch-icon") {
+					favicon = tokenAttr(tok, "href")
+				}
+			}
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := meta[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	preview.Title = firstNonEmpty(first("og:title", "twitter:title"), title)
+	preview.Description = first("og:description", "twitter:description", "description")
+	preview.SiteName = first("og:site_name", "application-name")
+	preview.Type = first("og:type")
+	preview.Image = resolveURL(base, first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"))
+	preview.ImageAlt = first("og:image:alt", "twitter:image:alt")
+	preview.Video = resolveURL(base, first("og:video:secure_url", "og:video", "og:video:url"))
+	preview.TwitterCard = first("twitter:card")
+	if favicon == "" {
+		favicon = "/favicon.ico"
+	}
+	preview.Favicon = resolveURL(base, favicon)
+	return preview
+}
+
+func tokenAttr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// resolveURL makes ref absolute relative to base, leaving it empty if unset.
+func resolveURL(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
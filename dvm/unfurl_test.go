@@ -0,0 +1,37 @@
+package dvm
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseLinkPreview(t *testing.T) {
+	page := `<html><head>
+		<title>Fallback &amp; title</title>
+		<meta property="og:title" content="Bitcoin: A Peer-to-Peer Electronic Cash System">
+		<meta name="description" content="The whitepaper">
+		<meta property="og:image" content="/img/card.png">
+		<meta name="twitter:card" content="summary_large_image">
+		<link rel="icon" href="favicon.svg">
+	</head><body>
+		<meta property="og:description" content="ignored, after the head">
+	</body></html>`
+	base, _ := url.Parse("https://bitcoin.org/en/bitcoin-paper")
+
+	preview := parseLinkPreview([]byte(page), base)
+	want := LinkPreview{
+		Title:       "Bitcoin: A Peer-to-Peer Electronic Cash System",
+		Description: "The whitepaper",
+		Image:       "https://bitcoin.org/img/card.png",
+		Favicon:     "https://bitcoin.org/en/favicon.svg",
+		TwitterCard: "summary_large_image",
+	}
+	if *preview != want {
+		t.Errorf("got  %+v\nwant %+v", *preview, want)
+	}
+
+	preview = parseLinkPreview([]byte(`<title>Fallback &amp; title</title>`), base)
+	if preview.Title != "Fallback & title" || preview.Favicon != "https://bitcoin.org/favicon.ico" {
+		t.Errorf("unexpected fallbacks: %+v", preview)
+	}
+}
@@ -0,0 +1,134 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// UploadConfig points at a file host that the DVM uploads media to, so
+// results can reference copies that outlive the original links.
+type UploadConfig struct {
+	Provider string // "blossom" or "nip96"
+	Server   string // e.g. "https://blossom.example.com"
+}
+
+// UploadedFile is a file stored on the upload host.
+type UploadedFile struct {
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+// uploader stores blobs on a file host. A nil uploader means uploads
+// aren't configured.
+type uploader interface {
+	upload(ctx context.Context, data []byte, mimeType string) (*UploadedFile, error)
+}
+
+func newUploader(cfg UploadConfig, sk string) (uploader, error) {
+	if cfg.Server == "" {
+		return nil, fmt.Errorf("uploads require a server")
+	}
+	server := strings.TrimSuffix(cfg.Server, "/")
+	client := &http.Client{Timeout: 5 * time.Minute}
+	switch cfg.Provider {
+	case "blossom":
+		return &blossomUploader{server: server, sk: sk, client: client}, nil
+	case "nip96":
+		return &nip96Uploader{server: server, sk: sk, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown upload provider %q", cfg.Provider)
+	}
+}
+
+// KindBlossomAuth is the kind of Blossom authorization events (BUD-01).
+const KindBlossomAuth = 24242
+
+// blossomUploader uploads to a Blossom server (BUD-02), authorizing with
+// events signed by the DVM's key.
+type blossomUploader struct {
+	server string
+	sk     string
+	client *http.Client
+}
+
+func (b *blossomUploader) upload(ctx context.Context, data []byte, mimeType string) (*UploadedFile, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	auth, err := b.authorization("upload", hash)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.server+"/upload", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if mimeType != "" {
+		req.Header.Set("Content-Type", mimeType)
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("User-Agent", fetchUserAgent)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("blossom upload returned %s: %s %s", resp.Status, resp.Header.Get("X-Reason"), msg)
+	}
+	var blob struct {
+		URL    string `json:"url"`
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+		Type   string `json:"type"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxFetchBytes)).Decode(&blob); err != nil {
+		return nil, fmt.Errorf("invalid blob descriptor: %w", err)
+	}
+	if blob.SHA256 != hash || blob.URL == "" {
+		return nil, fmt.Errorf("blossom server stored %q as %q, expected hash %s", blob.URL, blob.SHA256, hash)
+	}
+	if blob.Type == "" {
+		blob.Type = mimeType
+	}
+	return &UploadedFile{URL: blob.URL, SHA256: hash, Size: int64(len(data)), MimeType: blob.Type}, nil
+}
+
+// authorization returns the Authorization header for verb on the blob
+// with the given hash.
+func (b *blossomUploader) authorization(verb, hash string) (string, error) {
+	evt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindBlossomAuth,
+		Tags: nostr.Tags{
+			{"t", verb},
+			{"x", hash},
+			{"expiration", strconv.FormatInt(time.Now().Add(5*time.Minute).Unix(), 10)},
+		},
+		Content: fmt.Sprintf("Authorize %s of %s", verb, hash),
+	}
+	if err := evt.Sign(b.sk); err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(raw), nil
+}
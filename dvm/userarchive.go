@@ -0,0 +1,178 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// TimelineScraper fetches a user's tweets, newest first, a page at a time.
+// *twitterscraper.Scraper satisfies it; user archive jobs need it.
+type TimelineScraper interface {
+	FetchTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error)
+}
+
+// UserArchive is the final result of a KindUserArchiveRequest job. The
+// tweets themselves are in Pages result events tagged ["page", <n>], or
+// for ["param", "format", "upload"] in a single JSON file at Archive.
+type UserArchive struct {
+	Handle  string        `json:"handle"`
+	Tweets  int           `json:"tweets"`
+	Pages   int           `json:"pages,omitempty"`
+	Archive *UploadedFile `json:"archive,omitempty"`
+}
+
+// UserArchivePage is the content of one page event.
+type UserArchivePage struct {
+	Handle string                  `json:"handle"`
+	Page   int                     `json:"page"`
+	Tweets []*twitterscraper.Tweet `json:"tweets"`
+}
+
+const (
+	// maxUserArchiveTweets is about as far back as Twitter's timelines go.
+	maxUserArchiveTweets     = 3200
+	defaultUserArchiveTweets = 200
+	userArchivePageSize      = 100
+)
+
+// userArchiveHandler scrapes a user's most recent tweets (["param", "max",
+// <n>]), priced per tweet and paid up front.
+type userArchiveHandler struct {
+	d        *Dvm
+	timeline TimelineScraper
+}
+
+// userArchiveParams are the params of a user archive request.
+type userArchiveParams struct {
+	Max    int    `param:"max"`
+	Format string `param:"format"`
+}
+
+func (h *userArchiveHandler) params(req *nostr.Event) (userArchiveParams, error) {
+	params := userArchiveParams{Max: defaultUserArchiveTweets, Format: "events"}
+	if err := DecodeParams(req, &params); err != nil {
+		return params, err
+	}
+	if params.Max < 1 || params.Max > maxUserArchiveTweets {
+		return params, fmt.Errorf("max param must be between 1 and %d", maxUserArchiveTweets)
+	}
+	switch params.Format {
+	case "events":
+	case "upload":
+		if h.d.uploader == nil {
+			return params, fmt.Errorf("this DVM has no upload host")
+		}
+	default:
+		return params, fmt.Errorf("unknown format %q", params.Format)
+	}
+	return params, nil
+}
+
+func (h *userArchiveHandler) Validate(req *nostr.Event) error {
+	if !twitterHandlePattern.MatchString(strings.TrimSpace(req.Content)) {
+		return fmt.Errorf("content is not a Twitter handle")
+	}
+	_, err := h.params(req)
+	return err
+}
+
+func (h *userArchiveHandler) Units(req *nostr.Event) int64 {
+	params, _ := h.params(req)
+	return int64(params.Max)
+}
+
+func (h *userArchiveHandler) Unit() string {
+	return "tweet"
+}
+
+func (h *userArchiveHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	params, err := h.params(req)
+	if err != nil {
+		return nil, err
+	}
+	handle := strings.TrimPrefix(strings.TrimSpace(req.Content), "@")
+	limit := params.Max
+	upload := params.Format == "upload"
+
+	result := UserArchive{Handle: handle}
+	var all []*twitterscraper.Tweet
+	var pending []*twitterscraper.Tweet
+	flush := func() error {
+		result.Pages++
+		page, err := json.Marshal(UserArchivePage{Handle: handle, Page: result.Pages, Tweets: pending})
+		if err != nil {
+			return err
+		}
+		pending = nil
+		return publishPage(ctx, result.Pages, page)
+	}
+
+	cursor := ""
+	for result.Tweets < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		count := limit - result.Tweets
+		if count > userArchivePageSize {
+			count = userArchivePageSize
+		}
+		tweets, next, err := h.timeline.FetchTweets(handle, count, cursor)
+		if err != nil {
+			if result.Tweets == 0 {
+				return nil, err
+			}
+			// Keep what we have; the requester paid for it
+			log.Printf("Stopping archive of @%s after %d tweets: %v", handle, result.Tweets, err)
+			break
+		}
+		if len(tweets) == 0 {
+			break // the start of the timeline
+		}
+		for _, tweet := range tweets {
+			if result.Tweets == limit {
+				break
+			}
+			result.Tweets++
+			if upload {
+				all = append(all, tweet)
+				continue
+			}
+			pending = append(pending, tweet)
+			if len(pending) == userArchivePageSize {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		reportProgress(ctx, "Fetched %d of up to %d tweets from @%s", result.Tweets, limit, handle)
+		if next == "" || next == cursor {
+			break
+		}
+		cursor = next
+	}
+
+	if result.Tweets == 0 {
+		return nil, errors.New("no tweets found")
+	}
+	if upload {
+		data, err := json.Marshal(all)
+		if err != nil {
+			return nil, err
+		}
+		if result.Archive, err = h.d.uploader.upload(ctx, data, "application/json"); err != nil {
+			return nil, fmt.Errorf("uploading archive: %w", err)
+		}
+	} else if len(pending) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(result)
+}
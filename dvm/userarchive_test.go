@@ -0,0 +1,136 @@
+package dvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	twitterscraper "github.com/imperatrona/twitter-scraper"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// timelineScraper serves a timeline of n tweets, newest first, in pages.
+type timelineScraper struct {
+	fakeScraper
+	n int
+}
+
+func (s *timelineScraper) FetchTweets(user string, maxTweetsNbr int, cursor string) ([]*twitterscraper.Tweet, string, error) {
+	start, _ := strconv.Atoi(cursor)
+	var tweets []*twitterscraper.Tweet
+	for i := start; i < s.n && len(tweets) < maxTweetsNbr; i++ {
+		tweets = append(tweets, &twitterscraper.Tweet{ID: fmt.Sprint(s.n - i), Username: user, Text: "tweet"})
+	}
+	return tweets, strconv.Itoa(start + len(tweets)), nil
+}
+
+func TestUserArchive(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+	defer func(interval time.Duration) { paymentPollInterval = interval }(paymentPollInterval)
+	paymentPollInterval = 50 * time.Millisecond
+
+	zapperSK := testKey()
+	zapperPK, _ := nostr.GetPublicKey(zapperSK)
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := startTestDvm(t, relay,
+		WithScraper(&timelineScraper{n: 250}),
+		WithStore(store),
+		WithPayments(PaymentConfig{ZapperPubKey: zapperPK, Timeout: 5 * time.Second}),
+		WithUserArchive(10),
+	)
+
+	req := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindUserArchiveRequest, Content: "@halfin",
+		Tags: nostr.Tags{{"param", "max", "300"}}}
+	req.Sign(testKey())
+	go d.handleRequest(req)
+
+	// 300 tweets at 10 msats is 3 sats
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Tags.GetFirst([]string{"amount", "3000"}) == nil {
+		t.Fatalf("expected a payment request for 3000 msats, got %+v", fb)
+	}
+	relay.Publish(newZapReceipt(zapperSK, req.ID, d.GetPublicKey(), "lnbc30n1pvjluez"))
+
+	// Pages and feedback are published concurrently with the final result,
+	// which can reach the relay first, so wait for as many pages as it
+	// says there are
+	var final *nostr.Event
+	var pages []*nostr.Event
+	progress := 0
+	complete := func() bool {
+		if final == nil || progress < 3 {
+			return false
+		}
+		n, err := strconv.Atoi(tagValue(final.Tags, "total-pages"))
+		return err == nil && len(pages) >= n
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !complete() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		final, pages, progress = nil, nil, 0
+		for _, evt := range relay.Events() {
+			if evt.PubKey != d.GetPublicKey() || evt.Tags.GetFirst([]string{"e", req.ID}) == nil {
+				continue
+			}
+			switch {
+			case evt.Kind == KindJobFeedback && evt.Tags.GetFirst([]string{"status", StatusProcessing}) != nil:
+				progress++
+			case evt.Kind == ResultKind(req.Kind) && evt.Tags.GetFirst([]string{"page"}) != nil:
+				pages = append(pages, evt)
+			case evt.Kind == ResultKind(req.Kind):
+				final = evt
+			}
+		}
+	}
+	if final == nil {
+		t.Fatal("no final result")
+	}
+
+	var archive UserArchive
+	if err := json.Unmarshal([]byte(final.Content), &archive); err != nil {
+		t.Fatal(err)
+	}
+	if archive.Handle != "halfin" || archive.Tweets != 250 || archive.Pages != 3 || len(pages) != 3 {
+		t.Errorf("unexpected archive %+v with %d page events", archive, len(pages))
+	}
+	if progress != 3 {
+		t.Errorf("expected 3 progress updates, got %d", progress)
+	}
+	total := 0
+	for _, evt := range pages {
+		var page UserArchivePage
+		if err := json.Unmarshal([]byte(evt.Content), &page); err != nil {
+			t.Fatal(err)
+		}
+		if evt.Tags.GetFirst([]string{"page", strconv.Itoa(page.Page)}) == nil {
+			t.Errorf("page %d is tagged %v", page.Page, evt.Tags)
+		}
+		total += len(page.Tweets)
+	}
+	if total != 250 {
+		t.Errorf("expected 250 tweets across pages, got %d", total)
+	}
+
+	entries, err := NewLedger(store).Entries(time.Time{}, time.Time{})
+	if err != nil || len(entries) != 1 || entries[0].Sats != 3 || entries[0].JobID != req.ID {
+		t.Errorf("expected the payment in the ledger, got %+v, %v", entries, err)
+	}
+}
+
+func TestUserArchiveNeedsPayments(t *testing.T) {
+	d := &Dvm{scraper: &timelineScraper{}, pricing: PricingConfig{KindUserArchiveRequest: {PerUnitMsats: 10}}}
+	if _, ok := d.defaultHandlers()[KindUserArchiveRequest]; ok {
+		t.Error("user archives shouldn't be served without payments")
+	}
+	d.payments = &PaymentConfig{}
+	if _, ok := d.defaultHandlers()[KindUserArchiveRequest]; !ok {
+		t.Error("expected user archives with payments configured")
+	}
+}
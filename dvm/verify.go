@@ -0,0 +1,135 @@
+package dvm
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Sizes of the signature verification caches.
+const (
+	verifiedEventsCacheSize = 10000
+	pubKeyCacheSize         = 1000
+)
+
+// verifier is the process's signature verification cache. Connections in
+// relayConns skip go-nostr's own check (AssumeValid), and whatever reads
+// events from them checks them with verifyEvent instead, so an event seen
+// on several relays, or replayed by a resubscribe, is verified once.
+var verifier = newSigVerifier(verifiedEventsCacheSize, pubKeyCacheSize)
+
+// sigVerifier checks event signatures, remembering events it has verified
+// by ID and the parsed public keys of recent signers. Parsing a public key
+// is a good part of the cost of verifying, and a repeat requester's key
+// doesn't change between requests.
+type sigVerifier struct {
+	mu       sync.Mutex
+	verified *lru[string] // signature, by event ID
+	pubKeys  *lru[*btcec.PublicKey]
+}
+
+func newSigVerifier(events, pubKeys int) *sigVerifier {
+	return &sigVerifier{verified: newLRU[string](events), pubKeys: newLRU[*btcec.PublicKey](pubKeys)}
+}
+
+// verifyEvent reports whether evt's ID is the hash of its contents and its
+// signature is valid for its pubkey.
+func verifyEvent(evt *nostr.Event) bool {
+	return verifier.verify(evt)
+}
+
+func (v *sigVerifier) verify(evt *nostr.Event) bool {
+	// The ID is hashed afresh, so a cached ID vouches for these contents
+	hash := sha256.Sum256(evt.Serialize())
+	if hex.EncodeToString(hash[:]) != evt.ID {
+		return false
+	}
+	v.mu.Lock()
+	sig, ok := v.verified.get(evt.ID)
+	pubKey, known := v.pubKeys.get(evt.PubKey)
+	v.mu.Unlock()
+	if ok && sig == evt.Sig {
+		return true
+	}
+
+	if !known {
+		raw, err := hex.DecodeString(evt.PubKey)
+		if err != nil {
+			return false
+		}
+		if pubKey, err = schnorr.ParsePubKey(raw); err != nil {
+			return false
+		}
+	}
+	raw, err := hex.DecodeString(evt.Sig)
+	if err != nil {
+		return false
+	}
+	parsed, err := schnorr.ParseSignature(raw)
+	if err != nil || !parsed.Verify(hash[:], pubKey) {
+		return false
+	}
+
+	v.mu.Lock()
+	v.verified.put(evt.ID, evt.Sig)
+	v.pubKeys.put(evt.PubKey, pubKey)
+	v.mu.Unlock()
+	return true
+}
+
+// verifiedEvents returns the events with valid signatures, for query
+// results from relayConns connections.
+func verifiedEvents(events []*nostr.Event) []*nostr.Event {
+	valid := events[:0]
+	for _, evt := range events {
+		if verifyEvent(evt) {
+			valid = append(valid, evt)
+		}
+	}
+	return valid
+}
+
+// lru is a count-bounded LRU map. It isn't safe for concurrent use.
+type lru[V any] struct {
+	max   int
+	ll    *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any](max int) *lru[V] {
+	return &lru[V]{max: max, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lru[V]) get(key string) (V, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry[V]).value, true
+}
+
+func (c *lru[V]) put(key string, value V) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry[V]).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[V]{key: key, value: value})
+	if c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).key)
+	}
+}
@@ -0,0 +1,145 @@
+package dvm
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// WatchdogConfig configures the DVM's self-monitor, which samples its
+// goroutines, heap and queue depths and reports any whose floor keeps
+// rising, the mark of a leak such as subscriptions a reconnect left open.
+type WatchdogConfig struct {
+	Interval time.Duration // between samples, default 1m
+	Window   int           // samples a trend is judged over, default 15
+	Growth   float64       // rise of a measure's floor over the window reported, default 0.5 (50%)
+}
+
+func (c WatchdogConfig) withDefaults() WatchdogConfig {
+	if c.Interval <= 0 {
+		c.Interval = time.Minute
+	}
+	if c.Window < 4 {
+		c.Window = 15
+	}
+	if c.Growth <= 0 {
+		c.Growth = 0.5
+	}
+	return c
+}
+
+// watchdog keeps the recent samples of each measure. A measure is rising
+// when the least it was over the newer half of the window is above the
+// least over the older half by the growth fraction: load comes and goes,
+// but a leak raises the floor.
+type watchdog struct {
+	cfg     WatchdogConfig
+	samples map[string][]int64 // oldest first, at most cfg.Window
+	rising  map[string]bool    // measures last reported rising
+}
+
+func newWatchdog(cfg WatchdogConfig) *watchdog {
+	return &watchdog{cfg: cfg, samples: make(map[string][]int64), rising: make(map[string]bool)}
+}
+
+// measure is one sampled resource.
+type measure struct {
+	name  string
+	value int64
+}
+
+// record adds a sample of a measure, returning its floor over the older
+// and newer halves of the window and whether that's a rise.
+func (w *watchdog) record(name string, value int64) (from, to int64, rising bool) {
+	samples := append(w.samples[name], value)
+	if len(samples) > w.cfg.Window {
+		samples = samples[1:]
+	}
+	w.samples[name] = samples
+	if len(samples) < w.cfg.Window {
+		return 0, 0, false
+	}
+
+	half := len(samples) / 2
+	from, to = floor(samples[:half]), floor(samples[half:])
+	base := from
+	if base < 1 {
+		base = 1
+	}
+	return from, to, to > from && float64(to-from) >= w.cfg.Growth*float64(base)
+}
+
+func floor(samples []int64) int64 {
+	least := samples[0]
+	for _, v := range samples[1:] {
+		if v < least {
+			least = v
+		}
+	}
+	return least
+}
+
+// sampleResources measures what a leak would grow.
+func (d *Dvm) sampleResources() []measure {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	subs := 0
+	for _, r := range d.pool.list() {
+		r.mu.Lock()
+		if r.conn != nil {
+			subs += r.conn.Subscriptions.Size()
+		}
+		r.mu.Unlock()
+	}
+	return []measure{
+		{"goroutines", int64(runtime.NumGoroutine())},
+		{"heap_bytes", int64(mem.HeapAlloc)},
+		{"job_queue", int64(len(d.queue))},
+		{"publish_queue", metricPublishQueueDepth.Value()},
+		{"relay_subscriptions", int64(subs)},
+	}
+}
+
+// runWatchdog samples the DVM's resources every interval, logging each
+// sample and alerting when a measure starts rising.
+func (d *Dvm) runWatchdog(ctx context.Context) {
+	ticker := time.NewTicker(d.watchdog.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.checkResources()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkResources takes one sample and reports changes in what's rising.
+func (d *Dvm) checkResources() {
+	w := d.watchdog
+	var line []string
+	for _, m := range d.sampleResources() {
+		gauge := new(expvar.Int)
+		gauge.Set(m.value)
+		metricWatchdog.Set(m.name, gauge)
+		line = append(line, fmt.Sprintf("%s=%d", m.name, m.value))
+
+		from, to, rising := w.record(m.name, m.value)
+		switch {
+		case rising && !w.rising[m.name]:
+			msg := fmt.Sprintf("%s keeps rising: its floor went from %d to %d over the last %v",
+				m.name, from, to, time.Duration(w.cfg.Window)*w.cfg.Interval)
+			log.Printf("Watchdog: %s", msg)
+			d.alerts.raise(alertResourceGrowth, msg)
+		case !rising && w.rising[m.name]:
+			log.Printf("Watchdog: %s has stopped rising at %d", m.name, m.value)
+		}
+		w.rising[m.name] = rising
+	}
+	log.Printf("Watchdog: %s", strings.Join(line, " "))
+}
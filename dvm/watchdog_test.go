@@ -0,0 +1,68 @@
+package dvm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+)
+
+func TestWatchdogTrend(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		samples []int64
+		rising  bool
+	}{
+		{"steady", []int64{20, 20, 21, 20, 20, 21, 20, 20}, false},
+		{"bursty load", []int64{20, 80, 20, 90, 20, 70, 20, 95}, false},
+		{"leak", []int64{20, 22, 25, 27, 30, 33, 35, 38}, true},
+		{"leak under load", []int64{20, 60, 24, 70, 30, 80, 34, 90}, true},
+		{"backlog", []int64{0, 3, 0, 4, 2, 5, 3, 6}, true},
+	} {
+		w := newWatchdog(WatchdogConfig{Window: 8}.withDefaults())
+		var rising bool
+		for _, v := range tc.samples {
+			_, _, rising = w.record("m", v)
+		}
+		if rising != tc.rising {
+			t.Errorf("%s: expected rising=%v", tc.name, tc.rising)
+		}
+	}
+
+	// Nothing is judged before the window fills
+	w := newWatchdog(WatchdogConfig{Window: 8}.withDefaults())
+	for _, v := range []int64{1, 10, 100} {
+		if _, _, rising := w.record("m", v); rising {
+			t.Error("expected no trend from a partial window")
+		}
+	}
+}
+
+func TestWatchdogSamplesDvm(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	// Sampled by hand below rather than on the DVM's schedule
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithWatchdog(WatchdogConfig{Interval: time.Hour}))
+	time.Sleep(200 * time.Millisecond)
+
+	samples := make(map[string]int64)
+	for _, m := range d.sampleResources() {
+		samples[m.name] = m.value
+	}
+	if samples["goroutines"] == 0 || samples["heap_bytes"] == 0 {
+		t.Errorf("expected goroutines and heap measured, got %v", samples)
+	}
+	if samples["relay_subscriptions"] != 1 {
+		t.Errorf("expected the request subscription counted, got %d", samples["relay_subscriptions"])
+	}
+
+	out := captureLogs(func() { d.checkResources() })
+	if !strings.Contains(out, "Watchdog: goroutines=") {
+		t.Errorf("expected the sample logged, got %q", out)
+	}
+	if metricWatchdog.Get("goroutines") == nil {
+		t.Error("expected the sample published as metrics")
+	}
+}
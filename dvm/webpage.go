@@ -0,0 +1,308 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// WebPage is the result of a KindWebPageRequest job: the readable article
+// extracted from a page, plus hashes so the archive can be verified later.
+type WebPage struct {
+	URL         string    `json:"url"`       // as requested
+	FinalURL    string    `json:"final_url"` // after redirects
+	Title       string    `json:"title"`
+	Author      string    `json:"author,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	Published   string    `json:"published,omitempty"` // as the page states it
+	Language    string    `json:"language,omitempty"`
+	Excerpt     string    `json:"excerpt,omitempty"`
+	Text        string    `json:"text"`
+	WordCount   int       `json:"word_count"`
+	ContentHash string    `json:"content_sha256"` // of Text
+	HTMLHash    string    `json:"html_sha256"`    // of the page as served
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// webPageHandler archives an arbitrary web page as readable text.
+type webPageHandler struct {
+	client *http.Client
+}
+
+func newWebPageHandler() *webPageHandler {
+	return &webPageHandler{client: newFetchClient()}
+}
+
+func (h *webPageHandler) Validate(req *nostr.Event) error {
+	_, err := parseWebURL(req.Content)
+	return err
+}
+
+func (h *webPageHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	pageURL := strings.TrimSpace(req.Content)
+	header := http.Header{"Accept": {"text/html,application/xhtml+xml"}}
+	res, err := fetchWith(ctx, h.client, pageURL, header, maxFetchBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := html.Parse(bytes.NewReader(res.Body))
+	if err != nil {
+		return nil, err
+	}
+	page := extractReadable(doc)
+	page.URL = pageURL
+	page.FinalURL = res.URL
+	page.HTMLHash = sha256Hex(res.Body)
+	page.FetchedAt = time.Now().UTC()
+	return json.Marshal(page)
+}
+
+var (
+	// Class and id fragments that mark likely article containers or clutter
+	positiveHint = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|text|blog|story`)
+	negativeHint = regexp.MustCompile(`(?i)comment|meta|footer|footnote|sidebar|sponsor|\bad-|promo|related|share|social|nav|menu|banner|cookie|subscribe`)
+
+	whitespace = regexp.MustCompile(`[ \t\r\n]+`)
+)
+
+// Elements that never hold article text.
+var clutterTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Svg: true, atom.Button: true, atom.Template: true,
+}
+
+// Block elements whose text becomes a paragraph of the extracted article.
+var textBlocks = map[atom.Atom]bool{
+	atom.P: true, atom.Pre: true, atom.Blockquote: true, atom.Li: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// extractReadable pulls metadata and the main article text out of a page,
+// after the fashion of Mozilla's Readability: paragraphs score their
+// ancestors, and the best-scoring container becomes the article.
+func extractReadable(doc *html.Node) *WebPage {
+	page := &WebPage{}
+	readMetadata(doc, page)
+	removeClutter(doc)
+
+	scores := make(map[*html.Node]float64)
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Td {
+			return
+		}
+		text := nodeText(n)
+		if len(text) < 25 || n.Parent == nil {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + float64(len(text))/100
+		if score > 4 {
+			score = 4 + (score-4)/4 // diminishing returns for very long paragraphs
+		}
+		scores[n.Parent] += score
+		if n.Parent.Parent != nil {
+			scores[n.Parent.Parent] += score / 2
+		}
+	})
+
+	var best *html.Node
+	var bestScore float64
+	for n, score := range scores {
+		score = (score + classWeight(n)) * (1 - linkDensity(n))
+		if best == nil || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	if best == nil {
+		best = findFirst(doc, atom.Body)
+	}
+	if best == nil {
+		best = doc
+	}
+
+	var paragraphs []string
+	collectBlocks(best, &paragraphs)
+	if len(paragraphs) == 0 {
+		if text := nodeText(best); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+	page.Text = strings.Join(paragraphs, "\n\n")
+	page.WordCount = len(strings.Fields(page.Text))
+	page.ContentHash = sha256Hex([]byte(page.Text))
+	if page.Excerpt == "" && len(paragraphs) > 0 {
+		page.Excerpt = truncateText(paragraphs[0], 300)
+	}
+	return page
+}
+
+// readMetadata fills in the title, author and so on from <title>, <html lang>
+// and the usual OpenGraph, article and Dublin Core meta tags.
+func readMetadata(doc *html.Node, page *WebPage) {
+	meta := make(map[string]string)
+	walk(doc, func(n *html.Node) {
+		switch n.DataAtom {
+		case atom.Html:
+			page.Language = attr(n, "lang")
+		case atom.Title:
+			if page.Title == "" {
+				page.Title = nodeText(n)
+			}
+		case atom.Meta:
+			key := strings.ToLower(attr(n, "property"))
+			if key == "" {
+				key = strings.ToLower(attr(n, "name"))
+			}
+			if content := strings.TrimSpace(attr(n, "content")); key != "" && content != "" {
+				if _, seen := meta[key]; !seen {
+					meta[key] = content
+				}
+			}
+		}
+	})
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := meta[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	if t := first("og:title", "twitter:title"); t != "" {
+		page.Title = t
+	}
+	page.Author = first("author", "article:author", "dc.creator", "twitter:creator")
+	page.SiteName = first("og:site_name", "application-name")
+	page.Published = first("article:published_time", "dc.date", "date")
+	page.Excerpt = first("og:description", "description", "twitter:description")
+}
+
+// removeClutter detaches elements that are never part of the article.
+func removeClutter(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.CommentNode || (c.Type == html.ElementNode && clutterTags[c.DataAtom]) {
+			n.RemoveChild(c)
+		} else {
+			removeClutter(c)
+		}
+		c = next
+	}
+}
+
+// collectBlocks appends the text of each block element under n, without
+// descending into blocks already collected.
+func collectBlocks(n *html.Node, out *[]string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if textBlocks[c.DataAtom] {
+			if text := nodeText(c); text != "" {
+				*out = append(*out, text)
+			}
+			continue
+		}
+		collectBlocks(c, out)
+	}
+}
+
+// classWeight scores an element by its class and id, Readability style.
+func classWeight(n *html.Node) float64 {
+	var weight float64
+	for _, name := range []string{attr(n, "class"), attr(n, "id")} {
+		if name == "" {
+			continue
+		}
+		if negativeHint.MatchString(name) {
+			weight -= 25
+		}
+		if positiveHint.MatchString(name) {
+			weight += 25
+		}
+	}
+	if n.DataAtom == atom.Article || n.DataAtom == atom.Main {
+		weight += 25
+	}
+	return weight
+}
+
+// linkDensity is the fraction of n's text that sits inside links.
+func linkDensity(n *html.Node) float64 {
+	total := len(nodeText(n))
+	if total == 0 {
+		return 0
+	}
+	linked := 0
+	walk(n, func(c *html.Node) {
+		if c.DataAtom == atom.A {
+			linked += len(nodeText(c))
+		}
+	})
+	return float64(linked) / float64(total)
+}
+
+// nodeText returns n's text content with whitespace collapsed.
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(c *html.Node) {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+			b.WriteString(" ")
+		}
+	})
+	return strings.TrimSpace(whitespace.ReplaceAllString(b.String(), " "))
+}
+
+// walk calls fn for n and every node beneath it, depth first.
+func walk(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+func findFirst(n *html.Node, a atom.Atom) *html.Node {
+	if n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findFirst(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// truncateText shortens s to at most n bytes on a word boundary.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := strings.LastIndex(s[:n], " ")
+	if cut <= 0 {
+		for cut = n; cut > 0 && !utf8.RuneStart(s[cut]); cut-- {
+		}
+	}
+	return s[:cut] + "…"
+}
@@ -0,0 +1,88 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const testArticle = `<!doctype html>
+<html lang="en"><head>
+	<title>Fallback title</title>
+	<meta property="og:title" content="Running bitcoin">
+	<meta name="author" content="Hal Finney">
+	<meta property="og:site_name" content="Cypherpunks">
+	<script>var tracking = "nope";</script>
+</head><body>
+	<nav><a href="/">Home</a> <a href="/about">About</a></nav>
+	<div class="sidebar"><p>Subscribe to our newsletter for more great content, every week!</p></div>
+	<div class="post-content">
+		<h1>Running bitcoin</h1>
+		<p>When Satoshi announced the first release of the software, I grabbed it right away.</p>
+		<p>I think I was the first person besides Satoshi to run bitcoin, mined block 70-something.</p>
+	</div>
+	<div id="comments"><p>Great post, thanks for sharing it with all of us here!</p></div>
+	<footer>Copyright</footer>
+</body></html>`
+
+func TestWebPageHandlerExtractsArticle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/running-bitcoin", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte(testArticle))
+	}))
+	defer srv.Close()
+
+	h := newWebPageHandler()
+	h.client = srv.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := h.Handle(ctx, &nostr.Event{Content: srv.URL + "/old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page WebPage
+	if err := json.Unmarshal(result, &page); err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Title != "Running bitcoin" || page.Author != "Hal Finney" || page.SiteName != "Cypherpunks" || page.Language != "en" {
+		t.Errorf("unexpected metadata: %+v", page)
+	}
+	if page.FinalURL != srv.URL+"/running-bitcoin" {
+		t.Errorf("expected the redirect to be followed, got %s", page.FinalURL)
+	}
+	if !strings.HasPrefix(page.Text, "Running bitcoin\n\nWhen Satoshi") || !strings.Contains(page.Text, "block 70-something") {
+		t.Errorf("article text missing: %q", page.Text)
+	}
+	for _, clutter := range []string{"newsletter", "Great post", "Home", "tracking", "Copyright"} {
+		if strings.Contains(page.Text, clutter) {
+			t.Errorf("article text includes clutter %q: %q", clutter, page.Text)
+		}
+	}
+	if page.ContentHash != sha256Hex([]byte(page.Text)) || page.HTMLHash != sha256Hex([]byte(testArticle)) {
+		t.Error("hashes don't match the content")
+	}
+}
+
+func TestFetchClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal secrets"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := fetch(ctx, newFetchClient(), srv.URL, ""); err == nil || !strings.Contains(err.Error(), "non-public") {
+		t.Errorf("expected a loopback fetch to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package dvm
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// WoTConfig limits the DVM to requesters in a web of trust: the Roots, the
+// accounts they follow, and so on out to Hops, read from the kind 3 follow
+// lists on the DVM's relays. Requests from anyone else get error feedback
+// with ReasonUntrusted.
+type WoTConfig struct {
+	Roots   []string      // hex pubkeys the web grows from
+	Hops    int           // follows, follows of follows and so on, default 2
+	Refresh time.Duration // between rebuilds, default 6h
+	MaxSize int           // pubkeys at most, default 100000; the web stops growing there
+}
+
+func (c WoTConfig) withDefaults() WoTConfig {
+	if c.Hops <= 0 {
+		c.Hops = 2
+	}
+	if c.Refresh <= 0 {
+		c.Refresh = 6 * time.Hour
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = 100000
+	}
+	return c
+}
+
+// KindFollowList is the NIP-02 follow list kind.
+const KindFollowList = 3
+
+// Follow lists are fetched this many authors at a time.
+const wotBatch = 250
+
+// wotRetry is how soon a failed build is tried again. A var so tests can
+// shorten it.
+var wotRetry = time.Minute
+
+// webOfTrust is the set of trusted pubkeys, rebuilt every cfg.Refresh.
+type webOfTrust struct {
+	cfg WoTConfig
+
+	mu      sync.RWMutex
+	trusted map[string]int // pubkey to hops from a root; nil until built
+}
+
+func newWebOfTrust(cfg WoTConfig) (*webOfTrust, error) {
+	if len(cfg.Roots) == 0 {
+		return nil, errors.New("web of trust needs at least one root pubkey")
+	}
+	for _, root := range cfg.Roots {
+		if _, err := hex.DecodeString(root); err != nil || len(root) != 64 {
+			return nil, fmt.Errorf("invalid web of trust root %q: must be 64 hex characters", root)
+		}
+	}
+	return &webOfTrust{cfg: cfg.withDefaults()}, nil
+}
+
+// trusts reports whether pubkey is in the web, and whether the web has
+// been built yet; until it has, only the roots are trusted.
+func (w *webOfTrust) trusts(pubkey string) (trusted, built bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.trusted == nil {
+		for _, root := range w.cfg.Roots {
+			if root == pubkey {
+				return true, false
+			}
+		}
+		return false, false
+	}
+	_, trusted = w.trusted[pubkey]
+	return trusted, true
+}
+
+// runWoT builds the web of trust and keeps it current.
+func (d *Dvm) runWoT(ctx context.Context) {
+	for {
+		wait := d.wot.cfg.Refresh
+		if err := d.buildWoT(ctx); err != nil {
+			log.Printf("Failed to build the web of trust, retrying in %v: %v", wotRetry, err)
+			wait = wotRetry
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// buildWoT walks the follow lists out from the roots, a hop at a time,
+// and replaces the web with what it finds.
+func (d *Dvm) buildWoT(ctx context.Context) error {
+	w := d.wot
+	trusted := make(map[string]int, len(w.cfg.Roots))
+	frontier := make([]string, 0, len(w.cfg.Roots))
+	for _, root := range w.cfg.Roots {
+		if _, ok := trusted[root]; !ok {
+			trusted[root] = 0
+			frontier = append(frontier, root)
+		}
+	}
+
+	start := time.Now()
+	found := 0
+grow:
+	for hop := 1; hop <= w.cfg.Hops && len(frontier) > 0; hop++ {
+		var next []string
+		for i := 0; i < len(frontier); i += wotBatch {
+			end := i + wotBatch
+			if end > len(frontier) {
+				end = len(frontier)
+			}
+			lists := d.followLists(ctx, frontier[i:end])
+			found += len(lists)
+			for _, list := range lists {
+				for _, tag := range list.Tags {
+					if len(tag) < 2 || tag[0] != "p" || len(tag[1]) != 64 {
+						continue
+					}
+					if _, ok := trusted[tag[1]]; ok {
+						continue
+					}
+					trusted[tag[1]] = hop
+					next = append(next, tag[1])
+					if len(trusted) >= w.cfg.MaxSize {
+						log.Printf("Web of trust reached its limit of %d pubkeys at hop %d", w.cfg.MaxSize, hop)
+						break grow
+					}
+				}
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		frontier = next
+	}
+	if found == 0 {
+		return errors.New("no follow lists found for the roots")
+	}
+
+	w.mu.Lock()
+	w.trusted = trusted
+	w.mu.Unlock()
+	metricWoTSize.Set(int64(len(trusted)))
+	log.Printf("Built the web of trust: %d pubkeys within %d hops from %d follow lists in %v",
+		len(trusted), w.cfg.Hops, found, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// followLists returns the latest follow list of each of authors found on
+// the DVM's relays.
+func (d *Dvm) followLists(ctx context.Context, authors []string) map[string]*nostr.Event {
+	filter := nostr.Filter{Kinds: []int{KindFollowList}, Authors: authors}
+	latest := make(map[string]*nostr.Event, len(authors))
+	for _, f := range d.queryRelays(ctx, []nostr.Filter{filter}, nil, nil) {
+		if prev, ok := latest[f.Event.PubKey]; !ok || f.Event.CreatedAt > prev.CreatedAt {
+			latest[f.Event.PubKey] = f.Event
+		}
+	}
+	return latest
+}
+
+// rejectUntrusted sends feedback turning away a request from outside the
+// web of trust, or asking the requester to retry if it's still being
+// built.
+func (d *Dvm) rejectUntrusted(id *identity, evt *nostr.Event, built bool) {
+	metricJobsUntrusted.Add(1)
+	if !built {
+		d.logs.printf("Deferring request %s: the web of trust is still being built", evt.ID[:8])
+		retryAfter := int(wotRetry.Seconds())
+		d.publishFeedback(id, evt, StatusError, ReasonBusy,
+			fmt.Sprintf("DVM is still loading its web of trust, retry after %d seconds", retryAfter),
+			nostr.Tag{"retry-after", strconv.Itoa(retryAfter)})
+		return
+	}
+	d.logs.printf("Rejecting request %s: %s is outside the web of trust", evt.ID[:8], d.scrub.logPubKey(evt.PubKey))
+	d.publishFeedback(id, evt, StatusError, ReasonUntrusted,
+		fmt.Sprintf("Sorry, this DVM only serves accounts within %d follows of its operators' web of trust", d.wot.cfg.Hops))
+}
@@ -0,0 +1,81 @@
+package dvm
+
+import (
+	"testing"
+	"time"
+
+	"bandita/internal/relaytest"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newFollowList returns sk's follow list of pubkeys.
+func newFollowList(sk string, pubkeys ...string) *nostr.Event {
+	evt := &nostr.Event{CreatedAt: nostr.Now(), Kind: KindFollowList}
+	for _, pk := range pubkeys {
+		evt.Tags = append(evt.Tags, nostr.Tag{"p", pk})
+	}
+	evt.Sign(sk)
+	return evt
+}
+
+func TestWebOfTrust(t *testing.T) {
+	relay := relaytest.NewServer()
+	defer relay.Close()
+
+	// root follows a, who follows b, who follows c
+	var sks, pks [4]string
+	for i := range sks {
+		sks[i] = testKey()
+		pks[i], _ = nostr.GetPublicKey(sks[i])
+	}
+	for i := 0; i < 3; i++ {
+		relay.Publish(newFollowList(sks[i], pks[i+1]))
+	}
+	// An older list of the root's doesn't count
+	stale := newFollowList(sks[0], pks[3])
+	stale.CreatedAt -= 3600
+	stale.Sign(sks[0])
+	relay.Publish(stale)
+
+	d := startTestDvm(t, relay, WithScraper(&fakeScraper{}), WithWebOfTrust(WoTConfig{Roots: []string{pks[0]}}))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, built := d.wot.trusts(pks[0]); built {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("web of trust not built")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Two hops out is trusted, three isn't
+	req := newTestRequestFrom(sks[2], "20")
+	relay.Publish(req)
+	if resp := awaitResponse(t, relay, d.GetPublicKey(), req.ID); resp.Kind == KindJobFeedback {
+		t.Errorf("expected a result for a trusted requester, got feedback %v", resp.Tags)
+	}
+	req = newTestRequestFrom(sks[3], "20")
+	relay.Publish(req)
+	fb := awaitResponse(t, relay, d.GetPublicKey(), req.ID)
+	if fb.Tags.GetFirst([]string{"status", StatusError, ReasonUntrusted}) == nil {
+		t.Errorf("expected untrusted feedback, got %v", fb.Tags)
+	}
+}
+
+func TestWebOfTrustUnbuilt(t *testing.T) {
+	root, _ := nostr.GetPublicKey(testKey())
+	w, err := newWebOfTrust(WoTConfig{Roots: []string{root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trusted, built := w.trusts(root); !trusted || built {
+		t.Error("expected the roots to be trusted before the web is built")
+	}
+	if trusted, _ := w.trusts(testKey()); trusted {
+		t.Error("expected others to wait for the web")
+	}
+	if _, err := newWebOfTrust(WoTConfig{Roots: []string{"npub1xyz"}}); err == nil {
+		t.Error("expected an invalid root to be rejected")
+	}
+}
@@ -0,0 +1,222 @@
+package dvm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var youtubeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// YouTubeVideo is the result of a KindYouTubeRequest job.
+type YouTubeVideo struct {
+	ID              string             `json:"id"`
+	URL             string             `json:"url"`
+	Title           string             `json:"title"`
+	Channel         string             `json:"channel"`
+	ChannelID       string             `json:"channel_id"`
+	DurationSeconds int                `json:"duration_seconds"`
+	Description     string             `json:"description"`
+	Views           int64              `json:"views"`
+	Transcript      *YouTubeTranscript `json:"transcript,omitempty"` // nil when the video has no captions
+}
+
+// YouTubeTranscript is a video's caption track.
+type YouTubeTranscript struct {
+	Language      string              `json:"language"`
+	AutoGenerated bool                `json:"auto_generated"`
+	Text          string              `json:"text"` // all segments joined, for summarizers
+	Segments      []TranscriptSegment `json:"segments"`
+}
+
+// TranscriptSegment is one timed caption line.
+type TranscriptSegment struct {
+	Start    float64 `json:"start"`    // seconds
+	Duration float64 `json:"duration"` // seconds
+	Text     string  `json:"text"`
+}
+
+// youtubeHandler returns metadata and the transcript for a YouTube video,
+// read from the watch page since the data API needs a key. Requests may carry
+// a ["param", "language", "<code>"] tag to pick a caption language.
+type youtubeHandler struct {
+	client  *http.Client
+	baseURL string // overridden in tests
+}
+
+func newYouTubeHandler() *youtubeHandler {
+	return &youtubeHandler{client: newFetchClient(), baseURL: "https://www.youtube.com"}
+}
+
+func (h *youtubeHandler) Validate(req *nostr.Event) error {
+	_, err := parseYouTubeURL(req.Content)
+	return err
+}
+
+// parseYouTubeURL extracts the video ID from the common YouTube URL shapes:
+// watch?v=, youtu.be/, /shorts/, /embed/ and /live/.
+func parseYouTubeURL(raw string) (string, error) {
+	u, err := parseWebURL(raw)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	switch host {
+	case "youtu.be":
+		id = strings.Trim(u.Path, "/")
+	case "youtube.com", "m.youtube.com", "music.youtube.com":
+		if u.Path == "/watch" {
+			id = u.Query().Get("v")
+		} else {
+			for _, prefix := range []string{"/shorts/", "/embed/", "/live/"} {
+				if strings.HasPrefix(u.Path, prefix) {
+					id = strings.Trim(strings.TrimPrefix(u.Path, prefix), "/")
+				}
+			}
+		}
+	}
+	if !youtubeIDPattern.MatchString(id) {
+		return "", fmt.Errorf("not a YouTube video URL: %q", raw)
+	}
+	return id, nil
+}
+
+// youtubePlayerResponse is the subset of ytInitialPlayerResponse we use.
+type youtubePlayerResponse struct {
+	PlayabilityStatus struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	} `json:"playabilityStatus"`
+	VideoDetails struct {
+		VideoID          string `json:"videoId"`
+		Title            string `json:"title"`
+		Author           string `json:"author"`
+		ChannelID        string `json:"channelId"`
+		LengthSeconds    string `json:"lengthSeconds"`
+		ShortDescription string `json:"shortDescription"`
+		ViewCount        string `json:"viewCount"`
+	} `json:"videoDetails"`
+	Captions struct {
+		Renderer struct {
+			CaptionTracks []youtubeCaptionTrack `json:"captionTracks"`
+		} `json:"playerCaptionsTracklistRenderer"`
+	} `json:"captions"`
+}
+
+type youtubeCaptionTrack struct {
+	BaseURL      string `json:"baseUrl"`
+	LanguageCode string `json:"languageCode"`
+	Kind         string `json:"kind"` // "asr" for auto-generated
+}
+
+func (h *youtubeHandler) Handle(ctx context.Context, req *nostr.Event) ([]byte, error) {
+	id, err := parseYouTubeURL(req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := fetch(ctx, h.client, h.baseURL+"/watch?v="+id+"&hl=en", "text/html")
+	if err != nil {
+		return nil, err
+	}
+	player, err := extractPlayerResponse(page)
+	if err != nil {
+		return nil, err
+	}
+	if status := player.PlayabilityStatus.Status; status != "" && status != "OK" {
+		return nil, fmt.Errorf("video %s is unavailable: %s", id, player.PlayabilityStatus.Reason)
+	}
+
+	details := player.VideoDetails
+	video := YouTubeVideo{
+		ID:          id,
+		URL:         "https://www.youtube.com/watch?v=" + id,
+		Title:       details.Title,
+		Channel:     details.Author,
+		ChannelID:   details.ChannelID,
+		Description: details.ShortDescription,
+	}
+	video.DurationSeconds, _ = strconv.Atoi(details.LengthSeconds)
+	video.Views, _ = strconv.ParseInt(details.ViewCount, 10, 64)
+
+	language, _ := paramValue(req, "language")
+	if track := pickCaptionTrack(player.Captions.Renderer.CaptionTracks, language); track != nil {
+		// A missing transcript shouldn't fail the whole job
+		if video.Transcript, err = h.fetchTranscript(ctx, track); err != nil {
+			video.Transcript = nil
+		}
+	}
+	return json.Marshal(video)
+}
+
+// extractPlayerResponse finds the ytInitialPlayerResponse object embedded in
+// the watch page.
+func extractPlayerResponse(page []byte) (*youtubePlayerResponse, error) {
+	marker := []byte("ytInitialPlayerResponse = ")
+	i := bytes.Index(page, marker)
+	if i < 0 {
+		return nil, fmt.Errorf("watch page has no player response")
+	}
+	var player youtubePlayerResponse
+	if err := json.NewDecoder(bytes.NewReader(page[i+len(marker):])).Decode(&player); err != nil {
+		return nil, fmt.Errorf("invalid player response: %w", err)
+	}
+	return &player, nil
+}
+
+// pickCaptionTrack prefers the requested language, then a human-written
+// track over an auto-generated one.
+func pickCaptionTrack(tracks []youtubeCaptionTrack, language string) *youtubeCaptionTrack {
+	var best *youtubeCaptionTrack
+	for i := range tracks {
+		t := &tracks[i]
+		switch {
+		case language != "" && t.LanguageCode == language:
+			return t
+		case best == nil, best.Kind == "asr" && t.Kind != "asr":
+			best = t
+		}
+	}
+	return best
+}
+
+// fetchTranscript downloads a caption track in YouTube's timedtext XML format.
+func (h *youtubeHandler) fetchTranscript(ctx context.Context, track *youtubeCaptionTrack) (*YouTubeTranscript, error) {
+	body, err := fetch(ctx, h.client, track.BaseURL, "text/xml")
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Texts []struct {
+			Start    float64 `xml:"start,attr"`
+			Duration float64 `xml:"dur,attr"`
+			Text     string  `xml:",chardata"`
+		} `xml:"text"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid transcript: %w", err)
+	}
+
+	transcript := &YouTubeTranscript{Language: track.LanguageCode, AutoGenerated: track.Kind == "asr"}
+	lines := make([]string, 0, len(doc.Texts))
+	for _, t := range doc.Texts {
+		// Caption text arrives HTML-escaped inside the XML
+		text := strings.TrimSpace(html.UnescapeString(t.Text))
+		transcript.Segments = append(transcript.Segments, TranscriptSegment{Start: t.Start, Duration: t.Duration, Text: text})
+		lines = append(lines, text)
+	}
+	transcript.Text = strings.Join(lines, " ")
+	return transcript, nil
+}
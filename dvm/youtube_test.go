@@ -0,0 +1,77 @@
+package dvm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseYouTubeURL(t *testing.T) {
+	for _, raw := range []string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42",
+		"https://youtu.be/dQw4w9WgXcQ",
+		"https://m.youtube.com/shorts/dQw4w9WgXcQ",
+		"https://www.youtube.com/embed/dQw4w9WgXcQ",
+	} {
+		if id, err := parseYouTubeURL(raw); err != nil || id != "dQw4w9WgXcQ" {
+			t.Errorf("%s: got %q, %v", raw, id, err)
+		}
+	}
+	for _, raw := range []string{"https://www.youtube.com/@channel", "https://vimeo.com/123", "dQw4w9WgXcQ"} {
+		if _, err := parseYouTubeURL(raw); err == nil {
+			t.Errorf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestYouTubeHandlerReturnsMetadataAndTranscript(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/watch":
+			fmt.Fprintf(w, `<html><script>var ytInitialPlayerResponse = {
+				"playabilityStatus":{"status":"OK"},
+				"videoDetails":{"videoId":"dQw4w9WgXcQ","title":"Never Gonna","author":"Rick",
+					"channelId":"UC1","lengthSeconds":"213","shortDescription":"Classic","viewCount":"1000"},
+				"captions":{"playerCaptionsTracklistRenderer":{"captionTracks":[
+					{"baseUrl":"%[1]s/asr","languageCode":"en","kind":"asr"},
+					{"baseUrl":"%[1]s/manual","languageCode":"en"}]}}};var meta = {};</script></html>`, srv.URL)
+		case "/manual":
+			w.Write([]byte(`<transcript><text start="0.5" dur="2">never gonna</text>` +
+				`<text start="2.5" dur="2">give you &amp;#39;up&amp;#39;</text></transcript>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	h := newYouTubeHandler()
+	h.baseURL = srv.URL
+	h.client = srv.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := h.Handle(ctx, &nostr.Event{Content: "https://youtu.be/dQw4w9WgXcQ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var video YouTubeVideo
+	if err := json.Unmarshal(result, &video); err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Never Gonna" || video.Channel != "Rick" || video.DurationSeconds != 213 || video.Views != 1000 {
+		t.Errorf("unexpected metadata: %+v", video)
+	}
+	if video.Transcript == nil || video.Transcript.AutoGenerated {
+		t.Fatalf("expected the manual transcript, got %+v", video.Transcript)
+	}
+	if want := "never gonna give you 'up'"; video.Transcript.Text != want {
+		t.Errorf("transcript text %q, want %q", video.Transcript.Text, want)
+	}
+}
@@ -2,19 +2,22 @@ module bandita
 
 go 1.20
 
-require github.com/nbd-wtf/go-nostr v0.19.5
+require (
+	github.com/btcsuite/btcd/btcec/v2 v2.2.0
+	github.com/imperatrona/twitter-scraper v0.0.17
+	github.com/joho/godotenv v1.5.1
+	github.com/nbd-wtf/go-nostr v0.19.5
+	golang.org/x/net v0.29.0
+)
 
 require (
 	github.com/AlexEidt/Vidio v1.5.1 // indirect
-	github.com/btcsuite/btcd/btcec/v2 v2.2.0 // indirect
 	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.1 // indirect
 	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/gobwas/ws v1.2.0 // indirect
-	github.com/imperatrona/twitter-scraper v0.0.17 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/puzpuzpuz/xsync v1.5.2 // indirect
@@ -22,6 +25,5 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	golang.org/x/exp v0.0.0-20221106115401-f9659909a136 // indirect
-	golang.org/x/net v0.29.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 )
@@ -0,0 +1,261 @@
+// Package relaytest provides a minimal in-process nostr relay so the DVM and
+// client can be exercised without a network connection.
+package relaytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/net/websocket"
+)
+
+// Server is an in-memory relay. It stores every valid event it receives and
+// fans them out to matching subscriptions.
+type Server struct {
+	http *httptest.Server
+
+	mu           sync.Mutex
+	events       []*nostr.Event
+	conns        map[*conn]struct{}
+	authRequired bool
+	strictTags   bool
+}
+
+type conn struct {
+	ws *websocket.Conn
+
+	mu        sync.Mutex // guards writes, subs and authed
+	subs      map[string]nostr.Filters
+	challenge string
+	authed    bool
+
+	strictTags bool // see StrictTagFilters
+}
+
+// NewServer starts a relay listening on a random localhost port.
+func NewServer() *Server {
+	s := &Server{conns: make(map[*conn]struct{})}
+	s.http = httptest.NewServer(&websocket.Server{
+		// nostr clients send no origin header
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   s.serve,
+	})
+	return s
+}
+
+// URL returns the ws:// address of the relay.
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.http.URL, "http")
+}
+
+// Close drops all connections and stops the server.
+func (s *Server) Close() {
+	s.DropConnections()
+	s.http.Close()
+}
+
+// DropConnections forcibly closes every open client connection, simulating a
+// relay restart or network blip.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.ws.Close()
+	}
+}
+
+// Connections returns the number of open client connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// RequireAuth makes the relay refuse subscriptions with CLOSED until the
+// connection answers the NIP-42 AUTH challenge sent along with the first
+// refusal.
+func (s *Server) RequireAuth() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authRequired = true
+}
+
+// StrictTagFilters makes the relay, like real ones, index only
+// single-letter tags: a filter on any other tag matches nothing. It applies
+// to connections made after it's called.
+func (s *Server) StrictTagFilters() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strictTags = true
+}
+
+// Notice sends a NOTICE to every open connection.
+func (s *Server) Notice(message string) {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.send(nostr.NoticeEnvelope(message))
+	}
+}
+
+// Events returns a copy of all events the relay has accepted so far.
+func (s *Server) Events() []*nostr.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*nostr.Event(nil), s.events...)
+}
+
+// Publish stores evt and delivers it to subscribers as if a client had sent it.
+func (s *Server) Publish(evt *nostr.Event) {
+	s.mu.Lock()
+	s.events = append(s.events, evt)
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.deliver(evt)
+	}
+}
+
+func (s *Server) serve(ws *websocket.Conn) {
+	c := &conn{ws: ws, subs: make(map[string]nostr.Filters)}
+	s.mu.Lock()
+	c.strictTags = s.strictTags
+	s.conns[c] = struct{}{}
+	authRequired := s.authRequired
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+
+		switch env := nostr.ParseMessage([]byte(msg)).(type) {
+		case *nostr.EventEnvelope:
+			evt := env.Event
+			if ok, _ := evt.CheckSignature(); !ok {
+				reason := "invalid: bad signature"
+				c.send(nostr.OKEnvelope{EventID: evt.ID, OK: false, Reason: &reason})
+				continue
+			}
+			c.send(nostr.OKEnvelope{EventID: evt.ID, OK: true})
+			s.Publish(&evt)
+		case *nostr.ReqEnvelope:
+			c.mu.Lock()
+			if authRequired && !c.authed {
+				challenge := c.challenge
+				if challenge == "" {
+					challenge = nostr.GeneratePrivateKey()[:16]
+					c.challenge = challenge
+				}
+				c.mu.Unlock()
+				c.send(nostr.AuthEnvelope{Challenge: &challenge})
+				c.send(rawMessage{"CLOSED", env.SubscriptionID, "auth-required: authenticate to subscribe"})
+				continue
+			}
+			c.subs[env.SubscriptionID] = env.Filters
+			c.mu.Unlock()
+			for _, evt := range s.Events() {
+				if c.match(env.Filters, evt) {
+					id := env.SubscriptionID
+					c.send(nostr.EventEnvelope{SubscriptionID: &id, Event: *evt})
+				}
+			}
+			eose := nostr.EOSEEnvelope(env.SubscriptionID)
+			c.send(eose)
+		case *nostr.AuthEnvelope:
+			evt := env.Event
+			ok, _ := evt.CheckSignature()
+			c.mu.Lock()
+			ok = ok && evt.Kind == 22242 && c.challenge != "" && evt.Tags.GetFirst([]string{"challenge", c.challenge}) != nil
+			c.authed = c.authed || ok
+			c.mu.Unlock()
+			var reason *string
+			if !ok {
+				invalid := "invalid: bad AUTH event"
+				reason = &invalid
+			}
+			c.send(nostr.OKEnvelope{EventID: evt.ID, OK: ok, Reason: reason})
+		case *nostr.CloseEnvelope:
+			c.mu.Lock()
+			delete(c.subs, string(*env))
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *conn) deliver(evt *nostr.Event) {
+	c.mu.Lock()
+	var ids []string
+	for id, filters := range c.subs {
+		if c.match(filters, evt) {
+			ids = append(ids, id)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, id := range ids {
+		id := id
+		c.send(nostr.EventEnvelope{SubscriptionID: &id, Event: *evt})
+	}
+}
+
+// match reports whether any of filters matches evt.
+func (c *conn) match(filters nostr.Filters, evt *nostr.Event) bool {
+	for _, f := range filters {
+		if c.strictTags && !singleLetterTags(f) {
+			continue
+		}
+		if f.Matches(evt) {
+			return true
+		}
+	}
+	return false
+}
+
+// singleLetterTags reports whether f filters only on single-letter tags.
+func singleLetterTags(f nostr.Filter) bool {
+	for tag := range f.Tags {
+		if len(tag) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *conn) send(env json.Marshaler) {
+	b, err := env.MarshalJSON()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	websocket.Message.Send(c.ws, string(b))
+}
+
+// rawMessage is a relay message go-nostr has no envelope for, such as CLOSED.
+type rawMessage []any
+
+func (m rawMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any(m))
+}
@@ -9,7 +9,7 @@ import (
 	"sync"
 	"testing"
 	"time"
-	
+
 	"bandita/dvm"
 )
 
@@ -30,24 +30,25 @@ func TestTweetDvm(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to generate test private key: %v", err)
 	}
-	
+
 	dvmInstance, err := dvm.NewDvm(relayURL, sk)
 	if err != nil {
 		t.Fatalf("failed to create dvm: %v", err)
 	}
 
 	// Run DVM in background
+	runCtx, stop := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := dvmInstance.Run(); err != nil {
+		if err := dvmInstance.Run(runCtx); err != nil {
 			log.Printf("DVM run error: %v", err)
 		}
 	}()
 
 	defer func() {
-		dvmInstance.Stop()
+		stop()
 		wg.Wait()
 	}()
 
@@ -82,4 +83,4 @@ func TestTweetDvm(t *testing.T) {
 	}
 
 	t.Logf("SUCCESS: Received tweet from @%s: %q", tweet.Username, tweet.Text)
-}
\ No newline at end of file
+}